@@ -137,7 +137,8 @@ func RegisterAgentUpgradeCommand() *cobra.Command {
 		Short: "agent upgrade operation commands",
 		Example: "deepflow-ctl agent-upgrade list\n" +
 			"deepflow-ctl agent-upgrade agent-name --image-name=deepflow-agent\n" +
-			"deepflow-ctl agent-upgrade cancel agent-name\n",
+			"deepflow-ctl agent-upgrade cancel agent-name\n" +
+			"deepflow-ctl agent-upgrade rollout list\n",
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(args) == 2 {
 				if args[0] == "cancel" {
@@ -168,6 +169,7 @@ func RegisterAgentUpgradeCommand() *cobra.Command {
 		},
 	}
 	agentUpgrade.Flags().StringVarP(&imageName, "image-name", "I", "", "")
+	agentUpgrade.AddCommand(registerAgentUpgradeRolloutCommand())
 
 	return agentUpgrade
 }
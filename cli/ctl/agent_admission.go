@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ctl
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/deepflowio/deepflow/cli/ctl/common"
+	"github.com/deepflowio/deepflow/cli/ctl/common/table"
+)
+
+func RegisterAgentTokenCommand() *cobra.Command {
+	agentToken := &cobra.Command{
+		Use:   "agent-token",
+		Short: "agent bootstrap token operation commands",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Printf("please run with 'list | create | delete'.\n")
+		},
+	}
+
+	var listOutput string
+	list := &cobra.Command{
+		Use:     "list",
+		Short:   "list agent bootstrap tokens",
+		Example: "deepflow-ctl agent-token list",
+		Run: func(cmd *cobra.Command, args []string) {
+			listAgentToken(cmd, listOutput)
+		},
+	}
+	list.Flags().StringVarP(&listOutput, "output", "o", "", "output format")
+
+	var (
+		teamID     int
+		agentGroup string
+		expire     time.Duration
+		usageLimit int
+	)
+	create := &cobra.Command{
+		Use:     "create <name>",
+		Short:   "create agent bootstrap token, the token is only printed once",
+		Example: "deepflow-ctl agent-token create k8s-nodes --agent-group g-1yhIguXABC --expire 24h --usage-limit 10",
+		Run: func(cmd *cobra.Command, args []string) {
+			createAgentToken(cmd, args, teamID, agentGroup, expire, usageLimit)
+		},
+	}
+	create.Flags().IntVar(&teamID, "team-id", 0, "team of the admitted agents, default team if not set")
+	create.Flags().StringVar(&agentGroup, "agent-group", "", "agent group id of the admitted agents, such as g-1yhIguXABC, default agent group if not set")
+	create.Flags().DurationVar(&expire, "expire", 0, "token expires after the duration, such as 24h, never expires if not set")
+	create.Flags().IntVar(&usageLimit, "usage-limit", 0, "max number of agents admitted by the token, unlimited if not set")
+
+	delete := &cobra.Command{
+		Use:     "delete <token-id>",
+		Short:   "delete agent bootstrap token",
+		Example: "deepflow-ctl agent-token delete abcdef",
+		Run: func(cmd *cobra.Command, args []string) {
+			deleteAgentToken(cmd, args)
+		},
+	}
+
+	agentToken.AddCommand(list)
+	agentToken.AddCommand(create)
+	agentToken.AddCommand(delete)
+	return agentToken
+}
+
+func RegisterAgentPendingCommand() *cobra.Command {
+	agentPending := &cobra.Command{
+		Use:   "agent-pending",
+		Short: "commands for agents waiting for admission",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Printf("please run with 'list | approve | reject'.\n")
+		},
+	}
+
+	var listOutput string
+	list := &cobra.Command{
+		Use:     "list",
+		Short:   "list pending agents",
+		Example: "deepflow-ctl agent-pending list",
+		Run: func(cmd *cobra.Command, args []string) {
+			listPendingAgent(cmd, listOutput)
+		},
+	}
+	list.Flags().StringVarP(&listOutput, "output", "o", "", "output format")
+
+	approve := &cobra.Command{
+		Use:     "approve <name>",
+		Short:   "approve pending agent",
+		Example: "deepflow-ctl agent-pending approve node-1-V1",
+		Run: func(cmd *cobra.Command, args []string) {
+			decidePendingAgent(cmd, args, "approve")
+		},
+	}
+
+	reject := &cobra.Command{
+		Use:     "reject <name>",
+		Short:   "reject pending agent",
+		Example: "deepflow-ctl agent-pending reject node-1-V1",
+		Run: func(cmd *cobra.Command, args []string) {
+			decidePendingAgent(cmd, args, "reject")
+		},
+	}
+
+	agentPending.AddCommand(list)
+	agentPending.AddCommand(approve)
+	agentPending.AddCommand(reject)
+	return agentPending
+}
+
+func listAgentToken(cmd *cobra.Command, output string) {
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/agent-bootstrap-tokens/", server.IP, server.Port)
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	if output == "yaml" {
+		dataJson, _ := response.Get("DATA").MarshalJSON()
+		dataYaml, _ := yaml.JSONToYAML(dataJson)
+		fmt.Printf(string(dataYaml))
+		return
+	}
+	t := table.New()
+	t.SetHeader([]string{"NAME", "TOKEN_ID", "TEAM_ID", "AGENT_GROUP", "EXPIRE_AT", "USAGE", "CREATED_AT"})
+	tableItems := [][]string{}
+	for i := range response.Get("DATA").MustArray() {
+		token := response.Get("DATA").GetIndex(i)
+		usage := strconv.Itoa(token.Get("USAGE_COUNT").MustInt()) + "/"
+		if limit := token.Get("USAGE_LIMIT").MustInt(); limit > 0 {
+			usage += strconv.Itoa(limit)
+		} else {
+			usage += "unlimited"
+		}
+		expireAt := token.Get("EXPIRE_AT").MustString()
+		if expireAt == "" {
+			expireAt = "never"
+		}
+		tableItems = append(tableItems, []string{
+			token.Get("NAME").MustString(),
+			token.Get("TOKEN_ID").MustString(),
+			strconv.Itoa(token.Get("TEAM_ID").MustInt()),
+			token.Get("AGENT_GROUP_ID").MustString(),
+			expireAt,
+			usage,
+			token.Get("CREATED_AT").MustString(),
+		})
+	}
+	t.AppendBulk(tableItems)
+	t.Render()
+}
+
+func createAgentToken(cmd *cobra.Command, args []string, teamID int, agentGroup string, expire time.Duration, usageLimit int) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "must specify name.\nExample: %s\n", cmd.Example)
+		return
+	}
+
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/agent-bootstrap-tokens/", server.IP, server.Port)
+	body := map[string]interface{}{
+		"NAME":           args[0],
+		"TEAM_ID":        teamID,
+		"AGENT_GROUP_ID": agentGroup,
+		"TTL":            int(expire.Seconds()),
+		"USAGE_LIMIT":    usageLimit,
+	}
+	response, err := common.CURLPerform("POST", url, body, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Println(response.Get("DATA").Get("TOKEN").MustString())
+}
+
+func deleteAgentToken(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "must specify token id.\nExample: %s\n", cmd.Example)
+		return
+	}
+
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/agent-bootstrap-tokens/?token_id=%s", server.IP, server.Port, args[0])
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	if len(response.Get("DATA").MustArray()) == 0 {
+		fmt.Fprintf(os.Stderr, "agent bootstrap token (%s) not found\n", args[0])
+		return
+	}
+
+	lcuuid := response.Get("DATA").GetIndex(0).Get("LCUUID").MustString()
+	url = fmt.Sprintf("http://%s:%d/v1/agent-bootstrap-tokens/%s/", server.IP, server.Port, lcuuid)
+	_, err = common.CURLPerform("DELETE", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func listPendingAgent(cmd *cobra.Command, output string) {
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/pending-agents/", server.IP, server.Port)
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	if output == "yaml" {
+		dataJson, _ := response.Get("DATA").MarshalJSON()
+		dataYaml, _ := yaml.JSONToYAML(dataJson)
+		fmt.Printf(string(dataYaml))
+		return
+	}
+	t := table.New()
+	t.SetHeader([]string{"NAME", "CTRL_IP", "CTRL_MAC", "TEAM_ID", "CREATED_AT"})
+	tableItems := [][]string{}
+	for i := range response.Get("DATA").MustArray() {
+		agent := response.Get("DATA").GetIndex(i)
+		tableItems = append(tableItems, []string{
+			agent.Get("NAME").MustString(),
+			agent.Get("CTRL_IP").MustString(),
+			agent.Get("CTRL_MAC").MustString(),
+			strconv.Itoa(agent.Get("TEAM_ID").MustInt()),
+			agent.Get("CREATED_AT").MustString(),
+		})
+	}
+	t.AppendBulk(tableItems)
+	t.Render()
+}
+
+func decidePendingAgent(cmd *cobra.Command, args []string, decision string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "must specify name.\nExample: %s\n", cmd.Example)
+		return
+	}
+
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/pending-agents/", server.IP, server.Port)
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	lcuuid := ""
+	for i := range response.Get("DATA").MustArray() {
+		agent := response.Get("DATA").GetIndex(i)
+		if agent.Get("NAME").MustString() == args[0] {
+			lcuuid = agent.Get("LCUUID").MustString()
+			break
+		}
+	}
+	if lcuuid == "" {
+		fmt.Fprintf(os.Stderr, "pending agent (%s) not found\n", args[0])
+		return
+	}
+
+	url = fmt.Sprintf("http://%s:%d/v1/pending-agents/%s/%s/", server.IP, server.Port, lcuuid, decision)
+	_, err = common.CURLPerform("POST", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
@@ -18,9 +18,13 @@ package ctl
 
 import (
 	"fmt"
+	neturl "net/url"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
 
 	"github.com/deepflowio/deepflow/cli/ctl/common"
 	"github.com/deepflowio/deepflow/cli/ctl/common/table"
@@ -31,7 +35,7 @@ func RegisterAgentGroupConfigCommand() *cobra.Command {
 		Use:   "agent-group-config",
 		Short: "agent-group config operation commands",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("please run with 'example | list | create | update | delete'.\n")
+			fmt.Printf("please run with 'example | list | create | update | delete | revisions | diff | rollback'.\n")
 		},
 	}
 
@@ -46,29 +50,31 @@ func RegisterAgentGroupConfigCommand() *cobra.Command {
 	}
 	list.Flags().StringVarP(&listOutput, "output", "o", "", "output format")
 
-	var createFilename string
+	var createFilename, createComment string
 	create := &cobra.Command{
 		Use:     "create <agent-group ID> -f <filename>",
 		Short:   "create config",
 		Example: "deepflow-ctl agent-group-config create g-xxxxxx -f deepflow-config.yaml",
 		Run: func(cmd *cobra.Command, args []string) {
-			createAgentGroupConfig(cmd, args, createFilename)
+			createAgentGroupConfig(cmd, args, createFilename, createComment)
 		},
 	}
 	create.Flags().StringVarP(&createFilename, "filename", "f", "", "file to use create agent-group config")
 	create.MarkFlagRequired("filename")
+	create.Flags().StringVar(&createComment, "comment", "", "comment of the config revision")
 
-	var updateFilename string
+	var updateFilename, updateComment string
 	update := &cobra.Command{
 		Use:     "update <agent-group ID> -f <filename>",
 		Short:   "update agent-group config",
 		Example: "deepflow-ctl agent-group-config update g-xxxxxx -f deepflow-config.yaml",
 		Run: func(cmd *cobra.Command, args []string) {
-			updateAgentGroupConfig(cmd, args, updateFilename)
+			updateAgentGroupConfig(cmd, args, updateFilename, updateComment)
 		},
 	}
 	update.Flags().StringVarP(&updateFilename, "filename", "f", "", "file to use update agent-group config")
 	update.MarkFlagRequired("filename")
+	update.Flags().StringVar(&updateComment, "comment", "", "comment of the config revision")
 
 	delete := &cobra.Command{
 		Use:     "delete <agent-group ID>",
@@ -79,6 +85,38 @@ func RegisterAgentGroupConfigCommand() *cobra.Command {
 		},
 	}
 
+	revisions := &cobra.Command{
+		Use:     "revisions <agent-group ID>",
+		Short:   "list config revisions of agent-group",
+		Example: "deepflow-ctl agent-group-config revisions g-xxxxxx",
+		Run: func(cmd *cobra.Command, args []string) {
+			listAgentGroupConfigRevisions(cmd, args)
+		},
+	}
+
+	var diffBase string
+	diff := &cobra.Command{
+		Use:   "diff <agent-group ID> <revision>",
+		Short: "show config changes of a revision",
+		Example: "deepflow-ctl agent-group-config diff g-xxxxxx 3\n" +
+			"deepflow-ctl agent-group-config diff g-xxxxxx 3 --base 2",
+		Run: func(cmd *cobra.Command, args []string) {
+			diffAgentGroupConfigRevision(cmd, args, diffBase)
+		},
+	}
+	diff.Flags().StringVar(&diffBase, "base", "template", "compare with 'template' defaults or another revision")
+
+	var rollbackComment string
+	rollback := &cobra.Command{
+		Use:     "rollback <agent-group ID> <revision>",
+		Short:   "rollback agent-group config to a revision",
+		Example: "deepflow-ctl agent-group-config rollback g-xxxxxx 2",
+		Run: func(cmd *cobra.Command, args []string) {
+			rollbackAgentGroupConfig(cmd, args, rollbackComment)
+		},
+	}
+	rollback.Flags().StringVar(&rollbackComment, "comment", "", "comment of the rollback revision")
+
 	example := &cobra.Command{
 		Use:   "example",
 		Short: "example agent-group config",
@@ -91,6 +129,9 @@ func RegisterAgentGroupConfigCommand() *cobra.Command {
 	agentGroupConfig.AddCommand(create)
 	agentGroupConfig.AddCommand(update)
 	agentGroupConfig.AddCommand(delete)
+	agentGroupConfig.AddCommand(revisions)
+	agentGroupConfig.AddCommand(diff)
+	agentGroupConfig.AddCommand(rollback)
 	return agentGroupConfig
 }
 
@@ -216,7 +257,7 @@ func getAgentGroupLcuuid(cmd *cobra.Command, server *common.Server, shortUUID st
 	return response.Get("DATA").GetIndex(0).Get("LCUUID").MustString(), nil
 }
 
-func createAgentGroupConfig(cmd *cobra.Command, args []string, createFilename string) {
+func createAgentGroupConfig(cmd *cobra.Command, args []string, createFilename, comment string) {
 	if len(args) == 0 {
 		fmt.Fprintln(os.Stderr, "must specify agent-group ID.\nExample: %s", cmd.Example)
 		return
@@ -228,7 +269,8 @@ func createAgentGroupConfig(cmd *cobra.Command, args []string, createFilename st
 		fmt.Fprintln(os.Stderr, err)
 		return
 	}
-	url := fmt.Sprintf("http://%s:%d/v1/agent-group-configuration/%s/yaml", server.IP, server.Port, agentGroupLcuuid)
+	url := fmt.Sprintf("http://%s:%d/v1/agent-group-configuration/%s/yaml?comment=%s",
+		server.IP, server.Port, agentGroupLcuuid, neturl.QueryEscape(comment))
 
 	yamlFile, err := os.ReadFile(createFilename)
 	if err != nil {
@@ -242,7 +284,7 @@ func createAgentGroupConfig(cmd *cobra.Command, args []string, createFilename st
 	}
 }
 
-func updateAgentGroupConfig(cmd *cobra.Command, args []string, updateFilename string) {
+func updateAgentGroupConfig(cmd *cobra.Command, args []string, updateFilename, comment string) {
 	if len(args) == 0 {
 		fmt.Fprintln(os.Stderr, "must specify agent-group ID.\nExample: %s", cmd.Example)
 		return
@@ -254,7 +296,8 @@ func updateAgentGroupConfig(cmd *cobra.Command, args []string, updateFilename st
 		fmt.Fprintln(os.Stderr, err)
 		return
 	}
-	url := fmt.Sprintf("http://%s:%d/v1/agent-group-configuration/%s/yaml", server.IP, server.Port, agentGroupLcuuid)
+	url := fmt.Sprintf("http://%s:%d/v1/agent-group-configuration/%s/yaml?comment=%s",
+		server.IP, server.Port, agentGroupLcuuid, neturl.QueryEscape(comment))
 
 	yamlFile, err := os.ReadFile(updateFilename)
 	if err != nil {
@@ -291,3 +334,113 @@ func deleteAgentGroupConfig(cmd *cobra.Command, args []string) {
 		return
 	}
 }
+
+func listAgentGroupConfigRevisions(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "must specify agent-group ID.\nExample: %s\n", cmd.Example)
+		return
+	}
+	server := common.GetServerInfo(cmd)
+
+	agentGroupLcuuid, err := getAgentGroupLcuuid(cmd, server, args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	url := fmt.Sprintf("http://%s:%d/v1/agent-group-configuration/%s/revisions", server.IP, server.Port, agentGroupLcuuid)
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	t := table.New()
+	t.SetHeader([]string{"REVISION", "ACTION", "USER_ID", "CREATED_AT", "COMMENT"})
+	tableItems := [][]string{}
+	for i := range response.Get("DATA").MustArray() {
+		revision := response.Get("DATA").GetIndex(i)
+		tableItems = append(tableItems, []string{
+			strconv.Itoa(revision.Get("REVISION").MustInt()),
+			revision.Get("ACTION").MustString(),
+			strconv.Itoa(revision.Get("USER_ID").MustInt()),
+			revision.Get("CREATED_AT").MustString(),
+			revision.Get("COMMENT").MustString(),
+		})
+	}
+	t.AppendBulk(tableItems)
+	t.Render()
+}
+
+func diffAgentGroupConfigRevision(cmd *cobra.Command, args []string, base string) {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "must specify agent-group ID and revision.\nExample: %s\n", cmd.Example)
+		return
+	}
+	server := common.GetServerInfo(cmd)
+
+	agentGroupLcuuid, err := getAgentGroupLcuuid(cmd, server, args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	url := fmt.Sprintf("http://%s:%d/v1/agent-group-configuration/%s/revisions/%s/diff?base=%s",
+		server.IP, server.Port, agentGroupLcuuid, args[1], neturl.QueryEscape(base))
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	for i := range response.Get("DATA").MustArray() {
+		diff := response.Get("DATA").GetIndex(i)
+		key := diff.Get("KEY").MustString()
+		from, _ := diff.Get("FROM").MarshalJSON()
+		to, _ := diff.Get("TO").MarshalJSON()
+		switch diff.Get("KIND").MustString() {
+		case "added":
+			fmt.Printf("+ %s: %s\n", key, jsonToYAMLValue(to))
+		case "removed":
+			fmt.Printf("- %s: %s\n", key, jsonToYAMLValue(from))
+		default:
+			fmt.Printf("~ %s: %s -> %s\n", key, jsonToYAMLValue(from), jsonToYAMLValue(to))
+		}
+	}
+}
+
+func jsonToYAMLValue(data []byte) string {
+	y, err := yaml.JSONToYAML(data)
+	if err != nil {
+		return string(data)
+	}
+	return strings.TrimSpace(string(y))
+}
+
+func rollbackAgentGroupConfig(cmd *cobra.Command, args []string, comment string) {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "must specify agent-group ID and revision.\nExample: %s\n", cmd.Example)
+		return
+	}
+	revision, err := strconv.Atoi(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "revision (%s) must be a number\n", args[1])
+		return
+	}
+	server := common.GetServerInfo(cmd)
+
+	agentGroupLcuuid, err := getAgentGroupLcuuid(cmd, server, args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	url := fmt.Sprintf("http://%s:%d/v1/agent-group-configuration/%s/rollback", server.IP, server.Port, agentGroupLcuuid)
+	body := map[string]interface{}{"REVISION": revision, "COMMENT": comment}
+	response, err := common.CURLPerform("POST", url, body, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Printf("rollback to revision %d, new revision %d\n", revision, response.Get("DATA").Get("REVISION").MustInt())
+}
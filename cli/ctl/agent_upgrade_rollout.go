@@ -0,0 +1,286 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ctl
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/deepflowio/deepflow/cli/ctl/common"
+	"github.com/deepflowio/deepflow/cli/ctl/common/table"
+)
+
+func registerAgentUpgradeRolloutCommand() *cobra.Command {
+	rollout := &cobra.Command{
+		Use:   "rollout",
+		Short: "upgrade the agents of an agent group in waves with health gates",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Printf("please run with 'list | show | create | pause | resume | cancel | rollback | delete'.\n")
+		},
+	}
+
+	var listOutput string
+	list := &cobra.Command{
+		Use:     "list",
+		Short:   "list agent upgrade rollouts",
+		Example: "deepflow-ctl agent-upgrade rollout list",
+		Run: func(cmd *cobra.Command, args []string) {
+			listAgentUpgradeRollout(cmd, listOutput)
+		},
+	}
+	list.Flags().StringVarP(&listOutput, "output", "o", "", "output format")
+
+	var showOutput string
+	show := &cobra.Command{
+		Use:     "show <id>",
+		Short:   "show the progress of each agent of the rollout",
+		Example: "deepflow-ctl agent-upgrade rollout show 1",
+		Run: func(cmd *cobra.Command, args []string) {
+			showAgentUpgradeRollout(cmd, args, showOutput)
+		},
+	}
+	show.Flags().StringVarP(&showOutput, "output", "o", "", "output format")
+
+	var (
+		agentGroup       string
+		rolloutImageName string
+		canaryAgents     []string
+		canaryPercent    int
+		batchSize        int
+		reconnectTimeout int
+		observeTime      int
+		failurePolicy    string
+	)
+	create := &cobra.Command{
+		Use:   "create <name>",
+		Short: "create agent upgrade rollout",
+		Example: "deepflow-ctl agent-upgrade rollout create upgrade-v6.6 --agent-group g-1yhIguXABC --image-name deepflow-agent " +
+			"--canary-percent 10 --batch-size 20 --failure-policy rollback",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				fmt.Fprintf(os.Stderr, "must specify name.\nExample: %s\n", cmd.Example)
+				return
+			}
+			body := map[string]interface{}{
+				"NAME":              args[0],
+				"AGENT_GROUP_ID":    agentGroup,
+				"IMAGE_NAME":        rolloutImageName,
+				"CANARY_AGENTS":     canaryAgents,
+				"CANARY_PERCENT":    canaryPercent,
+				"BATCH_SIZE":        batchSize,
+				"RECONNECT_TIMEOUT": reconnectTimeout,
+				"OBSERVE_TIME":      observeTime,
+				"FAILURE_POLICY":    failurePolicy,
+			}
+			createAgentUpgradeRollout(cmd, body)
+		},
+	}
+	create.Flags().StringVar(&agentGroup, "agent-group", "", "agent group id to upgrade, such as g-1yhIguXABC")
+	create.Flags().StringVarP(&rolloutImageName, "image-name", "I", "", "agent image to upgrade to, see `deepflow-ctl repo agent list`")
+	create.Flags().StringSliceVar(&canaryAgents, "canary-agents", nil, "names of the agents upgraded in the first wave")
+	create.Flags().IntVar(&canaryPercent, "canary-percent", 0, "percentage of the agents upgraded in the first wave if --canary-agents is not set")
+	create.Flags().IntVar(&batchSize, "batch-size", 0, "agents upgraded in each wave after the canary, all the agents left if not set")
+	create.Flags().IntVar(&reconnectTimeout, "reconnect-timeout", 0, "unit: s, time for an agent to reconnect on the new revision, default of controller if not set")
+	create.Flags().IntVar(&observeTime, "observe-time", 0, "unit: s, time for an agent to stay healthy, default of controller if not set")
+	create.Flags().StringVar(&failurePolicy, "failure-policy", "pause", "pause or rollback when an agent fails the health gates")
+	create.MarkFlagRequired("agent-group")
+	create.MarkFlagRequired("image-name")
+
+	rollout.AddCommand(list)
+	rollout.AddCommand(show)
+	rollout.AddCommand(create)
+	for _, action := range []struct {
+		name  string
+		short string
+	}{
+		{"pause", "pause the rollout before the next wave"},
+		{"resume", "resume the paused rollout, the failed agents of the current wave are upgraded again"},
+		{"cancel", "cancel the rollout, the agents already upgraded are kept"},
+		{"rollback", "upgrade the agents upgraded by the rollout back to their previous images"},
+		{"delete", "delete the stopped rollout"},
+	} {
+		name := action.name
+		rollout.AddCommand(&cobra.Command{
+			Use:     name + " <id>",
+			Short:   action.short,
+			Example: fmt.Sprintf("deepflow-ctl agent-upgrade rollout %s 1", name),
+			Run: func(cmd *cobra.Command, args []string) {
+				updateAgentUpgradeRollout(cmd, args, name)
+			},
+		})
+	}
+	return rollout
+}
+
+func listAgentUpgradeRollout(cmd *cobra.Command, output string) {
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/agent-upgrade-rollouts/", server.IP, server.Port)
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	if output == "yaml" {
+		dataJson, _ := response.Get("DATA").MarshalJSON()
+		dataYaml, _ := yaml.JSONToYAML(dataJson)
+		fmt.Printf(string(dataYaml))
+		return
+	}
+	t := table.New()
+	t.SetHeader([]string{"ID", "NAME", "AGENT_GROUP", "IMAGE_NAME", "STATE", "WAVE", "AGENTS", "ERROR_MESSAGE", "CREATED_AT"})
+	tableItems := [][]string{}
+	for i := range response.Get("DATA").MustArray() {
+		rollout := response.Get("DATA").GetIndex(i)
+		tableItems = append(tableItems, []string{
+			strconv.Itoa(rollout.Get("ID").MustInt()),
+			rollout.Get("NAME").MustString(),
+			rollout.Get("AGENT_GROUP_NAME").MustString(),
+			rollout.Get("IMAGE_NAME").MustString(),
+			rollout.Get("STATE").MustString(),
+			rolloutWave(rollout),
+			fmt.Sprintf("%d/%d healthy, %d failed", rollout.Get("HEALTHY_AGENTS").MustInt(),
+				rollout.Get("TOTAL_AGENTS").MustInt(), rollout.Get("FAILED_AGENTS").MustInt()),
+			rollout.Get("ERROR_MESSAGE").MustString(),
+			rollout.Get("CREATED_AT").MustString(),
+		})
+	}
+	t.AppendBulk(tableItems)
+	t.Render()
+}
+
+// rolloutWave shows the current wave counting from 1, the canary wave is the first one
+func rolloutWave(rollout *simplejson.Json) string {
+	total := rollout.Get("TOTAL_WAVES").MustInt()
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d/%d", rollout.Get("CURRENT_WAVE").MustInt()+1, total)
+}
+
+func showAgentUpgradeRollout(cmd *cobra.Command, args []string, output string) {
+	lcuuid := getAgentUpgradeRolloutLcuuid(cmd, args)
+	if lcuuid == "" {
+		return
+	}
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/agent-upgrade-rollouts/%s/", server.IP, server.Port, lcuuid)
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	rollout := response.Get("DATA")
+	if output == "yaml" {
+		dataJson, _ := rollout.MarshalJSON()
+		dataYaml, _ := yaml.JSONToYAML(dataJson)
+		fmt.Printf(string(dataYaml))
+		return
+	}
+	fmt.Printf("%s: %s to %s (%s), wave %s, %s\n", rollout.Get("NAME").MustString(), rollout.Get("AGENT_GROUP_NAME").MustString(),
+		rollout.Get("IMAGE_NAME").MustString(), rollout.Get("EXPECTED_REVISION").MustString(), rolloutWave(rollout), rollout.Get("STATE").MustString())
+	if message := rollout.Get("ERROR_MESSAGE").MustString(); message != "" {
+		fmt.Printf("error: %s\n", message)
+	}
+	t := table.New()
+	t.SetHeader([]string{"WAVE", "NAME", "STATE", "PREVIOUS_REVISION", "UPGRADED_AT", "RECONNECTED_AT", "MESSAGE"})
+	tableItems := [][]string{}
+	for i := range rollout.Get("AGENTS").MustArray() {
+		agent := rollout.Get("AGENTS").GetIndex(i)
+		wave := "-"
+		if agent.Get("STATE").MustString() != "skipped" {
+			wave = strconv.Itoa(agent.Get("WAVE").MustInt() + 1)
+		}
+		tableItems = append(tableItems, []string{
+			wave,
+			agent.Get("NAME").MustString(),
+			agent.Get("STATE").MustString(),
+			agent.Get("PREVIOUS_REVISION").MustString(),
+			agent.Get("UPGRADED_AT").MustString(),
+			agent.Get("RECONNECTED_AT").MustString(),
+			agent.Get("MESSAGE").MustString(),
+		})
+	}
+	t.AppendBulk(tableItems)
+	t.Render()
+}
+
+func createAgentUpgradeRollout(cmd *cobra.Command, body map[string]interface{}) {
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/agent-upgrade-rollouts/", server.IP, server.Port)
+	response, err := common.CURLPerform("POST", url, body, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Printf("agent upgrade rollout (%d) created, run `deepflow-ctl agent-upgrade rollout show %d` to see the progress\n",
+		response.Get("DATA").Get("ID").MustInt(), response.Get("DATA").Get("ID").MustInt())
+}
+
+func updateAgentUpgradeRollout(cmd *cobra.Command, args []string, action string) {
+	lcuuid := getAgentUpgradeRolloutLcuuid(cmd, args)
+	if lcuuid == "" {
+		return
+	}
+	server := common.GetServerInfo(cmd)
+	method := "POST"
+	url := fmt.Sprintf("http://%s:%d/v1/agent-upgrade-rollouts/%s/%s/", server.IP, server.Port, lcuuid, action)
+	if action == "delete" {
+		method = "DELETE"
+		url = fmt.Sprintf("http://%s:%d/v1/agent-upgrade-rollouts/%s/", server.IP, server.Port, lcuuid)
+	}
+	response, err := common.CURLPerform(method, url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	if action != "delete" {
+		fmt.Printf("agent upgrade rollout (%s) is %s\n", args[0], response.Get("DATA").Get("STATE").MustString())
+	}
+}
+
+func getAgentUpgradeRolloutLcuuid(cmd *cobra.Command, args []string) string {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "must specify rollout id.\nExample: %s\n", cmd.Example)
+		return ""
+	}
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/agent-upgrade-rollouts/", server.IP, server.Port)
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ""
+	}
+	for i := range response.Get("DATA").MustArray() {
+		rollout := response.Get("DATA").GetIndex(i)
+		if strconv.Itoa(rollout.Get("ID").MustInt()) == args[0] || rollout.Get("LCUUID").MustString() == args[0] {
+			return rollout.Get("LCUUID").MustString()
+		}
+	}
+	fmt.Fprintf(os.Stderr, "agent upgrade rollout (%s) not found\n", args[0])
+	return ""
+}
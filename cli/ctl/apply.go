@@ -0,0 +1,581 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ctl
+
+import (
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path"
+	"strings"
+
+	simplejson "github.com/bitly/go-simplejson"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/deepflowio/deepflow/cli/ctl/common"
+	"github.com/deepflowio/deepflow/cli/ctl/common/manifest"
+)
+
+const (
+	// lcuuid of the default domain
+	defaultDomainLcuuid = "ffffffff-ffff-ffff-ffff-ffffffffffff"
+	// id of the default agent group
+	defaultAgentGroupID = 1
+)
+
+var applyExample = `deepflow-ctl apply -f ./deepflow-manifests --dry-run
+deepflow-ctl apply -f ./deepflow-manifests --prune --comment "release 2024-06"
+
+manifest example:
+  kind: AgentGroup
+  name: prod
+  spec:
+    group_id: g-1yhIguXABC  # only used on creation
+  ---
+  kind: AgentGroupConfig
+  name: prod  # agent group name
+  spec:
+    global:
+      limits:
+        max_millicpus: 2000
+  ---
+  kind: Domain
+  name: k8s-prod
+  spec:  # same as 'deepflow-ctl domain create'
+    type: kubernetes
+    config:
+      region_uuid: ffffffff-ffff-ffff-ffff-ffffffffffff
+  ---
+  kind: Plugin
+  name: hello
+  spec:
+    type: wasm  # wasm | so | lua
+    image: ./hello.wasm  # relative to the manifest file
+    user: agent
+    version: 1.1.0  # optional, generated as v<N> if not specified
+    signature: ./hello.wasm.sig  # optional, relative to the manifest file
+    promote: true
+  ---
+  kind: AgentRepo
+  name: deepflow-agent  # file name of image, or k8s_image
+  spec:
+    arch: x86
+    image: ./deepflow-agent`
+
+type applyArgs struct {
+	filenames []string
+	prune     bool
+	dryRun    bool
+	comment   string
+}
+
+// applyProvider reads and writes one kind of resource through the controller api.
+type applyProvider struct {
+	option manifest.KindOption
+	list   func(cmd *cobra.Command) ([]*manifest.Object, error)
+	// normalize validates the manifest and converts its spec into the form of Object.Spec
+	normalize func(m *manifest.Manifest) (interface{}, error)
+	create    func(cmd *cobra.Command, m *manifest.Manifest, spec interface{}, args applyArgs) error
+	update    func(cmd *cobra.Command, m *manifest.Manifest, spec interface{}, cur *manifest.Object, args applyArgs) error
+	delete    func(cmd *cobra.Command, cur *manifest.Object) error
+}
+
+var applyProviders = map[string]*applyProvider{
+	manifest.KindAgentGroup: {
+		list:      listAgentGroupObjects,
+		normalize: normalizeAgentGroup,
+		create:    createAgentGroupObject,
+		delete:    deleteAgentGroupObject,
+	},
+	manifest.KindAgentGroupConfig: {
+		option:    manifest.KindOption{Comparable: true, Exact: true},
+		list:      listAgentGroupConfigObjects,
+		normalize: normalizeAgentGroupConfig,
+		create: func(cmd *cobra.Command, m *manifest.Manifest, spec interface{}, args applyArgs) error {
+			return putAgentGroupConfigObject(cmd, "POST", m, spec, "", args)
+		},
+		update: func(cmd *cobra.Command, m *manifest.Manifest, spec interface{}, cur *manifest.Object, args applyArgs) error {
+			return putAgentGroupConfigObject(cmd, "PUT", m, spec, cur.ID, args)
+		},
+		delete: deleteAgentGroupConfigObject,
+	},
+	manifest.KindDomain: {
+		option:    manifest.KindOption{Comparable: true},
+		list:      listDomainObjects,
+		normalize: normalizeDomain,
+		create:    createDomainObject,
+		update:    updateDomainObject,
+		delete:    deleteDomainObject,
+	},
+	manifest.KindPlugin: {
+		list:      listPluginObjects,
+		normalize: normalizePlugin,
+		create:    createPluginObject,
+		delete:    deletePluginObject,
+	},
+	manifest.KindAgentRepo: {
+		list:      listAgentRepoObjects,
+		normalize: normalizeAgentRepo,
+		create:    createAgentRepoObject,
+		delete:    deleteAgentRepoObject,
+	},
+}
+
+func RegisterApplyCommand() *cobra.Command {
+	args := applyArgs{}
+	apply := &cobra.Command{
+		Use:   "apply",
+		Short: "apply agent groups, agent group configs, domains, plugins and agent repo entries from yaml manifests",
+		Long: "apply computes a plan by comparing the manifests with the controller and applies it.\n" +
+			"Plugins, agent repo entries and agent groups are only created or deleted, their content is not compared.\n" +
+			"Secrets of domains are masked by the controller, so changing only a secret is not detected.\n" +
+			"With --prune, resources of the kinds present in the manifests but not defined by them are deleted.",
+		Example: applyExample,
+		Run: func(cmd *cobra.Command, cmdArgs []string) {
+			if err := applyManifests(cmd, args); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+	apply.Flags().StringArrayVarP(&args.filenames, "filename", "f", nil, "manifest file or directory, can be repeated")
+	apply.Flags().BoolVar(&args.prune, "prune", false, "delete resources not defined in the manifests")
+	apply.Flags().BoolVar(&args.dryRun, "dry-run", false, "only print the plan")
+	apply.Flags().StringVar(&args.comment, "comment", "applied by deepflow-ctl", "comment of agent group config revisions")
+	apply.MarkFlagRequired("filename")
+	return apply
+}
+
+func applyManifests(cmd *cobra.Command, args applyArgs) error {
+	var desired []*manifest.Manifest
+	for _, filename := range args.filenames {
+		ms, err := manifest.Load(filename)
+		if err != nil {
+			return err
+		}
+		desired = append(desired, ms...)
+	}
+
+	normalized := make(map[*manifest.Manifest]interface{}, len(desired))
+	kinds := make(map[string]bool)
+	for _, m := range desired {
+		spec, err := applyProviders[m.Kind].normalize(m)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %s", m.File, m, err.Error())
+		}
+		normalized[m] = spec
+		kinds[m.Kind] = true
+	}
+	var current []*manifest.Object
+	options := make(map[string]manifest.KindOption)
+	for _, kind := range manifest.Kinds {
+		if !kinds[kind] {
+			continue
+		}
+		objects, err := applyProviders[kind].list(cmd)
+		if err != nil {
+			return fmt.Errorf("list %s failed: %s", kind, err.Error())
+		}
+		current = append(current, objects...)
+		options[kind] = applyProviders[kind].option
+	}
+
+	plan := manifest.NewPlan(desired, normalized, current, options, args.prune)
+	printApplyPlan(plan)
+	if args.dryRun || !plan.HasChanges() {
+		return nil
+	}
+
+	for _, change := range plan.Changes {
+		provider := applyProviders[change.Kind]
+		var err error
+		switch change.Action {
+		case manifest.ActionCreate:
+			err = provider.create(cmd, change.Desired, normalized[change.Desired], args)
+		case manifest.ActionUpdate:
+			err = provider.update(cmd, change.Desired, normalized[change.Desired], change.Current, args)
+		case manifest.ActionDelete:
+			err = provider.delete(cmd, change.Current)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s %s failed: %s", change.Action, change.Kind, change.Name, err.Error())
+		}
+		fmt.Printf("%s %s %s done\n", change.Action, change.Kind, change.Name)
+	}
+	return nil
+}
+
+func printApplyPlan(plan *manifest.Plan) {
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case manifest.ActionCreate:
+			fmt.Printf("+ %s %s\n", change.Kind, change.Name)
+		case manifest.ActionUpdate:
+			fmt.Printf("~ %s %s\n", change.Kind, change.Name)
+			for _, diff := range change.Diffs {
+				fmt.Printf("    %s\n", diff)
+			}
+		case manifest.ActionDelete:
+			fmt.Printf("- %s %s\n", change.Kind, change.Name)
+		}
+	}
+	fmt.Printf("Plan: %d to create, %d to update, %d to delete, %d unchanged.\n",
+		plan.Count(manifest.ActionCreate), plan.Count(manifest.ActionUpdate),
+		plan.Count(manifest.ActionDelete), plan.Count(manifest.ActionUnchanged))
+}
+
+func applyRequest(cmd *cobra.Command, method, urlPath string, body map[string]interface{}, strBody string) (*simplejson.Json, error) {
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d%s", server.IP, server.Port, urlPath)
+	return common.CURLPerform(method, url, body, strBody,
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+}
+
+func specString(spec map[string]interface{}, key string) (string, error) {
+	v, ok := spec[key]
+	if !ok || v == nil {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("spec.%s should be a string", key)
+	}
+	return s, nil
+}
+
+func listAgentGroupObjects(cmd *cobra.Command) ([]*manifest.Object, error) {
+	response, err := applyRequest(cmd, "GET", "/v1/vtap-groups/", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var objects []*manifest.Object
+	for i := range response.Get("DATA").MustArray() {
+		group := response.Get("DATA").GetIndex(i)
+		objects = append(objects, &manifest.Object{
+			Kind:      manifest.KindAgentGroup,
+			Name:      group.Get("NAME").MustString(),
+			ID:        group.Get("LCUUID").MustString(),
+			Protected: group.Get("ID").MustInt() == defaultAgentGroupID,
+		})
+	}
+	return objects, nil
+}
+
+func normalizeAgentGroup(m *manifest.Manifest) (interface{}, error) {
+	spec, err := m.SpecMap()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := specString(spec, "group_id"); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func createAgentGroupObject(cmd *cobra.Command, m *manifest.Manifest, spec interface{}, args applyArgs) error {
+	groupID, _ := specString(spec.(map[string]interface{}), "group_id")
+	_, err := applyRequest(cmd, "POST", "/v1/vtap-groups/", map[string]interface{}{"name": m.Name, "group_id": groupID}, "")
+	return err
+}
+
+func deleteAgentGroupObject(cmd *cobra.Command, cur *manifest.Object) error {
+	_, err := applyRequest(cmd, "DELETE", fmt.Sprintf("/v1/vtap-groups/%s/", cur.ID), nil, "")
+	return err
+}
+
+func listAgentGroupConfigObjects(cmd *cobra.Command) ([]*manifest.Object, error) {
+	groups, err := listAgentGroupObjects(cmd)
+	if err != nil {
+		return nil, err
+	}
+	lcuuidToName := make(map[string]string, len(groups))
+	for _, g := range groups {
+		lcuuidToName[g.ID] = g.Name
+	}
+	response, err := applyRequest(cmd, "GET", "/v1/agent-group-configuration/yaml", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var objects []*manifest.Object
+	for i := range response.Get("DATA").MustArray() {
+		config := response.Get("DATA").GetIndex(i)
+		lcuuid := config.Get("AGENT_GROUP_LCUUID").MustString()
+		name, ok := lcuuidToName[lcuuid]
+		if !ok {
+			continue
+		}
+		var spec interface{}
+		if err := yaml.Unmarshal([]byte(config.Get("YAML").MustString()), &spec); err != nil {
+			return nil, fmt.Errorf("agent group (%s) config is not valid yaml: %s", name, err.Error())
+		}
+		objects = append(objects, &manifest.Object{Kind: manifest.KindAgentGroupConfig, Name: name, ID: lcuuid, Spec: spec})
+	}
+	return objects, nil
+}
+
+// normalizeAgentGroupConfig accepts the config as a yaml mapping or as a yaml string.
+func normalizeAgentGroupConfig(m *manifest.Manifest) (interface{}, error) {
+	s, ok := m.Spec.(string)
+	if !ok {
+		return m.SpecMap()
+	}
+	var spec interface{}
+	if err := yaml.Unmarshal([]byte(s), &spec); err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		spec = map[string]interface{}{}
+	}
+	return spec, nil
+}
+
+func putAgentGroupConfigObject(cmd *cobra.Command, method string, m *manifest.Manifest, spec interface{}, agentGroupLcuuid string, args applyArgs) error {
+	if agentGroupLcuuid == "" {
+		response, err := applyRequest(cmd, "GET", "/v1/vtap-groups/?name="+neturl.QueryEscape(m.Name), nil, "")
+		if err != nil {
+			return err
+		}
+		if len(response.Get("DATA").MustArray()) == 0 {
+			return fmt.Errorf("agent group (%s) not found", m.Name)
+		}
+		agentGroupLcuuid = response.Get("DATA").GetIndex(0).Get("LCUUID").MustString()
+	}
+	content, ok := m.Spec.(string)
+	if !ok {
+		data, err := yaml.Marshal(spec)
+		if err != nil {
+			return err
+		}
+		content = string(data)
+	}
+	_, err := applyRequest(cmd, method,
+		fmt.Sprintf("/v1/agent-group-configuration/%s/yaml?comment=%s", agentGroupLcuuid, neturl.QueryEscape(args.comment)), nil, content)
+	return err
+}
+
+func deleteAgentGroupConfigObject(cmd *cobra.Command, cur *manifest.Object) error {
+	_, err := applyRequest(cmd, "DELETE", fmt.Sprintf("/v1/agent-group-configuration/%s", cur.ID), nil, "")
+	return err
+}
+
+func listDomainObjects(cmd *cobra.Command) ([]*manifest.Object, error) {
+	response, err := applyRequest(cmd, "GET", "/v2/domains/", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var objects []*manifest.Object
+	for i := range response.Get("DATA").MustArray() {
+		domain := response.Get("DATA").GetIndex(i)
+		lcuuid := domain.Get("LCUUID").MustString()
+		objects = append(objects, &manifest.Object{
+			Kind:      manifest.KindDomain,
+			Name:      domain.Get("NAME").MustString(),
+			ID:        lcuuid,
+			Spec:      domain.MustMap(),
+			Protected: lcuuid == defaultDomainLcuuid,
+		})
+	}
+	return objects, nil
+}
+
+// normalizeDomain converts spec into the body of domain create/update api,
+// keys are upper cased and type names are converted into numbers.
+func normalizeDomain(m *manifest.Manifest) (interface{}, error) {
+	spec, err := m.SpecMap()
+	if err != nil {
+		return nil, err
+	}
+	body := make(map[string]interface{}, len(spec))
+	for k, v := range spec {
+		body[strings.ToUpper(k)] = v
+	}
+	delete(body, "NAME")
+	domainTypeStr, err := specString(body, "TYPE")
+	if err != nil {
+		return nil, err
+	}
+	if domainTypeStr == "" {
+		return nil, fmt.Errorf("spec.type should be specified")
+	}
+	domainType := common.GetDomainTypeByName(domainTypeStr)
+	if domainType == common.DOMAIN_TYPE_UNKNOWN {
+		return nil, fmt.Errorf("domain type (%s) not supported, use 'deepflow-ctl domain example' to see supported types", domainTypeStr)
+	}
+	body["TYPE"] = int(domainType)
+	return body, nil
+}
+
+func domainBody(m *manifest.Manifest, spec interface{}) map[string]interface{} {
+	body := map[string]interface{}{"NAME": m.Name}
+	for k, v := range spec.(map[string]interface{}) {
+		body[k] = v
+	}
+	return body
+}
+
+func createDomainObject(cmd *cobra.Command, m *manifest.Manifest, spec interface{}, args applyArgs) error {
+	_, err := applyRequest(cmd, "POST", "/v1/domains/", domainBody(m, spec), "")
+	return err
+}
+
+func updateDomainObject(cmd *cobra.Command, m *manifest.Manifest, spec interface{}, cur *manifest.Object, args applyArgs) error {
+	_, err := applyRequest(cmd, "PATCH", fmt.Sprintf("/v1/domains/%s/", cur.ID), domainBody(m, spec), "")
+	return err
+}
+
+func deleteDomainObject(cmd *cobra.Command, cur *manifest.Object) error {
+	_, err := applyRequest(cmd, "DELETE", fmt.Sprintf("/v1/domains/%s/", cur.ID), nil, "")
+	return err
+}
+
+func listPluginObjects(cmd *cobra.Command) ([]*manifest.Object, error) {
+	response, err := applyRequest(cmd, "GET", "/v1/plugin/", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var objects []*manifest.Object
+	for i := range response.Get("DATA").MustArray() {
+		objects = append(objects, &manifest.Object{Kind: manifest.KindPlugin, Name: response.Get("DATA").GetIndex(i).Get("NAME").MustString()})
+	}
+	return objects, nil
+}
+
+func normalizePlugin(m *manifest.Manifest) (interface{}, error) {
+	spec, err := m.SpecMap()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"type", "image", "user", "version", "signature"} {
+		if _, err := specString(spec, key); err != nil {
+			return nil, err
+		}
+	}
+	if spec["type"] == nil || spec["image"] == nil {
+		return nil, fmt.Errorf("spec.type and spec.image should be specified")
+	}
+	if _, err := os.Stat(m.Path(spec["image"].(string))); err != nil {
+		return nil, err
+	}
+	if spec["signature"] != nil {
+		if _, err := os.Stat(m.Path(spec["signature"].(string))); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := spec["promote"]; ok {
+		if _, ok := v.(bool); !ok {
+			return nil, fmt.Errorf("spec.promote should be a bool, got %T", v)
+		}
+	}
+	return spec, nil
+}
+
+func createPluginObject(cmd *cobra.Command, m *manifest.Manifest, spec interface{}, args applyArgs) error {
+	s := spec.(map[string]interface{})
+	pluginType, _ := specString(s, "type")
+	image, _ := specString(s, "image")
+	user, _ := specString(s, "user")
+	if user == "" {
+		user = "agent"
+	}
+	version, _ := specString(s, "version")
+	signature, _ := specString(s, "signature")
+	if signature != "" {
+		signature = m.Path(signature)
+	}
+	promote := true
+	if v, ok := s["promote"].(bool); ok {
+		promote = v
+	}
+	return createPlugin(cmd, pluginType, m.Path(image), m.Name, user, version, signature, promote)
+}
+
+func deletePluginObject(cmd *cobra.Command, cur *manifest.Object) error {
+	_, err := applyRequest(cmd, "DELETE", fmt.Sprintf("/v1/plugin/%s/", cur.Name), nil, "")
+	return err
+}
+
+func listAgentRepoObjects(cmd *cobra.Command) ([]*manifest.Object, error) {
+	response, err := applyRequest(cmd, "GET", "/v1/vtap-repo/", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var objects []*manifest.Object
+	for i := range response.Get("DATA").MustArray() {
+		objects = append(objects, &manifest.Object{Kind: manifest.KindAgentRepo, Name: response.Get("DATA").GetIndex(i).Get("NAME").MustString()})
+	}
+	return objects, nil
+}
+
+// normalizeAgentRepo checks that name matches the name the controller will store,
+// which is the k8s image or the file name of the image.
+func normalizeAgentRepo(m *manifest.Manifest) (interface{}, error) {
+	spec, err := m.SpecMap()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"arch", "image", "version_image", "k8s_image"} {
+		if _, err := specString(spec, key); err != nil {
+			return nil, err
+		}
+	}
+	image, _ := specString(spec, "image")
+	versionImage, _ := specString(spec, "version_image")
+	k8sImage, _ := specString(spec, "k8s_image")
+	switch {
+	case k8sImage != "" && image != "":
+		return nil, fmt.Errorf("only one of spec.image and spec.k8s_image should be specified")
+	case k8sImage != "":
+		if k8sImage != m.Name {
+			return nil, fmt.Errorf("name should be the same as spec.k8s_image (%s)", k8sImage)
+		}
+	case image != "":
+		if path.Base(image) != m.Name {
+			return nil, fmt.Errorf("name should be the file name of spec.image (%s)", path.Base(image))
+		}
+	default:
+		return nil, fmt.Errorf("spec.image or spec.k8s_image should be specified")
+	}
+	if (k8sImage != "" || strings.HasSuffix(image, ".exe")) && versionImage == "" {
+		return nil, fmt.Errorf("spec.version_image should be specified to retrieve the version of %s", m.Name)
+	}
+	for _, p := range []string{image, versionImage} {
+		if p == "" {
+			continue
+		}
+		if _, err := os.Stat(m.Path(p)); err != nil {
+			return nil, err
+		}
+	}
+	return spec, nil
+}
+
+func createAgentRepoObject(cmd *cobra.Command, m *manifest.Manifest, spec interface{}, args applyArgs) error {
+	s := spec.(map[string]interface{})
+	arch, _ := specString(s, "arch")
+	image, _ := specString(s, "image")
+	versionImage, _ := specString(s, "version_image")
+	k8sImage, _ := specString(s, "k8s_image")
+	return createRepoAgent(cmd, arch, m.Path(image), m.Path(versionImage), k8sImage)
+}
+
+func deleteAgentRepoObject(cmd *cobra.Command, cur *manifest.Object) error {
+	_, err := applyRequest(cmd, "DELETE", "/v1/vtap-repo/", map[string]interface{}{"image_name": cur.Name}, "")
+	return err
+}
@@ -56,8 +56,13 @@ func Execute(version string) {
 	root.AddCommand(RegisterAgentUpgradeCommand())
 	root.AddCommand(RegisterAgentGroupCommand())
 	root.AddCommand(RegisterAgentGroupConfigCommand())
+	root.AddCommand(RegisterAgentTokenCommand())
+	root.AddCommand(RegisterAgentPendingCommand())
 	root.AddCommand(RegisterDomainCommand())
 	root.AddCommand(RegisterSubDomainCommand())
+	root.AddCommand(RegisterInventoryCommand())
+	root.AddCommand(RegisterOwnershipCommand())
+	root.AddCommand(RegisterApplyCommand())
 	root.AddCommand(RegisterGenesisCommand())
 	root.AddCommand(RegisterCloudCommand())
 	root.AddCommand(RegisterRecorderCommand())
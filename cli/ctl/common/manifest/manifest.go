@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package manifest loads declarative deepflow-ctl manifests and plans the
+// changes needed to bring the controller to the described state.
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	KindAgentGroup       = "AgentGroup"
+	KindAgentGroupConfig = "AgentGroupConfig"
+	KindDomain           = "Domain"
+	KindPlugin           = "Plugin"
+	KindAgentRepo        = "AgentRepo"
+)
+
+// Kinds in the order they are created, deletions happen in the reverse order.
+var Kinds = []string{KindAgentGroup, KindAgentGroupConfig, KindDomain, KindPlugin, KindAgentRepo}
+
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+type Manifest struct {
+	Kind string      `json:"kind"`
+	Name string      `json:"name"`
+	Spec interface{} `json:"spec"`
+
+	File string `json:"-"` // file the manifest is loaded from
+}
+
+func (m *Manifest) String() string {
+	return fmt.Sprintf("%s %s", m.Kind, m.Name)
+}
+
+// Path resolves a path in spec relative to the manifest file.
+func (m *Manifest) Path(p string) string {
+	if p == "" || filepath.IsAbs(p) || m.File == "" {
+		return p
+	}
+	return filepath.Join(filepath.Dir(m.File), p)
+}
+
+// SpecMap returns spec as a map, nil spec is treated as an empty map.
+func (m *Manifest) SpecMap() (map[string]interface{}, error) {
+	switch s := m.Spec.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		return s, nil
+	default:
+		return nil, fmt.Errorf("%s: spec of %s should be a mapping", m.File, m)
+	}
+}
+
+// Load reads manifests from a file or recursively from the *.yaml and *.yml files of a directory.
+func Load(path string) ([]*Manifest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	if info.IsDir() {
+		err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ext := filepath.Ext(p); !fi.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	} else {
+		files = []string{path}
+	}
+
+	var manifests []*Manifest
+	seen := make(map[string]string)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		ms, err := Parse(content, file)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range ms {
+			if prev, ok := seen[m.String()]; ok {
+				return nil, fmt.Errorf("%s: %s is already defined in %s", file, m, prev)
+			}
+			seen[m.String()] = file
+		}
+		manifests = append(manifests, ms...)
+	}
+	return manifests, nil
+}
+
+// Parse parses the documents of one yaml file.
+func Parse(content []byte, file string) ([]*Manifest, error) {
+	var manifests []*Manifest
+	for _, doc := range documentSeparator.Split(string(content), -1) {
+		if len(bytes.TrimSpace([]byte(doc))) == 0 {
+			continue
+		}
+		m := &Manifest{File: file}
+		if err := yaml.UnmarshalStrict([]byte(doc), m); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err.Error())
+		}
+		if m.Kind == "" && m.Name == "" && m.Spec == nil {
+			continue
+		}
+		if !isKnownKind(m.Kind) {
+			return nil, fmt.Errorf("%s: unknown kind (%s), supported: %s", file, m.Kind, strings.Join(Kinds, ", "))
+		}
+		if m.Name == "" {
+			return nil, fmt.Errorf("%s: name of %s should not be empty", file, m.Kind)
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
+
+func isKnownKind(kind string) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	content := `
+kind: AgentGroup
+name: prod
+---
+kind: Domain
+name: k8s-prod
+spec:
+  type: kubernetes
+  config:
+    pod_net_ipv4_cidr_max_mask: 16
+---
+`
+	ms, err := Parse([]byte(content), "a.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("expected 2 manifests, got %d", len(ms))
+	}
+	if ms[1].String() != "Domain k8s-prod" || ms[1].File != "a.yaml" {
+		t.Errorf("unexpected manifest %v", ms[1])
+	}
+	spec, err := ms[1].SpecMap()
+	if err != nil || spec["type"] != "kubernetes" {
+		t.Errorf("unexpected spec %v, %v", spec, err)
+	}
+
+	for _, invalid := range []string{"kind: Unknown\nname: a", "kind: Domain", "kind: Domain\nname: a\nextra: b"} {
+		if _, err := Parse([]byte(invalid), "b.yaml"); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "groups"), 0755)
+	os.WriteFile(filepath.Join(dir, "groups", "a.yaml"), []byte("kind: AgentGroup\nname: a\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.yml"), []byte("kind: Plugin\nname: p\nspec:\n  image: ./p.wasm\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a manifest"), 0644)
+
+	ms, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("expected 2 manifests, got %d", len(ms))
+	}
+	if got := ms[0].Path("./p.wasm"); got != filepath.Join(dir, "p.wasm") {
+		t.Errorf("unexpected path %s", got)
+	}
+
+	os.WriteFile(filepath.Join(dir, "c.yaml"), []byte("kind: AgentGroup\nname: a\n"), 0644)
+	if _, err := Load(dir); err == nil {
+		t.Error("expected duplicate manifest error")
+	}
+}
@@ -0,0 +1,236 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionDelete    = "delete"
+	ActionUnchanged = "unchanged"
+
+	// value returned by the controller in place of secrets
+	MaskedValue = "******"
+)
+
+// Object is the current state of a resource in the controller.
+type Object struct {
+	Kind      string
+	Name      string
+	ID        string      // identifier used by update and delete, e.g. lcuuid
+	Spec      interface{} // comparable with the normalized desired spec
+	Protected bool        // never pruned, e.g. the default agent group
+}
+
+// KindOption describes how the specs of a kind are compared.
+type KindOption struct {
+	// Comparable is false for kinds whose content can not be read back, they
+	// are only created or deleted.
+	Comparable bool
+	// Exact also reports keys only present in the current spec, otherwise they
+	// are treated as server side defaults.
+	Exact bool
+}
+
+type Diff struct {
+	Key  string
+	From interface{} // nil means added
+	To   interface{} // nil means removed
+}
+
+func (d Diff) String() string {
+	switch {
+	case d.From == nil:
+		return fmt.Sprintf("+ %s: %s", d.Key, formatValue(d.To))
+	case d.To == nil:
+		return fmt.Sprintf("- %s: %s", d.Key, formatValue(d.From))
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", d.Key, formatValue(d.From), formatValue(d.To))
+	}
+}
+
+type Change struct {
+	Action  string
+	Kind    string
+	Name    string
+	Desired *Manifest
+	Current *Object
+	Diffs   []Diff
+}
+
+type Plan struct {
+	Changes []*Change
+}
+
+// Count returns the number of changes of an action.
+func (p *Plan) Count(action string) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *Plan) HasChanges() bool {
+	return p.Count(ActionUnchanged) != len(p.Changes)
+}
+
+// NewPlan compares desired manifests with the current objects. desired specs
+// should already be normalized to the form of current specs. With prune, objects
+// of the kinds present in desired but not defined by any manifest are deleted.
+func NewPlan(desired []*Manifest, normalized map[*Manifest]interface{}, current []*Object, options map[string]KindOption, prune bool) *Plan {
+	plan := &Plan{}
+	currentByKey := make(map[string]*Object, len(current))
+	for _, o := range current {
+		currentByKey[o.Kind+"/"+o.Name] = o
+	}
+	desiredKinds := make(map[string]bool)
+	desiredKeys := make(map[string]bool)
+	for _, kind := range Kinds {
+		for _, m := range desired {
+			if m.Kind != kind {
+				continue
+			}
+			desiredKinds[kind] = true
+			desiredKeys[kind+"/"+m.Name] = true
+			cur, ok := currentByKey[kind+"/"+m.Name]
+			if !ok {
+				plan.Changes = append(plan.Changes, &Change{Action: ActionCreate, Kind: kind, Name: m.Name, Desired: m})
+				continue
+			}
+			change := &Change{Action: ActionUnchanged, Kind: kind, Name: m.Name, Desired: m, Current: cur}
+			if options[kind].Comparable {
+				change.Diffs = DiffSpec(cur.Spec, normalized[m], options[kind].Exact)
+				if len(change.Diffs) != 0 {
+					change.Action = ActionUpdate
+				}
+			}
+			plan.Changes = append(plan.Changes, change)
+		}
+	}
+	if prune {
+		for i := len(Kinds) - 1; i >= 0; i-- {
+			kind := Kinds[i]
+			if !desiredKinds[kind] {
+				continue
+			}
+			var deletes []*Change
+			for _, o := range current {
+				if o.Kind == kind && !o.Protected && !desiredKeys[kind+"/"+o.Name] {
+					deletes = append(deletes, &Change{Action: ActionDelete, Kind: kind, Name: o.Name, Current: o})
+				}
+			}
+			sort.Slice(deletes, func(i, j int) bool { return deletes[i].Name < deletes[j].Name })
+			plan.Changes = append(plan.Changes, deletes...)
+		}
+	}
+	return plan
+}
+
+// DiffSpec compares specs key by key after flattening nested maps into dotted keys.
+// Masked secrets in current are treated as equal to any desired value.
+func DiffSpec(current, desired interface{}, exact bool) []Diff {
+	from := make(map[string]interface{})
+	to := make(map[string]interface{})
+	flatten("", normalize(current), from)
+	flatten("", normalize(desired), to)
+
+	var diffs []Diff
+	for key, t := range to {
+		f, ok := from[key]
+		if !ok {
+			diffs = append(diffs, Diff{Key: key, To: t})
+			continue
+		}
+		if f == MaskedValue {
+			continue
+		}
+		if !reflect.DeepEqual(f, t) {
+			diffs = append(diffs, Diff{Key: key, From: f, To: t})
+		}
+	}
+	if exact {
+		for key, f := range from {
+			if _, ok := to[key]; !ok {
+				diffs = append(diffs, Diff{Key: key, From: f})
+			}
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Key < diffs[j].Key })
+	return diffs
+}
+
+// normalize converts values into their json form, so that numbers of
+// different types and yaml/json decoded maps compare equal.
+func normalize(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var result interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return v
+	}
+	return result
+}
+
+func flatten(prefix string, v interface{}, result map[string]interface{}) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		if prefix != "" {
+			result[prefix] = v
+		}
+		return
+	}
+	if len(m) == 0 && prefix != "" {
+		result[prefix] = m
+		return
+	}
+	for k, sub := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		flatten(key, sub, result)
+	}
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manifest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSpec(t *testing.T) {
+	current := map[string]interface{}{
+		"TYPE":    11,
+		"ENABLED": 1,
+		"CONFIG":  map[string]interface{}{"secret_key": MaskedValue, "region": "a", "max_mask": 16},
+	}
+	desired := map[string]interface{}{
+		"TYPE":   11.0,
+		"CONFIG": map[string]interface{}{"secret_key": "s3cret", "region": "b", "max_mask": 16, "new": true},
+	}
+	diffs := DiffSpec(current, desired, false)
+	want := []Diff{
+		{Key: "CONFIG.new", To: true},
+		{Key: "CONFIG.region", From: "a", To: "b"},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("got %v, want %v", diffs, want)
+	}
+
+	diffs = DiffSpec(current, desired, true)
+	if len(diffs) != 3 || diffs[2].Key != "ENABLED" || diffs[2].To != nil {
+		t.Errorf("exact diff should report removed keys, got %v", diffs)
+	}
+	if got := diffs[2].String(); got != "- ENABLED: 1" {
+		t.Errorf("unexpected diff string %s", got)
+	}
+}
+
+func TestNewPlan(t *testing.T) {
+	group := &Manifest{Kind: KindAgentGroup, Name: "prod"}
+	config := &Manifest{Kind: KindAgentGroupConfig, Name: "prod", Spec: map[string]interface{}{"global": map[string]interface{}{"limit": 2}}}
+	plugin := &Manifest{Kind: KindPlugin, Name: "hello"}
+	desired := []*Manifest{plugin, config, group}
+	normalized := map[*Manifest]interface{}{config: config.Spec}
+	current := []*Object{
+		{Kind: KindAgentGroup, Name: "default", Protected: true},
+		{Kind: KindAgentGroup, Name: "legacy"},
+		{Kind: KindAgentGroupConfig, Name: "prod", Spec: map[string]interface{}{"global": map[string]interface{}{"limit": 1}}},
+		{Kind: KindPlugin, Name: "hello"},
+		{Kind: KindDomain, Name: "untouched"},
+	}
+	options := map[string]KindOption{KindAgentGroupConfig: {Comparable: true, Exact: true}}
+
+	plan := NewPlan(desired, normalized, current, options, false)
+	var got []string
+	for _, c := range plan.Changes {
+		got = append(got, c.Action+" "+c.Kind+" "+c.Name)
+	}
+	want := []string{"create AgentGroup prod", "update AgentGroupConfig prod", "unchanged Plugin hello"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	plan = NewPlan(desired, normalized, current, options, true)
+	last := plan.Changes[len(plan.Changes)-1]
+	if last.Action != ActionDelete || last.Name != "legacy" {
+		t.Errorf("prune should only delete the unprotected agent group, got %v", last)
+	}
+	if plan.Count(ActionDelete) != 1 || !plan.HasChanges() {
+		t.Errorf("unexpected plan counts, delete: %d", plan.Count(ActionDelete))
+	}
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ctl
+
+import (
+	"fmt"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	simplejson "github.com/bitly/go-simplejson"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/deepflowio/deepflow/cli/ctl/common"
+	"github.com/deepflowio/deepflow/cli/ctl/common/table"
+)
+
+type inventoryArgs struct {
+	filters        []string
+	labelSelector  string
+	expand         string
+	includeDeleted bool
+	pageIndex      int
+	pageSize       int
+	columns        string
+	output         string
+}
+
+// default table columns, only the ones present in the returned objects are shown
+var inventoryDefaultColumns = []string{"ID", "NAME", "IP", "MAC", "VIP", "DEVICE_TYPE", "DEVICE_ID", "VPC_ID", "EPC_ID", "DOMAIN", "SUB_DOMAIN"}
+
+func RegisterInventoryCommand() *cobra.Command {
+	args := inventoryArgs{}
+	inventory := &cobra.Command{
+		Use:   "inventory [resource]",
+		Short: "list cloud and kubernetes resources with filters and related objects",
+		Long:  "list cloud and kubernetes resources with filters and related objects, run without resource to list supported resources and relations",
+		Example: `deepflow-ctl inventory
+deepflow-ctl inventory pods -f pod_namespace_id=3 -l app=web,tier!=db
+deepflow-ctl inventory pods --expand pod_node.vm.vpc --columns ID,NAME,POD_NODE.NAME,POD_NODE.VM.NAME,POD_NODE.VM.VPC.NAME
+deepflow-ctl inventory lan-ips -f ip=10.1.1.1 --expand vinterface.device -o json`,
+		Run: func(cmd *cobra.Command, cmdArgs []string) {
+			if len(cmdArgs) == 0 {
+				listInventoryResources(cmd, args.output)
+				return
+			}
+			listInventory(cmd, cmdArgs[0], args)
+		},
+	}
+	inventory.Flags().StringArrayVarP(&args.filters, "filter", "f", nil, "field filter as key=value, comma separated values mean any of them, can be repeated")
+	inventory.Flags().StringVarP(&args.labelSelector, "selector", "l", "", "label selector, e.g. app=web,tier!=db,canary,!legacy")
+	inventory.Flags().StringVar(&args.expand, "expand", "", "related objects to expand, e.g. pod_node.vm.vpc,pod_group")
+	inventory.Flags().BoolVar(&args.includeDeleted, "include-deleted", false, "include soft deleted resources")
+	inventory.Flags().IntVar(&args.pageIndex, "page", 0, "page index, starts from 1, 0 means no pagination")
+	inventory.Flags().IntVar(&args.pageSize, "page-size", 100, "page size")
+	inventory.Flags().StringVar(&args.columns, "columns", "", "table columns, dotted paths select fields of expanded objects")
+	inventory.Flags().StringVarP(&args.output, "output", "o", "", "output format: json | yaml, default table")
+	return inventory
+}
+
+func listInventoryResources(cmd *cobra.Command, output string) {
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/inventory/", server.IP, server.Port)
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	if printInventoryRaw(response.Get("DATA"), output) {
+		return
+	}
+
+	t := table.New()
+	t.SetHeader([]string{"RESOURCE", "LABEL_SELECTOR", "RELATIONS"})
+	tableItems := [][]string{}
+	for i := range response.Get("DATA").MustArray() {
+		r := response.Get("DATA").GetIndex(i)
+		tableItems = append(tableItems, []string{
+			r.Get("NAME").MustString(),
+			strconv.FormatBool(r.Get("LABEL_SELECTOR").MustBool()),
+			strings.Join(r.Get("RELATIONS").MustStringArray(), ","),
+		})
+	}
+	t.AppendBulk(tableItems)
+	t.Render()
+}
+
+func listInventory(cmd *cobra.Command, resource string, args inventoryArgs) {
+	values := neturl.Values{}
+	for _, filter := range args.filters {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			fmt.Fprintf(os.Stderr, "invalid filter (%s), should be key=value\n", filter)
+			return
+		}
+		values.Add(kv[0], kv[1])
+	}
+	if args.labelSelector != "" {
+		values.Set("label_selector", args.labelSelector)
+	}
+	if args.expand != "" {
+		values.Set("expand", args.expand)
+	}
+	if args.includeDeleted {
+		values.Set("include_deleted", "true")
+	}
+	if args.pageIndex > 0 {
+		values.Set("page_index", strconv.Itoa(args.pageIndex))
+		values.Set("page_size", strconv.Itoa(args.pageSize))
+	}
+
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/inventory/%s/", server.IP, server.Port, neturl.PathEscape(resource))
+	if len(values) != 0 {
+		url += "?" + values.Encode()
+	}
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	if printInventoryRaw(response.Get("DATA"), args.output) {
+		return
+	}
+
+	data := response.Get("DATA")
+	columns := inventoryColumns(data, args.columns, args.expand)
+	t := table.New()
+	t.SetHeader(columns)
+	tableItems := [][]string{}
+	for i := range data.MustArray() {
+		obj := data.GetIndex(i)
+		row := make([]string, 0, len(columns))
+		for _, column := range columns {
+			row = append(row, inventoryValue(obj, column))
+		}
+		tableItems = append(tableItems, row)
+	}
+	t.AppendBulk(tableItems)
+	t.Render()
+	if page, ok := response.CheckGet("PAGE"); ok {
+		fmt.Printf("page %d/%d, total %d\n", page.Get("INDEX").MustInt(), page.Get("TOTAL").MustInt(), page.Get("TOTAL_ITEM").MustInt())
+	}
+}
+
+func printInventoryRaw(data *simplejson.Json, output string) bool {
+	switch output {
+	case "json":
+		dataJson, _ := data.EncodePretty()
+		fmt.Println(string(dataJson))
+	case "yaml":
+		dataJson, _ := data.MarshalJSON()
+		dataYaml, _ := yaml.JSONToYAML(dataJson)
+		fmt.Print(string(dataYaml))
+	default:
+		return false
+	}
+	return true
+}
+
+// inventoryColumns returns the table columns, by default the common fields of the
+// first object followed by the name of each top level expanded object.
+func inventoryColumns(data *simplejson.Json, columns, expand string) []string {
+	if columns != "" {
+		return strings.Split(strings.ToUpper(columns), ",")
+	}
+	result := []string{}
+	first := data.GetIndex(0)
+	for _, column := range inventoryDefaultColumns {
+		if _, ok := first.CheckGet(column); ok {
+			result = append(result, column)
+		}
+	}
+	if len(result) == 0 {
+		result = append(result, "ID", "NAME")
+	}
+	seen := make(map[string]struct{})
+	for _, path := range strings.Split(expand, ",") {
+		name := strings.ToUpper(strings.TrimSpace(strings.Split(path, ".")[0]))
+		if _, ok := seen[name]; ok || name == "" {
+			continue
+		}
+		seen[name] = struct{}{}
+		result = append(result, name)
+	}
+	return result
+}
+
+func inventoryValue(obj *simplejson.Json, column string) string {
+	value := obj.GetPath(strings.Split(column, ".")...)
+	switch v := value.Interface().(type) {
+	case nil:
+		return ""
+	case map[string]interface{}:
+		// expanded object, show its name
+		if name, ok := v["NAME"]; ok && fmt.Sprint(name) != "" {
+			return fmt.Sprint(name)
+		}
+		return fmt.Sprint(v["ID"])
+	case []interface{}:
+		names := make([]string, 0, len(v))
+		for i := range v {
+			names = append(names, inventoryValue(value.GetIndex(i), "NAME"))
+		}
+		return strings.Join(names, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
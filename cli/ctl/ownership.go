@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ctl
+
+import (
+	"fmt"
+	neturl "net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/deepflowio/deepflow/cli/ctl/common"
+	"github.com/deepflowio/deepflow/cli/ctl/common/table"
+)
+
+type ownershipArgs struct {
+	at        string
+	startTime string
+	endTime   string
+	output    string
+}
+
+func RegisterOwnershipCommand() *cobra.Command {
+	args := ownershipArgs{}
+	ownership := &cobra.Command{
+		Use:   "ownership",
+		Short: "look up which resources owned an ip or mac, or which ips a resource owned, over time",
+		Run: func(cmd *cobra.Command, cmdArgs []string) {
+			fmt.Printf("please run with 'ip | mac | resource'.\n")
+		},
+	}
+	ownership.PersistentFlags().StringVar(&args.at, "at", "", "only show owners at this time, unix seconds or '2006-01-02 15:04:05'")
+	ownership.PersistentFlags().StringVar(&args.startTime, "start-time", "", "scan resource events since this time")
+	ownership.PersistentFlags().StringVar(&args.endTime, "end-time", "", "scan resource events until this time")
+	ownership.PersistentFlags().StringVarP(&args.output, "output", "o", "", "output format: json | yaml, default table")
+
+	ip := &cobra.Command{
+		Use:     "ip <ip>",
+		Short:   "show owners of an ip",
+		Example: "deepflow-ctl ownership ip 10.1.2.3 --at '2024-06-01 14:05:00'",
+		Run: func(cmd *cobra.Command, cmdArgs []string) {
+			if len(cmdArgs) != 1 {
+				fmt.Fprintf(os.Stderr, "must specify ip.\nExample: %s\n", cmd.Example)
+				return
+			}
+			listOwnership(cmd, neturl.Values{"ip": {cmdArgs[0]}}, args)
+		},
+	}
+	mac := &cobra.Command{
+		Use:     "mac <mac>",
+		Short:   "show owners of a mac",
+		Example: "deepflow-ctl ownership mac 52:54:00:12:34:56",
+		Run: func(cmd *cobra.Command, cmdArgs []string) {
+			if len(cmdArgs) != 1 {
+				fmt.Fprintf(os.Stderr, "must specify mac.\nExample: %s\n", cmd.Example)
+				return
+			}
+			listOwnership(cmd, neturl.Values{"mac": {cmdArgs[0]}}, args)
+		},
+	}
+	resource := &cobra.Command{
+		Use:     "resource <type> <id>",
+		Short:   "show ips owned by a resource, type is pod, vm, host, pod_node, pod_service, lb, nat_gateway or a device type number",
+		Example: "deepflow-ctl ownership resource pod 1024",
+		Run: func(cmd *cobra.Command, cmdArgs []string) {
+			if len(cmdArgs) != 2 {
+				fmt.Fprintf(os.Stderr, "must specify resource type and id.\nExample: %s\n", cmd.Example)
+				return
+			}
+			listOwnership(cmd, neturl.Values{"resource_type": {cmdArgs[0]}, "resource_id": {cmdArgs[1]}}, args)
+		},
+	}
+	ownership.AddCommand(ip, mac, resource)
+	return ownership
+}
+
+func listOwnership(cmd *cobra.Command, values neturl.Values, args ownershipArgs) {
+	for key, value := range map[string]string{"time": args.at, "start_time": args.startTime, "end_time": args.endTime} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/ownership/?%s", server.IP, server.Port, values.Encode())
+	response, err := common.CURLPerform("GET", url, nil, "",
+		[]common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	switch args.output {
+	case "json":
+		dataJson, _ := response.Get("DATA").EncodePretty()
+		fmt.Println(string(dataJson))
+		return
+	case "yaml":
+		dataJson, _ := response.Get("DATA").MarshalJSON()
+		dataYaml, _ := yaml.JSONToYAML(dataJson)
+		fmt.Print(string(dataYaml))
+		return
+	}
+
+	t := table.New()
+	t.SetHeader([]string{"IP", "MAC", "RESOURCE_TYPE", "RESOURCE_ID", "RESOURCE_NAME", "START_TIME", "END_TIME", "DELETED", "TAGS"})
+	tableItems := [][]string{}
+	for i := range response.Get("DATA").MustArray() {
+		o := response.Get("DATA").GetIndex(i)
+		startTime := o.Get("START_TIME").MustString()
+		if startTime == "" {
+			startTime = "-"
+		}
+		endTime := o.Get("END_TIME").MustString()
+		if endTime == "" {
+			endTime = "now"
+		}
+		tags := []string{}
+		for k, v := range o.Get("TAGS").MustMap() {
+			tags = append(tags, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(tags)
+		tableItems = append(tableItems, []string{
+			o.Get("IP").MustString(),
+			o.Get("MAC").MustString(),
+			o.Get("RESOURCE_TYPE_NAME").MustString(),
+			strconv.Itoa(o.Get("RESOURCE_ID").MustInt()),
+			o.Get("RESOURCE_NAME").MustString(),
+			startTime,
+			endTime,
+			strconv.FormatBool(o.Get("RESOURCE_DELETED").MustBool()),
+			strings.Join(tags, ","),
+		})
+	}
+	t.AppendBulk(tableItems)
+	t.Render()
+}
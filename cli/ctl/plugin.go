@@ -24,6 +24,7 @@ import (
 	"mime/multipart"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -37,23 +38,31 @@ func RegisterPluginCommand() *cobra.Command {
 		Use:   "plugin",
 		Short: "plugin operation commands",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("please run with 'list | create | delete.'\n")
+			fmt.Printf("please run with 'list | create | delete | list-versions | promote | rollback.'\n")
 		},
 	}
 
-	var createType, image, name, user string
+	var createType, image, name, user, version, signature string
+	var promote bool
 	create := &cobra.Command{
 		Use:   "create",
 		Short: "create plugin",
 		Example: "deepflow-ctl plugin create --type wasm --image /home/tom/hello.wasm --name hello\n" +
 			"deepflow-ctl plugin create --type so --image /home/tom/hello.so --name hello\n" +
-			"deepflow-ctl plugin create --type lua --image /home/tom/hello.lua --name hello --user server",
+			"deepflow-ctl plugin create --type lua --image /home/tom/hello.lua --name hello --user server\n" +
+			"deepflow-ctl plugin create --type wasm --image /home/tom/hello.wasm --name hello --version 1.1.0 --signature /home/tom/hello.wasm.sig --promote=false",
 		Run: func(cmd *cobra.Command, args []string) {
 			if _, err := os.Stat(image); errors.Is(err, os.ErrNotExist) {
 				fmt.Printf("file(%s) not found\n", image)
 				return
 			}
-			if err := createPlugin(cmd, createType, image, name, user); err != nil {
+			if signature != "" {
+				if _, err := os.Stat(signature); errors.Is(err, os.ErrNotExist) {
+					fmt.Printf("file(%s) not found\n", signature)
+					return
+				}
+			}
+			if err := createPlugin(cmd, createType, image, name, user, version, signature, promote); err != nil {
 				fmt.Println(err)
 			}
 		},
@@ -62,6 +71,9 @@ func RegisterPluginCommand() *cobra.Command {
 	create.Flags().StringVarP(&image, "image", "", "", "plugin image to upload")
 	create.Flags().StringVarP(&name, "name", "", "", "specify a unique alias for image")
 	create.Flags().StringVarP(&user, "user", "", "agent", "specify the component for which plugin is used. the optional value is agent/server")
+	create.Flags().StringVarP(&version, "version", "", "", "version of image, generated as v<N> if not specified")
+	create.Flags().StringVarP(&signature, "signature", "", "", "file of the image signature, sent to agents along with image for verification")
+	create.Flags().BoolVarP(&promote, "promote", "", true, "send this version to agents using the plugin by name, the first version is always promoted")
 	create.MarkFlagsRequiredTogether("type", "image", "name")
 
 	list := &cobra.Command{
@@ -84,16 +96,62 @@ func RegisterPluginCommand() *cobra.Command {
 		},
 	}
 
+	listVersions := &cobra.Command{
+		Use:     "list-versions",
+		Short:   "list versions of plugin",
+		Example: "deepflow-ctl plugin list-versions <name>",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := listPluginVersions(cmd, args); err != nil {
+				fmt.Println(err)
+			}
+		},
+	}
+
+	promoteCmd := &cobra.Command{
+		Use:   "promote",
+		Short: "send a version of plugin to agents using the plugin by name",
+		Example: "deepflow-ctl plugin promote <name> <version>\n" +
+			"(agent groups pinning a version by <name>@<version> in plugins.wasm_plugins or plugins.so_plugins are not affected)",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := promotePlugin(cmd, args); err != nil {
+				fmt.Println(err)
+			}
+		},
+	}
+
+	rollback := &cobra.Command{
+		Use:     "rollback",
+		Short:   "promote the version of plugin promoted before the current one again",
+		Example: "deepflow-ctl plugin rollback <name>",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := rollbackPlugin(cmd, args); err != nil {
+				fmt.Println(err)
+			}
+		},
+	}
+
 	plugin.AddCommand(create)
 	plugin.AddCommand(list)
 	plugin.AddCommand(delete)
+	plugin.AddCommand(listVersions)
+	plugin.AddCommand(promoteCmd)
+	plugin.AddCommand(rollback)
 	return plugin
 }
 
-func createPlugin(cmd *cobra.Command, t, image, name, user string) error {
+func createPlugin(cmd *cobra.Command, t, image, name, user, version, signature string, promote bool) error {
 	bodyBuf := &bytes.Buffer{}
 	bodyWriter := multipart.NewWriter(bodyBuf)
 	bodyWriter.WriteField("NAME", name)
+	bodyWriter.WriteField("VERSION", version)
+	bodyWriter.WriteField("PROMOTE", strconv.FormatBool(promote))
+	if signature != "" {
+		content, err := os.ReadFile(signature)
+		if err != nil {
+			return err
+		}
+		bodyWriter.WriteField("SIGNATURE", strings.TrimSpace(string(content)))
+	}
 	switch t {
 	case "wasm":
 		bodyWriter.WriteField("TYPE", "1")
@@ -144,12 +202,13 @@ func listPlugin(cmd *cobra.Command) {
 	}
 	data := response.Get("DATA")
 	var (
-		typeMaxSize = jsonparser.GetTheMaxSizeOfAttr(data, "TYPE")
-		nameMaxSize = jsonparser.GetTheMaxSizeOfAttr(data, "NAME")
-		userMaxSize = jsonparser.GetTheMaxSizeOfAttr(data, "USER")
+		typeMaxSize    = jsonparser.GetTheMaxSizeOfAttr(data, "TYPE")
+		nameMaxSize    = jsonparser.GetTheMaxSizeOfAttr(data, "NAME")
+		userMaxSize    = jsonparser.GetTheMaxSizeOfAttr(data, "USER")
+		versionMaxSize = jsonparser.GetTheMaxSizeOfAttr(data, "VERSION")
 	)
-	cmdFormat := "%-*s %-*s %-*s %-19s\n"
-	fmt.Printf(cmdFormat, typeMaxSize, "TYPE", nameMaxSize, "NAME", userMaxSize, "USER", "UPDATED_AT")
+	cmdFormat := "%-*s %-*s %-*s %-*s %-19s\n"
+	fmt.Printf(cmdFormat, typeMaxSize, "TYPE", nameMaxSize, "NAME", userMaxSize, "USER", versionMaxSize, "VERSION", "UPDATED_AT")
 	for i := range data.MustArray() {
 		d := data.GetIndex(i)
 
@@ -157,11 +216,80 @@ func listPlugin(cmd *cobra.Command) {
 			typeMaxSize, common.PluginType(d.Get("TYPE").MustInt()),
 			nameMaxSize, d.Get("NAME").MustString(),
 			userMaxSize, common.PluginUser(d.Get("USER").MustInt()),
+			versionMaxSize, d.Get("VERSION").MustString(),
 			d.Get("UPDATED_AT").MustString(),
 		)
 	}
 }
 
+func listPluginVersions(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("must specify one name\nExample: %s", cmd.Example)
+	}
+
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/plugin/%s/versions/", server.IP, server.Port, args[0])
+	response, err := common.CURLPerform("GET", url, nil, "", []common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		return err
+	}
+	data := response.Get("DATA")
+	versionMaxSize := jsonparser.GetTheMaxSizeOfAttr(data, "VERSION")
+	cmdFormat := "%-*s %-8s %-64s %-9s %-19s %-19s\n"
+	fmt.Printf(cmdFormat, versionMaxSize, "VERSION", "PROMOTED", "SHA256", "SIGNATURE", "PROMOTED_AT", "CREATED_AT")
+	for i := range data.MustArray() {
+		d := data.GetIndex(i)
+
+		promoted, signed := "", "no"
+		if d.Get("PROMOTED").MustBool() {
+			promoted = "*"
+		}
+		if d.Get("SIGNATURE").MustString() != "" {
+			signed = "yes"
+		}
+		fmt.Printf(cmdFormat,
+			versionMaxSize, d.Get("VERSION").MustString(),
+			promoted,
+			d.Get("SHA256").MustString(),
+			signed,
+			d.Get("PROMOTED_AT").MustString(),
+			d.Get("CREATED_AT").MustString(),
+		)
+	}
+	return nil
+}
+
+func promotePlugin(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("must specify name and version\nExample: %s", cmd.Example)
+	}
+
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/plugin/%s/promote/", server.IP, server.Port, args[0])
+	body := map[string]interface{}{"VERSION": args[1]}
+	response, err := common.CURLPerform("POST", url, body, "", []common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("plugin (%s) version %s promoted\n", args[0], response.Get("DATA").Get("VERSION").MustString())
+	return nil
+}
+
+func rollbackPlugin(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("must specify one name\nExample: %s", cmd.Example)
+	}
+
+	server := common.GetServerInfo(cmd)
+	url := fmt.Sprintf("http://%s:%d/v1/plugin/%s/rollback/", server.IP, server.Port, args[0])
+	response, err := common.CURLPerform("POST", url, nil, "", []common.HTTPOption{common.WithTimeout(common.GetTimeout(cmd)), common.WithORGID(common.GetORGID(cmd))}...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("plugin (%s) rolled back to version %s\n", args[0], response.Get("DATA").Get("VERSION").MustString())
+	return nil
+}
+
 func deletePlugin(cmd *cobra.Command, args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("must specify name\nExample: %s", cmd.Example)
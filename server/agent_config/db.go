@@ -31,6 +31,24 @@ func (MySQLAgentGroupConfiguration) TableName() string {
 	return "agent_group_configuration"
 }
 
+// AgentGroupConfigRevision is an immutable snapshot of agent_group_configuration, one is saved
+// on every create, update and rollback
+type AgentGroupConfigRevision struct {
+	ID               int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
+	Lcuuid           string    `gorm:"column:lcuuid;type:char(64);default:not null" json:"LCUUID"`
+	AgentGroupLcuuid string    `gorm:"column:agent_group_lcuuid;type:char(64);default:not null" json:"AGENT_GROUP_LCUUID"`
+	Revision         int       `gorm:"column:revision;type:int;not null" json:"REVISION"`
+	Action           string    `gorm:"column:action;type:varchar(16);not null" json:"ACTION"`
+	Yaml             string    `gorm:"column:yaml;type:text;default:not null" json:"YAML"`
+	UserID           int       `gorm:"column:user_id;type:int;default:1" json:"USER_ID"`
+	Comment          string    `gorm:"column:comment;type:varchar(512);default:''" json:"COMMENT"`
+	CreatedAt        time.Time `gorm:"column:created_at;type:timestamp;not null;default:CURRENT_TIMESTAMP" json:"CREATED_AT"`
+}
+
+func (AgentGroupConfigRevision) TableName() string {
+	return "agent_group_configuration_revision"
+}
+
 type AgentGroupConfigModel struct {
 	ID                                int      `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
 	MaxCollectPps                     *int     `gorm:"column:max_collect_pps;type:int;default:null" json:"MAX_COLLECT_PPS"`
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent_config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CONFIG_DIFF_KIND_ADDED   = "added"
+	CONFIG_DIFF_KIND_REMOVED = "removed"
+	CONFIG_DIFF_KIND_CHANGED = "changed"
+)
+
+// ConfigDiff is a difference of one leaf key, lists are compared as a whole
+type ConfigDiff struct {
+	Key  string      `json:"KEY" yaml:"key"`
+	Kind string      `json:"KIND" yaml:"kind"`
+	From interface{} `json:"FROM" yaml:"from"`
+	To   interface{} `json:"TO" yaml:"to"`
+}
+
+// DiffWithTemplate returns the keys of the agent group config that differ from the defaults in template.yaml,
+// keys set to their default values are not returned
+func DiffWithTemplate(yamlData []byte) ([]ConfigDiff, error) {
+	defaults, err := flattenYAML(YamlAgentGroupConfigTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parse template error: %v", err)
+	}
+	values, err := flattenYAML(yamlData)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ConfigDiff, 0)
+	for _, key := range sortedKeys(values) {
+		value := values[key]
+		defaultValue, ok := defaults[key]
+		if !ok {
+			result = append(result, ConfigDiff{Key: key, Kind: CONFIG_DIFF_KIND_ADDED, To: value})
+		} else if !equalValue(defaultValue, value) {
+			result = append(result, ConfigDiff{Key: key, Kind: CONFIG_DIFF_KIND_CHANGED, From: defaultValue, To: value})
+		}
+	}
+	return result, nil
+}
+
+// DiffYAML returns the keys added, removed or changed from one agent group config to another
+func DiffYAML(from, to []byte) ([]ConfigDiff, error) {
+	fromValues, err := flattenYAML(from)
+	if err != nil {
+		return nil, err
+	}
+	toValues, err := flattenYAML(to)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := sortedKeys(fromValues)
+	for key := range toValues {
+		if _, ok := fromValues[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := make([]ConfigDiff, 0)
+	for _, key := range keys {
+		fromValue, inFrom := fromValues[key]
+		toValue, inTo := toValues[key]
+		switch {
+		case !inFrom:
+			result = append(result, ConfigDiff{Key: key, Kind: CONFIG_DIFF_KIND_ADDED, To: toValue})
+		case !inTo:
+			result = append(result, ConfigDiff{Key: key, Kind: CONFIG_DIFF_KIND_REMOVED, From: fromValue})
+		case !equalValue(fromValue, toValue):
+			result = append(result, ConfigDiff{Key: key, Kind: CONFIG_DIFF_KIND_CHANGED, From: fromValue, To: toValue})
+		}
+	}
+	return result, nil
+}
+
+// flattenYAML converts yaml to long keys joined by '.' and their leaf values
+func flattenYAML(yamlData []byte) (map[string]interface{}, error) {
+	var data map[string]interface{}
+	if err := yaml.Unmarshal(yamlData, &data); err != nil {
+		return nil, fmt.Errorf("unmarshal yaml error: %v", err)
+	}
+	result := make(map[string]interface{})
+	flatten(data, "", result)
+	return result, nil
+}
+
+func flatten(data map[string]interface{}, ancestors string, result map[string]interface{}) {
+	for key, value := range data {
+		longKey := key
+		if ancestors != "" {
+			longKey = ancestors + "." + key
+		}
+		if m, ok := value.(map[string]interface{}); ok && len(m) > 0 {
+			flatten(m, longKey, result)
+			continue
+		}
+		result[longKey] = value
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// equalValue ignores the difference between int and float, 1 and 1.0 are the same config
+func equalValue(a, b interface{}) bool {
+	return reflect.DeepEqual(normalizeValue(a), normalizeValue(b))
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case int:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case uint64:
+		return float64(t)
+	case []interface{}:
+		result := make([]interface{}, len(t))
+		for i := range t {
+			result[i] = normalizeValue(t[i])
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(t))
+		for k := range t {
+			result[k] = normalizeValue(t[k])
+		}
+		return result
+	}
+	return v
+}
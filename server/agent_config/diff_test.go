@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent_config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffWithTemplate(t *testing.T) {
+	data := []byte(`
+global:
+  limits:
+    max_millicpus: 1000
+    max_memory: 1024
+unknown_key: 1
+`)
+	diffs, err := DiffWithTemplate(data)
+	assert.NoError(t, err)
+	assert.Equal(t, []ConfigDiff{
+		{Key: "global.limits.max_memory", Kind: CONFIG_DIFF_KIND_CHANGED, From: 768, To: 1024},
+		{Key: "unknown_key", Kind: CONFIG_DIFF_KIND_ADDED, To: 1},
+	}, diffs)
+
+	diffs, err = DiffWithTemplate([]byte(""))
+	assert.NoError(t, err)
+	assert.Empty(t, diffs)
+}
+
+func TestDiffYAML(t *testing.T) {
+	from := []byte(`
+global:
+  limits:
+    max_millicpus: 1000
+    max_memory: 1024
+inputs:
+  proc:
+    enabled: true
+`)
+	to := []byte(`
+global:
+  limits:
+    max_millicpus: 1000.0
+    max_log_backhaul_rate: 100
+inputs:
+  proc:
+    enabled: false
+`)
+	diffs, err := DiffYAML(from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, []ConfigDiff{
+		{Key: "global.limits.max_log_backhaul_rate", Kind: CONFIG_DIFF_KIND_ADDED, To: 100},
+		{Key: "global.limits.max_memory", Kind: CONFIG_DIFF_KIND_REMOVED, From: 1024},
+		{Key: "inputs.proc.enabled", Kind: CONFIG_DIFF_KIND_CHANGED, From: true, To: false},
+	}, diffs)
+
+	_, err = DiffYAML([]byte("a: [1"), to)
+	assert.Error(t, err)
+}
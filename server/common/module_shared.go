@@ -36,6 +36,7 @@ const QUEUE_SIZE = 1 << 16
 type ControllerIngesterShared struct {
 	ResourceEventQueue *queue.OverwriteQueue
 	TraceTreeQueue     *queue.OverwriteQueue
+	AlertEventQueue    *queue.OverwriteQueue
 }
 
 func NewControllerIngesterShared() *ControllerIngesterShared {
@@ -48,6 +49,9 @@ func NewControllerIngesterShared() *ControllerIngesterShared {
 			"querier-to-ingester-trace_tree", QUEUE_SIZE,
 			queue.OptionFlushIndicator(time.Second*3),
 			queue.OptionRelease(func(p interface{}) { p.(*tracetree.TraceTree).Release() })),
+		AlertEventQueue: queue.NewOverwriteQueue(
+			"controller-to-ingester-alert_event", QUEUE_SIZE,
+			queue.OptionFlushIndicator(time.Second*3)),
 	}
 }
 
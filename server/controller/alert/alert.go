@@ -0,0 +1,238 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/deepflowio/deepflow/message/alert_event"
+	"github.com/deepflowio/deepflow/server/controller/alert/config"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	"github.com/deepflowio/deepflow/server/libs/logger"
+	"github.com/deepflowio/deepflow/server/libs/queue"
+)
+
+var log = logger.MustGetLogger("alert")
+
+type orgState struct {
+	rules   map[int]*ruleState // key: policy id
+	invalid map[int]string     // policy id -> updatedKey of the invalid policy, to log only once
+}
+
+// Evaluator runs the alarm policies on the master controller, the firing and recovered alerts are written
+// to event.alert_event through the ingester in the same process, and sent to the webhooks and mail receivers
+type Evaluator struct {
+	eCtx            context.Context
+	eCancel         context.CancelFunc
+	cfg             config.AlertConfig
+	alertEventQueue *queue.OverwriteQueue
+	querier         *querier
+	notifier        *notifier
+}
+
+func NewEvaluator(cfg config.AlertConfig, ctx context.Context, alertEventQueue *queue.OverwriteQueue) *Evaluator {
+	eCtx, eCancel := context.WithCancel(ctx)
+	return &Evaluator{
+		eCtx:            eCtx,
+		eCancel:         eCancel,
+		cfg:             cfg,
+		alertEventQueue: alertEventQueue,
+		querier:         &querier{},
+		notifier:        newNotifier(cfg),
+	}
+}
+
+func (e *Evaluator) Start(sCtx context.Context) {
+	if !e.cfg.Enabled {
+		return
+	}
+	log.Info("alert evaluator start")
+	go func() {
+		// states are only kept in memory, pending and firing alerts are evaluated again after the master controller changes
+		orgStates := make(map[int]*orgState)
+		ticker := time.NewTicker(time.Duration(e.cfg.EvaluationInterval) * time.Second)
+		defer ticker.Stop()
+	LOOP:
+		for {
+			select {
+			case <-ticker.C:
+				metadb.GetDBs().DoOnAllDBs(func(db *metadb.DB) error {
+					state, ok := orgStates[db.ORGID]
+					if !ok {
+						state = &orgState{rules: make(map[int]*ruleState), invalid: make(map[int]string)}
+						orgStates[db.ORGID] = state
+					}
+					e.evaluate(sCtx, db, state, time.Now())
+					return nil
+				})
+			case <-sCtx.Done():
+				break LOOP
+			case <-e.eCtx.Done():
+				break LOOP
+			}
+		}
+	}()
+}
+
+func (e *Evaluator) Stop() {
+	if e.eCancel != nil {
+		e.eCancel()
+	}
+	log.Info("alert evaluator stopped")
+}
+
+func (e *Evaluator) loadRules(db *metadb.DB, state *orgState) map[int]*rule {
+	var policies []*metadbmodel.AlarmPolicy
+	if err := db.Where(
+		"state = ? AND deleted = ? AND query_url IN ?",
+		POLICY_STATE_ENABLED, POLICY_NOT_DELETED, []string{QUERY_URL_PROMQL, QUERY_URL_SQL},
+	).Find(&policies).Error; err != nil {
+		log.Errorf("failed to query %s: %s", "alarm_policy", err, db.LogPrefixORGID)
+		return nil
+	}
+	rules := make(map[int]*rule, len(policies))
+	invalid := make(map[int]string)
+	for _, p := range policies {
+		r, err := newRule(db.ORGID, p)
+		if err != nil {
+			key := p.QueryParams + p.ThresholdCritical + p.ThresholdError + p.ThresholdWarning
+			if state.invalid[p.ID] != key {
+				log.Warningf("alarm policy (%d, %s) is invalid: %s", p.ID, p.Name, err, db.LogPrefixORGID)
+			}
+			invalid[p.ID] = key
+			continue
+		}
+		if r != nil {
+			rules[r.id] = r
+		}
+	}
+	state.invalid = invalid
+	return rules
+}
+
+func (e *Evaluator) evaluate(ctx context.Context, db *metadb.DB, state *orgState, now time.Time) {
+	rules := e.loadRules(db, state)
+	if rules == nil {
+		return
+	}
+	for id := range state.rules {
+		if _, ok := rules[id]; !ok {
+			// the policy is deleted or disabled, the firing alerts are dropped without recovery
+			delete(state.rules, id)
+		}
+	}
+
+	var due []*ruleState
+	for id, r := range rules {
+		rs, ok := state.rules[id]
+		if !ok || rs.rule.updatedKey != r.updatedKey {
+			rs = newRuleState(r)
+			state.rules[id] = rs
+		}
+		rs.rule = r
+		if now.Sub(rs.lastEvaluated) < r.frequency {
+			continue
+		}
+		due = append(due, rs)
+	}
+
+	// each rule state is only updated by its own goroutine, a slow policy only takes one of the slots
+	concurrency := e.cfg.QueryConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var alerts []*alert
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+	for _, rs := range due {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(rs *ruleState) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			r := rs.rule
+			qCtx, cancel := context.WithTimeout(ctx, time.Duration(e.cfg.QueryTimeout)*time.Second)
+			samples, err := e.querier.query(qCtx, r, now)
+			cancel()
+			if err != nil {
+				// keep the states unchanged if the query fails
+				log.Warningf("alarm policy (%d, %s) query failed: %s", r.id, r.name, err, db.LogPrefixORGID)
+				rs.lastEvaluated = now
+				return
+			}
+			ruleAlerts := rs.update(now, samples, time.Duration(e.cfg.ResendInterval)*time.Second)
+			mutex.Lock()
+			alerts = append(alerts, ruleAlerts...)
+			mutex.Unlock()
+		}(rs)
+	}
+	wg.Wait()
+	if len(alerts) == 0 {
+		return
+	}
+
+	log.Infof("alarm policies generated %d alerts", len(alerts), db.LogPrefixORGID)
+	for _, a := range alerts {
+		if err := e.alertEventQueue.Put(newAlertEvent(a)); err != nil {
+			log.Warningf("put alert event to queue failed: %s", err, db.LogPrefixORGID)
+		}
+	}
+	go e.notifier.notify(db, alerts)
+}
+
+func newAlertEvent(a *alert) *alert_event.AlertEvent {
+	names := make([]string, 0, len(a.labels))
+	for name := range a.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = a.labels[name]
+	}
+	targetTags, _ := json.Marshal(a.labels)
+	h := fnv.New64a()
+	h.Write([]byte(strconv.Itoa(a.rule.id) + "/" + a.key()))
+
+	return &alert_event.AlertEvent{
+		Time:         proto.Uint32(uint32(a.time.Unix())),
+		PolicyId:     proto.Uint32(uint32(a.rule.id)),
+		PolicyType:   proto.Uint32(a.rule.policyType),
+		AlertPolicy:  proto.String(a.rule.name),
+		MetricValue:  proto.Float64(a.value),
+		EventLevel:   proto.Uint32(a.level),
+		TargetTags:   proto.String(string(targetTags)),
+		TagStrKeys:   names,
+		TagStrValues: values,
+		OrgId:        proto.Uint32(uint32(a.rule.orgID)),
+		UserId:       proto.Uint32(uint32(a.rule.userID)),
+		TeamId:       proto.Uint32(uint32(a.rule.teamID)),
+		XTargetUid:   proto.String(strconv.FormatUint(h.Sum64(), 16)),
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package alert
+
+import (
+	"testing"
+	"time"
+
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+)
+
+func newTestPolicy() *metadbmodel.AlarmPolicy {
+	return &metadbmodel.AlarmPolicy{
+		ID:                   1,
+		Name:                 "cpu",
+		State:                POLICY_STATE_ENABLED,
+		AppType:              3,
+		QueryURL:             QUERY_URL_PROMQL,
+		QueryParams:          `{"query": "avg(cpu) by (host)", "for": "2m"}`,
+		ThresholdCritical:    `{"OP":">=","VALUE":90}`,
+		ThresholdWarning:     `{"OP":">=","VALUE":70}`,
+		MonitoringFrequency:  "1m",
+		TriggerRecoveryEvent: 1,
+	}
+}
+
+func TestNewRule(t *testing.T) {
+	r, err := newRule(1, newTestPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.query != "avg(cpu) by (host)" || r.forDuration != 2*time.Minute || r.frequency != time.Minute || !r.recovery {
+		t.Errorf("unexpected rule %+v", r)
+	}
+	for _, c := range []struct {
+		value float64
+		level uint32
+	}{{95, EVENT_LEVEL_CRITICAL}, {90, EVENT_LEVEL_CRITICAL}, {80, EVENT_LEVEL_WARNING}, {50, 0}} {
+		if level := r.level(c.value); level != c.level {
+			t.Errorf("value %v, expected level %d, got %d", c.value, c.level, level)
+		}
+	}
+
+	p := newTestPolicy()
+	p.QueryURL = "/v1/alarm/vtap-lost/"
+	if r, err := newRule(1, p); r != nil || err != nil {
+		t.Errorf("policy with query_url %s should be ignored", p.QueryURL)
+	}
+	p = newTestPolicy()
+	p.ThresholdCritical, p.ThresholdWarning = "", "null"
+	if _, err := newRule(1, p); err == nil {
+		t.Errorf("policy without threshold should be invalid")
+	}
+	p = newTestPolicy()
+	p.QueryURL, p.QueryParams = QUERY_URL_SQL, `{"db": "flow_metrics", "sql": "SELECT 1", "metric": "v"}`
+	if r, err := newRule(1, p); err != nil || r.db != "flow_metrics" || r.metric != "v" || r.forDuration != 0 {
+		t.Errorf("unexpected sql rule %+v, err %v", r, err)
+	}
+}
+
+func TestRuleStateUpdate(t *testing.T) {
+	r, _ := newRule(1, newTestPolicy())
+	s := newRuleState(r)
+	start := time.Unix(1714557600, 0)
+	host := func(name string, v float64) sample {
+		return sample{labels: map[string]string{"host": name}, value: v}
+	}
+	steps := []struct {
+		offset  time.Duration
+		samples []sample
+		levels  []uint32
+	}{
+		{0, []sample{host("a", 80), host("b", 10)}, nil},                           // pending
+		{time.Minute, []sample{host("a", 85)}, nil},                                // pending
+		{2 * time.Minute, []sample{host("a", 85)}, []uint32{EVENT_LEVEL_WARNING}},  // firing
+		{3 * time.Minute, []sample{host("a", 85)}, nil},                            // dedup
+		{4 * time.Minute, []sample{host("a", 95)}, []uint32{EVENT_LEVEL_CRITICAL}}, // level changed
+		{5 * time.Minute, []sample{host("a", 20)}, []uint32{EVENT_LEVEL_RECOVERED}},
+		{6 * time.Minute, []sample{host("a", 95)}, nil}, // pending again
+		{7 * time.Minute, nil, nil},                     // disappeared before firing
+	}
+	for i, step := range steps {
+		alerts := s.update(start.Add(step.offset), step.samples, time.Hour)
+		if len(alerts) != len(step.levels) {
+			t.Fatalf("step %d: expected %d alerts, got %d", i, len(step.levels), len(alerts))
+		}
+		for j, a := range alerts {
+			if a.level != step.levels[j] || a.labels["host"] != "a" {
+				t.Errorf("step %d: unexpected alert %+v", i, a)
+			}
+		}
+	}
+	if len(s.series) != 0 {
+		t.Errorf("expected no series left, got %d", len(s.series))
+	}
+
+	// resend and resolve on disappearance
+	r.forDuration = 0
+	s = newRuleState(r)
+	if alerts := s.update(start, []sample{host("a", 95)}, time.Hour); len(alerts) != 1 {
+		t.Fatalf("expected firing alert, got %d", len(alerts))
+	}
+	if alerts := s.update(start.Add(time.Hour), []sample{host("a", 95)}, time.Hour); len(alerts) != 1 {
+		t.Fatalf("expected resent alert, got %d", len(alerts))
+	}
+	alerts := s.update(start.Add(2*time.Hour), nil, time.Hour)
+	if len(alerts) != 1 || alerts[0].level != EVENT_LEVEL_RECOVERED || alerts[0].value != 95 {
+		t.Fatalf("expected recovered alert with the last value, got %+v", alerts)
+	}
+}
+
+func TestSQLSamples(t *testing.T) {
+	columns := []interface{}{"host", "region", "Avg(cpu)"}
+	values := []interface{}{
+		[]interface{}{"a", "r1", 80.5},
+		[]interface{}{"b", "r1", uint64(3)},
+	}
+	samples, err := sqlSamples(columns, values, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 || samples[0].value != 80.5 || samples[1].value != 3 ||
+		samples[0].labels["host"] != "a" || samples[0].labels["region"] != "r1" || len(samples[0].labels) != 2 {
+		t.Errorf("unexpected samples %+v", samples)
+	}
+	if _, err := sqlSamples(columns, values, "not_exist"); err == nil {
+		t.Errorf("expected error for unknown metric column")
+	}
+}
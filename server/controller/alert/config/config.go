@@ -0,0 +1,40 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+type AlertConfig struct {
+	Enabled            bool    `default:"false" yaml:"enabled"`
+	EvaluationInterval int     `default:"10" yaml:"evaluation_interval"` // unit: second
+	QueryTimeout       int     `default:"30" yaml:"query_timeout"`       // unit: second
+	QueryConcurrency   int     `default:"8" yaml:"query_concurrency"`    // policies evaluated at the same time in an organization
+	ResendInterval     int     `default:"3600" yaml:"resend_interval"`   // unit: second, 0 means never resend a firing alert
+	NotifyTimeout      int     `default:"10" yaml:"notify_timeout"`      // unit: second
+	Webhook            Webhook `yaml:"webhook"`
+	Mail               Mail    `yaml:"mail"`
+}
+
+// Webhook receives all the alerts, a policy can also add its own webhooks in query_params
+type Webhook struct {
+	URLs []string `yaml:"urls"`
+}
+
+// Mail sends notifications through the enabled mail server configured by the '/v1/mail-server/' API, shared by all organizations
+type Mail struct {
+	Enabled   bool     `default:"false" yaml:"enabled"`
+	From      string   `yaml:"from"` // default: user name of the mail server
+	Receivers []string `yaml:"receivers"`
+}
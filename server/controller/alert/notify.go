@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package alert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/controller/alert/config"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+)
+
+const (
+	MAIL_SERVER_STATUS_ENABLED = 1
+
+	ALERT_STATUS_FIRING   = "firing"
+	ALERT_STATUS_RESOLVED = "resolved"
+)
+
+type webhookMessage struct {
+	OrgID      int               `json:"org_id"`
+	PolicyID   int               `json:"policy_id"`
+	PolicyName string            `json:"policy_name"`
+	Status     string            `json:"status"`
+	Level      string            `json:"level"`
+	Value      float64           `json:"value"`
+	Tags       map[string]string `json:"tags"`
+	Time       int64             `json:"time"`
+}
+
+func newWebhookMessage(a *alert) *webhookMessage {
+	status := ALERT_STATUS_FIRING
+	if a.level == EVENT_LEVEL_RECOVERED {
+		status = ALERT_STATUS_RESOLVED
+	}
+	return &webhookMessage{
+		OrgID:      a.rule.orgID,
+		PolicyID:   a.rule.id,
+		PolicyName: a.rule.name,
+		Status:     status,
+		Level:      eventLevelNames[a.level],
+		Value:      a.value,
+		Tags:       a.labels,
+		Time:       a.time.Unix(),
+	}
+}
+
+type notifier struct {
+	cfg    config.AlertConfig
+	client *http.Client
+}
+
+func newNotifier(cfg config.AlertConfig) *notifier {
+	return &notifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: time.Duration(cfg.NotifyTimeout) * time.Second},
+	}
+}
+
+func (n *notifier) notify(db *metadb.DB, alerts []*alert) {
+	for _, a := range alerts {
+		msg := newWebhookMessage(a)
+		for _, urls := range [][]string{n.cfg.Webhook.URLs, a.rule.webhookURLs} {
+			for _, url := range urls {
+				if err := n.postWebhook(url, msg); err != nil {
+					log.Warningf("send alert of policy (%d) to webhook %s failed: %s", a.rule.id, url, err, db.LogPrefixORGID)
+				}
+			}
+		}
+	}
+	if n.cfg.Mail.Enabled && len(n.cfg.Mail.Receivers) > 0 && len(alerts) > 0 {
+		if err := n.sendMail(alerts); err != nil {
+			log.Warningf("send alert mail failed: %s", err, db.LogPrefixORGID)
+		}
+	}
+}
+
+func (n *notifier) postWebhook(url string, msg *webhookMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("response status %s", resp.Status)
+	}
+	return nil
+}
+
+func (n *notifier) sendMail(alerts []*alert) error {
+	var server metadbmodel.MailServer
+	// the mail server is shared by all the organizations
+	if err := metadb.DefaultDB.Where("status = ?", MAIL_SERVER_STATUS_ENABLED).First(&server).Error; err != nil {
+		return fmt.Errorf("no enabled mail server: %s", err)
+	}
+	from := n.cfg.Mail.From
+	if from == "" {
+		from = server.UserName
+	}
+	msg := formatMail(from, n.cfg.Mail.Receivers, alerts)
+
+	addr := net.JoinHostPort(server.Host, strconv.Itoa(server.Port))
+	timeout := time.Duration(n.cfg.NotifyTimeout) * time.Second
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	// the deadline covers the whole smtp session, a stuck mail server can not block the notifications
+	conn.SetDeadline(time.Now().Add(timeout))
+	tlsConfig := &tls.Config{ServerName: server.Host}
+	var client *smtp.Client
+	switch strings.ToUpper(server.Security) {
+	case "SSL", "TLS":
+		client, err = smtp.NewClient(tls.Client(conn, tlsConfig), server.Host)
+	default:
+		client, err = smtp.NewClient(conn, server.Host)
+		if err == nil {
+			if ok, _ := client.Extension("STARTTLS"); ok {
+				err = client.StartTLS(tlsConfig)
+			}
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if server.UserName != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", server.UserName, server.Password, server.Host)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range n.cfg.Mail.Receivers {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func formatMail(from string, to []string, alerts []*alert) []byte {
+	var buf bytes.Buffer
+	subject := fmt.Sprintf("[DeepFlow] %d alert event(s)", len(alerts))
+	fmt.Fprintf(&buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", from, strings.Join(to, ", "), subject)
+	buf.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	for _, a := range alerts {
+		msg := newWebhookMessage(a)
+		names := make([]string, 0, len(msg.Tags))
+		for name := range msg.Tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		tags := make([]string, len(names))
+		for i, name := range names {
+			tags[i] = name + "=" + msg.Tags[name]
+		}
+		fmt.Fprintf(&buf, "[%s] policy: %s, level: %s, value: %g, time: %s, tags: {%s}\r\n",
+			msg.Status, msg.PolicyName, msg.Level, msg.Value, a.time.Format(time.RFC3339), strings.Join(tags, ", "))
+	}
+	return buf.Bytes()
+}
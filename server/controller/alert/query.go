@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package alert
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/deepflowio/deepflow/server/querier/app/prometheus/model"
+	"github.com/deepflowio/deepflow/server/querier/app/prometheus/service"
+	queriercommon "github.com/deepflowio/deepflow/server/querier/common"
+	queriercfg "github.com/deepflowio/deepflow/server/querier/config"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse"
+)
+
+// querier runs the rules in the querier module of the same process
+type querier struct {
+	promOnce    sync.Once
+	promService *service.PrometheusService
+}
+
+func (q *querier) query(ctx context.Context, r *rule, now time.Time) ([]sample, error) {
+	if queriercfg.Cfg == nil {
+		return nil, fmt.Errorf("querier is not started")
+	}
+	orgID := strconv.Itoa(r.orgID)
+	switch r.queryType {
+	case queryTypePromQL:
+		return q.queryPromQL(ctx, r.query, orgID, now)
+	case queryTypeSQL:
+		return q.querySQL(ctx, r.db, r.query, r.metric, orgID)
+	}
+	return nil, fmt.Errorf("unknown query type %d", r.queryType)
+}
+
+func (q *querier) queryPromQL(ctx context.Context, query, orgID string, now time.Time) ([]sample, error) {
+	q.promOnce.Do(func() {
+		q.promService = service.NewPrometheusService()
+	})
+	ts := strconv.FormatInt(now.Unix(), 10)
+	args := &model.PromQueryParams{
+		Promql:    query,
+		StartTime: ts,
+		EndTime:   ts,
+		Slimit:    queriercfg.Cfg.Prometheus.SeriesLimit,
+		OrgID:     orgID,
+		Context:   ctx,
+	}
+	resp, err := q.promService.PromInstantQueryService(args, ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := resp.Data.(*model.PromQueryData)
+	if !ok || data.Result == nil {
+		return nil, nil
+	}
+	switch result := data.Result.(type) {
+	case promql.Vector:
+		samples := make([]sample, 0, len(result))
+		for _, s := range result {
+			labels := make(map[string]string, len(s.Metric))
+			for _, l := range s.Metric {
+				labels[l.Name] = l.Value
+			}
+			samples = append(samples, sample{labels: labels, value: s.V})
+		}
+		return samples, nil
+	case promql.Scalar:
+		return []sample{{labels: map[string]string{}, value: result.V}}, nil
+	}
+	return nil, fmt.Errorf("unsupported result type %s of promql %s", data.Result.Type(), query)
+}
+
+func (q *querier) querySQL(ctx context.Context, db, sql, metric, orgID string) ([]sample, error) {
+	args := &queriercommon.QuerierParams{
+		DB:        db,
+		Sql:       sql,
+		Debug:     "false",
+		QueryUUID: uuid.New().String(),
+		Context:   ctx,
+		ORGID:     orgID,
+	}
+	ckEngine := &clickhouse.CHEngine{DB: args.DB, DataSource: args.DataSource}
+	ckEngine.Init()
+	result, _, err := ckEngine.ExecuteQuery(args)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, nil
+	}
+	return sqlSamples(result.Columns, result.Values, metric)
+}
+
+// sqlSamples uses the metric column (the last numeric column by default) as the value, and the others as tags
+func sqlSamples(columns, values []interface{}, metric string) ([]sample, error) {
+	metricIndex := -1
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = fmt.Sprint(c)
+		if metric != "" && names[i] == metric {
+			metricIndex = i
+		}
+	}
+	if metric != "" && metricIndex < 0 {
+		return nil, fmt.Errorf("metric column %s not found in %v", metric, names)
+	}
+
+	samples := make([]sample, 0, len(values))
+	for _, v := range values {
+		row, ok := v.([]interface{})
+		if !ok || len(row) != len(names) {
+			continue
+		}
+		index := metricIndex
+		if index < 0 {
+			for i := len(row) - 1; i >= 0; i-- {
+				if _, ok := toFloat64(row[i]); ok {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				return nil, fmt.Errorf("no numeric column found in %v", names)
+			}
+		}
+		value, ok := toFloat64(row[index])
+		if !ok {
+			continue
+		}
+		labels := make(map[string]string, len(row)-1)
+		for i, col := range row {
+			if i != index {
+				labels[names[i]] = fmt.Sprint(col)
+			}
+		}
+		samples = append(samples, sample{labels: labels, value: value})
+	}
+	return samples, nil
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case *float64:
+		if v != nil {
+			return *v, true
+		}
+	}
+	return 0, false
+}
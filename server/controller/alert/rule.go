@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+)
+
+// Alarm policies evaluated by the controller, the query is stored in query_params:
+//
+//	query_url: /prom/api/v1/query, query_params: {"query": "<PromQL>", "for": "5m", "webhook_urls": ["..."]}
+//	query_url: /v1/query/, query_params: {"db": "flow_metrics", "sql": "<SQL>", "metric": "<column>", "for": "5m"}
+//
+// For SQL, the metric column defaults to the last numeric column, all the other columns are used as series tags.
+// Policies with other query_url are evaluated by other components and ignored here.
+const (
+	QUERY_URL_PROMQL = "/prom/api/v1/query"
+	QUERY_URL_SQL    = "/v1/query/"
+
+	POLICY_STATE_ENABLED = 1
+	POLICY_NOT_DELETED   = 0
+)
+
+// values of event_level in event.alert_event
+const (
+	EVENT_LEVEL_CRITICAL  = 1
+	EVENT_LEVEL_ERROR     = 2
+	EVENT_LEVEL_WARNING   = 3
+	EVENT_LEVEL_RECOVERED = 5
+)
+
+var eventLevelNames = map[uint32]string{
+	EVENT_LEVEL_CRITICAL:  "critical",
+	EVENT_LEVEL_ERROR:     "error",
+	EVENT_LEVEL_WARNING:   "warning",
+	EVENT_LEVEL_RECOVERED: "recovered",
+}
+
+type queryType int
+
+const (
+	queryTypePromQL queryType = iota
+	queryTypeSQL
+)
+
+type queryParams struct {
+	Query       string   `json:"query"`
+	DB          string   `json:"db"`
+	SQL         string   `json:"sql"`
+	Metric      string   `json:"metric"`
+	For         string   `json:"for"`
+	WebhookURLs []string `json:"webhook_urls"`
+}
+
+type threshold struct {
+	Op    string  `json:"OP"`
+	Value float64 `json:"VALUE"`
+	level uint32
+}
+
+func (t *threshold) match(v float64) bool {
+	switch t.Op {
+	case ">":
+		return v > t.Value
+	case ">=":
+		return v >= t.Value
+	case "<":
+		return v < t.Value
+	case "<=":
+		return v <= t.Value
+	case "==", "=":
+		return v == t.Value
+	case "!=":
+		return v != t.Value
+	}
+	return false
+}
+
+type rule struct {
+	orgID       int
+	id          int
+	name        string
+	userID      int
+	teamID      int
+	policyType  uint32
+	queryType   queryType
+	query       string
+	db          string
+	metric      string
+	forDuration time.Duration
+	frequency   time.Duration
+	recovery    bool
+	webhookURLs []string
+	thresholds  []threshold // ordered by severity
+	updatedKey  string      // changes when the policy is modified
+}
+
+// newRule returns nil without error if the policy is not evaluated by the controller
+func newRule(orgID int, p *metadbmodel.AlarmPolicy) (*rule, error) {
+	if p.State != POLICY_STATE_ENABLED || p.Deleted != POLICY_NOT_DELETED {
+		return nil, nil
+	}
+	var qType queryType
+	switch p.QueryURL {
+	case QUERY_URL_PROMQL:
+		qType = queryTypePromQL
+	case QUERY_URL_SQL:
+		qType = queryTypeSQL
+	default:
+		return nil, nil
+	}
+
+	params := queryParams{}
+	if err := json.Unmarshal([]byte(p.QueryParams), &params); err != nil {
+		return nil, fmt.Errorf("invalid query_params %s: %s", p.QueryParams, err)
+	}
+	r := &rule{
+		orgID:       orgID,
+		id:          p.ID,
+		name:        p.Name,
+		userID:      p.UserID,
+		teamID:      p.TeamID,
+		policyType:  uint32(p.AppType),
+		queryType:   qType,
+		recovery:    p.TriggerRecoveryEvent != 0,
+		webhookURLs: params.WebhookURLs,
+		updatedKey:  p.QueryParams + p.ThresholdCritical + p.ThresholdError + p.ThresholdWarning,
+	}
+	if qType == queryTypePromQL {
+		r.query = params.Query
+	} else {
+		r.query, r.db, r.metric = params.SQL, params.DB, params.Metric
+	}
+	if r.query == "" {
+		return nil, fmt.Errorf("query is empty in query_params %s", p.QueryParams)
+	}
+
+	var err error
+	if r.forDuration, err = parseDuration(params.For, 0); err != nil {
+		return nil, fmt.Errorf("invalid for %s: %s", params.For, err)
+	}
+	if r.frequency, err = parseDuration(p.MonitoringFrequency, time.Minute); err != nil {
+		return nil, fmt.Errorf("invalid monitoring_frequency %s: %s", p.MonitoringFrequency, err)
+	}
+
+	for _, t := range []struct {
+		value string
+		level uint32
+	}{
+		{p.ThresholdCritical, EVENT_LEVEL_CRITICAL},
+		{p.ThresholdError, EVENT_LEVEL_ERROR},
+		{p.ThresholdWarning, EVENT_LEVEL_WARNING},
+	} {
+		if strings.TrimSpace(t.value) == "" || t.value == "null" {
+			continue
+		}
+		th := threshold{level: t.level}
+		if err := json.Unmarshal([]byte(t.value), &th); err != nil {
+			return nil, fmt.Errorf("invalid threshold %s: %s", t.value, err)
+		}
+		if th.Op == "" {
+			continue
+		}
+		r.thresholds = append(r.thresholds, th)
+	}
+	if len(r.thresholds) == 0 {
+		return nil, fmt.Errorf("no threshold is set")
+	}
+	return r, nil
+}
+
+// level returns the most severe level whose threshold is matched, 0 if none is matched
+func (r *rule) level(v float64) uint32 {
+	for i := range r.thresholds {
+		if r.thresholds[i].match(v) {
+			return r.thresholds[i].level
+		}
+	}
+	return 0
+}
+
+func parseDuration(s string, defaultValue time.Duration) (time.Duration, error) {
+	if s == "" {
+		return defaultValue, nil
+	}
+	d, err := model.ParseDuration(s)
+	return time.Duration(d), err
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package alert
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+func (s *sample) key() string {
+	names := make([]string, 0, len(s.labels))
+	for name := range s.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(s.labels[name])
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
+
+type seriesState struct {
+	sample
+	level      uint32
+	activeAt   time.Time
+	firing     bool
+	lastSentAt time.Time
+}
+
+// alert is a state change of a series that needs to be written and notified
+type alert struct {
+	rule  *rule
+	time  time.Time
+	level uint32 // EVENT_LEVEL_RECOVERED if resolved
+	sample
+}
+
+// ruleState keeps the state of all series of a rule, series are pending until the threshold
+// has been matched for the 'for' duration, then firing until they no longer match or disappear
+type ruleState struct {
+	rule          *rule
+	lastEvaluated time.Time
+	series        map[string]*seriesState
+}
+
+func newRuleState(r *rule) *ruleState {
+	return &ruleState{rule: r, series: make(map[string]*seriesState)}
+}
+
+// update returns the alerts to be sent, a firing alert is sent when it starts firing, when its level
+// changes, or every resendInterval while it keeps firing
+func (s *ruleState) update(now time.Time, samples []sample, resendInterval time.Duration) []*alert {
+	var alerts []*alert
+	seen := make(map[string]bool, len(samples))
+	current := make(map[string]sample, len(samples))
+	for _, smp := range samples {
+		key := smp.key()
+		current[key] = smp
+		level := s.rule.level(smp.value)
+		if level == 0 {
+			continue
+		}
+		seen[key] = true
+		st, ok := s.series[key]
+		if !ok {
+			st = &seriesState{activeAt: now}
+			s.series[key] = st
+		}
+		st.sample = smp
+		levelChanged := st.level != level
+		st.level = level
+
+		if !st.firing {
+			if now.Sub(st.activeAt) < s.rule.forDuration {
+				continue
+			}
+			st.firing = true
+		} else if !levelChanged && (resendInterval <= 0 || now.Sub(st.lastSentAt) < resendInterval) {
+			continue
+		}
+		st.lastSentAt = now
+		alerts = append(alerts, &alert{rule: s.rule, time: now, level: level, sample: smp})
+	}
+
+	for key, st := range s.series {
+		if seen[key] {
+			continue
+		}
+		if st.firing && s.rule.recovery {
+			// the value is the current one if the series still exists, otherwise the last one
+			smp, ok := current[key]
+			if !ok {
+				smp = st.sample
+			}
+			alerts = append(alerts, &alert{rule: s.rule, time: now, level: EVENT_LEVEL_RECOVERED, sample: smp})
+		}
+		delete(s.series, key)
+	}
+	s.lastEvaluated = now
+	return alerts
+}
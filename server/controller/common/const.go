@@ -655,6 +655,10 @@ const (
 	PLUGIN_TYPE_WASM = 1
 	PLUGIN_TYPE_SO   = 2
 	PLUGIN_TYPE_LUA  = 3
+
+	// plugins in agent group config are referenced by name, or by name@version to pin a version
+	PLUGIN_VERSION_SEPARATOR = "@"
+	PLUGIN_VERSION_MAX_LEN   = 64
 )
 
 var (
@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+)
+
+// ParsePluginReference splits a plugin referenced in agent group config into name and version,
+// an empty version means the promoted version of the plugin
+func ParsePluginReference(reference string) (name, version string) {
+	index := strings.LastIndex(reference, PLUGIN_VERSION_SEPARATOR)
+	if index <= 0 {
+		return reference, ""
+	}
+	return reference[:index], reference[index+1:]
+}
+
+func PluginReference(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + PLUGIN_VERSION_SEPARATOR + version
+}
+
+func CheckPluginVersion(version string) error {
+	if len(version) > PLUGIN_VERSION_MAX_LEN {
+		return fmt.Errorf("plugin version (%s) is longer than %d", version, PLUGIN_VERSION_MAX_LEN)
+	}
+	if strings.Contains(version, PLUGIN_VERSION_SEPARATOR) || strings.ContainsAny(version, ", \t\n") {
+		return fmt.Errorf("plugin version (%s) contains invalid characters", version)
+	}
+	return nil
+}
+
+func PluginSHA256(content []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
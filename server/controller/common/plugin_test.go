@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"testing"
+)
+
+func TestParsePluginReference(t *testing.T) {
+	testCases := []struct {
+		reference string
+		name      string
+		version   string
+	}{
+		{"my_wasm", "my_wasm", ""},
+		{"my_wasm@v2", "my_wasm", "v2"},
+		{"my_wasm@", "my_wasm", ""},
+		{"@v2", "@v2", ""},
+		{"scope@my_wasm@1.0.0", "scope@my_wasm", "1.0.0"},
+	}
+	for _, tc := range testCases {
+		name, version := ParsePluginReference(tc.reference)
+		if name != tc.name || version != tc.version {
+			t.Errorf("ParsePluginReference(%q) = (%q, %q), want (%q, %q)", tc.reference, name, version, tc.name, tc.version)
+		}
+		if tc.version != "" && PluginReference(name, version) != tc.reference {
+			t.Errorf("PluginReference(%q, %q) = %q, want %q", name, version, PluginReference(name, version), tc.reference)
+		}
+	}
+}
+
+func TestCheckPluginVersion(t *testing.T) {
+	for _, version := range []string{"", "v1", "1.0.0-rc.1"} {
+		if err := CheckPluginVersion(version); err != nil {
+			t.Errorf("CheckPluginVersion(%q) failed: %s", version, err)
+		}
+	}
+	for _, version := range []string{"v1@v2", "v1,v2", "v 1", string(make([]byte, PLUGIN_VERSION_MAX_LEN+1))} {
+		if err := CheckPluginVersion(version); err == nil {
+			t.Errorf("CheckPluginVersion(%q) should fail", version)
+		}
+	}
+}
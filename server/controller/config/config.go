@@ -26,6 +26,7 @@ import (
 	yaml "gopkg.in/yaml.v2"
 
 	shared_common "github.com/deepflowio/deepflow/server/common"
+	alert "github.com/deepflowio/deepflow/server/controller/alert/config"
 	"github.com/deepflowio/deepflow/server/controller/common"
 	configs "github.com/deepflowio/deepflow/server/controller/config/common"
 	"github.com/deepflowio/deepflow/server/controller/db/clickhouse"
@@ -36,6 +37,8 @@ import (
 	manager "github.com/deepflowio/deepflow/server/controller/manager/config"
 	monitor "github.com/deepflowio/deepflow/server/controller/monitor/config"
 	prometheus "github.com/deepflowio/deepflow/server/controller/prometheus/config"
+	retag "github.com/deepflowio/deepflow/server/controller/retag/config"
+	rollout "github.com/deepflowio/deepflow/server/controller/rollout/config"
 	statsd "github.com/deepflowio/deepflow/server/controller/statsd/config"
 	tagrecorder "github.com/deepflowio/deepflow/server/controller/tagrecorder/config"
 	trisolaris "github.com/deepflowio/deepflow/server/controller/trisolaris/config"
@@ -104,6 +107,9 @@ type ControllerConfig struct {
 	PrometheusCfg  prometheus.Config             `yaml:"prometheus"`
 	HTTPCfg        http.Config                   `yaml:"http"`
 	SwaggerCfg     configs.Swagger               `yaml:"swagger"`
+	AlertCfg       alert.AlertConfig             `yaml:"alert"`
+	RetagCfg       retag.RetagConfig             `yaml:"retag"`
+	RolloutCfg     rollout.RolloutConfig         `yaml:"agent_upgrade_rollout"`
 }
 
 type Config struct {
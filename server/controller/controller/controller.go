@@ -152,6 +152,7 @@ func Start(ctx context.Context, configPath, serverLogFile string, shared *server
 
 	router.SetInitStageForHealthChecker("Trisolaris init")
 	// 启动trisolaris
+	cfg.TrisolarisCfg.SetResourceEventQueue(shared.ResourceEventQueue)
 	tm := trisolaris.NewTrisolarisManager(&cfg.TrisolarisCfg, metadb.DefaultDB.DB)
 	go tm.Start()
 
@@ -170,7 +171,7 @@ func Start(ctx context.Context, configPath, serverLogFile string, shared *server
 	router.SetInitStageForHealthChecker("Master function init")
 	controllerCheck := monitor.NewControllerCheck(cfg, ctx)
 	analyzerCheck := monitor.NewAnalyzerCheck(cfg, ctx)
-	go checkAndStartMasterFunctions(cfg, ctx, controllerCheck, analyzerCheck)
+	go checkAndStartMasterFunctions(cfg, ctx, controllerCheck, analyzerCheck, shared)
 	// native field
 	native_field.Refresh()
 
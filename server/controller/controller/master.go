@@ -21,6 +21,8 @@ import (
 	"os"
 	"time"
 
+	servercommon "github.com/deepflowio/deepflow/server/common"
+	"github.com/deepflowio/deepflow/server/controller/alert"
 	"github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/controller/config"
 	"github.com/deepflowio/deepflow/server/controller/db/metadb/migrator"
@@ -33,6 +35,8 @@ import (
 	"github.com/deepflowio/deepflow/server/controller/monitor/vtap"
 	"github.com/deepflowio/deepflow/server/controller/prometheus"
 	"github.com/deepflowio/deepflow/server/controller/recorder"
+	"github.com/deepflowio/deepflow/server/controller/retag"
+	"github.com/deepflowio/deepflow/server/controller/rollout"
 	"github.com/deepflowio/deepflow/server/controller/tagrecorder"
 	tagrecordercheck "github.com/deepflowio/deepflow/server/controller/tagrecorder/check"
 )
@@ -76,6 +80,7 @@ func migrateMySQL(cfg *config.ControllerConfig) {
 func checkAndStartMasterFunctions(
 	cfg *config.ControllerConfig, ctx context.Context,
 	controllerCheck *monitor.ControllerCheck, analyzerCheck *monitor.AnalyzerCheck,
+	shared *servercommon.ControllerIngesterShared,
 ) {
 
 	// 定时检查当前是否为master controller
@@ -88,6 +93,9 @@ func checkAndStartMasterFunctions(
 	// - prometheus encoder
 	// - prometheus app label layout updater
 	// - http resource refresh task manager
+	// - alert evaluator
+	// - retag runner
+	// - agent upgrade rollout runner
 
 	// 从区域控制器无需判断是否为master controller
 	if !IsMasterRegion(cfg) {
@@ -104,6 +112,9 @@ func checkAndStartMasterFunctions(
 	tagrecordercheck.GetSingleton().Init(ctx, *cfg)
 	tr := tagrecordercheck.GetSingleton()
 	deletedORGChecker := service.GetDeletedORGChecker(ctx, cfg.FPermit)
+	alertEvaluator := alert.NewEvaluator(cfg.AlertCfg, ctx, shared.AlertEventQueue)
+	retagRunner := retag.NewRunner(cfg.RetagCfg, cfg.ClickHouseCfg, cfg.Kubeconfig, ctx)
+	rolloutRunner := rollout.NewRunner(cfg.RolloutCfg, cfg.ClickHouseCfg, ctx)
 
 	httpService := http.GetSingleton()
 
@@ -169,6 +180,15 @@ func checkAndStartMasterFunctions(
 					httpService.TaskManager.Start(sCtx, cfg.FPermit, cfg.RedisCfg)
 					deletedORGChecker.Start(sCtx)
 				}
+
+				// alert evaluator
+				alertEvaluator.Start(sCtx)
+
+				// retag runner
+				retagRunner.Start(sCtx)
+
+				// agent upgrade rollout runner
+				rolloutRunner.Start(sCtx)
 			} else if thisIsMasterController {
 				thisIsMasterController = false
 				log.Infof("I am not the master controller anymore, new master controller is %s", newMasterController)
@@ -183,6 +203,9 @@ func checkAndStartMasterFunctions(
 				// stop http task mananger
 				// stop resource cleaner
 				// stop delete org checker
+				// stop alert evaluator
+				// stop retag runner
+				// stop agent upgrade rollout runner
 				if sCancel != nil {
 					sCancel()
 				}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/deepflowio/deepflow/server/controller/common"
+)
+
+// GetEndpoints returns the config of every ClickHouse node behind the k8s endpoint named cfg.Host,
+// in standalone mode only cfg itself is returned.
+func GetEndpoints(cfg ClickHouseConfig, kubeconfig string) ([]ClickHouseConfig, error) {
+	if common.IsStandaloneRunningMode() {
+		// in standalone mode, only supports one ClickHouse node
+		return []ClickHouseConfig{cfg}, nil
+	}
+
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	namespace := os.Getenv(common.NAME_SPACE_KEY)
+	endpoints, err := clientset.CoreV1().Endpoints(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints.Items) == 0 {
+		log.Warningf("no endpoints in %s", namespace)
+	}
+	findEndpoint := false
+	var result []ClickHouseConfig
+	for _, endpoint := range endpoints.Items {
+		if endpoint.Name != cfg.Host {
+			continue
+		}
+		findEndpoint = true
+		for _, subset := range endpoint.Subsets {
+			for _, address := range subset.Addresses {
+				clickHouseCfg := cfg
+				if strings.Contains(address.IP, ":") {
+					clickHouseCfg.Host = fmt.Sprintf("[%s]", address.IP)
+				} else {
+					clickHouseCfg.Host = address.IP
+				}
+				for _, port := range subset.Ports {
+					if port.Name == cfg.EndpointTcpPortName {
+						clickHouseCfg.Port = uint32(port.Port)
+						result = append(result, clickHouseCfg)
+					}
+				}
+			}
+		}
+	}
+	if !findEndpoint {
+		log.Warningf("%s endpoint not found!", cfg.Host)
+	}
+	return result, nil
+}
@@ -4,12 +4,31 @@ CREATE TABLE IF NOT EXISTS plugin (
     type                INTEGER NOT NULL COMMENT '1: wasm 2: so 3: lua',
     user_name           INTEGER NOT NULL DEFAULT 1 COMMENT '1: agent 2: server',
     image               LONGBLOB NOT NULL,
+    version             VARCHAR(64) DEFAULT '' COMMENT 'promoted version, image is a copy of it',
+    sha256              CHAR(64) DEFAULT '',
+    signature           TEXT,
     created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     UNIQUE INDEX name_index(name)
 )ENGINE=innodb AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='store plugins for sending to vtap';
 TRUNCATE TABLE plugin;
 
+CREATE TABLE IF NOT EXISTS plugin_version (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name                VARCHAR(256) NOT NULL,
+    type                INTEGER NOT NULL COMMENT '1: wasm 2: so 3: lua',
+    user_name           INTEGER NOT NULL DEFAULT 1 COMMENT '1: agent 2: server',
+    version             VARCHAR(64) NOT NULL,
+    image               LONGBLOB NOT NULL,
+    sha256              CHAR(64) DEFAULT '',
+    signature           TEXT,
+    promoted_at         DATETIME DEFAULT NULL COMMENT 'null if never promoted or rolled back',
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX name_version_index(name, version)
+)ENGINE=innodb AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='store all versions of plugins';
+TRUNCATE TABLE plugin_version;
+
 CREATE TABLE IF NOT EXISTS vtap_repo (
     id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
     name                VARCHAR(512),
@@ -1578,6 +1597,20 @@ CREATE TABLE IF NOT EXISTS agent_group_configuration (
 ) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
 TRUNCATE TABLE agent_group_configuration;
 
+CREATE TABLE IF NOT EXISTS agent_group_configuration_revision (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    lcuuid              CHAR(64) NOT NULL,
+    agent_group_lcuuid  CHAR(64) NOT NULL,
+    revision            INTEGER NOT NULL COMMENT 'increases from 1 in each agent group',
+    action              VARCHAR(16) NOT NULL COMMENT 'create, update or rollback',
+    yaml                TEXT,
+    user_id             INTEGER DEFAULT 1,
+    comment             VARCHAR(512) DEFAULT '',
+    created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX agent_group_revision_index(agent_group_lcuuid, revision)
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+TRUNCATE TABLE agent_group_configuration_revision;
+
 CREATE TABLE IF NOT EXISTS npb_tunnel (
     id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
     user_id             INTEGER DEFAULT 1,
@@ -2651,3 +2684,102 @@ CREATE TABLE IF NOT EXISTS custom_service (
     UNIQUE INDEX name_index(name)
 ) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
 TRUNCATE TABLE custom_service;
+
+CREATE TABLE IF NOT EXISTS agent_bootstrap_token (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name                VARCHAR(256) NOT NULL,
+    token_id            CHAR(16) NOT NULL,
+    secret_hash         CHAR(64) NOT NULL COMMENT 'sha256 of the token secret',
+    team_id             INTEGER DEFAULT 1,
+    user_id             INTEGER DEFAULT 1,
+    agent_group_id      CHAR(64) DEFAULT '' COMMENT 'short uuid of the agent group, empty means the default agent group',
+    expire_at           DATETIME DEFAULT NULL COMMENT 'null means never expire',
+    usage_limit         INTEGER DEFAULT 0 COMMENT '0 means unlimited',
+    usage_count         INTEGER DEFAULT 0,
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX token_id_index(token_id)
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+TRUNCATE TABLE agent_bootstrap_token;
+
+CREATE TABLE IF NOT EXISTS prometheus_recording_rule_group (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name                VARCHAR(256) NOT NULL,
+    eval_interval       INTEGER DEFAULT 0 COMMENT 'unit: s, 0 means the default evaluation interval of querier',
+    query_offset        INTEGER DEFAULT 0 COMMENT 'unit: s, evaluate the rules at this offset before now to wait for late data',
+    remote_write_url    VARCHAR(512) DEFAULT '' COMMENT 'empty means the default remote write url of querier',
+    rules               TEXT COMMENT 'json array of {RECORD, EXPR, LABELS}',
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX name_index(name)
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+TRUNCATE TABLE prometheus_recording_rule_group;
+
+CREATE TABLE IF NOT EXISTS retag_job (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name                VARCHAR(256) DEFAULT '',
+    tables              TEXT COMMENT 'json array of the tables to retag, e.g. flow_log.l4_flow_log',
+    start_time          DATETIME NOT NULL,
+    end_time            DATETIME NOT NULL,
+    dry_run             TINYINT(1) DEFAULT 0 COMMENT 'only count the rows that would change',
+    batch_interval      INTEGER DEFAULT 0 COMMENT 'unit: s, pause after each mutation, 0 means the default of controller',
+    state               INTEGER DEFAULT 0 COMMENT '0: pending, 1: running, 2: finished, 3: failed, 4: cancelled',
+    total_steps         INTEGER DEFAULT 0 COMMENT 'number of (clickhouse node, table, partition) to retag',
+    done_steps          INTEGER DEFAULT 0,
+    changed_rows        BIGINT DEFAULT 0 COMMENT 'rows changed, or would be changed in dry run',
+    error_message       TEXT,
+    started_at          DATETIME DEFAULT NULL,
+    finished_at         DATETIME DEFAULT NULL,
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+TRUNCATE TABLE retag_job;
+
+CREATE TABLE IF NOT EXISTS agent_upgrade_rollout (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name                VARCHAR(256) DEFAULT '',
+    vtap_group_lcuuid   CHAR(64) NOT NULL,
+    image_name          VARCHAR(256) NOT NULL,
+    expected_revision   VARCHAR(256) DEFAULT '',
+    canary_agents       TEXT COMMENT 'json array of the agent names upgraded in the first wave',
+    canary_percent      INTEGER DEFAULT 0 COMMENT 'percentage of the agents upgraded in the first wave, used if canary_agents is empty',
+    batch_size          INTEGER DEFAULT 0 COMMENT 'agents upgraded in each wave after the canary, 0 means all the agents left',
+    reconnect_timeout   INTEGER DEFAULT 0 COMMENT 'unit: s, 0 means the default of controller',
+    observe_time        INTEGER DEFAULT 0 COMMENT 'unit: s, 0 means the default of controller',
+    failure_policy      INTEGER DEFAULT 0 COMMENT '0: pause, 1: rollback',
+    state               INTEGER DEFAULT 0 COMMENT '0: pending, 1: running, 2: paused, 3: finished, 4: failed, 5: rolling back, 6: rolled back, 7: cancelled',
+    current_wave        INTEGER DEFAULT 0,
+    total_waves         INTEGER DEFAULT 0,
+    total_agents        INTEGER DEFAULT 0,
+    healthy_agents      INTEGER DEFAULT 0,
+    failed_agents       INTEGER DEFAULT 0,
+    error_message       TEXT,
+    started_at          DATETIME DEFAULT NULL,
+    finished_at         DATETIME DEFAULT NULL,
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+TRUNCATE TABLE agent_upgrade_rollout;
+
+CREATE TABLE IF NOT EXISTS agent_upgrade_rollout_agent (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    rollout_id          INTEGER NOT NULL,
+    vtap_lcuuid         CHAR(64) NOT NULL,
+    vtap_name           VARCHAR(256) DEFAULT '',
+    wave                INTEGER DEFAULT 0,
+    state               INTEGER DEFAULT 0 COMMENT '0: waiting, 1: upgrading, 2: healthy, 3: failed, 4: skipped, 5: rolled back',
+    previous_revision   VARCHAR(256) DEFAULT '',
+    previous_image      VARCHAR(256) DEFAULT '' COMMENT 'image to roll back to, empty if unknown',
+    baseline_exceptions BIGINT DEFAULT 0 COMMENT 'exceptions of the agent before the upgrade',
+    upgraded_at         DATETIME DEFAULT NULL,
+    reconnected_at      DATETIME DEFAULT NULL,
+    message             TEXT,
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX rollout_id_index(rollout_id)
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+TRUNCATE TABLE agent_upgrade_rollout_agent;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS agent_bootstrap_token (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name                VARCHAR(256) NOT NULL,
+    token_id            CHAR(16) NOT NULL,
+    secret_hash         CHAR(64) NOT NULL COMMENT 'sha256 of the token secret',
+    team_id             INTEGER DEFAULT 1,
+    user_id             INTEGER DEFAULT 1,
+    agent_group_id      CHAR(64) DEFAULT '' COMMENT 'short uuid of the agent group, empty means the default agent group',
+    expire_at           DATETIME DEFAULT NULL COMMENT 'null means never expire',
+    usage_limit         INTEGER DEFAULT 0 COMMENT '0 means unlimited',
+    usage_count         INTEGER DEFAULT 0,
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX token_id_index(token_id)
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+
+UPDATE db_version SET version='7.0.1.10';
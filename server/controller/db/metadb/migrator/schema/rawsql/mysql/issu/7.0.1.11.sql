@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS agent_group_configuration_revision (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    lcuuid              CHAR(64) NOT NULL,
+    agent_group_lcuuid  CHAR(64) NOT NULL,
+    revision            INTEGER NOT NULL COMMENT 'increases from 1 in each agent group',
+    action              VARCHAR(16) NOT NULL COMMENT 'create, update or rollback',
+    yaml                TEXT,
+    user_id             INTEGER DEFAULT 1,
+    comment             VARCHAR(512) DEFAULT '',
+    created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX agent_group_revision_index(agent_group_lcuuid, revision)
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+
+-- existing configurations become the first revision of their agent groups, skip the groups already
+-- having revisions in case the upgrade is executed again
+INSERT INTO agent_group_configuration_revision (lcuuid, agent_group_lcuuid, revision, action, yaml, user_id, comment)
+    SELECT UUID(), c.agent_group_lcuuid, 1, 'create', c.yaml, 1, 'initial revision' FROM agent_group_configuration c
+    WHERE NOT EXISTS (
+        SELECT 1 FROM agent_group_configuration_revision r WHERE r.agent_group_lcuuid = c.agent_group_lcuuid
+    );
+
+UPDATE db_version SET version='7.0.1.11';
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS prometheus_recording_rule_group (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name                VARCHAR(256) NOT NULL,
+    eval_interval       INTEGER DEFAULT 0 COMMENT 'unit: s, 0 means the default evaluation interval of querier',
+    query_offset        INTEGER DEFAULT 0 COMMENT 'unit: s, evaluate the rules at this offset before now to wait for late data',
+    remote_write_url    VARCHAR(512) DEFAULT '' COMMENT 'empty means the default remote write url of querier',
+    rules               TEXT COMMENT 'json array of {RECORD, EXPR, LABELS}',
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX name_index(name)
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+
+UPDATE db_version SET version='7.0.1.12';
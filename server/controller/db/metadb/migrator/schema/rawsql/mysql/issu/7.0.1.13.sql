@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS retag_job (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name                VARCHAR(256) DEFAULT '',
+    tables              TEXT COMMENT 'json array of the tables to retag, e.g. flow_log.l4_flow_log',
+    start_time          DATETIME NOT NULL,
+    end_time            DATETIME NOT NULL,
+    dry_run             TINYINT(1) DEFAULT 0 COMMENT 'only count the rows that would change',
+    batch_interval      INTEGER DEFAULT 0 COMMENT 'unit: s, pause after each mutation, 0 means the default of controller',
+    state               INTEGER DEFAULT 0 COMMENT '0: pending, 1: running, 2: finished, 3: failed, 4: cancelled',
+    total_steps         INTEGER DEFAULT 0 COMMENT 'number of (clickhouse node, table, partition) to retag',
+    done_steps          INTEGER DEFAULT 0,
+    changed_rows        BIGINT DEFAULT 0 COMMENT 'rows changed, or would be changed in dry run',
+    error_message       TEXT,
+    started_at          DATETIME DEFAULT NULL,
+    finished_at         DATETIME DEFAULT NULL,
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+
+UPDATE db_version SET version='7.0.1.13';
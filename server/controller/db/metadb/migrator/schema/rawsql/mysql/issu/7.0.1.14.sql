@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS agent_upgrade_rollout (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name                VARCHAR(256) DEFAULT '',
+    vtap_group_lcuuid   CHAR(64) NOT NULL,
+    image_name          VARCHAR(256) NOT NULL,
+    expected_revision   VARCHAR(256) DEFAULT '',
+    canary_agents       TEXT COMMENT 'json array of the agent names upgraded in the first wave',
+    canary_percent      INTEGER DEFAULT 0 COMMENT 'percentage of the agents upgraded in the first wave, used if canary_agents is empty',
+    batch_size          INTEGER DEFAULT 0 COMMENT 'agents upgraded in each wave after the canary, 0 means all the agents left',
+    reconnect_timeout   INTEGER DEFAULT 0 COMMENT 'unit: s, 0 means the default of controller',
+    observe_time        INTEGER DEFAULT 0 COMMENT 'unit: s, 0 means the default of controller',
+    failure_policy      INTEGER DEFAULT 0 COMMENT '0: pause, 1: rollback',
+    state               INTEGER DEFAULT 0 COMMENT '0: pending, 1: running, 2: paused, 3: finished, 4: failed, 5: rolling back, 6: rolled back, 7: cancelled',
+    current_wave        INTEGER DEFAULT 0,
+    total_waves         INTEGER DEFAULT 0,
+    total_agents        INTEGER DEFAULT 0,
+    healthy_agents      INTEGER DEFAULT 0,
+    failed_agents       INTEGER DEFAULT 0,
+    error_message       TEXT,
+    started_at          DATETIME DEFAULT NULL,
+    finished_at         DATETIME DEFAULT NULL,
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+
+CREATE TABLE IF NOT EXISTS agent_upgrade_rollout_agent (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    rollout_id          INTEGER NOT NULL,
+    vtap_lcuuid         CHAR(64) NOT NULL,
+    vtap_name           VARCHAR(256) DEFAULT '',
+    wave                INTEGER DEFAULT 0,
+    state               INTEGER DEFAULT 0 COMMENT '0: waiting, 1: upgrading, 2: healthy, 3: failed, 4: skipped, 5: rolled back',
+    previous_revision   VARCHAR(256) DEFAULT '',
+    previous_image      VARCHAR(256) DEFAULT '' COMMENT 'image to roll back to, empty if unknown',
+    baseline_exceptions BIGINT DEFAULT 0 COMMENT 'exceptions of the agent before the upgrade',
+    upgraded_at         DATETIME DEFAULT NULL,
+    reconnected_at      DATETIME DEFAULT NULL,
+    message             TEXT,
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX rollout_id_index(rollout_id)
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1;
+
+UPDATE db_version SET version='7.0.1.14';
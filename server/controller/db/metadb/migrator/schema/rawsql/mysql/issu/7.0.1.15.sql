@@ -0,0 +1,53 @@
+DROP PROCEDURE IF EXISTS AddColumnIfNotExists;
+
+CREATE PROCEDURE AddColumnIfNotExists(
+    IN tableName VARCHAR(255),
+    IN colName VARCHAR(255),
+    IN colType VARCHAR(255),
+    IN afterCol VARCHAR(255)
+)
+BEGIN
+    DECLARE column_count INT;
+
+    SELECT COUNT(*)
+    INTO column_count
+    FROM information_schema.columns
+    WHERE TABLE_SCHEMA = DATABASE()
+    AND TABLE_NAME = tableName
+    AND column_name = colName;
+
+    IF column_count = 0 THEN
+        SET @sql = CONCAT('ALTER TABLE ', tableName, ' ADD COLUMN ', colName, ' ', colType, ' AFTER ', afterCol);
+        PREPARE stmt FROM @sql;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END;
+
+CALL AddColumnIfNotExists('plugin', 'version', "VARCHAR(64) DEFAULT '' COMMENT 'promoted version, image is a copy of it'", 'image');
+CALL AddColumnIfNotExists('plugin', 'sha256', "CHAR(64) DEFAULT ''", 'version');
+CALL AddColumnIfNotExists('plugin', 'signature', "TEXT", 'sha256');
+
+DROP PROCEDURE AddColumnIfNotExists;
+
+CREATE TABLE IF NOT EXISTS plugin_version (
+    id                  INTEGER NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name                VARCHAR(256) NOT NULL,
+    type                INTEGER NOT NULL COMMENT '1: wasm 2: so 3: lua',
+    user_name           INTEGER NOT NULL DEFAULT 1 COMMENT '1: agent 2: server',
+    version             VARCHAR(64) NOT NULL,
+    image               LONGBLOB NOT NULL,
+    sha256              CHAR(64) DEFAULT '',
+    signature           TEXT,
+    promoted_at         DATETIME DEFAULT NULL COMMENT 'null if never promoted or rolled back',
+    created_at          DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          DATETIME NOT NULL ON UPDATE CURRENT_TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX name_version_index(name, version)
+) ENGINE=innodb DEFAULT CHARSET=utf8 AUTO_INCREMENT=1 COMMENT='store all versions of plugins';
+
+-- existing plugins become their first version
+UPDATE plugin SET version='v1' WHERE version='' OR version IS NULL;
+INSERT IGNORE INTO plugin_version (name, type, user_name, version, image, promoted_at, created_at)
+    SELECT name, type, user_name, version, image, updated_at, created_at FROM plugin;
+
+UPDATE db_version SET version='7.0.1.15';
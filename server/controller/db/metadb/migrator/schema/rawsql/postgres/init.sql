@@ -1060,6 +1060,9 @@ CREATE TABLE IF NOT EXISTS plugin (
     type                INTEGER NOT NULL,
     user_name           INTEGER NOT NULL DEFAULT 1,
     image               BYTEA NOT NULL,
+    version             VARCHAR(64) DEFAULT '',
+    sha256              CHAR(64) DEFAULT '',
+    signature           TEXT,
     created_at          TIMESTAMP NOT NULL DEFAULT NOW(),
     updated_at          TIMESTAMP NOT NULL DEFAULT NOW(),
     UNIQUE (name)
@@ -1067,6 +1070,26 @@ CREATE TABLE IF NOT EXISTS plugin (
 TRUNCATE TABLE plugin;
 COMMENT ON COLUMN plugin.type IS '1: wasm 2: so 3: lua';
 COMMENT ON COLUMN plugin.user_name IS '1: agent 2: server';
+COMMENT ON COLUMN plugin.version IS 'promoted version, image is a copy of it';
+
+CREATE TABLE IF NOT EXISTS plugin_version (
+    id                  SERIAL PRIMARY KEY,
+    name                VARCHAR(256) NOT NULL,
+    type                INTEGER NOT NULL,
+    user_name           INTEGER NOT NULL DEFAULT 1,
+    version             VARCHAR(64) NOT NULL,
+    image               BYTEA NOT NULL,
+    sha256              CHAR(64) DEFAULT '',
+    signature           TEXT,
+    promoted_at         TIMESTAMP DEFAULT NULL,
+    created_at          TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at          TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (name, version)
+);
+TRUNCATE TABLE plugin_version;
+COMMENT ON COLUMN plugin_version.type IS '1: wasm 2: so 3: lua';
+COMMENT ON COLUMN plugin_version.user_name IS '1: agent 2: server';
+COMMENT ON COLUMN plugin_version.promoted_at IS 'null if never promoted or rolled back';
 
 CREATE TABLE IF NOT EXISTS sys_configuration (
     id                  SERIAL PRIMARY KEY,
@@ -1266,6 +1289,22 @@ TRUNCATE TABLE agent_group_configuration;
 COMMENT ON COLUMN agent_group_configuration.created_at IS 'Timestamp when the record was created';
 COMMENT ON COLUMN agent_group_configuration.updated_at IS 'Timestamp when the record was last updated';
 
+CREATE TABLE IF NOT EXISTS agent_group_configuration_revision (
+    id                  SERIAL PRIMARY KEY,
+    lcuuid              VARCHAR(64) NOT NULL,
+    agent_group_lcuuid  VARCHAR(64) NOT NULL,
+    revision            INTEGER NOT NULL,
+    action              VARCHAR(16) NOT NULL,
+    yaml                TEXT,
+    user_id             INTEGER DEFAULT 1,
+    comment             VARCHAR(512) DEFAULT '',
+    created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+TRUNCATE TABLE agent_group_configuration_revision;
+CREATE UNIQUE INDEX IF NOT EXISTS agent_group_configuration_revision_group_revision_idx ON agent_group_configuration_revision(agent_group_lcuuid, revision);
+COMMENT ON COLUMN agent_group_configuration_revision.revision IS 'increases from 1 in each agent group';
+COMMENT ON COLUMN agent_group_configuration_revision.action IS 'create, update or rollback';
+
 CREATE TABLE IF NOT EXISTS controller (
     id                  SERIAL PRIMARY KEY,
     state               INTEGER,
@@ -2793,3 +2832,126 @@ CREATE UNIQUE INDEX IF NOT EXISTS custom_service_name_idx ON custom_service(name
 COMMENT ON COLUMN custom_service.type IS '0: unknown 1: IP 2: PORT';
 COMMENT ON COLUMN custom_service.domain IS 'reserved for backend';
 COMMENT ON COLUMN custom_service.resource IS 'separated by ,';
+
+CREATE TABLE IF NOT EXISTS agent_bootstrap_token (
+    id                  SERIAL PRIMARY KEY,
+    name                VARCHAR(256) NOT NULL,
+    token_id            CHAR(16) NOT NULL,
+    secret_hash         CHAR(64) NOT NULL,
+    team_id             INTEGER DEFAULT 1,
+    user_id             INTEGER DEFAULT 1,
+    agent_group_id      CHAR(64) DEFAULT '',
+    expire_at           TIMESTAMP DEFAULT NULL,
+    usage_limit         INTEGER DEFAULT 0,
+    usage_count         INTEGER DEFAULT 0,
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+TRUNCATE TABLE agent_bootstrap_token;
+CREATE UNIQUE INDEX IF NOT EXISTS agent_bootstrap_token_token_id_idx ON agent_bootstrap_token(token_id);
+COMMENT ON COLUMN agent_bootstrap_token.secret_hash IS 'sha256 of the token secret';
+COMMENT ON COLUMN agent_bootstrap_token.agent_group_id IS 'short uuid of the agent group, empty means the default agent group';
+COMMENT ON COLUMN agent_bootstrap_token.expire_at IS 'null means never expire';
+COMMENT ON COLUMN agent_bootstrap_token.usage_limit IS '0 means unlimited';
+
+CREATE TABLE IF NOT EXISTS prometheus_recording_rule_group (
+    id                  SERIAL PRIMARY KEY,
+    name                VARCHAR(256) NOT NULL,
+    eval_interval       INTEGER DEFAULT 0,
+    query_offset        INTEGER DEFAULT 0,
+    remote_write_url    VARCHAR(512) DEFAULT '',
+    rules               TEXT,
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+TRUNCATE TABLE prometheus_recording_rule_group;
+CREATE UNIQUE INDEX IF NOT EXISTS prometheus_recording_rule_group_name_idx ON prometheus_recording_rule_group(name);
+COMMENT ON COLUMN prometheus_recording_rule_group.eval_interval IS 'unit: s, 0 means the default evaluation interval of querier';
+COMMENT ON COLUMN prometheus_recording_rule_group.query_offset IS 'unit: s, evaluate the rules at this offset before now to wait for late data';
+COMMENT ON COLUMN prometheus_recording_rule_group.remote_write_url IS 'empty means the default remote write url of querier';
+COMMENT ON COLUMN prometheus_recording_rule_group.rules IS 'json array of {RECORD, EXPR, LABELS}';
+
+CREATE TABLE IF NOT EXISTS retag_job (
+    id                  SERIAL PRIMARY KEY,
+    name                VARCHAR(256) DEFAULT '',
+    tables              TEXT,
+    start_time          TIMESTAMP NOT NULL,
+    end_time            TIMESTAMP NOT NULL,
+    dry_run             SMALLINT DEFAULT 0,
+    batch_interval      INTEGER DEFAULT 0,
+    state               INTEGER DEFAULT 0,
+    total_steps         INTEGER DEFAULT 0,
+    done_steps          INTEGER DEFAULT 0,
+    changed_rows        BIGINT DEFAULT 0,
+    error_message       TEXT,
+    started_at          TIMESTAMP DEFAULT NULL,
+    finished_at         TIMESTAMP DEFAULT NULL,
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+TRUNCATE TABLE retag_job;
+COMMENT ON COLUMN retag_job.tables IS 'json array of the tables to retag, e.g. flow_log.l4_flow_log';
+COMMENT ON COLUMN retag_job.dry_run IS 'only count the rows that would change';
+COMMENT ON COLUMN retag_job.batch_interval IS 'unit: s, pause after each mutation, 0 means the default of controller';
+COMMENT ON COLUMN retag_job.state IS '0: pending, 1: running, 2: finished, 3: failed, 4: cancelled';
+COMMENT ON COLUMN retag_job.total_steps IS 'number of (clickhouse node, table, partition) to retag';
+COMMENT ON COLUMN retag_job.changed_rows IS 'rows changed, or would be changed in dry run';
+
+CREATE TABLE IF NOT EXISTS agent_upgrade_rollout (
+    id                  SERIAL PRIMARY KEY,
+    name                VARCHAR(256) DEFAULT '',
+    vtap_group_lcuuid   CHAR(64) NOT NULL,
+    image_name          VARCHAR(256) NOT NULL,
+    expected_revision   VARCHAR(256) DEFAULT '',
+    canary_agents       TEXT,
+    canary_percent      INTEGER DEFAULT 0,
+    batch_size          INTEGER DEFAULT 0,
+    reconnect_timeout   INTEGER DEFAULT 0,
+    observe_time        INTEGER DEFAULT 0,
+    failure_policy      INTEGER DEFAULT 0,
+    state               INTEGER DEFAULT 0,
+    current_wave        INTEGER DEFAULT 0,
+    total_waves         INTEGER DEFAULT 0,
+    total_agents        INTEGER DEFAULT 0,
+    healthy_agents      INTEGER DEFAULT 0,
+    failed_agents       INTEGER DEFAULT 0,
+    error_message       TEXT,
+    started_at          TIMESTAMP DEFAULT NULL,
+    finished_at         TIMESTAMP DEFAULT NULL,
+    lcuuid              CHAR(64) NOT NULL,
+    created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+TRUNCATE TABLE agent_upgrade_rollout;
+COMMENT ON COLUMN agent_upgrade_rollout.canary_agents IS 'json array of the agent names upgraded in the first wave';
+COMMENT ON COLUMN agent_upgrade_rollout.canary_percent IS 'percentage of the agents upgraded in the first wave, used if canary_agents is empty';
+COMMENT ON COLUMN agent_upgrade_rollout.batch_size IS 'agents upgraded in each wave after the canary, 0 means all the agents left';
+COMMENT ON COLUMN agent_upgrade_rollout.reconnect_timeout IS 'unit: s, 0 means the default of controller';
+COMMENT ON COLUMN agent_upgrade_rollout.observe_time IS 'unit: s, 0 means the default of controller';
+COMMENT ON COLUMN agent_upgrade_rollout.failure_policy IS '0: pause, 1: rollback';
+COMMENT ON COLUMN agent_upgrade_rollout.state IS '0: pending, 1: running, 2: paused, 3: finished, 4: failed, 5: rolling back, 6: rolled back, 7: cancelled';
+
+CREATE TABLE IF NOT EXISTS agent_upgrade_rollout_agent (
+    id                  SERIAL PRIMARY KEY,
+    rollout_id          INTEGER NOT NULL,
+    vtap_lcuuid         CHAR(64) NOT NULL,
+    vtap_name           VARCHAR(256) DEFAULT '',
+    wave                INTEGER DEFAULT 0,
+    state               INTEGER DEFAULT 0,
+    previous_revision   VARCHAR(256) DEFAULT '',
+    previous_image      VARCHAR(256) DEFAULT '',
+    baseline_exceptions BIGINT DEFAULT 0,
+    upgraded_at         TIMESTAMP DEFAULT NULL,
+    reconnected_at      TIMESTAMP DEFAULT NULL,
+    message             TEXT,
+    created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+TRUNCATE TABLE agent_upgrade_rollout_agent;
+CREATE INDEX agent_upgrade_rollout_agent_rollout_id_index ON agent_upgrade_rollout_agent (rollout_id);
+COMMENT ON COLUMN agent_upgrade_rollout_agent.state IS '0: waiting, 1: upgrading, 2: healthy, 3: failed, 4: skipped, 5: rolled back';
+COMMENT ON COLUMN agent_upgrade_rollout_agent.previous_image IS 'image to roll back to, empty if unknown';
+COMMENT ON COLUMN agent_upgrade_rollout_agent.baseline_exceptions IS 'exceptions of the agent before the upgrade';
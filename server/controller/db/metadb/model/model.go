@@ -238,6 +238,28 @@ func (VTapGroup) TableName() string {
 	return "vtap_group"
 }
 
+// AgentBootstrapToken admits the agents presenting it on Sync, the token is '<token_id>.<secret>',
+// only the sha256 of the secret is stored
+type AgentBootstrapToken struct {
+	ID           int        `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
+	Name         string     `gorm:"column:name;type:varchar(256);not null" json:"NAME"`
+	TokenID      string     `gorm:"unique;column:token_id;type:char(16);not null" json:"TOKEN_ID"`
+	SecretHash   string     `gorm:"column:secret_hash;type:char(64);not null" json:"-"`
+	TeamID       int        `gorm:"column:team_id;type:int;default:1" json:"TEAM_ID"`
+	UserID       int        `gorm:"column:user_id;type:int;default:1" json:"USER_ID"`
+	AgentGroupID string     `gorm:"column:agent_group_id;type:char(64);default:''" json:"AGENT_GROUP_ID"` // short uuid, empty means the default agent group
+	ExpireAt     *time.Time `gorm:"column:expire_at;type:datetime;default:null" json:"EXPIRE_AT"`         // null means never expire
+	UsageLimit   int        `gorm:"column:usage_limit;type:int;default:0" json:"USAGE_LIMIT"`             // 0 means unlimited
+	UsageCount   int        `gorm:"column:usage_count;type:int;default:0" json:"USAGE_COUNT"`
+	Lcuuid       string     `gorm:"column:lcuuid;type:char(64);not null" json:"LCUUID"`
+	CreatedAt    time.Time  `gorm:"column:created_at;type:datetime;default:CURRENT_TIMESTAMP" json:"CREATED_AT"`
+	UpdatedAt    time.Time  `gorm:"column:updated_at;type:datetime;not null;default:CURRENT_TIMESTAMP" json:"UPDATED_AT"`
+}
+
+func (AgentBootstrapToken) TableName() string {
+	return "agent_bootstrap_token"
+}
+
 type LicenseFuncLog struct {
 	ID                  int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
 	TeamID              int       `gorm:"column:team_id;type:int;default:1" json:"TEAM_ID"`
@@ -496,6 +518,9 @@ type Plugin struct {
 	Type      int             `gorm:"column:type;type:int" json:"TYPE"`                // 1: wasm 2: so 3: lua
 	UserName  int             `gorm:"column:user_name;type:int;default:1" json:"USER"` // 1: agent 2: server
 	Image     compressedBytes `gorm:"column:image;type:logblob;not null" json:"IMAGE"`
+	Version   string          `gorm:"column:version;type:varchar(64);default:''" json:"VERSION"` // promoted version, image is a copy of it
+	SHA256    string          `gorm:"column:sha256;type:char(64);default:''" json:"SHA256"`
+	Signature string          `gorm:"column:signature;type:text" json:"SIGNATURE"`
 	CreatedAt time.Time       `gorm:"column:created_at;type:timestamp;not null;default:CURRENT_TIMESTAMP" json:"CREATED_AT"`
 	UpdatedAt time.Time       `gorm:"column:updated_at;type:timestamp;not null;default:CURRENT_TIMESTAMP" json:"UPDATED_AT"`
 }
@@ -504,6 +529,118 @@ func (Plugin) TableName() string {
 	return "plugin"
 }
 
+// PluginVersion keeps every uploaded version of a plugin, agents get a pinned version by name@version
+type PluginVersion struct {
+	ID         int             `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
+	Name       string          `gorm:"column:name;type:varchar(256);not null" json:"NAME"`
+	Type       int             `gorm:"column:type;type:int" json:"TYPE"`                // 1: wasm 2: so 3: lua
+	UserName   int             `gorm:"column:user_name;type:int;default:1" json:"USER"` // 1: agent 2: server
+	Version    string          `gorm:"column:version;type:varchar(64);not null" json:"VERSION"`
+	Image      compressedBytes `gorm:"column:image;type:logblob;not null" json:"IMAGE"`
+	SHA256     string          `gorm:"column:sha256;type:char(64);default:''" json:"SHA256"`
+	Signature  string          `gorm:"column:signature;type:text" json:"SIGNATURE"`
+	PromotedAt *time.Time      `gorm:"column:promoted_at;type:datetime;default:null" json:"PROMOTED_AT"` // null if never promoted or rolled back
+	CreatedAt  time.Time       `gorm:"column:created_at;type:timestamp;not null;default:CURRENT_TIMESTAMP" json:"CREATED_AT"`
+	UpdatedAt  time.Time       `gorm:"column:updated_at;type:timestamp;not null;default:CURRENT_TIMESTAMP" json:"UPDATED_AT"`
+}
+
+func (PluginVersion) TableName() string {
+	return "plugin_version"
+}
+
+// PrometheusRecordingRuleGroup is evaluated by the leader querier, results are written back by remote write
+type PrometheusRecordingRuleGroup struct {
+	ID             int       `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
+	Name           string    `gorm:"unique;column:name;type:varchar(256);not null" json:"NAME"`
+	EvalInterval   int       `gorm:"column:eval_interval;type:int;default:0" json:"EVAL_INTERVAL"` // unit: s, 0 means the default evaluation interval of querier
+	QueryOffset    int       `gorm:"column:query_offset;type:int;default:0" json:"QUERY_OFFSET"`   // unit: s
+	RemoteWriteURL string    `gorm:"column:remote_write_url;type:varchar(512);default:''" json:"REMOTE_WRITE_URL"`
+	Rules          string    `gorm:"column:rules;type:text" json:"RULES"` // json array of {RECORD, EXPR, LABELS}
+	Lcuuid         string    `gorm:"column:lcuuid;type:char(64);not null" json:"LCUUID"`
+	CreatedAt      time.Time `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP" json:"CREATED_AT"`
+	UpdatedAt      time.Time `gorm:"column:updated_at;type:datetime;not null;default:CURRENT_TIMESTAMP" json:"UPDATED_AT"`
+}
+
+func (PrometheusRecordingRuleGroup) TableName() string {
+	return "prometheus_recording_rule_group"
+}
+
+// RetagJob rewrites the universal tags of the stored data in [StartTime, EndTime] with the current platform data
+type RetagJob struct {
+	ID            int        `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
+	Name          string     `gorm:"column:name;type:varchar(256);default:''" json:"NAME"`
+	Tables        string     `gorm:"column:tables;type:text" json:"TABLES"` // json array of the tables, e.g. flow_log.l4_flow_log
+	StartTime     time.Time  `gorm:"column:start_time;type:datetime;not null" json:"START_TIME"`
+	EndTime       time.Time  `gorm:"column:end_time;type:datetime;not null" json:"END_TIME"`
+	DryRun        int        `gorm:"column:dry_run;type:tinyint(1);default:0" json:"DRY_RUN"`
+	BatchInterval int        `gorm:"column:batch_interval;type:int;default:0" json:"BATCH_INTERVAL"` // unit: s, 0 means the default of controller
+	State         int        `gorm:"column:state;type:int;default:0" json:"STATE"`                   // 0: pending, 1: running, 2: finished, 3: failed, 4: cancelled
+	TotalSteps    int        `gorm:"column:total_steps;type:int;default:0" json:"TOTAL_STEPS"`
+	DoneSteps     int        `gorm:"column:done_steps;type:int;default:0" json:"DONE_STEPS"`
+	ChangedRows   int64      `gorm:"column:changed_rows;type:bigint;default:0" json:"CHANGED_ROWS"`
+	ErrorMessage  string     `gorm:"column:error_message;type:text" json:"ERROR_MESSAGE"`
+	StartedAt     *time.Time `gorm:"column:started_at;type:datetime;default:null" json:"STARTED_AT"`
+	FinishedAt    *time.Time `gorm:"column:finished_at;type:datetime;default:null" json:"FINISHED_AT"`
+	Lcuuid        string     `gorm:"column:lcuuid;type:char(64);not null" json:"LCUUID"`
+	CreatedAt     time.Time  `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP" json:"CREATED_AT"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at;type:datetime;not null;default:CURRENT_TIMESTAMP" json:"UPDATED_AT"`
+}
+
+func (RetagJob) TableName() string {
+	return "retag_job"
+}
+
+type AgentUpgradeRollout struct {
+	ID               int        `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
+	Name             string     `gorm:"column:name;type:varchar(256);default:''" json:"NAME"`
+	VTapGroupLcuuid  string     `gorm:"column:vtap_group_lcuuid;type:char(64);not null" json:"VTAP_GROUP_LCUUID"`
+	ImageName        string     `gorm:"column:image_name;type:varchar(256);not null" json:"IMAGE_NAME"`
+	ExpectedRevision string     `gorm:"column:expected_revision;type:varchar(256);default:''" json:"EXPECTED_REVISION"`
+	CanaryAgents     string     `gorm:"column:canary_agents;type:text" json:"CANARY_AGENTS"`                  // json array of the agent names upgraded in the first wave
+	CanaryPercent    int        `gorm:"column:canary_percent;type:int;default:0" json:"CANARY_PERCENT"`       // used if canary_agents is empty
+	BatchSize        int        `gorm:"column:batch_size;type:int;default:0" json:"BATCH_SIZE"`               // 0 means all the agents left in one wave
+	ReconnectTimeout int        `gorm:"column:reconnect_timeout;type:int;default:0" json:"RECONNECT_TIMEOUT"` // unit: s, 0 means the default of controller
+	ObserveTime      int        `gorm:"column:observe_time;type:int;default:0" json:"OBSERVE_TIME"`           // unit: s, 0 means the default of controller
+	FailurePolicy    int        `gorm:"column:failure_policy;type:int;default:0" json:"FAILURE_POLICY"`       // 0: pause, 1: rollback
+	State            int        `gorm:"column:state;type:int;default:0" json:"STATE"`                         // 0: pending, 1: running, 2: paused, 3: finished, 4: failed, 5: rolling back, 6: rolled back, 7: cancelled
+	CurrentWave      int        `gorm:"column:current_wave;type:int;default:0" json:"CURRENT_WAVE"`
+	TotalWaves       int        `gorm:"column:total_waves;type:int;default:0" json:"TOTAL_WAVES"`
+	TotalAgents      int        `gorm:"column:total_agents;type:int;default:0" json:"TOTAL_AGENTS"`
+	HealthyAgents    int        `gorm:"column:healthy_agents;type:int;default:0" json:"HEALTHY_AGENTS"`
+	FailedAgents     int        `gorm:"column:failed_agents;type:int;default:0" json:"FAILED_AGENTS"`
+	ErrorMessage     string     `gorm:"column:error_message;type:text" json:"ERROR_MESSAGE"`
+	StartedAt        *time.Time `gorm:"column:started_at;type:datetime;default:null" json:"STARTED_AT"`
+	FinishedAt       *time.Time `gorm:"column:finished_at;type:datetime;default:null" json:"FINISHED_AT"`
+	Lcuuid           string     `gorm:"column:lcuuid;type:char(64);not null" json:"LCUUID"`
+	CreatedAt        time.Time  `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP" json:"CREATED_AT"`
+	UpdatedAt        time.Time  `gorm:"column:updated_at;type:datetime;not null;default:CURRENT_TIMESTAMP" json:"UPDATED_AT"`
+}
+
+func (AgentUpgradeRollout) TableName() string {
+	return "agent_upgrade_rollout"
+}
+
+type AgentUpgradeRolloutAgent struct {
+	ID                 int        `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
+	RolloutID          int        `gorm:"column:rollout_id;type:int;not null" json:"ROLLOUT_ID"`
+	VTapLcuuid         string     `gorm:"column:vtap_lcuuid;type:char(64);not null" json:"VTAP_LCUUID"`
+	VTapName           string     `gorm:"column:vtap_name;type:varchar(256);default:''" json:"VTAP_NAME"`
+	Wave               int        `gorm:"column:wave;type:int;default:0" json:"WAVE"`
+	State              int        `gorm:"column:state;type:int;default:0" json:"STATE"` // 0: waiting, 1: upgrading, 2: healthy, 3: failed, 4: skipped, 5: rolled back
+	PreviousRevision   string     `gorm:"column:previous_revision;type:varchar(256);default:''" json:"PREVIOUS_REVISION"`
+	PreviousImage      string     `gorm:"column:previous_image;type:varchar(256);default:''" json:"PREVIOUS_IMAGE"` // image to roll back to, empty if unknown
+	BaselineExceptions int64      `gorm:"column:baseline_exceptions;type:bigint;default:0" json:"BASELINE_EXCEPTIONS"`
+	UpgradedAt         *time.Time `gorm:"column:upgraded_at;type:datetime;default:null" json:"UPGRADED_AT"`
+	ReconnectedAt      *time.Time `gorm:"column:reconnected_at;type:datetime;default:null" json:"RECONNECTED_AT"`
+	Message            string     `gorm:"column:message;type:text" json:"MESSAGE"`
+	CreatedAt          time.Time  `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP" json:"CREATED_AT"`
+	UpdatedAt          time.Time  `gorm:"column:updated_at;type:datetime;not null;default:CURRENT_TIMESTAMP" json:"UPDATED_AT"`
+}
+
+func (AgentUpgradeRolloutAgent) TableName() string {
+	return "agent_upgrade_rollout_agent"
+}
+
 type MailServer struct {
 	ID           int    `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
 	Status       int    `gorm:"column:status;type:int;not null" json:"STATUS"`
@@ -523,10 +660,23 @@ func (MailServer) TableName() string {
 }
 
 type AlarmPolicy struct {
-	ID     int    `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
-	Name   string `gorm:"column:name;type:char(128)" json:"NAME"`
-	UserID int    `gorm:"column:user_id;type:int" json:"USER_ID"`
-	TeamID int    `gorm:"column:team_id;type:int;default:1" json:"TEAM_ID"`
+	ID                   int    `gorm:"primaryKey;column:id;type:int;not null" json:"ID"`
+	Name                 string `gorm:"column:name;type:char(128)" json:"NAME"`
+	UserID               int    `gorm:"column:user_id;type:int" json:"USER_ID"`
+	TeamID               int    `gorm:"column:team_id;type:int;default:1" json:"TEAM_ID"`
+	Level                int    `gorm:"column:level;type:tinyint(1);not null" json:"LEVEL"`            // 0.low 1.middle 2.high
+	State                int    `gorm:"column:state;type:tinyint(1);default:1" json:"STATE"`           // 0.disabled 1.enabled
+	AppType              int    `gorm:"column:app_type;type:tinyint(1);not null" json:"APP_TYPE"`      // 1.system 2.360view
+	SubType              int    `gorm:"column:sub_type;type:tinyint(1);default:1" json:"SUB_TYPE"`     // 1.metric
+	Deleted              int    `gorm:"column:deleted;type:tinyint(1);default:0" json:"DELETED"`       // 0.not deleted 1.deleted
+	ThresholdCritical    string `gorm:"column:threshold_critical;type:text" json:"THRESHOLD_CRITICAL"` // {"OP":">=","VALUE":70}
+	ThresholdError       string `gorm:"column:threshold_error;type:text" json:"THRESHOLD_ERROR"`
+	ThresholdWarning     string `gorm:"column:threshold_warning;type:text" json:"THRESHOLD_WARNING"`
+	QueryURL             string `gorm:"column:query_url;type:text" json:"QUERY_URL"`
+	QueryParams          string `gorm:"column:query_params;type:text" json:"QUERY_PARAMS"`
+	MonitoringFrequency  string `gorm:"column:monitoring_frequency;type:char(64);default:1m" json:"MONITORING_FREQUENCY"`
+	TriggerRecoveryEvent int    `gorm:"column:trigger_recovery_event;type:int;default:1" json:"TRIGGER_RECOVERY_EVENT"`
+	Lcuuid               string `gorm:"column:lcuuid;type:char(64)" json:"LCUUID"`
 }
 
 func (AlarmPolicy) TableName() string {
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/deepflowio/deepflow/server/controller/config"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb/common"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/http/service"
+	"github.com/deepflowio/deepflow/server/controller/model"
+)
+
+type AgentAdmission struct {
+	cfg *config.ControllerConfig
+}
+
+func NewAgentAdmission(cfg *config.ControllerConfig) *AgentAdmission {
+	return &AgentAdmission{cfg: cfg}
+}
+
+func (a *AgentAdmission) RegisterTo(e *gin.Engine) {
+	e.GET("/v1/agent-bootstrap-tokens/", a.getTokens())
+	e.POST("/v1/agent-bootstrap-tokens/", a.createToken())
+	e.DELETE("/v1/agent-bootstrap-tokens/:lcuuid/", a.deleteToken())
+
+	e.GET("/v1/pending-agents/", a.getPendingAgents())
+	e.POST("/v1/pending-agents/:lcuuid/approve/", a.approvePendingAgent())
+	e.POST("/v1/pending-agents/:lcuuid/reject/", a.rejectPendingAgent())
+}
+
+func (a *AgentAdmission) getTokens() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		args := make(map[string]interface{})
+		for _, key := range []string{"name", "token_id", "team_id", "agent_group_id"} {
+			if value, ok := c.GetQuery(key); ok {
+				args[key] = value
+			}
+		}
+		data, err := service.NewAgentAdmission(httpcommon.GetUserInfo(c), a.cfg).GetTokens(args)
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	}
+}
+
+func (a *AgentAdmission) createToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tokenCreate model.AgentBootstrapTokenCreate
+		if err := c.ShouldBindBodyWith(&tokenCreate, binding.JSON); err != nil {
+			response.JSON(c, response.SetOptStatus(httpcommon.INVALID_PARAMETERS), response.SetError(err))
+			return
+		}
+		if tokenCreate.TeamID == 0 {
+			tokenCreate.TeamID = common.DEFAULT_TEAM_ID
+		}
+		data, err := service.NewAgentAdmission(httpcommon.GetUserInfo(c), a.cfg).CreateToken(tokenCreate)
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	}
+}
+
+func (a *AgentAdmission) deleteToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, err := service.NewAgentAdmission(httpcommon.GetUserInfo(c), a.cfg).DeleteToken(c.Param("lcuuid"))
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	}
+}
+
+func (a *AgentAdmission) getPendingAgents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, err := service.NewAgentAdmission(httpcommon.GetUserInfo(c), a.cfg).GetPendingAgents()
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	}
+}
+
+func (a *AgentAdmission) approvePendingAgent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, err := service.NewAgentAdmission(httpcommon.GetUserInfo(c), a.cfg).ApprovePendingAgent(c.Param("lcuuid"))
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	}
+}
+
+func (a *AgentAdmission) rejectPendingAgent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, err := service.NewAgentAdmission(httpcommon.GetUserInfo(c), a.cfg).RejectPendingAgent(c.Param("lcuuid"))
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	}
+}
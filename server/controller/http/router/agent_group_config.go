@@ -18,14 +18,17 @@ package router
 
 import (
 	"io"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 
 	"github.com/deepflowio/deepflow/server/agent_config"
 	"github.com/deepflowio/deepflow/server/controller/config"
 	"github.com/deepflowio/deepflow/server/controller/http/common"
 	"github.com/deepflowio/deepflow/server/controller/http/common/response"
 	"github.com/deepflowio/deepflow/server/controller/http/service"
+	"github.com/deepflowio/deepflow/server/controller/model"
 )
 
 type AgentGroupConfig struct {
@@ -54,6 +57,11 @@ func (cgc *AgentGroupConfig) RegisterTo(e *gin.Engine) {
 
 	e.DELETE("/v1/agent-group-configuration/:group-lcuuid", deleteAgentGroupConfig(cgc.cfg))
 
+	e.GET("/v1/agent-group-configuration/:group-lcuuid/revisions", getAgentGroupConfigRevisions(cgc.cfg))
+	e.GET("/v1/agent-group-configuration/:group-lcuuid/revisions/:revision", getAgentGroupConfigRevision(cgc.cfg))
+	e.GET("/v1/agent-group-configuration/:group-lcuuid/revisions/:revision/diff", diffAgentGroupConfigRevision(cgc.cfg))
+	e.POST("/v1/agent-group-configuration/:group-lcuuid/rollback", rollbackAgentGroupConfig(cgc.cfg))
+
 }
 
 func getYAMLAgentGroupConfigTmpl(c *gin.Context) {
@@ -91,7 +99,7 @@ func postJsonAgentGroupConfig(cfg *config.ControllerConfig) gin.HandlerFunc {
 			return
 		}
 		groupLcuuid := c.Param("group-lcuuid")
-		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).CreateAgentGroupConfig(groupLcuuid, postData, service.DataTypeJSON)
+		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).CreateAgentGroupConfig(groupLcuuid, postData, service.DataTypeJSON, c.Query("comment"))
 		response.JSON(c, response.SetData(data), response.SetError(err))
 	}
 }
@@ -104,7 +112,7 @@ func putJsonAgentGroupConfig(cfg *config.ControllerConfig) gin.HandlerFunc {
 			return
 		}
 		groupLcuuid := c.Param("group-lcuuid")
-		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).UpdateAgentGroupConfig(groupLcuuid, postData, service.DataTypeJSON)
+		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).UpdateAgentGroupConfig(groupLcuuid, postData, service.DataTypeJSON, c.Query("comment"))
 		response.JSON(c, response.SetData(data), response.SetError(err))
 	}
 }
@@ -132,7 +140,7 @@ func postYAMLAgentGroupConfig(cfg *config.ControllerConfig) gin.HandlerFunc {
 			return
 		}
 		groupLcuuid := c.Param("group-lcuuid")
-		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).CreateAgentGroupConfig(groupLcuuid, bytes, service.DataTypeYAML)
+		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).CreateAgentGroupConfig(groupLcuuid, bytes, service.DataTypeYAML, c.Query("comment"))
 		response.JSON(c, response.SetData(string(data)), response.SetError(err)) // TODO 不需要转换类型
 	}
 }
@@ -145,7 +153,7 @@ func putYAMLAgentGroupConfig(cfg *config.ControllerConfig) gin.HandlerFunc {
 			return
 		}
 		groupLcuuid := c.Param("group-lcuuid")
-		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).UpdateAgentGroupConfig(groupLcuuid, bytes, service.DataTypeYAML)
+		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).UpdateAgentGroupConfig(groupLcuuid, bytes, service.DataTypeYAML, c.Query("comment"))
 		response.JSON(c, response.SetData(string(data)), response.SetError(err)) // TODO 不需要转换类型
 	}
 }
@@ -157,3 +165,50 @@ func deleteAgentGroupConfig(cfg *config.ControllerConfig) gin.HandlerFunc {
 		response.JSON(c, response.SetError(err))
 	}
 }
+
+func getAgentGroupConfigRevisions(cfg *config.ControllerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groupLcuuid := c.Param("group-lcuuid")
+		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).GetAgentGroupConfigRevisions(groupLcuuid)
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	}
+}
+
+func getAgentGroupConfigRevision(cfg *config.ControllerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revision, err := strconv.Atoi(c.Param("revision"))
+		if err != nil {
+			response.JSON(c, response.SetOptStatus(common.INVALID_PARAMETERS), response.SetError(err))
+			return
+		}
+		groupLcuuid := c.Param("group-lcuuid")
+		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).GetAgentGroupConfigRevision(groupLcuuid, revision)
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	}
+}
+
+func diffAgentGroupConfigRevision(cfg *config.ControllerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revision, err := strconv.Atoi(c.Param("revision"))
+		if err != nil {
+			response.JSON(c, response.SetOptStatus(common.INVALID_PARAMETERS), response.SetError(err))
+			return
+		}
+		groupLcuuid := c.Param("group-lcuuid")
+		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).DiffAgentGroupConfigRevision(groupLcuuid, revision, c.Query("base"))
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	}
+}
+
+func rollbackAgentGroupConfig(cfg *config.ControllerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rollback model.AgentGroupConfigRollback
+		if err := c.ShouldBindBodyWith(&rollback, binding.JSON); err != nil {
+			response.JSON(c, response.SetOptStatus(common.INVALID_PARAMETERS), response.SetError(err))
+			return
+		}
+		groupLcuuid := c.Param("group-lcuuid")
+		data, err := service.NewAgentGroupConfig(common.GetUserInfo(c), cfg).RollbackAgentGroupConfig(groupLcuuid, rollback.Revision, rollback.Comment)
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	}
+}
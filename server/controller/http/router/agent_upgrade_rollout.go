@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/http/service"
+	"github.com/deepflowio/deepflow/server/controller/model"
+	rolloutcommon "github.com/deepflowio/deepflow/server/controller/rollout/common"
+)
+
+type AgentUpgradeRollout struct{}
+
+func NewAgentUpgradeRollout() *AgentUpgradeRollout {
+	return new(AgentUpgradeRollout)
+}
+
+func (r *AgentUpgradeRollout) RegisterTo(e *gin.Engine) {
+	e.GET("/v1/agent-upgrade-rollouts/", getAgentUpgradeRollouts)
+	e.GET("/v1/agent-upgrade-rollouts/:lcuuid/", getAgentUpgradeRollout)
+	e.POST("/v1/agent-upgrade-rollouts/", createAgentUpgradeRollout)
+	e.POST("/v1/agent-upgrade-rollouts/:lcuuid/pause/", updateAgentUpgradeRollout(service.PauseAgentUpgradeRollout))
+	e.POST("/v1/agent-upgrade-rollouts/:lcuuid/resume/", updateAgentUpgradeRollout(service.ResumeAgentUpgradeRollout))
+	e.POST("/v1/agent-upgrade-rollouts/:lcuuid/cancel/", updateAgentUpgradeRollout(service.CancelAgentUpgradeRollout))
+	e.POST("/v1/agent-upgrade-rollouts/:lcuuid/rollback/", updateAgentUpgradeRollout(service.RollbackAgentUpgradeRollout))
+	e.DELETE("/v1/agent-upgrade-rollouts/:lcuuid/", deleteAgentUpgradeRollout)
+}
+
+func getAgentUpgradeRollouts(c *gin.Context) {
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	args := make(map[string]interface{})
+	if value, ok := c.GetQuery("state"); ok {
+		for state, name := range rolloutcommon.RolloutStateName {
+			if name == value {
+				args["state"] = state
+			}
+		}
+		if _, ok := args["state"]; !ok {
+			response.JSON(c, response.SetError(response.ServiceError(httpcommon.INVALID_PARAMETERS, "invalid state: "+value)))
+			return
+		}
+	}
+	if value, ok := c.GetQuery("agent_group_id"); ok {
+		var vtapGroup metadbmodel.VTapGroup
+		if err := dbInfo.Where("short_uuid = ?", value).First(&vtapGroup).Error; err != nil {
+			response.JSON(c, response.SetError(response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, "agent group not found: "+value)))
+			return
+		}
+		args["vtap_group_lcuuid"] = vtapGroup.Lcuuid
+	}
+	data, err := service.GetAgentUpgradeRollouts(dbInfo, args)
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
+
+func getAgentUpgradeRollout(c *gin.Context) {
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.GetAgentUpgradeRollout(dbInfo, c.Param("lcuuid"))
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
+
+func createAgentUpgradeRollout(c *gin.Context) {
+	var rolloutCreate model.AgentUpgradeRolloutCreate
+	if err := c.ShouldBindBodyWith(&rolloutCreate, binding.JSON); err != nil {
+		response.JSON(c, response.SetOptStatus(httpcommon.INVALID_PARAMETERS), response.SetError(err))
+		return
+	}
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.CreateAgentUpgradeRollout(dbInfo, rolloutCreate)
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
+
+func updateAgentUpgradeRollout(update func(*metadb.DB, string) (*model.AgentUpgradeRollout, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+		if err != nil {
+			response.JSON(c, response.SetError(err))
+			return
+		}
+		data, err := update(dbInfo, c.Param("lcuuid"))
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	}
+}
+
+func deleteAgentUpgradeRollout(c *gin.Context) {
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.DeleteAgentUpgradeRollout(dbInfo, c.Param("lcuuid"))
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
@@ -22,6 +22,7 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 
 	"github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/controller/db/metadb"
@@ -29,6 +30,7 @@ import (
 	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
 	"github.com/deepflowio/deepflow/server/controller/http/common/response"
 	"github.com/deepflowio/deepflow/server/controller/http/service"
+	"github.com/deepflowio/deepflow/server/controller/model"
 	"github.com/deepflowio/deepflow/server/controller/trisolaris/refresh"
 )
 
@@ -42,6 +44,9 @@ func (p *Plugin) RegisterTo(e *gin.Engine) {
 	e.GET("/v1/plugin/", getPlugin)
 	e.POST("/v1/plugin/", createPlugin)
 	e.DELETE("/v1/plugin/:name/", deletePlugin)
+	e.GET("/v1/plugin/:name/versions/", getPluginVersions)
+	e.POST("/v1/plugin/:name/promote/", promotePlugin)
+	e.POST("/v1/plugin/:name/rollback/", rollbackPlugin)
 }
 
 func getPlugin(c *gin.Context) {
@@ -66,10 +71,20 @@ func createPlugin(c *gin.Context) {
 		response.JSON(c, response.SetError(err))
 		return
 	}
+	promote := true
+	if c.PostForm("PROMOTE") != "" {
+		promote, err = strconv.ParseBool(c.PostForm("PROMOTE"))
+		if err != nil {
+			response.JSON(c, response.SetError(err))
+			return
+		}
+	}
 	plugin := &metadbmodel.Plugin{
-		Name:     c.PostForm("NAME"),
-		Type:     t,
-		UserName: u,
+		Name:      c.PostForm("NAME"),
+		Type:      t,
+		UserName:  u,
+		Version:   c.PostForm("VERSION"),
+		Signature: c.PostForm("SIGNATURE"),
 	}
 
 	// get file
@@ -92,7 +107,7 @@ func createPlugin(c *gin.Context) {
 		response.JSON(c, response.SetError(err))
 		return
 	}
-	data, err := service.CreatePlugin(dbInfo, plugin)
+	data, err := service.CreatePlugin(dbInfo, plugin, promote)
 	if err == nil {
 		refresh.RefreshCache(dbInfo.ORGID, []common.DataChanged{common.DATA_CHANGED_VTAP})
 	}
@@ -112,3 +127,44 @@ func deletePlugin(c *gin.Context) {
 	}
 	response.JSON(c, response.SetError(err))
 }
+
+func getPluginVersions(c *gin.Context) {
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.GetPluginVersions(dbInfo, c.Param("name"))
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
+
+func promotePlugin(c *gin.Context) {
+	var body model.PluginPromote
+	if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+		response.JSON(c, response.SetOptStatus(httpcommon.INVALID_PARAMETERS), response.SetError(err))
+		return
+	}
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.PromotePlugin(dbInfo, c.Param("name"), body.Version)
+	if err == nil {
+		refresh.RefreshCache(dbInfo.ORGID, []common.DataChanged{common.DATA_CHANGED_VTAP})
+	}
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
+
+func rollbackPlugin(c *gin.Context) {
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.RollbackPlugin(dbInfo, c.Param("name"))
+	if err == nil {
+		refresh.RefreshCache(dbInfo.ORGID, []common.DataChanged{common.DATA_CHANGED_VTAP})
+	}
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/http/service"
+	"github.com/deepflowio/deepflow/server/controller/model"
+)
+
+type PrometheusRecordingRule struct{}
+
+func NewPrometheusRecordingRule() *PrometheusRecordingRule {
+	return new(PrometheusRecordingRule)
+}
+
+func (p *PrometheusRecordingRule) RegisterTo(e *gin.Engine) {
+	e.GET("/v1/prometheus-recording-rule-groups/", getPrometheusRecordingRuleGroups)
+	e.POST("/v1/prometheus-recording-rule-groups/", createPrometheusRecordingRuleGroup)
+	e.PATCH("/v1/prometheus-recording-rule-groups/:lcuuid/", updatePrometheusRecordingRuleGroup)
+	e.DELETE("/v1/prometheus-recording-rule-groups/:lcuuid/", deletePrometheusRecordingRuleGroup)
+}
+
+func getPrometheusRecordingRuleGroups(c *gin.Context) {
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	args := make(map[string]interface{})
+	if value, ok := c.GetQuery("name"); ok {
+		args["name"] = value
+	}
+	data, err := service.GetPrometheusRecordingRuleGroups(dbInfo, args)
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
+
+func createPrometheusRecordingRuleGroup(c *gin.Context) {
+	var groupCreate model.PrometheusRecordingRuleGroupCreate
+	if err := c.ShouldBindBodyWith(&groupCreate, binding.JSON); err != nil {
+		response.JSON(c, response.SetOptStatus(httpcommon.INVALID_PARAMETERS), response.SetError(err))
+		return
+	}
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.CreatePrometheusRecordingRuleGroup(dbInfo, groupCreate)
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
+
+func updatePrometheusRecordingRuleGroup(c *gin.Context) {
+	var groupUpdate model.PrometheusRecordingRuleGroupUpdate
+	if err := c.ShouldBindBodyWith(&groupUpdate, binding.JSON); err != nil {
+		response.JSON(c, response.SetOptStatus(httpcommon.INVALID_PARAMETERS), response.SetError(err))
+		return
+	}
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.UpdatePrometheusRecordingRuleGroup(dbInfo, c.Param("lcuuid"), groupUpdate)
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
+
+func deletePrometheusRecordingRuleGroup(c *gin.Context) {
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.DeletePrometheusRecordingRuleGroup(dbInfo, c.Param("lcuuid"))
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
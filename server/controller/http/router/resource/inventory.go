@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/deepflowio/deepflow/server/controller/config"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/http/router/common"
+	"github.com/deepflowio/deepflow/server/controller/http/service/resource"
+	"github.com/deepflowio/deepflow/server/controller/model"
+)
+
+type Inventory struct {
+	cfg *config.ControllerConfig
+}
+
+func NewInventory(cfg *config.ControllerConfig) *Inventory {
+	return &Inventory{cfg: cfg}
+}
+
+func (i *Inventory) RegisterTo(e *gin.Engine) {
+	e.GET("/v1/inventory/", getInventoryResources)
+	e.GET("/v1/inventory/:resource/", getInventory(i.cfg))
+}
+
+func getInventoryResources(c *gin.Context) {
+	response.JSON(c, response.SetData(resource.GetInventoryResources()))
+}
+
+func getInventory(cfg *config.ControllerConfig) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		query := &model.InventoryQuery{Filters: make(map[string][]string)}
+		for key, values := range c.Request.URL.Query() {
+			switch key {
+			case "label_selector":
+				query.LabelSelector = c.Query(key)
+			case "expand":
+				query.Expand = c.Query(key)
+			case "include_deleted":
+				query.IncludeDeleted, _ = strconv.ParseBool(c.Query(key))
+			case "page_index", "page_size":
+				value, err := strconv.Atoi(c.Query(key))
+				if err != nil {
+					response.JSON(c, response.SetOptStatus(httpcommon.INVALID_PARAMETERS), response.SetError(err))
+					return
+				}
+				if key == "page_index" {
+					query.PageIndex = value
+				} else {
+					query.PageSize = value
+				}
+			default:
+				query.Filters[key] = values
+			}
+		}
+
+		db, err := common.GetContextOrgDB(c)
+		if err != nil {
+			response.JSON(c, response.SetOptStatus(httpcommon.GET_ORG_DB_FAIL), response.SetError(err))
+			return
+		}
+		excludeTeamIDs := []int{}
+		teamIDs, err := httpcommon.GetUnauthorizedTeamIDs(httpcommon.GetUserInfo(c), &cfg.FPermit)
+		if err != nil {
+			response.JSON(c, response.SetOptStatus(httpcommon.CHECK_SCOPE_TEAMS_FAIL), response.SetError(err))
+			return
+		}
+		for k := range teamIDs {
+			excludeTeamIDs = append(excludeTeamIDs, k)
+		}
+		data, page, err := resource.GetInventory(db, excludeTeamIDs, c.Param("resource"), query)
+		response.JSON(c, response.SetData(data), response.SetPage(*page), response.SetError(err))
+	})
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	ctrlcommon "github.com/deepflowio/deepflow/server/controller/common"
+	"github.com/deepflowio/deepflow/server/controller/config"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/http/router/common"
+	"github.com/deepflowio/deepflow/server/controller/http/service/resource"
+	"github.com/deepflowio/deepflow/server/controller/model"
+	recordercommon "github.com/deepflowio/deepflow/server/controller/recorder/common"
+)
+
+type Ownership struct {
+	cfg *config.ControllerConfig
+}
+
+func NewOwnership(cfg *config.ControllerConfig) *Ownership {
+	return &Ownership{cfg: cfg}
+}
+
+func (o *Ownership) RegisterTo(e *gin.Engine) {
+	e.GET("/v1/ownership/", getOwnerships(o.cfg))
+}
+
+func getOwnerships(cfg *config.ControllerConfig) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		query := &model.OwnershipQuery{IP: c.Query("ip"), MAC: c.Query("mac")}
+		if value, ok := c.GetQuery("resource_type"); ok {
+			resourceType, err := parseOwnershipResourceType(value)
+			if err != nil {
+				response.JSON(c, response.SetOptStatus(httpcommon.INVALID_PARAMETERS), response.SetError(err))
+				return
+			}
+			query.ResourceType = resourceType
+			query.ResourceID, err = strconv.Atoi(c.Query("resource_id"))
+			if err != nil {
+				response.JSON(c, response.SetOptStatus(httpcommon.INVALID_PARAMETERS), response.SetError(fmt.Errorf("invalid resource_id: %s", err.Error())))
+				return
+			}
+		}
+		for key, target := range map[string]*int64{"time": &query.Time, "start_time": &query.StartTime, "end_time": &query.EndTime} {
+			value, ok := c.GetQuery(key)
+			if !ok {
+				continue
+			}
+			t, err := parseOwnershipTime(value)
+			if err != nil {
+				response.JSON(c, response.SetOptStatus(httpcommon.INVALID_PARAMETERS), response.SetError(fmt.Errorf("invalid %s: %s", key, err.Error())))
+				return
+			}
+			*target = t
+		}
+
+		db, err := common.GetContextOrgDB(c)
+		if err != nil {
+			response.JSON(c, response.SetOptStatus(httpcommon.GET_ORG_DB_FAIL), response.SetError(err))
+			return
+		}
+		excludeTeamIDs := []int{}
+		teamIDs, err := httpcommon.GetUnauthorizedTeamIDs(httpcommon.GetUserInfo(c), &cfg.FPermit)
+		if err != nil {
+			response.JSON(c, response.SetOptStatus(httpcommon.CHECK_SCOPE_TEAMS_FAIL), response.SetError(err))
+			return
+		}
+		for k := range teamIDs {
+			excludeTeamIDs = append(excludeTeamIDs, k)
+		}
+		data, err := resource.GetOwnerships(db, cfg.ClickHouseCfg, excludeTeamIDs, query)
+		response.JSON(c, response.SetData(data), response.SetError(err))
+	})
+}
+
+// parseOwnershipResourceType accepts both device type names (pod, vm, ...) and numbers.
+func parseOwnershipResourceType(value string) (int, error) {
+	if t, err := strconv.Atoi(value); err == nil {
+		return t, nil
+	}
+	for t, name := range recordercommon.DEVICE_TYPE_INT_TO_STR {
+		if name == value {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported resource_type (%s)", value)
+}
+
+// parseOwnershipTime accepts unix seconds or local time like 2006-01-02 15:04:05.
+func parseOwnershipTime(value string) (int64, error) {
+	if t, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(ctrlcommon.GO_BIRTHDAY, value, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/http/service"
+	"github.com/deepflowio/deepflow/server/controller/model"
+	retagcommon "github.com/deepflowio/deepflow/server/controller/retag/common"
+)
+
+type RetagJob struct{}
+
+func NewRetagJob() *RetagJob {
+	return new(RetagJob)
+}
+
+func (r *RetagJob) RegisterTo(e *gin.Engine) {
+	e.GET("/v1/retag-jobs/", getRetagJobs)
+	e.GET("/v1/retag-jobs/:lcuuid/", getRetagJob)
+	e.POST("/v1/retag-jobs/", createRetagJob)
+	e.POST("/v1/retag-jobs/:lcuuid/cancel/", cancelRetagJob)
+	e.DELETE("/v1/retag-jobs/:lcuuid/", deleteRetagJob)
+}
+
+func getRetagJobs(c *gin.Context) {
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	args := make(map[string]interface{})
+	if value, ok := c.GetQuery("state"); ok {
+		for state, name := range retagcommon.JobStateName {
+			if name == value {
+				args["state"] = state
+			}
+		}
+		if _, ok := args["state"]; !ok {
+			response.JSON(c, response.SetError(response.ServiceError(httpcommon.INVALID_PARAMETERS, "invalid state: "+value)))
+			return
+		}
+	}
+	data, err := service.GetRetagJobs(dbInfo, args)
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
+
+func getRetagJob(c *gin.Context) {
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.GetRetagJobs(dbInfo, map[string]interface{}{"lcuuid": c.Param("lcuuid")})
+	if err == nil && len(data) == 0 {
+		response.JSON(c, response.SetError(response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, "retag job not found")))
+		return
+	}
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	response.JSON(c, response.SetData(data[0]))
+}
+
+func createRetagJob(c *gin.Context) {
+	var jobCreate model.RetagJobCreate
+	if err := c.ShouldBindBodyWith(&jobCreate, binding.JSON); err != nil {
+		response.JSON(c, response.SetOptStatus(httpcommon.INVALID_PARAMETERS), response.SetError(err))
+		return
+	}
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.CreateRetagJob(dbInfo, jobCreate)
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
+
+func cancelRetagJob(c *gin.Context) {
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.CancelRetagJob(dbInfo, c.Param("lcuuid"))
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
+
+func deleteRetagJob(c *gin.Context) {
+	dbInfo, err := metadb.GetDB(httpcommon.GetUserInfo(c).ORGID)
+	if err != nil {
+		response.JSON(c, response.SetError(err))
+		return
+	}
+	data, err := service.DeleteRetagJob(dbInfo, c.Param("lcuuid"))
+	response.JSON(c, response.SetData(data), response.SetError(err))
+}
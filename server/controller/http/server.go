@@ -128,12 +128,18 @@ func (s *Server) appendRegistrant() []registrant.Registrant {
 		router.NewMail(),
 		router.NewDatabase(s.controllerConfig),
 		router.NewAgentGroupConfig(s.controllerConfig),
+		router.NewAgentAdmission(s.controllerConfig),
+		router.NewPrometheusRecordingRule(),
+		router.NewRetagJob(),
+		router.NewAgentUpgradeRollout(),
 
 		// icon
 		router.NewIcon(s.controllerConfig),
 
 		// resource
 		resource.NewDomain(s.controllerConfig),
+		resource.NewInventory(s.controllerConfig),
+		resource.NewOwnership(s.controllerConfig),
 
 		agent.NewAgentCMD(s.controllerConfig),
 		vtap.NewAgentCMD(s.controllerConfig), // TODO remove
@@ -0,0 +1,287 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/deepflowio/deepflow/server/controller/common"
+	"github.com/deepflowio/deepflow/server/controller/config"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/model"
+	"github.com/deepflowio/deepflow/server/controller/trisolaris/admission"
+	"github.com/deepflowio/deepflow/server/controller/trisolaris/refresh"
+	"github.com/deepflowio/deepflow/server/libs/eventapi"
+)
+
+// AgentAdmission manages bootstrap tokens and the agents pending for approval
+type AgentAdmission struct {
+	cfg *config.ControllerConfig
+
+	resourceAccess *ResourceAccess
+}
+
+func NewAgentAdmission(userInfo *httpcommon.UserInfo, cfg *config.ControllerConfig) *AgentAdmission {
+	return &AgentAdmission{
+		cfg:            cfg,
+		resourceAccess: &ResourceAccess{Fpermit: cfg.FPermit, UserInfo: userInfo},
+	}
+}
+
+func (a *AgentAdmission) GetTokens(filter map[string]interface{}) ([]model.AgentBootstrapToken, error) {
+	userInfo := a.resourceAccess.UserInfo
+	dbInfo, err := metadb.GetDB(userInfo.ORGID)
+	if err != nil {
+		return nil, err
+	}
+	db := dbInfo.DB
+	for _, field := range []string{"lcuuid", "name", "token_id", "team_id", "agent_group_id"} {
+		if v, ok := filter[field]; ok {
+			db = db.Where(fmt.Sprintf("%s = ?", field), v)
+		}
+	}
+	var dbTokens []metadbmodel.AgentBootstrapToken
+	if err := db.Order("created_at DESC").Find(&dbTokens).Error; err != nil {
+		return nil, err
+	}
+	teamIDs, err := a.authorizedTeamFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]model.AgentBootstrapToken, 0, len(dbTokens))
+	for _, t := range dbTokens {
+		if !teamIDs(t.TeamID) {
+			continue
+		}
+		resp = append(resp, toTokenResp(&t))
+	}
+	return resp, nil
+}
+
+func (a *AgentAdmission) CreateToken(tokenCreate model.AgentBootstrapTokenCreate) (model.AgentBootstrapToken, error) {
+	lcuuid := uuid.New().String()
+	if err := a.resourceAccess.CanAddResource(tokenCreate.TeamID, common.SET_RESOURCE_TYPE_AGENT_GROUP, lcuuid); err != nil {
+		return model.AgentBootstrapToken{}, err
+	}
+	if tokenCreate.TTL < 0 || tokenCreate.UsageLimit < 0 {
+		return model.AgentBootstrapToken{}, response.ServiceError(httpcommon.INVALID_POST_DATA, "TTL and USAGE_LIMIT must not be negative")
+	}
+
+	userInfo := a.resourceAccess.UserInfo
+	dbInfo, err := metadb.GetDB(userInfo.ORGID)
+	if err != nil {
+		return model.AgentBootstrapToken{}, err
+	}
+	db := dbInfo.DB
+
+	if tokenCreate.AgentGroupID != "" {
+		var vtapGroup metadbmodel.VTapGroup
+		if err := db.Where("short_uuid = ?", tokenCreate.AgentGroupID).First(&vtapGroup).Error; err != nil {
+			return model.AgentBootstrapToken{}, response.ServiceError(
+				httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("agent group (%s) not found", tokenCreate.AgentGroupID))
+		}
+		if vtapGroup.TeamID != tokenCreate.TeamID {
+			return model.AgentBootstrapToken{}, response.ServiceError(httpcommon.INVALID_POST_DATA,
+				fmt.Sprintf("agent group team(%d) must equal to token team(%d)", vtapGroup.TeamID, tokenCreate.TeamID))
+		}
+	}
+
+	token, err := admission.GenerateToken()
+	if err != nil {
+		return model.AgentBootstrapToken{}, err
+	}
+	dbToken := metadbmodel.AgentBootstrapToken{
+		Name:         tokenCreate.Name,
+		TokenID:      token.ID,
+		SecretHash:   admission.HashSecret(token.Secret),
+		TeamID:       tokenCreate.TeamID,
+		UserID:       userInfo.ID,
+		AgentGroupID: tokenCreate.AgentGroupID,
+		UsageLimit:   tokenCreate.UsageLimit,
+		Lcuuid:       lcuuid,
+	}
+	if tokenCreate.TTL > 0 {
+		expireAt := time.Now().Add(time.Duration(tokenCreate.TTL) * time.Second)
+		dbToken.ExpireAt = &expireAt
+	}
+	if err := db.Create(&dbToken).Error; err != nil {
+		return model.AgentBootstrapToken{}, err
+	}
+	log.Infof("create agent bootstrap token (name: %s, token_id: %s)", dbToken.Name, dbToken.TokenID, dbInfo.LogPrefixORGID)
+
+	resp := toTokenResp(&dbToken)
+	resp.Token = token.Encode()
+	return resp, nil
+}
+
+func (a *AgentAdmission) DeleteToken(lcuuid string) (map[string]string, error) {
+	dbInfo, err := metadb.GetDB(a.resourceAccess.UserInfo.ORGID)
+	if err != nil {
+		return nil, err
+	}
+	db := dbInfo.DB
+
+	var dbToken metadbmodel.AgentBootstrapToken
+	if err := db.Where("lcuuid = ?", lcuuid).First(&dbToken).Error; err != nil {
+		return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("agent bootstrap token (%s) not found", lcuuid))
+	}
+	if err := a.resourceAccess.CanDeleteResource(dbToken.TeamID, common.SET_RESOURCE_TYPE_AGENT_GROUP, ""); err != nil {
+		return nil, err
+	}
+	log.Infof("delete agent bootstrap token (name: %s, token_id: %s)", dbToken.Name, dbToken.TokenID, dbInfo.LogPrefixORGID)
+	if err := db.Delete(&dbToken).Error; err != nil {
+		return nil, err
+	}
+	return map[string]string{"LCUUID": lcuuid}, nil
+}
+
+func (a *AgentAdmission) GetPendingAgents() ([]model.PendingAgent, error) {
+	userInfo := a.resourceAccess.UserInfo
+	dbInfo, err := metadb.GetDB(userInfo.ORGID)
+	if err != nil {
+		return nil, err
+	}
+	var allVTaps []metadbmodel.VTap
+	if err := dbInfo.DB.Where("state = ?", common.VTAP_STATE_PENDING).Order("created_at").Find(&allVTaps).Error; err != nil {
+		return nil, err
+	}
+	vtaps, err := GetAgentByUser(userInfo, &a.cfg.FPermit, allVTaps)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]model.PendingAgent, 0, len(vtaps))
+	for _, vtap := range vtaps {
+		resp = append(resp, model.PendingAgent{
+			ID:              vtap.ID,
+			Name:            vtap.Name,
+			CtrlIP:          vtap.CtrlIP,
+			CtrlMac:         vtap.CtrlMac,
+			TeamID:          vtap.TeamID,
+			VtapGroupLcuuid: vtap.VtapGroupLcuuid,
+			CreatedAt:       vtap.CreatedAt.Format(common.GO_BIRTHDAY),
+			Lcuuid:          vtap.Lcuuid,
+		})
+	}
+	return resp, nil
+}
+
+// ApprovePendingAgent moves the pending agent to normal state
+func (a *AgentAdmission) ApprovePendingAgent(lcuuid string) (map[string]string, error) {
+	orgID := a.resourceAccess.UserInfo.ORGID
+	dbInfo, vtap, err := a.getPendingAgent(lcuuid)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.resourceAccess.CanUpdateResource(vtap.TeamID, common.SET_RESOURCE_TYPE_AGENT, "", nil); err != nil {
+		return nil, err
+	}
+	log.Infof("approve pending agent (%s)", vtap.Name, dbInfo.LogPrefixORGID)
+	result := dbInfo.DB.Model(&metadbmodel.VTap{}).Where("id = ? AND state = ?", vtap.ID, common.VTAP_STATE_PENDING).
+		Update("state", common.VTAP_STATE_NORMAL)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("pending agent (%s) not found", lcuuid))
+	}
+	admission.PutEvent(a.cfg.TrisolarisCfg.GetResourceEventQueue(), orgID, vtap.TeamID,
+		eventapi.RESOURCE_EVENT_TYPE_AGENT_APPROVE, vtap.Name, vtap.CtrlIP,
+		fmt.Sprintf("agent %s (ctrl_ip: %s, ctrl_mac: %s) is approved by user(%d)",
+			vtap.Name, vtap.CtrlIP, vtap.CtrlMac, a.resourceAccess.UserInfo.ID))
+	refresh.RefreshCache(orgID, []common.DataChanged{common.DATA_CHANGED_VTAP})
+	return map[string]string{"LCUUID": lcuuid}, nil
+}
+
+// RejectPendingAgent deletes the pending agent, it will be pending again if it keeps syncing
+// without a valid bootstrap token
+func (a *AgentAdmission) RejectPendingAgent(lcuuid string) (map[string]string, error) {
+	orgID := a.resourceAccess.UserInfo.ORGID
+	dbInfo, vtap, err := a.getPendingAgent(lcuuid)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.resourceAccess.CanDeleteResource(vtap.TeamID, common.SET_RESOURCE_TYPE_AGENT, ""); err != nil {
+		return nil, err
+	}
+	log.Infof("reject pending agent (%s)", vtap.Name, dbInfo.LogPrefixORGID)
+	if err := dbInfo.DB.Where("id = ? AND state = ?", vtap.ID, common.VTAP_STATE_PENDING).Delete(&metadbmodel.VTap{}).Error; err != nil {
+		return nil, err
+	}
+	admission.PutEvent(a.cfg.TrisolarisCfg.GetResourceEventQueue(), orgID, vtap.TeamID,
+		eventapi.RESOURCE_EVENT_TYPE_AGENT_REJECT, vtap.Name, vtap.CtrlIP,
+		fmt.Sprintf("agent %s (ctrl_ip: %s, ctrl_mac: %s) is rejected by user(%d)",
+			vtap.Name, vtap.CtrlIP, vtap.CtrlMac, a.resourceAccess.UserInfo.ID))
+	refresh.RefreshCache(orgID, []common.DataChanged{common.DATA_CHANGED_VTAP})
+	return map[string]string{"LCUUID": lcuuid}, nil
+}
+
+func (a *AgentAdmission) getPendingAgent(lcuuid string) (*metadb.DB, *metadbmodel.VTap, error) {
+	dbInfo, err := metadb.GetDB(a.resourceAccess.UserInfo.ORGID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var vtap metadbmodel.VTap
+	if err := dbInfo.DB.Where("lcuuid = ? AND state = ?", lcuuid, common.VTAP_STATE_PENDING).First(&vtap).Error; err != nil {
+		return nil, nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("pending agent (%s) not found", lcuuid))
+	}
+	return dbInfo, &vtap, nil
+}
+
+// authorizedTeamFilter returns whether the user can see the resources of a team
+func (a *AgentAdmission) authorizedTeamFilter() (func(teamID int) bool, error) {
+	userInfo := a.resourceAccess.UserInfo
+	if userInfo.Type == common.DEFAULT_USER_TYPE && userInfo.ID == common.DEFAULT_USER_ID {
+		return func(int) bool { return true }, nil
+	}
+	if !a.cfg.FPermit.Enabled {
+		return func(teamID int) bool { return teamID == common.DEFAULT_TEAM_ID }, nil
+	}
+	unauthorized, err := httpcommon.GetUnauthorizedTeamIDs(userInfo, &a.cfg.FPermit)
+	if err != nil {
+		return nil, err
+	}
+	return func(teamID int) bool {
+		_, ok := unauthorized[teamID]
+		return !ok
+	}, nil
+}
+
+func toTokenResp(t *metadbmodel.AgentBootstrapToken) model.AgentBootstrapToken {
+	resp := model.AgentBootstrapToken{
+		ID:           t.ID,
+		Name:         t.Name,
+		TokenID:      t.TokenID,
+		TeamID:       t.TeamID,
+		UserID:       t.UserID,
+		AgentGroupID: t.AgentGroupID,
+		UsageLimit:   t.UsageLimit,
+		UsageCount:   t.UsageCount,
+		CreatedAt:    t.CreatedAt.Format(common.GO_BIRTHDAY),
+		Lcuuid:       t.Lcuuid,
+	}
+	if t.ExpireAt != nil {
+		resp.ExpireAt = t.ExpireAt.Format(common.GO_BIRTHDAY)
+	}
+	return resp
+}
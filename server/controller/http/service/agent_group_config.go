@@ -85,24 +85,36 @@ func (a *AgentGroupConfig) GetAgentGroupConfigTemplateJson() ([]byte, error) {
 	if err := dbInfo.Select("type", "id", "name").Find(&plugins).Error; err != nil {
 		return nil, err
 	}
+	var pluginVersions []model.PluginVersion
+	if err := dbInfo.Select("type", "id", "name", "version").Order("id").Find(&pluginVersions).Error; err != nil {
+		return nil, err
+	}
+	pluginTypeToReferences := make(map[int][]string)
+	for _, plugin := range plugins {
+		pluginTypeToReferences[plugin.Type] = append(pluginTypeToReferences[plugin.Type], plugin.Name)
+	}
+	// a plugin can also be pinned to a version by name@version
+	for _, pluginVersion := range pluginVersions {
+		pluginTypeToReferences[pluginVersion.Type] = append(pluginTypeToReferences[pluginVersion.Type],
+			common.PluginReference(pluginVersion.Name, pluginVersion.Version))
+	}
 	wasmPluginInfos := make([]map[string]interface{}, 0)
 	soPluginInfos := make([]map[string]interface{}, 0)
-	for _, plugin := range plugins {
-		if plugin.Type == 1 {
-			wasmPluginInfos = append(wasmPluginInfos, map[string]interface{}{
-				plugin.Name: map[string]interface{}{
-					"ch": plugin.Name,
-					"en": plugin.Name,
-				},
-			})
-		} else if plugin.Type == 2 {
-			soPluginInfos = append(soPluginInfos, map[string]interface{}{
-				plugin.Name: map[string]interface{}{
-					"ch": plugin.Name,
-					"en": plugin.Name,
-				},
-			})
-		}
+	for _, reference := range pluginTypeToReferences[common.PLUGIN_TYPE_WASM] {
+		wasmPluginInfos = append(wasmPluginInfos, map[string]interface{}{
+			reference: map[string]interface{}{
+				"ch": reference,
+				"en": reference,
+			},
+		})
+	}
+	for _, reference := range pluginTypeToReferences[common.PLUGIN_TYPE_SO] {
+		soPluginInfos = append(soPluginInfos, map[string]interface{}{
+			reference: map[string]interface{}{
+				"ch": reference,
+				"en": reference,
+			},
+		})
 	}
 	wasmPluginInfosYamlBytes, err := yaml.Marshal(wasmPluginInfos)
 	if err != nil {
@@ -239,7 +251,7 @@ func (a *AgentGroupConfig) getStringYaml(data interface{}, dataType int) (string
 	}
 }
 
-func (a *AgentGroupConfig) CreateAgentGroupConfig(groupLcuuid string, data interface{}, dataType int) ([]byte, error) {
+func (a *AgentGroupConfig) CreateAgentGroupConfig(groupLcuuid string, data interface{}, dataType int, comment string) ([]byte, error) {
 	dbInfo, err := metadb.GetDB(a.resourceAccess.UserInfo.ORGID)
 	if err != nil {
 		return nil, err
@@ -268,7 +280,13 @@ func (a *AgentGroupConfig) CreateAgentGroupConfig(groupLcuuid string, data inter
 				AgentGroupLcuuid: groupLcuuid,
 				Yaml:             strYaml,
 			}
-			if err := dbInfo.Create(newConfig).Error; err != nil {
+			err := dbInfo.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(newConfig).Error; err != nil {
+					return err
+				}
+				return a.saveRevision(tx, groupLcuuid, strYaml, AGENT_GROUP_CONFIG_ACTION_CREATE, comment)
+			})
+			if err != nil {
 				log.Errorf("failed to insert agent_group_configuration (agent group lcuuid %s): %v", groupLcuuid, err)
 				return nil, err
 			}
@@ -279,7 +297,7 @@ func (a *AgentGroupConfig) CreateAgentGroupConfig(groupLcuuid string, data inter
 	} else {
 		// TODO(weiqiang): duplicate and verify
 		agentGroupConfig.Yaml = strYaml
-		if err := dbInfo.Save(&agentGroupConfig).Error; err != nil {
+		if err := a.saveConfigWithRevision(dbInfo, &agentGroupConfig, AGENT_GROUP_CONFIG_ACTION_UPDATE, comment); err != nil {
 			log.Errorf("failed to update agent_group_configuration (agent group lcuuid %s): %v", groupLcuuid, err)
 			return nil, err
 		}
@@ -289,7 +307,7 @@ func (a *AgentGroupConfig) CreateAgentGroupConfig(groupLcuuid string, data inter
 	return a.strToBytes(agentGroupConfig.Yaml, dataType)
 }
 
-func (a *AgentGroupConfig) UpdateAgentGroupConfig(groupLcuuid string, data interface{}, dataType int) ([]byte, error) {
+func (a *AgentGroupConfig) UpdateAgentGroupConfig(groupLcuuid string, data interface{}, dataType int, comment string) ([]byte, error) {
 	dbInfo, err := metadb.GetDB(a.resourceAccess.UserInfo.ORGID)
 	if err != nil {
 		return nil, err
@@ -317,7 +335,7 @@ func (a *AgentGroupConfig) UpdateAgentGroupConfig(groupLcuuid string, data inter
 		return nil, err
 	} else {
 		agentGroupConfig.Yaml = strYaml
-		if err := dbInfo.Save(&agentGroupConfig).Error; err != nil {
+		if err := a.saveConfigWithRevision(dbInfo, &agentGroupConfig, AGENT_GROUP_CONFIG_ACTION_UPDATE, comment); err != nil {
 			log.Errorf("failed to update agent_group_configuration (agent group lcuuid %s): %v", groupLcuuid, err)
 			return nil, err
 		}
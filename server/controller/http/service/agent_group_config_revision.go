@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	agentconf "github.com/deepflowio/deepflow/server/agent_config"
+	"github.com/deepflowio/deepflow/server/controller/common"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/model"
+	"github.com/deepflowio/deepflow/server/controller/trisolaris/refresh"
+)
+
+const (
+	AGENT_GROUP_CONFIG_ACTION_CREATE   = "create"
+	AGENT_GROUP_CONFIG_ACTION_UPDATE   = "update"
+	AGENT_GROUP_CONFIG_ACTION_ROLLBACK = "rollback"
+
+	// diff base of a revision, other values are revision numbers
+	AGENT_GROUP_CONFIG_DIFF_BASE_TEMPLATE = "template"
+)
+
+// saveRevision appends an immutable revision of the agent group config, it must be called in
+// the transaction writing agent_group_configuration
+func (a *AgentGroupConfig) saveRevision(tx *gorm.DB, groupLcuuid, yaml, action, comment string) error {
+	var maxRevision int
+	if err := tx.Model(&agentconf.AgentGroupConfigRevision{}).Where("agent_group_lcuuid = ?", groupLcuuid).
+		Select("COALESCE(MAX(revision), 0)").Scan(&maxRevision).Error; err != nil {
+		return err
+	}
+	revision := &agentconf.AgentGroupConfigRevision{
+		Lcuuid:           uuid.New().String(),
+		AgentGroupLcuuid: groupLcuuid,
+		Revision:         maxRevision + 1,
+		Action:           action,
+		Yaml:             yaml,
+		UserID:           a.resourceAccess.UserInfo.ID,
+		Comment:          comment,
+	}
+	return tx.Create(revision).Error
+}
+
+func (a *AgentGroupConfig) saveConfigWithRevision(dbInfo *metadb.DB, config *agentconf.MySQLAgentGroupConfiguration, action, comment string) error {
+	return dbInfo.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(config).Error; err != nil {
+			return err
+		}
+		return a.saveRevision(tx, config.AgentGroupLcuuid, config.Yaml, action, comment)
+	})
+}
+
+func (a *AgentGroupConfig) GetAgentGroupConfigRevisions(groupLcuuid string) ([]model.AgentGroupConfigRevision, error) {
+	dbInfo, err := metadb.GetDB(a.resourceAccess.UserInfo.ORGID)
+	if err != nil {
+		return nil, err
+	}
+	var revisions []agentconf.AgentGroupConfigRevision
+	if err := dbInfo.Where("agent_group_lcuuid = ?", groupLcuuid).Omit("yaml").Order("revision DESC").Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+	resp := make([]model.AgentGroupConfigRevision, 0, len(revisions))
+	for i := range revisions {
+		resp = append(resp, toRevisionResp(&revisions[i]))
+	}
+	return resp, nil
+}
+
+func (a *AgentGroupConfig) GetAgentGroupConfigRevision(groupLcuuid string, revision int) (model.AgentGroupConfigRevision, error) {
+	r, err := a.getRevision(groupLcuuid, revision)
+	if err != nil {
+		return model.AgentGroupConfigRevision{}, err
+	}
+	resp := toRevisionResp(r)
+	resp.Yaml = r.Yaml
+	return resp, nil
+}
+
+// DiffAgentGroupConfigRevision compares the revision with template.yaml defaults or with another revision
+func (a *AgentGroupConfig) DiffAgentGroupConfigRevision(groupLcuuid string, revision int, base string) ([]agentconf.ConfigDiff, error) {
+	r, err := a.getRevision(groupLcuuid, revision)
+	if err != nil {
+		return nil, err
+	}
+	if base == "" || base == AGENT_GROUP_CONFIG_DIFF_BASE_TEMPLATE {
+		return agentconf.DiffWithTemplate([]byte(r.Yaml))
+	}
+	baseRevision, err := strconv.Atoi(base)
+	if err != nil {
+		return nil, response.ServiceError(httpcommon.INVALID_PARAMETERS,
+			fmt.Sprintf("base (%s) must be %s or a revision number", base, AGENT_GROUP_CONFIG_DIFF_BASE_TEMPLATE))
+	}
+	b, err := a.getRevision(groupLcuuid, baseRevision)
+	if err != nil {
+		return nil, err
+	}
+	return agentconf.DiffYAML([]byte(b.Yaml), []byte(r.Yaml))
+}
+
+// RollbackAgentGroupConfig restores the config of the revision, the rollback itself is saved as a new revision
+func (a *AgentGroupConfig) RollbackAgentGroupConfig(groupLcuuid string, revision int, comment string) (model.AgentGroupConfigRevision, error) {
+	dbInfo, err := metadb.GetDB(a.resourceAccess.UserInfo.ORGID)
+	if err != nil {
+		return model.AgentGroupConfigRevision{}, err
+	}
+	// the revisions are kept after the agent group is deleted, but the config must not be recreated for it
+	var agentGroup metadbmodel.VTapGroup
+	if err := dbInfo.Where("lcuuid = ?", groupLcuuid).First(&agentGroup).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.AgentGroupConfigRevision{}, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND,
+				fmt.Sprintf("agent group (%s) not found", groupLcuuid))
+		}
+		log.Errorf("failed to get vtap_group (lcuuid %s): %v", groupLcuuid, err, dbInfo.LogPrefixORGID)
+		return model.AgentGroupConfigRevision{}, err
+	}
+	r, err := a.getRevision(groupLcuuid, revision)
+	if err != nil {
+		return model.AgentGroupConfigRevision{}, err
+	}
+	if comment == "" {
+		comment = fmt.Sprintf("rollback to revision %d", revision)
+	}
+	log.Infof("rollback agent group config, group lcuuid: %s, revision: %d", groupLcuuid, revision, dbInfo.LogPrefixORGID)
+
+	var config agentconf.MySQLAgentGroupConfiguration
+	if err := dbInfo.Where("agent_group_lcuuid = ?", groupLcuuid).First(&config).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.AgentGroupConfigRevision{}, err
+		}
+		// the config has been deleted, rollback recreates it
+		config = agentconf.MySQLAgentGroupConfiguration{
+			Lcuuid:           uuid.New().String(),
+			AgentGroupLcuuid: groupLcuuid,
+		}
+	}
+	config.Yaml = r.Yaml
+	if err := a.saveConfigWithRevision(dbInfo, &config, AGENT_GROUP_CONFIG_ACTION_ROLLBACK, comment); err != nil {
+		log.Errorf("failed to rollback agent_group_configuration (agent group lcuuid %s): %v", groupLcuuid, err, dbInfo.LogPrefixORGID)
+		return model.AgentGroupConfigRevision{}, err
+	}
+	refresh.RefreshCache(dbInfo.GetORGID(), []common.DataChanged{common.DATA_CHANGED_VTAP})
+
+	var latest agentconf.AgentGroupConfigRevision
+	if err := dbInfo.Where("agent_group_lcuuid = ?", groupLcuuid).Omit("yaml").Order("revision DESC").First(&latest).Error; err != nil {
+		return model.AgentGroupConfigRevision{}, err
+	}
+	return toRevisionResp(&latest), nil
+}
+
+func (a *AgentGroupConfig) getRevision(groupLcuuid string, revision int) (*agentconf.AgentGroupConfigRevision, error) {
+	dbInfo, err := metadb.GetDB(a.resourceAccess.UserInfo.ORGID)
+	if err != nil {
+		return nil, err
+	}
+	var r agentconf.AgentGroupConfigRevision
+	if err := dbInfo.Where("agent_group_lcuuid = ? AND revision = ?", groupLcuuid, revision).First(&r).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND,
+				fmt.Sprintf("agent group (%s) config revision (%d) not found", groupLcuuid, revision))
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+func toRevisionResp(r *agentconf.AgentGroupConfigRevision) model.AgentGroupConfigRevision {
+	return model.AgentGroupConfigRevision{
+		Revision:         r.Revision,
+		AgentGroupLcuuid: r.AgentGroupLcuuid,
+		Action:           r.Action,
+		UserID:           r.UserID,
+		Comment:          r.Comment,
+		CreatedAt:        r.CreatedAt.Format(common.GO_BIRTHDAY),
+	}
+}
@@ -0,0 +1,336 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/deepflowio/deepflow/server/controller/common"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/model"
+	rolloutcommon "github.com/deepflowio/deepflow/server/controller/rollout/common"
+)
+
+var activeRolloutStates = []int{
+	rolloutcommon.ROLLOUT_STATE_PENDING, rolloutcommon.ROLLOUT_STATE_RUNNING,
+	rolloutcommon.ROLLOUT_STATE_PAUSED, rolloutcommon.ROLLOUT_STATE_ROLLING_BACK,
+}
+
+func GetAgentUpgradeRollouts(db *metadb.DB, filter map[string]interface{}) ([]model.AgentUpgradeRollout, error) {
+	queryDB := db.DB
+	for _, field := range []string{"lcuuid", "state", "vtap_group_lcuuid"} {
+		if v, ok := filter[field]; ok {
+			queryDB = queryDB.Where(fmt.Sprintf("%s = ?", field), v)
+		}
+	}
+	var dbRollouts []metadbmodel.AgentUpgradeRollout
+	if err := queryDB.Order("id DESC").Find(&dbRollouts).Error; err != nil {
+		return nil, err
+	}
+	var vtapGroups []metadbmodel.VTapGroup
+	if err := db.Select("lcuuid", "name", "short_uuid").Find(&vtapGroups).Error; err != nil {
+		return nil, err
+	}
+	lcuuidToVTapGroup := make(map[string]metadbmodel.VTapGroup, len(vtapGroups))
+	for _, g := range vtapGroups {
+		lcuuidToVTapGroup[g.Lcuuid] = g
+	}
+
+	resp := make([]model.AgentUpgradeRollout, 0, len(dbRollouts))
+	for _, r := range dbRollouts {
+		rollout := model.AgentUpgradeRollout{
+			ID:               r.ID,
+			Name:             r.Name,
+			AgentGroupID:     lcuuidToVTapGroup[r.VTapGroupLcuuid].ShortUUID,
+			AgentGroupName:   lcuuidToVTapGroup[r.VTapGroupLcuuid].Name,
+			ImageName:        r.ImageName,
+			ExpectedRevision: r.ExpectedRevision,
+			CanaryAgents:     []string{},
+			CanaryPercent:    r.CanaryPercent,
+			BatchSize:        r.BatchSize,
+			ReconnectTimeout: r.ReconnectTimeout,
+			ObserveTime:      r.ObserveTime,
+			FailurePolicy:    rolloutcommon.FailurePolicyName[r.FailurePolicy],
+			State:            rolloutcommon.RolloutStateName[r.State],
+			CurrentWave:      r.CurrentWave,
+			TotalWaves:       r.TotalWaves,
+			TotalAgents:      r.TotalAgents,
+			HealthyAgents:    r.HealthyAgents,
+			FailedAgents:     r.FailedAgents,
+			ErrorMessage:     r.ErrorMessage,
+			CreatedAt:        r.CreatedAt.Format(common.GO_BIRTHDAY),
+			UpdatedAt:        r.UpdatedAt.Format(common.GO_BIRTHDAY),
+			Lcuuid:           r.Lcuuid,
+		}
+		if r.TotalAgents > 0 {
+			rollout.Progress = float64(r.HealthyAgents) * 100 / float64(r.TotalAgents)
+		}
+		if r.StartedAt != nil {
+			rollout.StartedAt = r.StartedAt.Format(common.GO_BIRTHDAY)
+		}
+		if r.FinishedAt != nil {
+			rollout.FinishedAt = r.FinishedAt.Format(common.GO_BIRTHDAY)
+		}
+		if r.CanaryAgents != "" {
+			if err := json.Unmarshal([]byte(r.CanaryAgents), &rollout.CanaryAgents); err != nil {
+				log.Errorf("unmarshal canary agents of rollout (%d) failed: %s", r.ID, err.Error(), db.LogPrefixORGID)
+			}
+		}
+		resp = append(resp, rollout)
+	}
+	return resp, nil
+}
+
+// GetAgentUpgradeRollout returns the rollout with the progress of each agent
+func GetAgentUpgradeRollout(db *metadb.DB, lcuuid string) (*model.AgentUpgradeRollout, error) {
+	rollouts, err := GetAgentUpgradeRollouts(db, map[string]interface{}{"lcuuid": lcuuid})
+	if err != nil {
+		return nil, err
+	}
+	if len(rollouts) == 0 {
+		return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("agent upgrade rollout (%s) not found", lcuuid))
+	}
+	rollout := &rollouts[0]
+	var dbAgents []metadbmodel.AgentUpgradeRolloutAgent
+	if err := db.Where("rollout_id = ?", rollout.ID).Order("wave, vtap_name").Find(&dbAgents).Error; err != nil {
+		return nil, err
+	}
+	rollout.Agents = make([]model.AgentUpgradeRolloutAgent, 0, len(dbAgents))
+	for _, a := range dbAgents {
+		agent := model.AgentUpgradeRolloutAgent{
+			Name:             a.VTapName,
+			Lcuuid:           a.VTapLcuuid,
+			Wave:             a.Wave,
+			State:            rolloutcommon.AgentStateName[a.State],
+			PreviousRevision: a.PreviousRevision,
+			PreviousImage:    a.PreviousImage,
+			Message:          a.Message,
+		}
+		if a.UpgradedAt != nil {
+			agent.UpgradedAt = a.UpgradedAt.Format(common.GO_BIRTHDAY)
+		}
+		if a.ReconnectedAt != nil {
+			agent.ReconnectedAt = a.ReconnectedAt.Format(common.GO_BIRTHDAY)
+		}
+		rollout.Agents = append(rollout.Agents, agent)
+	}
+	return rollout, nil
+}
+
+func CreateAgentUpgradeRollout(db *metadb.DB, rolloutCreate model.AgentUpgradeRolloutCreate) (*model.AgentUpgradeRollout, error) {
+	if rolloutCreate.CanaryPercent < 0 || rolloutCreate.CanaryPercent > 100 {
+		return nil, response.ServiceError(httpcommon.INVALID_POST_DATA, "CANARY_PERCENT must be in [0, 100]")
+	}
+	if rolloutCreate.BatchSize < 0 || rolloutCreate.ReconnectTimeout < 0 || rolloutCreate.ObserveTime < 0 {
+		return nil, response.ServiceError(httpcommon.INVALID_POST_DATA, "BATCH_SIZE, RECONNECT_TIMEOUT and OBSERVE_TIME must not be negative")
+	}
+	failurePolicy := rolloutcommon.FAILURE_POLICY_PAUSE
+	if rolloutCreate.FailurePolicy != "" {
+		failurePolicy = -1
+		for policy, name := range rolloutcommon.FailurePolicyName {
+			if name == rolloutCreate.FailurePolicy {
+				failurePolicy = policy
+			}
+		}
+		if failurePolicy < 0 {
+			return nil, response.ServiceError(httpcommon.INVALID_POST_DATA, "invalid FAILURE_POLICY: "+rolloutCreate.FailurePolicy)
+		}
+	}
+
+	var vtapGroup metadbmodel.VTapGroup
+	if err := db.Where("short_uuid = ?", rolloutCreate.AgentGroupID).First(&vtapGroup).Error; err != nil {
+		return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("agent group (%s) not found", rolloutCreate.AgentGroupID))
+	}
+	var repo metadbmodel.VTapRepo
+	if err := db.Select("rev_count", "commit_id").Where("name = ?", rolloutCreate.ImageName).First(&repo).Error; err != nil {
+		return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("agent image (%s) not found", rolloutCreate.ImageName))
+	}
+	if repo.RevCount == "" || repo.CommitID == "" {
+		return nil, response.ServiceError(httpcommon.INVALID_POST_DATA, fmt.Sprintf("revision of agent image (%s) is unknown", rolloutCreate.ImageName))
+	}
+	if len(rolloutCreate.CanaryAgents) > 0 {
+		var names []string
+		if err := db.Model(&metadbmodel.VTap{}).Where(
+			"vtap_group_lcuuid = ? AND name IN ?", vtapGroup.Lcuuid, rolloutCreate.CanaryAgents,
+		).Pluck("name", &names).Error; err != nil {
+			return nil, err
+		}
+		nameSet := make(map[string]struct{}, len(names))
+		for _, name := range names {
+			nameSet[name] = struct{}{}
+		}
+		for _, name := range rolloutCreate.CanaryAgents {
+			if _, ok := nameSet[name]; !ok {
+				return nil, response.ServiceError(httpcommon.INVALID_POST_DATA,
+					fmt.Sprintf("canary agent (%s) not found in agent group (%s)", name, rolloutCreate.AgentGroupID))
+			}
+		}
+	}
+	var activeCount int64
+	if err := db.Model(&metadbmodel.AgentUpgradeRollout{}).Where(
+		"vtap_group_lcuuid = ? AND state IN ?", vtapGroup.Lcuuid, activeRolloutStates,
+	).Count(&activeCount).Error; err != nil {
+		return nil, err
+	}
+	if activeCount > 0 {
+		return nil, response.ServiceError(httpcommon.INVALID_POST_DATA,
+			fmt.Sprintf("agent group (%s) has a rollout in progress, cancel it first", rolloutCreate.AgentGroupID))
+	}
+
+	canaryAgents := []byte{}
+	if len(rolloutCreate.CanaryAgents) > 0 {
+		var err error
+		if canaryAgents, err = json.Marshal(rolloutCreate.CanaryAgents); err != nil {
+			return nil, err
+		}
+	}
+	dbRollout := metadbmodel.AgentUpgradeRollout{
+		Name:             rolloutCreate.Name,
+		VTapGroupLcuuid:  vtapGroup.Lcuuid,
+		ImageName:        rolloutCreate.ImageName,
+		ExpectedRevision: repo.RevCount + "-" + repo.CommitID,
+		CanaryAgents:     string(canaryAgents),
+		CanaryPercent:    rolloutCreate.CanaryPercent,
+		BatchSize:        rolloutCreate.BatchSize,
+		ReconnectTimeout: rolloutCreate.ReconnectTimeout,
+		ObserveTime:      rolloutCreate.ObserveTime,
+		FailurePolicy:    failurePolicy,
+		State:            rolloutcommon.ROLLOUT_STATE_PENDING,
+		Lcuuid:           uuid.New().String(),
+	}
+	if err := db.Create(&dbRollout).Error; err != nil {
+		return nil, err
+	}
+	log.Infof("create agent upgrade rollout (%d) of agent group (%s) to %s (%s)",
+		dbRollout.ID, vtapGroup.Name, dbRollout.ImageName, dbRollout.ExpectedRevision, db.LogPrefixORGID)
+	return GetAgentUpgradeRollout(db, dbRollout.Lcuuid)
+}
+
+// PauseAgentUpgradeRollout stops the rollout before the next wave, the agents of the current wave already
+// told to upgrade are not cancelled
+func PauseAgentUpgradeRollout(db *metadb.DB, lcuuid string) (*model.AgentUpgradeRollout, error) {
+	return transitAgentUpgradeRollout(db, lcuuid, "pause",
+		[]int{rolloutcommon.ROLLOUT_STATE_PENDING, rolloutcommon.ROLLOUT_STATE_RUNNING},
+		map[string]interface{}{"state": rolloutcommon.ROLLOUT_STATE_PAUSED})
+}
+
+// ResumeAgentUpgradeRollout goes on with the paused rollout, the failed agents of the current wave are upgraded again
+func ResumeAgentUpgradeRollout(db *metadb.DB, lcuuid string) (*model.AgentUpgradeRollout, error) {
+	var dbRollout metadbmodel.AgentUpgradeRollout
+	if err := db.Where("lcuuid = ?", lcuuid).First(&dbRollout).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("agent upgrade rollout (%s) not found", lcuuid))
+		}
+		return nil, err
+	}
+	state := rolloutcommon.ROLLOUT_STATE_RUNNING
+	if dbRollout.StartedAt == nil {
+		state = rolloutcommon.ROLLOUT_STATE_PENDING
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&metadbmodel.AgentUpgradeRollout{}).Where(
+			"id = ? AND state = ?", dbRollout.ID, rolloutcommon.ROLLOUT_STATE_PAUSED,
+		).Updates(map[string]interface{}{"state": state, "error_message": ""})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return response.ServiceError(httpcommon.INVALID_PARAMETERS, fmt.Sprintf(
+				"agent upgrade rollout (%s) is %s, only paused rollout can be resumed", lcuuid, rolloutcommon.RolloutStateName[dbRollout.State]))
+		}
+		return tx.Model(&metadbmodel.AgentUpgradeRolloutAgent{}).Where(
+			"rollout_id = ? AND wave = ? AND state = ?", dbRollout.ID, dbRollout.CurrentWave, rolloutcommon.AGENT_STATE_FAILED,
+		).Updates(map[string]interface{}{"state": rolloutcommon.AGENT_STATE_WAITING, "message": "", "reconnected_at": nil}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("resume agent upgrade rollout (%d)", dbRollout.ID, db.LogPrefixORGID)
+	return GetAgentUpgradeRollout(db, lcuuid)
+}
+
+// CancelAgentUpgradeRollout stops the rollout, the agents already upgraded are kept, roll back the rollout to
+// upgrade them back to their previous images
+func CancelAgentUpgradeRollout(db *metadb.DB, lcuuid string) (*model.AgentUpgradeRollout, error) {
+	return transitAgentUpgradeRollout(db, lcuuid, "cancel", activeRolloutStates,
+		map[string]interface{}{"state": rolloutcommon.ROLLOUT_STATE_CANCELLED, "finished_at": time.Now()})
+}
+
+// RollbackAgentUpgradeRollout upgrades the agents upgraded by the rollout back to their previous images
+func RollbackAgentUpgradeRollout(db *metadb.DB, lcuuid string) (*model.AgentUpgradeRollout, error) {
+	return transitAgentUpgradeRollout(db, lcuuid, "roll back",
+		[]int{
+			rolloutcommon.ROLLOUT_STATE_RUNNING, rolloutcommon.ROLLOUT_STATE_PAUSED, rolloutcommon.ROLLOUT_STATE_FINISHED,
+			rolloutcommon.ROLLOUT_STATE_FAILED, rolloutcommon.ROLLOUT_STATE_CANCELLED,
+		},
+		map[string]interface{}{"state": rolloutcommon.ROLLOUT_STATE_ROLLING_BACK, "finished_at": nil})
+}
+
+func DeleteAgentUpgradeRollout(db *metadb.DB, lcuuid string) (map[string]string, error) {
+	var dbRollout metadbmodel.AgentUpgradeRollout
+	if err := db.Where("lcuuid = ?", lcuuid).First(&dbRollout).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("agent upgrade rollout (%s) not found", lcuuid))
+		}
+		return nil, err
+	}
+	for _, state := range activeRolloutStates {
+		if dbRollout.State == state {
+			return nil, response.ServiceError(httpcommon.INVALID_PARAMETERS,
+				fmt.Sprintf("agent upgrade rollout (%s) is %s, cancel it first", lcuuid, rolloutcommon.RolloutStateName[state]))
+		}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("rollout_id = ?", dbRollout.ID).Delete(&metadbmodel.AgentUpgradeRolloutAgent{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&dbRollout).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("delete agent upgrade rollout (%d)", dbRollout.ID, db.LogPrefixORGID)
+	return map[string]string{"LCUUID": lcuuid}, nil
+}
+
+func transitAgentUpgradeRollout(db *metadb.DB, lcuuid, action string, from []int, values map[string]interface{}) (*model.AgentUpgradeRollout, error) {
+	var dbRollout metadbmodel.AgentUpgradeRollout
+	if err := db.Where("lcuuid = ?", lcuuid).First(&dbRollout).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("agent upgrade rollout (%s) not found", lcuuid))
+		}
+		return nil, err
+	}
+	result := db.Model(&metadbmodel.AgentUpgradeRollout{}).Where("id = ? AND state IN ?", dbRollout.ID, from).Updates(values)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, response.ServiceError(httpcommon.INVALID_PARAMETERS, fmt.Sprintf(
+			"agent upgrade rollout (%s) is %s, unable to %s", lcuuid, rolloutcommon.RolloutStateName[dbRollout.State], action))
+	}
+	log.Infof("%s agent upgrade rollout (%d)", action, dbRollout.ID, db.LogPrefixORGID)
+	return GetAgentUpgradeRollout(db, lcuuid)
+}
@@ -19,6 +19,8 @@ package service
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -30,31 +32,98 @@ import (
 	"github.com/deepflowio/deepflow/server/controller/model"
 )
 
-func CreatePlugin(db *metadb.DB, pluginCreate *metadbmodel.Plugin) (*model.Plugin, error) {
+// CreatePlugin uploads a new version of the plugin, the version is promoted to agents using the plugin by name
+// if promote is true or it is the first version of the plugin
+func CreatePlugin(db *metadb.DB, pluginCreate *metadbmodel.Plugin, promote bool) (*model.Plugin, error) {
+	if strings.Contains(pluginCreate.Name, common.PLUGIN_VERSION_SEPARATOR) {
+		return nil, response.ServiceError(httpcommon.INVALID_PARAMETERS,
+			fmt.Sprintf("plugin name (%s) can not contain %s", pluginCreate.Name, common.PLUGIN_VERSION_SEPARATOR))
+	}
+	if err := common.CheckPluginVersion(pluginCreate.Version); err != nil {
+		return nil, response.ServiceError(httpcommon.INVALID_PARAMETERS, err.Error())
+	}
+	pluginCreate.SHA256 = common.PluginSHA256(pluginCreate.Image)
+
 	var pluginFirst metadbmodel.Plugin
 	if err := db.Where("name = ?", pluginCreate.Name).First(&pluginFirst).Error; err != nil {
 		if !errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, response.ServiceError(httpcommon.SERVER_ERROR,
 				fmt.Sprintf("fail to query plugin by name(%s), error: %s", pluginCreate.Name, err))
 		}
+		promote = true
+	}
 
-		if err = db.Create(&pluginCreate).Error; err != nil {
-			return nil, err
-		}
-		plugines, _ := GetPlugin(db, map[string]interface{}{"name": pluginCreate.Name})
-		return &plugines[0], nil
+	var versionCount int64
+	if err := db.Model(&metadbmodel.PluginVersion{}).Where("name = ?", pluginCreate.Name).Count(&versionCount).Error; err != nil {
+		return nil, err
+	}
+	if pluginCreate.Version == "" {
+		pluginCreate.Version = fmt.Sprintf("v%d", versionCount+1)
+	}
+	var versionCheck int64
+	db.Model(&metadbmodel.PluginVersion{}).Where("name = ? AND version = ?", pluginCreate.Name, pluginCreate.Version).Count(&versionCheck)
+	if versionCheck > 0 {
+		return nil, response.ServiceError(httpcommon.RESOURCE_ALREADY_EXIST,
+			fmt.Sprintf("plugin (name: %s, version: %s) already exist", pluginCreate.Name, pluginCreate.Version))
 	}
 
-	// update by name and type
-	if err := db.Model(&metadbmodel.Plugin{}).Where("name = ?", pluginCreate.Name).
-		Updates(pluginCreate).Error; err != nil {
+	pluginVersion := &metadbmodel.PluginVersion{
+		Name:      pluginCreate.Name,
+		Type:      pluginCreate.Type,
+		UserName:  pluginCreate.UserName,
+		Version:   pluginCreate.Version,
+		Image:     pluginCreate.Image,
+		SHA256:    pluginCreate.SHA256,
+		Signature: pluginCreate.Signature,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(pluginVersion).Error; err != nil {
+			return err
+		}
+		if !promote {
+			return nil
+		}
+		return promotePluginVersion(tx, pluginVersion)
+	})
+	if err != nil {
 		return nil, err
 	}
+	log.Infof("create plugin (name: %s, version: %s, sha256: %s, promoted: %t)",
+		pluginCreate.Name, pluginCreate.Version, pluginCreate.SHA256, promote, db.LogPrefixORGID)
 
 	plugins, _ := GetPlugin(db, map[string]interface{}{"name": pluginCreate.Name})
+	if len(plugins) == 0 {
+		return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("plugin (name: %s) not found", pluginCreate.Name))
+	}
 	return &plugins[0], nil
 }
 
+// promotePluginVersion copies the version to the plugin, which is sent to agents using the plugin by name
+func promotePluginVersion(tx *gorm.DB, pluginVersion *metadbmodel.PluginVersion) error {
+	now := time.Now()
+	plugin := &metadbmodel.Plugin{
+		Name:      pluginVersion.Name,
+		Type:      pluginVersion.Type,
+		UserName:  pluginVersion.UserName,
+		Image:     pluginVersion.Image,
+		Version:   pluginVersion.Version,
+		SHA256:    pluginVersion.SHA256,
+		Signature: pluginVersion.Signature,
+		UpdatedAt: now,
+	}
+	result := tx.Model(&metadbmodel.Plugin{}).Where("name = ?", pluginVersion.Name).
+		Select("type", "user_name", "image", "version", "sha256", "signature", "updated_at").Updates(plugin)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if err := tx.Create(plugin).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&metadbmodel.PluginVersion{}).Where("id = ?", pluginVersion.ID).Update("promoted_at", now).Error
+}
+
 func GetPlugin(db *metadb.DB, filter map[string]interface{}) ([]model.Plugin, error) {
 	var plugins []metadbmodel.Plugin
 	queryDB := db.DB
@@ -73,11 +142,108 @@ func GetPlugin(db *metadb.DB, filter map[string]interface{}) ([]model.Plugin, er
 			Type:      plugin.Type,
 			UpdatedAt: plugin.UpdatedAt.Format(common.GO_BIRTHDAY),
 			UserName:  plugin.UserName,
+			Version:   plugin.Version,
+			SHA256:    plugin.SHA256,
+		}
+		resp = append(resp, temp)
+	}
+	return resp, nil
+
+}
+
+func GetPluginVersions(db *metadb.DB, name string) ([]model.PluginVersion, error) {
+	var plugin metadbmodel.Plugin
+	if err := db.Select("name", "version").Where("name = ?", name).First(&plugin).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("plugin (name: %s) not found", name))
+		}
+		return nil, err
+	}
+	var pluginVersions []metadbmodel.PluginVersion
+	if err := db.Omit("image").Where("name = ?", name).Order("created_at DESC, id DESC").Find(&pluginVersions).Error; err != nil {
+		return nil, err
+	}
+
+	resp := make([]model.PluginVersion, 0, len(pluginVersions))
+	for _, pluginVersion := range pluginVersions {
+		temp := model.PluginVersion{
+			Name:      pluginVersion.Name,
+			Type:      pluginVersion.Type,
+			UserName:  pluginVersion.UserName,
+			Version:   pluginVersion.Version,
+			SHA256:    pluginVersion.SHA256,
+			Signature: pluginVersion.Signature,
+			Promoted:  pluginVersion.Version == plugin.Version,
+			CreatedAt: pluginVersion.CreatedAt.Format(common.GO_BIRTHDAY),
+		}
+		if pluginVersion.PromotedAt != nil {
+			temp.PromotedAt = pluginVersion.PromotedAt.Format(common.GO_BIRTHDAY)
 		}
 		resp = append(resp, temp)
 	}
 	return resp, nil
+}
+
+// PromotePlugin sends the version to agents using the plugin by name, agents pinning a version are not affected
+func PromotePlugin(db *metadb.DB, name, version string) (*model.Plugin, error) {
+	var pluginVersion metadbmodel.PluginVersion
+	if err := db.Where("name = ? AND version = ?", name, version).First(&pluginVersion).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND,
+				fmt.Sprintf("plugin (name: %s, version: %s) not found", name, version))
+		}
+		return nil, err
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return promotePluginVersion(tx, &pluginVersion)
+	}); err != nil {
+		return nil, err
+	}
+	log.Infof("promote plugin (name: %s, version: %s)", name, version, db.LogPrefixORGID)
+
+	plugins, _ := GetPlugin(db, map[string]interface{}{"name": name})
+	if len(plugins) == 0 {
+		return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("plugin (name: %s) not found", name))
+	}
+	return &plugins[0], nil
+}
+
+// RollbackPlugin promotes the version promoted before the current one again, the current version is
+// dropped from the promotion history so that rolling back repeatedly walks back through it
+func RollbackPlugin(db *metadb.DB, name string) (*model.Plugin, error) {
+	var plugin metadbmodel.Plugin
+	if err := db.Select("name", "version").Where("name = ?", name).First(&plugin).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("plugin (name: %s) not found", name))
+		}
+		return nil, err
+	}
+	var previous metadbmodel.PluginVersion
+	if err := db.Where("name = ? AND version != ? AND promoted_at IS NOT NULL", name, plugin.Version).
+		Order("promoted_at DESC, id DESC").First(&previous).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ServiceError(httpcommon.INVALID_PARAMETERS,
+				fmt.Sprintf("plugin (name: %s) has no version promoted before %s", name, plugin.Version))
+		}
+		return nil, err
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&metadbmodel.PluginVersion{}).Where("name = ? AND version = ?", name, plugin.Version).
+			Update("promoted_at", nil).Error; err != nil {
+			return err
+		}
+		return promotePluginVersion(tx, &previous)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("roll back plugin (name: %s) from version %s to %s", name, plugin.Version, previous.Version, db.LogPrefixORGID)
 
+	plugins, _ := GetPlugin(db, map[string]interface{}{"name": name})
+	if len(plugins) == 0 {
+		return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("plugin (name: %s) not found", name))
+	}
+	return &plugins[0], nil
 }
 
 func DeletePlugin(db *metadb.DB, name string) error {
@@ -86,7 +252,13 @@ func DeletePlugin(db *metadb.DB, name string) error {
 		return response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("plugin (name: %s) not found", name))
 	}
 
-	if err := db.Where("name = ?", name).Delete(&metadbmodel.Plugin{}).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("name = ?", name).Delete(&metadbmodel.PluginVersion{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("name = ?", name).Delete(&metadbmodel.Plugin{}).Error
+	})
+	if err != nil {
 		return response.ServiceError(httpcommon.SERVER_ERROR, fmt.Sprintf("delete plugin (name: %s) failed, err: %v", name, err))
 	}
 	return nil
@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	prommodel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gorm.io/gorm"
+
+	"github.com/deepflowio/deepflow/server/controller/common"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/model"
+)
+
+func GetPrometheusRecordingRuleGroups(db *metadb.DB, filter map[string]interface{}) ([]model.PrometheusRecordingRuleGroup, error) {
+	queryDB := db.DB
+	for _, field := range []string{"lcuuid", "name"} {
+		if v, ok := filter[field]; ok {
+			queryDB = queryDB.Where(fmt.Sprintf("%s = ?", field), v)
+		}
+	}
+	var dbGroups []metadbmodel.PrometheusRecordingRuleGroup
+	if err := queryDB.Order("id").Find(&dbGroups).Error; err != nil {
+		return nil, err
+	}
+	resp := make([]model.PrometheusRecordingRuleGroup, 0, len(dbGroups))
+	for _, g := range dbGroups {
+		group := model.PrometheusRecordingRuleGroup{
+			ID:             g.ID,
+			Name:           g.Name,
+			EvalInterval:   g.EvalInterval,
+			QueryOffset:    g.QueryOffset,
+			RemoteWriteURL: g.RemoteWriteURL,
+			Rules:          []model.PrometheusRecordingRule{},
+			CreatedAt:      g.CreatedAt.Format(common.GO_BIRTHDAY),
+			UpdatedAt:      g.UpdatedAt.Format(common.GO_BIRTHDAY),
+			Lcuuid:         g.Lcuuid,
+		}
+		if g.Rules != "" {
+			if err := json.Unmarshal([]byte(g.Rules), &group.Rules); err != nil {
+				log.Errorf("unmarshal rules of prometheus recording rule group (%s) failed: %s", g.Name, err.Error(), db.LogPrefixORGID)
+			}
+		}
+		resp = append(resp, group)
+	}
+	return resp, nil
+}
+
+func CreatePrometheusRecordingRuleGroup(db *metadb.DB, groupCreate model.PrometheusRecordingRuleGroupCreate) (*model.PrometheusRecordingRuleGroup, error) {
+	if err := validatePrometheusRecordingRuleGroup(groupCreate.EvalInterval, groupCreate.QueryOffset, groupCreate.Rules); err != nil {
+		return nil, err
+	}
+	var count int64
+	db.Model(&metadbmodel.PrometheusRecordingRuleGroup{}).Where("name = ?", groupCreate.Name).Count(&count)
+	if count > 0 {
+		return nil, response.ServiceError(httpcommon.RESOURCE_ALREADY_EXIST,
+			fmt.Sprintf("prometheus recording rule group (%s) already exists", groupCreate.Name))
+	}
+	rules, err := json.Marshal(groupCreate.Rules)
+	if err != nil {
+		return nil, err
+	}
+	dbGroup := metadbmodel.PrometheusRecordingRuleGroup{
+		Name:           groupCreate.Name,
+		EvalInterval:   groupCreate.EvalInterval,
+		QueryOffset:    groupCreate.QueryOffset,
+		RemoteWriteURL: groupCreate.RemoteWriteURL,
+		Rules:          string(rules),
+		Lcuuid:         uuid.New().String(),
+	}
+	if err := db.Create(&dbGroup).Error; err != nil {
+		return nil, err
+	}
+	log.Infof("create prometheus recording rule group (%s) with %d rules", dbGroup.Name, len(groupCreate.Rules), db.LogPrefixORGID)
+	return getPrometheusRecordingRuleGroup(db, dbGroup.Lcuuid)
+}
+
+func UpdatePrometheusRecordingRuleGroup(db *metadb.DB, lcuuid string, groupUpdate model.PrometheusRecordingRuleGroupUpdate) (*model.PrometheusRecordingRuleGroup, error) {
+	group, err := getPrometheusRecordingRuleGroup(db, lcuuid)
+	if err != nil {
+		return nil, err
+	}
+	updates := map[string]interface{}{}
+	if groupUpdate.EvalInterval != nil {
+		group.EvalInterval = *groupUpdate.EvalInterval
+		updates["eval_interval"] = group.EvalInterval
+	}
+	if groupUpdate.QueryOffset != nil {
+		group.QueryOffset = *groupUpdate.QueryOffset
+		updates["query_offset"] = group.QueryOffset
+	}
+	if groupUpdate.RemoteWriteURL != nil {
+		updates["remote_write_url"] = *groupUpdate.RemoteWriteURL
+	}
+	if groupUpdate.Rules != nil {
+		group.Rules = *groupUpdate.Rules
+		rules, err := json.Marshal(group.Rules)
+		if err != nil {
+			return nil, err
+		}
+		updates["rules"] = string(rules)
+	}
+	if err := validatePrometheusRecordingRuleGroup(group.EvalInterval, group.QueryOffset, group.Rules); err != nil {
+		return nil, err
+	}
+	if len(updates) == 0 {
+		return group, nil
+	}
+	if err := db.Model(&metadbmodel.PrometheusRecordingRuleGroup{}).Where("lcuuid = ?", lcuuid).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	log.Infof("update prometheus recording rule group (%s): %v", group.Name, updates, db.LogPrefixORGID)
+	return getPrometheusRecordingRuleGroup(db, lcuuid)
+}
+
+func DeletePrometheusRecordingRuleGroup(db *metadb.DB, lcuuid string) (map[string]string, error) {
+	group, err := getPrometheusRecordingRuleGroup(db, lcuuid)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Where("lcuuid = ?", lcuuid).Delete(&metadbmodel.PrometheusRecordingRuleGroup{}).Error; err != nil {
+		return nil, err
+	}
+	log.Infof("delete prometheus recording rule group (%s)", group.Name, db.LogPrefixORGID)
+	return map[string]string{"LCUUID": lcuuid}, nil
+}
+
+func getPrometheusRecordingRuleGroup(db *metadb.DB, lcuuid string) (*model.PrometheusRecordingRuleGroup, error) {
+	var dbGroup metadbmodel.PrometheusRecordingRuleGroup
+	if err := db.Where("lcuuid = ?", lcuuid).First(&dbGroup).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND,
+				fmt.Sprintf("prometheus recording rule group (%s) not found", lcuuid))
+		}
+		return nil, err
+	}
+	groups, err := GetPrometheusRecordingRuleGroups(db, map[string]interface{}{"lcuuid": lcuuid})
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+	return &groups[0], nil
+}
+
+func validatePrometheusRecordingRuleGroup(evalInterval, queryOffset int, rules []model.PrometheusRecordingRule) error {
+	if evalInterval < 0 || queryOffset < 0 {
+		return response.ServiceError(httpcommon.INVALID_POST_DATA, "EVAL_INTERVAL and QUERY_OFFSET must not be negative")
+	}
+	if len(rules) == 0 {
+		return response.ServiceError(httpcommon.INVALID_POST_DATA, "RULES must not be empty")
+	}
+	for _, rule := range rules {
+		if !prommodel.IsValidMetricName(prommodel.LabelValue(rule.Record)) {
+			return response.ServiceError(httpcommon.INVALID_POST_DATA, fmt.Sprintf("invalid metric name (%s) of RECORD", rule.Record))
+		}
+		for name := range rule.Labels {
+			if !prommodel.LabelName(name).IsValid() || name == prommodel.MetricNameLabel {
+				return response.ServiceError(httpcommon.INVALID_POST_DATA, fmt.Sprintf("invalid label name (%s) of rule (%s)", name, rule.Record))
+			}
+		}
+		if _, err := parser.ParseExpr(rule.Expr); err != nil {
+			return response.ServiceError(httpcommon.INVALID_POST_DATA, fmt.Sprintf("invalid EXPR of rule (%s): %s", rule.Record, err.Error()))
+		}
+	}
+	return nil
+}
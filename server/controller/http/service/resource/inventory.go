@@ -0,0 +1,610 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/deepflowio/deepflow/server/controller/common"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/model"
+)
+
+const (
+	INVENTORY_POD             = "pods"
+	INVENTORY_POD_NODE        = "pod-nodes"
+	INVENTORY_POD_SERVICE     = "pod-services"
+	INVENTORY_POD_GROUP       = "pod-groups"
+	INVENTORY_POD_NAMESPACE   = "pod-namespaces"
+	INVENTORY_POD_CLUSTER     = "pod-clusters"
+	INVENTORY_VM              = "vms"
+	INVENTORY_HOST            = "hosts"
+	INVENTORY_VPC             = "vpcs"
+	INVENTORY_NETWORK         = "networks"
+	INVENTORY_SUBNET          = "subnets"
+	INVENTORY_LB              = "lbs"
+	INVENTORY_NAT_GATEWAY     = "nat-gateways"
+	INVENTORY_VINTERFACE      = "vinterfaces"
+	INVENTORY_LAN_IP          = "lan-ips"
+	INVENTORY_WAN_IP          = "wan-ips"
+	INVENTORY_RELATION_DEVICE = "device"
+)
+
+type inventoryRelationType int
+
+const (
+	inventoryRelationBelongTo  inventoryRelationType = iota // source holds the related id
+	inventoryRelationHasMany                                // related objects hold the source id
+	inventoryRelationVMPodNode                              // vm <-> pod_node through vm_pod_node_connection
+	inventoryRelationDevice                                 // vinterface device, resource decided by device type
+)
+
+type inventoryRelation struct {
+	Type     inventoryRelationType
+	Resource string
+	Field    string // json field holding the foreign id
+}
+
+type inventoryResource struct {
+	newModel   func() interface{}
+	labelField string   // json field used by label selector, empty means unsupported
+	hidden     []string // json fields never returned
+	relations  map[string]inventoryRelation
+}
+
+func belongTo(resource, field string) inventoryRelation {
+	return inventoryRelation{Type: inventoryRelationBelongTo, Resource: resource, Field: field}
+}
+
+func hasMany(resource, field string) inventoryRelation {
+	return inventoryRelation{Type: inventoryRelationHasMany, Resource: resource, Field: field}
+}
+
+var inventoryResources = map[string]*inventoryResource{
+	INVENTORY_POD: {
+		newModel:   func() interface{} { return &metadbmodel.Pod{} },
+		labelField: "LABEL",
+		relations: map[string]inventoryRelation{
+			"pod_node":      belongTo(INVENTORY_POD_NODE, "POD_NODE_ID"),
+			"pod_group":     belongTo(INVENTORY_POD_GROUP, "POD_GROUP_ID"),
+			"pod_service":   belongTo(INVENTORY_POD_SERVICE, "POD_SERVICE_ID"),
+			"pod_namespace": belongTo(INVENTORY_POD_NAMESPACE, "POD_NAMESPACE_ID"),
+			"pod_cluster":   belongTo(INVENTORY_POD_CLUSTER, "POD_CLUSTER_ID"),
+			"vpc":           belongTo(INVENTORY_VPC, "VPC_ID"),
+		},
+	},
+	INVENTORY_POD_NODE: {
+		newModel: func() interface{} { return &metadbmodel.PodNode{} },
+		relations: map[string]inventoryRelation{
+			"vm":          {Type: inventoryRelationVMPodNode, Resource: INVENTORY_VM},
+			"pod_cluster": belongTo(INVENTORY_POD_CLUSTER, "POD_CLUSTER_ID"),
+			"vpc":         belongTo(INVENTORY_VPC, "VPC_ID"),
+			"pods":        hasMany(INVENTORY_POD, "POD_NODE_ID"),
+		},
+	},
+	INVENTORY_POD_SERVICE: {
+		newModel:   func() interface{} { return &metadbmodel.PodService{} },
+		labelField: "LABEL",
+		relations: map[string]inventoryRelation{
+			"pod_namespace": belongTo(INVENTORY_POD_NAMESPACE, "POD_NAMESPACE_ID"),
+			"pod_cluster":   belongTo(INVENTORY_POD_CLUSTER, "POD_CLUSTER_ID"),
+			"vpc":           belongTo(INVENTORY_VPC, "VPC_ID"),
+			"pods":          hasMany(INVENTORY_POD, "POD_SERVICE_ID"),
+		},
+	},
+	INVENTORY_POD_GROUP: {
+		newModel:   func() interface{} { return &metadbmodel.PodGroup{} },
+		labelField: "LABEL",
+		relations: map[string]inventoryRelation{
+			"pod_namespace": belongTo(INVENTORY_POD_NAMESPACE, "POD_NAMESPACE_ID"),
+			"pod_cluster":   belongTo(INVENTORY_POD_CLUSTER, "POD_CLUSTER_ID"),
+			"pods":          hasMany(INVENTORY_POD, "POD_GROUP_ID"),
+		},
+	},
+	INVENTORY_POD_NAMESPACE: {
+		newModel:   func() interface{} { return &metadbmodel.PodNamespace{} },
+		labelField: "CLOUD_TAGS",
+		relations: map[string]inventoryRelation{
+			"pod_cluster": belongTo(INVENTORY_POD_CLUSTER, "POD_CLUSTER_ID"),
+		},
+	},
+	INVENTORY_POD_CLUSTER: {
+		newModel: func() interface{} { return &metadbmodel.PodCluster{} },
+		relations: map[string]inventoryRelation{
+			"vpc":       belongTo(INVENTORY_VPC, "VPC_ID"),
+			"pod_nodes": hasMany(INVENTORY_POD_NODE, "POD_CLUSTER_ID"),
+		},
+	},
+	INVENTORY_VM: {
+		newModel:   func() interface{} { return &metadbmodel.VM{} },
+		labelField: "CLOUD_TAGS",
+		relations: map[string]inventoryRelation{
+			"host":     belongTo(INVENTORY_HOST, "HOST_ID"),
+			"vpc":      belongTo(INVENTORY_VPC, "EPC_ID"),
+			"network":  belongTo(INVENTORY_NETWORK, "VL2ID"),
+			"pod_node": {Type: inventoryRelationVMPodNode, Resource: INVENTORY_POD_NODE},
+		},
+	},
+	INVENTORY_HOST: {
+		newModel: func() interface{} { return &metadbmodel.Host{} },
+		hidden:   []string{"USER_NAME", "USER_PASSWD"},
+		relations: map[string]inventoryRelation{
+			"vms": hasMany(INVENTORY_VM, "HOST_ID"),
+		},
+	},
+	INVENTORY_VPC: {
+		newModel: func() interface{} { return &metadbmodel.VPC{} },
+		relations: map[string]inventoryRelation{
+			"networks": hasMany(INVENTORY_NETWORK, "VPC_ID"),
+		},
+	},
+	INVENTORY_NETWORK: {
+		newModel: func() interface{} { return &metadbmodel.Network{} },
+		relations: map[string]inventoryRelation{
+			"vpc":     belongTo(INVENTORY_VPC, "VPC_ID"),
+			"subnets": hasMany(INVENTORY_SUBNET, "VL2ID"),
+		},
+	},
+	INVENTORY_SUBNET: {
+		newModel: func() interface{} { return &metadbmodel.Subnet{} },
+		relations: map[string]inventoryRelation{
+			"network": belongTo(INVENTORY_NETWORK, "VL2ID"),
+		},
+	},
+	INVENTORY_LB: {
+		newModel: func() interface{} { return &metadbmodel.LB{} },
+		relations: map[string]inventoryRelation{
+			"vpc": belongTo(INVENTORY_VPC, "EPC_ID"),
+		},
+	},
+	INVENTORY_NAT_GATEWAY: {
+		newModel: func() interface{} { return &metadbmodel.NATGateway{} },
+		relations: map[string]inventoryRelation{
+			"vpc": belongTo(INVENTORY_VPC, "EPC_ID"),
+		},
+	},
+	INVENTORY_VINTERFACE: {
+		newModel: func() interface{} { return &metadbmodel.VInterface{} },
+		relations: map[string]inventoryRelation{
+			INVENTORY_RELATION_DEVICE: {Type: inventoryRelationDevice},
+			"network":                 belongTo(INVENTORY_NETWORK, "SUBNET_ID"),
+			"vpc":                     belongTo(INVENTORY_VPC, "VPC_ID"),
+			"lan_ips":                 hasMany(INVENTORY_LAN_IP, "VINTERFACE_ID"),
+			"wan_ips":                 hasMany(INVENTORY_WAN_IP, "VINTERFACE_ID"),
+		},
+	},
+	INVENTORY_LAN_IP: {
+		newModel: func() interface{} { return &metadbmodel.LANIP{} },
+		relations: map[string]inventoryRelation{
+			"vinterface": belongTo(INVENTORY_VINTERFACE, "VINTERFACE_ID"),
+			"network":    belongTo(INVENTORY_NETWORK, "VL2ID"),
+			"subnet":     belongTo(INVENTORY_SUBNET, "SUBNET_ID"),
+		},
+	},
+	INVENTORY_WAN_IP: {
+		newModel: func() interface{} { return &metadbmodel.WANIP{} },
+		relations: map[string]inventoryRelation{
+			"vinterface": belongTo(INVENTORY_VINTERFACE, "VINTERFACE_ID"),
+			"subnet":     belongTo(INVENTORY_SUBNET, "SUBNET_ID"),
+		},
+	},
+}
+
+// inventoryDeviceResources maps vinterface device types to inventory resources.
+var inventoryDeviceResources = map[int]string{
+	common.VIF_DEVICE_TYPE_VM:          INVENTORY_VM,
+	common.VIF_DEVICE_TYPE_HOST:        INVENTORY_HOST,
+	common.VIF_DEVICE_TYPE_POD:         INVENTORY_POD,
+	common.VIF_DEVICE_TYPE_POD_SERVICE: INVENTORY_POD_SERVICE,
+	common.VIF_DEVICE_TYPE_POD_NODE:    INVENTORY_POD_NODE,
+	common.VIF_DEVICE_TYPE_LB:          INVENTORY_LB,
+	common.VIF_DEVICE_TYPE_NAT_GATEWAY: INVENTORY_NAT_GATEWAY,
+}
+
+func (r *inventoryResource) relationNames() []string {
+	names := make([]string, 0, len(r.relations))
+	for name := range r.relations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetInventoryResources lists the resources supported by the inventory api.
+func GetInventoryResources() []model.InventoryResource {
+	resp := make([]model.InventoryResource, 0, len(inventoryResources))
+	for name, r := range inventoryResources {
+		resp = append(resp, model.InventoryResource{
+			Name:          name,
+			Relations:     r.relationNames(),
+			LabelSelector: r.labelField != "",
+		})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
+	return resp
+}
+
+type inventoryObject = map[string]interface{}
+
+type inventory struct {
+	db                *gorm.DB
+	excludeDomains    []string
+	excludeSubDomains []string
+	includeDeleted    bool
+}
+
+// GetInventory lists resources of one kind, scoped by the teams the user is authorized to.
+// Field filters are pushed down to metadb, label selectors are evaluated in memory, so
+// pagination happens in metadb only when there is no label selector.
+func GetInventory(orgDB *metadb.DB, excludeTeamIDs []int, resourceName string, query *model.InventoryQuery) ([]inventoryObject, *response.Page, error) {
+	page := &response.Page{}
+	res, ok := inventoryResources[resourceName]
+	if !ok {
+		return nil, page, response.ServiceError(httpcommon.INVALID_PARAMETERS, fmt.Sprintf("unsupported inventory resource (%s)", resourceName))
+	}
+	var selector LabelSelector
+	if query.LabelSelector != "" {
+		if res.labelField == "" {
+			return nil, page, response.ServiceError(httpcommon.INVALID_PARAMETERS, fmt.Sprintf("resource (%s) does not support label selector", resourceName))
+		}
+		var err error
+		if selector, err = ParseLabelSelector(query.LabelSelector); err != nil {
+			return nil, page, response.ServiceError(httpcommon.INVALID_PARAMETERS, err.Error())
+		}
+	}
+	expand, err := ParseExpand(query.Expand)
+	if err != nil {
+		return nil, page, response.ServiceError(httpcommon.INVALID_PARAMETERS, err.Error())
+	}
+
+	inv := &inventory{db: orgDB.DB, includeDeleted: query.IncludeDeleted}
+	if err := inv.loadExcludedDomains(excludeTeamIDs); err != nil {
+		return nil, page, err
+	}
+
+	db, err := inv.filter(res, query.Filters)
+	if err != nil {
+		return nil, page, err
+	}
+	page = response.NewPage(query.PageIndex, query.PageSize)
+	if len(selector) == 0 && page.IsValid() {
+		var count int64
+		if err := db.Model(res.newModel()).Count(&count).Error; err != nil {
+			return nil, page, err
+		}
+		start, end := page.Fill(int(count))
+		db = db.Offset(start).Limit(end - start)
+		if end == start {
+			return []inventoryObject{}, page, nil
+		}
+	}
+	objects, err := inv.find(res, db.Order("id"))
+	if err != nil {
+		return nil, page, err
+	}
+	if len(selector) != 0 {
+		matched := make([]inventoryObject, 0, len(objects))
+		for _, obj := range objects {
+			if selector.Matches(parseLabels(obj[res.labelField])) {
+				matched = append(matched, obj)
+			}
+		}
+		objects = matched
+		if page.IsValid() {
+			start, end := page.Fill(len(objects))
+			objects = objects[start:end]
+		}
+	}
+	if err := inv.expand(res, objects, expand); err != nil {
+		return nil, page, err
+	}
+	return objects, page, nil
+}
+
+// loadExcludedDomains converts unauthorized teams into domains and sub domains,
+// as resources are bound to teams only through them.
+func (i *inventory) loadExcludedDomains(excludeTeamIDs []int) error {
+	if len(excludeTeamIDs) == 0 {
+		return nil
+	}
+	if err := i.db.Model(&metadbmodel.Domain{}).Where("team_id IN ?", excludeTeamIDs).Pluck("lcuuid", &i.excludeDomains).Error; err != nil {
+		return err
+	}
+	return i.db.Model(&metadbmodel.SubDomain{}).Where("team_id IN ?", excludeTeamIDs).Pluck("lcuuid", &i.excludeSubDomains).Error
+}
+
+// columns maps both db column names and lower case json names to db column names.
+func (i *inventory) columns(res *inventoryResource) (map[string]string, error) {
+	stmt := &gorm.Statement{DB: i.db}
+	if err := stmt.Parse(res.newModel()); err != nil {
+		return nil, err
+	}
+	hidden := make(map[string]struct{}, len(res.hidden))
+	for _, h := range res.hidden {
+		hidden[h] = struct{}{}
+	}
+	columns := make(map[string]string, len(stmt.Schema.Fields)*2)
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+		if _, ok := hidden[jsonName]; ok {
+			continue
+		}
+		columns[field.DBName] = field.DBName
+		if jsonName != "" && jsonName != "-" {
+			columns[strings.ToLower(jsonName)] = field.DBName
+		}
+	}
+	return columns, nil
+}
+
+func (i *inventory) scope(columns map[string]string) *gorm.DB {
+	db := i.db
+	if i.includeDeleted {
+		db = db.Unscoped()
+	}
+	if _, ok := columns["domain"]; ok && len(i.excludeDomains) != 0 {
+		db = db.Where("domain NOT IN ?", i.excludeDomains)
+	}
+	if _, ok := columns["sub_domain"]; ok && len(i.excludeSubDomains) != 0 {
+		db = db.Where("sub_domain NOT IN ?", i.excludeSubDomains)
+	}
+	return db
+}
+
+func (i *inventory) filter(res *inventoryResource, filters map[string][]string) (*gorm.DB, error) {
+	columns, err := i.columns(res)
+	if err != nil {
+		return nil, err
+	}
+	db := i.scope(columns)
+	keys := make([]string, 0, len(filters))
+	for key := range filters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		column, ok := columns[strings.ToLower(key)]
+		if !ok {
+			return nil, response.ServiceError(httpcommon.INVALID_PARAMETERS, fmt.Sprintf("unsupported filter field (%s)", key))
+		}
+		var values []string
+		for _, v := range filters[key] {
+			values = append(values, strings.Split(v, ",")...)
+		}
+		if len(values) == 1 {
+			db = db.Where(fmt.Sprintf("%s = ?", column), values[0])
+		} else {
+			db = db.Where(fmt.Sprintf("%s IN ?", column), values)
+		}
+	}
+	// new session so that the count and find queries do not share conditions
+	return db.Session(&gorm.Session{}), nil
+}
+
+// find loads rows of the resource model and converts them into json objects,
+// so that related objects can be attached without knowing the model type.
+func (i *inventory) find(res *inventoryResource, db *gorm.DB) ([]inventoryObject, error) {
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(res.newModel())))
+	if err := db.Find(rows.Interface()).Error; err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(rows.Elem().Interface())
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]inventoryObject, 0, rows.Elem().Len())
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&objects); err != nil {
+		return nil, err
+	}
+	for _, obj := range objects {
+		for _, h := range res.hidden {
+			delete(obj, h)
+		}
+	}
+	return objects, nil
+}
+
+func (i *inventory) findBy(resourceName, field string, ids []int) ([]inventoryObject, error) {
+	res := inventoryResources[resourceName]
+	if len(ids) == 0 {
+		return []inventoryObject{}, nil
+	}
+	columns, err := i.columns(res)
+	if err != nil {
+		return nil, err
+	}
+	column, ok := columns[strings.ToLower(field)]
+	if !ok {
+		return nil, fmt.Errorf("resource (%s) has no field (%s)", resourceName, field)
+	}
+	return i.find(res, i.scope(columns).Where(fmt.Sprintf("%s IN ?", column), ids).Order("id"))
+}
+
+func (i *inventory) findByIDs(resourceName string, ids []int) (map[int]inventoryObject, error) {
+	objects, err := i.findBy(resourceName, "ID", ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]inventoryObject, len(objects))
+	for _, obj := range objects {
+		result[objectInt(obj, "ID")] = obj
+	}
+	return result, nil
+}
+
+func (i *inventory) expand(res *inventoryResource, objects []inventoryObject, tree ExpandTree) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	for _, name := range tree.Keys() {
+		relation, ok := res.relations[name]
+		if !ok {
+			return response.ServiceError(httpcommon.INVALID_PARAMETERS, fmt.Sprintf("unsupported expand (%s), supported: %s", name, strings.Join(res.relationNames(), ", ")))
+		}
+		key := strings.ToUpper(name)
+		switch relation.Type {
+		case inventoryRelationBelongTo:
+			related, err := i.findByIDs(relation.Resource, objectInts(objects, relation.Field))
+			if err != nil {
+				return err
+			}
+			if err := i.expand(inventoryResources[relation.Resource], mapValues(related), tree[name]); err != nil {
+				return err
+			}
+			for _, obj := range objects {
+				obj[key] = related[objectInt(obj, relation.Field)]
+			}
+		case inventoryRelationHasMany:
+			related, err := i.findBy(relation.Resource, relation.Field, objectInts(objects, "ID"))
+			if err != nil {
+				return err
+			}
+			if err := i.expand(inventoryResources[relation.Resource], related, tree[name]); err != nil {
+				return err
+			}
+			grouped := make(map[int][]inventoryObject)
+			for _, r := range related {
+				id := objectInt(r, relation.Field)
+				grouped[id] = append(grouped[id], r)
+			}
+			for _, obj := range objects {
+				if children, ok := grouped[objectInt(obj, "ID")]; ok {
+					obj[key] = children
+				} else {
+					obj[key] = []inventoryObject{}
+				}
+			}
+		case inventoryRelationVMPodNode:
+			if err := i.expandVMPodNode(objects, relation, key, tree[name]); err != nil {
+				return err
+			}
+		case inventoryRelationDevice:
+			if len(tree[name]) != 0 {
+				return response.ServiceError(httpcommon.INVALID_PARAMETERS, fmt.Sprintf("expand (%s) can not be nested", name))
+			}
+			if err := i.expandDevice(objects, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (i *inventory) expandVMPodNode(objects []inventoryObject, relation inventoryRelation, key string, tree ExpandTree) error {
+	fromColumn := "pod_node_id"
+	if relation.Resource == INVENTORY_POD_NODE {
+		fromColumn = "vm_id"
+	}
+	var conns []*metadbmodel.VMPodNodeConnection
+	if err := i.db.Where(fmt.Sprintf("%s IN ?", fromColumn), objectInts(objects, "ID")).Find(&conns).Error; err != nil {
+		return err
+	}
+	fromTo := make(map[int]int, len(conns))
+	toIDs := make([]int, 0, len(conns))
+	for _, conn := range conns {
+		from, to := conn.PodNodeID, conn.VMID
+		if relation.Resource == INVENTORY_POD_NODE {
+			from, to = conn.VMID, conn.PodNodeID
+		}
+		fromTo[from] = to
+		toIDs = append(toIDs, to)
+	}
+	related, err := i.findByIDs(relation.Resource, toIDs)
+	if err != nil {
+		return err
+	}
+	if err := i.expand(inventoryResources[relation.Resource], mapValues(related), tree); err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		obj[key] = related[fromTo[objectInt(obj, "ID")]]
+	}
+	return nil
+}
+
+func (i *inventory) expandDevice(objects []inventoryObject, key string) error {
+	typeIDs := make(map[int][]int)
+	for _, obj := range objects {
+		deviceType := objectInt(obj, "DEVICE_TYPE")
+		if _, ok := inventoryDeviceResources[deviceType]; ok {
+			typeIDs[deviceType] = append(typeIDs[deviceType], objectInt(obj, "DEVICE_ID"))
+		}
+	}
+	devices := make(map[int]map[int]inventoryObject, len(typeIDs))
+	for deviceType, ids := range typeIDs {
+		related, err := i.findByIDs(inventoryDeviceResources[deviceType], ids)
+		if err != nil {
+			return err
+		}
+		devices[deviceType] = related
+	}
+	for _, obj := range objects {
+		obj[key] = devices[objectInt(obj, "DEVICE_TYPE")][objectInt(obj, "DEVICE_ID")]
+	}
+	return nil
+}
+
+func objectInt(obj inventoryObject, field string) int {
+	switch v := obj[field].(type) {
+	case json.Number:
+		i, _ := v.Int64()
+		return int(i)
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// objectInts returns the distinct non zero values of field.
+func objectInts(objects []inventoryObject, field string) []int {
+	seen := make(map[int]struct{}, len(objects))
+	result := make([]int, 0, len(objects))
+	for _, obj := range objects {
+		v := objectInt(obj, field)
+		if _, ok := seen[v]; ok || v == 0 {
+			continue
+		}
+		seen[v] = struct{}{}
+		result = append(result, v)
+	}
+	return result
+}
+
+func mapValues(m map[int]inventoryObject) []inventoryObject {
+	result := make([]inventoryObject, 0, len(m))
+	for _, v := range m {
+		result = append(result, v)
+	}
+	return result
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	LABEL_OPERATOR_EQUAL      = "="
+	LABEL_OPERATOR_NOT_EQUAL  = "!="
+	LABEL_OPERATOR_EXISTS     = "exists"
+	LABEL_OPERATOR_NOT_EXISTS = "!exists"
+
+	INVENTORY_EXPAND_MAX_DEPTH = 4
+)
+
+var labelKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_.\-/]*[A-Za-z0-9])?$`)
+
+// LabelRequirement is one term of a kubernetes style label selector.
+type LabelRequirement struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+func (r LabelRequirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+	switch r.Operator {
+	case LABEL_OPERATOR_EQUAL:
+		return ok && value == r.Value
+	case LABEL_OPERATOR_NOT_EQUAL:
+		return !ok || value != r.Value
+	case LABEL_OPERATOR_EXISTS:
+		return ok
+	case LABEL_OPERATOR_NOT_EXISTS:
+		return !ok
+	}
+	return false
+}
+
+type LabelSelector []LabelRequirement
+
+// ParseLabelSelector parses selectors like "app=web,tier!=db,canary,!legacy".
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var result LabelSelector
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var r LabelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			kv := strings.SplitN(term, "!=", 2)
+			r = LabelRequirement{Key: strings.TrimSpace(kv[0]), Operator: LABEL_OPERATOR_NOT_EQUAL, Value: strings.TrimSpace(kv[1])}
+		case strings.Contains(term, "=="):
+			kv := strings.SplitN(term, "==", 2)
+			r = LabelRequirement{Key: strings.TrimSpace(kv[0]), Operator: LABEL_OPERATOR_EQUAL, Value: strings.TrimSpace(kv[1])}
+		case strings.Contains(term, "="):
+			kv := strings.SplitN(term, "=", 2)
+			r = LabelRequirement{Key: strings.TrimSpace(kv[0]), Operator: LABEL_OPERATOR_EQUAL, Value: strings.TrimSpace(kv[1])}
+		case strings.HasPrefix(term, "!"):
+			r = LabelRequirement{Key: strings.TrimSpace(term[1:]), Operator: LABEL_OPERATOR_NOT_EXISTS}
+		default:
+			r = LabelRequirement{Key: term, Operator: LABEL_OPERATOR_EXISTS}
+		}
+		if !labelKeyRegexp.MatchString(r.Key) {
+			return nil, fmt.Errorf("invalid label selector term (%s)", term)
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLabels converts the label column of metadb resources ("k1:v1, k2:v2")
+// or a json tag map (cloud_tags) into a map.
+func parseLabels(raw interface{}) map[string]string {
+	labels := make(map[string]string)
+	switch v := raw.(type) {
+	case string:
+		for _, item := range strings.Split(v, ", ") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			kv := strings.SplitN(item, ":", 2)
+			if len(kv) == 2 {
+				labels[kv[0]] = kv[1]
+			} else {
+				labels[kv[0]] = ""
+			}
+		}
+	case map[string]interface{}:
+		for key, value := range v {
+			labels[key] = fmt.Sprint(value)
+		}
+	}
+	return labels
+}
+
+// ExpandTree is the parsed form of expand paths like "pod_node.vm.vpc,pod_group".
+type ExpandTree map[string]ExpandTree
+
+func ParseExpand(expand string) (ExpandTree, error) {
+	tree := make(ExpandTree)
+	for _, path := range strings.Split(expand, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		names := strings.Split(path, ".")
+		if len(names) > INVENTORY_EXPAND_MAX_DEPTH {
+			return nil, fmt.Errorf("expand path (%s) is deeper than %d", path, INVENTORY_EXPAND_MAX_DEPTH)
+		}
+		node := tree
+		for _, name := range names {
+			if name == "" {
+				return nil, fmt.Errorf("invalid expand path (%s)", path)
+			}
+			if _, ok := node[name]; !ok {
+				node[name] = make(ExpandTree)
+			}
+			node = node[name]
+		}
+	}
+	return tree, nil
+}
+
+func (t ExpandTree) Keys() []string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
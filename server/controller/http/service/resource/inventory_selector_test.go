@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	selector, err := ParseLabelSelector("app=web, tier!=db,env==prod,canary,!legacy")
+	assert.NoError(t, err)
+	assert.Equal(t, LabelSelector{
+		{Key: "app", Operator: LABEL_OPERATOR_EQUAL, Value: "web"},
+		{Key: "tier", Operator: LABEL_OPERATOR_NOT_EQUAL, Value: "db"},
+		{Key: "env", Operator: LABEL_OPERATOR_EQUAL, Value: "prod"},
+		{Key: "canary", Operator: LABEL_OPERATOR_EXISTS},
+		{Key: "legacy", Operator: LABEL_OPERATOR_NOT_EXISTS},
+	}, selector)
+
+	_, err = ParseLabelSelector("=web")
+	assert.Error(t, err)
+	_, err = ParseLabelSelector("a b=c")
+	assert.Error(t, err)
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	selector, err := ParseLabelSelector("app=web,tier!=db,!legacy")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		labels interface{}
+		want   bool
+	}{
+		{"metadb label text", "app:web, tier:frontend", true},
+		{"missing not equal key", "app:web", true},
+		{"not equal value", "app:web, tier:db", false},
+		{"not exists key present", "app:web, legacy:true", false},
+		{"cloud tags", map[string]interface{}{"app": "web"}, true},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, selector.Matches(parseLabels(tt.labels)))
+		})
+	}
+}
+
+func TestParseExpand(t *testing.T) {
+	tree, err := ParseExpand("pod_node.vm.vpc, pod_node.pod_cluster,pod_group")
+	assert.NoError(t, err)
+	assert.Equal(t, ExpandTree{
+		"pod_node": {
+			"vm":          {"vpc": {}},
+			"pod_cluster": {},
+		},
+		"pod_group": {},
+	}, tree)
+	assert.Equal(t, []string{"pod_group", "pod_node"}, tree.Keys())
+
+	_, err = ParseExpand("pod_node..vm")
+	assert.Error(t, err)
+	_, err = ParseExpand("a.b.c.d.e")
+	assert.Error(t, err)
+}
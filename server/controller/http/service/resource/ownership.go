@@ -0,0 +1,594 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/jmoiron/sqlx"
+
+	ctrlcommon "github.com/deepflowio/deepflow/server/controller/common"
+	"github.com/deepflowio/deepflow/server/controller/db/clickhouse"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/model"
+	recordercommon "github.com/deepflowio/deepflow/server/controller/recorder/common"
+	"github.com/deepflowio/deepflow/server/libs/ckdb"
+	"github.com/deepflowio/deepflow/server/libs/eventapi"
+)
+
+const (
+	OWNERSHIP_SOURCE_EVENT  = "event"
+	OWNERSHIP_SOURCE_METADB = "metadb"
+
+	// signal_source of resource events in event.event, see ingester/event/dbwriter
+	ownershipEventSignalSource = 1
+	// mac of add-ip/remove-ip events, see handleResourceEvent in ingester/event/decoder
+	ownershipMACAttribute = "attribute_values[indexOf(attribute_names, 'macs')]"
+)
+
+// description of add-ip/remove-ip events, see DESCAddIPFormat in recorder/event
+var ownershipDescRegexp = regexp.MustCompile(`^(.*) (?:add|remove) ip (\S+)\(mac: ([^)]*)\) in subnet`)
+
+// universal tag columns of event.event kept in ownership tags
+var ownershipTagColumns = []string{
+	"region_id", "az_id", "l3_epc_id", "subnet_id", "host_id",
+	"pod_cluster_id", "pod_ns_id", "pod_node_id", "pod_group_id", "pod_id", "service_id",
+}
+
+// json fields of metadb resources used to fill ownership tags when no event is retained
+var ownershipTagFields = map[string]string{
+	"VPC_ID":           "l3_epc_id",
+	"EPC_ID":           "l3_epc_id",
+	"VL2ID":            "subnet_id",
+	"HOST_ID":          "host_id",
+	"POD_CLUSTER_ID":   "pod_cluster_id",
+	"POD_NAMESPACE_ID": "pod_ns_id",
+	"POD_NODE_ID":      "pod_node_id",
+	"POD_GROUP_ID":     "pod_group_id",
+	"POD_SERVICE_ID":   "service_id",
+}
+
+type ownershipKey struct {
+	ip         string
+	deviceType int
+	deviceID   int
+}
+
+type ownershipEvent struct {
+	Time        int64  `db:"time"`
+	Type        string `db:"event_type"`
+	Description string `db:"event_desc"`
+	MAC         string `db:"mac"`
+	IP4         string `db:"ip4"`
+	IP6         string `db:"ip6"`
+	IsIPv4      int64  `db:"is_ipv4"`
+	DeviceType  int64  `db:"auto_instance_type"`
+	DeviceID    int64  `db:"auto_instance_id"`
+
+	RegionID     int64 `db:"region_id"`
+	AZID         int64 `db:"az_id"`
+	VPCID        int64 `db:"l3_epc_id"`
+	SubnetID     int64 `db:"subnet_id"`
+	HostID       int64 `db:"host_id"`
+	PodClusterID int64 `db:"pod_cluster_id"`
+	PodNSID      int64 `db:"pod_ns_id"`
+	PodNodeID    int64 `db:"pod_node_id"`
+	PodGroupID   int64 `db:"pod_group_id"`
+	PodID        int64 `db:"pod_id"`
+	ServiceID    int64 `db:"service_id"`
+}
+
+func (e *ownershipEvent) ip() string {
+	if e.IsIPv4 == 1 {
+		return e.IP4
+	}
+	return e.IP6
+}
+
+func (e *ownershipEvent) tags() map[string]int {
+	tags := make(map[string]int)
+	for column, value := range map[string]int64{
+		"region_id": e.RegionID, "az_id": e.AZID, "l3_epc_id": e.VPCID, "subnet_id": e.SubnetID,
+		"host_id": e.HostID, "pod_cluster_id": e.PodClusterID, "pod_ns_id": e.PodNSID,
+		"pod_node_id": e.PodNodeID, "pod_group_id": e.PodGroupID, "pod_id": e.PodID, "service_id": e.ServiceID,
+	} {
+		// l3_epc_id is -2 when the event has no vpc
+		if value > 0 {
+			tags[column] = int(value)
+		}
+	}
+	return tags
+}
+
+type ownershipInterval struct {
+	key     ownershipKey
+	mac     string
+	name    string
+	start   time.Time // zero means earlier than the retained history
+	end     time.Time // zero means still owned
+	deleted bool
+	sources map[string]struct{}
+	tags    map[string]int
+}
+
+func (o *ownershipInterval) addSource(source string) {
+	if o.sources == nil {
+		o.sources = make(map[string]struct{})
+	}
+	o.sources[source] = struct{}{}
+}
+
+func (o *ownershipInterval) contains(t time.Time) bool {
+	return (o.start.IsZero() || !o.start.After(t)) && (o.end.IsZero() || o.end.After(t))
+}
+
+// buildOwnershipIntervals pairs add-ip and remove-ip events of the same ip and owner.
+// A remove-ip without a preceding add-ip means the ip was added before the retained history.
+func buildOwnershipIntervals(events []*ownershipEvent) []*ownershipInterval {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time < events[j].Time })
+	var intervals []*ownershipInterval
+	open := make(map[ownershipKey]*ownershipInterval)
+	for _, e := range events {
+		key := ownershipKey{ip: e.ip(), deviceType: int(e.DeviceType), deviceID: int(e.DeviceID)}
+		var name, mac string
+		if m := ownershipDescRegexp.FindStringSubmatch(e.Description); m != nil {
+			name, mac = m[1], m[3]
+		}
+		if e.MAC != "" {
+			mac = e.MAC
+		}
+		switch e.Type {
+		case eventapi.RESOURCE_EVENT_TYPE_ADD_IP:
+			if _, ok := open[key]; ok {
+				continue
+			}
+			interval := &ownershipInterval{key: key, mac: mac, name: name, start: time.Unix(e.Time, 0), tags: e.tags()}
+			interval.addSource(OWNERSHIP_SOURCE_EVENT)
+			open[key] = interval
+			intervals = append(intervals, interval)
+		case eventapi.RESOURCE_EVENT_TYPE_REMOVE_IP:
+			interval, ok := open[key]
+			if !ok {
+				interval = &ownershipInterval{key: key, mac: mac, name: name, tags: e.tags()}
+				interval.addSource(OWNERSHIP_SOURCE_EVENT)
+				intervals = append(intervals, interval)
+			}
+			interval.end = time.Unix(e.Time, 0)
+			if interval.mac == "" {
+				interval.mac = mac
+			}
+			delete(open, key)
+		}
+	}
+	return intervals
+}
+
+// GetOwnerships rebuilds which resources owned an ip, a mac, or which ips a resource owned,
+// from add-ip/remove-ip resource events in clickhouse and the (soft deleted) rows in metadb.
+func GetOwnerships(orgDB *metadb.DB, ckCfg clickhouse.ClickHouseConfig, excludeTeamIDs []int, query *model.OwnershipQuery) ([]*model.Ownership, error) {
+	var conditions int
+	for _, ok := range []bool{query.IP != "", query.MAC != "", query.ResourceType != 0} {
+		if ok {
+			conditions++
+		}
+	}
+	if conditions != 1 {
+		return nil, response.ServiceError(httpcommon.INVALID_PARAMETERS, "exactly one of ip, mac and resource should be specified")
+	}
+	if query.IP != "" && net.ParseIP(query.IP) == nil {
+		return nil, response.ServiceError(httpcommon.INVALID_PARAMETERS, fmt.Sprintf("invalid ip (%s)", query.IP))
+	}
+	query.MAC = strings.ToLower(query.MAC)
+
+	inv := &inventory{db: orgDB.DB, includeDeleted: true}
+	if err := inv.loadExcludedDomains(excludeTeamIDs); err != nil {
+		return nil, err
+	}
+	devices, err := inv.loadOwnershipDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := queryOwnershipEvents(orgDB.ORGID, ckCfg, excludeTeamIDs, query, devices)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		deviceType, deviceID := devices.normalize(int(e.DeviceType), int(e.DeviceID))
+		e.DeviceType, e.DeviceID = int64(deviceType), int64(deviceID)
+	}
+	intervals := buildOwnershipIntervals(events)
+
+	intervals, err = inv.mergeCurrentOwnerships(intervals, query, devices)
+	if err != nil {
+		return nil, err
+	}
+	if err := inv.fillOwnershipResources(intervals); err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Ownership, 0, len(intervals))
+	for _, o := range intervals {
+		if query.Time != 0 && !o.contains(time.Unix(query.Time, 0)) {
+			continue
+		}
+		result = append(result, o.toModel())
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].StartTime != result[j].StartTime {
+			return result[i].StartTime < result[j].StartTime
+		}
+		return result[i].IP < result[j].IP
+	})
+	return result, nil
+}
+
+func queryOwnershipEvents(orgID int, ckCfg clickhouse.ClickHouseConfig, excludeTeamIDs []int, query *model.OwnershipQuery, devices *ownershipDevices) ([]*ownershipEvent, error) {
+	sql := fmt.Sprintf(
+		"SELECT toInt64(toUnixTimestamp(time)) AS time, event_type, event_desc, %s AS mac, toString(ip4) AS ip4, toString(ip6) AS ip6, "+
+			"toInt64(is_ipv4) AS is_ipv4, toInt64(auto_instance_type) AS auto_instance_type, toInt64(auto_instance_id) AS auto_instance_id, %s "+
+			"FROM `%sevent`.`event` WHERE signal_source = %d AND event_type IN ('%s', '%s')",
+		ownershipMACAttribute, ownershipTagSelect(), ckdb.OrgDatabasePrefix(uint16(orgID)), ownershipEventSignalSource,
+		eventapi.RESOURCE_EVENT_TYPE_ADD_IP, eventapi.RESOURCE_EVENT_TYPE_REMOVE_IP,
+	)
+	var args []interface{}
+	switch {
+	case query.IP != "":
+		if net.ParseIP(query.IP).To4() != nil {
+			sql += " AND is_ipv4 = 1 AND ip4 = toIPv4(?)"
+		} else {
+			sql += " AND is_ipv4 = 0 AND ip6 = toIPv6(?)"
+		}
+		args = append(args, query.IP)
+	case query.MAC != "":
+		sql += fmt.Sprintf(" AND lower(%s) = ?", ownershipMACAttribute)
+		args = append(args, query.MAC)
+	default:
+		var conditions []string
+		for _, device := range devices.aliases(query.ResourceType, query.ResourceID) {
+			conditions = append(conditions, "(auto_instance_type = ? AND auto_instance_id = ?)")
+			args = append(args, device.deviceType, device.deviceID)
+		}
+		sql += fmt.Sprintf(" AND (%s)", strings.Join(conditions, " OR "))
+	}
+	if query.StartTime != 0 {
+		sql += " AND time >= toDateTime(?)"
+		args = append(args, query.StartTime)
+	}
+	if query.EndTime != 0 {
+		sql += " AND time <= toDateTime(?)"
+		args = append(args, query.EndTime)
+	}
+	if len(excludeTeamIDs) != 0 {
+		teamIDs := make([]string, 0, len(excludeTeamIDs))
+		for _, id := range excludeTeamIDs {
+			teamIDs = append(teamIDs, fmt.Sprint(id))
+		}
+		sql += fmt.Sprintf(" AND team_id NOT IN (%s)", strings.Join(teamIDs, ","))
+	}
+	sql += " ORDER BY time"
+
+	ckDB, err := clickhouse.Connect(ckCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer ckDB.Close()
+	var events []*ownershipEvent
+	if err := sqlx.Select(ckDB, &events, sql, args...); err != nil {
+		log.Errorf("query resource events failed: %s, sql: %s", err.Error(), sql)
+		return nil, response.ServiceError(httpcommon.SERVER_ERROR, fmt.Sprintf("query resource events failed: %s", err.Error()))
+	}
+	return events, nil
+}
+
+func ownershipTagSelect() string {
+	columns := make([]string, 0, len(ownershipTagColumns))
+	for _, c := range ownershipTagColumns {
+		columns = append(columns, fmt.Sprintf("toInt64(%s) AS %s", c, c))
+	}
+	return strings.Join(columns, ", ")
+}
+
+type ownershipDevice struct {
+	deviceType int
+	deviceID   int
+}
+
+// ownershipDevices folds a vm working as a pod node into the pod node. Resource events key the owner by
+// auto_instance, which prefers the pod node, while metadb keys it by the device of the vinterface, so both
+// are normalized before intervals are paired and merged.
+type ownershipDevices struct {
+	vmToPodNode map[int]int
+	podNodeToVM map[int]int
+}
+
+func (i *inventory) loadOwnershipDevices() (*ownershipDevices, error) {
+	var connections []*metadbmodel.VMPodNodeConnection
+	if err := i.db.Select("vm_id", "pod_node_id").Find(&connections).Error; err != nil {
+		return nil, err
+	}
+	devices := &ownershipDevices{
+		vmToPodNode: make(map[int]int, len(connections)),
+		podNodeToVM: make(map[int]int, len(connections)),
+	}
+	for _, c := range connections {
+		if c.VMID == 0 || c.PodNodeID == 0 {
+			continue
+		}
+		devices.vmToPodNode[c.VMID] = c.PodNodeID
+		devices.podNodeToVM[c.PodNodeID] = c.VMID
+	}
+	return devices, nil
+}
+
+func (d *ownershipDevices) normalize(deviceType, deviceID int) (int, int) {
+	if deviceType == ctrlcommon.VIF_DEVICE_TYPE_VM {
+		if podNodeID, ok := d.vmToPodNode[deviceID]; ok {
+			return ctrlcommon.VIF_DEVICE_TYPE_POD_NODE, podNodeID
+		}
+	}
+	return deviceType, deviceID
+}
+
+// aliases returns the devices normalized to the same key as the device
+func (d *ownershipDevices) aliases(deviceType, deviceID int) []ownershipDevice {
+	deviceType, deviceID = d.normalize(deviceType, deviceID)
+	devices := []ownershipDevice{{deviceType: deviceType, deviceID: deviceID}}
+	if deviceType == ctrlcommon.VIF_DEVICE_TYPE_POD_NODE {
+		if vmID, ok := d.podNodeToVM[deviceID]; ok {
+			devices = append(devices, ownershipDevice{deviceType: ctrlcommon.VIF_DEVICE_TYPE_VM, deviceID: vmID})
+		}
+	}
+	return devices
+}
+
+type currentOwnership struct {
+	key       ownershipKey
+	mac       string
+	subnetID  int
+	createdAt time.Time
+}
+
+// mergeCurrentOwnerships adds the ips currently recorded in metadb, they confirm the
+// open intervals rebuilt from events, or open new ones if the events are not retained.
+func (i *inventory) mergeCurrentOwnerships(intervals []*ownershipInterval, query *model.OwnershipQuery, devices *ownershipDevices) ([]*ownershipInterval, error) {
+	current, err := i.currentOwnerships(query, devices)
+	if err != nil {
+		return nil, err
+	}
+	open := make(map[ownershipKey]*ownershipInterval)
+	for _, o := range intervals {
+		if o.end.IsZero() {
+			open[o.key] = o
+		}
+	}
+	for _, c := range current {
+		o, ok := open[c.key]
+		if !ok {
+			o = &ownershipInterval{key: c.key, start: c.createdAt, tags: map[string]int{}}
+			if c.subnetID != 0 {
+				o.tags["subnet_id"] = c.subnetID
+			}
+			intervals = append(intervals, o)
+			open[c.key] = o
+		}
+		if o.mac == "" {
+			o.mac = c.mac
+		}
+		o.addSource(OWNERSHIP_SOURCE_METADB)
+	}
+	return intervals, nil
+}
+
+func (i *inventory) currentOwnerships(query *model.OwnershipQuery, devices *ownershipDevices) ([]*currentOwnership, error) {
+	vifDB := i.scope(map[string]string{"domain": "domain", "sub_domain": "sub_domain"})
+	var vifs []*metadbmodel.VInterface
+	var lanIPs []*metadbmodel.LANIP
+	var wanIPs []*metadbmodel.WANIP
+	switch {
+	case query.IP != "":
+		if err := i.db.Where("ip = ?", query.IP).Find(&lanIPs).Error; err != nil {
+			return nil, err
+		}
+		if err := i.db.Where("ip = ?", query.IP).Find(&wanIPs).Error; err != nil {
+			return nil, err
+		}
+		vifIDs := make([]int, 0, len(lanIPs)+len(wanIPs))
+		for _, ip := range lanIPs {
+			vifIDs = append(vifIDs, ip.VInterfaceID)
+		}
+		for _, ip := range wanIPs {
+			vifIDs = append(vifIDs, ip.VInterfaceID)
+		}
+		if len(vifIDs) != 0 {
+			if err := vifDB.Where("id IN ?", vifIDs).Find(&vifs).Error; err != nil {
+				return nil, err
+			}
+		}
+	default:
+		if query.MAC != "" {
+			vifDB = vifDB.Where("mac = ?", query.MAC)
+		} else {
+			var conditions []string
+			var args []interface{}
+			for _, device := range devices.aliases(query.ResourceType, query.ResourceID) {
+				conditions = append(conditions, "(devicetype = ? AND deviceid = ?)")
+				args = append(args, device.deviceType, device.deviceID)
+			}
+			vifDB = vifDB.Where(strings.Join(conditions, " OR "), args...)
+		}
+		if err := vifDB.Find(&vifs).Error; err != nil {
+			return nil, err
+		}
+		vifIDs := make([]int, 0, len(vifs))
+		for _, vif := range vifs {
+			vifIDs = append(vifIDs, vif.ID)
+		}
+		if len(vifIDs) != 0 {
+			if err := i.db.Where("vifid IN ?", vifIDs).Find(&lanIPs).Error; err != nil {
+				return nil, err
+			}
+			if err := i.db.Where("vifid IN ?", vifIDs).Find(&wanIPs).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	idToVIF := make(map[int]*metadbmodel.VInterface, len(vifs))
+	for _, vif := range vifs {
+		idToVIF[vif.ID] = vif
+	}
+	var result []*currentOwnership
+	appendIP := func(ip string, vifID, subnetID int, createdAt time.Time) {
+		vif, ok := idToVIF[vifID]
+		if !ok {
+			return
+		}
+		deviceType, deviceID := devices.normalize(vif.DeviceType, vif.DeviceID)
+		result = append(result, &currentOwnership{
+			key:       ownershipKey{ip: ip, deviceType: deviceType, deviceID: deviceID},
+			mac:       vif.Mac,
+			subnetID:  subnetID,
+			createdAt: createdAt,
+		})
+	}
+	for _, ip := range lanIPs {
+		appendIP(ip.IP, ip.VInterfaceID, ip.NetworkID, ip.CreatedAt)
+	}
+	for _, ip := range wanIPs {
+		appendIP(ip.IP, ip.VInterfaceID, 0, ip.CreatedAt)
+	}
+	return result, nil
+}
+
+// fillOwnershipResources completes owner names, lifetimes and tags from the resource
+// rows, soft deleted ones included.
+func (i *inventory) fillOwnershipResources(intervals []*ownershipInterval) error {
+	typeIDs := make(map[int][]int)
+	for _, o := range intervals {
+		typeIDs[o.key.deviceType] = append(typeIDs[o.key.deviceType], o.key.deviceID)
+	}
+	resources := make(map[int]map[int]inventoryObject, len(typeIDs))
+	for deviceType, ids := range typeIDs {
+		resourceName, ok := inventoryDeviceResources[deviceType]
+		if !ok {
+			continue
+		}
+		objects, err := i.findByIDs(resourceName, ids)
+		if err != nil {
+			return err
+		}
+		resources[deviceType] = objects
+	}
+	regionIDs, azIDs, err := i.regionAndAZIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, o := range intervals {
+		obj, ok := resources[o.key.deviceType][o.key.deviceID]
+		if !ok {
+			continue
+		}
+		if name, _ := obj["NAME"].(string); name != "" {
+			o.name = name
+		}
+		if o.start.IsZero() {
+			if createdAt, err := time.Parse(time.RFC3339Nano, fmt.Sprint(obj["CREATED_AT"])); err == nil {
+				o.start = createdAt
+			}
+		}
+		if deletedAt, err := time.Parse(time.RFC3339Nano, fmt.Sprint(obj["DELETED_AT"])); err == nil {
+			o.deleted = true
+			if o.end.IsZero() || o.end.After(deletedAt) {
+				o.end = deletedAt
+			}
+		}
+		if o.tags == nil {
+			o.tags = make(map[string]int)
+		}
+		for field, tag := range ownershipTagFields {
+			if _, ok := o.tags[tag]; !ok && objectInt(obj, field) > 0 {
+				o.tags[tag] = objectInt(obj, field)
+			}
+		}
+		if _, ok := o.tags["region_id"]; !ok {
+			if id, ok := regionIDs[fmt.Sprint(obj["REGION"])]; ok {
+				o.tags["region_id"] = id
+			}
+		}
+		if _, ok := o.tags["az_id"]; !ok {
+			if id, ok := azIDs[fmt.Sprint(obj["AZ"])]; ok {
+				o.tags["az_id"] = id
+			}
+		}
+	}
+	return nil
+}
+
+func (i *inventory) regionAndAZIDs() (map[string]int, map[string]int, error) {
+	var regions []*metadbmodel.Region
+	if err := i.db.Unscoped().Select("id", "lcuuid").Find(&regions).Error; err != nil {
+		return nil, nil, err
+	}
+	var azs []*metadbmodel.AZ
+	if err := i.db.Unscoped().Select("id", "lcuuid").Find(&azs).Error; err != nil {
+		return nil, nil, err
+	}
+	regionIDs := make(map[string]int, len(regions))
+	for _, r := range regions {
+		regionIDs[r.Lcuuid] = r.ID
+	}
+	azIDs := make(map[string]int, len(azs))
+	for _, az := range azs {
+		azIDs[az.Lcuuid] = az.ID
+	}
+	return regionIDs, azIDs, nil
+}
+
+func (o *ownershipInterval) toModel() *model.Ownership {
+	m := &model.Ownership{
+		IP:               o.key.ip,
+		MAC:              o.mac,
+		ResourceType:     o.key.deviceType,
+		ResourceTypeName: recordercommon.DEVICE_TYPE_INT_TO_STR[o.key.deviceType],
+		ResourceID:       o.key.deviceID,
+		ResourceName:     o.name,
+		ResourceDeleted:  o.deleted,
+		Tags:             o.tags,
+	}
+	if !o.start.IsZero() {
+		m.StartTime = o.start.Format(ctrlcommon.GO_BIRTHDAY)
+	}
+	if !o.end.IsZero() {
+		m.EndTime = o.end.Format(ctrlcommon.GO_BIRTHDAY)
+	}
+	for source := range o.sources {
+		m.Sources = append(m.Sources, source)
+	}
+	sort.Strings(m.Sources)
+	return m
+}
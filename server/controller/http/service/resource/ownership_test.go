@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/deepflowio/deepflow/server/libs/eventapi"
+)
+
+func TestBuildOwnershipIntervals(t *testing.T) {
+	events := []*ownershipEvent{
+		{Time: 300, Type: eventapi.RESOURCE_EVENT_TYPE_ADD_IP, IsIPv4: 1, IP4: "10.1.2.3", DeviceType: 10, DeviceID: 2,
+			Description: "pod-b add ip 10.1.2.3(mac: 00:00:00:00:00:02) in subnet net-1.", MAC: "00:00:00:00:00:0b", PodID: 2, VPCID: 1},
+		{Time: 100, Type: eventapi.RESOURCE_EVENT_TYPE_ADD_IP, IsIPv4: 1, IP4: "10.1.2.3", DeviceType: 10, DeviceID: 1,
+			Description: "pod-a add ip 10.1.2.3(mac: 00:00:00:00:00:01) in subnet net-1.", PodID: 1, VPCID: -2},
+		{Time: 200, Type: eventapi.RESOURCE_EVENT_TYPE_REMOVE_IP, IsIPv4: 1, IP4: "10.1.2.3", DeviceType: 10, DeviceID: 1,
+			Description: "pod-a remove ip 10.1.2.3(mac: 00:00:00:00:00:01) in subnet net-1."},
+		{Time: 50, Type: eventapi.RESOURCE_EVENT_TYPE_REMOVE_IP, IsIPv4: 1, IP4: "10.1.2.3", DeviceType: 1, DeviceID: 9,
+			Description: "vm-x remove ip 10.1.2.3(mac: 00:00:00:00:00:09) in subnet net-1."},
+	}
+	intervals := buildOwnershipIntervals(events)
+	assert.Len(t, intervals, 3)
+
+	vm := intervals[0]
+	assert.Equal(t, ownershipKey{ip: "10.1.2.3", deviceType: 1, deviceID: 9}, vm.key)
+	assert.True(t, vm.start.IsZero())
+	assert.Equal(t, time.Unix(50, 0), vm.end)
+	assert.Equal(t, "vm-x", vm.name)
+
+	podA := intervals[1]
+	assert.Equal(t, "pod-a", podA.name)
+	assert.Equal(t, "00:00:00:00:00:01", podA.mac)
+	assert.Equal(t, time.Unix(100, 0), podA.start)
+	assert.Equal(t, time.Unix(200, 0), podA.end)
+	assert.Equal(t, map[string]int{"pod_id": 1}, podA.tags)
+
+	podB := intervals[2]
+	assert.Equal(t, "00:00:00:00:00:0b", podB.mac)
+	assert.True(t, podB.end.IsZero())
+	assert.Equal(t, map[string]int{"pod_id": 2, "l3_epc_id": 1}, podB.tags)
+
+	assert.True(t, vm.contains(time.Unix(10, 0)))
+	assert.False(t, vm.contains(time.Unix(50, 0)))
+	assert.True(t, podA.contains(time.Unix(150, 0)))
+	assert.False(t, podA.contains(time.Unix(250, 0)))
+	assert.True(t, podB.contains(time.Unix(1000, 0)))
+}
+
+func TestOwnershipDevices(t *testing.T) {
+	devices := &ownershipDevices{vmToPodNode: map[int]int{9: 3}, podNodeToVM: map[int]int{3: 9}}
+
+	deviceType, deviceID := devices.normalize(1, 9)
+	assert.Equal(t, 14, deviceType)
+	assert.Equal(t, 3, deviceID)
+	deviceType, deviceID = devices.normalize(1, 8)
+	assert.Equal(t, 1, deviceType)
+	assert.Equal(t, 8, deviceID)
+
+	expected := []ownershipDevice{{deviceType: 14, deviceID: 3}, {deviceType: 1, deviceID: 9}}
+	assert.Equal(t, expected, devices.aliases(1, 9))
+	assert.Equal(t, expected, devices.aliases(14, 3))
+	assert.Equal(t, []ownershipDevice{{deviceType: 10, deviceID: 2}}, devices.aliases(10, 2))
+}
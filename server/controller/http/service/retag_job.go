@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/deepflowio/deepflow/server/controller/common"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	httpcommon "github.com/deepflowio/deepflow/server/controller/http/common"
+	"github.com/deepflowio/deepflow/server/controller/http/common/response"
+	"github.com/deepflowio/deepflow/server/controller/model"
+	retagcommon "github.com/deepflowio/deepflow/server/controller/retag/common"
+)
+
+func GetRetagJobs(db *metadb.DB, filter map[string]interface{}) ([]model.RetagJob, error) {
+	queryDB := db.DB
+	for _, field := range []string{"lcuuid", "state"} {
+		if v, ok := filter[field]; ok {
+			queryDB = queryDB.Where(fmt.Sprintf("%s = ?", field), v)
+		}
+	}
+	var dbJobs []metadbmodel.RetagJob
+	if err := queryDB.Order("id DESC").Find(&dbJobs).Error; err != nil {
+		return nil, err
+	}
+	resp := make([]model.RetagJob, 0, len(dbJobs))
+	for _, j := range dbJobs {
+		job := model.RetagJob{
+			ID:            j.ID,
+			Name:          j.Name,
+			Tables:        []string{},
+			StartTime:     j.StartTime.Unix(),
+			EndTime:       j.EndTime.Unix(),
+			DryRun:        j.DryRun != 0,
+			BatchInterval: j.BatchInterval,
+			State:         retagcommon.JobStateName[j.State],
+			TotalSteps:    j.TotalSteps,
+			DoneSteps:     j.DoneSteps,
+			ChangedRows:   j.ChangedRows,
+			ErrorMessage:  j.ErrorMessage,
+			CreatedAt:     j.CreatedAt.Format(common.GO_BIRTHDAY),
+			UpdatedAt:     j.UpdatedAt.Format(common.GO_BIRTHDAY),
+			Lcuuid:        j.Lcuuid,
+		}
+		if j.TotalSteps > 0 {
+			job.Progress = float64(j.DoneSteps) * 100 / float64(j.TotalSteps)
+		}
+		if j.StartedAt != nil {
+			job.StartedAt = j.StartedAt.Format(common.GO_BIRTHDAY)
+		}
+		if j.FinishedAt != nil {
+			job.FinishedAt = j.FinishedAt.Format(common.GO_BIRTHDAY)
+		}
+		if j.Tables != "" {
+			if err := json.Unmarshal([]byte(j.Tables), &job.Tables); err != nil {
+				log.Errorf("unmarshal tables of retag job (%d) failed: %s", j.ID, err.Error(), db.LogPrefixORGID)
+			}
+		}
+		resp = append(resp, job)
+	}
+	return resp, nil
+}
+
+func CreateRetagJob(db *metadb.DB, jobCreate model.RetagJobCreate) (*model.RetagJob, error) {
+	if err := retagcommon.CheckTables(jobCreate.Tables); err != nil {
+		return nil, response.ServiceError(httpcommon.INVALID_POST_DATA, err.Error())
+	}
+	if jobCreate.StartTime <= 0 || jobCreate.EndTime < jobCreate.StartTime {
+		return nil, response.ServiceError(httpcommon.INVALID_POST_DATA, "START_TIME must be positive and not greater than END_TIME")
+	}
+	if jobCreate.EndTime > time.Now().Unix() {
+		return nil, response.ServiceError(httpcommon.INVALID_POST_DATA, "END_TIME must not be in the future")
+	}
+	if jobCreate.BatchInterval < 0 {
+		return nil, response.ServiceError(httpcommon.INVALID_POST_DATA, "BATCH_INTERVAL must not be negative")
+	}
+	tables, err := json.Marshal(jobCreate.Tables)
+	if err != nil {
+		return nil, err
+	}
+	dbJob := metadbmodel.RetagJob{
+		Name:          jobCreate.Name,
+		Tables:        string(tables),
+		StartTime:     time.Unix(jobCreate.StartTime, 0),
+		EndTime:       time.Unix(jobCreate.EndTime, 0),
+		BatchInterval: jobCreate.BatchInterval,
+		State:         retagcommon.JOB_STATE_PENDING,
+		Lcuuid:        uuid.New().String(),
+	}
+	if jobCreate.DryRun {
+		dbJob.DryRun = 1
+	}
+	if err := db.Create(&dbJob).Error; err != nil {
+		return nil, err
+	}
+	log.Infof("create retag job (%d) of tables %v in [%d, %d], dry run: %t",
+		dbJob.ID, jobCreate.Tables, jobCreate.StartTime, jobCreate.EndTime, jobCreate.DryRun, db.LogPrefixORGID)
+	return getRetagJob(db, dbJob.Lcuuid)
+}
+
+// CancelRetagJob stops a pending or running job, the running job stops after the current mutation,
+// the partitions already retagged are not rolled back.
+func CancelRetagJob(db *metadb.DB, lcuuid string) (*model.RetagJob, error) {
+	if _, err := getRetagJob(db, lcuuid); err != nil {
+		return nil, err
+	}
+	result := db.Model(&metadbmodel.RetagJob{}).Where(
+		"lcuuid = ? AND state IN ?", lcuuid, []int{retagcommon.JOB_STATE_PENDING, retagcommon.JOB_STATE_RUNNING},
+	).Updates(map[string]interface{}{"state": retagcommon.JOB_STATE_CANCELLED, "finished_at": time.Now()})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, response.ServiceError(httpcommon.INVALID_PARAMETERS, fmt.Sprintf("retag job (%s) is already stopped", lcuuid))
+	}
+	log.Infof("cancel retag job (%s)", lcuuid, db.LogPrefixORGID)
+	return getRetagJob(db, lcuuid)
+}
+
+func DeleteRetagJob(db *metadb.DB, lcuuid string) (map[string]string, error) {
+	job, err := getRetagJob(db, lcuuid)
+	if err != nil {
+		return nil, err
+	}
+	if job.State == retagcommon.JobStateName[retagcommon.JOB_STATE_RUNNING] {
+		return nil, response.ServiceError(httpcommon.INVALID_PARAMETERS, fmt.Sprintf("retag job (%s) is running, cancel it first", lcuuid))
+	}
+	if err := db.Where("lcuuid = ?", lcuuid).Delete(&metadbmodel.RetagJob{}).Error; err != nil {
+		return nil, err
+	}
+	log.Infof("delete retag job (%d)", job.ID, db.LogPrefixORGID)
+	return map[string]string{"LCUUID": lcuuid}, nil
+}
+
+func getRetagJob(db *metadb.DB, lcuuid string) (*model.RetagJob, error) {
+	var dbJob metadbmodel.RetagJob
+	if err := db.Where("lcuuid = ?", lcuuid).First(&dbJob).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ServiceError(httpcommon.RESOURCE_NOT_FOUND, fmt.Sprintf("retag job (%s) not found", lcuuid))
+		}
+		return nil, err
+	}
+	jobs, err := GetRetagJobs(db, map[string]interface{}{"lcuuid": lcuuid})
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
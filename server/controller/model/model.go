@@ -257,6 +257,94 @@ type VtapGroupUpdate struct {
 	VtapLcuuids []string `json:"VTAP_LCUUIDS"`
 }
 
+type AgentGroupConfigRevision struct {
+	Revision         int    `json:"REVISION"`
+	AgentGroupLcuuid string `json:"AGENT_GROUP_LCUUID"`
+	Action           string `json:"ACTION"`
+	UserID           int    `json:"USER_ID"`
+	Comment          string `json:"COMMENT"`
+	CreatedAt        string `json:"CREATED_AT"`
+	Yaml             string `json:"YAML,omitempty"`
+}
+
+type AgentGroupConfigRollback struct {
+	Revision int    `json:"REVISION" binding:"required"`
+	Comment  string `json:"COMMENT"`
+}
+
+type AgentBootstrapToken struct {
+	ID           int    `json:"ID"`
+	Name         string `json:"NAME"`
+	TokenID      string `json:"TOKEN_ID"`
+	Token        string `json:"TOKEN,omitempty"` // only returned on creation
+	TeamID       int    `json:"TEAM_ID"`
+	UserID       int    `json:"USER_ID"`
+	AgentGroupID string `json:"AGENT_GROUP_ID"`
+	ExpireAt     string `json:"EXPIRE_AT"`
+	UsageLimit   int    `json:"USAGE_LIMIT"`
+	UsageCount   int    `json:"USAGE_COUNT"`
+	CreatedAt    string `json:"CREATED_AT"`
+	Lcuuid       string `json:"LCUUID"`
+}
+
+type AgentBootstrapTokenCreate struct {
+	Name         string `json:"NAME" binding:"required"`
+	TeamID       int    `json:"TEAM_ID"`
+	AgentGroupID string `json:"AGENT_GROUP_ID"` // short uuid, empty means the default agent group
+	TTL          int    `json:"TTL"`            // unit: s, 0 means never expire
+	UsageLimit   int    `json:"USAGE_LIMIT"`    // 0 means unlimited
+}
+
+type PendingAgent struct {
+	ID              int    `json:"ID"`
+	Name            string `json:"NAME"`
+	CtrlIP          string `json:"CTRL_IP"`
+	CtrlMac         string `json:"CTRL_MAC"`
+	TeamID          int    `json:"TEAM_ID"`
+	VtapGroupLcuuid string `json:"VTAP_GROUP_LCUUID"`
+	CreatedAt       string `json:"CREATED_AT"`
+	Lcuuid          string `json:"LCUUID"`
+}
+
+type InventoryQuery struct {
+	Filters        map[string][]string // column name => values, multiple values mean IN
+	LabelSelector  string
+	Expand         string
+	IncludeDeleted bool
+	PageIndex      int
+	PageSize       int
+}
+
+type InventoryResource struct {
+	Name          string   `json:"NAME"`
+	Relations     []string `json:"RELATIONS"`
+	LabelSelector bool     `json:"LABEL_SELECTOR"`
+}
+
+type OwnershipQuery struct {
+	IP           string
+	MAC          string
+	ResourceType int
+	ResourceID   int
+	Time         int64 // unit: s, 0 means all intervals
+	StartTime    int64 // unit: s, bounds the event history scanned, 0 means unbounded
+	EndTime      int64
+}
+
+type Ownership struct {
+	IP               string         `json:"IP"`
+	MAC              string         `json:"MAC"`
+	ResourceType     int            `json:"RESOURCE_TYPE"`
+	ResourceTypeName string         `json:"RESOURCE_TYPE_NAME"`
+	ResourceID       int            `json:"RESOURCE_ID"`
+	ResourceName     string         `json:"RESOURCE_NAME"`
+	ResourceDeleted  bool           `json:"RESOURCE_DELETED"`
+	StartTime        string         `json:"START_TIME"` // empty means earlier than the retained history
+	EndTime          string         `json:"END_TIME"`   // empty means still owned
+	Sources          []string       `json:"SOURCES"`
+	Tags             map[string]int `json:"TAGS"` // universal tag ids, e.g. pod_ns_id
+}
+
 type DataSource struct {
 	ID                        int    `json:"ID"`
 	Name                      string `json:"NAME"`
@@ -725,9 +813,27 @@ type Plugin struct {
 	Type      int    `json:"TYPE" binding:"required"`
 	UserName  int    `json:"USER" binding:"required"`
 	Image     []byte `json:"IMAGE,omitempty" binding:"required"`
+	Version   string `json:"VERSION"`
+	SHA256    string `json:"SHA256"`
 	UpdatedAt string `json:"UPDATED_AT"`
 }
 
+type PluginVersion struct {
+	Name       string `json:"NAME"`
+	Type       int    `json:"TYPE"`
+	UserName   int    `json:"USER"`
+	Version    string `json:"VERSION"`
+	SHA256     string `json:"SHA256"`
+	Signature  string `json:"SIGNATURE"`
+	Promoted   bool   `json:"PROMOTED"`
+	PromotedAt string `json:"PROMOTED_AT"`
+	CreatedAt  string `json:"CREATED_AT"`
+}
+
+type PluginPromote struct {
+	Version string `json:"VERSION" binding:"required"`
+}
+
 type MailServerCreate struct {
 	Status       int    `json:"STATUS"`
 	Host         string `json:"HOST" binding:"required"`
@@ -765,3 +871,119 @@ type MailServer struct {
 	NtlmPassword string `json:"NTLM_PASSWORD"`
 	Lcuuid       string `json:"LCUUID"`
 }
+
+type PrometheusRecordingRule struct {
+	Record string            `json:"RECORD" yaml:"record" binding:"required"`
+	Expr   string            `json:"EXPR" yaml:"expr" binding:"required"`
+	Labels map[string]string `json:"LABELS" yaml:"labels"`
+}
+
+type PrometheusRecordingRuleGroup struct {
+	ID             int                       `json:"ID"`
+	Name           string                    `json:"NAME"`
+	EvalInterval   int                       `json:"EVAL_INTERVAL"`
+	QueryOffset    int                       `json:"QUERY_OFFSET"`
+	RemoteWriteURL string                    `json:"REMOTE_WRITE_URL"`
+	Rules          []PrometheusRecordingRule `json:"RULES"`
+	CreatedAt      string                    `json:"CREATED_AT"`
+	UpdatedAt      string                    `json:"UPDATED_AT"`
+	Lcuuid         string                    `json:"LCUUID"`
+}
+
+type PrometheusRecordingRuleGroupCreate struct {
+	Name           string                    `json:"NAME" binding:"required"`
+	EvalInterval   int                       `json:"EVAL_INTERVAL"` // unit: s, 0 means the default evaluation interval of querier
+	QueryOffset    int                       `json:"QUERY_OFFSET"`  // unit: s
+	RemoteWriteURL string                    `json:"REMOTE_WRITE_URL"`
+	Rules          []PrometheusRecordingRule `json:"RULES" binding:"required,dive"`
+}
+
+type PrometheusRecordingRuleGroupUpdate struct {
+	EvalInterval   *int                       `json:"EVAL_INTERVAL"`
+	QueryOffset    *int                       `json:"QUERY_OFFSET"`
+	RemoteWriteURL *string                    `json:"REMOTE_WRITE_URL"`
+	Rules          *[]PrometheusRecordingRule `json:"RULES"`
+}
+
+type RetagJob struct {
+	ID            int      `json:"ID"`
+	Name          string   `json:"NAME"`
+	Tables        []string `json:"TABLES"`
+	StartTime     int64    `json:"START_TIME"` // unix timestamp, unit: s
+	EndTime       int64    `json:"END_TIME"`   // unix timestamp, unit: s
+	DryRun        bool     `json:"DRY_RUN"`
+	BatchInterval int      `json:"BATCH_INTERVAL"`
+	State         string   `json:"STATE"`
+	TotalSteps    int      `json:"TOTAL_STEPS"`
+	DoneSteps     int      `json:"DONE_STEPS"`
+	Progress      float64  `json:"PROGRESS"` // 0-100
+	ChangedRows   int64    `json:"CHANGED_ROWS"`
+	ErrorMessage  string   `json:"ERROR_MESSAGE"`
+	StartedAt     string   `json:"STARTED_AT"`
+	FinishedAt    string   `json:"FINISHED_AT"`
+	CreatedAt     string   `json:"CREATED_AT"`
+	UpdatedAt     string   `json:"UPDATED_AT"`
+	Lcuuid        string   `json:"LCUUID"`
+}
+
+type RetagJobCreate struct {
+	Name          string   `json:"NAME"`
+	Tables        []string `json:"TABLES" binding:"required"`
+	StartTime     int64    `json:"START_TIME" binding:"required"` // unix timestamp, unit: s
+	EndTime       int64    `json:"END_TIME" binding:"required"`   // unix timestamp, unit: s
+	DryRun        bool     `json:"DRY_RUN"`
+	BatchInterval int      `json:"BATCH_INTERVAL"` // unit: s, 0 means the default of controller
+}
+
+type AgentUpgradeRollout struct {
+	ID               int                        `json:"ID"`
+	Name             string                     `json:"NAME"`
+	AgentGroupID     string                     `json:"AGENT_GROUP_ID"`
+	AgentGroupName   string                     `json:"AGENT_GROUP_NAME"`
+	ImageName        string                     `json:"IMAGE_NAME"`
+	ExpectedRevision string                     `json:"EXPECTED_REVISION"`
+	CanaryAgents     []string                   `json:"CANARY_AGENTS"`
+	CanaryPercent    int                        `json:"CANARY_PERCENT"`
+	BatchSize        int                        `json:"BATCH_SIZE"`
+	ReconnectTimeout int                        `json:"RECONNECT_TIMEOUT"`
+	ObserveTime      int                        `json:"OBSERVE_TIME"`
+	FailurePolicy    string                     `json:"FAILURE_POLICY"`
+	State            string                     `json:"STATE"`
+	CurrentWave      int                        `json:"CURRENT_WAVE"` // starts from 0
+	TotalWaves       int                        `json:"TOTAL_WAVES"`
+	TotalAgents      int                        `json:"TOTAL_AGENTS"`
+	HealthyAgents    int                        `json:"HEALTHY_AGENTS"`
+	FailedAgents     int                        `json:"FAILED_AGENTS"`
+	Progress         float64                    `json:"PROGRESS"` // 0-100
+	ErrorMessage     string                     `json:"ERROR_MESSAGE"`
+	StartedAt        string                     `json:"STARTED_AT"`
+	FinishedAt       string                     `json:"FINISHED_AT"`
+	CreatedAt        string                     `json:"CREATED_AT"`
+	UpdatedAt        string                     `json:"UPDATED_AT"`
+	Lcuuid           string                     `json:"LCUUID"`
+	Agents           []AgentUpgradeRolloutAgent `json:"AGENTS,omitempty"`
+}
+
+type AgentUpgradeRolloutAgent struct {
+	Name             string `json:"NAME"`
+	Lcuuid           string `json:"LCUUID"`
+	Wave             int    `json:"WAVE"`
+	State            string `json:"STATE"`
+	PreviousRevision string `json:"PREVIOUS_REVISION"`
+	PreviousImage    string `json:"PREVIOUS_IMAGE"`
+	UpgradedAt       string `json:"UPGRADED_AT"`
+	ReconnectedAt    string `json:"RECONNECTED_AT"`
+	Message          string `json:"MESSAGE"`
+}
+
+type AgentUpgradeRolloutCreate struct {
+	Name             string   `json:"NAME"`
+	AgentGroupID     string   `json:"AGENT_GROUP_ID" binding:"required"` // short uuid of the agent group, such as g-1yhIguXABC
+	ImageName        string   `json:"IMAGE_NAME" binding:"required"`
+	CanaryAgents     []string `json:"CANARY_AGENTS"`     // names of the agents upgraded in the first wave
+	CanaryPercent    int      `json:"CANARY_PERCENT"`    // used if CANARY_AGENTS is empty, 0 means no canary wave
+	BatchSize        int      `json:"BATCH_SIZE"`        // 0 means all the agents left in one wave
+	ReconnectTimeout int      `json:"RECONNECT_TIMEOUT"` // unit: s, 0 means the default of controller
+	ObserveTime      int      `json:"OBSERVE_TIME"`      // unit: s, 0 means the default of controller
+	FailurePolicy    string   `json:"FAILURE_POLICY"`    // pause or rollback, default: pause
+}
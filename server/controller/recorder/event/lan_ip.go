@@ -94,6 +94,7 @@ func (i *LANIP) OnResourceBatchAdded(md *message.Metadata, msg interface{}) {
 			eventapi.TagDescription(fmt.Sprintf(DESCAddIPFormat, deviceName, item.IP, mac, networkName)),
 			eventapi.TagAttributeSubnetIDs([]uint32{uint32(networkID)}),
 			eventapi.TagAttributeIPs([]string{item.IP}),
+			eventapi.TagAttributeMACs([]string{mac}),
 			eventapi.TagSubnetID(uint32(networkID)),
 			eventapi.TagIP(item.IP),
 		}...)
@@ -208,6 +209,7 @@ func (i *LANIP) OnResourceBatchDeleted(md *message.Metadata, msg interface{}) {
 			eventapi.TagDescription(fmt.Sprintf(DESCRemoveIPFormat, deviceName, ip, mac, networkName)),
 			eventapi.TagAttributeSubnetIDs([]uint32{uint32(networkID)}),
 			eventapi.TagAttributeIPs([]string{ip}),
+			eventapi.TagAttributeMACs([]string{mac}),
 			eventapi.TagSubnetID(uint32(networkID)),
 			eventapi.TagIP(ip),
 		)
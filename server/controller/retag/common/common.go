@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	JOB_STATE_PENDING = iota
+	JOB_STATE_RUNNING
+	JOB_STATE_FINISHED
+	JOB_STATE_FAILED
+	JOB_STATE_CANCELLED
+)
+
+var JobStateName = map[int]string{
+	JOB_STATE_PENDING:   "pending",
+	JOB_STATE_RUNNING:   "running",
+	JOB_STATE_FINISHED:  "finished",
+	JOB_STATE_FAILED:    "failed",
+	JOB_STATE_CANCELLED: "cancelled",
+}
+
+// Table is a table whose universal tags can be retagged, the tags are looked up by
+// (l3_epc_id, ip4/ip6) of each side, the same as the ingester does when writing the data
+type Table struct {
+	Database string
+	Name     string
+	Sides    []string // column suffixes of the sides, e.g. '_0' and '_1', or '' for the single side tables
+}
+
+func (t Table) String() string {
+	return t.Database + "." + t.Name
+}
+
+// LocalName is the table to mutate, the rows of the 1h/1d tables are stored in the '_agg' tables
+func (t Table) LocalName() string {
+	if strings.HasSuffix(t.Name, ".1h") || strings.HasSuffix(t.Name, ".1d") {
+		return t.Name + "_agg"
+	}
+	return t.Name + "_local"
+}
+
+// Rollups returns the 1h/1d tables aggregated from the 1m table. The materialized views only aggregate the
+// inserted rows, so the rollups are not retagged by the mutations of the 1m table
+func (t Table) Rollups() []Table {
+	prefix, ok := strings.CutSuffix(t.Name, ".1m")
+	if t.Database != "flow_metrics" || !ok {
+		return nil
+	}
+	return []Table{
+		{t.Database, prefix + ".1h", t.Sides},
+		{t.Database, prefix + ".1d", t.Sides},
+	}
+}
+
+var (
+	edgeSides   = []string{"_0", "_1"}
+	singleSides = []string{""}
+)
+
+var Tables = map[string]Table{}
+
+func init() {
+	for _, t := range []Table{
+		{"flow_log", "l4_flow_log", edgeSides},
+		{"flow_log", "l7_flow_log", edgeSides},
+		{"flow_metrics", "network.1s", singleSides},
+		{"flow_metrics", "network.1m", singleSides},
+		{"flow_metrics", "network_map.1s", edgeSides},
+		{"flow_metrics", "network_map.1m", edgeSides},
+		{"flow_metrics", "application.1s", singleSides},
+		{"flow_metrics", "application.1m", singleSides},
+		{"flow_metrics", "application_map.1s", edgeSides},
+		{"flow_metrics", "application_map.1m", edgeSides},
+	} {
+		Tables[t.String()] = t
+	}
+}
+
+func CheckTables(tables []string) error {
+	if len(tables) == 0 {
+		return fmt.Errorf("tables must not be empty")
+	}
+	for _, t := range tables {
+		if _, ok := Tables[t]; !ok {
+			names := make([]string, 0, len(Tables))
+			for name := range Tables {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("table (%s) does not support retag, supported tables: %v", t, names)
+		}
+	}
+	return nil
+}
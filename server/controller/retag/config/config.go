@@ -0,0 +1,24 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package config
+
+type RetagConfig struct {
+	Enabled         bool `default:"true" yaml:"enabled"`
+	CheckInterval   int  `default:"10" yaml:"check_interval"`     // unit: second, interval to pick up the pending jobs
+	BatchInterval   int  `default:"10" yaml:"batch_interval"`     // unit: second, pause after each mutation, can be overridden by the job
+	MutationTimeout int  `default:"3600" yaml:"mutation_timeout"` // unit: second, timeout of a mutation on one partition
+	QueryTimeout    int  `default:"300" yaml:"query_timeout"`     // unit: second, timeout of counting the rows to change on one partition
+}
@@ -0,0 +1,366 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package retag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	clickhousego "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/deepflowio/deepflow/message/trident"
+	"github.com/deepflowio/deepflow/server/controller/db/clickhouse"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	"github.com/deepflowio/deepflow/server/controller/retag/common"
+	"github.com/deepflowio/deepflow/server/controller/retag/config"
+	"github.com/deepflowio/deepflow/server/controller/trisolaris"
+	"github.com/deepflowio/deepflow/server/libs/ckdb"
+	"github.com/deepflowio/deepflow/server/libs/logger"
+)
+
+var log = logger.MustGetLogger("retag")
+
+var errJobCancelled = errors.New("job cancelled")
+
+type node struct {
+	cfg  clickhouse.ClickHouseConfig
+	conn *sqlx.DB
+}
+
+type step struct {
+	node      *node
+	database  string
+	table     common.Table
+	partition string
+}
+
+// Runner runs the retag jobs on the master controller, one job of each org at a time. A job rewrites the
+// universal tags of the flow logs and metrics in the time range with the current platform data, the
+// ClickHouse partitions are mutated one by one on every node, and the job is restarted from the
+// beginning if the master controller changes, the mutations only touch the rows still to be changed
+type Runner struct {
+	rCtx       context.Context
+	rCancel    context.CancelFunc
+	cfg        config.RetagConfig
+	ckCfg      clickhouse.ClickHouseConfig
+	kubeconfig string
+}
+
+func NewRunner(cfg config.RetagConfig, ckCfg clickhouse.ClickHouseConfig, kubeconfig string, ctx context.Context) *Runner {
+	rCtx, rCancel := context.WithCancel(ctx)
+	return &Runner{
+		rCtx:       rCtx,
+		rCancel:    rCancel,
+		cfg:        cfg,
+		ckCfg:      ckCfg,
+		kubeconfig: kubeconfig,
+	}
+}
+
+func (r *Runner) Start(sCtx context.Context) {
+	if !r.cfg.Enabled {
+		return
+	}
+	log.Info("retag runner start")
+	go func() {
+		ticker := time.NewTicker(time.Duration(r.cfg.CheckInterval) * time.Second)
+		defer ticker.Stop()
+	LOOP:
+		for {
+			select {
+			case <-ticker.C:
+				metadb.GetDBs().DoOnAllDBs(func(db *metadb.DB) error {
+					r.runNext(sCtx, db)
+					return nil
+				})
+			case <-sCtx.Done():
+				break LOOP
+			case <-r.rCtx.Done():
+				break LOOP
+			}
+		}
+	}()
+}
+
+func (r *Runner) Stop() {
+	if r.rCancel != nil {
+		r.rCancel()
+	}
+	log.Info("retag runner stopped")
+}
+
+func (r *Runner) runNext(ctx context.Context, db *metadb.DB) {
+	var job metadbmodel.RetagJob
+	result := db.Where("state IN ?", []int{common.JOB_STATE_PENDING, common.JOB_STATE_RUNNING}).Order("id").Limit(1).Find(&job)
+	if result.Error != nil {
+		log.Errorf("failed to query %s: %s", "retag_job", result.Error, db.LogPrefixORGID)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+	if job.State == common.JOB_STATE_RUNNING {
+		log.Infof("restart retag job (%d) interrupted by the master controller change", job.ID, db.LogPrefixORGID)
+	}
+	now := time.Now()
+	if err := db.Model(&job).Updates(map[string]interface{}{
+		"state": common.JOB_STATE_RUNNING, "started_at": now, "total_steps": 0, "done_steps": 0, "changed_rows": 0, "error_message": "",
+	}).Error; err != nil {
+		log.Errorf("failed to start retag job (%d): %s", job.ID, err, db.LogPrefixORGID)
+		return
+	}
+
+	log.Infof("retag job (%d) start, tables: %s, time range: [%s, %s], dry run: %t",
+		job.ID, job.Tables, job.StartTime, job.EndTime, job.DryRun != 0, db.LogPrefixORGID)
+	err := r.run(ctx, db, &job)
+	state, message := common.JOB_STATE_FINISHED, ""
+	if errors.Is(err, errJobCancelled) {
+		log.Infof("retag job (%d) cancelled", job.ID, db.LogPrefixORGID)
+		return
+	} else if ctx.Err() != nil || r.rCtx.Err() != nil {
+		// keep the job running, it is restarted by the next master controller
+		log.Infof("retag job (%d) interrupted", job.ID, db.LogPrefixORGID)
+		return
+	} else if err != nil {
+		state, message = common.JOB_STATE_FAILED, err.Error()
+		log.Errorf("retag job (%d) failed: %s", job.ID, message, db.LogPrefixORGID)
+	} else {
+		log.Infof("retag job (%d) finished", job.ID, db.LogPrefixORGID)
+	}
+	if err := db.Model(&metadbmodel.RetagJob{}).Where("id = ? AND state = ?", job.ID, common.JOB_STATE_RUNNING).Updates(map[string]interface{}{
+		"state": state, "error_message": message, "finished_at": time.Now(),
+	}).Error; err != nil {
+		log.Errorf("failed to update retag job (%d): %s", job.ID, err, db.LogPrefixORGID)
+	}
+}
+
+func (r *Runner) run(ctx context.Context, db *metadb.DB, job *metadbmodel.RetagJob) error {
+	var tableNames []string
+	if err := json.Unmarshal([]byte(job.Tables), &tableNames); err != nil {
+		return fmt.Errorf("invalid tables %s: %s", job.Tables, err)
+	}
+	if err := common.CheckTables(tableNames); err != nil {
+		return err
+	}
+	rows, err := r.getTagRows(db.ORGID)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := r.connect()
+	if err != nil {
+		return err
+	}
+	joinTable := joinTableName(db.ORGID, job.ID)
+	defer func() {
+		for _, n := range nodes {
+			if _, err := n.conn.Exec(dropJoinTableSQL(joinTable)); err != nil {
+				log.Warningf("drop %s on %s failed: %s", joinTable, n.cfg.Host, err, db.LogPrefixORGID)
+			}
+			n.conn.Close()
+		}
+	}()
+	for _, n := range nodes {
+		if err := createJoinTable(ctx, n, joinTable, rows); err != nil {
+			return fmt.Errorf("create %s on %s failed: %s", joinTable, n.cfg.Host, err)
+		}
+	}
+
+	steps, err := r.plan(ctx, db.ORGID, job, nodes, tableNames)
+	if err != nil {
+		return err
+	}
+	if err := updateProgress(db, job.ID, map[string]interface{}{"total_steps": len(steps)}); err != nil {
+		return err
+	}
+	log.Infof("retag job (%d) has %d partitions to retag with %d platform data rows", job.ID, len(steps), len(rows), db.LogPrefixORGID)
+
+	batchInterval := r.cfg.BatchInterval
+	if job.BatchInterval > 0 {
+		batchInterval = job.BatchInterval
+	}
+	var changedRows int64
+	for i, s := range steps {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.rCtx.Done():
+			return r.rCtx.Err()
+		default:
+		}
+
+		count, err := r.count(ctx, s, joinTable, job)
+		if err != nil {
+			return fmt.Errorf("count rows of %s partition %s on %s failed: %s", s.table, s.partition, s.node.cfg.Host, err)
+		}
+		if job.DryRun == 0 && count > 0 {
+			if err := r.mutate(ctx, s, joinTable, job); err != nil {
+				return fmt.Errorf("retag %s partition %s on %s failed: %s", s.table, s.partition, s.node.cfg.Host, err)
+			}
+			log.Infof("retag job (%d) updated %d rows of %s partition %s on %s",
+				job.ID, count, s.table, s.partition, s.node.cfg.Host, db.LogPrefixORGID)
+		}
+		changedRows += int64(count)
+		if err := updateProgress(db, job.ID, map[string]interface{}{"done_steps": i + 1, "changed_rows": changedRows}); err != nil {
+			return err
+		}
+		if job.DryRun == 0 && count > 0 && batchInterval > 0 && i+1 < len(steps) {
+			// throttle the mutations to limit the extra load on ClickHouse
+			if err := r.wait(ctx, time.Duration(batchInterval)*time.Second); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// wait returns after the duration, or the error of the context if the job is interrupted
+func (r *Runner) wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.rCtx.Done():
+		return r.rCtx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// updateProgress updates the job only if it is still running, errJobCancelled is returned if the job
+// is cancelled or deleted
+func updateProgress(db *metadb.DB, jobID int, values map[string]interface{}) error {
+	result := db.Model(&metadbmodel.RetagJob{}).Where("id = ? AND state = ?", jobID, common.JOB_STATE_RUNNING).Updates(values)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errJobCancelled
+	}
+	return nil
+}
+
+func (r *Runner) getTagRows(orgID int) (map[string]tagValues, error) {
+	metaData := trisolaris.GetMetaData(orgID)
+	if metaData == nil {
+		return nil, fmt.Errorf("platform data of org (%d) is not ready", orgID)
+	}
+	platformData := &trident.PlatformData{}
+	if err := platformData.Unmarshal(metaData.GetPlatformDataOP().GetAllPlatformDataForIngester().GetPlatformDataStr()); err != nil {
+		return nil, fmt.Errorf("unmarshal platform data failed: %s", err)
+	}
+	rows := newTagRows(platformData)
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("platform data of org (%d) is empty", orgID)
+	}
+	return rows, nil
+}
+
+func (r *Runner) connect() ([]*node, error) {
+	cfgs, err := clickhouse.GetEndpoints(r.ckCfg, r.kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("no ClickHouse endpoint found")
+	}
+	nodes := make([]*node, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		conn, err := clickhouse.Connect(cfg)
+		if err != nil {
+			for _, n := range nodes {
+				n.conn.Close()
+			}
+			return nil, err
+		}
+		nodes = append(nodes, &node{cfg: cfg, conn: conn})
+	}
+	return nodes, nil
+}
+
+func createJoinTable(ctx context.Context, n *node, joinTable string, rows map[string]tagValues) error {
+	// the mutations left by the interrupted run of the job would fail once the join table is dropped
+	if _, err := n.conn.ExecContext(ctx, killMutationSQL(joinTable, "", "")); err != nil {
+		return err
+	}
+	if _, err := n.conn.ExecContext(ctx, dropJoinTableSQL(joinTable)); err != nil {
+		return err
+	}
+	if _, err := n.conn.ExecContext(ctx, createJoinTableSQL(joinTable)); err != nil {
+		return err
+	}
+	for _, sql := range insertJoinTableSQLs(joinTable, rows) {
+		if _, err := n.conn.ExecContext(ctx, sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// plan lists the partitions of each table and its rollups overlapping the time range on every node, the
+// tables not created yet are skipped
+func (r *Runner) plan(ctx context.Context, orgID int, job *metadbmodel.RetagJob, nodes []*node, tableNames []string) ([]step, error) {
+	var steps []step
+	for _, n := range nodes {
+		for _, name := range tableNames {
+			for _, table := range append([]common.Table{common.Tables[name]}, common.Tables[name].Rollups()...) {
+				database := ckdb.OrgDatabasePrefix(uint16(orgID)) + table.Database
+				var partitions []string
+				if err := n.conn.SelectContext(ctx, &partitions, listPartitionsSQL(database, table, job.StartTime, job.EndTime)); err != nil {
+					return nil, fmt.Errorf("list partitions of %s on %s failed: %s", table, n.cfg.Host, err)
+				}
+				for _, p := range partitions {
+					steps = append(steps, step{node: n, database: database, table: table, partition: p})
+				}
+			}
+		}
+	}
+	return steps, nil
+}
+
+func (r *Runner) count(ctx context.Context, s step, joinTable string, job *metadbmodel.RetagJob) (uint64, error) {
+	qCtx, cancel := context.WithTimeout(ctx, time.Duration(r.cfg.QueryTimeout)*time.Second)
+	defer cancel()
+	var count uint64
+	err := s.node.conn.GetContext(qCtx, &count, countSQL(s.database, joinTable, s.table, s.partition, job.StartTime, job.EndTime))
+	return count, err
+}
+
+func (r *Runner) mutate(ctx context.Context, s step, joinTable string, job *metadbmodel.RetagJob) error {
+	mCtx, cancel := context.WithTimeout(ctx, time.Duration(r.cfg.MutationTimeout)*time.Second)
+	defer cancel()
+	// wait for the mutation to be done, joinGet reads the join table which is nondeterministic for the mutation
+	mCtx = clickhousego.Context(mCtx, clickhousego.WithSettings(clickhousego.Settings{
+		"mutations_sync":                   1,
+		"allow_nondeterministic_mutations": 1,
+	}))
+	_, err := s.node.conn.ExecContext(mCtx, mutationSQL(s.database, joinTable, s.table, s.partition, job.StartTime, job.EndTime))
+	if err != nil {
+		// ClickHouse keeps running the mutation after the timeout or cancellation, kill it before the join table is dropped
+		kCtx, kCancel := context.WithTimeout(context.Background(), time.Duration(r.cfg.QueryTimeout)*time.Second)
+		defer kCancel()
+		if _, kErr := s.node.conn.ExecContext(kCtx, killMutationSQL(joinTable, s.database, s.table.LocalName())); kErr != nil {
+			return fmt.Errorf("%s, and kill the mutation failed: %s", err, kErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,287 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package retag
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deepflowio/deepflow/message/trident"
+	"github.com/deepflowio/deepflow/server/controller/retag/common"
+)
+
+const (
+	// the same as the auto instance/service types in ingester/common
+	ipType          = 255
+	internetIPType  = 0
+	podType         = 10
+	podServiceType  = 12
+	podNodeType     = 14
+	podClusterType  = 103
+	customSvcType   = 104
+	processType     = 120
+	epcFromInternet = -2
+
+	joinTableDatabase = "default"
+	joinKeyColumn     = "key"
+	joinFoundColumn   = "found"
+	insertBatchSize   = 10000
+)
+
+type tagColumn struct {
+	name   string
+	ckType string
+}
+
+// tagColumns are the columns rewritten by a retag job, l3_epc_id is not rewritten
+// since it is a part of the sorting key and also the lookup key of the tags
+var tagColumns = []tagColumn{
+	{"region_id", "UInt16"},
+	{"az_id", "UInt16"},
+	{"host_id", "UInt16"},
+	{"l3_device_type", "UInt8"},
+	{"l3_device_id", "UInt32"},
+	{"pod_node_id", "UInt32"},
+	{"pod_ns_id", "UInt16"},
+	{"pod_group_id", "UInt32"},
+	{"pod_id", "UInt32"},
+	{"pod_cluster_id", "UInt16"},
+	{"subnet_id", "UInt16"},
+	{"auto_instance_id", "UInt32"},
+	{"auto_instance_type", "UInt8"},
+	{"auto_service_id", "UInt32"},
+	{"auto_service_type", "UInt8"},
+}
+
+// tagValues are the values of tagColumns in the same order
+type tagValues [15]uint32
+
+// newTagRows builds the corrected tags of each (l3_epc_id, ip) from the platform data, the key is
+// formatted as '<l3_epc_id>/<ip>'. IPs only matched by cidrs, pod services, custom services and
+// processes are not covered, the tags of these rows are kept unchanged
+func newTagRows(platformData *trident.PlatformData) map[string]tagValues {
+	rows := make(map[string]tagValues)
+	for _, intf := range platformData.GetInterfaces() {
+		epcID := int32(intf.GetEpcId())
+		if epcID == 0 {
+			epcID = epcFromInternet
+		}
+		deviceType := intf.GetDeviceType()
+		deviceID := intf.GetDeviceId()
+		podNodeID := intf.GetPodNodeId()
+		podGroupID := intf.GetPodGroupId()
+		podGroupType := intf.GetPodGroupType()
+		podID := intf.GetPodId()
+		podClusterID := intf.GetPodClusterId()
+		for _, ipRes := range intf.GetIpResources() {
+			ip := net.ParseIP(ipRes.GetIp())
+			if ip == nil {
+				continue
+			}
+			subnetID := ipRes.GetSubnetId()
+			autoInstanceID, autoInstanceType := getAutoInstance(podID, podNodeID, deviceID, subnetID, deviceType, epcID)
+			autoServiceID, autoServiceType := getAutoService(podGroupID, podClusterID, deviceID, subnetID, deviceType, podGroupType, epcID)
+			rows[joinKey(epcID, ip)] = tagValues{
+				intf.GetRegionId(),
+				intf.GetAzId(),
+				intf.GetLaunchServerId(),
+				deviceType,
+				deviceID,
+				podNodeID,
+				intf.GetPodNsId(),
+				podGroupID,
+				podID,
+				podClusterID,
+				subnetID,
+				autoInstanceID,
+				autoInstanceType,
+				autoServiceID,
+				autoServiceType,
+			}
+		}
+	}
+	return rows
+}
+
+func joinKey(epcID int32, ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return strconv.Itoa(int(epcID)) + "/" + ip.String()
+}
+
+func getAutoInstance(podID, podNodeID, deviceID, subnetID, deviceType uint32, epcID int32) (uint32, uint32) {
+	if podID > 0 {
+		return podID, podType
+	} else if podNodeID > 0 {
+		return podNodeID, podNodeType
+	} else if deviceID > 0 {
+		return deviceID, deviceType
+	} else if epcID == epcFromInternet {
+		return 0, internetIPType
+	}
+	return subnetID, ipType
+}
+
+func getAutoService(podGroupID, podClusterID, deviceID, subnetID, deviceType, podGroupType uint32, epcID int32) (uint32, uint32) {
+	if podGroupID > 0 {
+		return podGroupID, podGroupType
+	} else if podClusterID > 0 {
+		return podClusterID, podClusterType
+	} else if deviceID > 0 {
+		return deviceID, deviceType
+	} else if epcID == epcFromInternet {
+		return 0, internetIPType
+	}
+	return subnetID, ipType
+}
+
+func joinTableName(orgID, jobID int) string {
+	return fmt.Sprintf("%s.deepflow_retag_%d_%d", joinTableDatabase, orgID, jobID)
+}
+
+func createJoinTableSQL(joinTable string) string {
+	columns := []string{joinKeyColumn + " String", joinFoundColumn + " UInt8"}
+	for _, c := range tagColumns {
+		columns = append(columns, c.name+" "+c.ckType)
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = Join(ANY, LEFT, %s)",
+		joinTable, strings.Join(columns, ", "), joinKeyColumn)
+}
+
+func dropJoinTableSQL(joinTable string) string {
+	return "DROP TABLE IF EXISTS " + joinTable
+}
+
+// killMutationSQL kills the unfinished mutations reading the join table, which would fail forever
+// once the join table is dropped and block the later mutations of the table. The mutations of all
+// tables are killed if database is empty
+func killMutationSQL(joinTable, database, localTable string) string {
+	conditions := []string{fmt.Sprintf("position(command, 'joinGet(\\'%s\\'') > 0", joinTable)}
+	if database != "" {
+		conditions = append(conditions, fmt.Sprintf("database = '%s' AND table = '%s'", database, localTable))
+	}
+	return fmt.Sprintf("KILL MUTATION WHERE %s SYNC", strings.Join(conditions, " AND "))
+}
+
+// insertJoinTableSQLs returns the insert statements of the rows in batches, the rows are sorted by key
+func insertJoinTableSQLs(joinTable string, rows map[string]tagValues) []string {
+	keys := make([]string, 0, len(rows))
+	for k := range rows {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	columns := []string{joinKeyColumn, joinFoundColumn}
+	for _, c := range tagColumns {
+		columns = append(columns, c.name)
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", joinTable, strings.Join(columns, ", "))
+
+	var sqls []string
+	var sb strings.Builder
+	for i, k := range keys {
+		if i%insertBatchSize == 0 {
+			if sb.Len() > 0 {
+				sqls = append(sqls, sb.String())
+			}
+			sb.Reset()
+			sb.WriteString(prefix)
+		} else {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("('")
+		sb.WriteString(k)
+		sb.WriteString("', 1")
+		for _, v := range rows[k] {
+			sb.WriteString(", ")
+			sb.WriteString(strconv.FormatUint(uint64(v), 10))
+		}
+		sb.WriteString(")")
+	}
+	if sb.Len() > 0 {
+		sqls = append(sqls, sb.String())
+	}
+	return sqls
+}
+
+func keyExpr(side string) string {
+	return fmt.Sprintf("concat(toString(l3_epc_id%s), '/', if(is_ipv4 = 1, toString(ip4%s), toString(ip6%s)))", side, side, side)
+}
+
+func joinGetExpr(joinTable, column, side string) string {
+	return fmt.Sprintf("joinGet('%s', '%s', %s)", joinTable, column, keyExpr(side))
+}
+
+// newTagExpr returns the expression of the corrected value of the column, the value is kept
+// if the (l3_epc_id, ip) is not found in the platform data, or it can not be derived from the
+// platform data, e.g. the auto service of the pod service or custom service, the auto instance
+// and auto service of the process
+func newTagExpr(joinTable, column, side string) string {
+	current := column + side
+	found := fmt.Sprintf("%s = 1", joinGetExpr(joinTable, joinFoundColumn, side))
+	switch column {
+	case "auto_instance_id", "auto_instance_type":
+		found = fmt.Sprintf("%s AND NOT (auto_instance_type%s = %d AND %s = 0)",
+			found, side, processType, joinGetExpr(joinTable, "pod_id", side))
+	case "auto_service_id", "auto_service_type":
+		found = fmt.Sprintf("%s AND auto_service_type%s NOT IN (%d, %d) AND NOT (auto_service_type%s = %d AND %s = 0)",
+			found, side, customSvcType, podServiceType, side, processType, joinGetExpr(joinTable, "pod_group_id", side))
+	}
+	return fmt.Sprintf("if(%s, %s, %s)", found, joinGetExpr(joinTable, column, side), current)
+}
+
+func timeCondition(startTime, endTime time.Time) string {
+	return fmt.Sprintf("time >= %d AND time <= %d", startTime.Unix(), endTime.Unix())
+}
+
+// changedCondition matches the rows with at least one tag column to be changed
+func changedCondition(joinTable string, table common.Table) string {
+	var conditions []string
+	for _, side := range table.Sides {
+		for _, c := range tagColumns {
+			conditions = append(conditions, fmt.Sprintf("%s%s != %s", c.name, side, newTagExpr(joinTable, c.name, side)))
+		}
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+func listPartitionsSQL(database string, table common.Table, startTime, endTime time.Time) string {
+	return fmt.Sprintf(
+		"SELECT DISTINCT partition_id FROM system.parts WHERE database = '%s' AND table = '%s' AND active = 1 AND max_time >= %d AND min_time <= %d ORDER BY partition_id",
+		database, table.LocalName(), startTime.Unix(), endTime.Unix())
+}
+
+func countSQL(database, joinTable string, table common.Table, partition string, startTime, endTime time.Time) string {
+	return fmt.Sprintf("SELECT count() FROM %s.`%s` WHERE _partition_id = '%s' AND %s AND %s",
+		database, table.LocalName(), partition, timeCondition(startTime, endTime), changedCondition(joinTable, table))
+}
+
+func mutationSQL(database, joinTable string, table common.Table, partition string, startTime, endTime time.Time) string {
+	var assignments []string
+	for _, side := range table.Sides {
+		for _, c := range tagColumns {
+			assignments = append(assignments, fmt.Sprintf("%s%s = %s", c.name, side, newTagExpr(joinTable, c.name, side)))
+		}
+	}
+	return fmt.Sprintf("ALTER TABLE %s.`%s` UPDATE %s IN PARTITION ID '%s' WHERE %s AND %s",
+		database, table.LocalName(), strings.Join(assignments, ", "), partition,
+		timeCondition(startTime, endTime), changedCondition(joinTable, table))
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package retag
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/deepflowio/deepflow/message/trident"
+	"github.com/deepflowio/deepflow/server/controller/retag/common"
+)
+
+func TestNewTagRows(t *testing.T) {
+	platformData := &trident.PlatformData{
+		Interfaces: []*trident.Interface{
+			{
+				EpcId:          proto.Uint32(3),
+				DeviceType:     proto.Uint32(1),
+				DeviceId:       proto.Uint32(11),
+				RegionId:       proto.Uint32(2),
+				AzId:           proto.Uint32(4),
+				LaunchServerId: proto.Uint32(5),
+				PodNodeId:      proto.Uint32(6),
+				PodNsId:        proto.Uint32(7),
+				PodGroupId:     proto.Uint32(8),
+				PodGroupType:   proto.Uint32(1),
+				PodId:          proto.Uint32(9),
+				PodClusterId:   proto.Uint32(10),
+				IpResources: []*trident.IpResource{
+					{Ip: proto.String("10.0.0.1"), SubnetId: proto.Uint32(12)},
+					{Ip: proto.String("2001:db8:0::1"), SubnetId: proto.Uint32(13)},
+				},
+			},
+			{
+				DeviceType:  proto.Uint32(0),
+				IpResources: []*trident.IpResource{{Ip: proto.String("1.1.1.1")}, {Ip: proto.String("invalid")}},
+			},
+			{
+				EpcId:       proto.Uint32(3),
+				IpResources: []*trident.IpResource{{Ip: proto.String("10.0.0.2"), SubnetId: proto.Uint32(14)}},
+			},
+		},
+	}
+	rows := newTagRows(platformData)
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 rows, got %v", rows)
+	}
+	expected := map[string]tagValues{
+		"3/10.0.0.1":    {2, 4, 5, 1, 11, 6, 7, 8, 9, 10, 12, 9, podType, 8, 1},
+		"3/2001:db8::1": {2, 4, 5, 1, 11, 6, 7, 8, 9, 10, 13, 9, podType, 8, 1},
+		"-2/1.1.1.1":    {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, internetIPType, 0, internetIPType},
+		"3/10.0.0.2":    {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 14, 14, ipType, 14, ipType},
+	}
+	for k, v := range expected {
+		if rows[k] != v {
+			t.Errorf("key %s, expected %v, got %v", k, v, rows[k])
+		}
+	}
+}
+
+func TestInsertJoinTableSQLs(t *testing.T) {
+	rows := make(map[string]tagValues)
+	for i := 0; i < insertBatchSize+1; i++ {
+		rows[joinKey(int32(i), []byte{10, 0, 0, 1})] = tagValues{uint32(i)}
+	}
+	sqls := insertJoinTableSQLs("default.t", rows)
+	if len(sqls) != 2 {
+		t.Fatalf("expected 2 batches, got %d", len(sqls))
+	}
+	if !strings.HasPrefix(sqls[1], "INSERT INTO default.t (key, found, region_id, ") ||
+		!strings.HasSuffix(sqls[1], "('9999/10.0.0.1', 1, 9999, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)") {
+		t.Errorf("unexpected sql %s", sqls[1])
+	}
+	if n := strings.Count(sqls[0], "('"); n != insertBatchSize {
+		t.Errorf("expected %d rows in the first batch, got %d", insertBatchSize, n)
+	}
+}
+
+func TestMutationSQL(t *testing.T) {
+	start, end := time.Unix(1700000000, 0), time.Unix(1700003600, 0)
+	table := common.Tables["flow_log.l4_flow_log"]
+	sql := mutationSQL("0002_flow_log", "default.deepflow_retag_2_1", table, "1699995600", start, end)
+	for _, s := range []string{
+		"ALTER TABLE 0002_flow_log.`l4_flow_log_local` UPDATE region_id_0 = if(joinGet('default.deepflow_retag_2_1', 'found', concat(toString(l3_epc_id_0), '/', if(is_ipv4 = 1, toString(ip4_0), toString(ip6_0)))) = 1, ",
+		"auto_service_type_1 = if(",
+		"auto_service_type_1 NOT IN (104, 12) AND NOT (auto_service_type_1 = 120 AND joinGet('default.deepflow_retag_2_1', 'pod_group_id', ",
+		"IN PARTITION ID '1699995600' WHERE time >= 1700000000 AND time <= 1700003600 AND (region_id_0 != ",
+	} {
+		if !strings.Contains(sql, s) {
+			t.Errorf("%s not found in %s", s, sql)
+		}
+	}
+	if strings.Contains(sql, "l3_epc_id_0 =") {
+		t.Errorf("l3_epc_id should not be updated: %s", sql)
+	}
+
+	sql = killMutationSQL("default.deepflow_retag_2_1", "0002_flow_log", table.LocalName())
+	if sql != "KILL MUTATION WHERE position(command, 'joinGet(\\'default.deepflow_retag_2_1\\'') > 0 AND database = '0002_flow_log' AND table = 'l4_flow_log_local' SYNC" {
+		t.Errorf("unexpected sql %s", sql)
+	}
+	if sql = killMutationSQL("default.deepflow_retag_2_1", "", ""); strings.Contains(sql, "database") {
+		t.Errorf("unexpected sql %s", sql)
+	}
+
+	sql = countSQL("flow_metrics", "default.deepflow_retag_1_1", common.Tables["flow_metrics.network.1m"], "p", start, end)
+	if !strings.HasPrefix(sql, "SELECT count() FROM flow_metrics.`network.1m_local` WHERE _partition_id = 'p' AND ") ||
+		!strings.Contains(sql, "concat(toString(l3_epc_id), '/', if(is_ipv4 = 1, toString(ip4), toString(ip6)))") {
+		t.Errorf("unexpected sql %s", sql)
+	}
+}
+
+func TestRollups(t *testing.T) {
+	if rollups := common.Tables["flow_log.l7_flow_log"].Rollups(); len(rollups) != 0 {
+		t.Errorf("flow log has no rollups, got %v", rollups)
+	}
+	if rollups := common.Tables["flow_metrics.network.1s"].Rollups(); len(rollups) != 0 {
+		t.Errorf("1s table has no rollups, got %v", rollups)
+	}
+	rollups := common.Tables["flow_metrics.network_map.1m"].Rollups()
+	if len(rollups) != 2 || rollups[0].LocalName() != "network_map.1h_agg" || rollups[1].LocalName() != "network_map.1d_agg" || len(rollups[1].Sides) != 2 {
+		t.Fatalf("unexpected rollups %v", rollups)
+	}
+
+	start, end := time.Unix(1700000000, 0), time.Unix(1700003600, 0)
+	sql := mutationSQL("flow_metrics", "default.deepflow_retag_1_1", rollups[0], "p", start, end)
+	if !strings.HasPrefix(sql, "ALTER TABLE flow_metrics.`network_map.1h_agg` UPDATE region_id_0 = ") {
+		t.Errorf("unexpected sql %s", sql)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"strings"
+)
+
+const (
+	ROLLOUT_STATE_PENDING = iota
+	ROLLOUT_STATE_RUNNING
+	ROLLOUT_STATE_PAUSED
+	ROLLOUT_STATE_FINISHED
+	ROLLOUT_STATE_FAILED
+	ROLLOUT_STATE_ROLLING_BACK
+	ROLLOUT_STATE_ROLLED_BACK
+	ROLLOUT_STATE_CANCELLED
+)
+
+var RolloutStateName = map[int]string{
+	ROLLOUT_STATE_PENDING:      "pending",
+	ROLLOUT_STATE_RUNNING:      "running",
+	ROLLOUT_STATE_PAUSED:       "paused",
+	ROLLOUT_STATE_FINISHED:     "finished",
+	ROLLOUT_STATE_FAILED:       "failed",
+	ROLLOUT_STATE_ROLLING_BACK: "rolling_back",
+	ROLLOUT_STATE_ROLLED_BACK:  "rolled_back",
+	ROLLOUT_STATE_CANCELLED:    "cancelled",
+}
+
+const (
+	AGENT_STATE_WAITING = iota
+	AGENT_STATE_UPGRADING
+	AGENT_STATE_HEALTHY
+	AGENT_STATE_FAILED
+	AGENT_STATE_SKIPPED
+	AGENT_STATE_ROLLED_BACK
+)
+
+var AgentStateName = map[int]string{
+	AGENT_STATE_WAITING:     "waiting",
+	AGENT_STATE_UPGRADING:   "upgrading",
+	AGENT_STATE_HEALTHY:     "healthy",
+	AGENT_STATE_FAILED:      "failed",
+	AGENT_STATE_SKIPPED:     "skipped",
+	AGENT_STATE_ROLLED_BACK: "rolled_back",
+}
+
+// what to do when an agent of the current wave fails the health gates
+const (
+	FAILURE_POLICY_PAUSE = iota
+	FAILURE_POLICY_ROLLBACK
+)
+
+var FailurePolicyName = map[int]string{
+	FAILURE_POLICY_PAUSE:    "pause",
+	FAILURE_POLICY_ROLLBACK: "rollback",
+}
+
+// RealRevision returns the revision an agent reports without the branch, e.g. 'v6.6 10000-abcdef' -> '10000-abcdef',
+// it is compared with the expected revision built from rev_count and commit_id of the agent image
+func RealRevision(revision string) string {
+	splitStr := strings.Split(revision, " ")
+	if len(splitStr) == 2 {
+		return splitStr[1]
+	}
+	return revision
+}
@@ -0,0 +1,32 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package config
+
+type RolloutConfig struct {
+	Enabled          bool            `default:"true" yaml:"enabled"`
+	CheckInterval    int             `default:"10" yaml:"check_interval"`     // unit: second, interval to advance the rollouts
+	ReconnectTimeout int             `default:"600" yaml:"reconnect_timeout"` // unit: second, can be overridden by the rollout
+	ObserveTime      int             `default:"300" yaml:"observe_time"`      // unit: second, can be overridden by the rollout
+	DropCheck        DropCheckConfig `yaml:"drop_check"`
+}
+
+// DropCheckConfig compares the drop counters reported by an agent after the upgrade with those before it
+type DropCheckConfig struct {
+	Enabled      bool    `default:"true" yaml:"enabled"`
+	GrowthRatio  float64 `default:"2" yaml:"growth_ratio"`   // fail if the drop rate grows more than the ratio
+	MinRate      float64 `default:"10" yaml:"min_rate"`      // unit: drops per second, rates not greater than it are always stable
+	QueryTimeout int     `default:"30" yaml:"query_timeout"` // unit: second
+}
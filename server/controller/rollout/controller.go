@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rollout
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/deepflowio/deepflow/server/controller/common"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+)
+
+const (
+	upgradeURLFormat       = "http://%s:%d/v1/upgrade/vtap/%s/"
+	cancelUpgradeURLFormat = "http://%s:%d/v1/cancel-upgrade/vtap/%s/"
+)
+
+// upgradeAgent sets the image of the agent to upgrade to. The expected revision of an agent is kept in the
+// vtap cache of the controllers, so it is sent to the master region controllers and the controllers the agent
+// connects to, the same as deepflow-ctl agent-upgrade does. It fails if the controllers of the agent fail.
+func upgradeAgent(db *metadb.DB, vtap *metadbmodel.VTap, imageName string) error {
+	return sendToControllers(db, vtap, upgradeURLFormat, map[string]interface{}{"image_name": imageName})
+}
+
+// cancelAgentUpgrade cancels the upgrade not done yet, it fails if the agent has been upgraded
+func cancelAgentUpgrade(db *metadb.DB, vtap *metadbmodel.VTap) error {
+	return sendToControllers(db, vtap, cancelUpgradeURLFormat, nil)
+}
+
+func sendToControllers(db *metadb.DB, vtap *metadbmodel.VTap, urlFormat string, body map[string]interface{}) error {
+	if vtap.ControllerIP == "" && vtap.CurControllerIP == "" {
+		return fmt.Errorf("agent (%s) is not assigned to any controller", vtap.Name)
+	}
+	var controllers []metadbmodel.Controller
+	if err := metadb.DefaultDB.Where("state = ?", common.CONTROLLER_STATE_NORMAL).Find(&controllers).Error; err != nil {
+		return err
+	}
+	var sent bool
+	var errs []string
+	for _, c := range controllers {
+		ofAgent := c.IP == vtap.ControllerIP || c.IP == vtap.CurControllerIP
+		if !ofAgent && c.NodeType != common.CONTROLLER_NODE_TYPE_MASTER {
+			continue
+		}
+		ip, port := controllerAddress(&c)
+		url := fmt.Sprintf(urlFormat, common.GetCURLIP(ip), port, vtap.Lcuuid)
+		if _, err := common.CURLPerform("PATCH", url, body, common.WithORGHeader(strconv.Itoa(db.ORGID))); err != nil {
+			if ofAgent {
+				errs = append(errs, fmt.Sprintf("controller %s: %s", c.IP, err.Error()))
+			} else {
+				log.Warningf("request controller %s failed: %s", c.IP, err.Error(), db.LogPrefixORGID)
+			}
+			continue
+		}
+		if ofAgent {
+			sent = true
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	if !sent {
+		return fmt.Errorf("controller %s of agent (%s) not found", vtap.ControllerIP, vtap.Name)
+	}
+	return nil
+}
+
+// controllerAddress prefers the pod ip of the controller in the same cluster, the node port otherwise
+func controllerAddress(c *metadbmodel.Controller) (string, int) {
+	if c.PodIP != "" && common.IsTCPActive(c.PodIP, common.GConfig.HTTPPort) == nil {
+		return c.PodIP, common.GConfig.HTTPPort
+	}
+	return c.IP, common.GConfig.HTTPNodePort
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/controller/db/clickhouse"
+	"github.com/deepflowio/deepflow/server/libs/ckdb"
+)
+
+// drop counters reported by the agents to deepflow_tenant.deepflow_collector, the counters are the increments
+// of each stats interval
+var dropCounters = []struct {
+	table  string
+	metric string
+}{
+	{"deepflow_agent_dispatcher", "kernel_drops"},
+	{"deepflow_agent_collect_sender", "dropped"},
+}
+
+func dropRateSQL(orgID int) string {
+	var tables, values []string
+	for _, c := range dropCounters {
+		tables = append(tables, fmt.Sprintf("'%s'", c.table))
+		values = append(values, fmt.Sprintf(
+			"virtual_table_name='%s', if(indexOf(metrics_float_names, '%s')=0, 0, metrics_float_values[indexOf(metrics_float_names, '%s')])",
+			c.table, c.metric, c.metric))
+	}
+	return fmt.Sprintf(
+		"SELECT sum(multiIf(%s, 0)) FROM %sdeepflow_tenant.`deepflow_collector` "+
+			"WHERE virtual_table_name IN (%s) AND tag_values[indexOf(tag_names, 'host')] = ? "+
+			"AND time >= toDateTime(?) AND time < toDateTime(?)",
+		strings.Join(values, ", "), ckdb.OrgDatabasePrefix(uint16(orgID)), strings.Join(tables, ", "))
+}
+
+// dropRate returns the drops per second of the agent in [start, end), the agent is identified by its hostname
+// which is the host tag of its stats
+func (r *Runner) dropRate(ctx context.Context, orgID int, host string, start, end time.Time) (float64, error) {
+	seconds := end.Sub(start).Seconds()
+	if seconds < 1 {
+		return 0, nil
+	}
+	conn, err := clickhouse.Connect(r.ckCfg)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	qCtx, cancel := context.WithTimeout(ctx, time.Duration(r.cfg.DropCheck.QueryTimeout)*time.Second)
+	defer cancel()
+	var drops float64
+	if err := conn.GetContext(qCtx, &drops, dropRateSQL(orgID), host, start.Unix(), end.Unix()); err != nil {
+		return 0, err
+	}
+	return drops / seconds, nil
+}
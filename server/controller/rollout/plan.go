@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rollout
+
+import (
+	"fmt"
+	"time"
+
+	ctrlcommon "github.com/deepflowio/deepflow/server/controller/common"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	"github.com/deepflowio/deepflow/server/controller/rollout/common"
+)
+
+// planWaves splits the agents into the waves of a rollout. The first wave is the canary: the canary agents if
+// given, otherwise the percentage of the agents rounded up, the agents left are upgraded in batches of batchSize,
+// all in one wave if batchSize is 0. The agents keep their order in the waves.
+func planWaves(agents []string, canary []string, canaryPercent, batchSize int) [][]string {
+	if len(agents) == 0 {
+		return nil
+	}
+	var waves [][]string
+	rest := agents
+	if len(canary) > 0 {
+		canarySet := make(map[string]struct{}, len(canary))
+		for _, name := range canary {
+			canarySet[name] = struct{}{}
+		}
+		var first, left []string
+		for _, name := range agents {
+			if _, ok := canarySet[name]; ok {
+				first = append(first, name)
+			} else {
+				left = append(left, name)
+			}
+		}
+		if len(first) > 0 {
+			waves = append(waves, first)
+		}
+		rest = left
+	} else if canaryPercent > 0 {
+		n := (len(agents)*canaryPercent + 99) / 100
+		waves = append(waves, agents[:n])
+		rest = agents[n:]
+	}
+	for len(rest) > 0 {
+		n := len(rest)
+		if batchSize > 0 && batchSize < n {
+			n = batchSize
+		}
+		waves = append(waves, rest[:n])
+		rest = rest[n:]
+	}
+	return waves
+}
+
+type verdict int
+
+const (
+	verdictWait verdict = iota
+	verdictReconnected
+	verdictObserved
+	verdictFailed
+)
+
+type gate struct {
+	expectedRevision string
+	reconnectTimeout time.Duration
+	observeTime      time.Duration
+}
+
+// check checks an upgrading agent with its vtap. The agent has to reconnect on the expected revision in the
+// reconnect timeout, then stay connected on it without new exceptions during the observe time. The drop
+// counters are checked by the runner after the agent is observed, they are not in metadb.
+func (g gate) check(agent *metadbmodel.AgentUpgradeRolloutAgent, vtap *metadbmodel.VTap, now time.Time) (verdict, string) {
+	revision := common.RealRevision(vtap.Revision)
+	if agent.ReconnectedAt == nil {
+		if vtap.State == ctrlcommon.VTAP_STATE_NORMAL && revision == g.expectedRevision {
+			return verdictReconnected, ""
+		}
+		if agent.UpgradedAt != nil && now.Sub(*agent.UpgradedAt) > g.reconnectTimeout {
+			state := ctrlcommon.VTAP_STATE_NORMAL_STR
+			if vtap.State != ctrlcommon.VTAP_STATE_NORMAL {
+				state = ctrlcommon.VTAP_STATE_NOT_CONNECTED_STR
+			}
+			return verdictFailed, fmt.Sprintf("not reconnected on revision %s in %s, state: %s, revision: %s",
+				g.expectedRevision, g.reconnectTimeout, state, revision)
+		}
+		return verdictWait, ""
+	}
+	if vtap.State != ctrlcommon.VTAP_STATE_NORMAL {
+		return verdictFailed, "lost connection after the upgrade"
+	}
+	if revision != g.expectedRevision {
+		return verdictFailed, fmt.Sprintf("revision changed to %s after the upgrade", revision)
+	}
+	if exceptions := vtap.Exceptions &^ agent.BaselineExceptions; exceptions != 0 {
+		return verdictFailed, fmt.Sprintf("new exceptions 0x%x after the upgrade", exceptions)
+	}
+	if now.Sub(*agent.ReconnectedAt) < g.observeTime {
+		return verdictWait, ""
+	}
+	return verdictObserved, ""
+}
+
+// dropsStable compares the drop rates of an agent before and after the upgrade, unit: drops per second
+func dropsStable(before, after, growthRatio, minRate float64) bool {
+	if after <= minRate {
+		return true
+	}
+	return after <= before*growthRatio
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rollout
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ctrlcommon "github.com/deepflowio/deepflow/server/controller/common"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+)
+
+func TestPlanWaves(t *testing.T) {
+	agents := []string{"a", "b", "c", "d", "e", "f", "g"}
+	cases := []struct {
+		name          string
+		canary        []string
+		canaryPercent int
+		batchSize     int
+		expected      [][]string
+	}{
+		{"all in one wave", nil, 0, 0, [][]string{agents}},
+		{"batches", nil, 0, 3, [][]string{{"a", "b", "c"}, {"d", "e", "f"}, {"g"}}},
+		{"canary percent rounded up", nil, 10, 4, [][]string{{"a"}, {"b", "c", "d", "e"}, {"f", "g"}}},
+		{"canary percent of all", nil, 100, 2, [][]string{agents}},
+		{"canary agents", []string{"f", "b", "x"}, 50, 0, [][]string{{"b", "f"}, {"a", "c", "d", "e", "g"}}},
+		{"canary agents not found", []string{"x"}, 0, 5, [][]string{{"a", "b", "c", "d", "e"}, {"f", "g"}}},
+	}
+	for _, c := range cases {
+		waves := planWaves(agents, c.canary, c.canaryPercent, c.batchSize)
+		if !reflect.DeepEqual(waves, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, waves)
+		}
+	}
+	if waves := planWaves(nil, []string{"a"}, 10, 1); waves != nil {
+		t.Errorf("expected no wave, got %v", waves)
+	}
+}
+
+func TestGateCheck(t *testing.T) {
+	now := time.Now()
+	upgradedAt := now.Add(-time.Minute)
+	reconnectedAt := now.Add(-30 * time.Second)
+	g := gate{expectedRevision: "10001-abc", reconnectTimeout: 2 * time.Minute, observeTime: 20 * time.Second}
+	cases := []struct {
+		name          string
+		upgradedAt    time.Time
+		reconnectedAt *time.Time
+		vtap          metadbmodel.VTap
+		expected      verdict
+		message       string
+	}{
+		{"upgrading", upgradedAt, nil,
+			metadbmodel.VTap{State: ctrlcommon.VTAP_STATE_NOT_CONNECTED, Revision: "main 10000-abc"}, verdictWait, ""},
+		{"reconnected", upgradedAt, nil,
+			metadbmodel.VTap{State: ctrlcommon.VTAP_STATE_NORMAL, Revision: "main 10001-abc"}, verdictReconnected, ""},
+		{"reconnect timeout", now.Add(-3 * time.Minute), nil,
+			metadbmodel.VTap{State: ctrlcommon.VTAP_STATE_NORMAL, Revision: "main 10000-abc"}, verdictFailed, "not reconnected on revision 10001-abc"},
+		{"observed", upgradedAt, &reconnectedAt,
+			metadbmodel.VTap{State: ctrlcommon.VTAP_STATE_NORMAL, Revision: "main 10001-abc", Exceptions: 1 << 2}, verdictObserved, ""},
+		{"observing", now, &now,
+			metadbmodel.VTap{State: ctrlcommon.VTAP_STATE_NORMAL, Revision: "main 10001-abc"}, verdictWait, ""},
+		{"lost", upgradedAt, &reconnectedAt,
+			metadbmodel.VTap{State: ctrlcommon.VTAP_STATE_NOT_CONNECTED, Revision: "main 10001-abc"}, verdictFailed, "lost connection"},
+		{"revision changed", upgradedAt, &reconnectedAt,
+			metadbmodel.VTap{State: ctrlcommon.VTAP_STATE_NORMAL, Revision: "main 10000-abc"}, verdictFailed, "revision changed to 10000-abc"},
+		{"new exceptions", now, &now,
+			metadbmodel.VTap{State: ctrlcommon.VTAP_STATE_NORMAL, Revision: "main 10001-abc", Exceptions: 1<<2 | 1<<15}, verdictFailed, "new exceptions 0x8000"},
+	}
+	for _, c := range cases {
+		agent := &metadbmodel.AgentUpgradeRolloutAgent{
+			UpgradedAt:         &c.upgradedAt,
+			ReconnectedAt:      c.reconnectedAt,
+			BaselineExceptions: 1 << 2,
+		}
+		v, message := g.check(agent, &c.vtap, now)
+		if v != c.expected || !strings.HasPrefix(message, c.message) {
+			t.Errorf("%s: expected %d %q, got %d %q", c.name, c.expected, c.message, v, message)
+		}
+	}
+}
+
+func TestDropsStable(t *testing.T) {
+	cases := []struct {
+		before, after float64
+		expected      bool
+	}{
+		{0, 5, true},
+		{0, 20, false},
+		{100, 150, true},
+		{100, 201, false},
+	}
+	for _, c := range cases {
+		if stable := dropsStable(c.before, c.after, 2, 10); stable != c.expected {
+			t.Errorf("drops from %.0f to %.0f: expected stable %t, got %t", c.before, c.after, c.expected, stable)
+		}
+	}
+}
+
+func TestDropRateSQL(t *testing.T) {
+	sql := dropRateSQL(2)
+	for _, s := range []string{
+		"FROM 0002_deepflow_tenant.`deepflow_collector`",
+		"virtual_table_name='deepflow_agent_dispatcher', if(indexOf(metrics_float_names, 'kernel_drops')=0",
+		"virtual_table_name IN ('deepflow_agent_dispatcher', 'deepflow_agent_collect_sender')",
+		"tag_values[indexOf(tag_names, 'host')] = ?",
+	} {
+		if !strings.Contains(sql, s) {
+			t.Errorf("expected %q in %s", s, sql)
+		}
+	}
+	if sql := dropRateSQL(1); !strings.Contains(sql, "FROM deepflow_tenant.`deepflow_collector`") {
+		t.Errorf("expected the database of the default org in %s", sql)
+	}
+}
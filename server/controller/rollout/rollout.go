@@ -0,0 +1,478 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rollout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	ctrlcommon "github.com/deepflowio/deepflow/server/controller/common"
+	"github.com/deepflowio/deepflow/server/controller/db/clickhouse"
+	"github.com/deepflowio/deepflow/server/controller/db/metadb"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	"github.com/deepflowio/deepflow/server/controller/rollout/common"
+	"github.com/deepflowio/deepflow/server/controller/rollout/config"
+	"github.com/deepflowio/deepflow/server/libs/logger"
+)
+
+var log = logger.MustGetLogger("rollout")
+
+var errRolloutStopped = errors.New("rollout stopped")
+
+// Runner advances the agent upgrade rollouts on the master controller. A rollout upgrades the agents of an
+// agent group wave by wave, the canary wave first, and goes on to the next wave only if every agent of the
+// current wave passes the health gates: reconnected on the new revision, no new exceptions and stable drop
+// counters during the observe time. If an agent fails, the rollout is paused or rolled back by its failure
+// policy. The rollouts are kept in metadb, a new master controller goes on with them where they are.
+type Runner struct {
+	rCtx    context.Context
+	rCancel context.CancelFunc
+	cfg     config.RolloutConfig
+	ckCfg   clickhouse.ClickHouseConfig
+}
+
+func NewRunner(cfg config.RolloutConfig, ckCfg clickhouse.ClickHouseConfig, ctx context.Context) *Runner {
+	rCtx, rCancel := context.WithCancel(ctx)
+	return &Runner{
+		rCtx:    rCtx,
+		rCancel: rCancel,
+		cfg:     cfg,
+		ckCfg:   ckCfg,
+	}
+}
+
+func (r *Runner) Start(sCtx context.Context) {
+	if !r.cfg.Enabled {
+		return
+	}
+	log.Info("agent upgrade rollout runner start")
+	go func() {
+		ticker := time.NewTicker(time.Duration(r.cfg.CheckInterval) * time.Second)
+		defer ticker.Stop()
+	LOOP:
+		for {
+			select {
+			case <-ticker.C:
+				metadb.GetDBs().DoOnAllDBs(func(db *metadb.DB) error {
+					r.check(sCtx, db)
+					return nil
+				})
+			case <-sCtx.Done():
+				break LOOP
+			case <-r.rCtx.Done():
+				break LOOP
+			}
+		}
+	}()
+}
+
+func (r *Runner) Stop() {
+	if r.rCancel != nil {
+		r.rCancel()
+	}
+	log.Info("agent upgrade rollout runner stopped")
+}
+
+func (r *Runner) check(ctx context.Context, db *metadb.DB) {
+	var rollouts []metadbmodel.AgentUpgradeRollout
+	if err := db.Where(
+		"state IN ?", []int{common.ROLLOUT_STATE_PENDING, common.ROLLOUT_STATE_RUNNING, common.ROLLOUT_STATE_ROLLING_BACK},
+	).Order("id").Find(&rollouts).Error; err != nil {
+		log.Errorf("failed to query %s: %s", "agent_upgrade_rollout", err, db.LogPrefixORGID)
+		return
+	}
+	for i := range rollouts {
+		rollout := &rollouts[i]
+		var err error
+		switch rollout.State {
+		case common.ROLLOUT_STATE_PENDING:
+			err = r.start(db, rollout)
+		case common.ROLLOUT_STATE_RUNNING:
+			err = r.advance(ctx, db, rollout)
+		case common.ROLLOUT_STATE_ROLLING_BACK:
+			err = r.rollback(db, rollout)
+		}
+		if errors.Is(err, errRolloutStopped) {
+			log.Infof("rollout (%d) is stopped by the api", rollout.ID, db.LogPrefixORGID)
+		} else if err != nil {
+			log.Errorf("rollout (%d) failed: %s", rollout.ID, err.Error(), db.LogPrefixORGID)
+			if err := updateRollout(db.DB, rollout, map[string]interface{}{
+				"state": common.ROLLOUT_STATE_FAILED, "error_message": err.Error(), "finished_at": time.Now(),
+			}); err != nil && !errors.Is(err, errRolloutStopped) {
+				log.Errorf("failed to update rollout (%d): %s", rollout.ID, err, db.LogPrefixORGID)
+			}
+		}
+	}
+}
+
+// updateRollout updates the rollout only if it is still in the state it was read in, errRolloutStopped is
+// returned if it is paused, cancelled or deleted by the api in the meantime
+func updateRollout(db *gorm.DB, rollout *metadbmodel.AgentUpgradeRollout, values map[string]interface{}) error {
+	result := db.Model(&metadbmodel.AgentUpgradeRollout{}).Where("id = ? AND state = ?", rollout.ID, rollout.State).Updates(values)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errRolloutStopped
+	}
+	return nil
+}
+
+// start plans the waves of the agents in the agent group, the agents disabled, not connected or already on
+// the expected revision are skipped
+func (r *Runner) start(db *metadb.DB, rollout *metadbmodel.AgentUpgradeRollout) error {
+	var canary []string
+	if rollout.CanaryAgents != "" {
+		if err := json.Unmarshal([]byte(rollout.CanaryAgents), &canary); err != nil {
+			return fmt.Errorf("invalid canary agents %s: %s", rollout.CanaryAgents, err)
+		}
+	}
+	var vtaps []metadbmodel.VTap
+	if err := db.Where("vtap_group_lcuuid = ?", rollout.VTapGroupLcuuid).Order("name").Find(&vtaps).Error; err != nil {
+		return err
+	}
+
+	agents := make([]*metadbmodel.AgentUpgradeRolloutAgent, 0, len(vtaps))
+	nameToAgent := make(map[string]*metadbmodel.AgentUpgradeRolloutAgent)
+	var names []string
+	for _, vtap := range vtaps {
+		agent := &metadbmodel.AgentUpgradeRolloutAgent{
+			RolloutID:        rollout.ID,
+			VTapLcuuid:       vtap.Lcuuid,
+			VTapName:         vtap.Name,
+			State:            common.AGENT_STATE_SKIPPED,
+			PreviousRevision: common.RealRevision(vtap.Revision),
+		}
+		switch {
+		case vtap.Enable == 0:
+			agent.Message = "agent is disabled"
+		case vtap.State != ctrlcommon.VTAP_STATE_NORMAL:
+			agent.Message = "agent is not connected"
+		case agent.PreviousRevision == rollout.ExpectedRevision:
+			agent.Message = "agent is already on the expected revision"
+		default:
+			agent.State = common.AGENT_STATE_WAITING
+			names = append(names, vtap.Name)
+			nameToAgent[vtap.Name] = agent
+		}
+		agents = append(agents, agent)
+	}
+	waves := planWaves(names, canary, rollout.CanaryPercent, rollout.BatchSize)
+	for i, wave := range waves {
+		for _, name := range wave {
+			nameToAgent[name].Wave = i
+		}
+	}
+
+	now := time.Now()
+	values := map[string]interface{}{
+		"state": common.ROLLOUT_STATE_RUNNING, "current_wave": 0, "total_waves": len(waves), "total_agents": len(names),
+		"healthy_agents": 0, "failed_agents": 0, "error_message": "", "started_at": now,
+	}
+	if len(waves) == 0 {
+		values["state"] = common.ROLLOUT_STATE_FINISHED
+		values["finished_at"] = now
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := updateRollout(tx, rollout, values); err != nil {
+			return err
+		}
+		if len(agents) == 0 {
+			return nil
+		}
+		return tx.Create(&agents).Error
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("rollout (%d) start, upgrade %d agents of agent group (%s) to %s in %d waves",
+		rollout.ID, len(names), rollout.VTapGroupLcuuid, rollout.ImageName, len(waves), db.LogPrefixORGID)
+	return nil
+}
+
+func (r *Runner) gate(rollout *metadbmodel.AgentUpgradeRollout) gate {
+	g := gate{
+		expectedRevision: rollout.ExpectedRevision,
+		reconnectTimeout: time.Duration(r.cfg.ReconnectTimeout) * time.Second,
+		observeTime:      time.Duration(r.cfg.ObserveTime) * time.Second,
+	}
+	if rollout.ReconnectTimeout > 0 {
+		g.reconnectTimeout = time.Duration(rollout.ReconnectTimeout) * time.Second
+	}
+	if rollout.ObserveTime > 0 {
+		g.observeTime = time.Duration(rollout.ObserveTime) * time.Second
+	}
+	return g
+}
+
+// advance upgrades the waiting agents of the current wave, checks the health gates of the upgrading ones,
+// then goes on to the next wave if all of them are healthy
+func (r *Runner) advance(ctx context.Context, db *metadb.DB, rollout *metadbmodel.AgentUpgradeRollout) error {
+	var agents []metadbmodel.AgentUpgradeRolloutAgent
+	if err := db.Where("rollout_id = ? AND wave = ?", rollout.ID, rollout.CurrentWave).Find(&agents).Error; err != nil {
+		return err
+	}
+	lcuuidToVTap, err := getVTaps(db, agents)
+	if err != nil {
+		return err
+	}
+
+	g := r.gate(rollout)
+	now := time.Now()
+	for i := range agents {
+		agent := &agents[i]
+		vtap, ok := lcuuidToVTap[agent.VTapLcuuid]
+		switch agent.State {
+		case common.AGENT_STATE_WAITING:
+			if !ok {
+				err = db.Model(agent).Updates(map[string]interface{}{"state": common.AGENT_STATE_SKIPPED, "message": "agent is deleted"}).Error
+			} else {
+				err = r.upgrade(db, rollout, agent, vtap, now)
+			}
+		case common.AGENT_STATE_UPGRADING:
+			if !ok {
+				err = db.Model(agent).Updates(map[string]interface{}{"state": common.AGENT_STATE_FAILED, "message": "agent is deleted during the upgrade"}).Error
+			} else {
+				err = r.checkGates(ctx, db, g, agent, vtap, now)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return r.nextWave(db, rollout)
+}
+
+func getVTaps(db *metadb.DB, agents []metadbmodel.AgentUpgradeRolloutAgent) (map[string]*metadbmodel.VTap, error) {
+	lcuuids := make([]string, 0, len(agents))
+	for _, agent := range agents {
+		lcuuids = append(lcuuids, agent.VTapLcuuid)
+	}
+	lcuuidToVTap := make(map[string]*metadbmodel.VTap, len(agents))
+	if len(lcuuids) == 0 {
+		return lcuuidToVTap, nil
+	}
+	var vtaps []metadbmodel.VTap
+	if err := db.Where("lcuuid IN ?", lcuuids).Find(&vtaps).Error; err != nil {
+		return nil, err
+	}
+	for i := range vtaps {
+		lcuuidToVTap[vtaps[i].Lcuuid] = &vtaps[i]
+	}
+	return lcuuidToVTap, nil
+}
+
+// upgrade sends the upgrade of the agent to the controllers, the revision, image and exceptions before the
+// first attempt are kept for the rollback and the health gates
+func (r *Runner) upgrade(db *metadb.DB, rollout *metadbmodel.AgentUpgradeRollout, agent *metadbmodel.AgentUpgradeRolloutAgent, vtap *metadbmodel.VTap, now time.Time) error {
+	values := map[string]interface{}{"reconnected_at": nil}
+	if agent.UpgradedAt == nil {
+		previousRevision := common.RealRevision(vtap.Revision)
+		values["previous_revision"] = previousRevision
+		values["previous_image"] = previousImage(db, vtap, previousRevision)
+		values["baseline_exceptions"] = vtap.Exceptions
+	}
+	if err := upgradeAgent(db, vtap, rollout.ImageName); err != nil {
+		log.Warningf("rollout (%d) upgrade agent (%s) failed: %s", rollout.ID, vtap.Name, err.Error(), db.LogPrefixORGID)
+		values["state"] = common.AGENT_STATE_FAILED
+		values["message"] = "upgrade failed: " + err.Error()
+	} else {
+		log.Infof("rollout (%d) wave %d upgrade agent (%s) from %s to %s",
+			rollout.ID, rollout.CurrentWave, vtap.Name, common.RealRevision(vtap.Revision), rollout.ExpectedRevision, db.LogPrefixORGID)
+		values["state"] = common.AGENT_STATE_UPGRADING
+		values["upgraded_at"] = now
+		values["message"] = ""
+	}
+	return db.Model(agent).Updates(values).Error
+}
+
+// previousImage finds the image of the revision, the image of the same arch and os as the agent is preferred
+func previousImage(db *metadb.DB, vtap *metadbmodel.VTap, revision string) string {
+	revCount, commitID, ok := strings.Cut(revision, "-")
+	if !ok {
+		return ""
+	}
+	var repos []metadbmodel.VTapRepo
+	if err := db.Select("name", "arch", "os").Where("rev_count = ? AND commit_id = ?", revCount, commitID).Order("id DESC").Find(&repos).Error; err != nil {
+		log.Warningf("failed to query image of revision %s: %s", revision, err, db.LogPrefixORGID)
+		return ""
+	}
+	for _, repo := range repos {
+		if repo.Arch == vtap.Arch && repo.OS == vtap.Os {
+			return repo.Name
+		}
+	}
+	if len(repos) > 0 {
+		return repos[0].Name
+	}
+	return ""
+}
+
+func (r *Runner) checkGates(ctx context.Context, db *metadb.DB, g gate, agent *metadbmodel.AgentUpgradeRolloutAgent, vtap *metadbmodel.VTap, now time.Time) error {
+	v, message := g.check(agent, vtap, now)
+	switch v {
+	case verdictReconnected:
+		log.Infof("rollout (%d) agent (%s) reconnected on revision %s", agent.RolloutID, vtap.Name, g.expectedRevision, db.LogPrefixORGID)
+		return db.Model(agent).Updates(map[string]interface{}{"reconnected_at": now}).Error
+	case verdictObserved:
+		if r.cfg.DropCheck.Enabled && vtap.RawHostname != "" {
+			var err error
+			if message, err = r.checkDrops(ctx, db, g, agent, vtap, now); err != nil {
+				if now.Sub(*agent.ReconnectedAt) < g.observeTime+g.reconnectTimeout {
+					log.Warningf("rollout (%d) check drop counters of agent (%s) failed: %s, retry later",
+						agent.RolloutID, vtap.Name, err.Error(), db.LogPrefixORGID)
+					return nil
+				}
+				message = "check drop counters failed: " + err.Error()
+			}
+			if message != "" {
+				v = verdictFailed
+				break
+			}
+		}
+		log.Infof("rollout (%d) agent (%s) is healthy on revision %s", agent.RolloutID, vtap.Name, g.expectedRevision, db.LogPrefixORGID)
+		return db.Model(agent).Updates(map[string]interface{}{"state": common.AGENT_STATE_HEALTHY, "message": ""}).Error
+	}
+	if v == verdictFailed {
+		log.Warningf("rollout (%d) agent (%s) failed the health gates: %s", agent.RolloutID, vtap.Name, message, db.LogPrefixORGID)
+		return db.Model(agent).Updates(map[string]interface{}{"state": common.AGENT_STATE_FAILED, "message": message}).Error
+	}
+	return nil
+}
+
+// checkDrops compares the drop rate of the agent since it reconnected with the one in the observe time before
+// the upgrade, a message is returned if the drops are not stable
+func (r *Runner) checkDrops(ctx context.Context, db *metadb.DB, g gate, agent *metadbmodel.AgentUpgradeRolloutAgent, vtap *metadbmodel.VTap, now time.Time) (string, error) {
+	before, err := r.dropRate(ctx, db.ORGID, vtap.RawHostname, agent.UpgradedAt.Add(-g.observeTime), *agent.UpgradedAt)
+	if err != nil {
+		return "", err
+	}
+	after, err := r.dropRate(ctx, db.ORGID, vtap.RawHostname, *agent.ReconnectedAt, now)
+	if err != nil {
+		return "", err
+	}
+	if dropsStable(before, after, r.cfg.DropCheck.GrowthRatio, r.cfg.DropCheck.MinRate) {
+		return "", nil
+	}
+	return fmt.Sprintf("drop rate grew from %.2f/s to %.2f/s after the upgrade", before, after), nil
+}
+
+// nextWave counts the agents, pauses or rolls back the rollout if an agent of the current wave failed, or goes
+// on to the next wave if all the agents of the current wave are done
+func (r *Runner) nextWave(db *metadb.DB, rollout *metadbmodel.AgentUpgradeRollout) error {
+	var agents []metadbmodel.AgentUpgradeRolloutAgent
+	if err := db.Select("vtap_name", "wave", "state", "message").Where("rollout_id = ?", rollout.ID).Find(&agents).Error; err != nil {
+		return err
+	}
+	var healthy, failed int
+	var failure string
+	waveDone := true
+	for _, agent := range agents {
+		switch agent.State {
+		case common.AGENT_STATE_HEALTHY:
+			healthy++
+		case common.AGENT_STATE_FAILED:
+			failed++
+		}
+		if agent.Wave != rollout.CurrentWave {
+			continue
+		}
+		switch agent.State {
+		case common.AGENT_STATE_FAILED:
+			if failure == "" {
+				failure = fmt.Sprintf("agent (%s) of wave %d failed: %s", agent.VTapName, rollout.CurrentWave, agent.Message)
+			}
+		case common.AGENT_STATE_WAITING, common.AGENT_STATE_UPGRADING:
+			waveDone = false
+		}
+	}
+
+	values := map[string]interface{}{"healthy_agents": healthy, "failed_agents": failed}
+	switch {
+	case failure != "":
+		values["error_message"] = failure
+		if rollout.FailurePolicy == common.FAILURE_POLICY_ROLLBACK {
+			values["state"] = common.ROLLOUT_STATE_ROLLING_BACK
+		} else {
+			values["state"] = common.ROLLOUT_STATE_PAUSED
+		}
+		log.Warningf("rollout (%d) %s, %s", rollout.ID, common.RolloutStateName[values["state"].(int)], failure, db.LogPrefixORGID)
+	case !waveDone:
+	case rollout.CurrentWave+1 >= rollout.TotalWaves:
+		values["state"] = common.ROLLOUT_STATE_FINISHED
+		values["finished_at"] = time.Now()
+		log.Infof("rollout (%d) finished, %d agents upgraded to %s", rollout.ID, healthy, rollout.ImageName, db.LogPrefixORGID)
+	default:
+		values["current_wave"] = rollout.CurrentWave + 1
+		log.Infof("rollout (%d) wave %d passed the health gates", rollout.ID, rollout.CurrentWave, db.LogPrefixORGID)
+	}
+	return updateRollout(db.DB, rollout, values)
+}
+
+// rollback sends the agents upgraded by the rollout back to their previous images. The upgrade of the agents
+// whose previous image is not found is cancelled, it only works if the agent is not upgraded yet. The agents
+// failed to be rolled back are retried in the next check.
+func (r *Runner) rollback(db *metadb.DB, rollout *metadbmodel.AgentUpgradeRollout) error {
+	var agents []metadbmodel.AgentUpgradeRolloutAgent
+	if err := db.Where("rollout_id = ? AND state IN ? AND upgraded_at IS NOT NULL", rollout.ID,
+		[]int{common.AGENT_STATE_UPGRADING, common.AGENT_STATE_HEALTHY, common.AGENT_STATE_FAILED}).Find(&agents).Error; err != nil {
+		return err
+	}
+	lcuuidToVTap, err := getVTaps(db, agents)
+	if err != nil {
+		return err
+	}
+
+	var retries int
+	for i := range agents {
+		agent := &agents[i]
+		values := map[string]interface{}{"state": common.AGENT_STATE_ROLLED_BACK}
+		vtap, ok := lcuuidToVTap[agent.VTapLcuuid]
+		switch {
+		case !ok:
+			values["message"] = "agent is deleted"
+		case agent.PreviousImage != "":
+			if err := upgradeAgent(db, vtap, agent.PreviousImage); err != nil {
+				log.Warningf("rollout (%d) roll back agent (%s) failed: %s", rollout.ID, vtap.Name, err.Error(), db.LogPrefixORGID)
+				retries++
+				values = map[string]interface{}{"message": "rollback failed: " + err.Error()}
+			} else {
+				values["message"] = fmt.Sprintf("rolled back to %s (%s)", agent.PreviousImage, agent.PreviousRevision)
+			}
+		default:
+			if err := cancelAgentUpgrade(db, vtap); err != nil {
+				values = map[string]interface{}{"message": fmt.Sprintf(
+					"image of revision %s is not found, not rolled back: %s", agent.PreviousRevision, err.Error())}
+			} else {
+				values["message"] = fmt.Sprintf("image of revision %s is not found, upgrade cancelled", agent.PreviousRevision)
+			}
+		}
+		if err := db.Model(agent).Updates(values).Error; err != nil {
+			return err
+		}
+	}
+	if retries > 0 {
+		return nil
+	}
+	log.Infof("rollout (%d) rolled back %d agents", rollout.ID, len(agents), db.LogPrefixORGID)
+	return updateRollout(db.DB, rollout, map[string]interface{}{"state": common.ROLLOUT_STATE_ROLLED_BACK, "finished_at": time.Now()})
+}
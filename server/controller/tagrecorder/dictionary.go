@@ -19,7 +19,6 @@ package tagrecorder
 import (
 	"context"
 	"fmt"
-	"os"
 	"sort"
 	"strings"
 	"sync"
@@ -28,10 +27,6 @@ import (
 	"golang.org/x/exp/slices"
 
 	mapset "github.com/deckarep/golang-set"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/controller/config"
@@ -79,72 +74,14 @@ func (c *Dictionary) Start(sCtx context.Context) {
 
 func (c *Dictionary) Update() {
 	log.Info("tagrecorder update ch dictionary")
-	if common.IsStandaloneRunningMode() {
-		// in standalone mode, only supports one ClickHouse node
-		c.update(&c.cfg.ClickHouseCfg)
-		return
-	}
-
-	kubeconfig := c.cfg.Kubeconfig
-	var config *rest.Config
-	var err error
-	if kubeconfig != "" {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			log.Error(err)
-			return
-		}
-	} else {
-		config, err = rest.InClusterConfig()
-		if err != nil {
-			log.Error(err)
-			return
-		}
-	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Error(err)
-		return
-	}
-	ctx := context.Background()
-	namespace := os.Getenv(common.NAME_SPACE_KEY)
-	endpoints, err := clientset.CoreV1().Endpoints(namespace).List(ctx, metav1.ListOptions{})
+	clickHouseCfgs, err := clickhouse.GetEndpoints(c.cfg.ClickHouseCfg, c.cfg.Kubeconfig)
 	if err != nil {
 		log.Error(err)
 		return
 	}
-	if len(endpoints.Items) == 0 {
-		log.Warningf("no endpoints in %s", namespace)
-	}
-	endpointName := c.cfg.ClickHouseCfg.Host
-	findEndpoint := false
-	for _, endpoint := range endpoints.Items {
-		if endpoint.Name != endpointName {
-			continue
-		}
-		findEndpoint = true
-		subsets := endpoint.Subsets
-		for _, subset := range subsets {
-			for _, address := range subset.Addresses {
-				clickHouseCfg := c.cfg.ClickHouseCfg
-				if strings.Contains(address.IP, ":") {
-					clickHouseCfg.Host = fmt.Sprintf("[%s]", address.IP)
-				} else {
-					clickHouseCfg.Host = address.IP
-				}
-				for _, port := range subset.Ports {
-					if port.Name == c.cfg.ClickHouseCfg.EndpointTcpPortName {
-						clickHouseCfg.Port = uint32(port.Port)
-						c.update(&clickHouseCfg)
-					}
-				}
-			}
-		}
-	}
-	if !findEndpoint {
-		log.Warningf("%s endpoint not found!", endpointName)
+	for i := range clickHouseCfgs {
+		c.update(&clickHouseCfgs[i])
 	}
-	return
 }
 
 func (c *Dictionary) update(clickHouseCfg *clickhouse.ClickHouseConfig) {
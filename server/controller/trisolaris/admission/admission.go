@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package admission implements bootstrap token based admission of agents.
+//
+// A bootstrap token is '<token_id>.<secret>', the token_id is public and used to look up the token,
+// only the sha256 of the secret is stored in DB.
+package admission
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/deepflowio/deepflow/server/controller/common"
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+	"github.com/deepflowio/deepflow/server/libs/eventapi"
+	"github.com/deepflowio/deepflow/server/libs/logger"
+	"github.com/deepflowio/deepflow/server/libs/queue"
+)
+
+var log = logger.MustGetLogger("trisolaris.admission")
+
+const (
+	TOKEN_ID_LENGTH     = 6
+	TOKEN_SECRET_LENGTH = 16
+
+	tokenCharset = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+var (
+	ErrTokenMalformed = errors.New("bootstrap token malformed")
+	ErrTokenNotFound  = errors.New("bootstrap token not found")
+	ErrTokenMismatch  = errors.New("bootstrap token secret mismatch")
+	ErrTokenExpired   = errors.New("bootstrap token expired")
+	ErrTokenExhausted = errors.New("bootstrap token usage limit reached")
+)
+
+var tokenRegexp = regexp.MustCompile(fmt.Sprintf(`^([a-z0-9]{%d})\.([a-z0-9]{%d})$`, TOKEN_ID_LENGTH, TOKEN_SECRET_LENGTH))
+
+type Token struct {
+	ID     string
+	Secret string
+}
+
+// String hides the secret, tokens may be printed in logs
+func (t *Token) String() string {
+	return t.ID + ".****************"
+}
+
+func (t *Token) Encode() string {
+	return t.ID + "." + t.Secret
+}
+
+func GenerateToken() (*Token, error) {
+	id, err := randomString(TOKEN_ID_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomString(TOKEN_SECRET_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	return &Token{ID: id, Secret: secret}, nil
+}
+
+func ParseToken(s string) (*Token, error) {
+	m := tokenRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil, ErrTokenMalformed
+	}
+	return &Token{ID: m[1], Secret: m[2]}, nil
+}
+
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	max := big.NewInt(int64(len(tokenCharset)))
+	b := make([]byte, n)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = tokenCharset[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+// Check verifies the token against its DB record
+func Check(dbToken *metadbmodel.AgentBootstrapToken, t *Token, now time.Time) error {
+	if subtle.ConstantTimeCompare([]byte(dbToken.SecretHash), []byte(HashSecret(t.Secret))) != 1 {
+		return ErrTokenMismatch
+	}
+	if dbToken.ExpireAt != nil && !now.Before(*dbToken.ExpireAt) {
+		return ErrTokenExpired
+	}
+	if dbToken.UsageLimit > 0 && dbToken.UsageCount >= dbToken.UsageLimit {
+		return ErrTokenExhausted
+	}
+	return nil
+}
+
+// Validate looks up the token in DB and checks its secret, expiry and usage
+func Validate(db *gorm.DB, t *Token, now time.Time) (*metadbmodel.AgentBootstrapToken, error) {
+	var dbToken metadbmodel.AgentBootstrapToken
+	if err := db.Where("token_id = ?", t.ID).First(&dbToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+	if err := Check(&dbToken, t, now); err != nil {
+		return nil, err
+	}
+	return &dbToken, nil
+}
+
+// Consume increases the usage count of the token, the condition in the update keeps concurrent
+// registrations from exceeding the usage limit
+func Consume(db *gorm.DB, id int) error {
+	result := db.Model(&metadbmodel.AgentBootstrapToken{}).
+		Where("id = ? AND (usage_limit = 0 OR usage_count < usage_limit)", id).
+		Update("usage_count", gorm.Expr("usage_count + ?", 1))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenExhausted
+	}
+	return nil
+}
+
+// PutEvent writes an admission decision to the resource event stream
+func PutEvent(q *queue.OverwriteQueue, orgID, teamID int, eventType, agentName, ip, description string) {
+	if q == nil {
+		return
+	}
+	now := time.Now()
+	event := eventapi.AcquireResourceEvent()
+	event.Time = now.Unix()
+	event.TimeMilli = now.UnixMilli()
+	event.Type = eventType
+	event.InstanceType = common.VIF_DEVICE_TYPE_IP
+	event.InstanceName = agentName
+	event.IP = ip
+	event.Description = description
+	event.IfNeedTagged = false
+	event.ORGID = uint16(orgID)
+	event.TeamID = uint16(teamID)
+	if err := q.Put(event); err != nil {
+		log.Errorf("put %s event of agent(%s) into queue failed: %s", eventType, agentName, err.Error(), logger.NewORGPrefix(orgID))
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package admission
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	metadbmodel "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
+)
+
+func TestGenerateAndParseToken(t *testing.T) {
+	token, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseToken(token.Encode())
+	if err != nil {
+		t.Fatalf("parse generated token failed: %s", err)
+	}
+	if *parsed != *token {
+		t.Errorf("parsed token %+v, want %+v", parsed, token)
+	}
+	if token.String() == token.Encode() {
+		t.Error("String() should not expose the secret")
+	}
+
+	for _, s := range []string{"", "abcdef", "abcdef.", "abcdef.0123456789abcde", "ABCDEF.0123456789abcdef", "abcdef:0123456789abcdef"} {
+		if _, err := ParseToken(s); !errors.Is(err, ErrTokenMalformed) {
+			t.Errorf("ParseToken(%q) err = %v, want %v", s, err, ErrTokenMalformed)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	token := &Token{ID: "abcdef", Secret: "0123456789abcdef"}
+	record := func(expireAt *time.Time, limit, count int) *metadbmodel.AgentBootstrapToken {
+		return &metadbmodel.AgentBootstrapToken{
+			TokenID:    token.ID,
+			SecretHash: HashSecret(token.Secret),
+			ExpireAt:   expireAt,
+			UsageLimit: limit,
+			UsageCount: count,
+		}
+	}
+
+	cases := []struct {
+		name   string
+		record *metadbmodel.AgentBootstrapToken
+		token  *Token
+		want   error
+	}{
+		{"valid", record(nil, 0, 100), token, nil},
+		{"valid before expiry", record(&future, 2, 1), token, nil},
+		{"secret mismatch", record(nil, 0, 0), &Token{ID: "abcdef", Secret: "fedcba9876543210"}, ErrTokenMismatch},
+		{"expired", record(&past, 0, 0), token, ErrTokenExpired},
+		{"exhausted", record(nil, 2, 2), token, ErrTokenExhausted},
+	}
+	for _, c := range cases {
+		if err := Check(c.record, c.token, now); !errors.Is(err, c.want) {
+			t.Errorf("%s: Check() err = %v, want %v", c.name, err, c.want)
+		}
+	}
+}
@@ -125,6 +125,9 @@ const (
 	CONFIG_KEY_CAPTURE_MODE                = "inputs.cbpf.common.capture_mode"
 	CONFIG_KEY_DOMAIN_FILTER               = "inputs.resources.pull_resource_from_controller.domain_filter"
 	CONFIG_KEY_HYPERVISOR_RESOURCE_ENABLED = "inputs.resources.private_cloud.hypervisor_resource_enabled"
+	CONFIG_KEY_PLUGINS_UPDATE_TIME         = "plugins.update_time"
+	CONFIG_KEY_WASM_PLUGINS                = "plugins.wasm_plugins"
+	CONFIG_KEY_SO_PLUGINS                  = "plugins.so_plugins"
 )
 
 var (
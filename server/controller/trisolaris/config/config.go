@@ -22,10 +22,16 @@ import (
 
 	"github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/libs/logger"
+	"github.com/deepflowio/deepflow/server/libs/queue"
 )
 
 var log = logger.MustGetLogger("trisolaris.config")
 
+const (
+	AGENT_ADMISSION_AUTO  = "auto"
+	AGENT_ADMISSION_TOKEN = "token"
+)
+
 type Chrony struct {
 	Host    string `default:"chrony" yaml:"host"`
 	Port    uint32 `default:"123" yaml:"port"`
@@ -66,6 +72,9 @@ type Config struct {
 	IngesterAPI                    common.IngesterApi // data source
 	AllAgentConnectToNatIP         bool
 	NoIPOverlapping                bool
+
+	AgentAdmission     string                `default:"auto" yaml:"agent-admission"` // auto or token
+	ResourceEventQueue *queue.OverwriteQueue // agent admission events
 }
 
 func (c *Config) Convert() {
@@ -174,3 +183,15 @@ func (c *Config) SetFPermitConfig(fpermit common.FPermit) {
 func (c *Config) GetFPermitConfig() common.FPermit {
 	return c.FPermit
 }
+
+func (c *Config) SetResourceEventQueue(q *queue.OverwriteQueue) {
+	c.ResourceEventQueue = q
+}
+
+func (c *Config) GetResourceEventQueue() *queue.OverwriteQueue {
+	return c.ResourceEventQueue
+}
+
+func (c *Config) IsTokenAdmission() bool {
+	return c.AgentAdmission == AGENT_ADMISSION_TOKEN
+}
@@ -253,6 +253,10 @@ func (obj *_DBMgr[M]) WithType(dType int) Option {
 	return optionFunc(func(o *options) { o.query["type"] = dType })
 }
 
+func (obj *_DBMgr[M]) WithVersion(version string) Option {
+	return optionFunc(func(o *options) { o.query["version"] = version })
+}
+
 func (obj *_DBMgr[M]) WithCtrlIP(ctrlIP string) Option {
 	return optionFunc(func(o *options) { o.query["ctrl_ip"] = ctrlIP })
 }
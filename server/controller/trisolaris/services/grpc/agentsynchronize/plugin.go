@@ -20,10 +20,12 @@ import (
 	"crypto/md5"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 
 	api "github.com/deepflowio/deepflow/message/agent"
+	"github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/controller/db/metadb"
 	"github.com/deepflowio/deepflow/server/controller/db/metadb/model"
 	"github.com/deepflowio/deepflow/server/controller/trisolaris"
@@ -38,6 +40,9 @@ type PluginData struct {
 	totalLen   uint64
 	pktCount   uint32
 	md5Sum     string
+	sha256Sum  string
+	signature  string
+	version    string
 	step       uint64
 	updateTime uint32
 }
@@ -55,16 +60,40 @@ func (p *PluginEvent) GetPluginData(r *api.PluginRequest, orgID int) (*PluginDat
 	if err != nil {
 		return nil, fmt.Errorf("get db failed")
 	}
-	pluginDbMgr := dbmgr.DBMgr[model.Plugin](db.DB)
-	plugin, err := pluginDbMgr.GetByOption(
-		pluginDbMgr.WithName(r.GetPluginName()),
-		pluginDbMgr.WithType(int(r.GetPluginType())),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("get plugin(type=%s, name=%s) from db failed, %s",
-			r.GetPluginType(), r.GetPluginName(), err)
+	var content []byte
+	var version, sha256Sum, signature string
+	var updatedAt time.Time
+	name, pinnedVersion := common.ParsePluginReference(r.GetPluginName())
+	if pinnedVersion == "" {
+		pluginDbMgr := dbmgr.DBMgr[model.Plugin](db.DB)
+		plugin, err := pluginDbMgr.GetByOption(
+			pluginDbMgr.WithName(name),
+			pluginDbMgr.WithType(int(r.GetPluginType())),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("get plugin(type=%s, name=%s) from db failed, %s",
+				r.GetPluginType(), r.GetPluginName(), err)
+		}
+		content, version, sha256Sum, signature, updatedAt = plugin.Image, plugin.Version, plugin.SHA256, plugin.Signature, plugin.UpdatedAt
+	} else {
+		versionDbMgr := dbmgr.DBMgr[model.PluginVersion](db.DB)
+		plugin, err := versionDbMgr.GetByOption(
+			versionDbMgr.WithName(name),
+			versionDbMgr.WithType(int(r.GetPluginType())),
+			versionDbMgr.WithVersion(pinnedVersion),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("get plugin(type=%s, name=%s) from db failed, %s",
+				r.GetPluginType(), r.GetPluginName(), err)
+		}
+		// a pinned version never changes, so the update time is when it was uploaded
+		content, version, sha256Sum, signature, updatedAt = plugin.Image, plugin.Version, plugin.SHA256, plugin.Signature, plugin.CreatedAt
+	}
+	realSHA256Sum := common.PluginSHA256(content)
+	if sha256Sum != "" && sha256Sum != realSHA256Sum {
+		return nil, fmt.Errorf("plugin(type=%s, name=%s) sha256 (%s) mismatches the stored sha256 (%s), refuse to send it",
+			r.GetPluginType(), r.GetPluginName(), realSHA256Sum, sha256Sum)
 	}
-	content := plugin.Image
 	totalLen := uint64(len(content))
 	step := uint64(1024 * 1024)
 	pktCount := uint32(math.Ceil(float64(totalLen) / float64(step)))
@@ -74,8 +103,11 @@ func (p *PluginEvent) GetPluginData(r *api.PluginRequest, orgID int) (*PluginDat
 		totalLen:   totalLen,
 		pktCount:   pktCount,
 		md5Sum:     md5Sum,
+		sha256Sum:  realSHA256Sum,
+		signature:  signature,
+		version:    version,
 		step:       step,
-		updateTime: uint32(plugin.UpdatedAt.Unix()),
+		updateTime: uint32(updatedAt.Unix()),
 	}, err
 }
 func sendPluginFailed(in api.Synchronizer_PluginServer) error {
@@ -117,6 +149,9 @@ func (p *PluginEvent) Plugin(r *api.PluginRequest, in api.Synchronizer_PluginSer
 			PktCount:   proto.Uint32(pluginData.pktCount),
 			TotalLen:   proto.Uint64(pluginData.totalLen),
 			UpdateTime: proto.Uint32(pluginData.updateTime),
+			Version:    proto.String(pluginData.version),
+			Sha256:     proto.String(pluginData.sha256Sum),
+			Signature:  proto.String(pluginData.signature),
 		}
 		err = in.Send(response)
 		if err != nil {
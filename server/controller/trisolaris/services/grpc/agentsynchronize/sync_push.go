@@ -210,7 +210,8 @@ func (e *AgentEvent) Sync(ctx context.Context, in *api.SyncRequest) (*api.SyncRe
 				in.GetHost(),
 				in.GetAgentGroupIdRequest(),
 				int(in.GetAgentUniqueIdentifier()),
-				teamIDInt)
+				teamIDInt,
+				in.GetBootstrapToken())
 		}
 		return e.noAgentResponse(in, orgID), nil
 	}
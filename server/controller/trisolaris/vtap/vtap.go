@@ -1239,8 +1239,8 @@ func (v *VTapInfo) getVTapAutoRegister() bool {
 }
 
 func (v *VTapInfo) Register(tapMode int, ctrlIP string, ctrlMac string,
-	hostIPs []string, host string, vTapGroupID string, agentUniqueIdentifier int, teamID int) {
-	vTapRegister := newVTapRegister(tapMode, ctrlIP, ctrlMac, hostIPs, host, vTapGroupID, agentUniqueIdentifier, v, teamID)
+	hostIPs []string, host string, vTapGroupID string, agentUniqueIdentifier int, teamID int, bootstrapToken string) {
+	vTapRegister := newVTapRegister(tapMode, ctrlIP, ctrlMac, hostIPs, host, vTapGroupID, agentUniqueIdentifier, v, teamID, bootstrapToken)
 	v.registerMU.Lock()
 	v.register[vTapRegister.getKey()] = vTapRegister
 	v.registerMU.Unlock()
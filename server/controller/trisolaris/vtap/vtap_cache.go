@@ -152,6 +152,27 @@ func (f *VTapConfig) modifyUserConfig(c *VTapCache) {
 		domainFilters = []string{"0"}
 	}
 	f.UserConfig.Set(CONFIG_KEY_DOMAIN_FILTER, domainFilters)
+	f.setPluginsUpdateTime(c.vTapInfo.pluginNameToUpdateTime)
+}
+
+// setPluginsUpdateTime makes agents pull plugins again once the promoted version of
+// a plugin they use changes, pinned versions never change
+func (f *VTapConfig) setPluginsUpdateTime(pluginNameToUpdateTime map[string]uint32) {
+	var updateTime uint32
+	for _, key := range []string{CONFIG_KEY_WASM_PLUGINS, CONFIG_KEY_SO_PLUGINS} {
+		for _, reference := range f.UserConfig.Strings(key) {
+			name, version := ParsePluginReference(reference)
+			if version != "" {
+				continue
+			}
+			if pluginNameToUpdateTime[name] > updateTime {
+				updateTime = pluginNameToUpdateTime[name]
+			}
+		}
+	}
+	if updateTime > 0 {
+		f.UserConfig.Set(CONFIG_KEY_PLUGINS_UPDATE_TIME, fmt.Sprintf("%ds", updateTime))
+	}
 }
 
 func (f *VTapConfig) getDomainFilters() []string {
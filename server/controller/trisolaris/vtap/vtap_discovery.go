@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -27,8 +28,10 @@ import (
 	. "github.com/deepflowio/deepflow/server/controller/common"
 	models "github.com/deepflowio/deepflow/server/controller/db/metadb/model"
 	"github.com/deepflowio/deepflow/server/controller/recorder/db/idmng"
+	"github.com/deepflowio/deepflow/server/controller/trisolaris/admission"
 	"github.com/deepflowio/deepflow/server/controller/trisolaris/dbmgr"
 	. "github.com/deepflowio/deepflow/server/controller/trisolaris/utils"
+	"github.com/deepflowio/deepflow/server/libs/eventapi"
 )
 
 type VTapRegister struct {
@@ -42,6 +45,9 @@ type VTapRegister struct {
 	vTapInfo                        *VTapInfo
 	registerBy                      string
 	groupLicenseFunctions           string
+	bootstrapToken                  *admission.Token            // nil if the agent presents no well-formed token
+	bootstrapTokenErr               error                       // reason why the token is not accepted
+	admissionToken                  *models.AgentBootstrapToken // the validated token
 	VTapLKData
 	ORGID
 }
@@ -91,7 +97,7 @@ func FilterSlice(s []string, filter func(x string) bool) []string {
 }
 
 func newVTapRegister(tapMode int, ctrlIP string, ctrlMac string, hostIPs []string,
-	host string, vTapGroupID string, agentUniqueIdentifier int, vTapInfo *VTapInfo, teamID int) *VTapRegister {
+	host string, vTapGroupID string, agentUniqueIdentifier int, vTapInfo *VTapInfo, teamID int, bootstrapToken string) *VTapRegister {
 	hIPs := FilterSlice(hostIPs, func(x string) bool {
 		if x == "127.0.0.1" {
 			return true
@@ -99,6 +105,13 @@ func newVTapRegister(tapMode int, ctrlIP string, ctrlMac string, hostIPs []strin
 		return false
 	})
 	hIPs = append(hIPs, ctrlIP)
+	var (
+		token    *admission.Token
+		tokenErr error
+	)
+	if bootstrapToken != "" {
+		token, tokenErr = admission.ParseToken(bootstrapToken)
+	}
 	return &VTapRegister{
 		tapMode: tapMode,
 		VTapLKData: VTapLKData{
@@ -111,6 +124,8 @@ func newVTapRegister(tapMode int, ctrlIP string, ctrlMac string, hostIPs []strin
 		agentUniqueIdentifier: agentUniqueIdentifier,
 		vTapInfo:              vTapInfo,
 		teamID:                teamID,
+		bootstrapToken:        token,
+		bootstrapTokenErr:     tokenErr,
 		ORGID:                 vTapInfo.ORGID,
 	}
 }
@@ -181,7 +196,7 @@ func (r *VTapRegister) insertToDB(dbVTap *models.VTap, db *gorm.DB) bool {
 		}
 		return false
 	}
-	if r.vTapAutoRegister {
+	if r.admissionToken == nil && !r.vTapInfo.config.IsTokenAdmission() && r.vTapAutoRegister {
 		dbVTap.State = VTAP_STATE_NORMAL
 	}
 	ids, err := idmng.GetIDs(r.GetORGID(), RESOURCE_TYPE_VTAP_EN, 1)
@@ -191,6 +206,7 @@ func (r *VTapRegister) insertToDB(dbVTap *models.VTap, db *gorm.DB) bool {
 	}
 	if len(ids) != 1 {
 		log.Error(r.Logf("request ids=%v err", ids))
+		r.releaseIDs(ids)
 		return false
 	}
 	dbVTap.ID = ids[0]
@@ -200,12 +216,20 @@ func (r *VTapRegister) insertToDB(dbVTap *models.VTap, db *gorm.DB) bool {
 		dbVTap.FollowGroupFeatures = VTAP_ALL_LICENSE_FUNCTIONS
 	}
 	err = db.Transaction(func(tx *gorm.DB) error {
+		if r.admissionToken != nil {
+			err := admission.Consume(tx, r.admissionToken.ID)
+			if err == nil {
+				dbVTap.State = VTAP_STATE_NORMAL
+			} else if errors.Is(err, admission.ErrTokenExhausted) {
+				dbVTap.State = VTAP_STATE_PENDING
+				r.bootstrapTokenErr = err
+			} else {
+				log.Errorf(r.Logf("consume bootstrap token(%s) failed, err: %s", r.bootstrapToken, err))
+				return err
+			}
+		}
 		if err := tx.Create(dbVTap).Error; err != nil {
 			log.Errorf(r.Logf("insert agent(%s) to DB faild, err: %s", r, err))
-			errID := idmng.ReleaseIDs(r.GetORGID(), RESOURCE_TYPE_VTAP_EN, ids)
-			if errID != nil {
-				log.Error(r.Logf("Release ids=%v err: %s", ids, errID))
-			}
 			return err
 		}
 		r.finishLog(dbVTap)
@@ -214,11 +238,67 @@ func (r *VTapRegister) insertToDB(dbVTap *models.VTap, db *gorm.DB) bool {
 
 	if err != nil {
 		log.Error(r.Log(err.Error()))
+		// the agent is not inserted, whichever step of the transaction failed
+		r.releaseIDs(ids)
 		return false
 	}
+	r.putAdmissionEvent(dbVTap)
 	return true
 }
 
+func (r *VTapRegister) releaseIDs(ids []int) {
+	if len(ids) == 0 {
+		return
+	}
+	if err := idmng.ReleaseIDs(r.GetORGID(), RESOURCE_TYPE_VTAP_EN, ids); err != nil {
+		log.Error(r.Logf("Release ids=%v err: %s", ids, err))
+	}
+}
+
+// checkBootstrapToken validates the token presented by the agent, a valid token decides
+// the team and agent group of the agent
+func (r *VTapRegister) checkBootstrapToken(db *gorm.DB) {
+	if r.bootstrapToken == nil {
+		return
+	}
+	token, err := admission.Validate(db, r.bootstrapToken, time.Now())
+	if err != nil {
+		log.Warningf(r.Logf("agent(%s) bootstrap token(%s) rejected: %s", r.getKey(), r.bootstrapToken, err))
+		r.bootstrapTokenErr = err
+		return
+	}
+	r.admissionToken = token
+	r.teamID = token.TeamID
+	if token.AgentGroupID != "" {
+		r.vTapGroupID = token.AgentGroupID
+	}
+}
+
+func (r *VTapRegister) putAdmissionEvent(dbVTap *models.VTap) {
+	var eventType, description string
+	if dbVTap.State == VTAP_STATE_PENDING {
+		eventType = eventapi.RESOURCE_EVENT_TYPE_AGENT_PENDING
+		reason := "waiting for approval"
+		if r.bootstrapTokenErr != nil {
+			reason = r.bootstrapTokenErr.Error()
+		} else if r.bootstrapToken == nil && r.vTapInfo.config.IsTokenAdmission() {
+			reason = "no bootstrap token presented"
+		}
+		description = fmt.Sprintf("agent %s (ctrl_ip: %s, ctrl_mac: %s) is pending: %s",
+			dbVTap.Name, dbVTap.CtrlIP, dbVTap.CtrlMac, reason)
+	} else {
+		eventType = eventapi.RESOURCE_EVENT_TYPE_AGENT_ADMIT
+		by := "auto register"
+		if r.admissionToken != nil {
+			by = fmt.Sprintf("bootstrap token %s", r.admissionToken.TokenID)
+		}
+		description = fmt.Sprintf("agent %s (ctrl_ip: %s, ctrl_mac: %s) is admitted by %s",
+			dbVTap.Name, dbVTap.CtrlIP, dbVTap.CtrlMac, by)
+	}
+	admission.PutEvent(r.vTapInfo.config.GetResourceEventQueue(), r.GetORGID(), dbVTap.TeamID,
+		eventType, dbVTap.Name, dbVTap.CtrlIP, description)
+}
+
 func (l *VTapLKData) getKey() string {
 	return fmt.Sprintf("%s-%s", l.ctrlIP, l.ctrlMac)
 }
@@ -924,6 +1004,7 @@ func (r *VTapRegister) registerVTap(done func()) {
 		log.Error(r.Log(err.Error()))
 		return
 	}
+	r.checkBootstrapToken(v.db)
 	vtapConfig := r.vTapInfo.GetVTapConfigFromShortID(r.vTapGroupID)
 	if vtapConfig != nil {
 		r.tapMode = vtapConfig.GetConfigTapMode()
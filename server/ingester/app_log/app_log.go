@@ -23,6 +23,7 @@ import (
 	"github.com/deepflowio/deepflow/server/ingester/app_log/config"
 	"github.com/deepflowio/deepflow/server/ingester/app_log/dbwriter"
 	"github.com/deepflowio/deepflow/server/ingester/app_log/decoder"
+	"github.com/deepflowio/deepflow/server/ingester/app_log/httpreceiver"
 	dropletqueue "github.com/deepflowio/deepflow/server/ingester/droplet/queue"
 	"github.com/deepflowio/deepflow/server/ingester/ingesterctl"
 	"github.com/deepflowio/deepflow/server/ingester/pkg/ckwriter"
@@ -34,11 +35,13 @@ import (
 )
 
 type ApplicationLogger struct {
-	Config      *config.Config
-	Ckwriter    *ckwriter.CKWriter
-	SysLogger   *Logger
-	AgentLogger *Logger
-	AppLogger   *Logger
+	Config       *config.Config
+	Ckwriter     *ckwriter.CKWriter
+	SysLogger    *Logger
+	AgentLogger  *Logger
+	AppLogger    *Logger
+	HttpLogger   *Logger
+	HttpReceiver *httpreceiver.HttpReceiver
 }
 
 type Logger struct {
@@ -71,13 +74,21 @@ func NewApplicationLogger(
 		return nil, err
 	}
 
-	return &ApplicationLogger{
+	applicationLogger := &ApplicationLogger{
 		Config:      config,
 		Ckwriter:    ckwriter,
 		SysLogger:   sysLogger,
 		AgentLogger: agentLogger,
 		AppLogger:   appLogger,
-	}, nil
+	}
+
+	if config.HttpReceiver.Enabled {
+		applicationLogger.HttpLogger, applicationLogger.HttpReceiver, err = NewHttpLogger(config, manager, platformDataManager, ckwriter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return applicationLogger, nil
 }
 
 func (l *ApplicationLogger) Start() {
@@ -85,9 +96,17 @@ func (l *ApplicationLogger) Start() {
 	l.SysLogger.Start()
 	l.AgentLogger.Start()
 	l.AppLogger.Start()
+	if l.HttpLogger != nil {
+		l.HttpLogger.Start()
+		l.HttpReceiver.Start()
+	}
 }
 
 func (l *ApplicationLogger) Close() error {
+	if l.HttpLogger != nil {
+		l.HttpReceiver.Close()
+		l.HttpLogger.Close()
+	}
 	l.SysLogger.Close()
 	l.AgentLogger.Close()
 	l.AppLogger.Close()
@@ -117,7 +136,7 @@ func NewLogger(
 	decoders := make([]*decoder.Decoder, queueCount)
 	platformDatas := make([]*grpc.PlatformInfoTable, queueCount)
 	for i := 0; i < queueCount; i++ {
-		logWriter, err := dbwriter.NewAppLogWriter(i, msgType, config, ckwriter)
+		logWriter, err := dbwriter.NewAppLogWriter(i, msgType.String(), config, ckwriter)
 		if err != nil {
 			return nil, err
 		}
@@ -161,3 +180,46 @@ func (l *Logger) Close() error {
 	}
 	return nil
 }
+
+// NewHttpLogger creates the decoders of the logs pushed by Loki or Elasticsearch clients,
+// the http receiver parses the requests and puts them into the decode queues.
+func NewHttpLogger(
+	config *config.Config,
+	manager *dropletqueue.Manager,
+	platformDataManager *grpc.PlatformDataManager,
+	ckwriter *ckwriter.CKWriter,
+) (*Logger, *httpreceiver.HttpReceiver, error) {
+	queueCount := config.DecoderQueueCount
+	decodeQueues := manager.NewQueues(
+		"1-receive-to-decode-"+decoder.EXTERNAL_LOG_NAME,
+		config.DecoderQueueSize,
+		queueCount,
+		1,
+		libqueue.OptionFlushIndicator(3*time.Second))
+
+	decoders := make([]*decoder.Decoder, queueCount)
+	platformDatas := make([]*grpc.PlatformInfoTable, queueCount)
+	for i := 0; i < queueCount; i++ {
+		logWriter, err := dbwriter.NewAppLogWriter(i, decoder.EXTERNAL_LOG_NAME, config, ckwriter)
+		if err != nil {
+			return nil, nil, err
+		}
+		platformDatas[i], err = platformDataManager.NewPlatformInfoTable("app-log-" + decoder.EXTERNAL_LOG_NAME + "-" + strconv.Itoa(i))
+		if err != nil {
+			return nil, nil, err
+		}
+		decoders[i] = decoder.NewExternalLogDecoder(
+			i,
+			queue.QueueReader(decodeQueues.FixedMultiQueue[i]),
+			logWriter,
+			platformDatas[i],
+			config,
+		)
+	}
+
+	return &Logger{
+		Config:        config,
+		Decoders:      decoders,
+		PlatformDatas: platformDatas,
+	}, httpreceiver.NewHttpReceiver(&config.HttpReceiver, decodeQueues, queueCount), nil
+}
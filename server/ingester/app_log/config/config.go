@@ -32,14 +32,26 @@ const (
 	DefaultDecoderQueueCount = 2
 	DefaultDecoderQueueSize  = 4096
 	DefaultTTL               = 720 // hour
+
+	DefaultHttpReceiverPort        = 20044
+	DefaultHttpReceiverMaxBodySize = 16 << 20 // bytes
 )
 
+// HttpReceiverConfig configures the http endpoints compatible with the Loki push API
+// and the Elasticsearch bulk API, used by log shippers such as Promtail, Fluent Bit and Filebeat.
+type HttpReceiverConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	ListenPort  int  `yaml:"listen-port"`
+	MaxBodySize int  `yaml:"max-body-size"`
+}
+
 type Config struct {
 	Base              *config.Config
 	CKWriterConfig    config.CKWriterConfig `yaml:"application-log-ck-writer"`
 	DecoderQueueCount int                   `yaml:"application-log-decoder-queue-count"`
 	DecoderQueueSize  int                   `yaml:"application-log-decoder-queue-size"`
 	TTL               int                   `yaml:"application-log-ttl-hour"`
+	HttpReceiver      HttpReceiverConfig    `yaml:"application-log-http-receiver"`
 }
 
 type ApplicationLogConfig struct {
@@ -53,6 +65,12 @@ func (c *Config) Validate() error {
 	if c.DecoderQueueSize == 0 {
 		c.DecoderQueueSize = DefaultDecoderQueueSize
 	}
+	if c.HttpReceiver.ListenPort == 0 {
+		c.HttpReceiver.ListenPort = DefaultHttpReceiverPort
+	}
+	if c.HttpReceiver.MaxBodySize <= 0 {
+		c.HttpReceiver.MaxBodySize = DefaultHttpReceiverMaxBodySize
+	}
 
 	return nil
 }
@@ -65,6 +83,10 @@ func Load(base *config.Config, path string) *Config {
 			DecoderQueueCount: DefaultDecoderQueueCount,
 			DecoderQueueSize:  DefaultDecoderQueueSize,
 			TTL:               DefaultTTL,
+			HttpReceiver: HttpReceiverConfig{
+				ListenPort:  DefaultHttpReceiverPort,
+				MaxBodySize: DefaultHttpReceiverMaxBodySize,
+			},
 		},
 	}
 	if _, err := os.Stat(path); os.IsNotExist(err) {
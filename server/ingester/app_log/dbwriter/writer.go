@@ -26,7 +26,6 @@ import (
 	"github.com/deepflowio/deepflow/server/ingester/flow_tag"
 	"github.com/deepflowio/deepflow/server/ingester/pkg/ckwriter"
 	"github.com/deepflowio/deepflow/server/libs/ckdb"
-	"github.com/deepflowio/deepflow/server/libs/datatype"
 )
 
 var log = logging.MustGetLogger("app_log.dbwriter")
@@ -49,13 +48,13 @@ func (w *AppLogWriter) Write(l *ApplicationLogStore) {
 	w.ckWriter.Put(l)
 }
 
-func NewAppLogWriter(index int, msgType datatype.MessageType, config *config.Config, ckwriter *ckwriter.CKWriter) (*AppLogWriter, error) {
+func NewAppLogWriter(index int, name string, config *config.Config, ckwriter *ckwriter.CKWriter) (*AppLogWriter, error) {
 	w := &AppLogWriter{
 		writerConfig: config.CKWriterConfig,
 	}
 
 	table := LOG_TABLE
-	flowTagWriter, err := flow_tag.NewFlowTagWriter(index, fmt.Sprintf("%s-%s-%d", table, msgType.String(), index), LOG_DB, config.TTL, ckdb.TimeFuncTwelveHour, config.Base, &w.writerConfig)
+	flowTagWriter, err := flow_tag.NewFlowTagWriter(index, fmt.Sprintf("%s-%s-%d", table, name, index), LOG_DB, config.TTL, ckdb.TimeFuncTwelveHour, config.Base, &w.writerConfig)
 	if err != nil {
 		return nil, err
 	}
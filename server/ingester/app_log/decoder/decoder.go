@@ -112,6 +112,7 @@ type Counter struct {
 type Decoder struct {
 	index             int
 	msgType           datatype.MessageType
+	name              string
 	platformData      *grpc.PlatformInfoTable
 	inQueue           queue.QueueReader
 	logWriter         *dbwriter.AppLogWriter
@@ -135,6 +136,7 @@ func NewDecoder(
 	return &Decoder{
 		index:             index,
 		msgType:           msgType,
+		name:              msgType.String(),
 		platformData:      platformData,
 		inQueue:           inQueue,
 		debugEnabled:      log.IsEnabledFor(logging.DEBUG),
@@ -145,6 +147,20 @@ func NewDecoder(
 	}
 }
 
+// NewExternalLogDecoder creates the decoder of the logs received by the http receiver,
+// whose queue items are *ExternalLogBatch instead of *receiver.RecvBuffer
+func NewExternalLogDecoder(
+	index int,
+	inQueue queue.QueueReader,
+	logWriter *dbwriter.AppLogWriter,
+	platformData *grpc.PlatformInfoTable,
+	config *config.Config,
+) *Decoder {
+	d := NewDecoder(index, datatype.MESSAGE_TYPE_APPLICATION_LOG, inQueue, logWriter, platformData, config)
+	d.name = EXTERNAL_LOG_NAME
+	return d
+}
+
 func (d *Decoder) GetCounter() interface{} {
 	var counter *Counter
 	counter, d.counter = d.counter, &Counter{}
@@ -152,10 +168,10 @@ func (d *Decoder) GetCounter() interface{} {
 }
 
 func (d *Decoder) Run() {
-	log.Infof("application log (%s-%d) decoder run", d.msgType.String(), d.index)
+	log.Infof("application log (%s-%d) decoder run", d.name, d.index)
 	ingestercommon.RegisterCountableForIngester("decoder", d, stats.OptionStatTags{
 		"thread":   strconv.Itoa(d.index),
-		"msg_type": d.msgType.String()})
+		"msg_type": d.name})
 	buffer := make([]interface{}, BUFFER_SIZE)
 	decoder := &codec.SimpleDecoder{}
 	for {
@@ -165,6 +181,10 @@ func (d *Decoder) Run() {
 				continue
 			}
 			d.counter.InCount++
+			if batch, ok := buffer[i].(*ExternalLogBatch); ok {
+				d.handleExternalLog(batch)
+				continue
+			}
 			recvBytes, ok := buffer[i].(*receiver.RecvBuffer)
 			if !ok {
 				log.Warning("get application log decode queue data type wrong")
@@ -244,11 +264,11 @@ func (d *Decoder) WriteAgentLog(agentId uint16, bs []byte) error {
 				if ip4 := ip.To4(); ip4 != nil {
 					s.IsIPv4 = true
 					s.IP4 = utils.IpToUint32(ip4)
-					info = d.platformData.QueryIPV4Infos(s.OrgId, s.L3EpcID, s.IP4)
+					info = d.platformData.QueryIPV4Infos(s.OrgId, s.L3EpcID, s.IP4, s.Time)
 				} else {
 					s.IsIPv4 = false
 					s.IP6 = ip
-					info = d.platformData.QueryIPV6Infos(s.OrgId, s.L3EpcID, s.IP6)
+					info = d.platformData.QueryIPV6Infos(s.OrgId, s.L3EpcID, s.IP6, s.Time)
 				}
 			}
 		}
@@ -395,6 +415,14 @@ func (d *Decoder) WriteAppLog(agentId uint16, l *AppLogEntry) error {
 		}
 	}
 
+	d.fillUniversalTags(s, ip)
+
+	d.logWriter.Write(s)
+	return nil
+}
+
+// fillUniversalTags fills the universal tags of the log by the pod (if s.PodID is set) or the ip
+func (d *Decoder) fillUniversalTags(s *dbwriter.ApplicationLogStore, ip net.IP) {
 	if ip != nil {
 		if ip4 := ip.To4(); ip4 != nil {
 			s.IsIPv4 = true
@@ -410,9 +438,9 @@ func (d *Decoder) WriteAppLog(agentId uint16, l *AppLogEntry) error {
 		info = d.platformData.QueryPodIdInfo(s.OrgId, s.PodID)
 	} else {
 		if s.IsIPv4 && ip != nil {
-			info = d.platformData.QueryIPV4Infos(s.OrgId, s.L3EpcID, s.IP4)
+			info = d.platformData.QueryIPV4Infos(s.OrgId, s.L3EpcID, s.IP4, s.Time)
 		} else {
-			info = d.platformData.QueryIPV6Infos(s.OrgId, s.L3EpcID, s.IP6)
+			info = d.platformData.QueryIPV6Infos(s.OrgId, s.L3EpcID, s.IP6, s.Time)
 		}
 	}
 
@@ -448,9 +476,6 @@ func (d *Decoder) WriteAppLog(agentId uint16, l *AppLogEntry) error {
 	s.AutoInstanceID, s.AutoInstanceType = ingestercommon.GetAutoInstance(s.PodID, 0, s.PodNodeID, s.L3DeviceID, uint32(s.SubnetID), uint8(s.L3DeviceType), s.L3EpcID)
 	customServiceID := d.platformData.QueryCustomService(s.OrgId, s.L3EpcID, !s.IsIPv4, s.IP4, s.IP6, 0)
 	s.AutoServiceID, s.AutoServiceType = ingestercommon.GetAutoService(customServiceID, s.ServiceID, s.PodGroupID, 0, s.PodNodeID, s.L3DeviceID, uint32(s.SubnetID), uint8(s.L3DeviceType), podGroupType, s.L3EpcID)
-
-	d.logWriter.Write(s)
-	return nil
 }
 
 type AppLogEntry struct {
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package decoder
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/deepflowio/deepflow/server/ingester/app_log/dbwriter"
+)
+
+const EXTERNAL_LOG_NAME = "external_log"
+
+// ExternalLogEntry is a log pushed by third-party log shippers (Loki push API, Elasticsearch
+// bulk API), the well-known labels/fields have been extracted, and all the others are kept as attributes.
+type ExternalLogEntry struct {
+	Timestamp  int64 // us
+	Body       string
+	Level      string
+	AppService string
+	PodName    string
+	PodIp      string
+	TraceID    string
+	SpanID     string
+
+	AttributeNames  []string
+	AttributeValues []string
+}
+
+// ExternalLogBatch is the unit put into the decode queue by the http receiver
+type ExternalLogBatch struct {
+	OrgID   uint16
+	TeamID  uint16
+	Entries []ExternalLogEntry
+}
+
+func (d *Decoder) handleExternalLog(batch *ExternalLogBatch) {
+	for i := range batch.Entries {
+		if err := d.WriteExternalLog(batch.OrgID, batch.TeamID, &batch.Entries[i]); err != nil {
+			if d.counter.ErrorCount == 0 {
+				log.Warningf("external log decode failed: %s", err)
+			}
+			d.counter.ErrorCount++
+			continue
+		}
+		d.counter.OutCount++
+	}
+}
+
+func (d *Decoder) WriteExternalLog(orgId, teamId uint16, l *ExternalLogEntry) error {
+	if l.Body == "" {
+		return fmt.Errorf("external log body is empty. org id: %d, log: %v", orgId, l)
+	}
+	s := dbwriter.AcquireApplicationLogStore()
+
+	// the strings of the entry are allocated by the http receiver for each request, no need to clone
+	s.Body = l.Body
+	s.Type = dbwriter.LOG_TYPE_USER
+	s.Time = uint32(l.Timestamp / 1000000)
+	s.Timestamp = l.Timestamp
+	s.SetId(s.Time, d.platformData.QueryAnalyzerID())
+	s.OrgId, s.TeamID = orgId, teamId
+	s.TraceID = l.TraceID
+	s.SpanID = l.SpanID
+	s.SeverityNumber = StringToSeverity(l.Level)
+	s.AppService = l.AppService
+	s.AttributeNames = append(s.AttributeNames, l.AttributeNames...)
+	s.AttributeValues = append(s.AttributeValues, l.AttributeValues...)
+
+	var ip net.IP
+	if l.PodIp != "" {
+		ip = net.ParseIP(l.PodIp)
+	}
+	// there is no agent to determine the pod cluster, the pod can only be matched by name and IP
+	if podInfo := d.platformData.QueryPodInfoByName(s.OrgId, l.PodName, l.PodIp); podInfo != nil {
+		s.PodClusterID = uint16(podInfo.PodClusterId)
+		s.PodID = podInfo.PodId
+		s.L3EpcID = podInfo.EpcId
+		if ip == nil {
+			ip = net.ParseIP(podInfo.Ip)
+			// maybe Pod is hostnetwork mode or can't get pod IP, then get pod node IP instead
+			if ip == nil {
+				ip = net.ParseIP(podInfo.PodNodeIp)
+			}
+		}
+	}
+
+	d.fillUniversalTags(s, ip)
+
+	d.logWriter.Write(s)
+	return nil
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpreceiver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/ingester/app_log/decoder"
+)
+
+// Elasticsearch bulk API, see https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
+//
+// The body is NDJSON, each document is preceded by an action line:
+//   {"index": {"_index": "logs"}}
+//   {"@timestamp": "2024-05-01T10:00:00Z", "message": "...", "kubernetes": {"pod": {"name": "..."}}}
+// Only 'index' and 'create' actions are supported, 'update' and 'delete' are answered with an error item.
+
+const (
+	ES_VERSION        = "8.11.0"
+	ES_INDEX_ATTR_KEY = "_index"
+)
+
+type esBulkItemResult struct {
+	Index  string       `json:"_index"`
+	ID     string       `json:"_id"`
+	Status int          `json:"status"`
+	Result string       `json:"result,omitempty"`
+	Error  *esBulkError `json:"error,omitempty"`
+}
+
+type esBulkError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type esBulkResponse struct {
+	Took   int64                          `json:"took"`
+	Errors bool                           `json:"errors"`
+	Items  []map[string]*esBulkItemResult `json:"items"`
+}
+
+type esBulkAction struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+}
+
+// parseEsBulk returns the parsed entries, and the response items of each action in order
+func parseEsBulk(body []byte, defaultIndex string) ([]decoder.ExternalLogEntry, *esBulkResponse, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+
+	resp := &esBulkResponse{}
+	var entries []decoder.ExternalLogEntry
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		actions := map[string]esBulkAction{}
+		if err := json.Unmarshal(line, &actions); err != nil || len(actions) != 1 {
+			return nil, nil, fmt.Errorf("invalid bulk action line %s", line)
+		}
+		var opType string
+		var action esBulkAction
+		for k, v := range actions {
+			opType, action = k, v
+		}
+		if action.Index == "" {
+			action.Index = defaultIndex
+		}
+		item := &esBulkItemResult{Index: action.Index, ID: action.ID}
+		resp.Items = append(resp.Items, map[string]*esBulkItemResult{opType: item})
+
+		switch opType {
+		case "index", "create":
+		case "delete":
+			// 'delete' has no document line
+			item.Status, item.Error = 400, &esBulkError{Type: "illegal_argument_exception", Reason: "delete is not supported"}
+			resp.Errors = true
+			continue
+		default:
+			scanner.Scan()
+			item.Status, item.Error = 400, &esBulkError{Type: "illegal_argument_exception", Reason: opType + " is not supported"}
+			resp.Errors = true
+			continue
+		}
+
+		if !scanner.Scan() {
+			return nil, nil, fmt.Errorf("missing document of action %s", line)
+		}
+		entry, err := parseEsDocument(scanner.Bytes(), action.Index)
+		if err != nil {
+			item.Status, item.Error = 400, &esBulkError{Type: "mapper_parsing_exception", Reason: err.Error()}
+			resp.Errors = true
+			continue
+		}
+		item.Status, item.Result = 201, "created"
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return entries, resp, nil
+}
+
+func parseEsDocument(line []byte, index string) (decoder.ExternalLogEntry, error) {
+	entry := decoder.ExternalLogEntry{}
+	doc := map[string]interface{}{}
+	d := json.NewDecoder(bytes.NewReader(line))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return entry, err
+	}
+	flattenDocument("", doc, &entry)
+
+	if body, i := lookup(entry.AttributeNames, entry.AttributeValues, bodyKeys); i >= 0 {
+		entry.Body = body
+		removeAttribute(&entry, i)
+	} else {
+		entry.Body = string(line)
+	}
+	entry.Timestamp = time.Now().UnixMicro()
+	if timestamp, i := lookup(entry.AttributeNames, entry.AttributeValues, timestampKeys); i >= 0 {
+		if us, err := parseEsTimestamp(timestamp); err == nil {
+			entry.Timestamp = us
+			removeAttribute(&entry, i)
+		}
+	}
+	if index != "" {
+		entry.AttributeNames = append(entry.AttributeNames, ES_INDEX_ATTR_KEY)
+		entry.AttributeValues = append(entry.AttributeValues, index)
+	}
+	fillWellKnownFields(&entry)
+	return entry, nil
+}
+
+// flattenDocument flattens nested objects into dotted keys, such as 'kubernetes.pod.name',
+// arrays are kept as JSON strings
+func flattenDocument(prefix string, doc map[string]interface{}, entry *decoder.ExternalLogEntry) {
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		var value string
+		switch v := doc[k].(type) {
+		case map[string]interface{}:
+			flattenDocument(key, v, entry)
+			continue
+		case nil:
+			continue
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = strconv.FormatBool(v)
+		default:
+			b, _ := json.Marshal(v)
+			value = string(b)
+		}
+		entry.AttributeNames = append(entry.AttributeNames, key)
+		entry.AttributeValues = append(entry.AttributeValues, value)
+	}
+}
+
+// parseEsTimestamp supports RFC3339 strings and epoch milliseconds, returns microseconds
+func parseEsTimestamp(s string) (int64, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UnixMicro(), nil
+	}
+	if ms, err := strconv.ParseFloat(s, 64); err == nil {
+		return int64(ms * 1000), nil
+	}
+	return 0, fmt.Errorf("invalid timestamp %s", s)
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpreceiver
+
+import (
+	"github.com/deepflowio/deepflow/server/ingester/app_log/decoder"
+)
+
+// Well-known Loki stream labels and Elasticsearch document fields which are mapped to
+// the columns of application_log, the earlier key in the list takes precedence.
+// The labels/fields are still kept in attributes, the same as the 'kubernetes' block of
+// the agent application log.
+var (
+	appServiceKeys = []string{"service_name", "service.name", "app_service", "app", "service", "job"}
+	podNameKeys    = []string{"pod_name", "pod", "k8s_pod_name", "k8s.pod.name", "kubernetes.pod.name", "kubernetes.pod_name"}
+	podIpKeys      = []string{"pod_ip", "k8s_pod_ip", "k8s.pod.ip", "kubernetes.pod.ip", "kubernetes.pod_ip"}
+	levelKeys      = []string{"level", "severity", "severity_text", "detected_level", "log.level", "lvl"}
+	traceIdKeys    = []string{"trace_id", "traceid", "traceID", "trace.id"}
+	spanIdKeys     = []string{"span_id", "spanid", "spanID", "span.id"}
+
+	// only for Elasticsearch documents, these fields are not kept in attributes
+	bodyKeys      = []string{"message", "log", "msg", "body"}
+	timestampKeys = []string{"@timestamp", "timestamp", "time"}
+)
+
+func lookup(names, values []string, keys []string) (string, int) {
+	for _, key := range keys {
+		for i, name := range names {
+			if name == key {
+				return values[i], i
+			}
+		}
+	}
+	return "", -1
+}
+
+func fillWellKnownFields(e *decoder.ExternalLogEntry) {
+	names, values := e.AttributeNames, e.AttributeValues
+	e.AppService, _ = lookup(names, values, appServiceKeys)
+	e.PodName, _ = lookup(names, values, podNameKeys)
+	e.PodIp, _ = lookup(names, values, podIpKeys)
+	e.Level, _ = lookup(names, values, levelKeys)
+	e.TraceID, _ = lookup(names, values, traceIdKeys)
+	e.SpanID, _ = lookup(names, values, spanIdKeys)
+}
+
+func removeAttribute(e *decoder.ExternalLogEntry, index int) {
+	e.AttributeNames = append(e.AttributeNames[:index], e.AttributeNames[index+1:]...)
+	e.AttributeValues = append(e.AttributeValues[:index], e.AttributeValues[index+1:]...)
+}
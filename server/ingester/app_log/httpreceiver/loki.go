@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpreceiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/deepflowio/deepflow/server/ingester/app_log/decoder"
+	ingestercommon "github.com/deepflowio/deepflow/server/ingester/common"
+)
+
+// Loki push API, see https://grafana.com/docs/loki/latest/reference/loki-http-api/#ingest-logs
+//
+// JSON body:
+//   {"streams": [{"stream": {"label": "value"}, "values": [["<unix epoch in ns>", "<log line>", {"key": "value"}]]}]}
+//
+// Protobuf body (snappy compressed), the messages used are:
+//   PushRequest    { repeated StreamAdapter streams = 1; }
+//   StreamAdapter  { string labels = 1; repeated EntryAdapter entries = 2; uint64 hash = 3; }
+//   EntryAdapter   { google.protobuf.Timestamp timestamp = 1; string line = 2; repeated LabelPairAdapter structuredMetadata = 3; }
+//   LabelPairAdapter { string name = 1; string value = 2; }
+
+type lokiJsonPushRequest struct {
+	Streams []struct {
+		Stream map[string]string   `json:"stream"`
+		Values [][]json.RawMessage `json:"values"`
+	} `json:"streams"`
+}
+
+func parseLokiJson(body []byte) ([]decoder.ExternalLogEntry, error) {
+	req := &lokiJsonPushRequest{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, err
+	}
+
+	entries := make([]decoder.ExternalLogEntry, 0, len(req.Streams))
+	for _, stream := range req.Streams {
+		names := make([]string, 0, len(stream.Stream))
+		for name := range stream.Stream {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		values := make([]string, len(names))
+		for i, name := range names {
+			values[i] = stream.Stream[name]
+		}
+
+		for _, value := range stream.Values {
+			if len(value) < 2 {
+				return nil, fmt.Errorf("invalid stream value %s, expect [timestamp, line]", value)
+			}
+			var ts, line string
+			if err := json.Unmarshal(value[0], &ts); err != nil {
+				return nil, fmt.Errorf("invalid timestamp %s: %s", value[0], err)
+			}
+			nanos, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %s: %s", ts, err)
+			}
+			if err := json.Unmarshal(value[1], &line); err != nil {
+				return nil, fmt.Errorf("invalid log line %s: %s", value[1], err)
+			}
+			entry := newLokiEntry(names, values, nanos, line)
+			if len(value) > 2 {
+				metadata := map[string]string{}
+				if err := json.Unmarshal(value[2], &metadata); err != nil {
+					return nil, fmt.Errorf("invalid structured metadata %s: %s", value[2], err)
+				}
+				for k, v := range metadata {
+					entry.AttributeNames = append(entry.AttributeNames, k)
+					entry.AttributeValues = append(entry.AttributeValues, v)
+				}
+			}
+			fillWellKnownFields(&entry)
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func newLokiEntry(labelNames, labelValues []string, nanos int64, line string) decoder.ExternalLogEntry {
+	entry := decoder.ExternalLogEntry{
+		Timestamp:       nanos / 1000,
+		Body:            line,
+		AttributeNames:  make([]string, len(labelNames), len(labelNames)+2),
+		AttributeValues: make([]string, len(labelValues), len(labelValues)+2),
+	}
+	copy(entry.AttributeNames, labelNames)
+	copy(entry.AttributeValues, labelValues)
+	return entry
+}
+
+// parseLokiProtobuf parses the snappy decoded PushRequest
+func parseLokiProtobuf(body []byte) ([]decoder.ExternalLogEntry, error) {
+	var entries []decoder.ExternalLogEntry
+	err := ingestercommon.WalkProtobufFields(body, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		var err error
+		entries, err = parseLokiStream(value, entries)
+		return err
+	})
+	return entries, err
+}
+
+func parseLokiStream(stream []byte, entries []decoder.ExternalLogEntry) ([]decoder.ExternalLogEntry, error) {
+	var names, values []string
+	var rawEntries [][]byte
+	err := ingestercommon.WalkProtobufFields(stream, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		var err error
+		switch num {
+		case 1:
+			names, values, err = parseLokiLabels(string(value))
+		case 2:
+			rawEntries = append(rawEntries, value)
+		}
+		return err
+	})
+	if err != nil {
+		return entries, err
+	}
+
+	for _, rawEntry := range rawEntries {
+		var seconds, nanos int64
+		var line string
+		var metadataNames, metadataValues []string
+		err := ingestercommon.WalkProtobufFields(rawEntry, func(num protowire.Number, typ protowire.Type, value []byte) error {
+			if typ != protowire.BytesType {
+				return nil
+			}
+			switch num {
+			case 1:
+				return ingestercommon.WalkProtobufFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+					if typ != protowire.VarintType {
+						return nil
+					}
+					v, _ := protowire.ConsumeVarint(value)
+					switch num {
+					case 1:
+						seconds = int64(v)
+					case 2:
+						nanos = int64(int32(v))
+					}
+					return nil
+				})
+			case 2:
+				line = string(value)
+			case 3:
+				var name, labelValue string
+				err := ingestercommon.WalkProtobufFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+					switch num {
+					case 1:
+						name = string(value)
+					case 2:
+						labelValue = string(value)
+					}
+					return nil
+				})
+				metadataNames = append(metadataNames, name)
+				metadataValues = append(metadataValues, labelValue)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return entries, err
+		}
+		entry := newLokiEntry(names, values, seconds*1000000000+nanos, line)
+		entry.AttributeNames = append(entry.AttributeNames, metadataNames...)
+		entry.AttributeValues = append(entry.AttributeValues, metadataValues...)
+		fillWellKnownFields(&entry)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseLokiLabels parses the labels in Prometheus format, such as: {app="foo", namespace="bar"}
+func parseLokiLabels(s string) ([]string, []string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return nil, nil, fmt.Errorf("invalid labels %s", s)
+	}
+	s = s[1 : len(s)-1]
+
+	var names, values []string
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if s == "" {
+			return names, values, nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, nil, fmt.Errorf("invalid label %s", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " ")
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid value of label %s: %s", name, err)
+		}
+		value, _ := strconv.Unquote(quoted)
+		s = s[len(quoted):]
+		names = append(names, name)
+		values = append(values, value)
+	}
+}
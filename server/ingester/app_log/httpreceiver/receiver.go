@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpreceiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	logging "github.com/op/go-logging"
+
+	"github.com/deepflowio/deepflow/server/ingester/app_log/config"
+	"github.com/deepflowio/deepflow/server/ingester/app_log/decoder"
+	ingestercommon "github.com/deepflowio/deepflow/server/ingester/common"
+	"github.com/deepflowio/deepflow/server/libs/queue"
+	"github.com/deepflowio/deepflow/server/libs/stats"
+	"github.com/deepflowio/deepflow/server/libs/utils"
+)
+
+var log = logging.MustGetLogger("app_log.httpreceiver")
+
+const (
+	LOKI_PUSH_PATH = "/loki/api/v1/push"
+)
+
+type Counter struct {
+	LokiRequestCount int64 `statsd:"loki-request-count"`
+	EsRequestCount   int64 `statsd:"es-request-count"`
+	EntryCount       int64 `statsd:"entry-count"`
+	ErrorCount       int64 `statsd:"err-count"`
+	DropCount        int64 `statsd:"drop-count"`
+}
+
+type HttpReceiver struct {
+	config       *config.HttpReceiverConfig
+	decodeQueues queue.MultiQueueWriter
+	queueCount   int
+	putCount     uint64
+	server       *ingestercommon.HttpServer
+
+	counter *Counter
+	utils.Closable
+}
+
+func NewHttpReceiver(config *config.HttpReceiverConfig, decodeQueues queue.MultiQueueWriter, queueCount int) *HttpReceiver {
+	r := &HttpReceiver{
+		config:       config,
+		decodeQueues: decodeQueues,
+		queueCount:   queueCount,
+		server:       ingestercommon.NewHttpServer("application log", config.ListenPort, config.MaxBodySize),
+		counter:      &Counter{},
+	}
+	return r
+}
+
+func (r *HttpReceiver) GetCounter() interface{} {
+	counter := &Counter{
+		LokiRequestCount: atomic.SwapInt64(&r.counter.LokiRequestCount, 0),
+		EsRequestCount:   atomic.SwapInt64(&r.counter.EsRequestCount, 0),
+		EntryCount:       atomic.SwapInt64(&r.counter.EntryCount, 0),
+		ErrorCount:       r.server.SwapErrorCount(),
+		DropCount:        atomic.SwapInt64(&r.counter.DropCount, 0),
+	}
+	return counter
+}
+
+func (r *HttpReceiver) RegisterHandlers() {
+	router := r.server.Router()
+	router.HandleFunc(LOKI_PUSH_PATH, r.lokiPush).Methods("POST")
+
+	// Filebeat and other Elastic clients check the cluster info and license before sending
+	router.HandleFunc("/", r.esInfo).Methods("GET", "HEAD")
+	router.HandleFunc("/_license", r.esLicense).Methods("GET")
+	router.HandleFunc("/_bulk", r.esBulk).Methods("POST", "PUT")
+	router.HandleFunc("/{index}/_bulk", r.esBulk).Methods("POST", "PUT")
+}
+
+func (r *HttpReceiver) Start() {
+	r.RegisterHandlers()
+	ingestercommon.RegisterCountableForIngester("app_log_http_receiver", r, stats.OptionStatTags{"port": strconv.Itoa(r.config.ListenPort)})
+	r.server.Start()
+}
+
+func (r *HttpReceiver) Close() error {
+	r.Closable.Close()
+	return r.server.Close()
+}
+
+func (r *HttpReceiver) put(orgId, teamId uint16, entries []decoder.ExternalLogEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	atomic.AddInt64(&r.counter.EntryCount, int64(len(entries)))
+	key := queue.HashKey(atomic.AddUint64(&r.putCount, 1) % uint64(r.queueCount))
+	batch := &decoder.ExternalLogBatch{OrgID: orgId, TeamID: teamId, Entries: entries}
+	if err := r.decodeQueues.Put(key, batch); err != nil {
+		atomic.AddInt64(&r.counter.DropCount, int64(len(entries)))
+		log.Warningf("put external logs to decode queue failed: %s", err)
+	}
+}
+
+func (r *HttpReceiver) lokiPush(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&r.counter.LokiRequestCount, 1)
+	orgId, teamId, err := ingestercommon.ParseOrgTeamID(req)
+	if err != nil {
+		r.server.RespError(w, http.StatusBadRequest, err)
+		return
+	}
+	body, err := r.server.ReadBody(req)
+	if err != nil {
+		r.server.RespBodyError(w, err)
+		return
+	}
+
+	var entries []decoder.ExternalLogEntry
+	contentType := req.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/json") {
+		entries, err = parseLokiJson(body)
+	} else {
+		// Promtail and the Loki clients send snappy compressed protobuf by default
+		if body, err = r.server.SnappyDecode(body); err == nil {
+			entries, err = parseLokiProtobuf(body)
+		}
+	}
+	if err != nil {
+		r.server.RespBodyError(w, err)
+		return
+	}
+	r.put(orgId, teamId, entries)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeEsJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	// required by the Elastic clients (8.x) to accept the response
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	resp, _ := json.Marshal(v)
+	w.Write(resp)
+}
+
+func (r *HttpReceiver) esInfo(w http.ResponseWriter, req *http.Request) {
+	writeEsJson(w, map[string]interface{}{
+		"name":         "deepflow-ingester",
+		"cluster_name": "deepflow",
+		"version": map[string]string{
+			"number":       ES_VERSION,
+			"build_flavor": "default",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+func (r *HttpReceiver) esLicense(w http.ResponseWriter, req *http.Request) {
+	writeEsJson(w, map[string]interface{}{
+		"license": map[string]string{
+			"status": "active",
+			"type":   "basic",
+		},
+	})
+}
+
+func (r *HttpReceiver) esBulk(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&r.counter.EsRequestCount, 1)
+	start := time.Now()
+	orgId, teamId, err := ingestercommon.ParseOrgTeamID(req)
+	if err != nil {
+		r.server.RespError(w, http.StatusBadRequest, err)
+		return
+	}
+	body, err := r.server.ReadBody(req)
+	if err != nil {
+		r.server.RespBodyError(w, err)
+		return
+	}
+	entries, resp, err := parseEsBulk(body, mux.Vars(req)["index"])
+	if err != nil {
+		r.server.RespError(w, http.StatusBadRequest, err)
+		return
+	}
+	r.put(orgId, teamId, entries)
+	resp.Took = time.Since(start).Milliseconds()
+	writeEsJson(w, resp)
+}
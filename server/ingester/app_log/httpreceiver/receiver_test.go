@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpreceiver
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/deepflowio/deepflow/server/ingester/app_log/decoder"
+)
+
+func TestParseLokiLabels(t *testing.T) {
+	testCases := []struct {
+		labels string
+		names  []string
+		values []string
+		err    bool
+	}{
+		{`{}`, nil, nil, false},
+		{`{app="foo"}`, []string{"app"}, []string{"foo"}, false},
+		{`{app="foo", pod="bar-1",namespace="a\"b"}`, []string{"app", "pod", "namespace"}, []string{"foo", "bar-1", `a"b`}, false},
+		{`app="foo"`, nil, nil, true},
+		{`{app=foo}`, nil, nil, true},
+	}
+	for _, tc := range testCases {
+		names, values, err := parseLokiLabels(tc.labels)
+		if (err != nil) != tc.err {
+			t.Errorf("labels: %s, expected error: %v, got: %v", tc.labels, tc.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(names, tc.names) || !reflect.DeepEqual(values, tc.values) {
+			t.Errorf("labels: %s, expected: %v %v, got: %v %v", tc.labels, tc.names, tc.values, names, values)
+		}
+	}
+}
+
+func TestParseLokiJson(t *testing.T) {
+	body := `{"streams": [{"stream": {"app": "web", "pod": "web-0", "level": "warn"},
+		"values": [["1714557600000000000", "hello"], ["1714557601000000000", "world", {"trace_id": "abc"}]]}]}`
+	entries, err := parseLokiJson([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	e := entries[1]
+	if e.Timestamp != 1714557601000000 || e.Body != "world" || e.AppService != "web" ||
+		e.PodName != "web-0" || e.Level != "warn" || e.TraceID != "abc" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if len(entries[0].AttributeNames) != 3 || entries[0].TraceID != "" {
+		t.Errorf("structured metadata should not be shared between entries: %+v", entries[0])
+	}
+}
+
+func TestParseLokiProtobuf(t *testing.T) {
+	var timestamp, entry, stream, req []byte
+	timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, 1714557600)
+	timestamp = protowire.AppendTag(timestamp, 2, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, 5000)
+	entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, timestamp)
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendString(entry, "hello")
+	stream = protowire.AppendTag(stream, 1, protowire.BytesType)
+	stream = protowire.AppendString(stream, `{service_name="api", pod_ip="10.1.2.3"}`)
+	stream = protowire.AppendTag(stream, 2, protowire.BytesType)
+	stream = protowire.AppendBytes(stream, entry)
+	stream = protowire.AppendTag(stream, 3, protowire.VarintType)
+	stream = protowire.AppendVarint(stream, 12345)
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, stream)
+
+	entries, err := parseLokiProtobuf(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Timestamp != 1714557600000005 || e.Body != "hello" || e.AppService != "api" || e.PodIp != "10.1.2.3" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
+
+func TestParseEsBulk(t *testing.T) {
+	body := `{"index":{"_index":"logs"}}
+{"@timestamp":"2024-05-01T10:00:00Z","message":"hello","log":{"level":"error"},"kubernetes":{"pod":{"name":"web-0","ip":"10.1.2.3"}},"tags":["a","b"]}
+{"delete":{"_index":"logs","_id":"1"}}
+{"create":{}}
+{"msg":"no timestamp","service":{"name":"api"},"count":3}
+{"index":{}}
+not json
+`
+	entries, resp, err := parseEsBulk([]byte(body), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || len(resp.Items) != 4 || !resp.Errors {
+		t.Fatalf("unexpected result: %d entries, %d items, errors %v", len(entries), len(resp.Items), resp.Errors)
+	}
+	if resp.Items[0]["index"].Status != 201 || resp.Items[1]["delete"].Status != 400 ||
+		resp.Items[2]["create"].Index != "default" || resp.Items[3]["index"].Status != 400 {
+		t.Errorf("unexpected items %+v", resp.Items)
+	}
+
+	e := entries[0]
+	if e.Timestamp != 1714557600000000 || e.Body != "hello" || e.Level != "error" || e.PodName != "web-0" || e.PodIp != "10.1.2.3" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	for i, name := range e.AttributeNames {
+		if name == "message" || name == "@timestamp" {
+			t.Errorf("%s should not be kept in attributes", name)
+		}
+		if name == "tags" && e.AttributeValues[i] != `["a","b"]` {
+			t.Errorf("unexpected tags %s", e.AttributeValues[i])
+		}
+	}
+	if entries[1].Body != "no timestamp" || entries[1].AppService != "api" {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+}
+
+func TestRemoveAttribute(t *testing.T) {
+	e := &decoder.ExternalLogEntry{
+		AttributeNames:  []string{"a", "message", "b", "c"},
+		AttributeValues: []string{"1", "hello", "2", "3"},
+	}
+	removeAttribute(e, 1)
+	if !reflect.DeepEqual(e.AttributeNames, []string{"a", "b", "c"}) || !reflect.DeepEqual(e.AttributeValues, []string{"1", "2", "3"}) {
+		t.Errorf("unexpected attributes %v %v", e.AttributeNames, e.AttributeValues)
+	}
+}
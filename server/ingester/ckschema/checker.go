@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ckschema
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/deepflowio/deepflow/server/ingester/common"
+	"github.com/deepflowio/deepflow/server/ingester/config"
+	"github.com/deepflowio/deepflow/server/ingester/ingesterctl"
+	"github.com/deepflowio/deepflow/server/ingester/pkg/ckwriter"
+	"github.com/deepflowio/deepflow/server/libs/ckdb"
+	"github.com/deepflowio/deepflow/server/libs/debug"
+)
+
+var log = logging.MustGetLogger("ckschema")
+
+const DATA_SOURCE_REQUEST_TIMEOUT = 5 * time.Second
+
+// Checker diffs the live tables of every clickhouse node against the tables of the registered ckwriters
+type Checker struct {
+	cfg *config.Config
+}
+
+func NewChecker(cfg *config.Config) *Checker {
+	c := &Checker{cfg: cfg}
+	debug.ServerRegisterSimple(ingesterctl.CMD_CK_SCHEMA, c)
+	return c
+}
+
+// the nodes written by this ingester, and the other clickhouse nodes found by the watcher
+func (c *Checker) nodes() []string {
+	nodes := []string{}
+	exists := make(map[string]struct{})
+	add := func(addr string) {
+		if _, ok := exists[addr]; !ok {
+			exists[addr] = struct{}{}
+			nodes = append(nodes, addr)
+		}
+	}
+	if c.cfg.CKDB.ActualAddrs != nil {
+		for _, addr := range *c.cfg.CKDB.ActualAddrs {
+			add(addr)
+		}
+	}
+	if c.cfg.CKDB.Watcher != nil {
+		endpoints, err := c.cfg.CKDB.Watcher.GetClickhouseEndpointsWithoutMyself()
+		if err != nil {
+			log.Warningf("get clickhouse endpoints without myself failed: %s", err)
+		}
+		for _, endpoint := range endpoints {
+			add(fmt.Sprintf("%s:%d", endpoint.Host, endpoint.Port))
+		}
+	}
+	return nodes
+}
+
+// dataSources gets the retention settings of the org from the controller running in the same process
+func (c *Checker) dataSources(orgID uint16) (dataSources, error) {
+	url := fmt.Sprintf("http://localhost:%d/v1/data-sources/", c.cfg.ControllerListenPort)
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("X-Org-Id", strconv.Itoa(int(orgID)))
+	client := &http.Client{Timeout: DATA_SOURCE_REQUEST_TIMEOUT}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get data sources error, url: %s, code: %d", url, response.StatusCode)
+	}
+	var body struct {
+		Data dataSources `json:"DATA"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Data, nil
+}
+
+func expectedDatabases(expected []*ckdb.Table) map[string]struct{} {
+	databases := make(map[string]struct{})
+	for _, t := range expected {
+		databases[t.Database] = struct{}{}
+	}
+	return databases
+}
+
+func loadNodeSchema(conn *sql.DB, databases map[string]struct{}) (*nodeSchema, error) {
+	live := newNodeSchema()
+	inDatabases := func(db string) bool {
+		_, rawDb := parseOrgDatabase(db)
+		_, ok := databases[rawDb]
+		return ok
+	}
+
+	rows, err := conn.Query("SELECT name FROM system.databases")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var db string
+		if err := rows.Scan(&db); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if inDatabases(db) {
+			live.addDatabase(db)
+		}
+	}
+	rows.Close()
+
+	rows, err = conn.Query("SELECT database, name, engine, engine_full FROM system.tables WHERE NOT is_temporary")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var db, table, engine, engineFull string
+		if err := rows.Scan(&db, &table, &engine, &engineFull); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if inDatabases(db) {
+			live.addTable(db, table, engine, engineFull)
+		}
+	}
+	rows.Close()
+
+	rows, err = conn.Query("SELECT database, table, name, type, compression_codec FROM system.columns")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var db, table, name, columnType, codec string
+		if err := rows.Scan(&db, &table, &name, &columnType, &codec); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		live.addColumn(db, table, name, columnType, codec)
+	}
+	rows.Close()
+
+	rows, err = conn.Query("SELECT database, table, name, type FROM system.data_skipping_indices")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var db, table, name, indexType string
+		if err := rows.Scan(&db, &table, &name, &indexType); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		live.addIndex(db, table, name, indexType)
+	}
+	rows.Close()
+	return live, nil
+}
+
+// return orgIdPrefix,raw_database from orgDatabase. eg. '0123_flow_metrics', return '0123_', 'flow_metrics'
+func parseOrgDatabase(db string) (string, string) {
+	if len(db) > ckdb.ORG_ID_PREFIX_LEN && db[ckdb.ORG_ID_LEN] == '_' {
+		if _, err := strconv.Atoi(db[:ckdb.ORG_ID_LEN]); err == nil {
+			return db[:ckdb.ORG_ID_PREFIX_LEN], db[ckdb.ORG_ID_PREFIX_LEN:]
+		}
+	}
+	return "", db
+}
+
+func (c *Checker) checkNode(sb *strings.Builder, node string, expected []*ckdb.Table, orgFilter uint16, operate uint16, settings func(uint16) dataSources) {
+	conn, err := common.NewCKConnection(node, c.cfg.CKDBAuth.Username, c.cfg.CKDBAuth.Password)
+	if err != nil {
+		fmt.Fprintf(sb, "node %s: %s\n", node, err)
+		return
+	}
+	defer conn.Close()
+
+	live, err := loadNodeSchema(conn, expectedDatabases(expected))
+	if err != nil {
+		fmt.Fprintf(sb, "node %s: load schema failed: %s\n", node, err)
+		return
+	}
+	drifts := diff(expected, live, orgFilter, settings)
+	fmt.Fprintf(sb, "node %s: %d drift(s)\n", node, len(drifts))
+
+	for _, d := range drifts {
+		fmt.Fprintf(sb, "  %s\n", d)
+		if operate == ingesterctl.CK_SCHEMA_CHECK || len(d.Repair) == 0 {
+			continue
+		}
+		if !d.Safe {
+			for _, ddl := range d.Repair {
+				fmt.Fprintf(sb, "    -- manual: %s\n", strings.Join(strings.Fields(ddl), " "))
+			}
+			continue
+		}
+		for _, ddl := range d.Repair {
+			ddl = strings.Join(strings.Fields(ddl), " ")
+			if operate != ingesterctl.CK_SCHEMA_REPAIR_EXECUTE {
+				fmt.Fprintf(sb, "    %s;\n", ddl)
+				continue
+			}
+			log.Infof("schema repair on node %s: %s", node, ddl)
+			if _, err := conn.Exec(ddl); err != nil {
+				fmt.Fprintf(sb, "    %s; -- failed: %s\n", ddl, err)
+				break
+			}
+			fmt.Fprintf(sb, "    %s; -- done\n", ddl)
+		}
+	}
+}
+
+func (c *Checker) HandleSimpleCommand(operate uint16, arg string) string {
+	if c.cfg.CKDB.Type == ckdb.CKDBTypeByconity {
+		return "schema check is not supported for byconity"
+	}
+	orgFilter := uint16(0)
+	if arg != "" {
+		orgID, err := strconv.Atoi(arg)
+		if err != nil || !ckdb.IsValidOrgID(uint16(orgID)) {
+			return fmt.Sprintf("invalid org id '%s'", arg)
+		}
+		orgFilter = uint16(orgID)
+	}
+
+	expected := ckwriter.Tables()
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "checked %d tables\n", len(expected))
+	// the TTL of the tables is expected to be the retention time of their data sources
+	orgDataSources := make(map[uint16]dataSources)
+	settings := func(orgID uint16) dataSources {
+		if ds, ok := orgDataSources[orgID]; ok {
+			return ds
+		}
+		ds, err := c.dataSources(orgID)
+		if err != nil {
+			fmt.Fprintf(sb, "org %d: get data sources failed, expect the default TTL: %s\n", orgID, err)
+		}
+		orgDataSources[orgID] = ds
+		return ds
+	}
+	for _, node := range c.nodes() {
+		c.checkNode(sb, node, expected, orgFilter, operate, settings)
+	}
+	return sb.String()
+}
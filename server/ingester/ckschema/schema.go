@@ -0,0 +1,587 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ckschema
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/deepflowio/deepflow/server/libs/ckdb"
+)
+
+type DriftType uint8
+
+const (
+	TableMissing DriftType = iota
+	ColumnMissing
+	ColumnTypeMismatch
+	ColumnCodecMismatch
+	ColumnExtra
+	IndexMissing
+	IndexTypeMismatch
+	TTLMismatch
+	DistributedMissing
+	DistributedMismatch
+	RollupMissing
+	RollupColumnMissing
+	DatasourceIncomplete
+)
+
+var driftTypeStrings = []string{
+	TableMissing:         "table-missing",
+	ColumnMissing:        "column-missing",
+	ColumnTypeMismatch:   "column-type",
+	ColumnCodecMismatch:  "column-codec",
+	ColumnExtra:          "column-extra",
+	IndexMissing:         "index-missing",
+	IndexTypeMismatch:    "index-type",
+	TTLMismatch:          "ttl",
+	DistributedMissing:   "distributed-missing",
+	DistributedMismatch:  "distributed-engine",
+	RollupMissing:        "rollup-missing",
+	RollupColumnMissing:  "rollup-column-missing",
+	DatasourceIncomplete: "datasource-incomplete",
+}
+
+func (t DriftType) String() string {
+	return driftTypeStrings[t]
+}
+
+// Drift is a difference between the live table on a clickhouse node and the in-code schema
+type Drift struct {
+	Type     DriftType
+	Database string
+	Table    string
+	Column   string
+	Expected string
+	Actual   string
+	// DDL to fix the drift, empty if it can not be repaired automatically
+	Repair []string
+	// only the additive DDL (create table, add column/index) is safe, and will be executed by 'repair --execute'
+	Safe bool
+}
+
+func (d *Drift) String() string {
+	name := d.Database + ".`" + d.Table + "`"
+	if d.Column != "" {
+		name += "." + d.Column
+	}
+	expected, actual := d.Expected, d.Actual
+	if expected == "" {
+		expected = "-"
+	}
+	if actual == "" {
+		actual = "-"
+	}
+	return fmt.Sprintf("[%s] %s expected: %s, actual: %s", d.Type, name, expected, actual)
+}
+
+// dataSource is the retention setting of a data source in the controller, which is modified by the users
+type dataSource struct {
+	Name                string `json:"NAME"`
+	DataTableCollection string `json:"DATA_TABLE_COLLECTION"`
+	RetentionTime       int    `json:"RETENTION_TIME"` // hour
+}
+
+type dataSources []dataSource
+
+// ttl returns the retention time of the data source the table belongs to, eg. 'flow_metrics.network*' of
+// interval '1m' for 'flow_metrics.network_map.1m', or 'defaultTTL' if no data source matches
+func (s dataSources) ttl(db, table string, defaultTTL int) int {
+	base, interval := db+"."+table, ""
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		base, interval = db+"."+table[:i], table[i+1:]
+	}
+	for _, d := range s {
+		if d.RetentionTime <= 0 {
+			continue
+		}
+		if collection := d.DataTableCollection; strings.HasSuffix(collection, "*") {
+			if !strings.HasPrefix(base, strings.TrimSuffix(collection, "*")) {
+				continue
+			}
+		} else if base != collection {
+			continue
+		}
+		// the metrics tables of all intervals share the collection
+		if interval != "" && interval != d.Name {
+			continue
+		}
+		return d.RetentionTime
+	}
+	return defaultTTL
+}
+
+type liveColumn struct {
+	Type  string
+	Codec string
+}
+
+type liveTable struct {
+	Engine     string
+	EngineFull string
+	Columns    map[string]*liveColumn
+	Indexes    map[string]string // index name => index type
+}
+
+// nodeSchema is the schema read from the 'system' database of one clickhouse node
+type nodeSchema struct {
+	tables map[string]map[string]*liveTable // database => table name => table
+}
+
+func newNodeSchema() *nodeSchema {
+	return &nodeSchema{tables: make(map[string]map[string]*liveTable)}
+}
+
+func (s *nodeSchema) addDatabase(db string) {
+	if _, ok := s.tables[db]; !ok {
+		s.tables[db] = make(map[string]*liveTable)
+	}
+}
+
+func (s *nodeSchema) addTable(db, table, engine, engineFull string) {
+	s.addDatabase(db)
+	s.tables[db][table] = &liveTable{
+		Engine:     engine,
+		EngineFull: engineFull,
+		Columns:    make(map[string]*liveColumn),
+		Indexes:    make(map[string]string),
+	}
+}
+
+func (s *nodeSchema) table(db, table string) *liveTable {
+	return s.tables[db][table]
+}
+
+func (s *nodeSchema) addColumn(db, table, name, columnType, codec string) {
+	if t := s.table(db, table); t != nil {
+		t.Columns[name] = &liveColumn{Type: columnType, Codec: codec}
+	}
+}
+
+func (s *nodeSchema) addIndex(db, table, name, indexType string) {
+	if t := s.table(db, table); t != nil {
+		t.Indexes[name] = indexType
+	}
+}
+
+// return the org ids whose database of 'db' exists on the node
+func (s *nodeSchema) orgIDs(db string) []uint16 {
+	orgIDs := []uint16{}
+	for name := range s.tables {
+		if name == db {
+			orgIDs = append(orgIDs, ckdb.DEFAULT_ORG_ID)
+			continue
+		}
+		if len(name) != ckdb.ORG_ID_PREFIX_LEN+len(db) || name[ckdb.ORG_ID_LEN] != '_' || name[ckdb.ORG_ID_PREFIX_LEN:] != db {
+			continue
+		}
+		orgID, err := strconv.Atoi(name[:ckdb.ORG_ID_LEN])
+		if err != nil || !ckdb.IsValidOrgID(uint16(orgID)) || ckdb.IsDefaultOrgID(uint16(orgID)) {
+			continue
+		}
+		orgIDs = append(orgIDs, uint16(orgID))
+	}
+	sort.Slice(orgIDs, func(i, j int) bool { return orgIDs[i] < orgIDs[j] })
+	return orgIDs
+}
+
+var (
+	dateTimeReg    = regexp.MustCompile(`DateTime\('[^']*'\)`)
+	dateTime64Reg  = regexp.MustCompile(`DateTime64\((\d+),'[^']*'\)`)
+	ttlReg         = regexp.MustCompile(`TTL (\w+) \+ toIntervalHour\((\d+)\)`)
+	distributedReg = regexp.MustCompile(`^Distributed\('([^']*)', '([^']*)', '([^']*)'`)
+)
+
+// the time zone of the columns is modified by the ingester at startup, so ignore it when comparing types
+func normalizeType(t string) string {
+	t = strings.Join(strings.Fields(t), "")
+	t = dateTimeReg.ReplaceAllString(t, "DateTime")
+	return dateTime64Reg.ReplaceAllString(t, "DateTime64($1)")
+}
+
+func expectedType(c *ckdb.Column) string {
+	if c.TypeArgs != "" {
+		return fmt.Sprintf(c.Type.String(), c.TypeArgs)
+	}
+	return c.Type.String()
+}
+
+func expectedCodec(c *ckdb.Column) string {
+	if c.Codec == ckdb.CodecDefault {
+		return ""
+	}
+	return fmt.Sprintf("CODEC(%s)", c.Codec.String())
+}
+
+// only compare the index type name, the arguments such as 'set(300)' are shown as 'set' in 'system.data_skipping_indices'
+func indexTypeName(t string) string {
+	if i := strings.Index(t, "("); i >= 0 {
+		return t[:i]
+	}
+	return t
+}
+
+func tableName(db, table string) string {
+	return fmt.Sprintf("%s.`%s`", db, table)
+}
+
+func tablePrefix(t *ckdb.Table) string {
+	return strings.Split(t.GlobalName, ".")[0]
+}
+
+func addColumnSQL(db, table string, c *ckdb.Column, withCodec bool) string {
+	codec := ""
+	if withCodec && c.Codec != ckdb.CodecDefault {
+		codec = " " + expectedCodec(c)
+	}
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s%s", tableName(db, table), c.Name, expectedType(c), codec)
+}
+
+func addIndexSQL(db, table string, c *ckdb.Column) string {
+	return fmt.Sprintf("ALTER TABLE %s ADD INDEX IF NOT EXISTS %s_idx (%s) TYPE %s GRANULARITY 2", tableName(db, table), c.Name, c.Name, c.Index.String())
+}
+
+// diff returns the drifts of all expected tables on one node, if 'orgFilter' is not 0, only the database of the org is checked.
+// 'settings' returns the data sources of an org, the TTL in code is expected if it is nil.
+func diff(expected []*ckdb.Table, live *nodeSchema, orgFilter uint16, settings func(orgID uint16) dataSources) []*Drift {
+	drifts := []*Drift{}
+	for _, t := range expected {
+		for _, orgID := range live.orgIDs(t.Database) {
+			if orgFilter != 0 && orgID != orgFilter {
+				continue
+			}
+			var ds dataSources
+			if settings != nil {
+				ds = settings(orgID)
+			}
+			drifts = append(drifts, diffTable(t, orgID, live, ds)...)
+			if t.Aggr1H1D {
+				drifts = append(drifts, diffRollups(t, orgID, live, ds)...)
+			}
+		}
+	}
+	return drifts
+}
+
+func diffTable(t *ckdb.Table, orgID uint16, live *nodeSchema, ds dataSources) []*Drift {
+	db := t.OrgDatabase(orgID)
+	drifts := []*Drift{}
+
+	local := live.table(db, t.LocalName)
+	if local == nil {
+		drifts = append(drifts, &Drift{
+			Type:     TableMissing,
+			Database: db,
+			Table:    t.LocalName,
+			Expected: "exists",
+			Repair:   []string{t.MakeOrgLocalTableCreateSQL(orgID)},
+			Safe:     true,
+		})
+	} else {
+		drifts = append(drifts, diffColumns(t, db, t.LocalName, local, true)...)
+		drifts = append(drifts, diffIndexes(t, db, local)...)
+		if d := diffTTL(t, orgID, local, ds.ttl(t.Database, t.GlobalName, t.TTL)); d != nil {
+			drifts = append(drifts, d)
+		}
+	}
+
+	global := live.table(db, t.GlobalName)
+	if global == nil {
+		drifts = append(drifts, &Drift{
+			Type:     DistributedMissing,
+			Database: db,
+			Table:    t.GlobalName,
+			Expected: fmt.Sprintf(ckdb.Distributed.String(), t.Cluster, db, t.LocalName),
+			Repair:   []string{t.MakeOrgGlobalTableCreateSQL(orgID)},
+			Safe:     true,
+		})
+		return drifts
+	}
+	expectedEngine := fmt.Sprintf("Distributed('%s', '%s', '%s')", t.Cluster, db, t.LocalName)
+	actualEngine := global.Engine
+	if matchs := distributedReg.FindStringSubmatch(global.EngineFull); len(matchs) == 4 {
+		actualEngine = fmt.Sprintf("Distributed('%s', '%s', '%s')", matchs[1], matchs[2], matchs[3])
+	}
+	if actualEngine != expectedEngine {
+		drifts = append(drifts, &Drift{
+			Type:     DistributedMismatch,
+			Database: db,
+			Table:    t.GlobalName,
+			Expected: expectedEngine,
+			Actual:   actualEngine,
+			Repair: []string{
+				fmt.Sprintf("DROP TABLE IF EXISTS %s", tableName(db, t.GlobalName)),
+				t.MakeOrgGlobalTableCreateSQL(orgID),
+			},
+		})
+	}
+	drifts = append(drifts, diffColumns(t, db, t.GlobalName, global, false)...)
+	return drifts
+}
+
+// the codecs, indexes and the columns not in code (such as the native tags) are only checked on the local table
+func diffColumns(t *ckdb.Table, db, table string, live *liveTable, isLocal bool) []*Drift {
+	drifts := []*Drift{}
+	expectedNames := make(map[string]struct{}, len(t.Columns))
+	for _, c := range t.Columns {
+		expectedNames[c.Name] = struct{}{}
+		lc, ok := live.Columns[c.Name]
+		if !ok {
+			drifts = append(drifts, &Drift{
+				Type:     ColumnMissing,
+				Database: db,
+				Table:    table,
+				Column:   c.Name,
+				Expected: expectedType(c),
+				Repair:   []string{addColumnSQL(db, table, c, isLocal)},
+				Safe:     true,
+			})
+			continue
+		}
+		if normalizeType(lc.Type) != normalizeType(expectedType(c)) {
+			drifts = append(drifts, &Drift{
+				Type:     ColumnTypeMismatch,
+				Database: db,
+				Table:    table,
+				Column:   c.Name,
+				Expected: expectedType(c),
+				Actual:   lc.Type,
+				Repair:   []string{fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", tableName(db, table), c.Name, expectedType(c))},
+			})
+			continue
+		}
+		if !isLocal {
+			continue
+		}
+		if codec := expectedCodec(c); normalizeType(lc.Codec) != normalizeType(codec) {
+			repair := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s %s", tableName(db, table), c.Name, expectedType(c), codec)
+			if codec == "" {
+				repair = fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s REMOVE CODEC", tableName(db, table), c.Name)
+			}
+			drifts = append(drifts, &Drift{
+				Type:     ColumnCodecMismatch,
+				Database: db,
+				Table:    table,
+				Column:   c.Name,
+				Expected: codec,
+				Actual:   lc.Codec,
+				Repair:   []string{repair},
+			})
+		}
+	}
+	if !isLocal {
+		return drifts
+	}
+
+	extras := []string{}
+	for name := range live.Columns {
+		if _, ok := expectedNames[name]; !ok {
+			extras = append(extras, name)
+		}
+	}
+	sort.Strings(extras)
+	for _, name := range extras {
+		drifts = append(drifts, &Drift{
+			Type:     ColumnExtra,
+			Database: db,
+			Table:    table,
+			Column:   name,
+			Actual:   live.Columns[name].Type,
+		})
+	}
+	return drifts
+}
+
+func diffIndexes(t *ckdb.Table, db string, live *liveTable) []*Drift {
+	drifts := []*Drift{}
+	for _, c := range t.Columns {
+		if c.Index == ckdb.IndexNone {
+			continue
+		}
+		name := c.Name + "_idx"
+		indexType, ok := live.Indexes[name]
+		if !ok {
+			drifts = append(drifts, &Drift{
+				Type:     IndexMissing,
+				Database: db,
+				Table:    t.LocalName,
+				Column:   name,
+				Expected: c.Index.String(),
+				Repair:   []string{addIndexSQL(db, t.LocalName, c)},
+				Safe:     true,
+			})
+		} else if indexTypeName(indexType) != indexTypeName(c.Index.String()) {
+			drifts = append(drifts, &Drift{
+				Type:     IndexTypeMismatch,
+				Database: db,
+				Table:    t.LocalName,
+				Column:   name,
+				Expected: c.Index.String(),
+				Actual:   indexType,
+				Repair: []string{
+					fmt.Sprintf("ALTER TABLE %s DROP INDEX IF EXISTS %s", tableName(db, t.LocalName), name),
+					addIndexSQL(db, t.LocalName, c),
+				},
+			})
+		}
+	}
+	return drifts
+}
+
+// modifying the TTL will delete or move the data, so it is never executed automatically
+func diffTTL(t *ckdb.Table, orgID uint16, live *liveTable, ttl int) *Drift {
+	if ttl <= 0 {
+		return nil
+	}
+	actual := ""
+	if matchs := ttlReg.FindStringSubmatch(live.EngineFull); len(matchs) == 3 {
+		if matchs[1] == t.TimeKey && matchs[2] == strconv.Itoa(ttl) {
+			return nil
+		}
+		actual = fmt.Sprintf("%s + %sh", matchs[1], matchs[2])
+	}
+	return &Drift{
+		Type:     TTLMismatch,
+		Database: t.OrgDatabase(orgID),
+		Table:    t.LocalName,
+		Expected: fmt.Sprintf("%s + %dh", t.TimeKey, ttl),
+		Actual:   actual,
+		Repair:   []string{t.MakeOrgModifyTTLSQL(orgID, ttl)},
+	}
+}
+
+// diffRollups checks the 1h/1d agg, mv, local and global tables of the table, and the user defined datasources built on it
+func diffRollups(t *ckdb.Table, orgID uint16, live *nodeSchema, ds dataSources) []*Drift {
+	db := t.OrgDatabase(orgID)
+	prefix := tablePrefix(t)
+	drifts := []*Drift{}
+
+	for _, interval := range []ckdb.AggregationInterval{ckdb.AggregationHour, ckdb.AggregationDay} {
+		ttl := ckdb.DEFAULT_1H_TTL
+		if interval == ckdb.AggregationDay {
+			ttl = ckdb.DEFAULT_1D_TTL
+		}
+		name := prefix + "." + interval.String()
+		ttl = ds.ttl(t.Database, name, ttl)
+		mvSQL := t.MakeAggrMVTableCreateSQL(orgID, interval)
+		localSQL := t.MakeAggrLocalTableCreateSQL(orgID, interval)
+		rollups := []struct {
+			table string
+			sql   string
+		}{
+			{name + "_agg", t.MakeAggrTableCreateSQL(orgID, interval, ttl)},
+			{name + "_mv", mvSQL},
+			{name + "_local", localSQL},
+			{name, t.MakeAggrGlobalTableCreateSQL(orgID, interval)},
+		}
+		for _, r := range rollups {
+			if live.table(db, r.table) == nil {
+				drifts = append(drifts, &Drift{
+					Type:     RollupMissing,
+					Database: db,
+					Table:    r.table,
+					Expected: "exists",
+					Repair:   []string{r.sql},
+					Safe:     true,
+				})
+			}
+		}
+
+		agg := live.table(db, name+"_agg")
+		if agg == nil {
+			continue
+		}
+		for _, c := range t.Columns {
+			if strings.HasPrefix(c.Name, "_") {
+				continue
+			}
+			columnName, columnType := t.AggrColumn(c)
+			if _, ok := agg.Columns[columnName]; ok {
+				continue
+			}
+			// the mv and local views select all the columns, they must be recreated after the column is added
+			drifts = append(drifts, &Drift{
+				Type:     RollupColumnMissing,
+				Database: db,
+				Table:    name + "_agg",
+				Column:   columnName,
+				Expected: columnType,
+				Repair: []string{
+					fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", tableName(db, name+"_agg"), columnName, columnType),
+					fmt.Sprintf("DROP TABLE IF EXISTS %s", tableName(db, name+"_mv")),
+					mvSQL,
+					fmt.Sprintf("DROP TABLE IF EXISTS %s", tableName(db, name+"_local")),
+					localSQL,
+				},
+			})
+		}
+	}
+	return append(drifts, diffDatasources(t, db, live)...)
+}
+
+// the user defined datasources (such as 'network.1w') are created by the datasource manager with the user's
+// aggregation functions, only check that all their tables exist and the agg table has all the columns
+func diffDatasources(t *ckdb.Table, db string, live *nodeSchema) []*Drift {
+	prefix := tablePrefix(t) + "."
+	names := []string{}
+	for table := range live.tables[db] {
+		if !strings.HasPrefix(table, prefix) || !strings.HasSuffix(table, "_agg") {
+			continue
+		}
+		name := strings.TrimSuffix(table, "_agg")
+		if name == prefix+ckdb.AGGREGATION_1H || name == prefix+ckdb.AGGREGATION_1D {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	drifts := []*Drift{}
+	for _, name := range names {
+		for _, table := range []string{name + "_mv", name + "_local", name} {
+			if live.table(db, table) == nil {
+				drifts = append(drifts, &Drift{
+					Type:     DatasourceIncomplete,
+					Database: db,
+					Table:    table,
+					Expected: "exists",
+				})
+			}
+		}
+		agg := live.table(db, name+"_agg")
+		for _, c := range t.Columns {
+			if strings.HasPrefix(c.Name, "_") {
+				continue
+			}
+			columnName, _ := t.AggrColumn(c)
+			if _, ok := agg.Columns[columnName]; !ok {
+				drifts = append(drifts, &Drift{
+					Type:     DatasourceIncomplete,
+					Database: db,
+					Table:    name + "_agg",
+					Column:   columnName,
+					Expected: "exists",
+				})
+			}
+		}
+	}
+	return drifts
+}
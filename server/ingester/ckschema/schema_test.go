@@ -0,0 +1,240 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ckschema
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/deepflowio/deepflow/server/libs/ckdb"
+)
+
+func newTestTable(aggr1H1D bool) *ckdb.Table {
+	return &ckdb.Table{
+		Version:    "v1",
+		Database:   "flow_metrics",
+		LocalName:  "network.1m_local",
+		GlobalName: "network.1m",
+		Columns: []*ckdb.Column{
+			ckdb.NewColumnWithGroupBy("time", ckdb.DateTime),
+			ckdb.NewColumnWithGroupBy("ip4", ckdb.IPv4),
+			ckdb.NewColumn("byte", ckdb.UInt64),
+			ckdb.NewColumn("rtt_max", ckdb.Float64),
+		},
+		TimeKey:         "time",
+		TTL:             168,
+		PartitionFunc:   ckdb.TimeFuncTwelveHour,
+		Engine:          ckdb.MergeTree,
+		Cluster:         "df_cluster",
+		StoragePolicy:   "df_storage",
+		OrderKeys:       []string{"time", "ip4"},
+		PrimaryKeyCount: 2,
+		Aggr1H1D:        aggr1H1D,
+	}
+}
+
+// build the live schema of a node which has exactly the tables created from the code
+func newTestNodeSchema(t *ckdb.Table, db string) *nodeSchema {
+	live := newNodeSchema()
+	live.addTable(db, t.LocalName, "MergeTree", "MergeTree PARTITION BY toStartOfInterval(time, toIntervalHour(12)) ORDER BY (time, ip4) TTL time + toIntervalHour(168) SETTINGS storage_policy = 'df_storage'")
+	live.addTable(db, t.GlobalName, "Distributed", "Distributed('df_cluster', '"+db+"', 'network.1m_local', rand())")
+	for _, c := range t.Columns {
+		codec := ""
+		if c.Codec != ckdb.CodecDefault {
+			codec = "CODEC(" + c.Codec.String() + ")"
+		}
+		// the time zone has been modified by the ingester
+		columnType := strings.ReplaceAll(expectedType(c), ckdb.DF_TIMEZONE, "UTC")
+		live.addColumn(db, t.LocalName, c.Name, columnType, codec)
+		live.addColumn(db, t.GlobalName, c.Name, columnType, codec)
+		if c.Index != ckdb.IndexNone {
+			live.addIndex(db, t.LocalName, c.Name+"_idx", indexTypeName(c.Index.String()))
+		}
+	}
+	return live
+}
+
+func driftTypes(drifts []*Drift) []string {
+	types := []string{}
+	for _, d := range drifts {
+		types = append(types, d.Type.String()+" "+d.Database+"."+d.Table+"."+d.Column)
+	}
+	return types
+}
+
+func TestDiffNoDrift(t *testing.T) {
+	table := newTestTable(false)
+	live := newTestNodeSchema(table, "flow_metrics")
+	if drifts := diff([]*ckdb.Table{table}, live, 0, nil); len(drifts) != 0 {
+		t.Errorf("expected no drift, got %v", driftTypes(drifts))
+	}
+}
+
+func TestDiffColumns(t *testing.T) {
+	table := newTestTable(false)
+	live := newTestNodeSchema(table, "flow_metrics")
+	local := live.table("flow_metrics", table.LocalName)
+	delete(local.Columns, "byte")
+	local.Columns["rtt_max"].Codec = "CODEC(ZSTD(1))"
+	local.Columns["ip4"].Type = "UInt32"
+	local.Columns["tag_x"] = &liveColumn{Type: "String"}
+	delete(local.Indexes, "time_idx")
+
+	drifts := diff([]*ckdb.Table{table}, live, 0, nil)
+	expected := []string{
+		"column-type flow_metrics.network.1m_local.ip4",
+		"column-missing flow_metrics.network.1m_local.byte",
+		"column-codec flow_metrics.network.1m_local.rtt_max",
+		"column-extra flow_metrics.network.1m_local.tag_x",
+		"index-missing flow_metrics.network.1m_local.time_idx",
+	}
+	if got := driftTypes(drifts); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	missing := drifts[1]
+	if !missing.Safe || missing.Repair[0] != "ALTER TABLE flow_metrics.`network.1m_local` ADD COLUMN IF NOT EXISTS byte UInt64 CODEC(T64)" {
+		t.Errorf("unexpected repair of missing column: %v %v", missing.Safe, missing.Repair)
+	}
+	if drifts[0].Safe || drifts[2].Safe || drifts[3].Repair != nil {
+		t.Error("modifying or dropping columns must not be safe")
+	}
+	if drifts[4].Repair[0] != "ALTER TABLE flow_metrics.`network.1m_local` ADD INDEX IF NOT EXISTS time_idx (time) TYPE minmax GRANULARITY 2" {
+		t.Errorf("unexpected repair of missing index: %v", drifts[4].Repair)
+	}
+}
+
+func TestDiffTableAndDistributed(t *testing.T) {
+	table := newTestTable(false)
+	live := newTestNodeSchema(table, "flow_metrics")
+	// org 2 has the database but no tables, org 3 has a wrong distributed table
+	live.addDatabase("0002_flow_metrics")
+	other := newTestNodeSchema(table, "0003_flow_metrics")
+	live.tables["0003_flow_metrics"] = other.tables["0003_flow_metrics"]
+	live.table("0003_flow_metrics", table.GlobalName).EngineFull = "Distributed('df_cluster', 'flow_metrics', 'network.1m_local', rand())"
+	live.table("0003_flow_metrics", table.LocalName).EngineFull = "MergeTree ORDER BY (time, ip4) TTL time + toIntervalHour(24)"
+	// not an org database
+	live.addDatabase("abcd_flow_metrics")
+
+	drifts := diff([]*ckdb.Table{table}, live, 0, nil)
+	expected := []string{
+		"table-missing 0002_flow_metrics.network.1m_local.",
+		"distributed-missing 0002_flow_metrics.network.1m.",
+		"ttl 0003_flow_metrics.network.1m_local.",
+		"distributed-engine 0003_flow_metrics.network.1m.",
+	}
+	if got := driftTypes(drifts); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if !drifts[0].Safe || !strings.Contains(drifts[0].Repair[0], "CREATE TABLE IF NOT EXISTS 0002_flow_metrics.`network.1m_local`") {
+		t.Errorf("unexpected repair of missing table: %v", drifts[0].Repair)
+	}
+	if drifts[2].Safe || drifts[2].Actual != "time + 24h" || drifts[2].Repair[0] != "ALTER TABLE 0003_flow_metrics.`network.1m_local` MODIFY TTL time + toIntervalHour(168)" {
+		t.Errorf("unexpected ttl drift: %+v", drifts[2])
+	}
+	if drifts[3].Safe || len(drifts[3].Repair) != 2 {
+		t.Errorf("unexpected distributed drift: %+v", drifts[3])
+	}
+
+	if drifts := diff([]*ckdb.Table{table}, live, 2, nil); len(drifts) != 2 {
+		t.Errorf("expected 2 drifts of org 2, got %v", driftTypes(drifts))
+	}
+	if drifts := diff([]*ckdb.Table{table}, live, 1, nil); len(drifts) != 0 {
+		t.Errorf("expected no drift of the default org, got %v", driftTypes(drifts))
+	}
+}
+
+func TestDiffTTLDataSources(t *testing.T) {
+	ds := dataSources{
+		{Name: "1s", DataTableCollection: "flow_metrics.network*", RetentionTime: 24},
+		{Name: "1m", DataTableCollection: "flow_metrics.application*", RetentionTime: 72},
+		{Name: "1m", DataTableCollection: "flow_metrics.network*", RetentionTime: 720},
+		{Name: "1h", DataTableCollection: "flow_metrics.network*", RetentionTime: 2160},
+		{Name: "1m", DataTableCollection: "flow_metrics.traffic_policy", RetentionTime: 48},
+		{Name: "ext_metrics", DataTableCollection: "ext_metrics.*", RetentionTime: 336},
+		{Name: "flow_log.l7_flow_log", DataTableCollection: "flow_log.l7_flow_log", RetentionTime: 96},
+	}
+	for _, c := range []struct {
+		db, table string
+		ttl       int
+	}{
+		{"flow_metrics", "network_map.1m", 720},
+		{"flow_metrics", "network.1s", 24},
+		{"flow_metrics", "network.1h", 2160},
+		{"flow_metrics", "application_map.1m", 72},
+		{"flow_metrics", "traffic_policy.1m", 48},
+		{"ext_metrics", "metrics", 336},
+		{"flow_log", "l7_flow_log", 96},
+		{"flow_log", "l4_flow_log", 1},
+	} {
+		if ttl := ds.ttl(c.db, c.table, 1); ttl != c.ttl {
+			t.Errorf("expected ttl %d of %s.%s, got %d", c.ttl, c.db, c.table, ttl)
+		}
+	}
+
+	table := newTestTable(false)
+	live := newTestNodeSchema(table, "flow_metrics")
+	settings := func(orgID uint16) dataSources { return ds }
+	drifts := diff([]*ckdb.Table{table}, live, 0, settings)
+	if len(drifts) != 1 || drifts[0].Type != TTLMismatch || drifts[0].Expected != "time + 720h" || drifts[0].Actual != "time + 168h" ||
+		drifts[0].Repair[0] != "ALTER TABLE flow_metrics.`network.1m_local` MODIFY TTL time + toIntervalHour(720)" {
+		t.Fatalf("expected the ttl of the data source, got %v", drifts)
+	}
+	live.table("flow_metrics", table.LocalName).EngineFull = "MergeTree ORDER BY (time, ip4) TTL time + toIntervalHour(720)"
+	if drifts := diff([]*ckdb.Table{table}, live, 0, settings); len(drifts) != 0 {
+		t.Errorf("expected no drift, got %v", driftTypes(drifts))
+	}
+}
+
+func TestDiffRollups(t *testing.T) {
+	table := newTestTable(true)
+	live := newTestNodeSchema(table, "flow_metrics")
+	for _, name := range []string{"network.1h", "network.1d", "network.1w"} {
+		live.addTable("flow_metrics", name+"_agg", "AggregatingMergeTree", "")
+		for _, c := range table.Columns {
+			columnName, columnType := table.AggrColumn(c)
+			live.addColumn("flow_metrics", name+"_agg", columnName, columnType, "")
+		}
+		live.addTable("flow_metrics", name+"_mv", "MaterializedView", "")
+		live.addTable("flow_metrics", name+"_local", "View", "")
+		live.addTable("flow_metrics", name, "Distributed", "")
+	}
+	delete(live.tables["flow_metrics"], "network.1d_mv")
+	delete(live.table("flow_metrics", "network.1h_agg").Columns, "rtt_max__agg")
+	delete(live.tables["flow_metrics"], "network.1w_local")
+	delete(live.table("flow_metrics", "network.1w_agg").Columns, "byte__agg")
+
+	drifts := diff([]*ckdb.Table{table}, live, 0, nil)
+	expected := []string{
+		"rollup-column-missing flow_metrics.network.1h_agg.rtt_max__agg",
+		"rollup-missing flow_metrics.network.1d_mv.",
+		"datasource-incomplete flow_metrics.network.1w_local.",
+		"datasource-incomplete flow_metrics.network.1w_agg.byte__agg",
+	}
+	if got := driftTypes(drifts); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if drifts[0].Expected != "AggregateFunction(avg, Float64)" || drifts[0].Safe || len(drifts[0].Repair) != 5 {
+		t.Errorf("unexpected rollup column drift: %+v", drifts[0])
+	}
+	if !drifts[1].Safe || !strings.Contains(drifts[1].Repair[0], "CREATE MATERIALIZED VIEW IF NOT EXISTS flow_metrics.`network.1d_mv`") {
+		t.Errorf("unexpected rollup drift: %+v", drifts[1])
+	}
+	if drifts[2].Repair != nil || drifts[3].Repair != nil {
+		t.Error("user defined datasources must not be repaired")
+	}
+}
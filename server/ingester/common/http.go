@@ -0,0 +1,190 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/gorilla/mux"
+
+	"github.com/deepflowio/deepflow/server/libs/ckdb"
+)
+
+const (
+	HEADER_KEY_X_ORG_ID       = "X-Org-Id"
+	HEADER_KEY_X_TEAM_ID      = "X-Team-Id"
+	HEADER_KEY_X_SCOPE_ORG_ID = "X-Scope-OrgID" // Loki/Pyroscope tenant header, used when X-Org-Id is absent
+)
+
+// ParseOrgTeamID gets the org/team of the data pushed to the http receivers from the request
+// headers, default org 1 and team 1.
+func ParseOrgTeamID(req *http.Request) (uint16, uint16, error) {
+	orgId, teamId := uint16(ckdb.DEFAULT_ORG_ID), uint16(ckdb.DEFAULT_TEAM_ID)
+	orgStr := req.Header.Get(HEADER_KEY_X_ORG_ID)
+	if orgStr == "" {
+		orgStr = req.Header.Get(HEADER_KEY_X_SCOPE_ORG_ID)
+	}
+	if orgStr != "" {
+		id, err := strconv.ParseUint(orgStr, 10, 16)
+		if err != nil || !ckdb.IsValidOrgID(uint16(id)) {
+			return 0, 0, fmt.Errorf("invalid org id %s", orgStr)
+		}
+		orgId = uint16(id)
+	}
+	if teamStr := req.Header.Get(HEADER_KEY_X_TEAM_ID); teamStr != "" {
+		id, err := strconv.ParseUint(teamStr, 10, 16)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid team id %s", teamStr)
+		}
+		teamId = uint16(id)
+	}
+	return orgId, teamId, nil
+}
+
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// HttpServer serves the handlers of the http receivers pushing data to the ingester, and reads the
+// request bodies with the size limit of the receiver.
+type HttpServer struct {
+	name        string
+	port        int
+	maxBodySize int64
+	server      *http.Server
+	router      *mux.Router
+
+	errorCount int64
+}
+
+func NewHttpServer(name string, port, maxBodySize int) *HttpServer {
+	router := mux.NewRouter()
+	return &HttpServer{
+		name:        name,
+		port:        port,
+		maxBodySize: int64(maxBodySize),
+		server: &http.Server{
+			Addr:    ":" + strconv.Itoa(port),
+			Handler: router,
+		},
+		router: router,
+	}
+}
+
+func (s *HttpServer) Router() *mux.Router {
+	return s.router
+}
+
+func (s *HttpServer) Port() int {
+	return s.port
+}
+
+// SwapErrorCount returns the count of error responses since the last call
+func (s *HttpServer) SwapErrorCount() int64 {
+	return atomic.SwapInt64(&s.errorCount, 0)
+}
+
+func (s *HttpServer) Start() {
+	go func() {
+		if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Errorf("%s http receiver ListenAndServe() failed: %v", s.name, err)
+		}
+	}()
+	log.Infof("%s http receiver started, listen port: %d", s.name, s.port)
+}
+
+func (s *HttpServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// ReadBody reads the body limited to the max body size before and after gzip decompression,
+// ErrBodyTooLarge is returned instead of truncating the body
+func (s *HttpServer) ReadBody(req *http.Request) ([]byte, error) {
+	var reader io.Reader = http.MaxBytesReader(nil, req.Body, s.maxBodySize)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, bodyError(err)
+		}
+		defer gzipReader.Close()
+		reader = io.LimitReader(gzipReader, s.maxBodySize+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, bodyError(err)
+	}
+	if int64(len(body)) > s.maxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	return body, nil
+}
+
+// SnappyDecode checks the decoded length of the snappy compressed body against the max body size
+// before decoding, a small body can claim a decoded length of up to 4GB
+func (s *HttpServer) SnappyDecode(compressed []byte) ([]byte, error) {
+	if err := s.CheckSnappyDecodedLen(compressed); err != nil {
+		return nil, err
+	}
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy body: %s", err)
+	}
+	return body, nil
+}
+
+// CheckSnappyDecodedLen is used when the snappy compressed body is passed on as it is
+func (s *HttpServer) CheckSnappyDecodedLen(compressed []byte) error {
+	decodedLen, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return fmt.Errorf("invalid snappy body: %s", err)
+	}
+	if int64(decodedLen) > s.maxBodySize {
+		return ErrBodyTooLarge
+	}
+	return nil
+}
+
+func bodyError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return ErrBodyTooLarge
+	}
+	return err
+}
+
+func (s *HttpServer) RespError(w http.ResponseWriter, code int, err error) {
+	atomic.AddInt64(&s.errorCount, 1)
+	log.Debugf("%s http receiver response %d: %s", s.name, code, err)
+	http.Error(w, err.Error(), code)
+}
+
+// RespBodyError responds 413 for ErrBodyTooLarge, and 400 for the other errors of reading or parsing the body
+func (s *HttpServer) RespBodyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrBodyTooLarge) {
+		s.RespError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	s.RespError(w, http.StatusBadRequest, err)
+}
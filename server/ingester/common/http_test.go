@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+func TestReadBodyTooLarge(t *testing.T) {
+	s := NewHttpServer("test", 0, 1024)
+	gzipBody := func(data []byte) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		w := gzip.NewWriter(buf)
+		w.Write(data)
+		w.Close()
+		return buf
+	}
+
+	req := httptest.NewRequest("POST", "/", bytes.NewBuffer(make([]byte, 1024)))
+	if body, err := s.ReadBody(req); err != nil || len(body) != 1024 {
+		t.Errorf("read body of max size failed, len %d, err %v", len(body), err)
+	}
+	req = httptest.NewRequest("POST", "/", bytes.NewBuffer(make([]byte, 1025)))
+	if _, err := s.ReadBody(req); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+	req = httptest.NewRequest("POST", "/", gzipBody(bytes.Repeat([]byte("a"), 1024)))
+	req.Header.Set("Content-Encoding", "gzip")
+	if body, err := s.ReadBody(req); err != nil || len(body) != 1024 {
+		t.Errorf("read gzip body of max size failed, len %d, err %v", len(body), err)
+	}
+	// compressed body is small, but the decompressed body is over the limit
+	req = httptest.NewRequest("POST", "/", gzipBody(bytes.Repeat([]byte("a"), 1025)))
+	req.Header.Set("Content-Encoding", "gzip")
+	if _, err := s.ReadBody(req); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+}
+
+func TestSnappyDecode(t *testing.T) {
+	s := NewHttpServer("test", 0, 1024)
+	data := bytes.Repeat([]byte("a"), 1024)
+	if body, err := s.SnappyDecode(snappy.Encode(nil, data)); err != nil || !bytes.Equal(body, data) {
+		t.Errorf("snappy decode of max size failed, err %v", err)
+	}
+	if _, err := s.SnappyDecode(snappy.Encode(nil, append(data, 'a'))); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+	// a 5 bytes body claiming a decoded length of 4GB
+	if _, err := s.SnappyDecode([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+	if _, err := s.SnappyDecode([]byte{0xff}); err == nil || errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("expected invalid snappy body, got %v", err)
+	}
+}
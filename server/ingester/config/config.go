@@ -37,6 +37,7 @@ var log = logging.MustGetLogger("config")
 const (
 	DefaultLocalIP                  = "127.0.0.1"
 	DefaultControllerPort           = 20035
+	DefaultControllerListenPort     = 20417
 	DefaultCheckInterval            = 180 // clickhouse是异步删除
 	DefaultDiskUsedPercent          = 80
 	DefaultDiskFreeSpace            = 300
@@ -58,6 +59,9 @@ const (
 	DefaultStatsInterval            = 10      // s
 	DefaultFlowTagCacheFlushTimeout = 1800    // s
 	DefaultFlowTagCacheMaxSize      = 1 << 18 // 256k
+	DefaultHistoryMaxVersions       = 20
+	DefaultHistoryMaxAge            = 1800 // s
+	DefaultHistoryMaxMemory         = 256  // MB
 	IndexTypeHash                   = "hash"
 	IndexTypeIncremetalIdLocation   = "incremental-id"
 	FormatHex                       = "hex"
@@ -163,6 +167,15 @@ func (c *CKDB) updateActualAddrs(endpoints []Endpoint) {
 	c.ActualAddrs = &c.actualAddrsValue
 }
 
+// PlatformDataHistory limits the superseded platform data versions kept in memory,
+// they are used to tag the late arriving data with the platform data at that time.
+type PlatformDataHistory struct {
+	Disabled    bool `yaml:"disabled"`
+	MaxVersions int  `yaml:"max-versions"`
+	MaxAge      int  `yaml:"max-age"`    // s
+	MaxMemory   int  `yaml:"max-memory"` // MB
+}
+
 type Config struct {
 	IsRunningModeStandalone  bool
 	StorageDisabled          bool            `yaml:"storage-disabled"`
@@ -178,16 +191,18 @@ type Config struct {
 	CKDiskMonitor            CKDiskMonitor   `yaml:"ck-disk-monitor"`
 	ColdStorage              CKDBColdStorage `yaml:"ckdb-cold-storage"`
 	ckdbColdStorages         map[string]*ckdb.ColdStorage
-	NodeIP                   string `yaml:"node-ip"`
-	GrpcBufferSize           int    `yaml:"grpc-buffer-size"`
-	ServiceLabelerLruCap     int    `yaml:"service-labeler-lru-cap"`
-	StatsInterval            int    `yaml:"stats-interval"`
-	FlowTagCacheFlushTimeout uint32 `yaml:"flow-tag-cache-flush-timeout"`
-	FlowTagCacheMaxSize      uint32 `yaml:"flow-tag-cache-max-size"`
+	NodeIP                   string              `yaml:"node-ip"`
+	GrpcBufferSize           int                 `yaml:"grpc-buffer-size"`
+	ServiceLabelerLruCap     int                 `yaml:"service-labeler-lru-cap"`
+	StatsInterval            int                 `yaml:"stats-interval"`
+	FlowTagCacheFlushTimeout uint32              `yaml:"flow-tag-cache-flush-timeout"`
+	FlowTagCacheMaxSize      uint32              `yaml:"flow-tag-cache-max-size"`
+	PlatformDataHistory      PlatformDataHistory `yaml:"platform-data-history"`
 	LogFile                  string
 	LogLevel                 string
 	MyNodeName               string
 	TraceIdWithIndex         TraceIdWithIndex
+	ControllerListenPort     int // http port of the controller running in the same process
 }
 
 type Location struct {
@@ -208,9 +223,14 @@ type BaseConfig struct {
 	LogFile          string           `yaml:"log-file"`
 	LogLevel         string           `yaml:"log-level"`
 	TraceIdWithIndex TraceIdWithIndex `yaml:"trace-id-with-index"`
+	Controller       ControllerConfig `yaml:"controller"`
 	Base             Config           `yaml:"ingester"`
 }
 
+type ControllerConfig struct {
+	ListenPort int `yaml:"listen-port"`
+}
+
 func sleepAndExit() {
 	time.Sleep(time.Microsecond)
 	os.Exit(1)
@@ -276,6 +296,16 @@ func (c *Config) Validate() error {
 		c.GrpcBufferSize = DefaultGrpcBufferSize
 	}
 
+	if c.PlatformDataHistory.MaxVersions <= 0 {
+		c.PlatformDataHistory.MaxVersions = DefaultHistoryMaxVersions
+	}
+	if c.PlatformDataHistory.MaxAge <= 0 {
+		c.PlatformDataHistory.MaxAge = DefaultHistoryMaxAge
+	}
+	if c.PlatformDataHistory.MaxMemory <= 0 {
+		c.PlatformDataHistory.MaxMemory = DefaultHistoryMaxMemory
+	}
+
 	if c.ServiceLabelerLruCap <= 0 {
 		c.ServiceLabelerLruCap = DefaultServiceLabelerLruCap
 	}
@@ -488,6 +518,9 @@ func Load(path string) *Config {
 	config := BaseConfig{
 		LogFile:  "/var/log/deepflow/server.log",
 		LogLevel: "info",
+		Controller: ControllerConfig{
+			ListenPort: DefaultControllerListenPort,
+		},
 		Base: Config{
 			ControllerIPs:   []string{DefaultLocalIP},
 			ControllerPort:  DefaultControllerPort,
@@ -533,6 +566,11 @@ func Load(path string) *Config {
 			StatsInterval:            DefaultStatsInterval,
 			FlowTagCacheFlushTimeout: DefaultFlowTagCacheFlushTimeout,
 			FlowTagCacheMaxSize:      DefaultFlowTagCacheMaxSize,
+			PlatformDataHistory: PlatformDataHistory{
+				MaxVersions: DefaultHistoryMaxVersions,
+				MaxAge:      DefaultHistoryMaxAge,
+				MaxMemory:   DefaultHistoryMaxMemory,
+			},
 		},
 	}
 	if err != nil {
@@ -551,6 +589,7 @@ func Load(path string) *Config {
 	}
 	config.Base.LogFile = config.LogFile
 	config.Base.LogLevel = config.LogLevel
+	config.Base.ControllerListenPort = config.Controller.ListenPort
 	return &config.Base
 }
 
@@ -201,7 +201,7 @@ func GenAlertEventCKTable(cluster, storagePolicy, ckdbType string, ttl int, cold
 	}
 }
 
-func NewAlertEventWriter(config *config.Config) (*EventWriter, error) {
+func NewAlertEventWriter(decoderIndex int, config *config.Config) (*EventWriter, error) {
 	w := &EventWriter{
 		ckdbAddrs:         config.Base.CKDB.ActualAddrs,
 		ckdbUsername:      config.Base.CKDBAuth.Username,
@@ -213,7 +213,7 @@ func NewAlertEventWriter(config *config.Config) (*EventWriter, error) {
 		writerConfig:      config.CKWriterConfig,
 	}
 
-	flowTagWriter, err := flow_tag.NewFlowTagWriter(0, common.ALERT_EVENT.String(), EVENT_DB, w.ttl, ckdb.TimeFuncTwelveHour, config.Base, &w.writerConfig)
+	flowTagWriter, err := flow_tag.NewFlowTagWriter(decoderIndex, common.ALERT_EVENT.String(), EVENT_DB, w.ttl, ckdb.TimeFuncTwelveHour, config.Base, &w.writerConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -222,7 +222,7 @@ func NewAlertEventWriter(config *config.Config) (*EventWriter, error) {
 	ckTable := GenAlertEventCKTable(w.ckdbCluster, w.ckdbStoragePolicy, config.Base.CKDB.Type, w.ttl, ckdb.GetColdStorage(w.ckdbColdStorages, EVENT_DB, common.ALERT_EVENT.TableName()))
 
 	ckwriter, err := ckwriter.NewCKWriter(*w.ckdbAddrs, w.ckdbUsername, w.ckdbPassword,
-		common.ALERT_EVENT.TableName(), config.Base.CKDB.TimeZone, ckTable, w.writerConfig.QueueCount, w.writerConfig.QueueSize, w.writerConfig.BatchSize, w.writerConfig.FlushTimeout, config.Base.CKDB.Watcher)
+		common.ALERT_EVENT.TableName()+"-"+strconv.Itoa(decoderIndex), config.Base.CKDB.TimeZone, ckTable, w.writerConfig.QueueCount, w.writerConfig.QueueSize, w.writerConfig.BatchSize, w.writerConfig.FlushTimeout, config.Base.CKDB.Watcher)
 	if err != nil {
 		return nil, err
 	}
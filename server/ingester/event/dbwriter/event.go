@@ -138,10 +138,10 @@ func (e *EventStore) DataSource() uint32 {
 
 func (e *EventStore) EncodeTo(protocol config.ExportProtocol, utags *utag.UniversalTagsManager, cfg *config.ExporterCfg) (interface{}, error) {
 	switch protocol {
-	case config.PROTOCOL_KAFKA:
+	case config.PROTOCOL_KAFKA, config.PROTOCOL_PARQUET:
 		tags := e.QueryUniversalTags(utags)
 		k8sLabels := utags.QueryCustomK8sLabels(e.OrgId, e.PodID)
-		return exportercommon.EncodeToJson(e, int(e.DataSource()), cfg, tags, tags, k8sLabels, k8sLabels), nil
+		return exportercommon.EncodeToJsonOrRecord(e, int(e.DataSource()), cfg, tags, tags, k8sLabels, k8sLabels), nil
 	default:
 		return nil, fmt.Errorf("event unsupport export to %s", protocol)
 	}
@@ -141,14 +141,18 @@ func (d *Decoder) Run() {
 				d.handlePerfEvent(recvBytes.VtapID, decoder)
 				receiver.ReleaseRecvBuffer(recvBytes)
 			case common.ALERT_EVENT:
-				recvBytes, ok := buffer[i].(*receiver.RecvBuffer)
-				if !ok {
+				switch v := buffer[i].(type) {
+				case *receiver.RecvBuffer:
+					decoder.Init(v.Buffer[v.Begin:v.End])
+					d.handleAlertEvent(decoder)
+					receiver.ReleaseRecvBuffer(v)
+				case *alert_event.AlertEvent:
+					// generated by the alert evaluator of the controller
+					d.counter.OutCount++
+					d.writeAlertEvent(v)
+				default:
 					log.Warning("get alert event decode queue data type wrong")
-					continue
 				}
-				decoder.Init(recvBytes.Buffer[recvBytes.Begin:recvBytes.End])
-				d.handleAlertEvent(decoder)
-				receiver.ReleaseRecvBuffer(recvBytes)
 			case common.K8S_EVENT:
 				recvBytes, ok := buffer[i].(*receiver.RecvBuffer)
 				if !ok {
@@ -208,10 +212,10 @@ func (d *Decoder) WritePerfEvent(vtapId uint16, e *pb.ProcEvent) {
 				if ip4 := vtapIP.To4(); ip4 != nil {
 					s.IsIPv4 = true
 					s.IP4 = utils.IpToUint32(ip4)
-					info = d.platformData.QueryIPV4Infos(s.OrgId, vtapInfo.EpcId, s.IP4)
+					info = d.platformData.QueryIPV4Infos(s.OrgId, vtapInfo.EpcId, s.IP4, s.Time)
 				} else {
 					s.IP6 = vtapIP
-					info = d.platformData.QueryIPV6Infos(s.OrgId, vtapInfo.EpcId, s.IP6)
+					info = d.platformData.QueryIPV6Infos(s.OrgId, vtapInfo.EpcId, s.IP6, s.Time)
 				}
 			}
 		}
@@ -332,6 +336,11 @@ func (d *Decoder) handleResourceEvent(event *eventapi.ResourceEvent) {
 			strings.Join(event.AttributeIPs, SEPARATOR))
 
 	}
+	if len(event.AttributeMACs) > 0 {
+		s.AttributeNames = append(s.AttributeNames, "macs")
+		s.AttributeValues = append(s.AttributeValues,
+			strings.Join(event.AttributeMACs, SEPARATOR))
+	}
 
 	podGroupType := uint8(0)
 	if event.IfNeedTagged {
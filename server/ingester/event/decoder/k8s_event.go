@@ -74,10 +74,10 @@ func (d *Decoder) WriteK8sEvent(vtapId uint16, e *pb.KubernetesEvent) {
 			if ip4 := vtapIP.To4(); ip4 != nil {
 				s.IsIPv4 = true
 				s.IP4 = utils.IpToUint32(ip4)
-				info = d.platformData.QueryIPV4Infos(s.OrgId, vtapInfo.EpcId, s.IP4)
+				info = d.platformData.QueryIPV4Infos(s.OrgId, vtapInfo.EpcId, s.IP4, s.Time)
 			} else {
 				s.IP6 = vtapIP
-				info = d.platformData.QueryIPV6Infos(s.OrgId, vtapInfo.EpcId, s.IP6)
+				info = d.platformData.QueryIPV6Infos(s.OrgId, vtapInfo.EpcId, s.IP6, s.Time)
 			}
 		}
 	}
@@ -52,7 +52,7 @@ type Eventor struct {
 	PlatformDatas []*grpc.PlatformInfoTable
 }
 
-func NewEvent(config *config.Config, resourceEventQueue *queue.OverwriteQueue, recv *receiver.Receiver, platformDataManager *grpc.PlatformDataManager, exporters *exporters.Exporters) (*Event, error) {
+func NewEvent(config *config.Config, resourceEventQueue, alertEventQueue *queue.OverwriteQueue, recv *receiver.Receiver, platformDataManager *grpc.PlatformDataManager, exporters *exporters.Exporters) (*Event, error) {
 	manager := dropletqueue.NewManager(ingesterctl.INGESTERCTL_EVENT_QUEUE)
 	resourceEventor, err := NewResouceEventor(resourceEventQueue, config, platformDataManager.GetMasterPlatformInfoTable())
 	if err != nil {
@@ -64,7 +64,7 @@ func NewEvent(config *config.Config, resourceEventQueue *queue.OverwriteQueue, r
 		return nil, err
 	}
 
-	alertEventor, err := NewAlertEventor(config, recv, manager, platformDataManager.GetMasterPlatformInfoTable())
+	alertEventor, err := NewAlertEventor(alertEventQueue, config, recv, manager, platformDataManager.GetMasterPlatformInfoTable())
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +103,7 @@ func NewResouceEventor(eventQueue *queue.OverwriteQueue, config *config.Config,
 	}, nil
 }
 
-func NewAlertEventor(config *config.Config, recv *receiver.Receiver, manager *dropletqueue.Manager, platformTable *grpc.PlatformInfoTable) (*Eventor, error) {
+func NewAlertEventor(alertEventQueue *queue.OverwriteQueue, config *config.Config, recv *receiver.Receiver, manager *dropletqueue.Manager, platformTable *grpc.PlatformInfoTable) (*Eventor, error) {
 	eventMsg := datatype.MESSAGE_TYPE_ALERT_EVENT
 	decodeQueues := manager.NewQueues(
 		"1-receive-to-decode-"+eventMsg.String(),
@@ -114,22 +114,28 @@ func NewAlertEventor(config *config.Config, recv *receiver.Receiver, manager *dr
 		libqueue.OptionRelease(func(p interface{}) { receiver.ReleaseRecvBuffer(p.(*receiver.RecvBuffer)) }))
 	recv.RegistHandler(eventMsg, decodeQueues, 1)
 
-	eventWriter, err := dbwriter.NewAlertEventWriter(config)
-	if err != nil {
-		return nil, err
+	// decoder 0 handles the alert events sent by the agents, decoder 1 handles the
+	// alert events generated by the alert evaluator of the controller
+	inQueues := []queue.QueueReader{decodeQueues.FixedMultiQueue[0], alertEventQueue}
+	decoders := make([]*decoder.Decoder, len(inQueues))
+	for i, inQueue := range inQueues {
+		eventWriter, err := dbwriter.NewAlertEventWriter(i, config)
+		if err != nil {
+			return nil, err
+		}
+		decoders[i] = decoder.NewDecoder(
+			i,
+			common.ALERT_EVENT,
+			inQueue,
+			eventWriter,
+			platformTable,
+			nil,
+			config,
+		)
 	}
-	d := decoder.NewDecoder(
-		0,
-		common.ALERT_EVENT,
-		queue.QueueReader(decodeQueues.FixedMultiQueue[0]),
-		eventWriter,
-		platformTable,
-		nil,
-		config,
-	)
 	return &Eventor{
 		Config:   config,
-		Decoders: []*decoder.Decoder{d},
+		Decoders: decoders,
 	}, nil
 }
 
@@ -112,6 +112,95 @@ func writeK8sLabels(sb *strings.Builder, keyName, valueName string, k8sLabels ut
 	sb.WriteString(valuesBuilder.String())
 }
 
+// fieldValue is the value of an exported field after translation
+type fieldValue struct {
+	isString, isFloat64, isStringSlice, isFloat64Slice bool
+
+	valueStr     string // for string and float64 value, float64 value keeps its original string to avoid losing precision
+	valueFloat64 float64
+	stringSlice  []string
+	float64Slice []float64
+}
+
+// ExportFieldName returns the key name of the field when exporting
+func ExportFieldName(structTags *config.StructTags, isMapItem bool, exporterCfg *config.ExporterCfg) string {
+	keyStr := structTags.Name
+	if isMapItem && structTags.MapName != "" {
+		keyStr = structTags.MapName
+	}
+	if structTags.ToStringFuncName == "" && structTags.UniversalTagMapID > 0 && !exporterCfg.UniversalTagTranslateToNameDisabled {
+		// skip '_id'
+		if pos := strings.Index(keyStr, "_id"); pos != -1 {
+			keyStr = (keyStr[:pos]) + keyStr[pos+3:] // 3 is  length of '_id'
+		}
+	}
+	return keyStr
+}
+
+// getFieldValue gets and translates the value of the field, returns false if the field should not be exported
+func getFieldValue(item EncodeItem, structTags *config.StructTags, exporterCfg *config.ExporterCfg, uTags0, uTags1 *utag.UniversalTags) (fieldValue, bool) {
+	var v fieldValue
+	value := item.GetFieldValueByOffsetAndKind(structTags.Offset, structTags.DataKind, structTags.DataType)
+	if utils.IsNil(value) {
+		log.Debugf("%s value is nil", structTags.FieldName)
+		return v, false
+	}
+	if s, ok := value.(string); ok {
+		v.isString = true
+		v.valueStr = s
+	} else if s, ok := value.([]string); ok {
+		v.isStringSlice = true
+		v.stringSlice = s
+	} else if f, ok := value.([]float64); ok {
+		v.isFloat64Slice = true
+		v.float64Slice = f
+	} else if f, fStr, ok := utils.ConvertToFloat64(value); ok {
+		v.isFloat64 = true
+		v.valueFloat64 = f
+		v.valueStr = fStr
+	} else {
+		v.isString = true
+		v.valueStr = fmt.Sprintf("%v", value)
+	}
+
+	if structTags.ToStringFuncName != "" {
+		ret := structTags.ToStringFunc.Call([]reflect.Value{reflect.ValueOf(value)})
+		v.valueStr = ret[0].String()
+		v.isString = true
+	} else if structTags.UniversalTagMapID > 0 && !exporterCfg.UniversalTagTranslateToNameDisabled {
+		if strings.HasSuffix(structTags.Name, "_1") {
+			v.valueStr = uTags1.GetTagValue(structTags.UniversalTagMapID)
+		} else {
+			v.valueStr = uTags0.GetTagValue(structTags.UniversalTagMapID)
+		}
+		v.isString = true
+	} else if structTags.EnumFile != "" && !exporterCfg.EnumTranslateToNameDisabled {
+		if v.isString {
+			v.valueStr = structTags.EnumStringMap[v.valueStr]
+		} else if v.isFloat64 {
+			v.valueStr = structTags.EnumIntMap[int(v.valueFloat64)]
+		}
+		v.isString = true
+	}
+
+	// not export empty tags
+	if !exporterCfg.ExportEmptyTag &&
+		(structTags.CategoryBit&config.TAG) != 0 &&
+		((v.isString && v.valueStr == "") ||
+			(v.isStringSlice && len(v.stringSlice) == 0)) {
+		return v, false
+	}
+
+	// not export empty metrics
+	if exporterCfg.ExportEmptyMetricsDisabled &&
+		(structTags.CategoryBit&config.METRICS) != 0 &&
+		((v.isString && v.valueStr == "") || (v.isFloat64 && v.valueFloat64 == 0) ||
+			(v.isFloat64Slice && len(v.float64Slice) == 0)) {
+		return v, false
+	}
+	return v, true
+}
+
 func EncodeToJson(item EncodeItem, dataSourceId int, exporterCfg *config.ExporterCfg, uTags0, uTags1 *utag.UniversalTags, k8sLabels0, k8sLabels1 utag.Labels) string {
 	var sb = &strings.Builder{}
 	sb.WriteString("{\"datasource\":\"")
@@ -124,110 +213,42 @@ func EncodeToJson(item EncodeItem, dataSourceId int, exporterCfg *config.Exporte
 	}
 
 	isMapItem := config.DataSourceID(dataSourceId).IsMap()
-	var isString, isFloat64, isStringSlice, isFloat64Slice bool
-	var keyStr, valueStr string
-	var valueFloat64 float64
-	var stringSlice []string
-	var float64Slice []float64
-	for _, structTags := range exporterCfg.ExportFieldStructTags[dataSourceId] {
-		isString, isFloat64, isStringSlice, isFloat64Slice = false, false, false, false
-		value := item.GetFieldValueByOffsetAndKind(structTags.Offset, structTags.DataKind, structTags.DataType)
-		if utils.IsNil(value) {
-			log.Debugf("%s value is nil", structTags.FieldName)
-			continue
-		}
-		if isMapItem && structTags.MapName != "" {
-			keyStr = structTags.MapName
-		} else {
-			keyStr = structTags.Name
-		}
-		if v, ok := value.(string); ok {
-			isString = true
-			valueStr = v
-		} else if v, ok := value.([]string); ok {
-			isStringSlice = true
-			stringSlice = v
-		} else if v, ok := value.([]float64); ok {
-			isFloat64Slice = true
-			float64Slice = v
-		} else if v, vStr, ok := utils.ConvertToFloat64(value); ok {
-			isFloat64 = true
-			valueFloat64 = v
-			valueStr = vStr
-		} else {
-			isString = true
-			valueStr = fmt.Sprintf("%v", value)
-		}
-
-		if structTags.ToStringFuncName != "" {
-			ret := structTags.ToStringFunc.Call([]reflect.Value{reflect.ValueOf(value)})
-			valueStr = ret[0].String()
-			isString = true
-		} else if structTags.UniversalTagMapID > 0 && !exporterCfg.UniversalTagTranslateToNameDisabled {
-			// skip '_id'
-			if pos := strings.Index(keyStr, "_id"); pos != -1 {
-				keyStr = (keyStr[:pos]) + keyStr[pos+3:] // 3 is  length of '_id'
-			}
-			if strings.HasSuffix(structTags.Name, "_1") {
-				valueStr = uTags1.GetTagValue(structTags.UniversalTagMapID)
-			} else {
-				valueStr = uTags0.GetTagValue(structTags.UniversalTagMapID)
-			}
-			isString = true
-		} else if structTags.EnumFile != "" && !exporterCfg.EnumTranslateToNameDisabled {
-			if isString {
-				valueStr = structTags.EnumStringMap[valueStr]
-			} else if isFloat64 {
-				valueStr = structTags.EnumIntMap[int(valueFloat64)]
-			}
-			isString = true
-		}
-
-		// not export empty tags
-		if !exporterCfg.ExportEmptyTag &&
-			(structTags.CategoryBit&config.TAG) != 0 &&
-			((isString && valueStr == "") ||
-				(isStringSlice && len(stringSlice) == 0)) {
-			continue
-		}
-
-		// not export empty metrics
-		if exporterCfg.ExportEmptyMetricsDisabled &&
-			(structTags.CategoryBit&config.METRICS) != 0 &&
-			((isString && valueStr == "") || (isFloat64 && valueFloat64 == 0) ||
-				(isFloat64Slice && len(float64Slice) == 0)) {
+	for i := range exporterCfg.ExportFieldStructTags[dataSourceId] {
+		structTags := &exporterCfg.ExportFieldStructTags[dataSourceId][i]
+		v, ok := getFieldValue(item, structTags, exporterCfg, uTags0, uTags1)
+		if !ok {
 			continue
 		}
 
 		sb.WriteString(`,"`)
-		sb.WriteString(keyStr)
+		sb.WriteString(ExportFieldName(structTags, isMapItem, exporterCfg))
 		sb.WriteString(`":`)
-		if isString {
+		if v.isString {
 			sb.WriteString(`"`)
-			utils.EscapeJsonStringToStringBuilder(sb, valueStr)
+			utils.EscapeJsonStringToStringBuilder(sb, v.valueStr)
 			sb.WriteString(`"`)
-		} else if isStringSlice {
+		} else if v.isStringSlice {
 			sb.WriteString("[")
-			for i, v := range stringSlice {
+			for i, s := range v.stringSlice {
 				if i != 0 {
 					sb.WriteString(`,`)
 				}
 				sb.WriteString(`"`)
-				sb.WriteString(v)
+				sb.WriteString(s)
 				sb.WriteString(`"`)
 			}
 			sb.WriteString("]")
-		} else if isFloat64Slice {
+		} else if v.isFloat64Slice {
 			sb.WriteString("[")
-			for i, v := range float64Slice {
+			for i, f := range v.float64Slice {
 				if i != 0 {
 					sb.WriteString(`,`)
 				}
-				sb.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+				sb.WriteString(strconv.FormatFloat(f, 'f', -1, 64))
 			}
 			sb.WriteString("]")
-		} else if isFloat64 {
-			sb.WriteString(valueStr)
+		} else if v.isFloat64 {
+			sb.WriteString(v.valueStr)
 		} else {
 			log.Warningf("unreachable")
 		}
@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"reflect"
+	"strconv"
+
+	"github.com/deepflowio/deepflow/server/ingester/exporters/config"
+	utag "github.com/deepflowio/deepflow/server/ingester/exporters/universal_tag"
+	"github.com/deepflowio/deepflow/server/libs/utils"
+)
+
+type FieldType uint8
+
+const (
+	FIELD_TYPE_STRING FieldType = iota
+	FIELD_TYPE_INT64
+	FIELD_TYPE_UINT64
+	FIELD_TYPE_FLOAT64
+	FIELD_TYPE_BOOL
+	FIELD_TYPE_STRING_ARRAY
+	FIELD_TYPE_FLOAT64_ARRAY
+	FIELD_TYPE_TIMESTAMP_US // int64, unix timestamp in microseconds
+)
+
+const (
+	FIELD_DATASOURCE   = "datasource"
+	FIELD_TIMESTAMP_US = "timestamp_us"
+)
+
+// ExportFieldType returns the type of the field after translation, which is the same for all items of the data source
+func ExportFieldType(structTags *config.StructTags, exporterCfg *config.ExporterCfg) FieldType {
+	if structTags.ToStringFuncName != "" ||
+		(structTags.UniversalTagMapID > 0 && !exporterCfg.UniversalTagTranslateToNameDisabled) ||
+		(structTags.EnumFile != "" && !exporterCfg.EnumTranslateToNameDisabled) {
+		return FIELD_TYPE_STRING
+	}
+	switch structTags.DataKind {
+	case reflect.Bool:
+		return FIELD_TYPE_BOOL
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return FIELD_TYPE_INT64
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return FIELD_TYPE_UINT64
+	case reflect.Float32, reflect.Float64:
+		return FIELD_TYPE_FLOAT64
+	case reflect.Pointer:
+		switch structTags.DataType {
+		case utils.DATATYPE_Int8Ptr, utils.DATATYPE_Int16Ptr, utils.DATATYPE_Int32Ptr, utils.DATATYPE_Int64Ptr:
+			return FIELD_TYPE_INT64
+		case utils.DATATYPE_Uint8Ptr, utils.DATATYPE_Uint16Ptr, utils.DATATYPE_Uint32Ptr, utils.DATATYPE_Uint64Ptr:
+			return FIELD_TYPE_UINT64
+		}
+	case reflect.Slice:
+		switch structTags.DataType {
+		case utils.DATATYPE_StringSlice:
+			return FIELD_TYPE_STRING_ARRAY
+		case utils.DATATYPE_Float64Slice:
+			return FIELD_TYPE_FLOAT64_ARRAY
+		}
+	}
+	return FIELD_TYPE_STRING
+}
+
+// Record is the item encoded for the schema-based encodings. Values are laid out as:
+//
+//	datasource, export fields..., k8s label names/values of side 0, k8s label names/values of side 1, timestamp
+//
+// a nil value means the field is not exported
+type Record struct {
+	DataSourceID int
+	Values       []interface{}
+}
+
+func recordValueCount(fieldCount int) int {
+	return fieldCount + 6
+}
+
+type SchemaField struct {
+	Name       string
+	Type       FieldType
+	ValueIndex int // index of Record.Values
+}
+
+// Schema describes the records of a data source, it is the same for all items of the data source,
+// so all fields are optional
+type Schema struct {
+	DataSourceID int
+	Name         string // name of the data source, such as 'flow_log.l7_flow_log'
+	Fields       []SchemaField
+}
+
+// NewSchema builds the schema from the exported fields, it should be called after the struct tags of the data source initialized
+func NewSchema(dataSourceId int, exporterCfg *config.ExporterCfg) *Schema {
+	dataSource := config.DataSourceID(dataSourceId)
+	structTags := exporterCfg.ExportFieldStructTags[dataSourceId]
+	schema := &Schema{
+		DataSourceID: dataSourceId,
+		Name:         dataSource.String(),
+		Fields:       make([]SchemaField, 0, recordValueCount(len(structTags))),
+	}
+	names := make(map[string]bool, cap(schema.Fields))
+	addField := func(name string, fieldType FieldType, valueIndex int) {
+		// the same name may be generated after removing '_id' of the universal tags, keep the first one as json does
+		if names[name] {
+			return
+		}
+		names[name] = true
+		schema.Fields = append(schema.Fields, SchemaField{Name: name, Type: fieldType, ValueIndex: valueIndex})
+	}
+
+	addField(FIELD_DATASOURCE, FIELD_TYPE_STRING, 0)
+	isMapItem := dataSource.IsMap()
+	for i := range structTags {
+		addField(ExportFieldName(&structTags[i], isMapItem, exporterCfg), ExportFieldType(&structTags[i], exporterCfg), i+1)
+	}
+	n := len(structTags) + 1
+	if isMapItem {
+		addField("k8s_label_names_0", FIELD_TYPE_STRING_ARRAY, n)
+		addField("k8s_label_values_0", FIELD_TYPE_STRING_ARRAY, n+1)
+		addField("k8s_label_names_1", FIELD_TYPE_STRING_ARRAY, n+2)
+		addField("k8s_label_values_1", FIELD_TYPE_STRING_ARRAY, n+3)
+	} else {
+		addField("k8s_label_names", FIELD_TYPE_STRING_ARRAY, n)
+		addField("k8s_label_values", FIELD_TYPE_STRING_ARRAY, n+1)
+	}
+	addField(FIELD_TIMESTAMP_US, FIELD_TYPE_TIMESTAMP_US, n+4)
+	return schema
+}
+
+func (v *fieldValue) typedValue(fieldType FieldType) interface{} {
+	switch fieldType {
+	case FIELD_TYPE_STRING:
+		if v.isString || v.isFloat64 {
+			return v.valueStr
+		}
+	case FIELD_TYPE_INT64:
+		if i, err := strconv.ParseInt(v.valueStr, 10, 64); err == nil {
+			return i
+		} else if v.isFloat64 {
+			return int64(v.valueFloat64)
+		}
+	case FIELD_TYPE_UINT64:
+		if i, err := strconv.ParseUint(v.valueStr, 10, 64); err == nil {
+			return i
+		} else if v.isFloat64 {
+			return uint64(v.valueFloat64)
+		}
+	case FIELD_TYPE_FLOAT64:
+		if v.isFloat64 {
+			return v.valueFloat64
+		} else if f, err := strconv.ParseFloat(v.valueStr, 64); err == nil {
+			return f
+		}
+	case FIELD_TYPE_BOOL:
+		if v.isFloat64 {
+			return v.valueFloat64 != 0
+		}
+	case FIELD_TYPE_STRING_ARRAY:
+		if v.isStringSlice {
+			return v.stringSlice
+		}
+	case FIELD_TYPE_FLOAT64_ARRAY:
+		if v.isFloat64Slice {
+			return v.float64Slice
+		}
+	}
+	return nil
+}
+
+func k8sLabelValues(k8sLabels utag.Labels) ([]string, []string) {
+	if len(k8sLabels) == 0 {
+		return nil, nil
+	}
+	names, values := make([]string, 0, len(k8sLabels)), make([]string, 0, len(k8sLabels))
+	for name, value := range k8sLabels {
+		names = append(names, name)
+		values = append(values, value)
+	}
+	return names, values
+}
+
+// EncodeToRecord translates the fields as EncodeToJson does, and keeps the values typed
+func EncodeToRecord(item EncodeItem, dataSourceId int, exporterCfg *config.ExporterCfg, uTags0, uTags1 *utag.UniversalTags, k8sLabels0, k8sLabels1 utag.Labels) *Record {
+	if dataSourceId >= int(config.MAX_DATASOURCE_ID) {
+		log.Errorf("export datasource wrong: datasourceid %d ", dataSourceId)
+		return nil
+	}
+	structTags := exporterCfg.ExportFieldStructTags[dataSourceId]
+	record := &Record{
+		DataSourceID: dataSourceId,
+		Values:       make([]interface{}, recordValueCount(len(structTags))),
+	}
+	record.Values[0] = config.DataSourceID(dataSourceId).String()
+	for i := range structTags {
+		v, ok := getFieldValue(item, &structTags[i], exporterCfg, uTags0, uTags1)
+		if !ok {
+			continue
+		}
+		if value := v.typedValue(ExportFieldType(&structTags[i], exporterCfg)); value != nil {
+			record.Values[i+1] = value
+		}
+	}
+	n := len(structTags) + 1
+	if names, values := k8sLabelValues(k8sLabels0); len(names) > 0 {
+		record.Values[n], record.Values[n+1] = names, values
+	}
+	if config.DataSourceID(dataSourceId).IsMap() {
+		if names, values := k8sLabelValues(k8sLabels1); len(names) > 0 {
+			record.Values[n+2], record.Values[n+3] = names, values
+		}
+	}
+	record.Values[n+4] = item.TimestampUs()
+	return record
+}
+
+// EncodeToJsonOrRecord encodes the item to *Record for the parquet exporter and the schema-based encodings of
+// the kafka exporter, otherwise to json string
+func EncodeToJsonOrRecord(item EncodeItem, dataSourceId int, exporterCfg *config.ExporterCfg, uTags0, uTags1 *utag.UniversalTags, k8sLabels0, k8sLabels1 utag.Labels) interface{} {
+	if exporterCfg.ExportProtocol == config.PROTOCOL_PARQUET ||
+		exporterCfg.Encoding == config.ENCODING_PROTOBUF || exporterCfg.Encoding == config.ENCODING_AVRO {
+		return EncodeToRecord(item, dataSourceId, exporterCfg, uTags0, uTags1, k8sLabels0, k8sLabels1)
+	}
+	return EncodeToJson(item, dataSourceId, exporterCfg, uTags0, uTags1, k8sLabels0, k8sLabels1)
+}
@@ -17,10 +17,13 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -45,6 +48,37 @@ const (
 	DefaultExportOtherBatchSize = 1024
 	SecurityProtocol            = "SASL_SSL"
 
+	DefaultRetryInitialInterval = 1  // second
+	DefaultRetryMaxInterval     = 30 // second
+	DefaultRetryMaxElapsedTime  = 60 // second
+
+	OTLP_PROTOCOL_GRPC          = "grpc"
+	OTLP_PROTOCOL_HTTP_PROTOBUF = "http/protobuf"
+	OTLP_PROTOCOL_HTTP_JSON     = "http/json"
+
+	COMPRESSION_NONE     = ""
+	COMPRESSION_DISABLED = "none"
+	COMPRESSION_GZIP     = "gzip"
+	COMPRESSION_SNAPPY   = "snappy"
+	COMPRESSION_ZSTD     = "zstd"
+
+	DefaultRetryQueueDirectory       = "/var/lib/deepflow/exporters/retry-queue"
+	DefaultRetryQueueMaxSize         = 1024 // MB
+	DefaultRetryQueueMaxAttempts     = 10
+	DefaultRetryQueueInitialInterval = 1   // second
+	DefaultRetryQueueMaxInterval     = 300 // second
+
+	DefaultParquetMaxFileSize     = 128 // MB
+	DefaultParquetMaxFileDuration = 300 // second
+
+	ENCODING_JSON     = "json"
+	ENCODING_PROTOBUF = "protobuf"
+	ENCODING_AVRO     = "avro"
+
+	SUBJECT_NAME_STRATEGY_TOPIC        = "topic"
+	SUBJECT_NAME_STRATEGY_RECORD       = "record"
+	SUBJECT_NAME_STRATEGY_TOPIC_RECORD = "topic-record"
+
 	CATEGORY_K8S_LABEL = "$k8s.label"
 	CATEGORY_TAG       = "$tag"
 	CATEGORY_METRICS   = "$metrics"
@@ -476,15 +510,224 @@ type ExporterCfg struct {
 	// private configuration
 	ExtraHeaders map[string]string `yaml:"extra-headers"`
 
+	// otlp, prometheus and kafka configuration
+	RetryQueue RetryQueueConfig `yaml:"retry-queue"`
+
+	// otlp private configuration
+	OtlpProtocol    string                       `yaml:"otlp-protocol"` // 'grpc', 'http/protobuf' or 'http/json'
+	EndpointHeaders map[string]map[string]string `yaml:"endpoint-headers"`
+	Retry           RetryConfig                  `yaml:"retry"`
+
+	// otlp and kafka private configuration
+	TLS         TLSConfig `yaml:"tls"`
+	Compression string    `yaml:"compression"`
+
 	// kafka private configuration
-	Sasl  Sasl   `yaml:"sasl"`
-	Topic string `yaml:"topic"`
+	Sasl                   Sasl                           `yaml:"sasl"`
+	Topic                  string                         `yaml:"topic"`
+	Encoding               string                         `yaml:"encoding"` // 'json', 'protobuf' or 'avro'
+	SchemaRegistry         SchemaRegistry                 `yaml:"schema-registry"`
+	PartitionKey           string                         `yaml:"partition-key"` // field name, such as 'flow_id', 'trace_id'
+	PartitionKeyStructTags [MAX_DATASOURCE_ID]*StructTags // gen by `PartitionKey` and init when exporting item first time
+
+	// parquet private configuration
+	Parquet         ParquetConfig                  `yaml:"parquet"`
+	OrgIDStructTags [MAX_DATASOURCE_ID]*StructTags // gen by the field 'org_id' and init when exporting item first time
+}
+
+// ParquetConfig writes the parquet files to the local directory, or to the S3-compatible bucket if 's3.bucket' is set
+type ParquetConfig struct {
+	Directory       string   `yaml:"directory"`
+	S3              S3Config `yaml:"s3"`
+	MaxFileSize     int      `yaml:"max-file-size"`     // MB, roll the file when the buffered size exceeds it
+	MaxFileDuration int      `yaml:"max-file-duration"` // second, roll the file when it has been opened longer than it
+}
+
+type S3Config struct {
+	Endpoint       string `yaml:"endpoint"` // empty for AWS S3
+	Region         string `yaml:"region"`
+	Bucket         string `yaml:"bucket"`
+	Prefix         string `yaml:"prefix"`
+	AccessKey      string `yaml:"access-key"`
+	SecretKey      string `yaml:"secret-key"`
+	ForcePathStyle bool   `yaml:"force-path-style"` // required by most S3-compatible storages, such as minio
+}
+
+func (p *ParquetConfig) Validate() error {
+	if p.S3.Bucket == "" && p.Directory == "" {
+		return fmt.Errorf("'parquet.directory' or 'parquet.s3.bucket' should be configured")
+	}
+	if p.MaxFileSize <= 0 {
+		p.MaxFileSize = DefaultParquetMaxFileSize
+	}
+	if p.MaxFileDuration <= 0 {
+		p.MaxFileDuration = DefaultParquetMaxFileDuration
+	}
+	return nil
+}
+
+// SchemaRegistry is the confluent schema registry, where the schemas of protobuf/avro encoding are registered
+type SchemaRegistry struct {
+	URL                 string    `yaml:"url"`
+	Username            string    `yaml:"username"`
+	Password            string    `yaml:"password"`
+	SubjectNameStrategy string    `yaml:"subject-name-strategy"` // 'topic', 'record' or 'topic-record'
+	TLS                 TLSConfig `yaml:"tls"`
+}
+
+func (r *SchemaRegistry) Validate(encoding string) error {
+	if encoding != ENCODING_PROTOBUF && encoding != ENCODING_AVRO {
+		return nil
+	}
+	if r.URL == "" {
+		return fmt.Errorf("'schema-registry.url' should be configured when the encoding is %s", encoding)
+	}
+	switch r.SubjectNameStrategy {
+	case "":
+		r.SubjectNameStrategy = SUBJECT_NAME_STRATEGY_TOPIC
+	case SUBJECT_NAME_STRATEGY_TOPIC, SUBJECT_NAME_STRATEGY_RECORD, SUBJECT_NAME_STRATEGY_TOPIC_RECORD:
+	default:
+		return fmt.Errorf("invalid subject-name-strategy %s, should be one of: %s, %s, %s", r.SubjectNameStrategy,
+			SUBJECT_NAME_STRATEGY_TOPIC, SUBJECT_NAME_STRATEGY_RECORD, SUBJECT_NAME_STRATEGY_TOPIC_RECORD)
+	}
+	return r.TLS.Validate()
+}
+
+// Headers returns the request headers of the endpoint, 'endpoint-headers' of the endpoint overrides 'extra-headers'
+func (cfg *ExporterCfg) Headers(endpoint string) map[string]string {
+	endpointHeaders := cfg.EndpointHeaders[endpoint]
+	if len(endpointHeaders) == 0 {
+		return cfg.ExtraHeaders
+	}
+	headers := make(map[string]string, len(cfg.ExtraHeaders)+len(endpointHeaders))
+	for k, v := range cfg.ExtraHeaders {
+		headers[k] = v
+	}
+	for k, v := range endpointHeaders {
+		headers[k] = v
+	}
+	return headers
+}
+
+type TLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca-file"`   // verify the server with the CA, use the system CAs if empty
+	CertFile           string `yaml:"cert-file"` // client certificate for mTLS
+	KeyFile            string `yaml:"key-file"`
+	ServerName         string `yaml:"server-name"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify"`
+}
+
+func (t *TLSConfig) Validate() error {
+	if !t.Enabled {
+		return nil
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("'cert-file' and 'key-file' of tls should be configured together")
+	}
+	return nil
+}
+
+// Load builds the tls.Config from the CA, certificate and key files
+func (t *TLSConfig) Load() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		ca, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file %s failed: %s", t.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no valid certificate in ca file %s", t.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load certificate %s and key %s failed: %s", t.CertFile, t.KeyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// RetryConfig is the exponential backoff of the retryable failures, the server may
+// override the interval by 'RetryInfo' (grpc) or 'Retry-After' (http)
+type RetryConfig struct {
+	Disabled        bool `yaml:"disabled"`
+	InitialInterval int  `yaml:"initial-interval"` // second
+	MaxInterval     int  `yaml:"max-interval"`     // second
+	MaxElapsedTime  int  `yaml:"max-elapsed-time"` // second, give up and drop the batch after it
+}
+
+func (r *RetryConfig) Validate() {
+	if r.InitialInterval <= 0 {
+		r.InitialInterval = DefaultRetryInitialInterval
+	}
+	if r.MaxInterval <= 0 {
+		r.MaxInterval = DefaultRetryMaxInterval
+	}
+	if r.MaxInterval < r.InitialInterval {
+		r.MaxInterval = r.InitialInterval
+	}
+	if r.MaxElapsedTime <= 0 {
+		r.MaxElapsedTime = DefaultRetryMaxElapsedTime
+	}
+}
+
+// RetryQueueConfig enables the at-least-once delivery, the failed batches are written to the disk queue
+// and retried with exponential backoff, and the exporter blocks the putting instead of overwriting when
+// its queues are full. The batches failed permanently or more than 'max-attempts' times are moved to the
+// dead-letter directory.
+type RetryQueueConfig struct {
+	Enabled             bool   `yaml:"enabled"`
+	Directory           string `yaml:"directory"`
+	MaxSize             int    `yaml:"max-size"`         // MB, the new failed batches are dropped when exceeded
+	MaxAttempts         int    `yaml:"max-attempts"`     // negative means retrying until the batch is sent or poisoned
+	InitialInterval     int    `yaml:"initial-interval"` // second
+	MaxInterval         int    `yaml:"max-interval"`     // second
+	DeadLetterDirectory string `yaml:"dead-letter-directory"`
+	DeadLetterMaxSize   int    `yaml:"dead-letter-max-size"` // MB, the poisoned batches are dropped when exceeded
+}
+
+func (r *RetryQueueConfig) Validate() {
+	if !r.Enabled {
+		return
+	}
+	if r.Directory == "" {
+		r.Directory = DefaultRetryQueueDirectory
+	}
+	if r.DeadLetterDirectory == "" {
+		r.DeadLetterDirectory = filepath.Join(r.Directory, "dead-letter")
+	}
+	if r.MaxSize <= 0 {
+		r.MaxSize = DefaultRetryQueueMaxSize
+	}
+	if r.DeadLetterMaxSize <= 0 {
+		r.DeadLetterMaxSize = r.MaxSize
+	}
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = DefaultRetryQueueMaxAttempts
+	}
+	if r.InitialInterval <= 0 {
+		r.InitialInterval = DefaultRetryQueueInitialInterval
+	}
+	if r.MaxInterval <= 0 {
+		r.MaxInterval = DefaultRetryQueueMaxInterval
+	}
+	if r.MaxInterval < r.InitialInterval {
+		r.MaxInterval = r.InitialInterval
+	}
 }
 
 type Sasl struct {
 	Enabled          bool   `yaml:"enabled"`
 	SecurityProtocol string `yaml:"security-protocol"` // only support 'SASL_SSL'
-	Mechanism        string `yaml:"sasl-mechanism"`    // only support 'PLAIN'
+	Mechanism        string `yaml:"sasl-mechanism"`    // support 'PLAIN', 'SCRAM-SHA-256' and 'SCRAM-SHA-512'
 	Username         string `yaml:"username"`
 	Password         string `yaml:"password"`
 }
@@ -496,8 +739,13 @@ func (s *Sasl) Validate() error {
 	if s.SecurityProtocol != SecurityProtocol {
 		log.Warningf("'sasl-protocol' only support value %s", SecurityProtocol)
 	}
-	if s.Mechanism != sarama.SASLTypePlaintext {
-		log.Warningf("'sasl-mechanism' only support value %s", sarama.SASLTypePlaintext)
+	switch s.Mechanism {
+	case "":
+		s.Mechanism = sarama.SASLTypePlaintext
+	case sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+	default:
+		return fmt.Errorf("invalid sasl-mechanism %s, should be one of: %s, %s, %s", s.Mechanism,
+			sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512)
 	}
 	return nil
 }
@@ -508,6 +756,7 @@ const (
 	PROTOCOL_OTLP ExportProtocol = iota
 	PROTOCOL_PROMETHEUS
 	PROTOCOL_KAFKA
+	PROTOCOL_PARQUET
 
 	MAX_PROTOCOL_ID
 )
@@ -516,6 +765,7 @@ var protocolToStrings = []string{
 	PROTOCOL_OTLP:       "opentelemetry",
 	PROTOCOL_PROMETHEUS: "prometheus",
 	PROTOCOL_KAFKA:      "kafka",
+	PROTOCOL_PARQUET:    "parquet",
 	MAX_PROTOCOL_ID:     "unknown",
 }
 
@@ -570,7 +820,67 @@ func (cfg *ExporterCfg) Validate() error {
 	}
 
 	cfg.TagFilterCondition.Validate()
-	cfg.Sasl.Validate()
+	if err := cfg.Sasl.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.TLS.Validate(); err != nil {
+		return err
+	}
+	cfg.Retry.Validate()
+	switch cfg.ExportProtocol {
+	case PROTOCOL_OTLP, PROTOCOL_PROMETHEUS, PROTOCOL_KAFKA:
+		cfg.RetryQueue.Validate()
+	default:
+		if cfg.RetryQueue.Enabled {
+			log.Warningf("retry-queue is not supported by the %s exporter", cfg.Protocol)
+			cfg.RetryQueue.Enabled = false
+		}
+	}
+
+	if cfg.ExportProtocol == PROTOCOL_OTLP {
+		switch cfg.OtlpProtocol {
+		case "":
+			cfg.OtlpProtocol = OTLP_PROTOCOL_GRPC
+		case OTLP_PROTOCOL_GRPC, OTLP_PROTOCOL_HTTP_PROTOBUF, OTLP_PROTOCOL_HTTP_JSON:
+		default:
+			return fmt.Errorf("invalid otlp-protocol %s, should be one of: %s, %s, %s", cfg.OtlpProtocol,
+				OTLP_PROTOCOL_GRPC, OTLP_PROTOCOL_HTTP_PROTOBUF, OTLP_PROTOCOL_HTTP_JSON)
+		}
+		if cfg.Compression != COMPRESSION_NONE && cfg.Compression != COMPRESSION_GZIP {
+			return fmt.Errorf("invalid compression %s of otlp exporter, only support %s", cfg.Compression, COMPRESSION_GZIP)
+		}
+	} else if cfg.ExportProtocol == PROTOCOL_KAFKA {
+		if cfg.Compression == COMPRESSION_NONE {
+			cfg.Compression = COMPRESSION_SNAPPY
+		}
+		var codec sarama.CompressionCodec
+		if err := codec.UnmarshalText([]byte(cfg.Compression)); err != nil {
+			return fmt.Errorf("invalid compression of kafka exporter: %s", err)
+		}
+		switch cfg.Encoding {
+		case "":
+			cfg.Encoding = ENCODING_JSON
+		case ENCODING_JSON, ENCODING_PROTOBUF, ENCODING_AVRO:
+		default:
+			return fmt.Errorf("invalid encoding %s of kafka exporter, should be one of: %s, %s, %s", cfg.Encoding,
+				ENCODING_JSON, ENCODING_PROTOBUF, ENCODING_AVRO)
+		}
+		if err := cfg.SchemaRegistry.Validate(cfg.Encoding); err != nil {
+			return err
+		}
+	} else if cfg.ExportProtocol == PROTOCOL_PARQUET {
+		switch cfg.Compression {
+		case COMPRESSION_NONE:
+			cfg.Compression = COMPRESSION_SNAPPY
+		case COMPRESSION_DISABLED, COMPRESSION_GZIP, COMPRESSION_SNAPPY, COMPRESSION_ZSTD:
+		default:
+			return fmt.Errorf("invalid compression %s of parquet exporter, should be one of: %s, %s, %s, %s", cfg.Compression,
+				COMPRESSION_DISABLED, COMPRESSION_GZIP, COMPRESSION_SNAPPY, COMPRESSION_ZSTD)
+		}
+		if err := cfg.Parquet.Validate(); err != nil {
+			return err
+		}
+	}
 
 	for i := range cfg.TagFiltersGroups {
 		cfg.TagFiltersGroups[i].Validate()
@@ -27,6 +27,7 @@ import (
 	"github.com/deepflowio/deepflow/server/ingester/exporters/enum_translation"
 	"github.com/deepflowio/deepflow/server/ingester/exporters/kafka_exporter"
 	"github.com/deepflowio/deepflow/server/ingester/exporters/otlp_exporter"
+	"github.com/deepflowio/deepflow/server/ingester/exporters/parquet_exporter"
 	"github.com/deepflowio/deepflow/server/ingester/exporters/prometheus_exporter"
 	"github.com/deepflowio/deepflow/server/ingester/exporters/universal_tag"
 	"github.com/deepflowio/deepflow/server/libs/queue"
@@ -97,6 +98,12 @@ func NewExporters(cfg *config.Config) *Exporters {
 			exporter = prometheus_exporter.NewPrometheusExporter(i, &cfg.Exporters[i], universalTagManager)
 		case config.PROTOCOL_KAFKA:
 			exporter = kafka_exporter.NewKafkaExporter(i, &cfg.Exporters[i], universalTagManager)
+		case config.PROTOCOL_PARQUET:
+			// avoid the typed nil interface if the storage failed to init
+			exporter = nil
+			if parquetExporter := parquet_exporter.NewParquetExporter(i, &cfg.Exporters[i], universalTagManager); parquetExporter != nil {
+				exporter = parquetExporter
+			}
 		default:
 			exporter = nil
 			log.Warningf("unsupport export protocol %s", exporterCfg.Protocol)
@@ -323,6 +330,18 @@ func (es *Exporters) initStructTags(item interface{}, dataSourceId uint32, expor
 			if structTag.IsExportedField {
 				exportFieldStructTags = append(exportFieldStructTags, structTag)
 			}
+			if exporterCfg.PartitionKey != "" && exporterCfg.PartitionKeyStructTags[dataSourceId] == nil &&
+				(structTag.Name == exporterCfg.PartitionKey || structTag.MapName == exporterCfg.PartitionKey) {
+				partitionKeyStructTag := structTag
+				exporterCfg.PartitionKeyStructTags[dataSourceId] = &partitionKeyStructTag
+			}
+			if structTag.Name == "org_id" && exporterCfg.OrgIDStructTags[dataSourceId] == nil {
+				orgIDStructTag := structTag
+				exporterCfg.OrgIDStructTags[dataSourceId] = &orgIDStructTag
+			}
+		}
+		if exporterCfg.PartitionKey != "" && exporterCfg.PartitionKeyStructTags[dataSourceId] == nil {
+			log.Warningf("export protocol %s datasource %s, partition key %s is not found", exporterCfg.Protocol, config.DataSourceID(dataSourceId).String(), exporterCfg.PartitionKey)
 		}
 		exporterCfg.TagFiltersStructTags[dataSourceId] = tagFiltersStructTags
 		exporterCfg.TagFiltersGroupsStructTags[dataSourceId] = tagFiltersGroupsStructTags
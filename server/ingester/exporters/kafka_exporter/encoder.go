@@ -0,0 +1,290 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka_exporter
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/deepflowio/deepflow/server/ingester/exporters/common"
+	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+)
+
+const SCHEMA_NAMESPACE_PREFIX = "deepflow"
+
+// recordEncoder encodes the records by the schema of the data source
+type recordEncoder interface {
+	// schemaType is the type registered to the schema registry
+	schemaType() string
+	// schema returns the full name and the definition of the schema
+	schema(s *common.Schema) (string, string)
+	// encode appends the record encoded after the wire format header
+	encode(buf []byte, s *common.Schema, r *common.Record) []byte
+}
+
+func newRecordEncoder(encoding string) recordEncoder {
+	switch encoding {
+	case exporters_cfg.ENCODING_PROTOBUF:
+		return &protobufEncoder{}
+	case exporters_cfg.ENCODING_AVRO:
+		return &avroEncoder{}
+	}
+	return nil
+}
+
+// splitSchemaName splits the data source name into namespace and name,
+// eg: 'flow_metrics.application_map.1s' -> 'deepflow.flow_metrics', 'application_map_1s'
+func splitSchemaName(dataSource string) (string, string) {
+	namespace, name := "", dataSource
+	if pos := strings.Index(dataSource, "."); pos != -1 {
+		namespace, name = dataSource[:pos], dataSource[pos+1:]
+	}
+	return SCHEMA_NAMESPACE_PREFIX + "." + sanitizeName(namespace), sanitizeName(name)
+}
+
+// sanitizeName makes the name valid for both avro and protobuf: [A-Za-z_][A-Za-z0-9_]*
+func sanitizeName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// camelName converts 'l7_flow_log' to 'L7FlowLog', as the name of the protobuf message
+func camelName(name string) string {
+	sb := strings.Builder{}
+	upper := true
+	for _, c := range name {
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		sb.WriteRune(c)
+	}
+	camel := sb.String()
+	if camel == "" || camel[0] >= '0' && camel[0] <= '9' {
+		return "M" + camel
+	}
+	return camel
+}
+
+type avroEncoder struct{}
+
+func (e *avroEncoder) schemaType() string {
+	return "AVRO"
+}
+
+func avroType(fieldType common.FieldType) interface{} {
+	switch fieldType {
+	case common.FIELD_TYPE_INT64, common.FIELD_TYPE_UINT64:
+		return "long"
+	case common.FIELD_TYPE_FLOAT64:
+		return "double"
+	case common.FIELD_TYPE_BOOL:
+		return "boolean"
+	case common.FIELD_TYPE_STRING_ARRAY:
+		return map[string]string{"type": "array", "items": "string"}
+	case common.FIELD_TYPE_FLOAT64_ARRAY:
+		return map[string]string{"type": "array", "items": "double"}
+	case common.FIELD_TYPE_TIMESTAMP_US:
+		return map[string]string{"type": "long", "logicalType": "timestamp-micros"}
+	}
+	return "string"
+}
+
+// schema generates the avro record schema, all fields are unions with null, as they may not be exported
+func (e *avroEncoder) schema(s *common.Schema) (string, string) {
+	type avroField struct {
+		Name    string        `json:"name"`
+		Type    []interface{} `json:"type"`
+		Default interface{}   `json:"default"`
+	}
+	namespace, name := splitSchemaName(s.Name)
+	fields := make([]avroField, 0, len(s.Fields))
+	for _, f := range s.Fields {
+		fields = append(fields, avroField{Name: sanitizeName(f.Name), Type: []interface{}{"null", avroType(f.Type)}})
+	}
+	definition, _ := json.Marshal(map[string]interface{}{
+		"type":      "record",
+		"namespace": namespace,
+		"name":      name,
+		"fields":    fields,
+	})
+	return namespace + "." + name, string(definition)
+}
+
+func appendAvroLong(buf []byte, v int64) []byte {
+	return binary.AppendVarint(buf, v)
+}
+
+func appendAvroString(buf []byte, s string) []byte {
+	buf = appendAvroLong(buf, int64(len(s)))
+	return append(buf, s...)
+}
+
+func appendAvroDouble(buf []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f))
+}
+
+// encode appends the record in avro binary encoding, the union index is 0 for null and 1 for the value
+func (e *avroEncoder) encode(buf []byte, s *common.Schema, r *common.Record) []byte {
+	for _, f := range s.Fields {
+		value := r.Values[f.ValueIndex]
+		if value == nil {
+			buf = appendAvroLong(buf, 0)
+			continue
+		}
+		buf = appendAvroLong(buf, 1)
+		switch v := value.(type) {
+		case string:
+			buf = appendAvroString(buf, v)
+		case int64:
+			buf = appendAvroLong(buf, v)
+		case uint64:
+			buf = appendAvroLong(buf, int64(v))
+		case float64:
+			buf = appendAvroDouble(buf, v)
+		case bool:
+			if v {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		case []string:
+			if len(v) > 0 {
+				buf = appendAvroLong(buf, int64(len(v)))
+				for _, s := range v {
+					buf = appendAvroString(buf, s)
+				}
+			}
+			buf = appendAvroLong(buf, 0)
+		case []float64:
+			if len(v) > 0 {
+				buf = appendAvroLong(buf, int64(len(v)))
+				for _, f := range v {
+					buf = appendAvroDouble(buf, f)
+				}
+			}
+			buf = appendAvroLong(buf, 0)
+		default:
+			buf = appendAvroString(buf, fmt.Sprintf("%v", v))
+		}
+	}
+	return buf
+}
+
+type protobufEncoder struct{}
+
+func (e *protobufEncoder) schemaType() string {
+	return "PROTOBUF"
+}
+
+func protobufType(fieldType common.FieldType) string {
+	switch fieldType {
+	case common.FIELD_TYPE_INT64, common.FIELD_TYPE_TIMESTAMP_US:
+		return "optional int64"
+	case common.FIELD_TYPE_UINT64:
+		return "optional uint64"
+	case common.FIELD_TYPE_FLOAT64:
+		return "optional double"
+	case common.FIELD_TYPE_BOOL:
+		return "optional bool"
+	case common.FIELD_TYPE_STRING_ARRAY:
+		return "repeated string"
+	case common.FIELD_TYPE_FLOAT64_ARRAY:
+		return "repeated double"
+	}
+	return "optional string"
+}
+
+// schema generates the proto3 schema, the field number is the index of the field in the schema plus 1
+func (e *protobufEncoder) schema(s *common.Schema) (string, string) {
+	namespace, name := splitSchemaName(s.Name)
+	name = camelName(name)
+	sb := strings.Builder{}
+	sb.WriteString("syntax = \"proto3\";\n")
+	sb.WriteString("package " + namespace + ";\n\n")
+	sb.WriteString("message " + name + " {\n")
+	for i, f := range s.Fields {
+		sb.WriteString(fmt.Sprintf("  %s %s = %d;\n", protobufType(f.Type), sanitizeName(f.Name), i+1))
+	}
+	sb.WriteString("}\n")
+	return namespace + "." + name, sb.String()
+}
+
+// encode appends the message indexes of the confluent wire format and the protobuf message
+func (e *protobufEncoder) encode(buf []byte, s *common.Schema, r *common.Record) []byte {
+	// the message is the first one in the schema, its message indexes [0] is encoded as a single 0
+	buf = append(buf, 0)
+	for i, f := range s.Fields {
+		value := r.Values[f.ValueIndex]
+		if value == nil {
+			continue
+		}
+		num := protowire.Number(i + 1)
+		switch v := value.(type) {
+		case string:
+			buf = protowire.AppendTag(buf, num, protowire.BytesType)
+			buf = protowire.AppendString(buf, v)
+		case int64:
+			buf = protowire.AppendTag(buf, num, protowire.VarintType)
+			buf = protowire.AppendVarint(buf, uint64(v))
+		case uint64:
+			buf = protowire.AppendTag(buf, num, protowire.VarintType)
+			buf = protowire.AppendVarint(buf, v)
+		case float64:
+			buf = protowire.AppendTag(buf, num, protowire.Fixed64Type)
+			buf = protowire.AppendFixed64(buf, math.Float64bits(v))
+		case bool:
+			buf = protowire.AppendTag(buf, num, protowire.VarintType)
+			buf = protowire.AppendVarint(buf, protowire.EncodeBool(v))
+		case []string:
+			for _, s := range v {
+				buf = protowire.AppendTag(buf, num, protowire.BytesType)
+				buf = protowire.AppendString(buf, s)
+			}
+		case []float64:
+			if len(v) == 0 {
+				continue
+			}
+			// repeated scalar fields are packed in proto3
+			buf = protowire.AppendTag(buf, num, protowire.BytesType)
+			buf = protowire.AppendVarint(buf, uint64(len(v)*8))
+			for _, f := range v {
+				buf = protowire.AppendFixed64(buf, math.Float64bits(f))
+			}
+		default:
+			buf = protowire.AppendTag(buf, num, protowire.BytesType)
+			buf = protowire.AppendString(buf, fmt.Sprintf("%v", v))
+		}
+	}
+	return buf
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka_exporter
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/deepflowio/deepflow/server/ingester/exporters/common"
+)
+
+func testSchema() (*common.Schema, *common.Record) {
+	schema := &common.Schema{
+		Name: "flow_metrics.application_map.1s",
+		Fields: []common.SchemaField{
+			{Name: "datasource", Type: common.FIELD_TYPE_STRING, ValueIndex: 0},
+			{Name: "flow_id", Type: common.FIELD_TYPE_UINT64, ValueIndex: 1},
+			{Name: "rrt", Type: common.FIELD_TYPE_FLOAT64, ValueIndex: 2},
+			{Name: "k8s_label_names", Type: common.FIELD_TYPE_STRING_ARRAY, ValueIndex: 3},
+			{Name: "timestamp_us", Type: common.FIELD_TYPE_TIMESTAMP_US, ValueIndex: 4},
+		},
+	}
+	record := &common.Record{
+		Values: []interface{}{"flow_metrics.application_map.1s", uint64(300), nil, []string{"app"}, int64(-1)},
+	}
+	return schema, record
+}
+
+func TestAvroEncoder(t *testing.T) {
+	schema, record := testSchema()
+	e := &avroEncoder{}
+	name, definition := e.schema(schema)
+	if name != "deepflow.flow_metrics.application_map_1s" {
+		t.Errorf("unexpected schema name %s", name)
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(definition), &parsed); err != nil || len(parsed["fields"].([]interface{})) != 5 {
+		t.Fatalf("invalid avro schema %s: %v", definition, err)
+	}
+
+	buf := e.encode(nil, schema, record)
+	var expect []byte
+	expect = append(expect, 2, byte(len(record.Values[0].(string))*2))
+	expect = append(expect, record.Values[0].(string)...)
+	expect = append(expect, 2, 0xd8, 0x04) // 300
+	expect = append(expect, 0)             // null
+	expect = append(expect, 2, 2, 6, 'a', 'p', 'p', 0)
+	expect = append(expect, 2, 1) // -1
+	if string(buf) != string(expect) {
+		t.Errorf("avro encode got %v, expect %v", buf, expect)
+	}
+}
+
+func TestProtobufEncoder(t *testing.T) {
+	schema, record := testSchema()
+	e := &protobufEncoder{}
+	name, definition := e.schema(schema)
+	if name != "deepflow.flow_metrics.ApplicationMap1s" ||
+		!strings.Contains(definition, "message ApplicationMap1s {") ||
+		!strings.Contains(definition, "optional uint64 flow_id = 2;") ||
+		!strings.Contains(definition, "repeated string k8s_label_names = 4;") {
+		t.Errorf("unexpected protobuf schema %s: %s", name, definition)
+	}
+
+	buf := e.encode(nil, schema, record)
+	if buf[0] != 0 {
+		t.Fatalf("message indexes should be 0")
+	}
+	values := make(map[protowire.Number]interface{})
+	b := buf[1:]
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			values[num], b = v, b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			values[num], b = math.Float64frombits(v), b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			values[num], b = v, b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+	if values[1] != record.Values[0] || values[2] != uint64(300) || values[4] != "app" || int64(values[5].(uint64)) != -1 {
+		t.Errorf("unexpected protobuf message %v", values)
+	}
+	if _, ok := values[3]; ok {
+		t.Errorf("nil value should not be encoded")
+	}
+}
+
+func TestWireFormatHeader(t *testing.T) {
+	buf := appendWireFormatHeader(nil, 258)
+	if len(buf) != WIRE_FORMAT_HEADER_LENGTH || buf[0] != WIRE_FORMAT_MAGIC_BYTE || binary.BigEndian.Uint32(buf[1:]) != 258 {
+		t.Errorf("unexpected wire format header %v", buf)
+	}
+}
+
+// test vectors from RFC 7677
+func TestScramClient(t *testing.T) {
+	c := newScramClientGenerator(sarama.SASLTypeSCRAMSHA256)().(*scramClient)
+	if err := c.Begin("user", "pencil", ""); err != nil {
+		t.Fatal(err)
+	}
+	c.ClientConversation = c.Client.WithNonceGenerator(func() string { return "rOprNGfwEbeRWgbNEkqO" }).NewConversation()
+	clientFirst, _ := c.Step("")
+	if clientFirst != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("unexpected client first message %s", clientFirst)
+	}
+	clientFinal, err := c.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil || clientFinal != "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=" {
+		t.Errorf("unexpected client final message %s, err: %v", clientFinal, err)
+	}
+	if _, err := c.Step("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil || !c.Done() {
+		t.Errorf("verify server final failed: %v", err)
+	}
+}
+
+func TestEncodeMessages(t *testing.T) {
+	now := time.UnixMicro(time.Now().UnixMicro())
+	batch := []*sarama.ProducerMessage{
+		{Topic: "deepflow.flow_log.l7_flow_log", Key: sarama.StringEncoder("123"), Value: sarama.ByteEncoder(`{"a":1}`), Timestamp: now},
+		{Topic: "t", Value: sarama.StringEncoder(""), Timestamp: now},
+	}
+	data, err := encodeMessages(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeMessages(data)
+	if err != nil || len(decoded) != 2 {
+		t.Fatalf("decode messages failed: %v", err)
+	}
+	for i, msg := range decoded {
+		var expectKey, key []byte
+		if batch[i].Key != nil {
+			expectKey, _ = batch[i].Key.Encode()
+		}
+		if msg.Key != nil {
+			key, _ = msg.Key.Encode()
+		}
+		expectValue, _ := batch[i].Value.Encode()
+		value, _ := msg.Value.Encode()
+		if msg.Topic != batch[i].Topic || (msg.Key == nil) != (batch[i].Key == nil) || string(key) != string(expectKey) ||
+			string(value) != string(expectValue) || !msg.Timestamp.Equal(now) {
+			t.Errorf("message %d decoded as %+v, expect %+v", i, msg, batch[i])
+		}
+	}
+	if _, err := decodeMessages(data[:len(data)-3]); err == nil {
+		t.Errorf("truncated messages should not be decoded")
+	}
+
+	retryable, poisoned := failedMessages(batch, sarama.ProducerErrors{
+		{Msg: batch[0], Err: sarama.ErrMessageSizeTooLarge},
+		{Msg: batch[1], Err: sarama.ErrNotLeaderForPartition},
+	})
+	if len(retryable) != 1 || retryable[0] != batch[1] || len(poisoned) != 1 || poisoned[0] != batch[0] {
+		t.Errorf("unexpected failed messages, retryable %v, poisoned %v", retryable, poisoned)
+	}
+}
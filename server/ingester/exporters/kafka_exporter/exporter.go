@@ -17,7 +17,10 @@
 package kafka_exporter
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"strconv"
 	"time"
 
@@ -27,6 +30,7 @@ import (
 	ingester_common "github.com/deepflowio/deepflow/server/ingester/common"
 	"github.com/deepflowio/deepflow/server/ingester/exporters/common"
 	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+	"github.com/deepflowio/deepflow/server/ingester/exporters/retry_queue"
 	utag "github.com/deepflowio/deepflow/server/ingester/exporters/universal_tag"
 	"github.com/deepflowio/deepflow/server/ingester/ingesterctl"
 	"github.com/deepflowio/deepflow/server/libs/debug"
@@ -39,6 +43,10 @@ var log = logging.MustGetLogger("kafka_exporter")
 
 const (
 	QUEUE_BATCH_COUNT = 1024
+
+	SCHEMA_REGISTER_RETRY_INTERVAL = 10 * time.Second
+
+	MESSAGES_ENCODING_VERSION = 1
 )
 
 type KafkaExporter struct {
@@ -46,7 +54,12 @@ type KafkaExporter struct {
 	Addr                 string
 	dataQueues           queue.FixedMultiQueue
 	queueCount           int
-	producers            []sarama.SyncProducer
+	producers            []sarama.SyncProducer // the last one is used by the retry queue
+	retryQueue           *retry_queue.RetryQueue
+	compression          sarama.CompressionCodec
+	encoder              recordEncoder
+	schemaRegistry       *schemaRegistry
+	recordSchemas        [][exporters_cfg.MAX_DATASOURCE_ID]*recordSchema // each queue has its own schemas
 	universalTagsManager *utag.UniversalTagsManager
 	config               *exporters_cfg.ExporterCfg
 	counter              *Counter
@@ -64,15 +77,29 @@ type Counter struct {
 	DropCounter          int64 `statsd:"drop-count"`
 	DropBatchCounter     int64 `statsd:"drop-batch-count"`
 	DropNoTraceIDCounter int64 `statsd:"drop-no-traceid-count"`
+
+	retry_queue.Counter
 }
 
 func (e *KafkaExporter) GetCounter() interface{} {
 	var counter Counter
 	counter, *e.counter = *e.counter, Counter{}
+	if e.retryQueue != nil {
+		counter.Counter = e.retryQueue.SwapCounter()
+	}
 	e.lastCounter = counter
 	return &counter
 }
 
+// recordSchema is the schema of a data source and its ids registered for the topics
+type recordSchema struct {
+	schema           *common.Schema
+	name             string
+	definition       string
+	ids              map[string]uint32 // key: topic
+	registerFailedAt time.Time
+}
+
 func NewKafkaExporter(index int, config *exporters_cfg.ExporterCfg, universalTagsManager *utag.UniversalTagsManager) *KafkaExporter {
 	dataQueues := queue.NewOverwriteQueues(
 		fmt.Sprintf("kafka_exporter_%d", index), queue.HashKey(config.QueueCount), config.QueueSize,
@@ -85,10 +112,30 @@ func NewKafkaExporter(index int, config *exporters_cfg.ExporterCfg, universalTag
 		dataQueues:           dataQueues,
 		queueCount:           config.QueueCount,
 		universalTagsManager: universalTagsManager,
-		producers:            make([]sarama.SyncProducer, config.QueueCount),
+		producers:            make([]sarama.SyncProducer, config.QueueCount+1),
+		encoder:              newRecordEncoder(config.Encoding),
+		recordSchemas:        make([][exporters_cfg.MAX_DATASOURCE_ID]*recordSchema, config.QueueCount),
 		config:               config,
 		counter:              &Counter{},
 	}
+	if err := exporter.compression.UnmarshalText([]byte(config.Compression)); err != nil {
+		exporter.compression = sarama.CompressionSnappy
+	}
+	if exporter.encoder != nil {
+		registry, err := newSchemaRegistry(&config.SchemaRegistry, exporter.encoder.schemaType())
+		if err != nil {
+			log.Errorf("kafka exporter %d init schema registry failed: %s", index, err)
+		}
+		exporter.schemaRegistry = registry
+	}
+	if config.RetryQueue.Enabled {
+		retryQueue, err := retry_queue.NewRetryQueue(fmt.Sprintf("kafka-%d", index), &config.RetryQueue, exporter.retrySend)
+		if err != nil {
+			log.Errorf("kafka exporter %d init retry queue failed, the failed messages will be dropped: %s", index, err)
+		} else {
+			exporter.retryQueue = retryQueue
+		}
+	}
 	debug.ServerRegisterSimple(ingesterctl.CMD_KAFKA_EXPORTER, exporter)
 	ingester_common.RegisterCountableForIngester("exporter", exporter, stats.OptionStatTags{
 		"type": "kafka", "index": strconv.Itoa(index)})
@@ -98,7 +145,51 @@ func NewKafkaExporter(index int, config *exporters_cfg.ExporterCfg, universalTag
 
 func (e *KafkaExporter) Put(items ...interface{}) {
 	e.counter.RecvCounter++
-	e.dataQueues.Put(queue.HashKey(int(e.counter.RecvCounter)%e.queueCount), items...)
+	if e.config.PartitionKey == "" {
+		key := queue.HashKey(int(e.counter.RecvCounter) % e.queueCount)
+		if e.retryQueue != nil {
+			e.retryQueue.WaitForRoom(e.dataQueues, key, e.config.QueueSize, len(items))
+		}
+		e.dataQueues.Put(key, items...)
+		return
+	}
+	// items with the same partition key are put into the same queue to keep them in order
+	for _, item := range items {
+		queueID := int(e.counter.RecvCounter) % e.queueCount
+		if exportItem, ok := item.(common.ExportItem); ok {
+			if key := e.partitionKey(exportItem); key != "" {
+				h := fnv.New32a()
+				h.Write([]byte(key))
+				queueID = int(h.Sum32() % uint32(e.queueCount))
+			}
+		}
+		if e.retryQueue != nil {
+			e.retryQueue.WaitForRoom(e.dataQueues, queue.HashKey(queueID), e.config.QueueSize, 1)
+		}
+		e.dataQueues.Put(queue.HashKey(queueID), item)
+	}
+}
+
+// partitionKey returns the value of the 'partition-key' field, messages with the same key are sent to the same partition
+func (e *KafkaExporter) partitionKey(item common.ExportItem) string {
+	dataSourceId := item.DataSource()
+	if dataSourceId >= uint32(exporters_cfg.MAX_DATASOURCE_ID) {
+		return ""
+	}
+	structTags := e.config.PartitionKeyStructTags[dataSourceId]
+	if structTags == nil {
+		return ""
+	}
+	value := item.GetFieldValueByOffsetAndKind(structTags.Offset, structTags.DataKind, structTags.DataType)
+	if utils.IsNil(value) {
+		return ""
+	}
+	if v, ok := value.(string); ok {
+		return v
+	} else if _, vStr, ok := utils.ConvertToFloat64(value); ok {
+		return vStr
+	}
+	return fmt.Sprintf("%v", value)
 }
 
 func (e *KafkaExporter) Start() {
@@ -116,7 +207,10 @@ func (e *KafkaExporter) Start() {
 func (e *KafkaExporter) Close() {
 	e.Closable.Close()
 	e.running = false
-	for i := 0; i < e.queueCount; i++ {
+	if e.retryQueue != nil {
+		e.retryQueue.Close()
+	}
+	for i := range e.producers {
 		if e.producers[i] != nil {
 			e.producers[i].Close()
 			e.producers[i] = nil
@@ -131,12 +225,29 @@ func (e *KafkaExporter) newProducer(id int) error {
 	config.Producer.RequiredAcks = sarama.WaitForAll
 	config.Producer.Retry.Max = 3
 	config.Producer.Return.Successes = true
-	config.Producer.Compression = sarama.CompressionSnappy
+	config.Producer.Compression = e.compression
+	// messages with the same key are sent to the same partition, and randomly if the key is nil
+	config.Producer.Partitioner = sarama.NewHashPartitioner
 
 	config.Net.SASL.Enable = e.config.Sasl.Enabled
-	config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	config.Net.SASL.Mechanism = sarama.SASLMechanism(e.config.Sasl.Mechanism)
 	config.Net.SASL.User = e.config.Sasl.Username
 	config.Net.SASL.Password = e.config.Sasl.Password
+	switch config.Net.SASL.Mechanism {
+	case sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		config.Net.SASL.SCRAMClientGeneratorFunc = newScramClientGenerator(config.Net.SASL.Mechanism)
+	case "":
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	}
+
+	if e.config.TLS.Enabled {
+		tlsConfig, err := e.config.TLS.Load()
+		if err != nil {
+			return err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
 
 	producer, err := sarama.NewSyncProducer(e.config.Endpoints, config)
 	if err != nil {
@@ -165,7 +276,15 @@ func (e *KafkaExporter) queueProcess(queueID int) {
 				continue
 			}
 
-			json, err := exportItem.EncodeTo(exporters_cfg.PROTOCOL_KAFKA, e.universalTagsManager, e.config)
+			encoded, err := exportItem.EncodeTo(exporters_cfg.PROTOCOL_KAFKA, e.universalTagsManager, e.config)
+			topic := e.config.Topic
+			if topic == "" {
+				topic = exporters_cfg.DataSourceID(exportItem.DataSource()).TopicString()
+			}
+			var value sarama.Encoder
+			if err == nil {
+				value, err = e.encodeValue(queueID, topic, encoded)
+			}
 			if err != nil {
 				if e.counter.DropCounter == 0 {
 					log.Warningf("kafka encode failed, err: %s", err)
@@ -175,21 +294,20 @@ func (e *KafkaExporter) queueProcess(queueID int) {
 				continue
 			}
 
-			jsonStr := json.(string)
-			topic := e.config.Topic
-			if topic == "" {
-				topic = exporters_cfg.DataSourceID(exportItem.DataSource()).TopicString()
+			var key sarama.Encoder
+			if k := e.partitionKey(exportItem); k != "" {
+				key = sarama.StringEncoder(k)
 			}
 			batch = append(batch,
 				&sarama.ProducerMessage{
 					Topic:     topic,
-					Key:       nil,
-					Value:     sarama.ByteEncoder(utils.Slice(jsonStr)),
+					Key:       key,
+					Value:     value,
 					Timestamp: time.UnixMicro(exportItem.TimestampUs()),
 				},
 			)
 			if len(batch) >= e.config.BatchSize {
-				log.Debugf("kafka: %s \n %+v", jsonStr, item)
+				log.Debugf("kafka: %v \n %+v", encoded, item)
 				e.exportBatch(queueID, batch)
 				batch = batch[:0]
 			}
@@ -198,6 +316,55 @@ func (e *KafkaExporter) queueProcess(queueID int) {
 	}
 }
 
+// encodeValue encodes the json string as it is, and the record by the schema registered to the schema registry
+func (e *KafkaExporter) encodeValue(queueID int, topic string, encoded interface{}) (sarama.Encoder, error) {
+	switch v := encoded.(type) {
+	case string:
+		return sarama.ByteEncoder(utils.Slice(v)), nil
+	case *common.Record:
+		if v == nil || e.encoder == nil || e.schemaRegistry == nil {
+			return nil, fmt.Errorf("invalid record, or schema registry of encoding %s is not initialized", e.config.Encoding)
+		}
+		rs, err := e.recordSchema(queueID, v.DataSourceID, topic)
+		if err != nil {
+			return nil, err
+		}
+		buf := appendWireFormatHeader(make([]byte, 0, 512), rs.ids[topic])
+		return sarama.ByteEncoder(e.encoder.encode(buf, rs.schema, v)), nil
+	}
+	return nil, fmt.Errorf("unsupported encoded type %T", encoded)
+}
+
+// recordSchema gets the schema of the data source, and registers it for the topic if not registered
+func (e *KafkaExporter) recordSchema(queueID, dataSourceId int, topic string) (*recordSchema, error) {
+	rs := e.recordSchemas[queueID][dataSourceId]
+	if rs == nil {
+		schema := common.NewSchema(dataSourceId, e.config)
+		name, definition := e.encoder.schema(schema)
+		rs = &recordSchema{
+			schema:     schema,
+			name:       name,
+			definition: definition,
+			ids:        make(map[string]uint32),
+		}
+		e.recordSchemas[queueID][dataSourceId] = rs
+	}
+	if _, ok := rs.ids[topic]; ok {
+		return rs, nil
+	}
+
+	if time.Since(rs.registerFailedAt) < SCHEMA_REGISTER_RETRY_INTERVAL {
+		return nil, fmt.Errorf("schema %s of topic %s is not registered", rs.name, topic)
+	}
+	id, err := e.schemaRegistry.register(e.schemaRegistry.subject(topic, rs.name), rs.definition)
+	if err != nil {
+		rs.registerFailedAt = time.Now()
+		return nil, err
+	}
+	rs.ids[topic] = id
+	return rs, nil
+}
+
 func (e *KafkaExporter) exportBatch(queueID int, batch []*sarama.ProducerMessage) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -208,6 +375,9 @@ func (e *KafkaExporter) exportBatch(queueID int, batch []*sarama.ProducerMessage
 	if len(batch) == 0 {
 		return
 	}
+	if e.spill(queueID, batch) {
+		return
+	}
 
 	if utils.IsNil(e.producers[queueID]) {
 		err := e.newProducer(queueID)
@@ -215,8 +385,10 @@ func (e *KafkaExporter) exportBatch(queueID int, batch []*sarama.ProducerMessage
 			if e.counter.DropCounter == 0 {
 				log.Warningf("exporter %d queue %d new kafka producer failed. err: %s", e.index, queueID, err)
 			}
-			e.counter.DropCounter += int64(len(batch))
-			e.counter.DropBatchCounter++
+			if !e.saveFailed(batch, err) {
+				e.counter.DropCounter += int64(len(batch))
+				e.counter.DropBatchCounter++
+			}
 			return
 		}
 	}
@@ -237,8 +409,10 @@ func (e *KafkaExporter) exportBatch(queueID int, batch []*sarama.ProducerMessage
 		if e.counter.DropCounter == 0 {
 			log.Warningf("exporter %d send kafka messages failed. err: %s", e.index, err)
 		}
-		e.counter.DropCounter += int64(len(batch))
-		e.counter.DropBatchCounter++
+		if !e.saveFailed(batch, err) {
+			e.counter.DropCounter += int64(len(batch))
+			e.counter.DropBatchCounter++
+		}
 	} else {
 		e.counter.SendCounter += int64(len(batch))
 		e.counter.SendBatchCounter++
@@ -247,6 +421,164 @@ func (e *KafkaExporter) exportBatch(queueID int, batch []*sarama.ProducerMessage
 	e.counter.ExportUsedTimeNs += int64(time.Since(now))
 }
 
+// spill puts the batch to the retry queue without sending it if the queue is backlogged, returns true if spilled
+func (e *KafkaExporter) spill(queueID int, batch []*sarama.ProducerMessage) bool {
+	if e.retryQueue == nil || !e.retryQueue.Backlogged(e.dataQueues, queue.HashKey(queueID), e.config.QueueSize) {
+		return false
+	}
+	data, err := encodeMessages(batch)
+	return err == nil && e.retryQueue.Spill(data) == nil
+}
+
+// isPoisoned returns true if the message is rejected for itself, retrying will not succeed
+func isPoisoned(err error) bool {
+	return errors.Is(err, sarama.ErrMessageSizeTooLarge) || errors.Is(err, sarama.ErrInvalidMessage) ||
+		errors.Is(err, sarama.ErrInvalidMessageSize) || errors.Is(err, sarama.ErrInvalidRecord)
+}
+
+// failedMessages splits the failed messages of the batch into the retryable and the poisoned ones
+func failedMessages(batch []*sarama.ProducerMessage, err error) ([]*sarama.ProducerMessage, []*sarama.ProducerMessage) {
+	var retryable, poisoned []*sarama.ProducerMessage
+	var producerErrs sarama.ProducerErrors
+	if !errors.As(err, &producerErrs) {
+		if isPoisoned(err) {
+			return nil, batch
+		}
+		return batch, nil
+	}
+	for _, e := range producerErrs {
+		if isPoisoned(e.Err) {
+			poisoned = append(poisoned, e.Msg)
+		} else {
+			retryable = append(retryable, e.Msg)
+		}
+	}
+	return retryable, poisoned
+}
+
+// saveFailed puts the failed messages to the retry queue, and the poisoned ones to the dead-letter directory,
+// returns false if the messages are dropped
+func (e *KafkaExporter) saveFailed(batch []*sarama.ProducerMessage, err error) bool {
+	if e.retryQueue == nil {
+		return false
+	}
+	retryable, poisoned := failedMessages(batch, err)
+	saved := true
+	if len(poisoned) > 0 {
+		if data, encodeErr := encodeMessages(poisoned); encodeErr != nil || e.retryQueue.DeadLetter(data, err) != nil {
+			saved = false
+		}
+	}
+	if len(retryable) > 0 {
+		if data, encodeErr := encodeMessages(retryable); encodeErr != nil || e.retryQueue.Put(data) != nil {
+			saved = false
+		}
+	}
+	return saved
+}
+
+// retrySend sends the messages read from the retry queue by the last producer
+func (e *KafkaExporter) retrySend(data []byte) error {
+	batch, err := decodeMessages(data)
+	if err != nil {
+		return retry_queue.Permanent(err)
+	}
+	id := e.queueCount
+	if utils.IsNil(e.producers[id]) {
+		if err := e.newProducer(id); err != nil {
+			return err
+		}
+	}
+	if err = e.producers[id].SendMessages(batch); err == nil {
+		return nil
+	}
+	// the succeeded messages are sent again when retrying, unless all the failed ones are poisoned
+	if retryable, _ := failedMessages(batch, err); len(retryable) == 0 {
+		return retry_queue.Permanent(err)
+	}
+	return err
+}
+
+// encodeMessages encodes the messages for the retry queue, each message is encoded as:
+// len(topic), topic, len(key)+1 (0 means nil), key, len(value), value, timestamp(us)
+func encodeMessages(batch []*sarama.ProducerMessage) ([]byte, error) {
+	buf := []byte{MESSAGES_ENCODING_VERSION}
+	for _, msg := range batch {
+		buf = binary.AppendUvarint(buf, uint64(len(msg.Topic)))
+		buf = append(buf, msg.Topic...)
+		if msg.Key == nil {
+			buf = binary.AppendUvarint(buf, 0)
+		} else {
+			key, err := msg.Key.Encode()
+			if err != nil {
+				return nil, err
+			}
+			buf = binary.AppendUvarint(buf, uint64(len(key))+1)
+			buf = append(buf, key...)
+		}
+		var value []byte
+		if msg.Value != nil {
+			var err error
+			if value, err = msg.Value.Encode(); err != nil {
+				return nil, err
+			}
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(value)))
+		buf = append(buf, value...)
+		buf = binary.AppendVarint(buf, msg.Timestamp.UnixMicro())
+	}
+	return buf, nil
+}
+
+func decodeMessages(data []byte) ([]*sarama.ProducerMessage, error) {
+	if len(data) == 0 || data[0] != MESSAGES_ENCODING_VERSION {
+		return nil, fmt.Errorf("unsupported messages encoding version")
+	}
+	data = data[1:]
+	invalid := fmt.Errorf("invalid encoded messages")
+	readBytes := func() ([]byte, bool) {
+		l, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < l {
+			return nil, false
+		}
+		b := data[n : n+int(l)]
+		data = data[n+int(l):]
+		return b, true
+	}
+
+	var batch []*sarama.ProducerMessage
+	for len(data) > 0 {
+		topic, ok := readBytes()
+		if !ok {
+			return nil, invalid
+		}
+		msg := &sarama.ProducerMessage{Topic: string(topic)}
+		keyLen, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n)+1 < keyLen {
+			return nil, invalid
+		}
+		if keyLen > 0 {
+			msg.Key = sarama.ByteEncoder(data[n : n+int(keyLen-1)])
+			data = data[n+int(keyLen-1):]
+		} else {
+			data = data[n:]
+		}
+		value, ok := readBytes()
+		if !ok {
+			return nil, invalid
+		}
+		msg.Value = sarama.ByteEncoder(value)
+		timestamp, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, invalid
+		}
+		msg.Timestamp = time.UnixMicro(timestamp)
+		data = data[n:]
+		batch = append(batch, msg)
+	}
+	return batch, nil
+}
+
 func (e *KafkaExporter) HandleSimpleCommand(op uint16, arg string) string {
 	return fmt.Sprintf("kafka exporter %d last 10s counter: %+v", e.index, e.lastCounter)
 }
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka_exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+)
+
+const (
+	SCHEMA_REGISTRY_TIMEOUT      = 10 * time.Second
+	SCHEMA_REGISTRY_CONTENT_TYPE = "application/vnd.schemaregistry.v1+json"
+
+	// the confluent wire format: magic byte(0) + schema id(4 bytes, big endian) + payload
+	WIRE_FORMAT_MAGIC_BYTE    = 0
+	WIRE_FORMAT_HEADER_LENGTH = 5
+)
+
+// schemaRegistry registers the schemas to the confluent schema registry, and caches the schema ids
+type schemaRegistry struct {
+	config     *exporters_cfg.SchemaRegistry
+	schemaType string // 'AVRO' or 'PROTOBUF'
+	client     *http.Client
+
+	sync.Mutex
+	ids map[string]uint32 // key: subject + schema
+}
+
+func newSchemaRegistry(config *exporters_cfg.SchemaRegistry, schemaType string) (*schemaRegistry, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.TLS.Enabled {
+		tlsConfig, err := config.TLS.Load()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &schemaRegistry{
+		config:     config,
+		schemaType: schemaType,
+		client:     &http.Client{Transport: transport, Timeout: SCHEMA_REGISTRY_TIMEOUT},
+		ids:        make(map[string]uint32),
+	}, nil
+}
+
+// subject returns the subject name of the message value by the subject name strategy
+func (r *schemaRegistry) subject(topic, recordName string) string {
+	switch r.config.SubjectNameStrategy {
+	case exporters_cfg.SUBJECT_NAME_STRATEGY_RECORD:
+		return recordName
+	case exporters_cfg.SUBJECT_NAME_STRATEGY_TOPIC_RECORD:
+		return topic + "-" + recordName
+	default:
+		return topic + "-value"
+	}
+}
+
+// register registers the schema under the subject if not registered, and returns the schema id
+func (r *schemaRegistry) register(subject, schema string) (uint32, error) {
+	key := subject + "\x00" + schema
+	r.Lock()
+	id, ok := r.ids[key]
+	r.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"schema": schema, "schemaType": r.schemaType})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest("POST",
+		strings.TrimSuffix(r.config.URL, "/")+"/subjects/"+url.PathEscape(subject)+"/versions", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", SCHEMA_REGISTRY_CONTENT_TYPE)
+	if r.config.Username != "" {
+		req.SetBasicAuth(r.config.Username, r.config.Password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("register schema of subject %s failed: %s", subject, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("register schema of subject %s failed, status: %s, response: %s", subject, resp.Status, respBody)
+	}
+	var result struct {
+		ID uint32 `json:"id"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return 0, fmt.Errorf("register schema of subject %s failed, invalid response %s: %s", subject, respBody, err)
+	}
+
+	r.Lock()
+	r.ids[key] = result.ID
+	r.Unlock()
+	log.Infof("registered schema of subject %s, id %d", subject, result.ID)
+	return result.ID, nil
+}
+
+func appendWireFormatHeader(buf []byte, schemaID uint32) []byte {
+	buf = append(buf, WIRE_FORMAT_MAGIC_BYTE)
+	return binary.BigEndian.AppendUint32(buf, schemaID)
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka_exporter
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// scramClient adapts the SCRAM (RFC 5802) client conversation of xdg-go/scram to sarama
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	hashGen scram.HashGeneratorFcn
+}
+
+func newScramClientGenerator(mechanism sarama.SASLMechanism) func() sarama.SCRAMClient {
+	hashGen := scram.HashGeneratorFcn(sha256.New)
+	if mechanism == sarama.SASLTypeSCRAMSHA512 {
+		hashGen = sha512.New
+	}
+	return func() sarama.SCRAMClient {
+		return &scramClient{hashGen: hashGen}
+	}
+}
+
+func (c *scramClient) Begin(username, password, authzID string) (err error) {
+	if c.Client, err = c.hashGen.NewClient(username, password, authzID); err != nil {
+		return err
+	}
+	c.ClientConversation = c.Client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.ClientConversation.Done()
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	logging "github.com/op/go-logging"
@@ -27,12 +28,11 @@ import (
 	"go.opentelemetry.io/collector/pdata/ptrace"
 	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
 	"golang.org/x/net/context"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
 
 	ingester_common "github.com/deepflowio/deepflow/server/ingester/common"
 	"github.com/deepflowio/deepflow/server/ingester/exporters/common"
 	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+	"github.com/deepflowio/deepflow/server/ingester/exporters/retry_queue"
 	utag "github.com/deepflowio/deepflow/server/ingester/exporters/universal_tag"
 	"github.com/deepflowio/deepflow/server/ingester/ingesterctl"
 	"github.com/deepflowio/deepflow/server/libs/debug"
@@ -45,6 +45,7 @@ var log = logging.MustGetLogger("otlp_exporter")
 
 const (
 	QUEUE_BATCH_COUNT = 1024
+	EXPORT_TIMEOUT    = 30 * time.Second
 )
 
 type OtlpExporter struct {
@@ -52,9 +53,11 @@ type OtlpExporter struct {
 	Addr                 string
 	dataQueues           queue.FixedMultiQueue
 	queueCount           int
-	grpcExporters        []ptraceotlp.GRPCClient
-	grpcConns            []*grpc.ClientConn
-	grpcFailedCounters   []int
+	senders              []sender // the last one is used by the retry queue
+	senderEndpoints      []int
+	failedCounters       []int
+	endpointCounters     []*endpointCountable
+	retryQueue           *retry_queue.RetryQueue
 	universalTagsManager *utag.UniversalTagsManager
 	config               *exporters_cfg.ExporterCfg
 	counter              *Counter
@@ -71,15 +74,46 @@ type Counter struct {
 	ExportUsedTimeNs int64 `statsd:"export-used-time-ns"`
 	DropCounter      int64 `statsd:"drop-count"`
 	DropBatchCounter int64 `statsd:"drop-batch-count"`
+
+	retry_queue.Counter
 }
 
 func (e *OtlpExporter) GetCounter() interface{} {
 	var counter Counter
 	counter, *e.counter = *e.counter, Counter{}
+	if e.retryQueue != nil {
+		counter.Counter = e.retryQueue.SwapCounter()
+	}
 	e.lastCounter = counter
 	return &counter
 }
 
+type EndpointCounter struct {
+	SendBatchCounter int64 `statsd:"send-batch-count"`
+	FailedCounter    int64 `statsd:"failed-count"`
+	RetryCounter     int64 `statsd:"retry-count"`
+	ThrottledCounter int64 `statsd:"throttled-count"`
+	DropBatchCounter int64 `statsd:"drop-batch-count"`
+	ExportUsedTimeNs int64 `statsd:"export-used-time-ns"`
+}
+
+// endpointCountable is shared by the queues exporting to the same endpoint, so the counters are updated atomically
+type endpointCountable struct {
+	counter EndpointCounter
+	utils.Closable
+}
+
+func (c *endpointCountable) GetCounter() interface{} {
+	return &EndpointCounter{
+		SendBatchCounter: atomic.SwapInt64(&c.counter.SendBatchCounter, 0),
+		FailedCounter:    atomic.SwapInt64(&c.counter.FailedCounter, 0),
+		RetryCounter:     atomic.SwapInt64(&c.counter.RetryCounter, 0),
+		ThrottledCounter: atomic.SwapInt64(&c.counter.ThrottledCounter, 0),
+		DropBatchCounter: atomic.SwapInt64(&c.counter.DropBatchCounter, 0),
+		ExportUsedTimeNs: atomic.SwapInt64(&c.counter.ExportUsedTimeNs, 0),
+	}
+}
+
 func NewOtlpExporter(index int, config *exporters_cfg.ExporterCfg, universalTagsManager *utag.UniversalTagsManager) *OtlpExporter {
 	dataQueues := queue.NewOverwriteQueues(
 		fmt.Sprintf("otlp_exporter_%d", index), queue.HashKey(config.QueueCount), config.QueueSize,
@@ -92,22 +126,40 @@ func NewOtlpExporter(index int, config *exporters_cfg.ExporterCfg, universalTags
 		dataQueues:           dataQueues,
 		queueCount:           config.QueueCount,
 		universalTagsManager: universalTagsManager,
-		grpcConns:            make([]*grpc.ClientConn, config.QueueCount),
-		grpcFailedCounters:   make([]int, config.QueueCount),
-		grpcExporters:        make([]ptraceotlp.GRPCClient, config.QueueCount),
+		senders:              make([]sender, config.QueueCount+1),
+		senderEndpoints:      make([]int, config.QueueCount+1),
+		failedCounters:       make([]int, config.QueueCount+1),
+		endpointCounters:     make([]*endpointCountable, len(config.Endpoints)),
 		config:               config,
 		counter:              &Counter{},
 	}
+	if config.RetryQueue.Enabled {
+		retryQueue, err := retry_queue.NewRetryQueue(fmt.Sprintf("otlp-%d", index), &config.RetryQueue, exporter.retrySend)
+		if err != nil {
+			log.Errorf("otlp exporter %d init retry queue failed, the failed requests will be dropped: %s", index, err)
+		} else {
+			exporter.retryQueue = retryQueue
+		}
+	}
 	debug.ServerRegisterSimple(ingesterctl.CMD_OTLP_EXPORTER, exporter)
 	ingester_common.RegisterCountableForIngester("exporter", exporter, stats.OptionStatTags{
 		"type": "otlp", "index": strconv.Itoa(index)})
+	for i, endpoint := range config.Endpoints {
+		exporter.endpointCounters[i] = &endpointCountable{}
+		ingester_common.RegisterCountableForIngester("exporter_endpoint", exporter.endpointCounters[i], stats.OptionStatTags{
+			"type": "otlp", "index": strconv.Itoa(index), "endpoint": endpoint})
+	}
 	log.Infof("otlp exporter %d created", index)
 	return exporter
 }
 
 func (e *OtlpExporter) Put(items ...interface{}) {
 	e.counter.RecvCounter++
-	e.dataQueues.Put(queue.HashKey(int(e.counter.RecvCounter)%e.queueCount), items...)
+	key := queue.HashKey(int(e.counter.RecvCounter) % e.queueCount)
+	if e.retryQueue != nil {
+		e.retryQueue.WaitForRoom(e.dataQueues, key, e.config.QueueSize, len(items))
+	}
+	e.dataQueues.Put(key, items...)
 }
 
 func (e *OtlpExporter) Start() {
@@ -124,6 +176,12 @@ func (e *OtlpExporter) Start() {
 
 func (e *OtlpExporter) Close() {
 	e.running = false
+	for _, c := range e.endpointCounters {
+		c.Close()
+	}
+	if e.retryQueue != nil {
+		e.retryQueue.Close()
+	}
 	log.Infof("otlp exporter %d stopping", e.index)
 }
 
@@ -132,18 +190,19 @@ func (e *OtlpExporter) queueProcess(queueID int) {
 	traces := ptrace.NewTraces()
 	items := make([]interface{}, QUEUE_BATCH_COUNT)
 
-	ctx := context.Background()
-	if len(e.config.ExtraHeaders) > 0 {
-		ctx = metadata.NewOutgoingContext(ctx, metadata.New(e.config.ExtraHeaders))
-	}
-
 	doExport := func() {
 		if batchCount == 0 {
 			return
 		}
 
-		if err := e.grpcExport(ctx, queueID, ptraceotlp.NewExportRequestFromTraces(traces)); err == nil {
-			e.counter.SendCounter += int64(batchCount)
+		req := ptraceotlp.NewExportRequestFromTraces(traces)
+		if !e.spill(queueID, req) {
+			if err := e.export(queueID, req); err == nil {
+				e.counter.SendCounter += int64(batchCount)
+			} else if !e.saveFailed(req, err) {
+				e.counter.DropCounter++
+				e.counter.DropBatchCounter++
+			}
 		}
 		batchCount = 0
 		log.Debugf(tracesToString(traces))
@@ -185,73 +244,159 @@ func (e *OtlpExporter) queueProcess(queueID int) {
 	}
 }
 
-func (e *OtlpExporter) grpcExport(ctx context.Context, queueID int, req ptraceotlp.ExportRequest) error {
+// export sends the request and retries the retryable failures with exponential backoff, until
+// retry.max-elapsed-time is reached. When throttled, the request is retried on the same endpoint
+// after the delay given by the server, otherwise the next endpoint is used.
+func (e *OtlpExporter) export(queueID int, req ptraceotlp.ExportRequest) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Warningf("grpc otlp export error: %s", r)
+			log.Warningf("otlp export error: %s", r)
 			if j, err := req.MarshalJSON(); err == nil {
 				log.Infof("otlp request: %s", string(j))
 			}
+			// the request causing panic is poisoned
+			err = retry_queue.Permanent(fmt.Errorf("otlp export error: %s", r))
 		}
 	}()
 
 	now := time.Now()
+	retry := &e.config.Retry
+	backoff := time.Duration(retry.InitialInterval) * time.Second
+	maxBackoff := time.Duration(retry.MaxInterval) * time.Second
+	deadline := now.Add(time.Duration(retry.MaxElapsedTime) * time.Second)
+	for {
+		err := e.exportOnce(queueID, req)
+		if err == nil {
+			e.counter.SendBatchCounter++
+			e.counter.ExportUsedTimeNs += int64(time.Since(now))
+			return nil
+		}
 
-	if e.grpcExporters[queueID] == nil {
-		if err := e.newGrpcExporter(queueID); err != nil {
+		exportErr, _ := err.(*exportError)
+		wait := backoff
+		if exportErr != nil && exportErr.throttled && exportErr.delay > 0 {
+			wait = exportErr.delay
+		}
+		if retry.Disabled || exportErr == nil || !exportErr.retryable || !e.running || time.Now().Add(wait).After(deadline) {
 			if e.counter.DropCounter == 0 {
-				log.Warningf("new grpc otlp exporter failed. err: %s", err)
+				log.Warningf("otlp exporter %d send traces to %s failed. failedCounter=%d, err: %s",
+					e.index, e.config.Endpoints[e.senderEndpoints[queueID]], e.failedCounters[queueID], err)
+			}
+			if exportErr != nil {
+				atomic.AddInt64(&e.endpointCounters[e.senderEndpoints[queueID]].counter.DropBatchCounter, 1)
 			}
-			e.counter.DropCounter++
 			return err
 		}
+		atomic.AddInt64(&e.endpointCounters[e.senderEndpoints[queueID]].counter.RetryCounter, 1)
+		time.Sleep(wait)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
-	_, err := e.grpcExporters[queueID].Export(ctx, req)
-	if err != nil {
-		if e.counter.DropCounter == 0 {
-			log.Warningf("otlp exporter %d send grpc traces failed. faildCounter=%d, err: %s", e.index, e.grpcFailedCounters[queueID], err)
+}
+
+// exportOnce sends the request by the sender of senderID, the sender is closed and the next endpoint
+// will be used if failed and not throttled
+func (e *OtlpExporter) exportOnce(senderID int, req ptraceotlp.ExportRequest) error {
+	if e.senders[senderID] == nil {
+		if err := e.newSender(senderID); err != nil {
+			if e.counter.DropCounter == 0 {
+				log.Warningf("new otlp sender failed. err: %s", err)
+			}
+			return err
 		}
-		e.counter.DropCounter++
-		e.grpcExporters[queueID] = nil
-		return err
+	}
+	endpointCounter := &e.endpointCounters[e.senderEndpoints[senderID]].counter
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), EXPORT_TIMEOUT)
+	err := e.senders[senderID].Export(ctx, req)
+	cancel()
+	atomic.AddInt64(&endpointCounter.ExportUsedTimeNs, int64(time.Since(start)))
+	if err == nil {
+		atomic.AddInt64(&endpointCounter.SendBatchCounter, 1)
+		return nil
+	}
+
+	atomic.AddInt64(&endpointCounter.FailedCounter, 1)
+	if exportErr, ok := err.(*exportError); ok && exportErr.throttled {
+		atomic.AddInt64(&endpointCounter.ThrottledCounter, 1)
 	} else {
-		e.counter.SendBatchCounter++
+		// next time, change to next endpoint
+		e.closeSender(senderID)
 	}
-	e.counter.ExportUsedTimeNs += int64(time.Since(now))
-	return nil
+	return err
 }
 
-func (e *OtlpExporter) getConn(queueID int) (*grpc.ClientConn, error) {
-	addrIndex := e.grpcFailedCounters[queueID] % len(e.config.Endpoints)
-	var options = []grpc.DialOption{grpc.WithInsecure(), grpc.WithTimeout(time.Minute)}
-	conn, err := grpc.Dial(e.config.Endpoints[addrIndex], options...)
-	if err != nil {
-		// next time, change to next endpoint
-		e.grpcFailedCounters[queueID]++
-		return nil, fmt.Errorf("grpc dial %s failed, err: %s", e.config.Endpoints[addrIndex], err)
+// spill puts the request to the retry queue without sending it if the queue is backlogged, returns true if spilled
+func (e *OtlpExporter) spill(queueID int, req ptraceotlp.ExportRequest) bool {
+	if e.retryQueue == nil || !e.retryQueue.Backlogged(e.dataQueues, queue.HashKey(queueID), e.config.QueueSize) {
+		return false
 	}
-	// next time, change to next endpoint
-	e.grpcFailedCounters[queueID]++
-	log.Debugf("new grpc otlp exporter: %s", e.config.Endpoints[addrIndex])
-	return conn, nil
+	data, err := req.MarshalProto()
+	return err == nil && e.retryQueue.Spill(data) == nil
+}
+
+// saveFailed puts the failed request to the retry queue, or to the dead-letter directory if it is rejected
+// by the server as not retryable, returns false if the request is dropped
+func (e *OtlpExporter) saveFailed(req ptraceotlp.ExportRequest, err error) bool {
+	if e.retryQueue == nil {
+		return false
+	}
+	data, marshalErr := req.MarshalProto()
+	if marshalErr != nil {
+		log.Warningf("otlp exporter %d marshal failed request failed: %s", e.index, marshalErr)
+		return false
+	}
+	if exportErr, ok := err.(*exportError); (ok && !exportErr.retryable) || retry_queue.IsPermanent(err) {
+		err = e.retryQueue.DeadLetter(data, err)
+	} else {
+		err = e.retryQueue.Put(data)
+	}
+	return err == nil
 }
 
-func (e *OtlpExporter) newGrpcExporter(queueID int) error {
-	if e.grpcConns[queueID] != nil {
-		e.grpcConns[queueID].Close()
-		e.grpcConns[queueID] = nil
+// retrySend sends the request read from the retry queue by the last sender, the retry queue does the backoff
+func (e *OtlpExporter) retrySend(data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = retry_queue.Permanent(fmt.Errorf("otlp export error: %s", r))
+		}
+	}()
+	req := ptraceotlp.NewExportRequest()
+	if err := req.UnmarshalProto(data); err != nil {
+		return retry_queue.Permanent(err)
+	}
+	err = e.exportOnce(e.queueCount, req)
+	if exportErr, ok := err.(*exportError); ok && !exportErr.retryable {
+		return retry_queue.Permanent(err)
 	}
+	return err
+}
+
+func (e *OtlpExporter) newSender(queueID int) error {
+	e.closeSender(queueID)
 
-	conn, err := e.getConn(queueID)
+	endpointIndex := e.failedCounters[queueID] % len(e.config.Endpoints)
+	// next time, change to next endpoint
+	e.failedCounters[queueID]++
+	s, err := newSender(e.config, e.config.Endpoints[endpointIndex])
 	if err != nil {
 		return err
 	}
-
-	e.grpcConns[queueID] = conn
-	e.grpcExporters[queueID] = ptraceotlp.NewGRPCClient(conn)
+	log.Debugf("new otlp sender: %s, protocol: %s", e.config.Endpoints[endpointIndex], e.config.OtlpProtocol)
+	e.senders[queueID] = s
+	e.senderEndpoints[queueID] = endpointIndex
 	return nil
 }
 
+func (e *OtlpExporter) closeSender(queueID int) {
+	if e.senders[queueID] != nil {
+		e.senders[queueID].Close()
+		e.senders[queueID] = nil
+	}
+}
+
 func (e *OtlpExporter) HandleSimpleCommand(op uint16, arg string) string {
 	return fmt.Sprintf("otlp exporter %d last 10s counter: %+v", e.index, e.lastCounter)
 }
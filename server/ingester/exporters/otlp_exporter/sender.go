@@ -0,0 +1,316 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package otlp_exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	ingester_common "github.com/deepflowio/deepflow/server/ingester/common"
+	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+)
+
+const (
+	OTLP_HTTP_TRACES_PATH = "/v1/traces"
+
+	RETRY_INFO_TYPE_URL = "type.googleapis.com/google.rpc.RetryInfo"
+)
+
+// sender sends the trace requests to one endpoint
+type sender interface {
+	Export(ctx context.Context, req ptraceotlp.ExportRequest) error
+	Close() error
+}
+
+// exportError is returned by the senders. Retryable errors may be retried after the
+// backoff interval, or after delay if the server throttles the requests.
+type exportError struct {
+	err       error
+	retryable bool
+	throttled bool
+	delay     time.Duration
+}
+
+func (e *exportError) Error() string {
+	return e.err.Error()
+}
+
+func newSender(config *exporters_cfg.ExporterCfg, endpoint string) (sender, error) {
+	switch config.OtlpProtocol {
+	case exporters_cfg.OTLP_PROTOCOL_HTTP_PROTOBUF, exporters_cfg.OTLP_PROTOCOL_HTTP_JSON:
+		return newHttpSender(config, endpoint)
+	default:
+		return newGrpcSender(config, endpoint)
+	}
+}
+
+type grpcSender struct {
+	conn        *grpc.ClientConn
+	client      ptraceotlp.GRPCClient
+	md          metadata.MD
+	callOptions []grpc.CallOption
+}
+
+func newGrpcSender(config *exporters_cfg.ExporterCfg, endpoint string) (*grpcSender, error) {
+	var options = []grpc.DialOption{grpc.WithTimeout(time.Minute)}
+	if config.TLS.Enabled {
+		tlsConfig, err := config.TLS.Load()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		options = append(options, grpc.WithInsecure())
+	}
+	conn, err := grpc.Dial(endpoint, options...)
+	if err != nil {
+		return nil, fmt.Errorf("grpc dial %s failed, err: %s", endpoint, err)
+	}
+
+	s := &grpcSender{
+		conn:   conn,
+		client: ptraceotlp.NewGRPCClient(conn),
+	}
+	if headers := config.Headers(endpoint); len(headers) > 0 {
+		s.md = metadata.New(headers)
+	}
+	if config.Compression == exporters_cfg.COMPRESSION_GZIP {
+		s.callOptions = append(s.callOptions, grpc.UseCompressor(grpcgzip.Name))
+	}
+	return s, nil
+}
+
+func (s *grpcSender) Export(ctx context.Context, req ptraceotlp.ExportRequest) error {
+	if s.md != nil {
+		ctx = metadata.NewOutgoingContext(ctx, s.md)
+	}
+	_, err := s.client.Export(ctx, req, s.callOptions...)
+	if err != nil {
+		return grpcExportError(err)
+	}
+	return nil
+}
+
+func (s *grpcSender) Close() error {
+	return s.conn.Close()
+}
+
+// grpcExportError classifies the error by the status code, as required by the OTLP specification:
+// https://opentelemetry.io/docs/specs/otlp/#failures
+func grpcExportError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return &exportError{err: err}
+	}
+	delay, hasRetryInfo := retryInfoDelay(st)
+	switch st.Code() {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return &exportError{err: err, retryable: true, throttled: hasRetryInfo, delay: delay}
+	case codes.ResourceExhausted:
+		// only retryable if the server tells when to retry
+		return &exportError{err: err, retryable: hasRetryInfo, throttled: hasRetryInfo, delay: delay}
+	}
+	return &exportError{err: err}
+}
+
+// retryInfoDelay gets the delay of the google.rpc.RetryInfo in the status details:
+//
+//	RetryInfo { google.protobuf.Duration retry_delay = 1; }
+//	Duration  { int64 seconds = 1; int32 nanos = 2; }
+func retryInfoDelay(st *status.Status) (time.Duration, bool) {
+	for _, detail := range st.Proto().GetDetails() {
+		if detail.GetTypeUrl() != RETRY_INFO_TYPE_URL {
+			continue
+		}
+		var delay time.Duration
+		// a malformed detail is ignored, the delay decoded before the error is kept
+		ingester_common.WalkProtobufFields(detail.GetValue(), func(num protowire.Number, typ protowire.Type, value []byte) error {
+			if num != 1 || typ != protowire.BytesType {
+				return nil
+			}
+			return ingester_common.WalkProtobufFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				if typ != protowire.VarintType {
+					return nil
+				}
+				v, _ := protowire.ConsumeVarint(value)
+				switch num {
+				case 1:
+					delay += time.Duration(int64(v)) * time.Second
+				case 2:
+					delay += time.Duration(int32(v))
+				}
+				return nil
+			})
+		})
+		return delay, true
+	}
+	return 0, false
+}
+
+type httpSender struct {
+	url     string
+	client  *http.Client
+	headers map[string]string
+	json    bool
+	gzip    bool
+}
+
+// httpEndpointURL completes the endpoint, such as: 'collector:4318' -> 'http://collector:4318/v1/traces'
+func httpEndpointURL(endpoint string, tlsEnabled bool) (*url.URL, error) {
+	if !strings.Contains(endpoint, "://") {
+		if tlsEnabled {
+			endpoint = "https://" + endpoint
+		} else {
+			endpoint = "http://" + endpoint
+		}
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid otlp http endpoint %s: %s", endpoint, err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = OTLP_HTTP_TRACES_PATH
+	}
+	return u, nil
+}
+
+func newHttpSender(config *exporters_cfg.ExporterCfg, endpoint string) (*httpSender, error) {
+	u, err := httpEndpointURL(endpoint, config.TLS.Enabled)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.TLS.Enabled || u.Scheme == "https" {
+		tlsConfig, err := config.TLS.Load()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &httpSender{
+		url:     u.String(),
+		client:  &http.Client{Transport: transport},
+		headers: config.Headers(endpoint),
+		json:    config.OtlpProtocol == exporters_cfg.OTLP_PROTOCOL_HTTP_JSON,
+		gzip:    config.Compression == exporters_cfg.COMPRESSION_GZIP,
+	}, nil
+}
+
+func (s *httpSender) Export(ctx context.Context, req ptraceotlp.ExportRequest) error {
+	var body []byte
+	var err error
+	contentType := "application/x-protobuf"
+	if s.json {
+		body, err = req.MarshalJSON()
+		contentType = "application/json"
+	} else {
+		body, err = req.MarshalProto()
+	}
+	if err != nil {
+		return &exportError{err: err}
+	}
+	if s.gzip {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return &exportError{err: err}
+		}
+		if err := w.Close(); err != nil {
+			return &exportError{err: err}
+		}
+		body = buf.Bytes()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return &exportError{err: err}
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	if s.gzip {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range s.headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		// network failures are retryable
+		return &exportError{err: err, retryable: true}
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return httpExportError(resp.StatusCode, resp.Header.Get("Retry-After"), fmt.Errorf("otlp http export to %s returned status %s: %s", s.url, resp.Status, respBody))
+}
+
+func (s *httpSender) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// httpExportError classifies the error by the status code, as required by the OTLP specification:
+// https://opentelemetry.io/docs/specs/otlp/#failures-1
+func httpExportError(statusCode int, retryAfter string, err error) error {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		delay := parseRetryAfter(retryAfter, time.Now())
+		return &exportError{
+			err:       err,
+			retryable: true,
+			throttled: statusCode == http.StatusTooManyRequests || delay > 0,
+			delay:     delay,
+		}
+	}
+	return &exportError{err: err}
+}
+
+// parseRetryAfter parses the Retry-After header, which is either seconds or an http date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package otlp_exporter
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"golang.org/x/net/context"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"-1", 0},
+		{"invalid", 0},
+		{"Mon, 01 Jan 2024 00:00:10 GMT", 10 * time.Second},
+		{"Sun, 31 Dec 2023 23:59:50 GMT", 0},
+	}
+	for _, tc := range testCases {
+		if got := parseRetryAfter(tc.value, now); got != tc.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestRetryInfoDelay(t *testing.T) {
+	var duration, retryInfo []byte
+	duration = protowire.AppendTag(duration, 1, protowire.VarintType)
+	duration = protowire.AppendVarint(duration, 2)
+	duration = protowire.AppendTag(duration, 2, protowire.VarintType)
+	duration = protowire.AppendVarint(duration, 500000000)
+	retryInfo = protowire.AppendTag(retryInfo, 1, protowire.BytesType)
+	retryInfo = protowire.AppendBytes(retryInfo, duration)
+
+	st := status.FromProto(&spb.Status{
+		Code:    int32(codes.ResourceExhausted),
+		Message: "slow down",
+		Details: []*anypb.Any{{TypeUrl: RETRY_INFO_TYPE_URL, Value: retryInfo}},
+	})
+	err := grpcExportError(st.Err()).(*exportError)
+	if !err.retryable || !err.throttled || err.delay != 2500*time.Millisecond {
+		t.Errorf("unexpected export error %+v", err)
+	}
+
+	err = grpcExportError(status.Error(codes.ResourceExhausted, "no retry info")).(*exportError)
+	if err.retryable {
+		t.Errorf("ResourceExhausted without RetryInfo should not be retryable")
+	}
+	err = grpcExportError(status.Error(codes.Unavailable, "unavailable")).(*exportError)
+	if !err.retryable || err.throttled {
+		t.Errorf("unexpected export error %+v", err)
+	}
+}
+
+func TestHttpSender(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != OTLP_HTTP_TRACES_PATH || r.Header.Get("Authorization") != "Bearer token" ||
+			r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Content-Encoding") != "gzip" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(reader)
+		req := ptraceotlp.NewExportRequest()
+		if err := req.UnmarshalJSON(body); err != nil || req.Traces().SpanCount() != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if requests == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := &exporters_cfg.ExporterCfg{
+		Endpoints:       []string{server.URL},
+		OtlpProtocol:    exporters_cfg.OTLP_PROTOCOL_HTTP_JSON,
+		Compression:     exporters_cfg.COMPRESSION_GZIP,
+		EndpointHeaders: map[string]map[string]string{server.URL: {"Authorization": "Bearer token"}},
+	}
+	s, err := newSender(config, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	traces := ptrace.NewTraces()
+	traces.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty().SetName("test")
+	req := ptraceotlp.NewExportRequestFromTraces(traces)
+
+	err = s.Export(context.Background(), req)
+	exportErr, ok := err.(*exportError)
+	if !ok || !exportErr.retryable || !exportErr.throttled || exportErr.delay != time.Second {
+		t.Fatalf("expected throttled error, got %v", err)
+	}
+	if err := s.Export(context.Background(), req); err != nil {
+		t.Fatalf("export failed: %s", err)
+	}
+}
+
+func TestHttpEndpointURL(t *testing.T) {
+	testCases := []struct {
+		endpoint string
+		tls      bool
+		want     string
+	}{
+		{"127.0.0.1:4318", false, "http://127.0.0.1:4318/v1/traces"},
+		{"collector:4318", true, "https://collector:4318/v1/traces"},
+		{"https://collector/otlp/v1/traces", false, "https://collector/otlp/v1/traces"},
+	}
+	for _, tc := range testCases {
+		u, err := httpEndpointURL(tc.endpoint, tc.tls)
+		if err != nil || u.String() != tc.want {
+			t.Errorf("httpEndpointURL(%s) = %v %v, want %s", tc.endpoint, u, err, tc.want)
+		}
+	}
+}
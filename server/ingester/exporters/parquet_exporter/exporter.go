@@ -0,0 +1,318 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package parquet_exporter
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	logging "github.com/op/go-logging"
+
+	ingester_common "github.com/deepflowio/deepflow/server/ingester/common"
+	"github.com/deepflowio/deepflow/server/ingester/exporters/common"
+	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+	utag "github.com/deepflowio/deepflow/server/ingester/exporters/universal_tag"
+	"github.com/deepflowio/deepflow/server/ingester/ingesterctl"
+	"github.com/deepflowio/deepflow/server/libs/ckdb"
+	"github.com/deepflowio/deepflow/server/libs/debug"
+	"github.com/deepflowio/deepflow/server/libs/queue"
+	"github.com/deepflowio/deepflow/server/libs/stats"
+	"github.com/deepflowio/deepflow/server/libs/utils"
+)
+
+var log = logging.MustGetLogger("parquet_exporter")
+
+const (
+	QUEUE_BATCH_COUNT = 1024
+
+	MANIFEST_NAME_PREFIX = "_manifest"
+	SECONDS_PER_HOUR     = 3600
+)
+
+// partition is the directory of the files: $datasource/org_id=$org_id/date=$date/hour=$hour
+type partition struct {
+	dataSourceId int
+	orgID        uint16
+	hour         int64 // unix timestamp in hours
+}
+
+func (p partition) Date() string {
+	return time.Unix(p.hour*SECONDS_PER_HOUR, 0).UTC().Format("2006-01-02")
+}
+
+func (p partition) Hour() int {
+	return int(p.hour % 24)
+}
+
+func (p partition) Path() string {
+	return fmt.Sprintf("%s/org_id=%d/date=%s/hour=%02d",
+		exporters_cfg.DataSourceID(p.dataSourceId).String(), p.orgID, p.Date(), p.Hour())
+}
+
+// partitionWriter buffers the records of a partition until the file is rolled
+type partitionWriter struct {
+	schema         *common.Schema
+	writer         *parquetWriter
+	openedAt       time.Time
+	minTimestampUs int64
+	maxTimestampUs int64
+}
+
+type ParquetExporter struct {
+	index                int
+	dataQueues           queue.FixedMultiQueue
+	queueCount           int
+	universalTagsManager *utag.UniversalTagsManager
+	config               *exporters_cfg.ExporterCfg
+	storage              storage
+	manifests            *manifests
+	hostname             string
+	counter              *Counter
+	lastCounter          Counter
+	running              bool
+	wg                   sync.WaitGroup
+
+	utils.Closable
+}
+
+type Counter struct {
+	RecvCounter      int64 `statsd:"recv-count"`
+	WriteCounter     int64 `statsd:"write-count"`
+	FileCounter      int64 `statsd:"file-count"`
+	FileBytes        int64 `statsd:"file-bytes"`
+	ExportUsedTimeNs int64 `statsd:"export-used-time-ns"`
+	DropCounter      int64 `statsd:"drop-count"`
+	DropFileCounter  int64 `statsd:"drop-file-count"`
+}
+
+func (e *ParquetExporter) GetCounter() interface{} {
+	var counter Counter
+	counter, *e.counter = *e.counter, Counter{}
+	e.lastCounter = counter
+	return &counter
+}
+
+func NewParquetExporter(index int, config *exporters_cfg.ExporterCfg, universalTagsManager *utag.UniversalTagsManager) *ParquetExporter {
+	s, err := newStorage(&config.Parquet)
+	if err != nil {
+		log.Errorf("parquet exporter %d init storage failed: %s", index, err)
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "deepflow-server"
+	}
+
+	dataQueues := queue.NewOverwriteQueues(
+		fmt.Sprintf("parquet_exporter_%d", index), queue.HashKey(config.QueueCount), config.QueueSize,
+		queue.OptionFlushIndicator(time.Second),
+		queue.OptionRelease(func(p interface{}) { p.(common.ExportItem).Release() }),
+		ingester_common.QUEUE_STATS_MODULE_INGESTER)
+
+	exporter := &ParquetExporter{
+		index:                index,
+		dataQueues:           dataQueues,
+		queueCount:           config.QueueCount,
+		universalTagsManager: universalTagsManager,
+		config:               config,
+		storage:              s,
+		// each ingester writes its own manifest, as multiple ingesters may write the same partition
+		manifests: newManifests(s, MANIFEST_NAME_PREFIX+"."+hostname+".json", config.Compression),
+		hostname:  hostname,
+		counter:   &Counter{},
+	}
+	debug.ServerRegisterSimple(ingesterctl.CMD_PARQUET_EXPORTER, exporter)
+	ingester_common.RegisterCountableForIngester("exporter", exporter, stats.OptionStatTags{
+		"type": "parquet", "index": strconv.Itoa(index)})
+	log.Infof("parquet exporter %d created, storage: %s", index, s)
+	return exporter
+}
+
+func (e *ParquetExporter) Put(items ...interface{}) {
+	e.counter.RecvCounter++
+	e.dataQueues.Put(queue.HashKey(int(e.counter.RecvCounter)%e.queueCount), items...)
+}
+
+func (e *ParquetExporter) Start() {
+	if e.running {
+		log.Warningf("parquet exporter %d already running", e.index)
+		return
+	}
+	e.running = true
+	for i := 0; i < e.queueCount; i++ {
+		e.wg.Add(1)
+		go e.queueProcess(int(i))
+	}
+	log.Infof("parquet exporter %d started %d queue", e.index, e.queueCount)
+}
+
+// Close stops the queues and waits for the buffered files being written
+func (e *ParquetExporter) Close() {
+	e.Closable.Close()
+	e.running = false
+	log.Infof("parquet exporter %d stopping", e.index)
+	e.wg.Wait()
+	log.Infof("parquet exporter %d stopped", e.index)
+}
+
+func (e *ParquetExporter) orgID(item common.ExportItem, dataSourceId uint32) uint16 {
+	structTags := e.config.OrgIDStructTags[dataSourceId]
+	if structTags == nil {
+		return ckdb.DEFAULT_ORG_ID
+	}
+	if orgID, ok := item.GetFieldValueByOffsetAndKind(structTags.Offset, structTags.DataKind, structTags.DataType).(uint16); ok && orgID != 0 {
+		return orgID
+	}
+	return ckdb.DEFAULT_ORG_ID
+}
+
+func (e *ParquetExporter) queueProcess(queueID int) {
+	defer e.wg.Done()
+	items := make([]interface{}, QUEUE_BATCH_COUNT)
+	writers := make(map[partition]*partitionWriter)
+	schemas := [exporters_cfg.MAX_DATASOURCE_ID]*common.Schema{}
+	maxFileSize := e.config.Parquet.MaxFileSize << 20
+	maxFileDuration := time.Duration(e.config.Parquet.MaxFileDuration) * time.Second
+
+	for e.running {
+		n := e.dataQueues.Gets(queue.HashKey(queueID), items)
+		for _, item := range items[:n] {
+			if item == nil {
+				now := time.Now()
+				for p, w := range writers {
+					if now.Sub(w.openedAt) >= maxFileDuration {
+						e.writeFile(queueID, p, w)
+						delete(writers, p)
+					}
+				}
+				continue
+			}
+			exportItem, ok := item.(common.ExportItem)
+			if !ok {
+				e.counter.DropCounter++
+				continue
+			}
+			dataSourceId := exportItem.DataSource()
+			encoded, err := exportItem.EncodeTo(exporters_cfg.PROTOCOL_PARQUET, e.universalTagsManager, e.config)
+			record, _ := encoded.(*common.Record)
+			if err != nil || record == nil {
+				if e.counter.DropCounter == 0 {
+					log.Warningf("parquet exporter encode failed. err: %v", err)
+				}
+				e.counter.DropCounter++
+				exportItem.Release()
+				continue
+			}
+
+			timestampUs := exportItem.TimestampUs()
+			p := partition{
+				dataSourceId: int(dataSourceId),
+				orgID:        e.orgID(exportItem, dataSourceId),
+				hour:         timestampUs / int64(time.Hour/time.Microsecond),
+			}
+			exportItem.Release()
+
+			w := writers[p]
+			if w == nil {
+				if schemas[dataSourceId] == nil {
+					schemas[dataSourceId] = common.NewSchema(int(dataSourceId), e.config)
+				}
+				writer, err := newParquetWriter(schemas[dataSourceId], e.config.Compression)
+				if err != nil {
+					if e.counter.DropCounter == 0 {
+						log.Warningf("parquet exporter new writer failed. err: %s", err)
+					}
+					e.counter.DropCounter++
+					continue
+				}
+				w = &partitionWriter{
+					schema:         schemas[dataSourceId],
+					writer:         writer,
+					openedAt:       time.Now(),
+					minTimestampUs: timestampUs,
+					maxTimestampUs: timestampUs,
+				}
+				writers[p] = w
+			}
+			if err := w.writer.Append(record); err != nil {
+				if e.counter.DropCounter == 0 {
+					log.Warningf("parquet exporter append record failed. err: %s", err)
+				}
+				e.counter.DropCounter++
+				continue
+			}
+			if timestampUs < w.minTimestampUs {
+				w.minTimestampUs = timestampUs
+			}
+			if timestampUs > w.maxTimestampUs {
+				w.maxTimestampUs = timestampUs
+			}
+			if w.writer.BufferedSize() >= maxFileSize {
+				e.writeFile(queueID, p, w)
+				delete(writers, p)
+			}
+		}
+	}
+
+	for p, w := range writers {
+		e.writeFile(queueID, p, w)
+	}
+}
+
+// writeFile writes the buffered rows of the partition as a parquet file, and adds it to the manifest
+func (e *ParquetExporter) writeFile(queueID int, p partition, w *partitionWriter) {
+	rows := w.writer.Rows()
+	if rows == 0 {
+		return
+	}
+	now := time.Now()
+	data, err := w.writer.Bytes()
+	if err != nil {
+		log.Warningf("parquet exporter %d encode file of %s failed: %s", e.index, p.Path(), err)
+		e.counter.DropCounter += rows
+		e.counter.DropFileCounter++
+		return
+	}
+
+	file := ManifestFile{
+		Name:           fmt.Sprintf("part-%s-%d-%d-%d.parquet", e.hostname, e.index, queueID, now.UnixNano()),
+		Rows:           rows,
+		Size:           len(data),
+		MinTimestampUs: w.minTimestampUs,
+		MaxTimestampUs: w.maxTimestampUs,
+		CreatedAt:      now.UTC().Format(time.RFC3339),
+	}
+	if err := e.storage.Put(p.Path()+"/"+file.Name, data); err != nil {
+		log.Warningf("parquet exporter %d write file %s/%s failed: %s", e.index, p.Path(), file.Name, err)
+		e.counter.DropCounter += rows
+		e.counter.DropFileCounter++
+		return
+	}
+	if err := e.manifests.AddFile(p, w.schema, file); err != nil {
+		log.Warningf("parquet exporter %d write manifest of %s failed: %s", e.index, p.Path(), err)
+	}
+	e.counter.WriteCounter += rows
+	e.counter.FileCounter++
+	e.counter.FileBytes += int64(len(data))
+	e.counter.ExportUsedTimeNs += int64(time.Since(now))
+}
+
+func (e *ParquetExporter) HandleSimpleCommand(op uint16, arg string) string {
+	return fmt.Sprintf("parquet exporter %d last 10s counter: %+v", e.index, e.lastCounter)
+}
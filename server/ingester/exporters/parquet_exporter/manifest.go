@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package parquet_exporter
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/ingester/exporters/common"
+)
+
+const (
+	MANIFEST_CACHE_TIMEOUT = 2 * time.Hour
+)
+
+var fieldTypeNames = []string{
+	common.FIELD_TYPE_STRING:        "string",
+	common.FIELD_TYPE_INT64:         "int64",
+	common.FIELD_TYPE_UINT64:        "uint64",
+	common.FIELD_TYPE_FLOAT64:       "double",
+	common.FIELD_TYPE_BOOL:          "bool",
+	common.FIELD_TYPE_STRING_ARRAY:  "array<string>",
+	common.FIELD_TYPE_FLOAT64_ARRAY: "array<double>",
+	common.FIELD_TYPE_TIMESTAMP_US:  "timestamp_us",
+}
+
+type ManifestField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type ManifestFile struct {
+	Name           string `json:"name"`
+	Rows           int64  `json:"rows"`
+	Size           int    `json:"size"`
+	MinTimestampUs int64  `json:"min_timestamp_us"`
+	MaxTimestampUs int64  `json:"max_timestamp_us"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// Manifest lists the files of a partition written by the ingester, and the schema of the latest file
+type Manifest struct {
+	DataSource  string          `json:"datasource"`
+	OrgID       uint16          `json:"org_id"`
+	Date        string          `json:"date"`
+	Hour        int             `json:"hour"`
+	Compression string          `json:"compression"`
+	Schema      []ManifestField `json:"schema"`
+	Files       []ManifestFile  `json:"files"`
+
+	lastUpdated time.Time
+}
+
+func manifestSchema(schema *common.Schema) []ManifestField {
+	fields := make([]ManifestField, 0, len(schema.Fields))
+	for _, f := range schema.Fields {
+		fields = append(fields, ManifestField{Name: f.Name, Type: fieldTypeNames[f.Type]})
+	}
+	return fields
+}
+
+// manifests is shared by all queues of the exporter
+type manifests struct {
+	storage      storage
+	manifestName string
+	compression  string
+
+	sync.Mutex
+	cache map[partition]*Manifest
+}
+
+func newManifests(s storage, manifestName, compression string) *manifests {
+	return &manifests{
+		storage:      s,
+		manifestName: manifestName,
+		compression:  compression,
+		cache:        make(map[partition]*Manifest),
+	}
+}
+
+// AddFile appends the file to the manifest of the partition and writes the manifest. The manifest already written
+// in the storage is loaded first, so that the files written before restarting are kept.
+func (m *manifests) AddFile(p partition, schema *common.Schema, file ManifestFile) error {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	for k, v := range m.cache {
+		if now.Sub(v.lastUpdated) > MANIFEST_CACHE_TIMEOUT {
+			delete(m.cache, k)
+		}
+	}
+
+	name := p.Path() + "/" + m.manifestName
+	manifest := m.cache[p]
+	if manifest == nil {
+		manifest = &Manifest{}
+		if data, err := m.storage.Get(name); err == nil {
+			if err := json.Unmarshal(data, manifest); err != nil {
+				log.Warningf("invalid manifest %s, it will be overwritten: %s", name, err)
+				manifest = &Manifest{}
+			}
+		} else if err != errNotExist {
+			log.Warningf("read manifest %s failed: %s", name, err)
+		}
+		manifest.DataSource, manifest.OrgID = schema.Name, p.orgID
+		manifest.Date, manifest.Hour = p.Date(), p.Hour()
+		m.cache[p] = manifest
+	}
+	manifest.Compression = m.compression
+	manifest.Schema = manifestSchema(schema)
+	manifest.Files = append(manifest.Files, file)
+	manifest.lastUpdated = now
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return m.storage.Put(name, data)
+}
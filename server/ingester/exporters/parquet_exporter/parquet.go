@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package parquet_exporter
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+
+	"github.com/deepflowio/deepflow/server/ingester/exporters/common"
+	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+)
+
+const (
+	PARQUET_CREATED_BY = "deepflow parquet exporter"
+
+	KEY_DATASOURCE = "deepflow.datasource"
+)
+
+type parquetColumn struct {
+	valueIdx    int
+	columnIndex int
+	kind        parquet.Kind
+	repeated    bool
+}
+
+// parquetWriter buffers the records as parquet rows, and writes them as a parquet file
+type parquetWriter struct {
+	columns []parquetColumn
+	writer  *parquet.Writer
+	buffer  bytes.Buffer
+	row     parquet.Row
+	rows    int64
+	size    int
+}
+
+func parquetCodec(compression string) (compress.Codec, error) {
+	switch compression {
+	case exporters_cfg.COMPRESSION_SNAPPY:
+		return &parquet.Snappy, nil
+	case exporters_cfg.COMPRESSION_GZIP:
+		return &parquet.Gzip, nil
+	case exporters_cfg.COMPRESSION_ZSTD:
+		return &parquet.Zstd, nil
+	case exporters_cfg.COMPRESSION_NONE, exporters_cfg.COMPRESSION_DISABLED:
+		return &parquet.Uncompressed, nil
+	}
+	return nil, fmt.Errorf("unsupported parquet compression %s", compression)
+}
+
+func parquetNode(fieldType common.FieldType) parquet.Node {
+	switch fieldType {
+	case common.FIELD_TYPE_INT64:
+		return parquet.Optional(parquet.Int(64))
+	case common.FIELD_TYPE_UINT64:
+		return parquet.Optional(parquet.Uint(64))
+	case common.FIELD_TYPE_TIMESTAMP_US:
+		return parquet.Optional(parquet.Timestamp(parquet.Microsecond))
+	case common.FIELD_TYPE_FLOAT64:
+		return parquet.Optional(parquet.Leaf(parquet.DoubleType))
+	case common.FIELD_TYPE_BOOL:
+		return parquet.Optional(parquet.Leaf(parquet.BooleanType))
+	case common.FIELD_TYPE_STRING_ARRAY:
+		return parquet.Repeated(parquet.String())
+	case common.FIELD_TYPE_FLOAT64_ARRAY:
+		return parquet.Repeated(parquet.Leaf(parquet.DoubleType))
+	}
+	return parquet.Optional(parquet.String())
+}
+
+func newParquetWriter(schema *common.Schema, compression string) (*parquetWriter, error) {
+	codec, err := parquetCodec(compression)
+	if err != nil {
+		return nil, err
+	}
+	group := make(parquet.Group, len(schema.Fields))
+	for _, f := range schema.Fields {
+		group[f.Name] = parquetNode(f.Type)
+	}
+	parquetSchema := parquet.NewSchema(schema.Name, group)
+
+	w := &parquetWriter{columns: make([]parquetColumn, 0, len(schema.Fields))}
+	for _, f := range schema.Fields {
+		leaf, _ := parquetSchema.Lookup(f.Name)
+		w.columns = append(w.columns, parquetColumn{
+			valueIdx:    f.ValueIndex,
+			columnIndex: leaf.ColumnIndex,
+			kind:        leaf.Node.Type().Kind(),
+			repeated:    leaf.MaxRepetitionLevel > 0,
+		})
+	}
+	// the values of a row are ordered by the columns, which are sorted by name in the parquet schema
+	sort.Slice(w.columns, func(i, j int) bool { return w.columns[i].columnIndex < w.columns[j].columnIndex })
+	w.writer = parquet.NewWriter(&w.buffer, parquetSchema,
+		parquet.Compression(codec),
+		parquet.CreatedBy(PARQUET_CREATED_BY, "", ""),
+		parquet.KeyValueMetadata(KEY_DATASOURCE, schema.Name),
+	)
+	return w, nil
+}
+
+// parquetValue converts the value to the kind of the column, returns false if it is nil or mismatched
+func parquetValue(kind parquet.Kind, value interface{}) (parquet.Value, int, bool) {
+	switch v := value.(type) {
+	case string:
+		if kind == parquet.ByteArray {
+			return parquet.ByteArrayValue([]byte(v)), len(v) + 4, true
+		}
+	case int64:
+		if kind == parquet.Int64 {
+			return parquet.Int64Value(v), 8, true
+		}
+	case uint64:
+		if kind == parquet.Int64 {
+			return parquet.Int64Value(int64(v)), 8, true
+		}
+	case float64:
+		if kind == parquet.Double {
+			return parquet.DoubleValue(v), 8, true
+		}
+	case bool:
+		if kind == parquet.Boolean {
+			return parquet.BooleanValue(v), 1, true
+		}
+	}
+	return parquet.Value{}, 0, false
+}
+
+// Append appends a record as a row, the nil or mismatched values are written as null
+func (w *parquetWriter) Append(r *common.Record) error {
+	row := w.row[:0]
+	for i := range w.columns {
+		c := &w.columns[i]
+		value := r.Values[c.valueIdx]
+		if !c.repeated {
+			if v, size, ok := parquetValue(c.kind, value); ok {
+				row = append(row, v.Level(0, 1, c.columnIndex))
+				w.size += size
+			} else {
+				row = append(row, parquet.NullValue().Level(0, 0, c.columnIndex))
+			}
+			continue
+		}
+
+		n := 0
+		switch values := value.(type) {
+		case []string:
+			for _, s := range values {
+				v, size, _ := parquetValue(c.kind, s)
+				row = append(row, v.Level(min(n, 1), 1, c.columnIndex))
+				w.size += size
+				n++
+			}
+		case []float64:
+			for _, f := range values {
+				v, size, _ := parquetValue(c.kind, f)
+				row = append(row, v.Level(min(n, 1), 1, c.columnIndex))
+				w.size += size
+				n++
+			}
+		}
+		if n == 0 {
+			row = append(row, parquet.NullValue().Level(0, 0, c.columnIndex))
+		}
+	}
+	w.row = row
+	if _, err := w.writer.WriteRows([]parquet.Row{row}); err != nil {
+		return err
+	}
+	w.rows++
+	return nil
+}
+
+func (w *parquetWriter) Rows() int64 {
+	return w.rows
+}
+
+// BufferedSize is the estimated uncompressed size of the buffered rows
+func (w *parquetWriter) BufferedSize() int {
+	return w.size
+}
+
+// Bytes writes the footer and returns the parquet file, the writer can not be appended after that
+func (w *parquetWriter) Bytes() ([]byte, error) {
+	if err := w.writer.Close(); err != nil {
+		return nil, err
+	}
+	return w.buffer.Bytes(), nil
+}
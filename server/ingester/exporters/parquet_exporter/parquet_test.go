@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package parquet_exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/deepflowio/deepflow/server/ingester/exporters/common"
+	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+)
+
+func testSchema() *common.Schema {
+	return &common.Schema{
+		Name: "flow_log.l7_flow_log",
+		Fields: []common.SchemaField{
+			{Name: "datasource", Type: common.FIELD_TYPE_STRING, ValueIndex: 0},
+			{Name: "flow_id", Type: common.FIELD_TYPE_UINT64, ValueIndex: 1},
+			{Name: "rrt", Type: common.FIELD_TYPE_FLOAT64, ValueIndex: 2},
+			{Name: "k8s_label_names", Type: common.FIELD_TYPE_STRING_ARRAY, ValueIndex: 3},
+			{Name: "timestamp_us", Type: common.FIELD_TYPE_TIMESTAMP_US, ValueIndex: 4},
+		},
+	}
+}
+
+// parquetTestRow is the row of testSchema read back by the parquet library
+type parquetTestRow struct {
+	Datasource    *string  `parquet:"datasource,optional"`
+	FlowID        *uint64  `parquet:"flow_id,optional"`
+	RRT           *float64 `parquet:"rrt,optional"`
+	K8sLabelNames []string `parquet:"k8s_label_names"`
+	TimestampUs   int64    `parquet:"timestamp_us,optional,timestamp(microsecond)"`
+}
+
+func TestParquetWriter(t *testing.T) {
+	schema := testSchema()
+	datasource, flowID, rrt := "flow_log.l7_flow_log", uint64(1), 1.5
+	expected := []parquetTestRow{
+		{Datasource: &datasource, FlowID: &flowID, RRT: &rrt, K8sLabelNames: []string{"app", "env"}, TimestampUs: 100},
+		{Datasource: &datasource, K8sLabelNames: []string{}, TimestampUs: 200},
+	}
+	for _, compression := range []string{"none", "snappy", "gzip", "zstd"} {
+		w, err := newParquetWriter(schema, compression)
+		if err != nil {
+			t.Fatalf("new parquet writer with %s failed: %s", compression, err)
+		}
+		for _, values := range [][]interface{}{
+			{"flow_log.l7_flow_log", uint64(1), 1.5, []string{"app", "env"}, int64(100)},
+			{"flow_log.l7_flow_log", nil, "mismatched", nil, int64(200)},
+		} {
+			if err := w.Append(&common.Record{Values: values}); err != nil {
+				t.Fatalf("append with %s failed: %s", compression, err)
+			}
+		}
+		if w.Rows() != 2 || w.BufferedSize() == 0 {
+			t.Errorf("unexpected rows %d, buffered size %d", w.Rows(), w.BufferedSize())
+		}
+		data, err := w.Bytes()
+		if err != nil {
+			t.Fatalf("encode parquet with %s failed: %s", compression, err)
+		}
+
+		file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("open parquet with %s failed: %s", compression, err)
+		}
+		if value, _ := file.Lookup(KEY_DATASOURCE); file.NumRows() != 2 || value != schema.Name {
+			t.Errorf("unexpected file with %s, rows %d, datasource %s", compression, file.NumRows(), value)
+		}
+		if leaf, ok := file.Schema().Lookup("k8s_label_names"); !ok || leaf.MaxRepetitionLevel != 1 {
+			t.Errorf("k8s_label_names should be repeated with %s", compression)
+		}
+		rows, err := parquet.Read[parquetTestRow](bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("read parquet with %s failed: %s", compression, err)
+		}
+		if !reflect.DeepEqual(rows, expected) {
+			t.Errorf("rows with %s got %+v, expect %+v", compression, rows, expected)
+		}
+	}
+}
+
+func TestManifests(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := newStorage(&exporters_cfg.ParquetConfig{Directory: dir})
+	p := partition{dataSourceId: int(exporters_cfg.L7_FLOW_LOG), orgID: 1, hour: 1700000000 / SECONDS_PER_HOUR}
+	if p.Path() != "flow_log.l7_flow_log/org_id=1/date=2023-11-14/hour=22" {
+		t.Errorf("unexpected partition path %s", p.Path())
+	}
+
+	schema := testSchema()
+	m := newManifests(s, "_manifest.test.json", "snappy")
+	if err := m.AddFile(p, schema, ManifestFile{Name: "part-1.parquet", Rows: 2}); err != nil {
+		t.Fatalf("add file failed: %s", err)
+	}
+	// a restarted exporter keeps the files in the manifest
+	m = newManifests(s, "_manifest.test.json", "snappy")
+	if err := m.AddFile(p, schema, ManifestFile{Name: "part-2.parquet", Rows: 3}); err != nil {
+		t.Fatalf("add file failed: %s", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(p.Path()), "_manifest.test.json"))
+	if err != nil {
+		t.Fatalf("read manifest failed: %s", err)
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		t.Fatalf("invalid manifest %s: %s", data, err)
+	}
+	if manifest.DataSource != schema.Name || manifest.Date != "2023-11-14" || manifest.Hour != 22 ||
+		len(manifest.Files) != 2 || manifest.Files[1].Name != "part-2.parquet" ||
+		len(manifest.Schema) != len(schema.Fields) || manifest.Schema[3].Type != "array<string>" {
+		t.Errorf("unexpected manifest %s", data)
+	}
+	if _, err := s.Get("not-exist"); err != errNotExist {
+		t.Errorf("get not exist file got %v", err)
+	}
+}
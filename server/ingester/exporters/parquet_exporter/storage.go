@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package parquet_exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+)
+
+var errNotExist = errors.New("object not exist")
+
+// storage stores the files by the relative path, such as 'flow_log.l7_flow_log/org_id=1/date=2024-01-01/hour=00/xxx.parquet'
+type storage interface {
+	Put(name string, data []byte) error
+	// Get returns errNotExist if the file does not exist
+	Get(name string) ([]byte, error)
+	String() string
+}
+
+func newStorage(config *exporters_cfg.ParquetConfig) (storage, error) {
+	if config.S3.Bucket != "" {
+		return newS3Storage(&config.S3)
+	}
+	return &localStorage{directory: config.Directory}, nil
+}
+
+type localStorage struct {
+	directory string
+}
+
+// Put writes to a temporary file and renames it, so that readers never see partial files
+func (s *localStorage) Put(name string, data []byte) error {
+	filename := filepath.Join(s.directory, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	tmpFilename := filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err := os.WriteFile(tmpFilename, data, 0644); err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+func (s *localStorage) Get(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.directory, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil, errNotExist
+	}
+	return data, err
+}
+
+func (s *localStorage) String() string {
+	return "file://" + s.directory
+}
+
+type s3Storage struct {
+	config *exporters_cfg.S3Config
+	client *s3.Client
+}
+
+func newS3Storage(config *exporters_cfg.S3Config) (*s3Storage, error) {
+	options := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(config.Region)}
+	if config.AccessKey != "" {
+		options = append(options, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(config.AccessKey, config.SecretKey, "")))
+	}
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.TODO(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("load s3 config failed: %s", err)
+	}
+	client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.UsePathStyle = config.ForcePathStyle
+		if config.Endpoint != "" {
+			o.EndpointResolver = s3.EndpointResolverFromURL(config.Endpoint)
+		}
+	})
+	return &s3Storage{config: config, client: client}, nil
+}
+
+func (s *s3Storage) key(name string) string {
+	return path.Join(strings.Trim(s.config.Prefix, "/"), name)
+}
+
+func (s *s3Storage) Put(name string, data []byte) error {
+	_, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.key(name)),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (s *s3Storage) Get(name string) ([]byte, error) {
+	output, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, errNotExist
+		}
+		return nil, err
+	}
+	defer output.Body.Close()
+	return io.ReadAll(output.Body)
+}
+
+func (s *s3Storage) String() string {
+	return "s3://" + s.config.Bucket + "/" + strings.Trim(s.config.Prefix, "/")
+}
@@ -33,6 +33,7 @@ import (
 	ingester_common "github.com/deepflowio/deepflow/server/ingester/common"
 	"github.com/deepflowio/deepflow/server/ingester/exporters/common"
 	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+	"github.com/deepflowio/deepflow/server/ingester/exporters/retry_queue"
 	utag "github.com/deepflowio/deepflow/server/ingester/exporters/universal_tag"
 	"github.com/deepflowio/deepflow/server/ingester/ingesterctl"
 	"github.com/deepflowio/deepflow/server/libs/debug"
@@ -56,7 +57,8 @@ type PrometheusExporter struct {
 	Addr                  string
 	dataQueues            queue.FixedMultiQueue
 	queueCount            int
-	requestFailedCounters []int
+	requestFailedCounters []int // the last one is used by the retry queue
+	retryQueue            *retry_queue.RetryQueue
 
 	universalTagsManager *utag.UniversalTagsManager
 	config               *exporters_cfg.ExporterCfg
@@ -74,11 +76,16 @@ type Counter struct {
 	DropCounter      int64 `statsd:"drop-count"`
 	DropBatchCounter int64 `statsd:"drop-batch-count"`
 	ExportUsedTimeNs int64 `statsd:"export-used-time-ns"`
+
+	retry_queue.Counter
 }
 
 func (e *PrometheusExporter) GetCounter() interface{} {
 	var counter Counter
 	counter, *e.counter = *e.counter, Counter{}
+	if e.retryQueue != nil {
+		counter.Counter = e.retryQueue.SwapCounter()
+	}
 	e.lastCounter = counter
 	return &counter
 }
@@ -95,13 +102,21 @@ func NewPrometheusExporter(index int, config *exporters_cfg.ExporterCfg, univers
 		index:                 index,
 		dataQueues:            dataQueues,
 		queueCount:            config.QueueCount,
-		requestFailedCounters: make([]int, config.QueueCount),
+		requestFailedCounters: make([]int, config.QueueCount+1),
 		universalTagsManager:  universalTagsManager,
 		config:                config,
 		counter:               &Counter{},
 		ctx:                   ctx,
 		cancel:                cancel,
 	}
+	if config.RetryQueue.Enabled {
+		retryQueue, err := retry_queue.NewRetryQueue(fmt.Sprintf("prometheus-%d", index), &config.RetryQueue, exporter.retrySend)
+		if err != nil {
+			log.Errorf("promethues exporter %d init retry queue failed, the failed requests will be dropped: %s", index, err)
+		} else {
+			exporter.retryQueue = retryQueue
+		}
+	}
 	debug.ServerRegisterSimple(ingesterctl.CMD_PROMETHEUS_EXPORTER, exporter)
 	ingester_common.RegisterCountableForIngester("exporter", exporter, stats.OptionStatTags{
 		"type": "promethues", "index": strconv.Itoa(index)})
@@ -111,7 +126,11 @@ func NewPrometheusExporter(index int, config *exporters_cfg.ExporterCfg, univers
 
 func (e *PrometheusExporter) Put(items ...interface{}) {
 	e.counter.RecvCounter++
-	e.dataQueues.Put(queue.HashKey(int(e.counter.RecvCounter)%e.queueCount), items...)
+	key := queue.HashKey(int(e.counter.RecvCounter) % e.queueCount)
+	if e.retryQueue != nil {
+		e.retryQueue.WaitForRoom(e.dataQueues, key, e.config.QueueSize, len(items))
+	}
+	e.dataQueues.Put(key, items...)
 }
 
 func (e *PrometheusExporter) Start() {
@@ -128,8 +147,11 @@ func (e *PrometheusExporter) Start() {
 
 func (e *PrometheusExporter) Close() {
 	e.running = false
-	e.Close()
+	e.Closable.Close()
 	e.cancel()
+	if e.retryQueue != nil {
+		e.retryQueue.Close()
+	}
 	log.Infof("promethues exporter %d stopping", e.index)
 }
 
@@ -143,12 +165,22 @@ func (e *PrometheusExporter) queueProcess(queueID int) {
 			return
 		}
 		now := time.Now()
-		if err := e.sendRequest(queueID, batchs); err != nil {
+		data, err := encodeRequest(batchs)
+		if err == nil && e.spill(queueID, data) {
+			batchs = batchs[:0]
+			return
+		}
+		if err == nil {
+			err = e.sendRequest(queueID, data)
+		}
+		if err != nil {
 			if e.counter.DropCounter == 0 {
 				log.Warningf("failed to send promrw request,requestFaildCounter=%d, err: %v", e.requestFailedCounters[queueID], err)
 			}
-			e.counter.DropCounter += int64(batchCount)
-			e.counter.DropBatchCounter++
+			if !e.saveFailed(data, err) {
+				e.counter.DropCounter += int64(batchCount)
+				e.counter.DropBatchCounter++
+			}
 		} else {
 			e.counter.SendCounter += int64(batchCount)
 			e.counter.SendBatchCounter++
@@ -199,15 +231,45 @@ func (e *PrometheusExporter) getEndpont(queueID int) string {
 	return e.config.RandomEndpoints[e.requestFailedCounters[queueID]%l]
 }
 
-func (e *PrometheusExporter) sendRequest(queueID int, batchs []prompb.TimeSeries) error {
+// encodeRequest returns the snappy compressed remote write request
+func encodeRequest(batchs []prompb.TimeSeries) ([]byte, error) {
 	wr := &prompb.WriteRequest{Timeseries: batchs}
 	data, err := proto.Marshal(wr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	buf := make([]byte, len(data), cap(data))
-	compressedData := snappy.Encode(buf, data)
+	return snappy.Encode(buf, data), nil
+}
+
+// spill puts the request to the retry queue without sending it if the queue is backlogged, returns true if spilled
+func (e *PrometheusExporter) spill(queueID int, data []byte) bool {
+	if e.retryQueue == nil || !e.retryQueue.Backlogged(e.dataQueues, queue.HashKey(queueID), e.config.QueueSize) {
+		return false
+	}
+	return e.retryQueue.Spill(data) == nil
+}
+
+// saveFailed puts the failed request to the retry queue, or to the dead-letter directory if it is poisoned,
+// returns false if the request is dropped
+func (e *PrometheusExporter) saveFailed(data []byte, err error) bool {
+	if e.retryQueue == nil || data == nil {
+		return false
+	}
+	if retry_queue.IsPermanent(err) {
+		err = e.retryQueue.DeadLetter(data, err)
+	} else {
+		err = e.retryQueue.Put(data)
+	}
+	return err == nil
+}
+
+// retrySend sends the request read from the retry queue, by the last endpoint selector of 'requestFailedCounters'
+func (e *PrometheusExporter) retrySend(data []byte) error {
+	return e.sendRequest(e.queueCount, data)
+}
 
+func (e *PrometheusExporter) sendRequest(queueID int, compressedData []byte) error {
 	endpoint := e.getEndpont(queueID)
 	req, err := http.NewRequestWithContext(e.ctx, "POST", endpoint, bytes.NewReader(compressedData))
 	if err != nil {
@@ -239,7 +301,12 @@ func (e *PrometheusExporter) sendRequest(queueID int, batchs []prompb.TimeSeries
 	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
 	if resp.StatusCode >= 400 {
 		e.requestFailedCounters[queueID]++
-		return fmt.Errorf("remote write returned HTTP status %v; err = %s: %s", resp.Status, err, body)
+		err = fmt.Errorf("remote write returned HTTP status %v; err = %s: %s", resp.Status, err, body)
+		// the 4xx errors except 429 (too many requests) are caused by the request, retrying will not succeed
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return retry_queue.Permanent(err)
+		}
+		return err
 	}
 
 	return nil
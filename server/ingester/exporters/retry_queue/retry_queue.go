@@ -0,0 +1,495 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package retry_queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logging "github.com/op/go-logging"
+
+	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+	"github.com/deepflowio/deepflow/server/libs/queue"
+)
+
+var log = logging.MustGetLogger("exporters.retry_queue")
+
+const (
+	BATCH_FILE_SUFFIX = ".batch"
+	ERROR_FILE_SUFFIX = ".error"
+	TMP_FILE_SUFFIX   = ".tmp"
+
+	// header: magic(4B) + crc32 of data(4B) + attempts(4B)
+	HEADER_SIZE     = 12
+	HEADER_MAGIC    = "DFRQ"
+	ATTEMPTS_OFFSET = 8
+
+	IDLE_CHECK_INTERVAL = time.Second
+	PUT_WAIT_INTERVAL   = 10 * time.Millisecond
+	PUT_WAIT_TIMEOUT    = 100 * time.Millisecond
+)
+
+var (
+	ErrQueueFull   = errors.New("retry queue is full")
+	errInvalidFile = errors.New("invalid batch file")
+)
+
+// permanentError marks the batch poisoned, it will be moved to the dead-letter directory without retrying
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps the error returned by SendFunc, if the batch will never be sent successfully
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var e *permanentError
+	return errors.As(err, &e)
+}
+
+// SendFunc sends the batch read from the disk, it is called by the retrying goroutine only
+type SendFunc func(data []byte) error
+
+// Counter is embedded in the counters of the exporters
+type Counter struct {
+	InBatchCounter         int64 `statsd:"retry-queue-in-batch-count"`
+	RetryCounter           int64 `statsd:"retry-queue-retry-count"`
+	SuccessBatchCounter    int64 `statsd:"retry-queue-success-batch-count"`
+	DropBatchCounter       int64 `statsd:"retry-queue-drop-batch-count"`
+	DeadLetterBatchCounter int64 `statsd:"dead-letter-batch-count"`
+	SpillBatchCounter      int64 `statsd:"retry-queue-spill-batch-count"`
+	LagBatches             int64 `statsd:"retry-queue-lag-batches"`
+	LagBytes               int64 `statsd:"retry-queue-lag-bytes"`
+	LagSeconds             int64 `statsd:"retry-queue-lag-seconds"` // age of the oldest batch
+	PutBlockedTimeNs       int64 `statsd:"put-blocked-time-ns"`
+}
+
+type batchFile struct {
+	name      string
+	size      int64
+	createdAt time.Time
+}
+
+// RetryQueue is the bounded disk queue of an exporter, each failed batch is a file named by its sequence,
+// so the batches are retried in order and kept after restarting.
+type RetryQueue struct {
+	name                string
+	directory           string
+	deadLetterDirectory string
+	config              *exporters_cfg.RetryQueueConfig
+	send                SendFunc
+
+	mutex          sync.Mutex
+	files          []batchFile
+	size           int64
+	deadLetterSize int64
+	nextSeq        uint64
+
+	counter Counter
+	notify  chan struct{}
+	closeCh chan struct{}
+	closed  int32
+	wg      sync.WaitGroup
+}
+
+// NewRetryQueue loads the batches left by the last running from '$directory/$name', and starts retrying them
+func NewRetryQueue(name string, config *exporters_cfg.RetryQueueConfig, send SendFunc) (*RetryQueue, error) {
+	q := &RetryQueue{
+		name:                name,
+		directory:           filepath.Join(config.Directory, name),
+		deadLetterDirectory: filepath.Join(config.DeadLetterDirectory, name),
+		config:              config,
+		send:                send,
+		notify:              make(chan struct{}, 1),
+		closeCh:             make(chan struct{}),
+	}
+	for _, dir := range []string{q.directory, q.deadLetterDirectory} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	if len(q.files) > 0 {
+		log.Infof("retry queue %s loaded %d batches, %d bytes", name, len(q.files), q.size)
+	}
+
+	q.wg.Add(1)
+	go q.run()
+	return q, nil
+}
+
+func parseSeq(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, BATCH_FILE_SUFFIX) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(name, BATCH_FILE_SUFFIX), 10, 64)
+	return seq, err == nil
+}
+
+func (q *RetryQueue) load() error {
+	entries, err := os.ReadDir(q.directory)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if strings.HasSuffix(name, TMP_FILE_SUFFIX) {
+			// partial file written before crashing
+			os.Remove(filepath.Join(q.directory, name))
+			continue
+		}
+		seq, ok := parseSeq(name)
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		q.files = append(q.files, batchFile{name: name, size: info.Size(), createdAt: info.ModTime()})
+		q.size += info.Size()
+		if seq >= q.nextSeq {
+			q.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(q.files, func(i, j int) bool {
+		seqI, _ := parseSeq(q.files[i].name)
+		seqJ, _ := parseSeq(q.files[j].name)
+		return seqI < seqJ
+	})
+
+	entries, err = os.ReadDir(q.deadLetterDirectory)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			q.deadLetterSize += info.Size()
+		}
+		// the batches written to the dead-letter directory directly also take the sequences
+		if seq, ok := parseSeq(entry.Name()); ok && seq >= q.nextSeq {
+			q.nextSeq = seq + 1
+		}
+	}
+	return nil
+}
+
+// writeFile writes to a temporary file and renames it, so that the partial files are never loaded
+func writeFile(filename string, data ...[]byte) error {
+	tmpFile := filename + TMP_FILE_SUFFIX
+	f, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for _, d := range data {
+		if _, err = f.Write(d); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, filename)
+}
+
+func encodeHeader(data []byte, attempts uint32) []byte {
+	header := make([]byte, HEADER_SIZE)
+	copy(header, HEADER_MAGIC)
+	binary.LittleEndian.PutUint32(header[4:], crc32.ChecksumIEEE(data))
+	binary.LittleEndian.PutUint32(header[ATTEMPTS_OFFSET:], attempts)
+	return header
+}
+
+// decodeFile returns the data and the attempts, errInvalidFile is returned if the file is corrupted
+func decodeFile(content []byte) ([]byte, uint32, error) {
+	if len(content) < HEADER_SIZE || string(content[:4]) != HEADER_MAGIC {
+		return nil, 0, errInvalidFile
+	}
+	data := content[HEADER_SIZE:]
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(content[4:]) {
+		return nil, 0, errInvalidFile
+	}
+	return data, binary.LittleEndian.Uint32(content[ATTEMPTS_OFFSET:]), nil
+}
+
+// Put writes the failed batch to the queue, ErrQueueFull is returned if 'max-size' is exceeded
+func (q *RetryQueue) Put(data []byte) error {
+	size := int64(len(data) + HEADER_SIZE)
+	q.mutex.Lock()
+	if q.size+size > int64(q.config.MaxSize)<<20 {
+		q.mutex.Unlock()
+		atomic.AddInt64(&q.counter.DropBatchCounter, 1)
+		return ErrQueueFull
+	}
+	name := fmt.Sprintf("%020d%s", q.nextSeq, BATCH_FILE_SUFFIX)
+	if err := writeFile(filepath.Join(q.directory, name), encodeHeader(data, 0), data); err != nil {
+		q.mutex.Unlock()
+		atomic.AddInt64(&q.counter.DropBatchCounter, 1)
+		return err
+	}
+	q.nextSeq++
+	q.files = append(q.files, batchFile{name: name, size: size, createdAt: time.Now()})
+	q.size += size
+	q.mutex.Unlock()
+
+	atomic.AddInt64(&q.counter.InBatchCounter, 1)
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// DeadLetter writes the poisoned batch to the dead-letter directory directly, with the reason in the '.error' file
+func (q *RetryQueue) DeadLetter(data []byte, reason error) error {
+	q.mutex.Lock()
+	name := fmt.Sprintf("%020d%s", q.nextSeq, BATCH_FILE_SUFFIX)
+	q.nextSeq++
+	q.mutex.Unlock()
+	return q.writeDeadLetter(name, append(encodeHeader(data, 0), data...), reason)
+}
+
+func (q *RetryQueue) writeDeadLetter(name string, content []byte, reason error) error {
+	errorContent := []byte(fmt.Sprintf("%s\n", reason))
+	size := int64(len(content) + len(errorContent))
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.deadLetterSize+size > int64(q.config.DeadLetterMaxSize)<<20 {
+		atomic.AddInt64(&q.counter.DropBatchCounter, 1)
+		return fmt.Errorf("dead-letter directory %s is full", q.deadLetterDirectory)
+	}
+	filename := filepath.Join(q.deadLetterDirectory, name)
+	if err := writeFile(filename, content); err != nil {
+		atomic.AddInt64(&q.counter.DropBatchCounter, 1)
+		return err
+	}
+	writeFile(strings.TrimSuffix(filename, BATCH_FILE_SUFFIX)+ERROR_FILE_SUFFIX, errorContent)
+	q.deadLetterSize += size
+	atomic.AddInt64(&q.counter.DeadLetterBatchCounter, 1)
+	return nil
+}
+
+func (q *RetryQueue) oldest() (batchFile, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.files) == 0 {
+		return batchFile{}, false
+	}
+	return q.files[0], true
+}
+
+func (q *RetryQueue) remove(file batchFile) {
+	os.Remove(filepath.Join(q.directory, file.name))
+	q.mutex.Lock()
+	if len(q.files) > 0 && q.files[0].name == file.name {
+		q.files = q.files[1:]
+		q.size -= file.size
+	}
+	q.mutex.Unlock()
+}
+
+func (q *RetryQueue) moveToDeadLetter(file batchFile, content []byte, reason error) {
+	if err := q.writeDeadLetter(file.name, content, reason); err != nil {
+		log.Warningf("retry queue %s drop batch %s: %s", q.name, file.name, err)
+	} else {
+		log.Warningf("retry queue %s move batch %s to dead-letter directory: %s", q.name, file.name, reason)
+	}
+	q.remove(file)
+}
+
+// sleep returns false if the queue is closed
+func (q *RetryQueue) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-q.notify:
+		return true
+	case <-q.closeCh:
+		return false
+	}
+}
+
+func (q *RetryQueue) run() {
+	defer q.wg.Done()
+	initialInterval := time.Duration(q.config.InitialInterval) * time.Second
+	maxInterval := time.Duration(q.config.MaxInterval) * time.Second
+	backoff := initialInterval
+	for atomic.LoadInt32(&q.closed) == 0 {
+		file, ok := q.oldest()
+		if !ok {
+			if !q.sleep(IDLE_CHECK_INTERVAL) {
+				return
+			}
+			continue
+		}
+
+		filename := filepath.Join(q.directory, file.name)
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			log.Warningf("retry queue %s read batch %s failed: %s", q.name, file.name, err)
+			q.remove(file)
+			atomic.AddInt64(&q.counter.DropBatchCounter, 1)
+			continue
+		}
+		data, attempts, err := decodeFile(content)
+		if err != nil {
+			q.moveToDeadLetter(file, content, err)
+			continue
+		}
+
+		atomic.AddInt64(&q.counter.RetryCounter, 1)
+		err = q.send(data)
+		if err == nil {
+			atomic.AddInt64(&q.counter.SuccessBatchCounter, 1)
+			q.remove(file)
+			backoff = initialInterval
+			continue
+		}
+		attempts++
+		if IsPermanent(err) || (q.config.MaxAttempts > 0 && int(attempts) >= q.config.MaxAttempts) {
+			binary.LittleEndian.PutUint32(content[ATTEMPTS_OFFSET:], attempts)
+			q.moveToDeadLetter(file, content, fmt.Errorf("attempts %d: %s", attempts, err))
+			continue
+		}
+		if f, openErr := os.OpenFile(filename, os.O_WRONLY, 0); openErr == nil {
+			var buf [4]byte
+			binary.LittleEndian.PutUint32(buf[:], attempts)
+			f.WriteAt(buf[:], ATTEMPTS_OFFSET)
+			f.Close()
+		}
+		if attempts == 1 {
+			log.Infof("retry queue %s retry batch %s failed, retry after %s: %s", q.name, file.name, backoff, err)
+		}
+
+		// the new batches may notify the queue while sleeping, so sleep for the rest of the backoff
+		for deadline := time.Now().Add(backoff); time.Now().Before(deadline); {
+			if !q.sleep(time.Until(deadline)) {
+				return
+			}
+		}
+		if backoff *= 2; backoff > maxInterval {
+			backoff = maxInterval
+		}
+	}
+}
+
+// WaitForRoom waits at most PUT_WAIT_TIMEOUT while the queue of the exporter cannot hold 'n' more items, so that
+// the items are not overwritten while the backlog is spilled to the retry queue, see Backlogged. The decoders
+// calling it are never blocked for long, the items are overwritten if the queue is still full after the timeout.
+// Returns immediately when the retry queue is closed.
+func (q *RetryQueue) WaitForRoom(dataQueues queue.FixedMultiQueue, key queue.HashKey, size, n int) {
+	var start time.Time
+	for l := dataQueues.Len(key); l > 0 && l+n > size; l = dataQueues.Len(key) {
+		if atomic.LoadInt32(&q.closed) != 0 {
+			break
+		}
+		if start.IsZero() {
+			start = time.Now()
+		} else if time.Since(start) >= PUT_WAIT_TIMEOUT {
+			break
+		}
+		time.Sleep(PUT_WAIT_INTERVAL)
+	}
+	if !start.IsZero() {
+		atomic.AddInt64(&q.counter.PutBlockedTimeNs, int64(time.Since(start)))
+	}
+}
+
+// Backlogged returns true if the queue of the exporter is more than half full. The batches of the queue should
+// be spilled to the retry queue instead of being sent, so that the queue is drained at the speed of the disk.
+func (q *RetryQueue) Backlogged(dataQueues queue.FixedMultiQueue, key queue.HashKey, size int) bool {
+	return atomic.LoadInt32(&q.closed) == 0 && dataQueues.Len(key) > size/2
+}
+
+// Spill puts the batch which is not sent yet to the queue, it is sent by the retrying goroutine later
+func (q *RetryQueue) Spill(data []byte) error {
+	if err := q.Put(data); err != nil {
+		return err
+	}
+	atomic.AddInt64(&q.counter.SpillBatchCounter, 1)
+	return nil
+}
+
+// SwapCounter returns the counter and resets it, the lag is the current state of the queue
+func (q *RetryQueue) SwapCounter() Counter {
+	counter := Counter{
+		InBatchCounter:         atomic.SwapInt64(&q.counter.InBatchCounter, 0),
+		RetryCounter:           atomic.SwapInt64(&q.counter.RetryCounter, 0),
+		SuccessBatchCounter:    atomic.SwapInt64(&q.counter.SuccessBatchCounter, 0),
+		DropBatchCounter:       atomic.SwapInt64(&q.counter.DropBatchCounter, 0),
+		DeadLetterBatchCounter: atomic.SwapInt64(&q.counter.DeadLetterBatchCounter, 0),
+		SpillBatchCounter:      atomic.SwapInt64(&q.counter.SpillBatchCounter, 0),
+		PutBlockedTimeNs:       atomic.SwapInt64(&q.counter.PutBlockedTimeNs, 0),
+	}
+	q.mutex.Lock()
+	counter.LagBatches = int64(len(q.files))
+	counter.LagBytes = q.size
+	if len(q.files) > 0 {
+		counter.LagSeconds = int64(time.Since(q.files[0].createdAt) / time.Second)
+	}
+	q.mutex.Unlock()
+	return counter
+}
+
+func (q *RetryQueue) String() string {
+	return q.directory
+}
+
+// Close stops retrying, the batches are kept in the directory and retried after restarting
+func (q *RetryQueue) Close() {
+	if !atomic.CompareAndSwapInt32(&q.closed, 0, 1) {
+		return
+	}
+	close(q.closeCh)
+	q.wg.Wait()
+}
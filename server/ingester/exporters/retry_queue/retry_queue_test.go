@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package retry_queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	exporters_cfg "github.com/deepflowio/deepflow/server/ingester/exporters/config"
+	"github.com/deepflowio/deepflow/server/libs/queue"
+)
+
+type testSender struct {
+	sync.Mutex
+	failures int // the number of failures before succeeding
+	err      error
+	sent     []string
+}
+
+func (s *testSender) send(data []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return s.err
+	}
+	s.sent = append(s.sent, string(data))
+	return nil
+}
+
+func (s *testSender) sentBatches() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.sent...)
+}
+
+func testConfig(dir string) *exporters_cfg.RetryQueueConfig {
+	config := &exporters_cfg.RetryQueueConfig{Enabled: true, Directory: dir, MaxAttempts: 3}
+	config.Validate()
+	return config
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("timeout")
+}
+
+func lagBatches(q *RetryQueue) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.files)
+}
+
+func TestRetryQueue(t *testing.T) {
+	dir := t.TempDir()
+	sender := &testSender{failures: 1, err: errors.New("unavailable")}
+	q, err := NewRetryQueue("test-0", testConfig(dir), sender.send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Put([]byte("batch-1"))
+	q.Put([]byte("batch-2"))
+	waitFor(t, func() bool { return lagBatches(q) == 0 })
+	if sent := sender.sentBatches(); len(sent) != 2 || sent[0] != "batch-1" || sent[1] != "batch-2" {
+		t.Errorf("unexpected sent batches %v", sent)
+	}
+	counter := q.SwapCounter()
+	if counter.InBatchCounter != 2 || counter.RetryCounter != 3 || counter.SuccessBatchCounter != 2 || counter.LagBatches != 0 {
+		t.Errorf("unexpected counter %+v", counter)
+	}
+	q.Close()
+
+	// the batches are kept after closing, and retried after restarting
+	sender = &testSender{failures: 100, err: errors.New("unavailable")}
+	q, _ = NewRetryQueue("test-0", testConfig(dir), sender.send)
+	q.Put([]byte("batch-3"))
+	q.Close()
+	sender.failures = 0
+	q, _ = NewRetryQueue("test-0", testConfig(dir), sender.send)
+	if counter := q.SwapCounter(); counter.LagBatches != 1 {
+		t.Errorf("batches are not loaded, counter %+v", counter)
+	}
+	q.Put([]byte("batch-4"))
+	waitFor(t, func() bool { return lagBatches(q) == 0 })
+	if sent := sender.sentBatches(); len(sent) != 2 || sent[0] != "batch-3" || sent[1] != "batch-4" {
+		t.Errorf("unexpected sent batches %v", sent)
+	}
+	q.Close()
+}
+
+func TestRetryQueueDeadLetter(t *testing.T) {
+	dir := t.TempDir()
+	config := testConfig(dir)
+	sender := &testSender{failures: 1, err: Permanent(errors.New("bad request"))}
+	q, _ := NewRetryQueue("test-0", config, sender.send)
+	defer q.Close()
+
+	q.Put([]byte("poisoned"))
+	q.DeadLetter([]byte("rejected"), errors.New("invalid argument"))
+	waitFor(t, func() bool { return lagBatches(q) == 0 })
+
+	deadLetterDir := filepath.Join(config.DeadLetterDirectory, "test-0")
+	entries, _ := os.ReadDir(deadLetterDir)
+	if len(entries) != 4 {
+		t.Fatalf("expect 2 batches and 2 error files in the dead-letter directory, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		content, _ := os.ReadFile(filepath.Join(deadLetterDir, entry.Name()))
+		if filepath.Ext(entry.Name()) != BATCH_FILE_SUFFIX {
+			continue
+		}
+		if data, _, err := decodeFile(content); err != nil || (string(data) != "poisoned" && string(data) != "rejected") {
+			t.Errorf("unexpected dead-letter batch %s: %v", content, err)
+		}
+	}
+	if len(sender.sentBatches()) != 0 {
+		t.Errorf("the poisoned batch should not be sent")
+	}
+}
+
+func TestRetryQueueFull(t *testing.T) {
+	config := testConfig(t.TempDir())
+	config.MaxSize = 1
+	sender := &testSender{failures: 100, err: errors.New("unavailable")}
+	q, _ := NewRetryQueue("test-0", config, sender.send)
+	defer q.Close()
+
+	if err := q.Put(make([]byte, 1<<19)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Put(make([]byte, 1<<19)); err != ErrQueueFull {
+		t.Errorf("expect queue full, got %v", err)
+	}
+	if counter := q.SwapCounter(); counter.DropBatchCounter != 1 || counter.LagBytes != 1<<19+HEADER_SIZE {
+		t.Errorf("unexpected counter %+v", counter)
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	content := append(encodeHeader([]byte("data"), 2), "data"...)
+	if data, attempts, err := decodeFile(content); err != nil || string(data) != "data" || attempts != 2 {
+		t.Errorf("decode file failed: %s %d %v", data, attempts, err)
+	}
+	content[len(content)-1] = 'A'
+	if _, _, err := decodeFile(content); err != errInvalidFile {
+		t.Errorf("expect invalid file, got %v", err)
+	}
+}
+
+func TestWaitForRoom(t *testing.T) {
+	q, _ := NewRetryQueue("test-0", testConfig(t.TempDir()), (&testSender{}).send)
+	defer q.Close()
+	dataQueues := queue.NewOverwriteQueues("test", 1, 4)
+	dataQueues.Put(0, 1, 2, 3)
+
+	if q.Backlogged(dataQueues, 0, 8) || !q.Backlogged(dataQueues, 0, 4) {
+		t.Errorf("3 items of 8 should not be backlogged, and of 4 should be")
+	}
+	start := time.Now()
+	q.WaitForRoom(dataQueues, 0, 4, 2)
+	if elapsed := time.Since(start); elapsed < PUT_WAIT_TIMEOUT || elapsed > 10*PUT_WAIT_TIMEOUT {
+		t.Errorf("the wait should be bounded by %s, waited %s", PUT_WAIT_TIMEOUT, elapsed)
+	}
+	if err := q.Spill([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if counter := q.SwapCounter(); counter.SpillBatchCounter != 1 || counter.PutBlockedTimeNs < int64(PUT_WAIT_TIMEOUT) {
+		t.Errorf("unexpected counter %+v", counter)
+	}
+}
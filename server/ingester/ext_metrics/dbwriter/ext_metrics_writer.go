@@ -159,6 +159,13 @@ func (w *ExtMetricsWriter) Write(m *ExtMetrics) {
 	w.ckWriter.Put(m)
 }
 
+// Close stops the ckwriters of the metrics and flow_tag tables
+func (w *ExtMetricsWriter) Close() error {
+	w.ckWriter.Close()
+	w.flowTagWriter.Close()
+	return w.Closable.Close()
+}
+
 func NewExtMetricsWriter(
 	decoderIndex int,
 	msgType datatype.MessageType,
@@ -318,9 +318,9 @@ func (d *Decoder) fillExtMetricsBaseSlow(m *dbwriter.ExtMetrics, vtapID uint16,
 
 	var info *grpc.Info
 	if t.IsIPv6 == 1 {
-		info = d.platformData.QueryIPV6Infos(m.OrgId, t.L3EpcID, t.IP6)
+		info = d.platformData.QueryIPV6Infos(m.OrgId, t.L3EpcID, t.IP6, m.Timestamp)
 	} else {
-		info = d.platformData.QueryIPV4Infos(m.OrgId, t.L3EpcID, t.IP)
+		info = d.platformData.QueryIPV4Infos(m.OrgId, t.L3EpcID, t.IP, m.Timestamp)
 	}
 	if info != nil {
 		t.RegionID = uint16(info.RegionID)
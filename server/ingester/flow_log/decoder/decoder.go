@@ -38,6 +38,7 @@ import (
 	"github.com/deepflowio/deepflow/server/ingester/flow_log/log_data/dd_import"
 	"github.com/deepflowio/deepflow/server/ingester/flow_log/log_data/sw_import"
 	"github.com/deepflowio/deepflow/server/ingester/flow_log/throttler"
+	"github.com/deepflowio/deepflow/server/ingester/flow_metrics/span_metrics"
 	"github.com/deepflowio/deepflow/server/ingester/flow_tag"
 	"github.com/deepflowio/deepflow/server/libs/codec"
 	"github.com/deepflowio/deepflow/server/libs/datatype"
@@ -89,6 +90,7 @@ type Decoder struct {
 	appServiceTagWriter *flow_tag.AppServiceTagWriter
 	spanWriter          *dbwriter.SpanWriter
 	spanBuf             []interface{}
+	spanMetrics         *span_metrics.Shard
 	exporters           *exporters.Exporters
 	cfg                 *config.Config
 	debugEnabled        bool
@@ -110,9 +112,14 @@ func NewDecoder(
 	flowTagWriter *flow_tag.FlowTagWriter,
 	appServiceTagWriter *flow_tag.AppServiceTagWriter,
 	spanWriter *dbwriter.SpanWriter,
+	spanMetrics *span_metrics.SpanMetrics,
 	exporters *exporters.Exporters,
 	cfg *config.Config,
 ) *Decoder {
+	var spanMetricsShard *span_metrics.Shard
+	if spanMetrics != nil {
+		spanMetricsShard = spanMetrics.NewShard()
+	}
 	return &Decoder{
 		index:               index,
 		msgType:             msgType,
@@ -124,6 +131,7 @@ func NewDecoder(
 		appServiceTagWriter: appServiceTagWriter,
 		spanWriter:          spanWriter,
 		spanBuf:             make([]interface{}, 0, BUFFER_SIZE),
+		spanMetrics:         spanMetricsShard,
 		exporters:           exporters,
 		cfg:                 cfg,
 		debugEnabled:        log.IsEnabledFor(logging.DEBUG),
@@ -272,6 +280,10 @@ func (d *Decoder) sendOpenMetetry(tracesData *v1.TracesData) {
 	d.counter.Count++
 	ls := log_data.OTelTracesDataToL7FlowLogs(d.agentId, d.orgId, d.teamId, tracesData, d.platformData, d.cfg)
 	for _, l := range ls {
+		// metrics are aggregated from all spans, before throttling
+		if d.spanMetrics != nil {
+			d.spanMetrics.Put(l)
+		}
 		l.AddReferenceCount()
 		if !d.throttler.SendWithThrottling(l) {
 			d.counter.DropCount++
@@ -321,6 +333,10 @@ func (d *Decoder) sendSkyWalking(segmentData, peerIP []byte, uri string) {
 	d.counter.Count++
 	ls := sw_import.SkyWalkingDataToL7FlowLogs(d.agentId, d.orgId, d.teamId, segmentData, peerIP, uri, d.platformData, d.cfg)
 	for _, l := range ls {
+		// metrics are aggregated from all spans, before throttling
+		if d.spanMetrics != nil {
+			d.spanMetrics.Put(l)
+		}
 		l.AddReferenceCount()
 		if !d.throttler.SendWithThrottling(l) {
 			d.counter.DropCount++
@@ -370,6 +386,10 @@ func (d *Decoder) sendDatadog(ddogData *pb.ThirdPartyTrace) {
 	d.counter.Count++
 	ls := dd_import.DDogDataToL7FlowLogs(d.agentId, d.orgId, d.teamId, ddogData, d.platformData, d.cfg)
 	for _, l := range ls {
+		// metrics are aggregated from all spans, before throttling
+		if d.spanMetrics != nil {
+			d.spanMetrics.Put(l)
+		}
 		l.AddReferenceCount()
 		if !d.throttler.SendWithThrottling(l) {
 			d.counter.DropCount++
@@ -536,7 +536,8 @@ func (k *KnowledgeGraph) fill(
 	vtapId uint16, podId0, podId1 uint32,
 	port uint16,
 	tapSide uint32,
-	protocol layers.IPProtocol) {
+	protocol layers.IPProtocol,
+	timestamp uint32) {
 
 	var info0, info1, agentInfo *grpc.Info
 
@@ -603,47 +604,47 @@ func (k *KnowledgeGraph) fill(
 	if info0 == nil {
 		if lookupByMac0 {
 			k.TagSource0 |= uint8(flow_metrics.Mac)
-			info0 = platformData.QueryMacInfo(k.OrgId, l3EpcMac0)
+			info0 = platformData.QueryMacInfo(k.OrgId, l3EpcMac0, timestamp)
 		} else if lookupByAgent0 {
 			k.TagSource0 |= uint8(flow_metrics.Agent)
 			if info := platformData.QueryVtapInfo(k.OrgId, vtapId); info != nil {
-				agentInfo = common.RegetInfoFromIP(k.OrgId, !info.IsIPv4, info.IP6, info.IP4, info.EpcId, platformData)
+				agentInfo = common.RegetInfoFromIP(k.OrgId, !info.IsIPv4, info.IP6, info.IP4, info.EpcId, platformData, timestamp)
 				info0 = agentInfo
 			}
 		}
 		if info0 == nil {
 			k.TagSource0 |= uint8(flow_metrics.EpcIP)
-			info0 = common.RegetInfoFromIP(k.OrgId, isIPv6, ip60, ip40, l3EpcID0, platformData)
+			info0 = common.RegetInfoFromIP(k.OrgId, isIPv6, ip60, ip40, l3EpcID0, platformData, timestamp)
 		}
 	}
 
 	if info1 == nil {
 		if lookupByMac1 {
 			k.TagSource1 |= uint8(flow_metrics.Mac)
-			info1 = platformData.QueryMacInfo(k.OrgId, l3EpcMac1)
+			info1 = platformData.QueryMacInfo(k.OrgId, l3EpcMac1, timestamp)
 		} else if lookupByAgent1 {
 			k.TagSource1 |= uint8(flow_metrics.Agent)
 			if lookupByAgent0 && agentInfo != nil {
 				info1 = agentInfo
 			} else {
 				if info := platformData.QueryVtapInfo(k.OrgId, vtapId); info != nil {
-					info1 = common.RegetInfoFromIP(k.OrgId, !info.IsIPv4, info.IP6, info.IP4, info.EpcId, platformData)
+					info1 = common.RegetInfoFromIP(k.OrgId, !info.IsIPv4, info.IP6, info.IP4, info.EpcId, platformData, timestamp)
 				}
 			}
 		}
 		if info1 == nil {
 			k.TagSource1 |= uint8(flow_metrics.EpcIP)
-			info1 = common.RegetInfoFromIP(k.OrgId, isIPv6, ip61, ip41, l3EpcID1, platformData)
+			info1 = common.RegetInfoFromIP(k.OrgId, isIPv6, ip61, ip41, l3EpcID1, platformData, timestamp)
 		}
 	}
 
 	var l2Info0, l2Info1 *grpc.Info
 	if l3EpcID0 > 0 && l3EpcID1 > 0 {
-		l2Info0, l2Info1 = platformData.QueryMacInfosPair(k.OrgId, l3EpcMac0, l3EpcMac1)
+		l2Info0, l2Info1 = platformData.QueryMacInfosPair(k.OrgId, l3EpcMac0, l3EpcMac1, timestamp)
 	} else if l3EpcID0 > 0 {
-		l2Info0 = platformData.QueryMacInfo(k.OrgId, l3EpcMac0)
+		l2Info0 = platformData.QueryMacInfo(k.OrgId, l3EpcMac0, timestamp)
 	} else if l3EpcID1 > 0 {
-		l2Info1 = platformData.QueryMacInfo(k.OrgId, l3EpcMac1)
+		l2Info1 = platformData.QueryMacInfo(k.OrgId, l3EpcMac1, timestamp)
 	}
 
 	if info0 != nil {
@@ -711,7 +712,8 @@ func (k *KnowledgeGraph) FillL4(f *pb.Flow, isIPv6 bool, platformData *grpc.Plat
 		uint16(f.FlowKey.VtapId), 0, 0,
 		uint16(f.FlowKey.PortDst),
 		f.TapSide,
-		layers.IPProtocol(f.FlowKey.Proto))
+		layers.IPProtocol(f.FlowKey.Proto),
+		uint32(f.EndTime/uint64(time.Second)))
 }
 
 func getStatus(t datatype.CloseType, p layers.IPProtocol) datatype.LogMessageStatus {
@@ -547,6 +547,7 @@ func (k *KnowledgeGraph) FillL7(l *pb.AppProtoLogsBaseInfo, platformData *grpc.P
 		uint16(l.PortDst),
 		l.TapSide,
 		protocol,
+		uint32(l.EndTime/uint64(time.Second)),
 	)
 }
 
@@ -396,6 +396,7 @@ func (k *KnowledgeGraph) FillOTel(l *L7FlowLog, platformData *grpc.PlatformInfoT
 		uint16(l.ServerPort),
 		flow_metrics.Rest,
 		layers.IPProtocol(l.Protocol),
+		l.L7Base.Time,
 	)
 
 	// OTel data always not from INTERNET
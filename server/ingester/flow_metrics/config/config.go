@@ -19,6 +19,7 @@ package config
 import (
 	"io/ioutil"
 	"os"
+	"sort"
 
 	"github.com/deepflowio/deepflow/server/ingester/config"
 
@@ -39,8 +40,13 @@ const (
 	DefaultPromWriterQueueSize    = 100000
 	DefaultPromWriterBatchSize    = 2048
 	DefaultPromWriterFlushTimeout = 5
+	DefaultSpanMetricsMaxDelay    = 10 // second
+	DefaultSpanMetricsMaxKeys     = 100000
 )
 
+// us
+var DefaultSpanMetricsLatencyBuckets = []int{1000, 5000, 10000, 25000, 50000, 100000, 250000, 500000, 1000000, 2500000, 5000000, 10000000}
+
 type PCapConfig struct {
 	FileDirectory string `yaml:"file-directory"`
 }
@@ -52,6 +58,18 @@ type FlowMetricsTTL struct {
 	VtapApp1S  int `yaml:"vtap-app-1s"`
 }
 
+// SpanMetricsConfig controls the aggregation of spans imported from OpenTelemetry, SkyWalking
+// and Datadog into the application metrics tables.
+type SpanMetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// how long a window is kept open for late spans after it ends
+	MaxDelay int `yaml:"max-delay"`
+	// max number of aggregation keys kept in memory per window size, extra spans are dropped
+	MaxKeys int `yaml:"max-keys"`
+	// upper bounds (us) of the latency histogram buckets, empty to disable the histogram
+	LatencyHistogramBuckets []int `yaml:"latency-histogram-buckets"`
+}
+
 type Config struct {
 	Base                 *config.Config
 	CKReadTimeout        int                   `yaml:"ck-read-timeout"`
@@ -61,6 +79,7 @@ type Config struct {
 	UnmarshallQueueSize  int                   `yaml:"unmarshall-queue-size"`
 	ReceiverWindowSize   uint64                `yaml:"receiver-window-size"`
 	FlowMetricsTTL       FlowMetricsTTL        `yaml:"flow-metrics-ttl-hour"`
+	SpanMetrics          SpanMetricsConfig     `yaml:"span-metrics"`
 }
 
 type FlowMetricsConfig struct {
@@ -88,6 +107,29 @@ func (c *Config) Validate() error {
 		c.FlowMetricsTTL.VtapApp1S = DefaultFlowMetrics1STTL
 	}
 
+	if c.SpanMetrics.MaxDelay <= 0 {
+		c.SpanMetrics.MaxDelay = DefaultSpanMetricsMaxDelay
+	}
+
+	if c.SpanMetrics.MaxKeys <= 0 {
+		c.SpanMetrics.MaxKeys = DefaultSpanMetricsMaxKeys
+	}
+
+	buckets := make([]int, 0, len(c.SpanMetrics.LatencyHistogramBuckets))
+	for _, b := range c.SpanMetrics.LatencyHistogramBuckets {
+		if b > 0 {
+			buckets = append(buckets, b)
+		}
+	}
+	sort.Ints(buckets)
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] == buckets[i-1] {
+			buckets = append(buckets[:i], buckets[i+1:]...)
+			i--
+		}
+	}
+	c.SpanMetrics.LatencyHistogramBuckets = buckets
+
 	return nil
 }
 
@@ -101,6 +143,11 @@ func Load(base *config.Config, path string) *Config {
 			UnmarshallQueueSize:  DefaultUnmarshallQueueSize,
 			ReceiverWindowSize:   DefaultReceiverWindowSize,
 			FlowMetricsTTL:       FlowMetricsTTL{DefaultFlowMetrics1MTTL, DefaultFlowMetrics1STTL, DefaultFlowMetrics1MTTL, DefaultFlowMetrics1STTL},
+			SpanMetrics: SpanMetricsConfig{
+				MaxDelay:                DefaultSpanMetricsMaxDelay,
+				MaxKeys:                 DefaultSpanMetricsMaxKeys,
+				LatencyHistogramBuckets: DefaultSpanMetricsLatencyBuckets,
+			},
 		},
 	}
 	if _, err := os.Stat(path); os.IsNotExist(err) {
@@ -0,0 +1,641 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package span_metrics
+
+import (
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	logging "github.com/op/go-logging"
+
+	"github.com/deepflowio/deepflow/server/ingester/common"
+	extconfig "github.com/deepflowio/deepflow/server/ingester/ext_metrics/config"
+	extdbwriter "github.com/deepflowio/deepflow/server/ingester/ext_metrics/dbwriter"
+	"github.com/deepflowio/deepflow/server/ingester/flow_log/log_data"
+	"github.com/deepflowio/deepflow/server/ingester/flow_metrics/config"
+	"github.com/deepflowio/deepflow/server/ingester/flow_metrics/unmarshaller"
+	"github.com/deepflowio/deepflow/server/ingester/pkg/ckwriter"
+	"github.com/deepflowio/deepflow/server/libs/app"
+	"github.com/deepflowio/deepflow/server/libs/ckdb"
+	"github.com/deepflowio/deepflow/server/libs/datatype"
+	flow_metrics "github.com/deepflowio/deepflow/server/libs/flow-metrics"
+	"github.com/deepflowio/deepflow/server/libs/grpc"
+	"github.com/deepflowio/deepflow/server/libs/utils"
+)
+
+var log = logging.MustGetLogger("flow_metrics.span_metrics")
+
+const (
+	FLUSH_INTERVAL = time.Second
+
+	// latency histograms are written to ext_metrics.metrics as prometheus style cumulative buckets
+	HISTOGRAM_TABLE  = "span.rrt_bucket"
+	HISTOGRAM_METRIC = "rrt_bucket"
+	HISTOGRAM_MSG    = datatype.MESSAGE_TYPE_OPENTELEMETRY
+)
+
+type Counter struct {
+	InCount         int64 `statsd:"in-count"`
+	SkipCount       int64 `statsd:"skip-count"`
+	LateCount       int64 `statsd:"late-count"`
+	DropFutureCount int64 `statsd:"drop-future-count"`
+	OverflowCount   int64 `statsd:"overflow-count"`
+	DocCount        int64 `statsd:"doc-count"`
+	DropDocCount    int64 `statsd:"drop-doc-count"`
+	HistogramCount  int64 `statsd:"histogram-count"`
+}
+
+func (c *Counter) add(o *Counter) {
+	c.InCount += o.InCount
+	c.SkipCount += o.SkipCount
+	c.LateCount += o.LateCount
+	c.DropFutureCount += o.DropFutureCount
+	c.OverflowCount += o.OverflowCount
+	c.DocCount += o.DocCount
+	c.DropDocCount += o.DropDocCount
+	c.HistogramCount += o.HistogramCount
+}
+
+// spanKey identifies the service, endpoint and peer a span belongs to. Side 0 is the
+// client and side 1 is the server, the same as in l7_flow_log.
+type spanKey struct {
+	orgID, teamID, vtapID uint16
+	signalSource          uint16
+	serverPort            uint16
+	tapSide, tapType      uint8
+	protocol, l7Protocol  uint8
+	bizType               uint8
+	tapPortType           uint8
+	natSource, tunnelType uint8
+	tapPort               uint32
+	isIPv6                bool
+
+	ip0, ip1     uint32
+	ip60, ip61   [net.IPv6len]byte
+	epc0, epc1   int32
+	gpid0, gpid1 uint32
+
+	appService, appInstance, endpoint string
+}
+
+func newSpanKey(l *log_data.L7FlowLog) spanKey {
+	k := spanKey{
+		orgID:        l.OrgId,
+		teamID:       l.TeamID,
+		vtapID:       l.VtapID,
+		signalSource: l.SignalSource,
+		serverPort:   l.ServerPort,
+		tapSide:      l.TapSideEnum,
+		tapType:      l.TapType,
+		protocol:     l.Protocol,
+		l7Protocol:   l.L7Protocol,
+		bizType:      l.BizType,
+		tapPortType:  l.TapPortType,
+		natSource:    l.NatSource,
+		tunnelType:   l.TunnelType,
+		tapPort:      l.TapPort,
+		isIPv6:       !l.IsIPv4,
+		epc0:         l.L3EpcID0,
+		epc1:         l.L3EpcID1,
+		gpid0:        l.GPID0,
+		gpid1:        l.GPID1,
+		appService:   l.AppService,
+		appInstance:  l.AppInstance,
+		endpoint:     l.Endpoint,
+	}
+	if k.isIPv6 {
+		copy(k.ip60[:], l.IP60)
+		copy(k.ip61[:], l.IP61)
+	} else {
+		k.ip0, k.ip1 = l.IP40, l.IP41
+	}
+	return k
+}
+
+// serviceKey drops the peer, the side of the instrumented service is moved to side 0.
+func (k spanKey) serviceKey() spanKey {
+	if flow_metrics.TAPSideEnum(k.tapSide) == flow_metrics.ServerApp {
+		k.ip0, k.ip60, k.epc0, k.gpid0 = k.ip1, k.ip61, k.epc1, k.gpid1
+	}
+	k.ip1, k.ip61, k.epc1, k.gpid1 = 0, [net.IPv6len]byte{}, 0, 0
+	k.tapPort, k.tapPortType, k.natSource, k.tunnelType = 0, 0, 0, 0
+	return k
+}
+
+func (k *spanKey) fillTag(t *flow_metrics.Tag, edge bool) {
+	t.OrgId, t.TeamID, t.VTAPID = k.orgID, k.teamID, k.vtapID
+	t.SignalSource = k.signalSource
+	t.Protocol = layers.IPProtocol(k.protocol)
+	t.ServerPort = k.serverPort
+	t.TAPType = flow_metrics.TAPTypeEnum(k.tapType)
+	t.TAPSide = flow_metrics.TAPSideEnum(k.tapSide)
+	t.TAPSideStr = t.TAPSide.String()
+	t.L7Protocol = datatype.L7Protocol(k.l7Protocol)
+	t.AppService, t.AppInstance, t.Endpoint = k.appService, k.appInstance, k.endpoint
+	t.BizType = k.bizType
+
+	if k.isIPv6 {
+		t.IsIPv4 = 0
+		t.IP6 = append(net.IP{}, k.ip60[:]...)
+	} else {
+		t.IsIPv4 = 1
+		t.IP = k.ip0
+	}
+	t.L3EpcID, t.GPID = k.epc0, k.gpid0
+
+	if !edge {
+		t.Code = flow_metrics.APPLICATION
+		if t.TAPSide == flow_metrics.ServerApp {
+			t.Role = flow_metrics.ROLE_SERVER
+		} else {
+			t.Role = flow_metrics.ROLE_CLIENT
+		}
+		return
+	}
+
+	t.Code = flow_metrics.APPLICATION_MAP
+	if k.isIPv6 {
+		t.IP61 = append(net.IP{}, k.ip61[:]...)
+	} else {
+		t.IP1 = k.ip1
+	}
+	t.L3EpcID1, t.GPID1 = k.epc1, k.gpid1
+	t.TapPort, t.TapPortType = k.tapPort, k.tapPortType
+	t.NatSource, t.TunnelType = datatype.NATSource(k.natSource), datatype.TunnelType(k.tunnelType)
+}
+
+type entry struct {
+	meter flow_metrics.AppMeter
+	// buckets[i] counts the spans in (bounds[i-1], bounds[i]], the last one is +Inf
+	buckets []uint64
+}
+
+func (e *entry) add(l *log_data.L7FlowLog, bounds []uint64) {
+	e.meter.Request++
+	// an imported span always finished with a response. The importers map an unset span
+	// status to STATUS_TIMEOUT, so timeouts cannot be told apart and are not counted.
+	e.meter.Response++
+	if e.meter.DirectionScore < l.DirectionScore {
+		e.meter.DirectionScore = l.DirectionScore
+	}
+	if d := l.ResponseDuration; d > 0 {
+		rrt := uint32(math.MaxUint32)
+		if d < math.MaxUint32 {
+			rrt = uint32(d)
+		}
+		if e.meter.RRTMax < rrt {
+			e.meter.RRTMax = rrt
+		}
+		e.meter.RRTSum += d
+		e.meter.RRTCount++
+	}
+	switch datatype.LogMessageStatus(l.ResponseStatus) {
+	case datatype.STATUS_CLIENT_ERROR:
+		e.meter.ClientError++
+	case datatype.STATUS_SERVER_ERROR:
+		e.meter.ServerError++
+	}
+	if e.buckets != nil {
+		e.buckets[sort.Search(len(bounds), func(i int) bool { return l.ResponseDuration <= bounds[i] })]++
+	}
+}
+
+func (e *entry) merge(o *entry) {
+	e.meter.AppTraffic.ConcurrentMerge(&o.meter.AppTraffic)
+	e.meter.AppLatency.ConcurrentMerge(&o.meter.AppLatency)
+	e.meter.AppAnomaly.ConcurrentMerge(&o.meter.AppAnomaly)
+	for i := range e.buckets {
+		e.buckets[i] += o.buckets[i]
+	}
+}
+
+type window struct {
+	interval  uint32
+	flag      app.DocumentFlag
+	histogram bool
+}
+
+// Shard aggregates the spans of a decoder, so that the decoders do not contend for a lock.
+// The shards are merged when the windows are flushed.
+type Shard struct {
+	sync.Mutex
+	spanMetrics *SpanMetrics
+	// slots[i] are the aggregation slots of windows[i], indexed by the window start time
+	slots   []map[uint32]map[spanKey]*entry
+	counter *Counter
+}
+
+type SpanMetrics struct {
+	sync.Mutex // protects shards and counter
+	shards     []*Shard
+	windows    []window
+	// keys[i] is the number of keys of windows[i] in all shards, updated atomically
+	keys   []int64
+	bounds []uint64
+
+	maxDelay uint32
+	maxKeys  int
+
+	platformData    *grpc.PlatformInfoTable
+	writer          *writer
+	histogramWriter *extdbwriter.ExtMetricsWriter
+	now             func() uint32
+	counter         *Counter
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	utils.Closable
+}
+
+func newSpanMetrics(cfg *config.Config, platformData *grpc.PlatformInfoTable) *SpanMetrics {
+	s := &SpanMetrics{
+		maxDelay:     uint32(cfg.SpanMetrics.MaxDelay),
+		maxKeys:      cfg.SpanMetrics.MaxKeys,
+		platformData: platformData,
+		now:          func() uint32 { return uint32(time.Now().Unix()) },
+		counter:      &Counter{},
+		done:         make(chan struct{}),
+	}
+	for _, b := range cfg.SpanMetrics.LatencyHistogramBuckets {
+		s.bounds = append(s.bounds, uint64(b))
+	}
+	if !cfg.DisableSecondWrite {
+		s.windows = append(s.windows, window{interval: 1, flag: app.FLAG_PER_SECOND_METRICS})
+	}
+	// histograms are only kept at minute granularity, a row per bucket every second would outweigh the metrics
+	s.windows = append(s.windows, window{interval: 60, histogram: len(s.bounds) > 0})
+	s.keys = make([]int64, len(s.windows))
+	return s
+}
+
+// NewSpanMetrics aggregates the spans imported from OpenTelemetry, SkyWalking and Datadog into
+// application(_map).1s/1m, so that services instrumented only by SDKs get RED metrics too.
+func NewSpanMetrics(cfg *config.Config, extMetricsConfig *extconfig.Config, platformDataManager *grpc.PlatformDataManager) (*SpanMetrics, error) {
+	platformData, err := platformDataManager.NewPlatformInfoTable("span-metrics")
+	if err != nil {
+		return nil, err
+	}
+	s := newSpanMetrics(cfg, platformData)
+
+	s.writer, err = newWriter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.bounds) > 0 {
+		s.histogramWriter, err = extdbwriter.NewExtMetricsWriter(0, HISTOGRAM_MSG, extdbwriter.EXT_METRICS_DB, extMetricsConfig)
+		if err != nil {
+			s.writer.Close()
+			return nil, err
+		}
+	}
+
+	common.RegisterCountableForIngester("span_metrics", s)
+	return s, nil
+}
+
+func (s *SpanMetrics) GetCounter() interface{} {
+	s.Lock()
+	counter := s.counter
+	s.counter = &Counter{}
+	shards := s.shards
+	s.Unlock()
+	for _, sh := range shards {
+		sh.Lock()
+		counter.add(sh.counter)
+		sh.counter = &Counter{}
+		sh.Unlock()
+	}
+	return counter
+}
+
+// NewShard is called by every flow_log decoder to get its own aggregation shard.
+func (s *SpanMetrics) NewShard() *Shard {
+	sh := &Shard{
+		spanMetrics: s,
+		slots:       make([]map[uint32]map[spanKey]*entry, len(s.windows)),
+		counter:     &Counter{},
+	}
+	for i := range sh.slots {
+		sh.slots[i] = make(map[uint32]map[spanKey]*entry)
+	}
+	s.Lock()
+	s.shards = append(s.shards, sh)
+	s.Unlock()
+	return sh
+}
+
+// Put is called by the flow_log decoder of the shard for every imported span before throttling.
+func (sh *Shard) Put(l *log_data.L7FlowLog) {
+	s := sh.spanMetrics
+	tapSide := flow_metrics.TAPSideEnum(l.TapSideEnum)
+	sh.Lock()
+	defer sh.Unlock()
+	sh.counter.InCount++
+	// internal spans are not requests between services
+	if tapSide != flow_metrics.ClientApp && tapSide != flow_metrics.ServerApp {
+		sh.counter.SkipCount++
+		return
+	}
+	now := s.now()
+	if l.Time > now+s.maxDelay {
+		sh.counter.DropFutureCount++
+		return
+	}
+
+	k := newSpanKey(l)
+	for i := range s.windows {
+		w := &s.windows[i]
+		ts := l.Time / w.interval * w.interval
+		if ts+w.interval+s.maxDelay <= now {
+			// the window is already written, the late span is written as another row
+			sh.counter.LateCount++
+		}
+		slot := sh.slots[i][ts]
+		if slot == nil {
+			slot = make(map[spanKey]*entry)
+			sh.slots[i][ts] = slot
+		}
+		e := slot[k]
+		if e == nil {
+			// a key aggregated by several shards is counted once per shard, so that the limit
+			// bounds the entries in memory
+			if atomic.LoadInt64(&s.keys[i]) >= int64(s.maxKeys) {
+				sh.counter.OverflowCount++
+				continue
+			}
+			e = &entry{}
+			if w.histogram {
+				e.buckets = make([]uint64, len(s.bounds)+1)
+			}
+			slot[k] = e
+			atomic.AddInt64(&s.keys[i], 1)
+		}
+		e.add(l, s.bounds)
+	}
+}
+
+type flushSlot struct {
+	w       *window
+	ts      uint32
+	entries map[spanKey]*entry
+}
+
+// flush takes the windows ended more than maxDelay ago, or all of them when force is set,
+// and merges the slots of the same window taken from all shards.
+func (s *SpanMetrics) flush(now uint32, force bool) []flushSlot {
+	s.Lock()
+	shards := s.shards
+	s.Unlock()
+
+	var taken []flushSlot
+	for _, sh := range shards {
+		sh.Lock()
+		for i := range s.windows {
+			w := &s.windows[i]
+			for ts, entries := range sh.slots[i] {
+				if force || ts+w.interval+s.maxDelay <= now {
+					taken = append(taken, flushSlot{w, ts, entries})
+					delete(sh.slots[i], ts)
+					atomic.AddInt64(&s.keys[i], -int64(len(entries)))
+				}
+			}
+		}
+		sh.Unlock()
+	}
+
+	type slotKey struct {
+		w  *window
+		ts uint32
+	}
+	merged := make(map[slotKey]int, len(taken))
+	var slots []flushSlot
+	for _, f := range taken {
+		index, ok := merged[slotKey{f.w, f.ts}]
+		if !ok {
+			merged[slotKey{f.w, f.ts}] = len(slots)
+			slots = append(slots, f)
+			continue
+		}
+		entries := slots[index].entries
+		for k, e := range f.entries {
+			if me, ok := entries[k]; ok {
+				me.merge(e)
+			} else {
+				entries[k] = e
+			}
+		}
+	}
+	return slots
+}
+
+// documents converts a flushed window to application_map documents for every peer and
+// application documents for every service, and the latency histograms of the services.
+func (s *SpanMetrics) documents(f flushSlot) ([]interface{}, []*extdbwriter.ExtMetrics) {
+	docs := make([]interface{}, 0, len(f.entries)*2)
+	var histograms []*extdbwriter.ExtMetrics
+	services := make(map[spanKey]*entry, len(f.entries))
+	for k, e := range f.entries {
+		if doc := s.document(f, &k, e, true); doc != nil {
+			docs = append(docs, doc)
+		}
+		sk := k.serviceKey()
+		if se, ok := services[sk]; ok {
+			se.merge(e)
+		} else {
+			services[sk] = e
+		}
+	}
+	for k, e := range services {
+		doc := s.document(f, &k, e, false)
+		if doc == nil {
+			continue
+		}
+		docs = append(docs, doc)
+		if e.buckets != nil {
+			histograms = append(histograms, s.histogram(f.ts, &doc.Tag, e.buckets)...)
+		}
+	}
+	return docs, histograms
+}
+
+func (s *SpanMetrics) document(f flushSlot, k *spanKey, e *entry, edge bool) *app.DocumentApp {
+	doc := app.AcquireDocumentApp()
+	doc.Timestamp = f.ts
+	doc.Flags = f.w.flag
+	k.fillTag(&doc.Tag, edge)
+	doc.AppMeter = e.meter
+	if s.platformData != nil {
+		if err := unmarshaller.DocumentExpand(doc, s.platformData); err != nil {
+			log.Debug(err)
+			s.Lock()
+			s.counter.DropDocCount++
+			s.Unlock()
+			doc.Release()
+			return nil
+		}
+	}
+	return doc
+}
+
+func (s *SpanMetrics) histogram(ts uint32, t *flow_metrics.Tag, buckets []uint64) []*extdbwriter.ExtMetrics {
+	rows := make([]*extdbwriter.ExtMetrics, 0, len(buckets))
+	count := uint64(0)
+	for i, n := range buckets {
+		count += n
+		le := "+Inf"
+		if i < len(s.bounds) {
+			le = strconv.FormatUint(s.bounds[i], 10)
+		}
+		m := extdbwriter.AcquireExtMetrics()
+		m.Timestamp = ts
+		m.MsgType = HISTOGRAM_MSG
+		m.VTableName = HISTOGRAM_TABLE
+		m.AgentID = t.VTAPID
+		m.OrgId, m.RawOrgId, m.TeamID = t.OrgId, t.OrgId, t.TeamID
+		fillUniversalTag(&m.UniversalTag, t)
+		m.TagNames = append(m.TagNames, "app_service", "app_instance", "endpoint", "l7_protocol", "observation_point", "server_port", "le")
+		m.TagValues = append(m.TagValues, t.AppService, t.AppInstance, t.Endpoint, t.L7Protocol.String(false), t.TAPSideStr, strconv.Itoa(int(t.ServerPort)), le)
+		m.MetricsFloatNames = append(m.MetricsFloatNames, HISTOGRAM_METRIC)
+		m.MetricsFloatValues = append(m.MetricsFloatValues, float64(count))
+		rows = append(rows, m)
+	}
+	return rows
+}
+
+func fillUniversalTag(u *flow_metrics.UniversalTag, t *flow_metrics.Tag) {
+	u.IP6, u.IP, u.L3EpcID = t.IP6, t.IP, t.L3EpcID
+	u.IsIPv6 = 1 - t.IsIPv4
+	u.L3DeviceID, u.L3DeviceType = t.L3DeviceID, t.L3DeviceType
+	u.RegionID, u.SubnetID, u.HostID, u.AZID = t.RegionID, t.SubnetID, t.HostID, t.AZID
+	u.PodClusterID, u.PodNSID, u.PodID, u.PodNodeID, u.PodGroupID = t.PodClusterID, t.PodNSID, t.PodID, t.PodNodeID, t.PodGroupID
+	u.ServiceID, u.GPID = t.ServiceID, t.GPID
+	u.AutoInstanceID, u.AutoInstanceType = t.AutoInstanceID, t.AutoInstanceType
+	u.AutoServiceID, u.AutoServiceType = t.AutoServiceID, t.AutoServiceType
+	u.VTAPID = t.VTAPID
+}
+
+func (s *SpanMetrics) write(slots []flushSlot) {
+	for _, f := range slots {
+		docs, histograms := s.documents(f)
+		s.Lock()
+		s.counter.DocCount += int64(len(docs))
+		s.counter.HistogramCount += int64(len(histograms))
+		s.Unlock()
+		if len(docs) > 0 {
+			s.writer.Put(docs...)
+		}
+		for _, m := range histograms {
+			s.histogramWriter.Write(m)
+		}
+	}
+}
+
+func (s *SpanMetrics) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(FLUSH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.write(s.flush(s.now(), false))
+		case <-s.done:
+			s.write(s.flush(0, true))
+			return
+		}
+	}
+}
+
+func (s *SpanMetrics) Start() {
+	s.wg.Add(1)
+	go s.run()
+}
+
+func (s *SpanMetrics) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	s.writer.Close()
+	if s.histogramWriter != nil {
+		s.histogramWriter.Close()
+	}
+	return s.Closable.Close()
+}
+
+// writer only writes the application tables, with its own ckwriter counters so that the
+// span metrics can be told apart from the ones reported by agents.
+type writer struct {
+	ckwriters [flow_metrics.METRICS_TABLE_ID_MAX]*ckwriter.CKWriter
+}
+
+func newWriter(cfg *config.Config) (*writer, error) {
+	w := &writer{}
+	ttl := cfg.FlowMetricsTTL
+	tables := flow_metrics.GetMetricsTables(ckdb.MergeTree, common.CK_VERSION, cfg.Base.CKDB.ClusterName, cfg.Base.CKDB.StoragePolicy, cfg.Base.CKDB.Type,
+		ttl.VtapFlow1M, ttl.VtapFlow1S, ttl.VtapApp1M, ttl.VtapApp1S, cfg.Base.GetCKDBColdStorages())
+	for _, table := range tables {
+		var counterName string
+		switch flow_metrics.MetricsTableID(table.ID) {
+		case flow_metrics.APPLICATION_1M, flow_metrics.APPLICATION_MAP_1M:
+			counterName = "span_app_1m"
+		case flow_metrics.APPLICATION_1S, flow_metrics.APPLICATION_MAP_1S:
+			counterName = "span_app_1s"
+		default:
+			continue
+		}
+		ckwriter, err := ckwriter.NewCKWriter(*cfg.Base.CKDB.ActualAddrs, cfg.Base.CKDBAuth.Username, cfg.Base.CKDBAuth.Password, counterName, cfg.Base.CKDB.TimeZone, table,
+			cfg.CKWriterConfig.QueueCount, cfg.CKWriterConfig.QueueSize, cfg.CKWriterConfig.BatchSize, cfg.CKWriterConfig.FlushTimeout, cfg.Base.CKDB.Watcher)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		ckwriter.Run()
+		w.ckwriters[table.ID] = ckwriter
+	}
+	return w, nil
+}
+
+func (w *writer) Put(items ...interface{}) {
+	var caches [flow_metrics.METRICS_TABLE_ID_MAX][]interface{}
+	for _, item := range items {
+		doc := item.(app.Document)
+		id, err := doc.TableID()
+		if err != nil || w.ckwriters[id] == nil {
+			log.Warningf("span metrics doc table id not found. %v", doc)
+			doc.Release()
+			continue
+		}
+		caches[id] = append(caches[id], doc)
+	}
+	for id, cache := range caches {
+		if len(cache) > 0 {
+			w.ckwriters[id].Put(cache...)
+		}
+	}
+}
+
+func (w *writer) Close() {
+	for _, ckwriter := range w.ckwriters {
+		if ckwriter != nil {
+			ckwriter.Close()
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package span_metrics
+
+import (
+	"testing"
+
+	"github.com/deepflowio/deepflow/server/ingester/flow_log/log_data"
+	"github.com/deepflowio/deepflow/server/ingester/flow_metrics/config"
+	"github.com/deepflowio/deepflow/server/libs/app"
+	"github.com/deepflowio/deepflow/server/libs/datatype"
+	flow_metrics "github.com/deepflowio/deepflow/server/libs/flow-metrics"
+)
+
+const serverIP = 0x0a000001
+
+func newTestSpanMetrics(now uint32, maxKeys int) *SpanMetrics {
+	cfg := &config.Config{}
+	cfg.SpanMetrics.MaxDelay = 10
+	cfg.SpanMetrics.MaxKeys = maxKeys
+	cfg.SpanMetrics.LatencyHistogramBuckets = []int{1000, 10000}
+	s := newSpanMetrics(cfg, nil)
+	s.now = func() uint32 { return now }
+	return s
+}
+
+func newSpan(ts uint32, tapSide flow_metrics.TAPSideEnum, clientIP uint32, duration uint64, status datatype.LogMessageStatus) *log_data.L7FlowLog {
+	l := &log_data.L7FlowLog{}
+	l.Time = ts
+	l.IsIPv4 = true
+	l.IP40, l.IP41 = clientIP, serverIP
+	l.ServerPort = 8080
+	l.TapSideEnum = uint8(tapSide)
+	l.AppService = "checkout"
+	l.Endpoint = "POST /order"
+	l.ResponseDuration = duration
+	l.ResponseStatus = uint8(status)
+	return l
+}
+
+func TestAggregate(t *testing.T) {
+	s := newTestSpanMetrics(1000, 100)
+	// the spans of a service are merged from the shards of different decoders
+	sh0, sh1 := s.NewShard(), s.NewShard()
+	sh0.Put(newSpan(995, flow_metrics.ServerApp, 1, 500, datatype.STATUS_OK))
+	sh1.Put(newSpan(995, flow_metrics.ServerApp, 1, 5000, datatype.STATUS_CLIENT_ERROR))
+	sh0.Put(newSpan(995, flow_metrics.ServerApp, 2, 50000, datatype.STATUS_SERVER_ERROR))
+	sh1.Put(newSpan(995, flow_metrics.App, 1, 100, datatype.STATUS_OK))
+	sh0.Put(newSpan(2000, flow_metrics.ServerApp, 1, 100, datatype.STATUS_OK))
+	if c := s.GetCounter().(*Counter); c.InCount != 5 || c.SkipCount != 1 || c.DropFutureCount != 1 {
+		t.Fatalf("unexpected counter %+v", c)
+	}
+
+	if slots := s.flush(1005, false); len(slots) != 0 {
+		t.Fatalf("window flushed before max delay: %d", len(slots))
+	}
+	slots := s.flush(1006, false)
+	if len(slots) != 1 || slots[0].w.flag != app.FLAG_PER_SECOND_METRICS || slots[0].ts != 995 {
+		t.Fatalf("expect the second window to be flushed, got %+v", slots)
+	}
+	docs, histograms := s.documents(slots[0])
+	if len(docs) != 3 || len(histograms) != 0 {
+		t.Fatalf("expect 2 map docs and 1 app doc without histogram, got %d %d", len(docs), len(histograms))
+	}
+	var service *app.DocumentApp
+	for _, d := range docs {
+		doc := d.(*app.DocumentApp)
+		if id, _ := doc.TableID(); doc.Code == flow_metrics.APPLICATION && id == uint8(flow_metrics.APPLICATION_1S) {
+			service = doc
+		} else if id != uint8(flow_metrics.APPLICATION_MAP_1S) || doc.IP1 != serverIP {
+			t.Errorf("unexpected map doc %s", doc)
+		}
+	}
+	if service == nil {
+		t.Fatal("app doc not found")
+	}
+	if service.IP != serverIP || service.Role != flow_metrics.ROLE_SERVER || service.AppService != "checkout" || service.Endpoint != "POST /order" {
+		t.Errorf("unexpected app doc tag %s", service)
+	}
+	m := service.AppMeter
+	if m.Request != 3 || m.Response != 3 || m.RRTSum != 55500 || m.RRTCount != 3 || m.RRTMax != 50000 || m.ClientError != 1 || m.ServerError != 1 || m.Timeout != 0 {
+		t.Errorf("unexpected app meter %+v", m)
+	}
+
+	slots = s.flush(1030, false)
+	if len(slots) != 1 || slots[0].ts != 960 {
+		t.Fatalf("expect the minute window to be flushed, got %+v", slots)
+	}
+	docs, histograms = s.documents(slots[0])
+	if len(docs) != 3 || len(histograms) != 3 {
+		t.Fatalf("expect 3 docs and 3 histogram buckets, got %d %d", len(docs), len(histograms))
+	}
+	expected := map[string]float64{"1000": 1, "10000": 2, "+Inf": 3}
+	for _, h := range histograms {
+		le := h.TagValues[len(h.TagValues)-1]
+		if h.VTableName != HISTOGRAM_TABLE || h.Timestamp != 960 || h.MetricsFloatValues[0] != expected[le] {
+			t.Errorf("unexpected bucket le=%s %+v", le, h)
+		}
+	}
+	for i, w := range s.windows {
+		if s.keys[i] != 0 || len(sh0.slots[i]) != 0 || len(sh1.slots[i]) != 0 {
+			t.Errorf("window %d not empty after flush", w.interval)
+		}
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	s := newTestSpanMetrics(1000, 1)
+	sh0, sh1 := s.NewShard(), s.NewShard()
+	sh0.Put(newSpan(995, flow_metrics.ClientApp, 1, 500, datatype.STATUS_OK))
+	sh0.Put(newSpan(995, flow_metrics.ClientApp, 2, 500, datatype.STATUS_OK))
+	sh0.Put(newSpan(995, flow_metrics.ClientApp, 1, 500, datatype.STATUS_OK))
+	// the limit is shared by the shards
+	sh1.Put(newSpan(995, flow_metrics.ClientApp, 1, 500, datatype.STATUS_OK))
+	if c := s.GetCounter().(*Counter); c.OverflowCount != 4 {
+		t.Errorf("expect 4 overflows (one per window per shard), got %d", c.OverflowCount)
+	}
+	sh0.Put(newSpan(900, flow_metrics.ClientApp, 1, 500, datatype.STATUS_OK))
+	if c := s.GetCounter().(*Counter); c.LateCount != 2 {
+		t.Errorf("expect the late span counted in both windows, got %d", c.LateCount)
+	}
+}
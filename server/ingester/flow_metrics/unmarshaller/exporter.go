@@ -132,10 +132,10 @@ func (e *ExportDocumentUsage) EncodeTo(protocol config.ExportProtocol, utags *ut
 
 func EncodeTo(e app.Document, protocol config.ExportProtocol, utags *utag.UniversalTagsManager, cfg *config.ExporterCfg) (interface{}, error) {
 	switch protocol {
-	case config.PROTOCOL_KAFKA:
+	case config.PROTOCOL_KAFKA, config.PROTOCOL_PARQUET:
 		tags0, tags1 := QueryUniversalTags0(e, utags), QueryUniversalTags1(e, utags)
 		k8sLabels0, k8sLabels1 := utags.QueryCustomK8sLabels(e.OrgID(), e.Tags().PodID), utags.QueryCustomK8sLabels(e.OrgID(), e.Tags().PodID1)
-		return exportercommon.EncodeToJson(e, int(e.DataSource()), cfg, tags0, tags1, k8sLabels0, k8sLabels1), nil
+		return exportercommon.EncodeToJsonOrRecord(e, int(e.DataSource()), cfg, tags0, tags1, k8sLabels0, k8sLabels1), nil
 	case config.PROTOCOL_PROMETHEUS:
 		return EncodeToPrometheus(e, utags, cfg)
 	default:
@@ -145,6 +145,15 @@ func (w *FlowTagWriter) WriteFieldsAndFieldValuesInCache() {
 	}
 }
 
+func (w *FlowTagWriter) Close() error {
+	for _, ckwriter := range w.ckwriters {
+		if ckwriter != nil {
+			ckwriter.Close()
+		}
+	}
+	return w.Closable.Close()
+}
+
 func (w *FlowTagWriter) GetCounter() interface{} {
 	var counter *Counter
 	counter, w.counter = w.counter, &Counter{}
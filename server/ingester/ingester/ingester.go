@@ -40,6 +40,7 @@ import (
 	servercommon "github.com/deepflowio/deepflow/server/common"
 	applicationlogcfg "github.com/deepflowio/deepflow/server/ingester/app_log/config"
 	"github.com/deepflowio/deepflow/server/ingester/ckissu"
+	"github.com/deepflowio/deepflow/server/ingester/ckschema"
 	"github.com/deepflowio/deepflow/server/ingester/common"
 	"github.com/deepflowio/deepflow/server/ingester/config"
 	eventcfg "github.com/deepflowio/deepflow/server/ingester/event/config"
@@ -51,6 +52,7 @@ import (
 	flowlog "github.com/deepflowio/deepflow/server/ingester/flow_log/flow_log"
 	flowmetricscfg "github.com/deepflowio/deepflow/server/ingester/flow_metrics/config"
 	flowmetrics "github.com/deepflowio/deepflow/server/ingester/flow_metrics/flow_metrics"
+	"github.com/deepflowio/deepflow/server/ingester/flow_metrics/span_metrics"
 	pcapcfg "github.com/deepflowio/deepflow/server/ingester/pcap/config"
 	"github.com/deepflowio/deepflow/server/ingester/pcap/pcap"
 	profilecfg "github.com/deepflowio/deepflow/server/ingester/profile/config"
@@ -165,7 +167,13 @@ func Start(configPath string, shared *servercommon.ControllerIngesterShared) []i
 			MAX_SLAVE_PLATFORMDATA_COUNT,
 			cfg.GrpcBufferSize,
 			cfg.NodeIP,
-			receiver)
+			receiver,
+			grpc.HistoryLimits{
+				Disabled:    cfg.PlatformDataHistory.Disabled,
+				MaxVersions: cfg.PlatformDataHistory.MaxVersions,
+				MaxAge:      time.Duration(cfg.PlatformDataHistory.MaxAge) * time.Second,
+				MaxMemory:   int64(cfg.PlatformDataHistory.MaxMemory) << 20,
+			})
 
 		exporters := exporters.NewExporters(exportersConfig)
 		if exporters != nil {
@@ -173,8 +181,18 @@ func Start(configPath string, shared *servercommon.ControllerIngesterShared) []i
 			closers = append(closers, exporters)
 		}
 
+		// aggregate the imported spans into application metrics
+		var spanMetrics *span_metrics.SpanMetrics
+		if !cfg.StorageDisabled && flowMetricsConfig.SpanMetrics.Enabled {
+			var err error
+			spanMetrics, err = span_metrics.NewSpanMetrics(flowMetricsConfig, extMetricsConfig, platformDataManager)
+			checkError(err)
+			spanMetrics.Start()
+			closers = append(closers, spanMetrics)
+		}
+
 		// 写流日志数据
-		flowLog, err := flowlog.NewFlowLog(flowLogConfig, shared.TraceTreeQueue, receiver, platformDataManager, exporters)
+		flowLog, err := flowlog.NewFlowLog(flowLogConfig, shared.TraceTreeQueue, receiver, platformDataManager, exporters, spanMetrics)
 		checkError(err)
 		flowLog.Start()
 		closers = append(closers, flowLog)
@@ -193,7 +211,7 @@ func Start(configPath string, shared *servercommon.ControllerIngesterShared) []i
 			closers = append(closers, flowMetrics)
 
 			// write event data
-			event, err := event.NewEvent(eventConfig, shared.ResourceEventQueue, receiver, platformDataManager, exporters)
+			event, err := event.NewEvent(eventConfig, shared.ResourceEventQueue, shared.AlertEventQueue, receiver, platformDataManager, exporters)
 			checkError(err)
 			event.Start()
 			closers = append(closers, event)
@@ -229,6 +247,9 @@ func Start(configPath string, shared *servercommon.ControllerIngesterShared) []i
 			cm.Start()
 			closers = append(closers, cm)
 
+			// diff the clickhouse tables against the in-code schema on demand, see 'ingester schema check'
+			ckschema.NewChecker(cfg)
+
 			// 初始化建表完成,再执行issu
 			time.Sleep(time.Second)
 			err = issu.Start()
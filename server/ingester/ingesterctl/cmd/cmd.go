@@ -104,6 +104,7 @@ func RegisterIngesterCommand(root *cobra.Command) {
 		nil,
 	))
 	ingesterCmd.AddCommand(RegisterDecodeTraceCommand(ip, uint16(orgId)))
+	ingesterCmd.AddCommand(RegisterSchemaCommand())
 
 	dropletCmd.AddCommand(queue.RegisterCommand(ingesterctl.INGESTERCTL_QUEUE, []string{
 		"1-receiver-to-statsd",
@@ -132,6 +133,7 @@ func RegisterIngesterCommand(root *cobra.Command) {
 	exportersCmd.AddCommand(debug.ClientRegisterSimple(ingesterctl.CMD_EXPORTER_PLATFORMDATA, debug.CmdHelper{"platformData", "show otlp platformData"}, nil))
 	exportersCmd.AddCommand(debug.ClientRegisterSimple(ingesterctl.CMD_KAFKA_EXPORTER, debug.CmdHelper{Cmd: "kafka", Helper: "show kafka exporter stats"}, nil))
 	exportersCmd.AddCommand(debug.ClientRegisterSimple(ingesterctl.CMD_PROMETHEUS_EXPORTER, debug.CmdHelper{Cmd: "prometheus", Helper: "show prometheus exporter stats"}, nil))
+	exportersCmd.AddCommand(debug.ClientRegisterSimple(ingesterctl.CMD_PARQUET_EXPORTER, debug.CmdHelper{Cmd: "parquet", Helper: "show parquet exporter stats"}, nil))
 
 	profileCmd.AddCommand(debug.ClientRegisterSimple(ingesterctl.CMD_PLATFORMDATA_PROFILE, debug.CmdHelper{"platformData [filter]", "show profile platform data statistics"}, nil))
 
@@ -334,3 +336,45 @@ func RegisterDecodeTraceCommand(ip string, orgId uint16) *cobra.Command {
 
 	return cmd
 }
+
+func RegisterSchemaCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "clickhouse schema commands",
+	}
+
+	var repair, execute bool
+	var onlyOrgId uint32
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "diff the clickhouse tables of every org database on every node against the in-code schema",
+		Run: func(cmd *cobra.Command, args []string) {
+			if execute && !repair {
+				fmt.Println("'--execute' must be used with '--repair'")
+				return
+			}
+			operate := ingesterctl.CK_SCHEMA_CHECK
+			if execute {
+				operate = ingesterctl.CK_SCHEMA_REPAIR_EXECUTE
+			} else if repair {
+				operate = ingesterctl.CK_SCHEMA_REPAIR
+			}
+			arg := ""
+			if onlyOrgId != 0 {
+				arg = strconv.Itoa(int(onlyOrgId))
+			}
+			result, err := debug.CommmandGetResult(ingesterctl.CMD_CK_SCHEMA, int(operate), arg)
+			if err != nil {
+				fmt.Println("Get result failed", err)
+				return
+			}
+			fmt.Println(result)
+		},
+	}
+	checkCmd.Flags().BoolVar(&repair, "repair", false, "print the DDL to fix the drifts, the DDL which may rewrite or delete data is printed as '-- manual:'")
+	checkCmd.Flags().BoolVar(&execute, "execute", false, "with '--repair', execute the safe DDL (create table, add column/index) on each node")
+	checkCmd.Flags().Uint32Var(&onlyOrgId, "only-org", 0, "only check the databases of the org, check all orgs if 0")
+
+	cmd.AddCommand(checkCmd)
+	return cmd
+}
@@ -59,6 +59,15 @@ const (
 	CMD_CONTINUOUS_PROFILER
 	CMD_ORG_SWITCH
 	CMD_FREE_OS_MEMORY
+	CMD_PARQUET_EXPORTER
+	CMD_CK_SCHEMA
+)
+
+// operates of CMD_CK_SCHEMA
+const (
+	CK_SCHEMA_CHECK uint16 = iota
+	CK_SCHEMA_REPAIR
+	CK_SCHEMA_REPAIR_EXECUTE
 )
 
 const (
@@ -65,6 +65,23 @@ func RegisterToCkwriterManager(w *CKWriter) {
 	ckwriterManager.Unlock()
 }
 
+// return the tables of all registered ckwriters, deduplicated by database and local table name
+func Tables() []*ckdb.Table {
+	ckwriterManager.Lock()
+	defer ckwriterManager.Unlock()
+	tables := make([]*ckdb.Table, 0, len(ckwriterManager.ckwriters))
+	exists := make(map[string]struct{})
+	for _, ckwriter := range ckwriterManager.ckwriters {
+		key := ckwriter.table.Database + "." + ckwriter.table.LocalName
+		if _, ok := exists[key]; ok {
+			continue
+		}
+		exists[key] = struct{}{}
+		tables = append(tables, ckwriter.table)
+	}
+	return tables
+}
+
 func (m *CKWriterManager) DropOrg(orgId uint16) error {
 	log.Infof("call ckwriters drop org %d", orgId)
 	ckwriterManager.Lock()
@@ -27,6 +27,14 @@ import (
 
 var log = logging.MustGetLogger("profile.config")
 
+// HttpReceiverConfig configures the http endpoints compatible with the Pyroscope ingest API
+// and the OTLP profiles API, used by the language SDKs pushing profiles without the agent.
+type HttpReceiverConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	ListenPort  int  `yaml:"listen-port"`
+	MaxBodySize int  `yaml:"max-body-size"`
+}
+
 type Config struct {
 	Base                       *config.Config
 	CKWriterConfig             config.CKWriterConfig `yaml:"profile-ck-writer"`
@@ -35,6 +43,7 @@ type Config struct {
 	DecoderQueueSize           int                   `yaml:"profile-decoder-queue-size"`
 	CompressionAlgorithm       *string               `yaml:"profile-compression-algorithm"`
 	OffCpuSplittingGranularity int                   `yaml:"profile-off-cpu-splitting-granularity"`
+	HttpReceiver               HttpReceiverConfig    `yaml:"profile-http-receiver"`
 }
 
 type ProfileConfig struct {
@@ -46,6 +55,9 @@ const (
 	DefaultDecoderQueueCount          = 2
 	DefaultDecoderQueueSize           = 4096
 	DefaultOffCpuSplittingGranularity = 1
+
+	DefaultHttpReceiverPort        = 20045
+	DefaultHttpReceiverMaxBodySize = 16 << 20 // bytes
 )
 
 func (c *Config) Validate() error {
@@ -61,6 +73,13 @@ func (c *Config) Validate() error {
 		c.DecoderQueueSize = DefaultDecoderQueueSize
 	}
 
+	if c.HttpReceiver.ListenPort == 0 {
+		c.HttpReceiver.ListenPort = DefaultHttpReceiverPort
+	}
+	if c.HttpReceiver.MaxBodySize <= 0 {
+		c.HttpReceiver.MaxBodySize = DefaultHttpReceiverMaxBodySize
+	}
+
 	if c.CompressionAlgorithm == nil {
 		// when not configure `profile-compression-algorithm`, default value is `zstd`
 		// when configure profile-compression-algorithm with '', will not use compression algo
@@ -80,6 +99,10 @@ func Load(base *config.Config, path string) *Config {
 			DecoderQueueCount:          DefaultDecoderQueueCount,
 			DecoderQueueSize:           DefaultDecoderQueueSize,
 			OffCpuSplittingGranularity: DefaultOffCpuSplittingGranularity,
+			HttpReceiver: HttpReceiverConfig{
+				ListenPort:  DefaultHttpReceiverPort,
+				MaxBodySize: DefaultHttpReceiverMaxBodySize,
+			},
 		},
 	}
 	if _, err := os.Stat(path); os.IsNotExist(err) {
@@ -321,14 +321,14 @@ func (p *InProcessProfile) fillResource(vtapID uint16, podID uint32, platformDat
 			if ip4 := vtapIP.To4(); ip4 != nil {
 				// fill ip from Vtap first, can be overwritten by podInfo later
 				IP4 := utils.IpToUint32(ip4)
-				vtapPlatformInfo = platformData.QueryIPV4Infos(p.OrgId, vtapInfo.EpcId, IP4)
+				vtapPlatformInfo = platformData.QueryIPV4Infos(p.OrgId, vtapInfo.EpcId, IP4, p.Time)
 				if p.IP4 == 0 && (len(p.IP6) == 0 || p.IP6.Equal(net.IPv6zero)) {
 					p.IP4 = IP4
 					p.IsIPv4 = true
 				}
 			} else {
 				IP6 := vtapIP
-				vtapPlatformInfo = platformData.QueryIPV6Infos(p.OrgId, vtapInfo.EpcId, IP6)
+				vtapPlatformInfo = platformData.QueryIPV6Infos(p.OrgId, vtapInfo.EpcId, IP6, p.Time)
 				if p.IP4 == 0 && (len(p.IP6) == 0 || p.IP6.Equal(net.IPv6zero)) {
 					p.IP6 = IP6
 					p.IsIPv4 = false
@@ -352,9 +352,9 @@ func (p *InProcessProfile) fillResource(vtapID uint16, podID uint32, platformDat
 		// app profile: submit IP from agent
 		// ebpf profile with hostnetwork: when PodID get nil infos, try to get info from PodNodeID
 		if p.IsIPv4 {
-			info = platformData.QueryIPV4Infos(p.OrgId, p.L3EpcID, p.IP4)
+			info = platformData.QueryIPV4Infos(p.OrgId, p.L3EpcID, p.IP4, p.Time)
 		} else {
-			info = platformData.QueryIPV6Infos(p.OrgId, p.L3EpcID, p.IP6)
+			info = platformData.QueryIPV6Infos(p.OrgId, p.L3EpcID, p.IP6, p.Time)
 		}
 	}
 
@@ -82,7 +82,8 @@ func (p *ProfileWriter) Write(m []interface{}) {
 	p.ckWriter.Put(m...)
 }
 
-func NewProfileWriter(msgType datatype.MessageType, decoderIndex int, config *config.Config) (*ProfileWriter, error) {
+// NewProfileWriter creates the writer of the profiles, name distinguishes the writers of the same msgType
+func NewProfileWriter(msgType datatype.MessageType, name string, decoderIndex int, config *config.Config) (*ProfileWriter, error) {
 	writer := &ProfileWriter{
 		msgType:           msgType,
 		ckdbAddrs:         config.Base.CKDB.ActualAddrs,
@@ -100,7 +101,7 @@ func NewProfileWriter(msgType datatype.MessageType, decoderIndex int, config *co
 		*writer.ckdbAddrs,
 		writer.ckdbUsername,
 		writer.ckdbPassword,
-		fmt.Sprintf("%s-%s-%d", msgType, PROFILE_TABLE, decoderIndex),
+		fmt.Sprintf("%s-%s-%d", name, PROFILE_TABLE, decoderIndex),
 		config.Base.CKDB.TimeZone,
 		table,
 		writer.writerConfig.QueueCount,
@@ -119,7 +120,7 @@ func NewProfileWriter(msgType datatype.MessageType, decoderIndex int, config *co
 		BatchSize:    config.CKWriterConfig.BatchSize,
 		FlushTimeout: config.CKWriterConfig.FlushTimeout,
 	}
-	flowTagWriter, err := flow_tag.NewFlowTagWriter(decoderIndex, msgType.String(), PROFILE_DB, writer.ttl, ckdb.TimeFuncTwelveHour, config.Base, &flowTagWriterConfig)
+	flowTagWriter, err := flow_tag.NewFlowTagWriter(decoderIndex, name, PROFILE_DB, writer.ttl, ckdb.TimeFuncTwelveHour, config.Base, &flowTagWriterConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -127,7 +128,7 @@ func NewProfileWriter(msgType datatype.MessageType, decoderIndex int, config *co
 	writer.ckWriter = ckwriter
 	writer.flowTagWriter = flowTagWriter
 
-	common.RegisterCountableForIngester("profile_writer", writer, stats.OptionStatTags{"msg": msgType.String(), "decoder_index": strconv.Itoa(decoderIndex)})
+	common.RegisterCountableForIngester("profile_writer", writer, stats.OptionStatTags{"msg": name, "decoder_index": strconv.Itoa(decoderIndex)})
 	writer.ckWriter.Run()
 	return writer, nil
 }
@@ -41,6 +41,8 @@ import (
 	logging "github.com/op/go-logging"
 	"github.com/pyroscope-io/pyroscope/pkg/convert/jfr"
 	"github.com/pyroscope-io/pyroscope/pkg/convert/pprof"
+	convert_profile "github.com/pyroscope-io/pyroscope/pkg/convert/profile"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/speedscope"
 	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
@@ -59,6 +61,7 @@ type Counter struct {
 	JavaProfileCount   int64 `statsd:"java-profile-count"`
 	GolangProfileCount int64 `statsd:"golang-profile-count"`
 	EBPFProfileCount   int64 `statsd:"ebpf-profile-count"`
+	OtherProfileCount  int64 `statsd:"other-profile-count"`
 
 	UncompressSize int64 `statsd:"uncompress-size"`
 	CompressedSize int64 `statsd:"compressed-size"`
@@ -100,6 +103,7 @@ const (
 type Decoder struct {
 	index               int
 	msgType             datatype.MessageType
+	name                string
 	platformData        *grpc.PlatformInfoTable
 	inQueue             queue.QueueReader
 	profileWriter       *dbwriter.ProfileWriter
@@ -128,12 +132,25 @@ func NewDecoder(index int, msgType datatype.MessageType, compressionAlgo string,
 		inQueue:                    inQueue,
 		profileWriter:              profileWriter,
 		appServiceTagWriter:        appServiceTagWriter,
+		name:                       msgType.String(),
 		compressionAlgo:            compressionAlgo,
 		offCpuSplittingGranularity: offCpuSplittingGranularity,
 		counter:                    &Counter{},
 	}
 }
 
+// NewExternalProfileDecoder creates the decoder of the profiles received by the http receiver,
+// whose queue items are *ExternalProfile instead of *receiver.RecvBuffer
+func NewExternalProfileDecoder(index int, compressionAlgo string,
+	platformData *grpc.PlatformInfoTable,
+	inQueue queue.QueueReader,
+	profileWriter *dbwriter.ProfileWriter,
+	appServiceTagWriter *flow_tag.AppServiceTagWriter) *Decoder {
+	d := NewDecoder(index, datatype.MESSAGE_TYPE_PROFILE, compressionAlgo, 0, platformData, inQueue, profileWriter, appServiceTagWriter)
+	d.name = EXTERNAL_PROFILE_NAME
+	return d
+}
+
 func (d *Decoder) GetCounter() interface{} {
 	var counter *Counter
 	counter, d.counter = d.counter, &Counter{}
@@ -146,7 +163,7 @@ func (d *Decoder) GetCounter() interface{} {
 func (d *Decoder) Run() {
 	common.RegisterCountableForIngester("decoder", d, stats.OptionStatTags{
 		"thread":   strconv.Itoa(d.index),
-		"msg_type": datatype.MESSAGE_TYPE_PROFILE.String()})
+		"msg_type": d.name})
 	buffer := make([]interface{}, BUFFER_SIZE)
 	decoder := &codec.SimpleDecoder{}
 	for {
@@ -157,6 +174,10 @@ func (d *Decoder) Run() {
 				continue
 			}
 			atomic.AddInt64(&d.counter.RawCount, 1)
+			if p, ok := buffer[i].(*ExternalProfile); ok {
+				d.handleExternalProfile(p)
+				continue
+			}
 			recvBytes, ok := buffer[i].(*receiver.RecvBuffer)
 			if !ok {
 				log.Warning("get decode queue data type wrong")
@@ -193,45 +214,69 @@ func (d *Decoder) handleProfileData(vtapID uint16, decoder *codec.SimpleDecoder)
 			log.Errorf("profile data decode failed, offset=%d len=%d", decoder.Offset(), len(decoder.Bytes()))
 			return
 		}
-
-		parser := &Parser{
-			vtapID:                      vtapID,
-			orgId:                       d.orgId,
-			teamId:                      d.teamId,
-			inTimestamp:                 time.Now(),
-			profileWriterCallback:       d.profileWriter.Write,
-			appServiceTagWriterCallback: d.appServiceTagWrite,
-			platformData:                d.platformData,
-			IP:                          make([]byte, len(profile.Ip)),
-			podID:                       profile.PodId,
-			compressionAlgo:             d.compressionAlgo,
-			observer:                    &observer{},
-			offCpuSplittingGranularity:  d.offCpuSplittingGranularity,
-			Counter:                     d.counter,
+		if err := d.handleProfile(vtapID, profile); err != nil {
+			log.Errorf("%s, offset=%d, len=%d", err, decoder.Offset(), len(decoder.Bytes()))
+			return
 		}
-		copy(parser.IP, profile.Ip[:len(profile.Ip)])
-
-		// for jfr/pprof format, no matter compress or not, it requires decompress to parse profile data
-		switch profile.Format {
-		case "jfr":
-			atomic.AddInt64(&d.counter.JavaProfileCount, 1)
-			metadata := d.buildMetaData(profile)
-			parser.profileName = metadata.Key.AppName()
-			compressFlag := _GZIP_COMPRESS_FLAG
-			if profile.DataCompressed {
-				compressFlag |= _ZSTD_COMPRESS_FLAG
-			}
-			log.Debugf("decode java profile data, compression: %d, data: %v", compressFlag, profile.Data)
-			err := d.sendProfileData(&jfr.RawProfile{
-				FormDataContentType: string(profile.ContentType),
-				RawData:             d.decompressData(profile.Data, compressFlag),
-			}, profile.Format, parser, metadata)
+	}
+}
 
-			if err != nil {
-				log.Errorf("decode java profile data failed, offset=%d, len=%d, err=%s", decoder.Offset(), len(decoder.Bytes()), err)
-				return
-			}
-		case "pprof":
+func (d *Decoder) handleProfile(vtapID uint16, profile *pb.Profile) error {
+	parser := &Parser{
+		vtapID:                      vtapID,
+		orgId:                       d.orgId,
+		teamId:                      d.teamId,
+		inTimestamp:                 time.Now(),
+		profileWriterCallback:       d.profileWriter.Write,
+		appServiceTagWriterCallback: d.appServiceTagWrite,
+		platformData:                d.platformData,
+		IP:                          make([]byte, len(profile.Ip)),
+		podID:                       profile.PodId,
+		compressionAlgo:             d.compressionAlgo,
+		observer:                    &observer{},
+		offCpuSplittingGranularity:  d.offCpuSplittingGranularity,
+		Counter:                     d.counter,
+	}
+	copy(parser.IP, profile.Ip[:len(profile.Ip)])
+
+	// for jfr/pprof format, no matter compress or not, it requires decompress to parse profile data
+	switch profile.Format {
+	case "jfr":
+		atomic.AddInt64(&d.counter.JavaProfileCount, 1)
+		metadata := d.buildMetaData(profile)
+		parser.profileName = metadata.Key.AppName()
+		compressFlag := _GZIP_COMPRESS_FLAG
+		if profile.DataCompressed {
+			compressFlag |= _ZSTD_COMPRESS_FLAG
+		}
+		log.Debugf("decode java profile data, compression: %d, data: %v", compressFlag, profile.Data)
+		err := d.sendProfileData(&jfr.RawProfile{
+			FormDataContentType: string(profile.ContentType),
+			RawData:             d.decompressData(profile.Data, compressFlag),
+		}, profile.Format, parser, metadata)
+		if err != nil {
+			return fmt.Errorf("decode java profile data failed, err=%s", err)
+		}
+	case "pprof":
+		atomic.AddInt64(&d.counter.GolangProfileCount, 1)
+		metadata := d.buildMetaData(profile)
+		parser.profileName = metadata.Key.AppName()
+		var compressFlag uint8 = 0
+		if profile.DataCompressed {
+			compressFlag = _ZSTD_COMPRESS_FLAG
+		}
+		log.Debugf("decode golang profile data, compression: %d, data: %v", compressFlag, profile.Data)
+		err := d.sendProfileData(&pprof.RawProfile{
+			FormDataContentType: string(profile.ContentType),
+			RawData:             d.decompressData(profile.Data, compressFlag),
+		}, profile.Format, parser, metadata)
+		if err != nil {
+			return fmt.Errorf("decode golang profile data failed, err=%s", err)
+		}
+	case "":
+		// 如果 format == "" && contentType 有 "multipart/form-data"，默认当作 pprof 来解析，且 StreamingParser&PoolStreamingParser = true
+		// if format == "" && contentType has "multipart/form-data", using pprof parser as default, StreamingParser&PoolStreamingParser = true
+		if strings.Contains(string(profile.ContentType), "multipart/form-data") {
 			atomic.AddInt64(&d.counter.GolangProfileCount, 1)
 			metadata := d.buildMetaData(profile)
 			parser.profileName = metadata.Key.AppName()
@@ -243,64 +288,66 @@ func (d *Decoder) handleProfileData(vtapID uint16, decoder *codec.SimpleDecoder)
 			err := d.sendProfileData(&pprof.RawProfile{
 				FormDataContentType: string(profile.ContentType),
 				RawData:             d.decompressData(profile.Data, compressFlag),
+				StreamingParser:     true,
+				PoolStreamingParser: true,
 			}, profile.Format, parser, metadata)
 			if err != nil {
-				log.Errorf("decode golang profile data failed, offset=%d, len=%d, err=%s", decoder.Offset(), len(decoder.Bytes()), err)
-				return
+				return fmt.Errorf("decode golang profile data failed, err=%s", err)
 			}
-		case "":
-			// 如果 format == "" && contentType 有 "multipart/form-data"，默认当作 pprof 来解析，且 StreamingParser&PoolStreamingParser = true
-			// if format == "" && contentType has "multipart/form-data", using pprof parser as default, StreamingParser&PoolStreamingParser = true
-			if strings.Contains(string(profile.ContentType), "multipart/form-data") {
-				atomic.AddInt64(&d.counter.GolangProfileCount, 1)
-				metadata := d.buildMetaData(profile)
-				parser.profileName = metadata.Key.AppName()
-				var compressFlag uint8 = 0
-				if profile.DataCompressed {
-					compressFlag = _ZSTD_COMPRESS_FLAG
-				}
-				log.Debugf("decode golang profile data, compression: %d, data: %v", compressFlag, profile.Data)
-				err := d.sendProfileData(&pprof.RawProfile{
-					FormDataContentType: string(profile.ContentType),
-					RawData:             d.decompressData(profile.Data, compressFlag),
-					StreamingParser:     true,
-					PoolStreamingParser: true,
-				}, profile.Format, parser, metadata)
-				if err != nil {
-					log.Errorf("decode golang profile data failed, offset=%d, len=%d, err=%s", decoder.Offset(), len(decoder.Bytes()), err)
-					return
-				}
-			} else {
-				atomic.AddInt64(&d.counter.EBPFProfileCount, 1)
-				profile = d.filleBPFData(profile)
-				metadata := d.buildMetaData(profile)
-				parser.profileName = metadata.Key.AppName()
-				parser.processTracer = &processTracer{value: profile.WideCount, pid: profile.Pid, stime: int64(profile.Stime), eventType: eBPFEventType[profile.EventType]}
-				if profile.WideCount == 0 {
-					// adapt agent version before v6.6
-					parser.processTracer.value = uint64(profile.Count)
-				}
-				// for ebpf profiling data, directly write, no need to parse
-				log.Debugf("decode ebpf profile data, compression: %d, data: %v", profile.DataCompressed, profile.Data)
-				err := parser.rawStackToInProcess(
-					profile.Data,
-					parser.value,
-					metadata.StartTime,
-					metadata.Units.String(),
-					metadata.SpyName,
-					metadata.Key.Labels(),
-					profile.DataCompressed,
-				)
-				if err != nil {
-					log.Errorf("decode ebpf profile data failed, offset=%d, len=%d, err=%s", decoder.Offset(), len(decoder.Bytes()), err)
-					return
-				}
+		} else {
+			atomic.AddInt64(&d.counter.EBPFProfileCount, 1)
+			profile = d.filleBPFData(profile)
+			metadata := d.buildMetaData(profile)
+			parser.profileName = metadata.Key.AppName()
+			parser.processTracer = &processTracer{value: profile.WideCount, pid: profile.Pid, stime: int64(profile.Stime), eventType: eBPFEventType[profile.EventType]}
+			if profile.WideCount == 0 {
+				// adapt agent version before v6.6
+				parser.processTracer.value = uint64(profile.Count)
 			}
-		case "speedscope", "tree", "trie", "lines":
-			// not implemented
-			continue
+			// for ebpf profiling data, directly write, no need to parse
+			log.Debugf("decode ebpf profile data, compression: %d, data: %v", profile.DataCompressed, profile.Data)
+			err := parser.rawStackToInProcess(
+				profile.Data,
+				parser.value,
+				metadata.StartTime,
+				metadata.Units.String(),
+				metadata.SpyName,
+				metadata.Key.Labels(),
+				profile.DataCompressed,
+			)
+			if err != nil {
+				return fmt.Errorf("decode ebpf profile data failed, err=%s", err)
+			}
+		}
+	case "groups", "lines", "trie", "tree", "speedscope":
+		// collapsed stacks pushed by pyspy, rbspy, dotnetspy etc., the name is <appName>.<eventType>
+		atomic.AddInt64(&d.counter.OtherProfileCount, 1)
+		metadata := d.buildMetaData(profile)
+		parser.profileName = trimEventType(metadata.Key.AppName())
+		var compressFlag uint8 = 0
+		if profile.DataCompressed {
+			compressFlag = _ZSTD_COMPRESS_FLAG
+		}
+		data := d.decompressData(profile.Data, compressFlag)
+		var rawProfile ingestion.RawProfile
+		if profile.Format == "speedscope" {
+			rawProfile = &speedscope.RawProfile{RawData: data}
+		} else {
+			rawProfile = &convert_profile.RawProfile{Format: ingestion.Format(profile.Format), RawData: data}
+		}
+		if err := d.sendProfileData(rawProfile, profile.Format, parser, metadata); err != nil {
+			return fmt.Errorf("decode %s profile data failed, err=%s", profile.Format, err)
 		}
 	}
+	return nil
+}
+
+// trimEventType removes the event type suffix of the app name, such as: `app.cpu` -> `app`
+func trimEventType(appName string) string {
+	if i := strings.LastIndexByte(appName, '.'); i > 0 {
+		return appName[:i]
+	}
+	return appName
 }
 
 func (d *Decoder) filleBPFData(profile *pb.Profile) *pb.Profile {
@@ -364,8 +411,8 @@ func (d *Decoder) decompressData(data []byte, compressFlag uint8) []byte {
 			return data
 		}
 	}
-	// gzipCompress comes from application-profiler
-	if compressFlag&_GZIP_COMPRESS_FLAG == _GZIP_COMPRESS_FLAG {
+	// gzipCompress comes from application-profiler, profilers pushing directly may send uncompressed data
+	if compressFlag&_GZIP_COMPRESS_FLAG == _GZIP_COMPRESS_FLAG && isGzip(data) {
 		var err error
 		data, err = profile_common.GzipDecompress(data)
 		if err != nil {
@@ -376,6 +423,10 @@ func (d *Decoder) decompressData(data []byte, compressFlag uint8) []byte {
 	return data
 }
 
+func isGzip(data []byte) bool {
+	return len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
 func (d *Decoder) sendProfileData(profile ingestion.RawProfile, format string, parser *Parser, metadata ingestion.Metadata) error {
 	input := &ingestion.IngestInput{
 		Format:   ingestion.Format(format),
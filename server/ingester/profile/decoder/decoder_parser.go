@@ -37,6 +37,7 @@ const (
 	// the maximum duration of off-cpu profile is 1h + <1s
 	MAX_OFF_CPU_PROFILE_SPLIT_COUNT = 4000
 	DEFAULT_COMPRESSION_ALGO        = "zstd"
+	DEFAULT_EVENT_TYPE              = "cpu"
 )
 
 type Parser struct {
@@ -71,6 +72,10 @@ type processTracer struct {
 func (p *Parser) Put(ctx context.Context, i *storage.PutInput) error {
 	// for application profiling, appName like : application.cpu, e.g.:<appName>.<eventType>
 	eventType := strings.TrimPrefix(i.Key.AppName(), fmt.Sprintf("%s.", p.profileName))
+	if eventType == i.Key.AppName() {
+		// profiles pushed without the event type suffix in the name
+		eventType = DEFAULT_EVENT_TYPE
+	}
 	if p.processTracer != nil {
 		// for ebpf profiling event type
 		eventType = p.processTracer.eventType
@@ -0,0 +1,37 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package decoder
+
+import (
+	"github.com/deepflowio/deepflow/server/libs/flow-metrics/pb"
+)
+
+const EXTERNAL_PROFILE_NAME = "external_profile"
+
+// ExternalProfile is the unit put into the decode queue by the http receiver, the profile
+// pushed by the Pyroscope or OTLP clients has been converted to the format sent by the agent.
+type ExternalProfile struct {
+	OrgID   uint16
+	TeamID  uint16
+	Profile *pb.Profile
+}
+
+func (d *Decoder) handleExternalProfile(p *ExternalProfile) {
+	d.orgId, d.teamId = p.OrgID, p.TeamID
+	if err := d.handleProfile(0, p.Profile); err != nil {
+		log.Warningf("external profile %s: %s", p.Profile.Name, err)
+	}
+}
@@ -0,0 +1,534 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httpreceiver
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/convert/pprof"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	ingestercommon "github.com/deepflowio/deepflow/server/ingester/common"
+	"github.com/deepflowio/deepflow/server/libs/flow-metrics/pb"
+)
+
+// OTLP/HTTP profiles API (opentelemetry-proto v1.5.0, profiles/v1development), the messages used are:
+//   ExportProfilesServiceRequest { repeated ResourceProfiles resource_profiles = 1; }
+//   ResourceProfiles { Resource resource = 1; repeated ScopeProfiles scope_profiles = 2; }
+//   Resource         { repeated KeyValue attributes = 1; }
+//   ScopeProfiles    { InstrumentationScope scope = 1; repeated Profile profiles = 2; }
+//   Profile          { repeated ValueType sample_type = 1; repeated Sample sample = 2; repeated Location location_table = 4;
+//                      repeated int32 location_indices = 5; repeated Function function_table = 6;
+//                      repeated KeyValue attribute_table = 7; repeated string string_table = 10; int64 time_nanos = 11;
+//                      int64 duration_nanos = 12; ValueType period_type = 13; int64 period = 14; repeated int32 attribute_indices = 18; }
+//   ValueType        { int32 type_strindex = 1; int32 unit_strindex = 2; }
+//   Sample           { int32 locations_start_index = 1; int32 locations_length = 2; repeated int64 value = 3; }
+//   Location         { repeated Line line = 3; }
+//   Line             { int32 function_index = 1; int64 line = 2; }
+//   Function         { int32 name_strindex = 1; int32 system_name_strindex = 2; int32 filename_strindex = 3; int64 start_line = 4; }
+//   KeyValue         { string key = 1; AnyValue value = 2; }
+//   AnyValue         { string string_value = 1; bool bool_value = 2; int64 int_value = 3; double double_value = 4; }
+//
+// Each profile is converted to a pprof profile, its app_service is the `service.name` resource attribute, and
+// the other resource attributes and the profile attributes become the labels. Sample attributes are ignored.
+
+const (
+	OTLP_SERVICE_NAME_KEY  = "service.name"
+	OTLP_SDK_LANGUAGE_KEY  = "telemetry.sdk.language"
+	OTLP_UNKNOWN_SERVICE   = "unknown_service"
+	OTLP_PROFILE_SPY_NAME  = "otlp"
+	OTLP_DEFAULT_UNIT_NAME = "count"
+)
+
+// spy names known by the decoder, to fill the profile_language_type
+var otlpLanguageSpyName = map[string]string{
+	"go":     "gospy",
+	"java":   "javaspy",
+	"python": "pyspy",
+	"ruby":   "rbspy",
+	"php":    "phpspy",
+	"dotnet": "dotnetspy",
+	"nodejs": "nodespy",
+}
+
+type otlpValueType struct {
+	typ, unit int64
+}
+
+type otlpSample struct {
+	locationsStart, locationsLength int64
+	values                          []int64
+}
+
+type otlpLine struct {
+	functionIndex, line int64
+}
+
+type otlpFunction struct {
+	name, systemName, filename, startLine int64
+}
+
+type otlpProfile struct {
+	sampleTypes      []otlpValueType
+	samples          []otlpSample
+	locations        [][]otlpLine
+	locationIndices  []int64
+	functions        []otlpFunction
+	attributes       []keyValue
+	attributeIndices []int64
+	strings          []string
+	timeNanos        int64
+	durationNanos    int64
+	periodType       otlpValueType
+	period           int64
+}
+
+type keyValue struct {
+	key, value string
+}
+
+func varint(value []byte) int64 {
+	v, _ := protowire.ConsumeVarint(value)
+	return int64(v)
+}
+
+// appendVarints decodes both the packed and the unpacked repeated integers
+func appendVarints(values []int64, typ protowire.Type, value []byte) ([]int64, error) {
+	if typ == protowire.VarintType {
+		return append(values, varint(value)), nil
+	}
+	if typ != protowire.BytesType {
+		return values, nil
+	}
+	for len(value) > 0 {
+		v, n := protowire.ConsumeVarint(value)
+		if n < 0 {
+			return values, protowire.ParseError(n)
+		}
+		values = append(values, int64(v))
+		value = value[n:]
+	}
+	return values, nil
+}
+
+func parseValueType(b []byte) (otlpValueType, error) {
+	vt := otlpValueType{}
+	err := ingestercommon.WalkProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.VarintType {
+			return nil
+		}
+		switch num {
+		case 1:
+			vt.typ = varint(value)
+		case 2:
+			vt.unit = varint(value)
+		}
+		return nil
+	})
+	return vt, err
+}
+
+func parseAnyValue(b []byte) (string, error) {
+	var s string
+	err := ingestercommon.WalkProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch num {
+		case 1:
+			s = string(value)
+		case 2:
+			s = strconv.FormatBool(varint(value) != 0)
+		case 3:
+			s = strconv.FormatInt(varint(value), 10)
+		case 4:
+			if typ == protowire.Fixed64Type {
+				v, _ := protowire.ConsumeFixed64(value)
+				s = strconv.FormatFloat(math.Float64frombits(v), 'f', -1, 64)
+			}
+		}
+		return nil
+	})
+	return s, err
+}
+
+func parseKeyValue(b []byte) (keyValue, error) {
+	kv := keyValue{}
+	err := ingestercommon.WalkProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		var err error
+		switch num {
+		case 1:
+			kv.key = string(value)
+		case 2:
+			kv.value, err = parseAnyValue(value)
+		}
+		return err
+	})
+	return kv, err
+}
+
+func parseResource(b []byte) ([]keyValue, error) {
+	var attributes []keyValue
+	err := ingestercommon.WalkProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		kv, err := parseKeyValue(value)
+		attributes = append(attributes, kv)
+		return err
+	})
+	return attributes, err
+}
+
+func parseSample(b []byte) (otlpSample, error) {
+	sample := otlpSample{}
+	err := ingestercommon.WalkProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		var err error
+		switch num {
+		case 1:
+			sample.locationsStart = varint(value)
+		case 2:
+			sample.locationsLength = varint(value)
+		case 3:
+			sample.values, err = appendVarints(sample.values, typ, value)
+		}
+		return err
+	})
+	return sample, err
+}
+
+func parseLocation(b []byte) ([]otlpLine, error) {
+	var lines []otlpLine
+	err := ingestercommon.WalkProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 3 || typ != protowire.BytesType {
+			return nil
+		}
+		line := otlpLine{}
+		err := ingestercommon.WalkProtobufFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+			switch num {
+			case 1:
+				line.functionIndex = varint(value)
+			case 2:
+				line.line = varint(value)
+			}
+			return nil
+		})
+		lines = append(lines, line)
+		return err
+	})
+	return lines, err
+}
+
+func parseFunction(b []byte) (otlpFunction, error) {
+	function := otlpFunction{}
+	err := ingestercommon.WalkProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch num {
+		case 1:
+			function.name = varint(value)
+		case 2:
+			function.systemName = varint(value)
+		case 3:
+			function.filename = varint(value)
+		case 4:
+			function.startLine = varint(value)
+		}
+		return nil
+	})
+	return function, err
+}
+
+func parseOtlpProfile(b []byte) (*otlpProfile, error) {
+	p := &otlpProfile{}
+	err := ingestercommon.WalkProtobufFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		var err error
+		switch num {
+		case 1:
+			var vt otlpValueType
+			vt, err = parseValueType(value)
+			p.sampleTypes = append(p.sampleTypes, vt)
+		case 2:
+			var sample otlpSample
+			sample, err = parseSample(value)
+			p.samples = append(p.samples, sample)
+		case 4:
+			var lines []otlpLine
+			lines, err = parseLocation(value)
+			p.locations = append(p.locations, lines)
+		case 5:
+			p.locationIndices, err = appendVarints(p.locationIndices, typ, value)
+		case 6:
+			var function otlpFunction
+			function, err = parseFunction(value)
+			p.functions = append(p.functions, function)
+		case 7:
+			var kv keyValue
+			kv, err = parseKeyValue(value)
+			p.attributes = append(p.attributes, kv)
+		case 10:
+			p.strings = append(p.strings, string(value))
+		case 11:
+			p.timeNanos = varint(value)
+		case 12:
+			p.durationNanos = varint(value)
+		case 13:
+			p.periodType, err = parseValueType(value)
+		case 14:
+			p.period = varint(value)
+		case 18:
+			p.attributeIndices, err = appendVarints(p.attributeIndices, typ, value)
+		}
+		return err
+	})
+	return p, err
+}
+
+func (p *otlpProfile) str(i int64) (string, error) {
+	if i < 0 || i >= int64(len(p.strings)) {
+		return "", fmt.Errorf("string index %d out of range %d", i, len(p.strings))
+	}
+	return p.strings[i], nil
+}
+
+// toPprof converts the profile to a pprof profile, function i and location i of the profile
+// use id i+1 in the pprof profile
+func (p *otlpProfile) toPprof() (*tree.Profile, error) {
+	if len(p.strings) == 0 {
+		p.strings = []string{""}
+	}
+	for _, vt := range append([]otlpValueType{p.periodType}, p.sampleTypes...) {
+		if _, err := p.str(vt.typ); err != nil {
+			return nil, err
+		}
+		if _, err := p.str(vt.unit); err != nil {
+			return nil, err
+		}
+	}
+	pp := &tree.Profile{
+		StringTable:   p.strings,
+		TimeNanos:     p.timeNanos,
+		DurationNanos: p.durationNanos,
+		PeriodType:    &tree.ValueType{Type: p.periodType.typ, Unit: p.periodType.unit},
+		Period:        p.period,
+	}
+	for _, vt := range p.sampleTypes {
+		pp.SampleType = append(pp.SampleType, &tree.ValueType{Type: vt.typ, Unit: vt.unit})
+	}
+	for i, f := range p.functions {
+		for _, s := range []int64{f.name, f.systemName, f.filename} {
+			if _, err := p.str(s); err != nil {
+				return nil, err
+			}
+		}
+		pp.Function = append(pp.Function, &tree.Function{
+			Id: uint64(i) + 1, Name: f.name, SystemName: f.systemName, Filename: f.filename, StartLine: f.startLine,
+		})
+	}
+	for i, lines := range p.locations {
+		location := &tree.Location{Id: uint64(i) + 1}
+		for _, line := range lines {
+			if line.functionIndex < 0 || line.functionIndex >= int64(len(p.functions)) {
+				return nil, fmt.Errorf("function index %d out of range %d", line.functionIndex, len(p.functions))
+			}
+			location.Line = append(location.Line, &tree.Line{FunctionId: uint64(line.functionIndex) + 1, Line: line.line})
+		}
+		pp.Location = append(pp.Location, location)
+	}
+	for _, sample := range p.samples {
+		start, end := sample.locationsStart, sample.locationsStart+sample.locationsLength
+		if start < 0 || end > int64(len(p.locationIndices)) || start > end {
+			return nil, fmt.Errorf("sample locations [%d, %d) out of range %d", start, end, len(p.locationIndices))
+		}
+		locationIDs := make([]uint64, 0, end-start)
+		for _, index := range p.locationIndices[start:end] {
+			if index < 0 || index >= int64(len(p.locations)) {
+				return nil, fmt.Errorf("location index %d out of range %d", index, len(p.locations))
+			}
+			locationIDs = append(locationIDs, uint64(index)+1)
+		}
+		if len(sample.values) != len(p.sampleTypes) {
+			return nil, fmt.Errorf("sample has %d values, but profile has %d sample types", len(sample.values), len(p.sampleTypes))
+		}
+		pp.Sample = append(pp.Sample, &tree.Sample{LocationId: locationIDs, Value: sample.values})
+	}
+	return pp, nil
+}
+
+// sampleTypeConfig extends the default sample types of the pprof parser with the
+// sample types of the profile, otherwise the unknown ones would be dropped
+func (p *otlpProfile) sampleTypeConfig() map[string]*tree.SampleTypeConfig {
+	config := make(map[string]*tree.SampleTypeConfig, len(tree.DefaultSampleTypeMapping)+len(p.sampleTypes))
+	for k, v := range tree.DefaultSampleTypeMapping {
+		config[k] = v
+	}
+	for _, vt := range p.sampleTypes {
+		name := p.strings[vt.typ]
+		if _, ok := config[name]; ok {
+			continue
+		}
+		unit := p.strings[vt.unit]
+		if unit == "" {
+			unit = OTLP_DEFAULT_UNIT_NAME
+		}
+		config[name] = &tree.SampleTypeConfig{Units: metadata.Units(unit)}
+	}
+	return config
+}
+
+// sanitizeName replaces the characters not allowed in the Pyroscope app names and label names
+func sanitizeName(s string, allowDot bool) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' ||
+			(allowDot && (r == '.' || r == '-')) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// profileName builds the name in the format of the Pyroscope ingest API: <appName>{<label>=<value>,...}
+func profileName(attributes []keyValue) (string, string) {
+	appName, language := OTLP_UNKNOWN_SERVICE, ""
+	labels := make(map[string]string, len(attributes))
+	for _, kv := range attributes {
+		switch kv.key {
+		case OTLP_SERVICE_NAME_KEY:
+			if kv.value != "" {
+				appName = kv.value
+			}
+			continue
+		case OTLP_SDK_LANGUAGE_KEY:
+			language = kv.value
+		}
+		key := sanitizeName(kv.key, false)
+		if key == "" || strings.HasPrefix(key, "__") {
+			continue
+		}
+		labels[key] = strings.NewReplacer(",", "_", "{", "_", "}", "_", "=", "_").Replace(kv.value)
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(sanitizeName(appName, true))
+	sb.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(labels[k])
+	}
+	sb.WriteByte('}')
+	return sb.String(), otlpLanguageSpyName[language]
+}
+
+func (p *otlpProfile) toProfile(resource []keyValue, now time.Time) (*pb.Profile, error) {
+	pp, err := p.toPprof()
+	if err != nil {
+		return nil, err
+	}
+	data, err := pp.MarshalVT()
+	if err != nil {
+		return nil, err
+	}
+	// the sample types are passed in the multipart form
+	raw := &pprof.RawProfile{Profile: data, SampleTypeConfig: p.sampleTypeConfig()}
+	body, err := raw.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := append([]keyValue{}, resource...)
+	for _, i := range p.attributeIndices {
+		if i >= 0 && i < int64(len(p.attributes)) {
+			attributes = append(attributes, p.attributes[i])
+		}
+	}
+	name, spyName := profileName(attributes)
+	if spyName == "" {
+		spyName = OTLP_PROFILE_SPY_NAME
+	}
+
+	start := time.Unix(0, p.timeNanos)
+	if p.timeNanos <= 0 {
+		start = now
+	}
+	end := start.Add(time.Duration(p.durationNanos))
+	return &pb.Profile{
+		Name:        url.QueryEscape(name),
+		From:        uint32(start.Unix()),
+		Until:       uint32(end.Unix()),
+		SpyName:     spyName,
+		Format:      "pprof",
+		ContentType: []byte(raw.ContentType()),
+		Data:        body,
+	}, nil
+}
+
+func parseOtlpProfiles(body []byte, now time.Time) ([]*pb.Profile, error) {
+	var profiles []*pb.Profile
+	err := ingestercommon.WalkProtobufFields(body, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		var resource []keyValue
+		var scopeProfiles [][]byte
+		err := ingestercommon.WalkProtobufFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+			var err error
+			switch num {
+			case 1:
+				resource, err = parseResource(value)
+			case 2:
+				scopeProfiles = append(scopeProfiles, value)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, scope := range scopeProfiles {
+			err := ingestercommon.WalkProtobufFields(scope, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				if num != 2 || typ != protowire.BytesType {
+					return nil
+				}
+				p, err := parseOtlpProfile(value)
+				if err != nil {
+					return err
+				}
+				profile, err := p.toProfile(resource, now)
+				if err != nil {
+					return err
+				}
+				profiles = append(profiles, profile)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return profiles, err
+}
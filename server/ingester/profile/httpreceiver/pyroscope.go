@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httpreceiver
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/libs/flow-metrics/pb"
+)
+
+// Pyroscope ingest API, see https://grafana.com/docs/pyroscope/latest/configure-server/about-server-api/#ingestion
+//
+//   POST /ingest?name=<appName>.<eventType>{<label>=<value>,...}&from=<unix s>&until=<unix s>&format=<format>
+//        &sampleRate=100&spyName=<spy>&units=samples&aggregationType=sum
+//
+// The body is the profile in the given format, pprof and jfr may be sent as multipart/form-data.
+// The name is parsed by the decoder in the same way as the profiles sent by the agent.
+
+const (
+	DEFAULT_SAMPLE_RATE      = 100
+	DEFAULT_UNITS            = "samples"
+	DEFAULT_AGGREGATION_TYPE = "sum"
+
+	TRIE_CONTENT_TYPE = "binary/octet-stream+trie"
+)
+
+// ingestFormat returns the format used by the decoder, the collapsed stacks format is called
+// `folded` by the clients and `groups` by the parser, and it is the default format
+func ingestFormat(format, contentType string) (string, error) {
+	switch format {
+	case "pprof", "jfr", "trie", "tree", "lines", "speedscope":
+		return format, nil
+	case "":
+		if strings.Contains(contentType, "multipart/form-data") {
+			return "pprof", nil
+		}
+		if contentType == TRIE_CONTENT_TYPE {
+			return "trie", nil
+		}
+		return "groups", nil
+	case "folded", "groups":
+		return "groups", nil
+	}
+	return "", fmt.Errorf("unsupported format %s", format)
+}
+
+// parseUnixSeconds accepts timestamps in seconds, milliseconds or nanoseconds
+func parseUnixSeconds(s string, defaultValue time.Time) (uint32, error) {
+	if s == "" {
+		return uint32(defaultValue.Unix()), nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid timestamp %s", s)
+	}
+	switch {
+	case v > 1e15:
+		v /= 1e9
+	case v > 1e12:
+		v /= 1e3
+	}
+	return uint32(v), nil
+}
+
+func parseIngest(query url.Values, contentType string, body []byte, now time.Time) (*pb.Profile, error) {
+	name := query.Get("name")
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	format, err := ingestFormat(query.Get("format"), contentType)
+	if err != nil {
+		return nil, err
+	}
+	until, err := parseUnixSeconds(query.Get("until"), now)
+	if err != nil {
+		return nil, err
+	}
+	from, err := parseUnixSeconds(query.Get("from"), time.Unix(int64(until), 0))
+	if err != nil {
+		return nil, err
+	}
+	sampleRate := uint64(DEFAULT_SAMPLE_RATE)
+	if s := query.Get("sampleRate"); s != "" {
+		if sampleRate, err = strconv.ParseUint(s, 10, 32); err != nil {
+			return nil, fmt.Errorf("invalid sampleRate %s", s)
+		}
+	}
+	units := query.Get("units")
+	if units == "" {
+		units = DEFAULT_UNITS
+	}
+	aggregationType := query.Get("aggregationType")
+	if aggregationType == "" {
+		aggregationType = DEFAULT_AGGREGATION_TYPE
+	}
+
+	return &pb.Profile{
+		// the decoder unescapes the name once more, keep it escaped
+		Name:            url.QueryEscape(name),
+		From:            from,
+		Until:           until,
+		SampleRate:      uint32(sampleRate),
+		SpyName:         query.Get("spyName"),
+		Units:           units,
+		AggregationType: aggregationType,
+		Format:          format,
+		ContentType:     []byte(contentType),
+		Data:            body,
+	}, nil
+}
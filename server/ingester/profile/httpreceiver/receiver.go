@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httpreceiver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	logging "github.com/op/go-logging"
+
+	ingestercommon "github.com/deepflowio/deepflow/server/ingester/common"
+	"github.com/deepflowio/deepflow/server/ingester/profile/config"
+	"github.com/deepflowio/deepflow/server/ingester/profile/decoder"
+	"github.com/deepflowio/deepflow/server/libs/flow-metrics/pb"
+	"github.com/deepflowio/deepflow/server/libs/queue"
+	"github.com/deepflowio/deepflow/server/libs/stats"
+	"github.com/deepflowio/deepflow/server/libs/utils"
+)
+
+var log = logging.MustGetLogger("profile.httpreceiver")
+
+const (
+	PYROSCOPE_INGEST_PATH = "/ingest"
+	OTLP_PROFILES_PATH    = "/v1development/profiles"
+)
+
+type Counter struct {
+	IngestRequestCount int64 `statsd:"ingest-request-count"`
+	OtlpRequestCount   int64 `statsd:"otlp-request-count"`
+	ProfileCount       int64 `statsd:"profile-count"`
+	ErrorCount         int64 `statsd:"err-count"`
+	DropCount          int64 `statsd:"drop-count"`
+}
+
+type HttpReceiver struct {
+	config       *config.HttpReceiverConfig
+	decodeQueues queue.MultiQueueWriter
+	queueCount   int
+	putCount     uint64
+	server       *ingestercommon.HttpServer
+
+	counter *Counter
+	utils.Closable
+}
+
+func NewHttpReceiver(config *config.HttpReceiverConfig, decodeQueues queue.MultiQueueWriter, queueCount int) *HttpReceiver {
+	return &HttpReceiver{
+		config:       config,
+		decodeQueues: decodeQueues,
+		queueCount:   queueCount,
+		server:       ingestercommon.NewHttpServer("profile", config.ListenPort, config.MaxBodySize),
+		counter:      &Counter{},
+	}
+}
+
+func (r *HttpReceiver) GetCounter() interface{} {
+	counter := &Counter{
+		IngestRequestCount: atomic.SwapInt64(&r.counter.IngestRequestCount, 0),
+		OtlpRequestCount:   atomic.SwapInt64(&r.counter.OtlpRequestCount, 0),
+		ProfileCount:       atomic.SwapInt64(&r.counter.ProfileCount, 0),
+		ErrorCount:         r.server.SwapErrorCount(),
+		DropCount:          atomic.SwapInt64(&r.counter.DropCount, 0),
+	}
+	return counter
+}
+
+func (r *HttpReceiver) RegisterHandlers() {
+	router := r.server.Router()
+	router.HandleFunc(PYROSCOPE_INGEST_PATH, r.ingest).Methods("POST")
+	router.HandleFunc(OTLP_PROFILES_PATH, r.otlpProfiles).Methods("POST")
+}
+
+func (r *HttpReceiver) Start() {
+	r.RegisterHandlers()
+	ingestercommon.RegisterCountableForIngester("profile_http_receiver", r, stats.OptionStatTags{"port": strconv.Itoa(r.config.ListenPort)})
+	r.server.Start()
+}
+
+func (r *HttpReceiver) Close() error {
+	r.Closable.Close()
+	return r.server.Close()
+}
+
+// clientIP is used to look up the platform info of the profiles, as the agent IP does for agent profiles
+func clientIP(req *http.Request) net.IP {
+	if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+		if ip := net.ParseIP(strings.TrimSpace(strings.Split(forwarded, ",")[0])); ip != nil {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func (r *HttpReceiver) put(orgId, teamId uint16, ip net.IP, profiles []*pb.Profile) {
+	for _, profile := range profiles {
+		if ip4 := ip.To4(); ip4 != nil {
+			profile.Ip = ip4
+		} else {
+			profile.Ip = ip
+		}
+		atomic.AddInt64(&r.counter.ProfileCount, 1)
+		key := queue.HashKey(atomic.AddUint64(&r.putCount, 1) % uint64(r.queueCount))
+		if err := r.decodeQueues.Put(key, &decoder.ExternalProfile{OrgID: orgId, TeamID: teamId, Profile: profile}); err != nil {
+			atomic.AddInt64(&r.counter.DropCount, 1)
+			log.Warningf("put external profile to decode queue failed: %s", err)
+		}
+	}
+}
+
+func (r *HttpReceiver) ingest(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&r.counter.IngestRequestCount, 1)
+	orgId, teamId, err := ingestercommon.ParseOrgTeamID(req)
+	if err != nil {
+		r.server.RespError(w, http.StatusBadRequest, err)
+		return
+	}
+	body, err := r.server.ReadBody(req)
+	if err != nil {
+		r.server.RespBodyError(w, err)
+		return
+	}
+	profile, err := parseIngest(req.URL.Query(), req.Header.Get("Content-Type"), body, time.Now())
+	if err != nil {
+		r.server.RespError(w, http.StatusBadRequest, err)
+		return
+	}
+	r.put(orgId, teamId, clientIP(req), []*pb.Profile{profile})
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *HttpReceiver) otlpProfiles(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&r.counter.OtlpRequestCount, 1)
+	orgId, teamId, err := ingestercommon.ParseOrgTeamID(req)
+	if err != nil {
+		r.server.RespError(w, http.StatusBadRequest, err)
+		return
+	}
+	if contentType := req.Header.Get("Content-Type"); contentType != "" && !strings.HasPrefix(contentType, "application/x-protobuf") {
+		r.server.RespError(w, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %s, only protobuf is supported", contentType))
+		return
+	}
+	body, err := r.server.ReadBody(req)
+	if err != nil {
+		r.server.RespBodyError(w, err)
+		return
+	}
+	profiles, err := parseOtlpProfiles(body, time.Now())
+	if err != nil {
+		r.server.RespError(w, http.StatusBadRequest, err)
+		return
+	}
+	r.put(orgId, teamId, clientIP(req), profiles)
+	// an empty ExportProfilesServiceResponse
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httpreceiver
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestParseIngest(t *testing.T) {
+	now := time.Unix(1714557600, 0)
+	testCases := []struct {
+		query       string
+		contentType string
+		format      string
+		from, until uint32
+		err         bool
+	}{
+		{"name=app.cpu", "", "groups", 1714557600, 1714557600, false},
+		{"name=app.cpu&format=folded&from=1714557500&until=1714557510", "", "groups", 1714557500, 1714557510, false},
+		{"name=app.cpu&from=1714557500000&until=1714557510000000000", "", "groups", 1714557500, 1714557510, false},
+		{"name=app", "multipart/form-data; boundary=x", "pprof", 1714557600, 1714557600, false},
+		{"name=app&format=jfr", "", "jfr", 1714557600, 1714557600, false},
+		{"name=app", TRIE_CONTENT_TYPE, "trie", 1714557600, 1714557600, false},
+		{"name=app&format=unknown", "", "", 0, 0, true},
+		{"format=pprof", "", "", 0, 0, true},
+		{"name=app&from=abc", "", "", 0, 0, true},
+	}
+	for _, tc := range testCases {
+		query, _ := url.ParseQuery(tc.query)
+		p, err := parseIngest(query, tc.contentType, []byte("a;b 1"), now)
+		if (err != nil) != tc.err {
+			t.Errorf("query: %s, expected error: %v, got: %v", tc.query, tc.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if p.Format != tc.format || p.From != tc.from || p.Until != tc.until {
+			t.Errorf("query: %s, unexpected profile: %s %d %d", tc.query, p.Format, p.From, p.Until)
+		}
+	}
+
+	query, _ := url.ParseQuery("name=" + url.QueryEscape("app.cpu{env=prod}") + "&spyName=pyspy")
+	p, _ := parseIngest(query, "", nil, now)
+	if name, _ := url.QueryUnescape(p.Name); name != "app.cpu{env=prod}" || p.SpyName != "pyspy" ||
+		p.SampleRate != DEFAULT_SAMPLE_RATE || p.Units != DEFAULT_UNITS || p.AggregationType != DEFAULT_AGGREGATION_TYPE {
+		t.Errorf("unexpected profile %+v", p)
+	}
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func stringKeyValue(key, value string) []byte {
+	var anyValue, kv []byte
+	anyValue = appendString(anyValue, 1, value)
+	kv = appendString(kv, 1, key)
+	return appendMessage(kv, 2, anyValue)
+}
+
+// main -> work (5), main (2)
+func testOtlpRequest() []byte {
+	var profile []byte
+	for _, s := range []string{"", "cpu", "nanoseconds", "main", "work", "main.go"} {
+		profile = appendString(profile, 10, s)
+	}
+	var sampleType []byte
+	sampleType = appendVarint(sampleType, 1, 1)
+	sampleType = appendVarint(sampleType, 2, 2)
+	profile = appendMessage(profile, 1, sampleType)
+	profile = appendMessage(profile, 13, sampleType)
+	for _, name := range []uint64{3, 4} {
+		var function []byte
+		function = appendVarint(function, 1, name)
+		function = appendVarint(function, 3, 5)
+		profile = appendMessage(profile, 6, function)
+	}
+	for functionIndex := uint64(0); functionIndex < 2; functionIndex++ {
+		var line, location []byte
+		line = appendVarint(line, 1, functionIndex)
+		line = appendVarint(line, 2, 10+functionIndex)
+		location = appendMessage(location, 3, line)
+		profile = appendMessage(profile, 4, location)
+	}
+	// packed location indices: [work, main], [main]
+	var indices []byte
+	for _, i := range []uint64{1, 0, 0} {
+		indices = protowire.AppendVarint(indices, i)
+	}
+	profile = appendMessage(profile, 5, indices)
+	for _, s := range [][3]uint64{{0, 2, 5}, {2, 1, 2}} {
+		var sample []byte
+		sample = appendVarint(sample, 1, s[0])
+		sample = appendVarint(sample, 2, s[1])
+		sample = appendVarint(sample, 3, s[2]) // unpacked
+		profile = appendMessage(profile, 2, sample)
+	}
+	profile = appendVarint(profile, 11, 1714557600*1e9)
+	profile = appendVarint(profile, 12, 10*1e9)
+	profile = appendMessage(profile, 7, stringKeyValue("thread.name", "worker{1}"))
+	profile = appendVarint(profile, 18, 0)
+
+	var resource, scope, resourceProfiles, req []byte
+	resource = appendMessage(resource, 1, stringKeyValue("service.name", "my service"))
+	resource = appendMessage(resource, 1, stringKeyValue("telemetry.sdk.language", "go"))
+	resource = appendMessage(resource, 1, stringKeyValue("__name__", "x"))
+	scope = appendMessage(scope, 2, profile)
+	resourceProfiles = appendMessage(resourceProfiles, 1, resource)
+	resourceProfiles = appendMessage(resourceProfiles, 2, scope)
+	return appendMessage(req, 1, resourceProfiles)
+}
+
+func TestParseOtlpProfiles(t *testing.T) {
+	profiles, err := parseOtlpProfiles(testOtlpRequest(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 {
+		t.Fatalf("expected 1 profile, got %d", len(profiles))
+	}
+	p := profiles[0]
+	name, _ := url.QueryUnescape(p.Name)
+	if name != "my_service{telemetry_sdk_language=go,thread_name=worker_1_}" {
+		t.Errorf("unexpected name %s", name)
+	}
+	if p.Format != "pprof" || p.SpyName != "gospy" || p.From != 1714557600 || p.Until != 1714557610 {
+		t.Errorf("unexpected profile %s %s %d %d", p.Format, p.SpyName, p.From, p.Until)
+	}
+
+	contentType := string(p.ContentType)
+	boundary := contentType[strings.Index(contentType, "boundary=")+len("boundary="):]
+	form, err := multipart.NewReader(bytes.NewReader(p.Data), boundary).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(form.File["sample_type_config"]) != 1 {
+		t.Errorf("sample type config is missing")
+	}
+	f, _ := form.File["profile"][0].Open()
+	var buf bytes.Buffer
+	buf.ReadFrom(f)
+	pp := &tree.Profile{}
+	if err := pp.UnmarshalVT(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	stacks := map[string]int64{}
+	for _, s := range pp.Sample {
+		var names []string
+		for _, id := range s.LocationId {
+			names = append(names, pp.StringTable[pp.Function[pp.Location[id-1].Line[0].FunctionId-1].Name])
+		}
+		stacks[strings.Join(names, ";")] = s.Value[0]
+	}
+	if !reflect.DeepEqual(stacks, map[string]int64{"work;main": 5, "main": 2}) {
+		t.Errorf("unexpected samples %v", stacks)
+	}
+}
+
+func TestParseOtlpProfilesOutOfRange(t *testing.T) {
+	var profile, sample, scope, resourceProfiles, req []byte
+	profile = appendString(profile, 10, "")
+	sample = appendVarint(sample, 1, 0)
+	sample = appendVarint(sample, 2, 3)
+	profile = appendMessage(profile, 2, sample)
+	scope = appendMessage(scope, 2, profile)
+	resourceProfiles = appendMessage(resourceProfiles, 2, scope)
+	req = appendMessage(req, 1, resourceProfiles)
+	if _, err := parseOtlpProfiles(req, time.Now()); err == nil {
+		t.Errorf("expected out of range error")
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/deepflowio/deepflow/server/ingester/profile/config"
 	"github.com/deepflowio/deepflow/server/ingester/profile/dbwriter"
 	"github.com/deepflowio/deepflow/server/ingester/profile/decoder"
+	"github.com/deepflowio/deepflow/server/ingester/profile/httpreceiver"
 	"github.com/deepflowio/deepflow/server/libs/ckdb"
 	"github.com/deepflowio/deepflow/server/libs/datatype"
 	"github.com/deepflowio/deepflow/server/libs/debug"
@@ -36,7 +37,9 @@ import (
 )
 
 type Profile struct {
-	Profiler *Profiler
+	Profiler     *Profiler
+	HttpProfiler *Profiler
+	HttpReceiver *httpreceiver.HttpReceiver
 }
 
 type Profiler struct {
@@ -50,9 +53,16 @@ func NewProfile(config *config.Config, recv *receiver.Receiver, platformDataMana
 	if err != nil {
 		return nil, err
 	}
-	return &Profile{
+	p := &Profile{
 		Profiler: profiler,
-	}, nil
+	}
+	if config.HttpReceiver.Enabled {
+		p.HttpProfiler, p.HttpReceiver, err = NewHttpProfiler(config, platformDataManager, manager)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
 }
 
 func NewProfiler(msgType datatype.MessageType, config *config.Config, platformDataManager *grpc.PlatformDataManager, manager *dropletqueue.Manager, recv *receiver.Receiver) (*Profiler, error) {
@@ -80,7 +90,7 @@ func NewProfiler(msgType datatype.MessageType, config *config.Config, platformDa
 		if err != nil {
 			return nil, err
 		}
-		profileWriter, err := dbwriter.NewProfileWriter(datatype.MESSAGE_TYPE_PROFILE, i, config)
+		profileWriter, err := dbwriter.NewProfileWriter(datatype.MESSAGE_TYPE_PROFILE, msgType.String(), i, config)
 		if err != nil {
 			return nil, err
 		}
@@ -101,6 +111,50 @@ func NewProfiler(msgType datatype.MessageType, config *config.Config, platformDa
 	}, nil
 }
 
+// NewHttpProfiler creates the decoders of the profiles pushed by the Pyroscope or OTLP clients,
+// the http receiver parses the requests and puts them into the decode queues.
+func NewHttpProfiler(config *config.Config, platformDataManager *grpc.PlatformDataManager, manager *dropletqueue.Manager) (*Profiler, *httpreceiver.HttpReceiver, error) {
+	queueCount := config.DecoderQueueCount
+	decodeQueues := manager.NewQueues(
+		"1-receive-to-decode-"+decoder.EXTERNAL_PROFILE_NAME,
+		config.DecoderQueueSize,
+		queueCount,
+		1,
+		libqueue.OptionFlushIndicator(3*time.Second))
+
+	decoders := make([]*decoder.Decoder, queueCount)
+	platformDatas := make([]*grpc.PlatformInfoTable, queueCount)
+	for i := 0; i < queueCount; i++ {
+		if platformDataManager != nil {
+			var err error
+			platformDatas[i], err = platformDataManager.NewPlatformInfoTable("profile-" + decoder.EXTERNAL_PROFILE_NAME + "-" + strconv.Itoa(i))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		appServiceTagWriter, err := flow_tag.NewAppServiceTagWriter(i, dbwriter.PROFILE_DB, decoder.EXTERNAL_PROFILE_NAME, config.ProfileTTL, ckdb.TimeFuncTwelveHour, config.Base)
+		if err != nil {
+			return nil, nil, err
+		}
+		profileWriter, err := dbwriter.NewProfileWriter(datatype.MESSAGE_TYPE_PROFILE, decoder.EXTERNAL_PROFILE_NAME, i, config)
+		if err != nil {
+			return nil, nil, err
+		}
+		decoders[i] = decoder.NewExternalProfileDecoder(
+			i,
+			*config.CompressionAlgorithm,
+			platformDatas[i],
+			queue.QueueReader(decodeQueues.FixedMultiQueue[i]),
+			profileWriter,
+			appServiceTagWriter,
+		)
+	}
+	return &Profiler{
+		Decoders:      decoders,
+		PlatformDatas: platformDatas,
+	}, httpreceiver.NewHttpReceiver(&config.HttpReceiver, decodeQueues, queueCount), nil
+}
+
 func (p *Profiler) Start() {
 	for _, platformData := range p.PlatformDatas {
 		if platformData != nil {
@@ -131,9 +185,17 @@ func (p *Profiler) Close() {
 
 func (p *Profile) Start() {
 	p.Profiler.Start()
+	if p.HttpProfiler != nil {
+		p.HttpProfiler.Start()
+		p.HttpReceiver.Start()
+	}
 }
 
 func (p *Profile) Close() error {
 	p.Profiler.Close()
+	if p.HttpProfiler != nil {
+		p.HttpReceiver.Close()
+		p.HttpProfiler.Close()
+	}
 	return nil
 }
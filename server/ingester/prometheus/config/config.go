@@ -37,8 +37,19 @@ const (
 	DefaultAppLabelColumnIncrement      = 8
 	DefaultAppLabelColumnMinCount       = 8
 	DefaultLabelCacheExpiration         = 86400 // 1 day
+
+	DefaultHttpReceiverPort        = 20046
+	DefaultHttpReceiverMaxBodySize = 16 << 20 // bytes
 )
 
+// HttpReceiverConfig configures the http endpoint compatible with the Prometheus remote write API,
+// the org/team of the samples is taken from the request headers instead of the agent.
+type HttpReceiverConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	ListenPort  int  `yaml:"listen-port"`
+	MaxBodySize int  `yaml:"max-body-size"`
+}
+
 type Config struct {
 	Base                         *config.Config
 	CKWriterConfig               config.CKWriterConfig `yaml:"prometheus-ck-writer"`
@@ -51,6 +62,7 @@ type Config struct {
 	AppLabelColumnMinCount       int                   `yaml:"prometheus-app-label-column-min-count"`
 	IgnoreUniversalTag           bool                  `yaml:"prometheus-sample-ignore-universal-tag"`
 	LabelCacheExpiration         int                   `yaml:"prometheus-label-cache-expiration"`
+	HttpReceiver                 HttpReceiverConfig    `yaml:"prometheus-http-receiver"`
 }
 
 type PrometheusConfig struct {
@@ -79,6 +91,12 @@ func (c *Config) Validate() error {
 	if c.LabelCacheExpiration <= 0 {
 		c.LabelCacheExpiration = DefaultLabelCacheExpiration
 	}
+	if c.HttpReceiver.ListenPort == 0 {
+		c.HttpReceiver.ListenPort = DefaultHttpReceiverPort
+	}
+	if c.HttpReceiver.MaxBodySize <= 0 {
+		c.HttpReceiver.MaxBodySize = DefaultHttpReceiverMaxBodySize
+	}
 
 	return nil
 }
@@ -96,6 +114,10 @@ func Load(base *config.Config, path string) *Config {
 			AppLabelColumnIncrement:      DefaultAppLabelColumnIncrement,
 			AppLabelColumnMinCount:       DefaultAppLabelColumnMinCount,
 			LabelCacheExpiration:         DefaultLabelCacheExpiration,
+			HttpReceiver: HttpReceiverConfig{
+				ListenPort:  DefaultHttpReceiverPort,
+				MaxBodySize: DefaultHttpReceiverMaxBodySize,
+			},
 		},
 	}
 	if _, err := os.Stat(path); os.IsNotExist(err) {
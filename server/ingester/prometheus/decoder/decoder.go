@@ -308,6 +308,10 @@ func (d *Decoder) sendPrometheus(vtapID uint16, ts *prompb.TimeSeries, extraLabe
 
 func (b *PrometheusSamplesBuilder) GetEpcPodClusterId(orgId, vtapID uint16) (uint16, uint16, error) {
 	epcId, podClusterId := int32(0), uint16(0)
+	if vtapID == 0 {
+		// received by the remote write api rather than sent by an agent, e.g. the results of recording rules
+		return 0, 0, nil
+	}
 	if vtapInfo := b.platformData.QueryVtapInfo(orgId, vtapID); vtapInfo != nil {
 		epcId, podClusterId = vtapInfo.EpcId, uint16(vtapInfo.PodClusterId)
 	}
@@ -562,9 +566,9 @@ func (b *PrometheusSamplesBuilder) fillUniversalTagSlow(m *dbwriter.PrometheusSa
 
 	var info *grpc.Info
 	if t.IsIPv6 == 1 {
-		info = b.platformData.QueryIPV6Infos(m.OrgId, t.L3EpcID, t.IP6)
+		info = b.platformData.QueryIPV6Infos(m.OrgId, t.L3EpcID, t.IP6, m.Timestamp)
 	} else {
-		info = b.platformData.QueryIPV4Infos(m.OrgId, t.L3EpcID, t.IP)
+		info = b.platformData.QueryIPV4Infos(m.OrgId, t.L3EpcID, t.IP, m.Timestamp)
 	}
 	podGroupType := uint8(0)
 	if info != nil {
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httpreceiver
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	logging "github.com/op/go-logging"
+
+	ingestercommon "github.com/deepflowio/deepflow/server/ingester/common"
+	"github.com/deepflowio/deepflow/server/ingester/prometheus/config"
+	"github.com/deepflowio/deepflow/server/libs/codec"
+	"github.com/deepflowio/deepflow/server/libs/flow-metrics/pb"
+	"github.com/deepflowio/deepflow/server/libs/queue"
+	"github.com/deepflowio/deepflow/server/libs/receiver"
+	"github.com/deepflowio/deepflow/server/libs/stats"
+	"github.com/deepflowio/deepflow/server/libs/utils"
+)
+
+var log = logging.MustGetLogger("prometheus.httpreceiver")
+
+const (
+	REMOTE_WRITE_PATH = "/api/v1/write"
+	// same path as the prometheus integration of deepflow-agent, so that the url only changes in host and port
+	AGENT_COMPATIBLE_PATH = "/api/v1/prometheus"
+)
+
+type Counter struct {
+	RequestCount int64 `statsd:"request-count"`
+	ErrorCount   int64 `statsd:"err-count"`
+	DropCount    int64 `statsd:"drop-count"`
+}
+
+// HttpReceiver receives the Prometheus remote write requests and puts them to the decode queues
+// as the data sent by agents, the org/team of the samples is taken from the request headers.
+type HttpReceiver struct {
+	config       *config.HttpReceiverConfig
+	decodeQueues queue.MultiQueueWriter
+	queueCount   int
+	putCount     uint64
+	server       *ingestercommon.HttpServer
+
+	counter *Counter
+	utils.Closable
+}
+
+func NewHttpReceiver(config *config.HttpReceiverConfig, decodeQueues queue.MultiQueueWriter, queueCount int) *HttpReceiver {
+	return &HttpReceiver{
+		config:       config,
+		decodeQueues: decodeQueues,
+		queueCount:   queueCount,
+		server:       ingestercommon.NewHttpServer("prometheus", config.ListenPort, config.MaxBodySize),
+		counter:      &Counter{},
+	}
+}
+
+func (r *HttpReceiver) GetCounter() interface{} {
+	counter := &Counter{
+		RequestCount: atomic.SwapInt64(&r.counter.RequestCount, 0),
+		ErrorCount:   r.server.SwapErrorCount(),
+		DropCount:    atomic.SwapInt64(&r.counter.DropCount, 0),
+	}
+	return counter
+}
+
+func (r *HttpReceiver) RegisterHandlers() {
+	router := r.server.Router()
+	router.HandleFunc(REMOTE_WRITE_PATH, r.remoteWrite).Methods("POST")
+	router.HandleFunc(AGENT_COMPATIBLE_PATH, r.remoteWrite).Methods("POST")
+}
+
+func (r *HttpReceiver) Start() {
+	r.RegisterHandlers()
+	ingestercommon.RegisterCountableForIngester("prometheus_http_receiver", r, stats.OptionStatTags{"port": strconv.Itoa(r.config.ListenPort)})
+	r.server.Start()
+}
+
+func (r *HttpReceiver) Close() error {
+	r.Closable.Close()
+	return r.server.Close()
+}
+
+// readBody reads the snappy compressed body, ErrBodyTooLarge is returned if the body is larger
+// than MaxBodySize before or after decompression
+func (r *HttpReceiver) readBody(req *http.Request) ([]byte, error) {
+	body, err := r.server.ReadBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.server.CheckSnappyDecodedLen(body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (r *HttpReceiver) remoteWrite(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&r.counter.RequestCount, 1)
+	orgId, teamId, err := ingestercommon.ParseOrgTeamID(req)
+	if err != nil {
+		r.server.RespError(w, http.StatusBadRequest, err)
+		return
+	}
+	body, err := r.readBody(req)
+	if err != nil {
+		r.server.RespBodyError(w, err)
+		return
+	}
+
+	// encoded as the messages of agents, the decoder handles them in the same way
+	metric := &pb.PrometheusMetric{Metrics: body}
+	data, err := metric.Marshal()
+	if err != nil {
+		r.server.RespError(w, http.StatusInternalServerError, err)
+		return
+	}
+	encoder := &codec.SimpleEncoder{}
+	encoder.WriteBytes(data)
+	encoded := encoder.Bytes()
+
+	recvBuffer, _ := receiver.AcquireRecvBuffer(len(encoded), receiver.TCP)
+	recvBuffer.Begin, recvBuffer.End = 0, copy(recvBuffer.Buffer, encoded)
+	recvBuffer.VtapID = 0
+	recvBuffer.OrgID, recvBuffer.TeamID = orgId, uint32(teamId)
+	key := queue.HashKey(atomic.AddUint64(&r.putCount, 1) % uint64(r.queueCount))
+	if err := r.decodeQueues.Put(key, recvBuffer); err != nil {
+		atomic.AddInt64(&r.counter.DropCount, 1)
+		receiver.ReleaseRecvBuffer(recvBuffer)
+		r.server.RespError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httpreceiver
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+
+	ingestercommon "github.com/deepflowio/deepflow/server/ingester/common"
+)
+
+func TestReadBody(t *testing.T) {
+	r := &HttpReceiver{server: ingestercommon.NewHttpServer("prometheus", 0, 64)}
+
+	body := snappy.Encode(nil, bytes.Repeat([]byte("a"), 64))
+	req := httptest.NewRequest("POST", REMOTE_WRITE_PATH, bytes.NewReader(body))
+	if data, err := r.readBody(req); err != nil || !bytes.Equal(data, body) {
+		t.Errorf("read body of max size failed, err %v", err)
+	}
+	// compressed body is small, but the decompressed body is over the limit
+	req = httptest.NewRequest("POST", REMOTE_WRITE_PATH, bytes.NewReader(snappy.Encode(nil, bytes.Repeat([]byte("a"), 65))))
+	if _, err := r.readBody(req); !errors.Is(err, ingestercommon.ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+	req = httptest.NewRequest("POST", REMOTE_WRITE_PATH, bytes.NewReader(bytes.Repeat([]byte{0xff}, 65)))
+	if _, err := r.readBody(req); !errors.Is(err, ingestercommon.ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+	req = httptest.NewRequest("POST", REMOTE_WRITE_PATH, bytes.NewReader([]byte{0xff}))
+	if _, err := r.readBody(req); err == nil || errors.Is(err, ingestercommon.ErrBodyTooLarge) {
+		t.Errorf("expected invalid snappy body, got %v", err)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/deepflowio/deepflow/server/ingester/prometheus/config"
 	"github.com/deepflowio/deepflow/server/ingester/prometheus/dbwriter"
 	"github.com/deepflowio/deepflow/server/ingester/prometheus/decoder"
+	"github.com/deepflowio/deepflow/server/ingester/prometheus/httpreceiver"
 	"github.com/deepflowio/deepflow/server/libs/datatype"
 	"github.com/deepflowio/deepflow/server/libs/debug"
 	"github.com/deepflowio/deepflow/server/libs/grpc"
@@ -44,6 +45,7 @@ type PrometheusHandler struct {
 	PlatformDatas        []*grpc.PlatformInfoTable
 	SlowPlatformDatas    []*grpc.PlatformInfoTable
 	prometheusLabelTable *decoder.PrometheusLabelTable
+	HttpReceiver         *httpreceiver.HttpReceiver
 }
 
 func NewPrometheusHandler(config *config.Config, recv *receiver.Receiver, platformDataManager *grpc.PlatformDataManager) (*PrometheusHandler, error) {
@@ -66,6 +68,10 @@ func NewPrometheusHandler(config *config.Config, recv *receiver.Receiver, platfo
 		libqueue.OptionRelease(func(p interface{}) { decoder.ReleaseSlowItem(p.(*decoder.SlowItem)) }))
 
 	recv.RegistHandler(msgType, decodeQueues, queueCount)
+	var httpReceiver *httpreceiver.HttpReceiver
+	if config.HttpReceiver.Enabled {
+		httpReceiver = httpreceiver.NewHttpReceiver(&config.HttpReceiver, decodeQueues, queueCount)
+	}
 
 	prometheusLabelTable := decoder.NewPrometheusLabelTable(config.Base.ControllerIPs, int(config.Base.ControllerPort), config.LabelMsgMaxSize, config.LabelCacheExpiration)
 
@@ -123,6 +129,7 @@ func NewPrometheusHandler(config *config.Config, recv *receiver.Receiver, platfo
 		SlowPlatformDatas:    slowPlatformDatas,
 		prometheusLabelTable: prometheusLabelTable,
 		SlowDecoders:         slowDecoders,
+		HttpReceiver:         httpReceiver,
 	}, nil
 }
 
@@ -136,9 +143,15 @@ func (m *PrometheusHandler) Start() {
 		go decoder.Run()
 		go m.SlowDecoders[i].Run()
 	}
+	if m.HttpReceiver != nil {
+		m.HttpReceiver.Start()
+	}
 }
 
 func (m *PrometheusHandler) Close() error {
+	if m.HttpReceiver != nil {
+		m.HttpReceiver.Close()
+	}
 	for i, platformData := range m.PlatformDatas {
 		platformData.ClosePlatformInfoTable()
 		m.SlowPlatformDatas[i].ClosePlatformInfoTable()
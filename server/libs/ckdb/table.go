@@ -330,6 +330,10 @@ func (t *Table) makeTTLString(duration int) string {
 	return fmt.Sprintf("%s + toIntervalHour(%d)", t.TimeKey, duration)
 }
 
+func (t *Table) MakeOrgModifyTTLSQL(orgID uint16, ttl int) string {
+	return fmt.Sprintf("ALTER TABLE %s.`%s` MODIFY TTL %s", t.OrgDatabase(orgID), t.LocalName, t.makeTTLString(ttl))
+}
+
 func isUnsummable(column *Column) bool {
 	if strings.HasSuffix(column.Name, "_max") ||
 		column.Name == "direction_score" {
@@ -345,6 +349,14 @@ func getAggr(column *Column) string {
 	return "sum"
 }
 
+// return the column name and type in the 1h/1d agg table
+func (t *Table) AggrColumn(c *Column) (string, string) {
+	if c.GroupBy {
+		return c.Name, c.Type.String()
+	}
+	return c.Name + "__agg", fmt.Sprintf("AggregateFunction(%s, %s)", getAggr(c), c.Type.String())
+}
+
 func (t *Table) MakeAggrTableCreateSQL1H(orgID uint16) string {
 	return t.MakeAggrTableCreateSQL(orgID, AggregationHour, DEFAULT_1H_TTL)
 }
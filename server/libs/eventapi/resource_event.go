@@ -26,6 +26,11 @@ const (
 	RESOURCE_EVENT_TYPE_RECREATE     = "recreate"
 	RESOURCE_EVENT_TYPE_ADD_IP       = "add-ip"
 	RESOURCE_EVENT_TYPE_REMOVE_IP    = "remove-ip"
+
+	RESOURCE_EVENT_TYPE_AGENT_ADMIT   = "agent-admit"
+	RESOURCE_EVENT_TYPE_AGENT_PENDING = "agent-pending"
+	RESOURCE_EVENT_TYPE_AGENT_APPROVE = "agent-approve"
+	RESOURCE_EVENT_TYPE_AGENT_REJECT  = "agent-reject"
 )
 
 type ResourceEvent struct {
@@ -37,6 +42,7 @@ type ResourceEvent struct {
 	InstanceName       string
 	AttributeSubnetIDs []uint32
 	AttributeIPs       []string
+	AttributeMACs      []string
 	Description        string
 	GProcessID         uint32 // if this value is set, InstanceType and InstanceID are empty
 	GProcessName       string // if this value is set, InstanceName is empty
@@ -76,6 +82,12 @@ func TagAttributeIPs(ips []string) TagFieldOption {
 	}
 }
 
+func TagAttributeMACs(macs []string) TagFieldOption {
+	return func(r *ResourceEvent) {
+		r.AttributeMACs = macs
+	}
+}
+
 func TagDescription(description string) TagFieldOption {
 	return func(r *ResourceEvent) {
 		r.Description = description
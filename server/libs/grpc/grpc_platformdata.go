@@ -141,6 +141,11 @@ type Counter struct {
 	ContainerTotalCount int64 `statsd:"container-total-count"`
 	ContainerHitCount   int64 `statsd:"container-hit-count"`
 	ContainerMissCount  int64 `statsd:"container-miss-count"`
+
+	HistoryHitCount  int64 `statsd:"history-hit-count"`
+	HistoryMissCount int64 `statsd:"history-miss-count"`
+	HistoryVersions  int64 `statsd:"history-versions"`
+	HistoryMemory    int64 `statsd:"history-memory"`
 }
 
 type PlatformInfoTable struct {
@@ -173,6 +178,11 @@ type PlatformInfoTable struct {
 	// podIPInfos is first obtained from podIDInfosPlatformData and needs to be supplemented by podIPs information.
 	podIDInfos [MAX_ORG_COUNT]map[uint32]*Info
 
+	// superseded platform data versions, used to tag the late arriving data with the platform data at that time
+	historyLimits         HistoryLimits
+	history               [MAX_ORG_COUNT]*platformDataHistory
+	platformDataValidFrom [MAX_ORG_COUNT]uint32
+
 	bootTime            uint32
 	moduleName          string
 	versionPlatformData [MAX_ORG_COUNT]uint64
@@ -185,6 +195,7 @@ type PlatformInfoTable struct {
 	ServiceTable  [MAX_ORG_COUNT]*ServiceTable
 
 	podNameInfos       [MAX_ORG_COUNT]map[string][]*PodInfo
+	podIpInfos         [MAX_ORG_COUNT]map[string][]*PodInfo
 	vtapIdInfos        [MAX_ORG_COUNT]map[uint16]*VtapInfo
 	orgIds             []uint16
 	orgIdsUpdateTime   uint32
@@ -246,6 +257,7 @@ func QueryVtapOrgAndTeamID(orgId, vtapId uint16) (uint16, uint16) {
 func (t *PlatformInfoTable) GetCounter() interface{} {
 	var counter *Counter
 	counter, t.counter = t.counter, &Counter{}
+	counter.HistoryVersions, counter.HistoryMemory = t.historyStats()
 	return counter
 }
 
@@ -280,19 +292,37 @@ func (t *PlatformInfoTable) QueryEpcIDBaseInfosPair(orgId uint16, epcID0, epcID1
 	return t.queryEpcIDBaseInfosPair(orgId, epcID0, epcID1)
 }
 
-func (t *PlatformInfoTable) QueryMacInfo(orgId uint16, mac uint64) *Info {
+// timestamp(s) is the time of the data, used to query the platform data version active at that time, 0 means the current version
+func (t *PlatformInfoTable) QueryMacInfo(orgId uint16, mac uint64, timestamp uint32) *Info {
+	if version := t.queryHistory(orgId, timestamp); version != nil {
+		info := version.queryMacInfo(mac)
+		historyInfoStat(info)
+		return info
+	}
 	return t.queryMacInfo(orgId, mac)
 }
 
-func (t *PlatformInfoTable) QueryMacInfosPair(orgId uint16, mac0, mac1 uint64) (*Info, *Info) {
+func (t *PlatformInfoTable) QueryMacInfosPair(orgId uint16, mac0, mac1 uint64, timestamp uint32) (*Info, *Info) {
+	if version := t.queryHistory(orgId, timestamp); version != nil {
+		info0, info1 := version.queryMacInfo(mac0), version.queryMacInfo(mac1)
+		historyInfoStat(info0)
+		historyInfoStat(info1)
+		return info0, info1
+	}
 	return t.queryMacInfosPair(orgId, mac0, mac1)
 }
 
-func (t *PlatformInfoTable) QueryIPV4Infos(orgId uint16, epcID int32, ipv4 uint32) *Info {
+func (t *PlatformInfoTable) QueryIPV4Infos(orgId uint16, epcID int32, ipv4 uint32, timestamp uint32) *Info {
 	if epcID == datatype.EPC_FROM_INTERNET {
 		return nil
 	}
-	info := t.queryIPV4Infos(orgId, epcID, ipv4)
+	var info *Info
+	if version := t.queryHistory(orgId, timestamp); version != nil {
+		info = version.queryIPV4Infos(epcID, ipv4)
+		historyInfoStat(info)
+	} else {
+		info = t.queryIPV4Infos(orgId, epcID, ipv4)
+	}
 	if info != nil {
 		return info
 	}
@@ -306,11 +336,17 @@ func (t *PlatformInfoTable) QueryIPV4Infos(orgId uint16, epcID int32, ipv4 uint3
 	}
 }
 
-func (t *PlatformInfoTable) QueryIPV6Infos(orgId uint16, epcID int32, ipv6 net.IP) *Info {
+func (t *PlatformInfoTable) QueryIPV6Infos(orgId uint16, epcID int32, ipv6 net.IP, timestamp uint32) *Info {
 	if epcID == datatype.EPC_FROM_INTERNET {
 		return nil
 	}
-	info := t.queryIPV6Infos(orgId, epcID, ipv6)
+	var info *Info
+	if version := t.queryHistory(orgId, timestamp); version != nil {
+		info = version.queryIPV6Infos(epcID, ipv6)
+		historyInfoStat(info)
+	} else {
+		info = t.queryIPV6Infos(orgId, epcID, ipv6)
+	}
 	if info != nil {
 		return info
 	}
@@ -324,13 +360,19 @@ func (t *PlatformInfoTable) QueryIPV6Infos(orgId uint16, epcID int32, ipv6 net.I
 	}
 }
 
-func (t *PlatformInfoTable) QueryIPV4InfosPair(orgId uint16, epcID0 int32, ipv40 uint32, epcID1 int32, ipv41 uint32) (info0 *Info, info1 *Info) {
+func (t *PlatformInfoTable) QueryIPV4InfosPair(orgId uint16, epcID0 int32, ipv40 uint32, epcID1 int32, ipv41 uint32, timestamp uint32) (info0 *Info, info1 *Info) {
 	if epcID0 == datatype.EPC_FROM_INTERNET {
-		return nil, t.QueryIPV4Infos(orgId, epcID1, ipv41)
+		return nil, t.QueryIPV4Infos(orgId, epcID1, ipv41, timestamp)
 	} else if epcID1 == datatype.EPC_FROM_INTERNET {
-		return t.QueryIPV4Infos(orgId, epcID0, ipv40), nil
+		return t.QueryIPV4Infos(orgId, epcID0, ipv40, timestamp), nil
+	}
+	if version := t.queryHistory(orgId, timestamp); version != nil {
+		info0, info1 = version.queryIPV4Infos(epcID0, ipv40), version.queryIPV4Infos(epcID1, ipv41)
+		historyInfoStat(info0)
+		historyInfoStat(info1)
+	} else {
+		info0, info1 = t.queryIPV4InfosPair(orgId, epcID0, ipv40, epcID1, ipv41)
 	}
-	info0, info1 = t.queryIPV4InfosPair(orgId, epcID0, ipv40, epcID1, ipv41)
 	if info0 == nil {
 		if baseInfo := t.queryEpcIDBaseInfo(orgId, int32(epcID0)); baseInfo != nil {
 			info0 = &Info{
@@ -348,13 +390,19 @@ func (t *PlatformInfoTable) QueryIPV4InfosPair(orgId uint16, epcID0 int32, ipv40
 	return
 }
 
-func (t *PlatformInfoTable) QueryIPV6InfosPair(orgId uint16, epcID0 int32, ipv60 net.IP, epcID1 int32, ipv61 net.IP) (info0 *Info, info1 *Info) {
+func (t *PlatformInfoTable) QueryIPV6InfosPair(orgId uint16, epcID0 int32, ipv60 net.IP, epcID1 int32, ipv61 net.IP, timestamp uint32) (info0 *Info, info1 *Info) {
 	if epcID0 == datatype.EPC_FROM_INTERNET {
-		return nil, t.QueryIPV6Infos(orgId, epcID1, ipv61)
+		return nil, t.QueryIPV6Infos(orgId, epcID1, ipv61, timestamp)
 	} else if epcID1 == datatype.EPC_FROM_INTERNET {
-		return t.QueryIPV6Infos(orgId, epcID0, ipv60), nil
+		return t.QueryIPV6Infos(orgId, epcID0, ipv60, timestamp), nil
+	}
+	if version := t.queryHistory(orgId, timestamp); version != nil {
+		info0, info1 = version.queryIPV6Infos(epcID0, ipv60), version.queryIPV6Infos(epcID1, ipv61)
+		historyInfoStat(info0)
+		historyInfoStat(info1)
+	} else {
+		info0, info1 = t.queryIPV6InfosPair(orgId, epcID0, ipv60, epcID1, ipv61)
 	}
-	info0, info1 = t.queryIPV6InfosPair(orgId, epcID0, ipv60, epcID1, ipv61)
 	if info0 == nil {
 		if baseInfo := t.queryEpcIDBaseInfo(orgId, int32(epcID0)); baseInfo != nil {
 			info0 = &Info{
@@ -415,14 +463,16 @@ type PlatformDataManager struct {
 	rpcMaxMsgSize int
 	nodeIP        string
 	receiver      *receiver.Receiver
+	historyLimits HistoryLimits
 }
 
 var platformDataManager *PlatformDataManager
 
-func NewPlatformDataManager(ips []net.IP, port, maxSlaveTableSize, rpcMaxMsgSize int, nodeIP string, receiver *receiver.Receiver) *PlatformDataManager {
+func NewPlatformDataManager(ips []net.IP, port, maxSlaveTableSize, rpcMaxMsgSize int, nodeIP string, receiver *receiver.Receiver, historyLimits HistoryLimits) *PlatformDataManager {
 	if platformDataManager != nil {
 		return platformDataManager
 	}
+	historyLimits.fillDefaults()
 	platformDataManager = &PlatformDataManager{
 		slaveTables:       make([]*PlatformInfoTable, maxSlaveTableSize),
 		maxSlaveTableSize: maxSlaveTableSize,
@@ -431,6 +481,7 @@ func NewPlatformDataManager(ips []net.IP, port, maxSlaveTableSize, rpcMaxMsgSize
 		rpcMaxMsgSize:     rpcMaxMsgSize,
 		nodeIP:            nodeIP,
 		receiver:          receiver,
+		historyLimits:     historyLimits,
 	}
 	return platformDataManager
 }
@@ -485,6 +536,10 @@ func NewPlatformInfoTable(ips []net.IP, port, index, rpcMaxMsgSize int, moduleNa
 		ctlIP:   nodeIP,
 		counter: &Counter{},
 	}
+	if manager != nil {
+		table.historyLimits = manager.historyLimits
+	}
+	table.historyLimits.fillDefaults()
 	for i := 0; i < MAX_ORG_COUNT; i++ {
 		table.epcIDIPV4Infos[i] = make(map[uint64]*Info)
 		table.epcIDIPV6Infos[i] = make(map[[EpcIDIPV6_LEN]byte]*Info)
@@ -499,6 +554,7 @@ func NewPlatformInfoTable(ips []net.IP, port, index, rpcMaxMsgSize int, moduleNa
 		table.podIDInfos[i] = make(map[uint32]*Info)
 		table.ServiceTable[i] = NewServiceTable(nil)
 		table.podNameInfos[i] = make(map[string][]*PodInfo)
+		table.podIpInfos[i] = make(map[string][]*PodInfo)
 		table.vtapIdInfos[i] = make(map[uint16]*VtapInfo)
 		table.containerInfos[i] = make(map[string][]*PodInfo)
 		table.containerMissCount[i] = make(map[string]*uint64)
@@ -804,41 +860,12 @@ func (t *PlatformInfoTable) queryIPV6InfosPair(orgId uint16, epcID0 int32, ipv60
 
 // 查询Cidr之前，需要先查询过epcip表, 否则会覆盖epcip表的内容
 func (t *PlatformInfoTable) queryIPV4Cidr(orgId uint16, epcID int32, ipv4 uint32) *Info {
-	var info *Info
-	if cidrInfos, exist := t.epcIDIPV4CidrInfos[orgId][int32(epcID)]; exist {
-		ip := utils.IpFromUint32(ipv4)
-		for _, cidrInfo := range cidrInfos {
-			if cidrInfo.Cidr.Contains(ip) {
-				info = &Info{
-					SubnetID: cidrInfo.SubnetID,
-					RegionID: cidrInfo.RegionID,
-					AZID:     cidrInfo.AZID,
-					HitCount: cidrInfo.HitCount,
-				}
-				break
-			}
-		}
-	}
-	return info
+	return lookupIPV4Cidr(t.epcIDIPV4CidrInfos[orgId], epcID, ipv4)
 }
 
 // 查询Cidr之前，需要先查询过epcip表, 否则会覆盖epcip表的内容
 func (t *PlatformInfoTable) queryIPV6Cidr(orgId uint16, epcID int32, ipv6 net.IP) *Info {
-	var info *Info
-	if cidrInfos, exist := t.epcIDIPV6CidrInfos[orgId][epcID]; exist {
-		for _, cidrInfo := range cidrInfos {
-			if cidrInfo.Cidr.Contains(ipv6) {
-				info = &Info{
-					SubnetID: cidrInfo.SubnetID,
-					RegionID: cidrInfo.RegionID,
-					AZID:     cidrInfo.AZID,
-					HitCount: cidrInfo.HitCount,
-				}
-				break
-			}
-		}
-	}
-	return info
+	return lookupIPV6Cidr(t.epcIDIPV6CidrInfos[orgId], epcID, ipv6)
 }
 
 func (t *PlatformInfoTable) String() string {
@@ -1030,6 +1057,11 @@ func (t *PlatformInfoTable) HandleSimpleCommand(op uint16, arg string) string {
 		return t.gprocessInfosString(orgId)
 	case "container":
 		return t.containersString(orgId)
+	case "history":
+		if history := t.history[orgId]; history != nil {
+			return history.String()
+		}
+		return "no history platform data\n"
 	}
 
 	filter := arg
@@ -1097,6 +1129,10 @@ func (t *PlatformInfoTable) updatePlatformData(orgId uint16, platformData *tride
 	t.updatePeerConnections(orgId, platformData.GetPeerConnections())
 	t.updateGprocessInfos(orgId, platformData.GetGprocessInfos())
 
+	now := time.Now()
+	t.saveHistory(orgId, now)
+	t.platformDataValidFrom[orgId] = uint32(now.Unix())
+
 	t.epcIDIPV4Infos[orgId] = newEpcIDIPV4Infos
 	t.epcIDIPV4CidrInfos[orgId] = newEpcIDIPV4CidrInfos
 	if t.epcIDIPV4Lru[orgId] != nil {
@@ -1197,12 +1233,16 @@ func (t *PlatformInfoTable) ReloadSlave(orgId uint16) error {
 		t.podNodeInfos[orgId] = masterTable.podNodeInfos[orgId]
 		t.hostInfos[orgId] = masterTable.hostInfos[orgId]
 		t.vmInfos[orgId] = masterTable.vmInfos[orgId]
+
+		t.history[orgId] = masterTable.history[orgId]
+		t.platformDataValidFrom[orgId] = masterTable.platformDataValidFrom[orgId]
 	}
 	t.vtapIdInfos[orgId] = masterTable.vtapIdInfos[orgId]
 	t.orgIds = masterTable.orgIds
 	t.orgIdExists = masterTable.orgIdExists
 	t.orgIdsUpdateTime = masterTable.orgIdsUpdateTime
 	t.podNameInfos[orgId] = masterTable.podNameInfos[orgId]
+	t.podIpInfos[orgId] = masterTable.podIpInfos[orgId]
 	t.regionID = masterTable.regionID
 	t.analyzerID = masterTable.analyzerID
 	t.containerInfos[orgId] = masterTable.containerInfos[orgId]
@@ -1299,6 +1339,9 @@ func (t *PlatformInfoTable) ReloadMaster(orgId uint16) error {
 	}
 	platformTime := int64(time.Since(start)) - grpcRequestTime - serviceTime
 	t.counter.UpdatePlatformTime += platformTime
+	if history := t.history[orgId]; history != nil {
+		history.prune(time.Now())
+	}
 
 	t.updateOthers(orgId, response)
 
@@ -1836,8 +1879,38 @@ func parseIP(ipStr string) (isIPv4 bool, ip4U32 uint32, ip6 net.IP) {
 	return
 }
 
+// QueryPodInfoByName is used when there is no agent to determine the pod cluster, such as
+// logs pushed directly to the ingester. Pod names may repeat across clusters, so podIp is used
+// to pick one of them; nil is returned when the pod still cannot be identified uniquely.
+func (t *PlatformInfoTable) QueryPodInfoByName(orgId uint16, podName, podIp string) *PodInfo {
+	var podInfos []*PodInfo
+	if podName != "" {
+		podInfos = t.podNameInfos[orgId][podName]
+	} else if podIp != "" {
+		podInfos = t.podIpInfos[orgId][podIp]
+	}
+	if len(podInfos) == 1 {
+		return podInfos[0]
+	}
+	if podIp == "" {
+		return nil
+	}
+	var found *PodInfo
+	for _, podInfo := range podInfos {
+		if podInfo.Ip != podIp {
+			continue
+		}
+		if found != nil {
+			return nil
+		}
+		found = podInfo
+	}
+	return found
+}
+
 func (t *PlatformInfoTable) updatePodIps(orgId uint16, podIps []*trident.PodIp) {
 	podNameInfos := make(map[string][]*PodInfo)
+	podIpInfos := make(map[string][]*PodInfo)
 	containerInfos := make(map[string][]*PodInfo)
 
 	podIDInfos := make(map[uint32]*Info)
@@ -1878,6 +1951,9 @@ func (t *PlatformInfoTable) updatePodIps(orgId uint16, podIps []*trident.PodIp)
 		} else {
 			podNameInfos[podName] = []*PodInfo{podInfo}
 		}
+		if pIp != "" {
+			podIpInfos[pIp] = append(podIpInfos[pIp], podInfo)
+		}
 		for _, containerId := range containerIds {
 			if podInfos, ok := containerInfos[containerId]; ok {
 				containerInfos[containerId] = append(podInfos, podInfo)
@@ -1895,9 +1971,9 @@ func (t *PlatformInfoTable) updatePodIps(orgId uint16, podIps []*trident.PodIp)
 				isIPv4, ip4, ip6 := parseIP(ip)
 				var infoPtr *Info
 				if isIPv4 {
-					infoPtr = t.QueryIPV4Infos(orgId, epcId, ip4)
+					infoPtr = t.QueryIPV4Infos(orgId, epcId, ip4, 0)
 				} else {
-					infoPtr = t.QueryIPV6Infos(orgId, epcId, ip6)
+					infoPtr = t.QueryIPV6Infos(orgId, epcId, ip6, 0)
 				}
 				if infoPtr != nil {
 					info = *infoPtr
@@ -1915,6 +1991,7 @@ func (t *PlatformInfoTable) updatePodIps(orgId uint16, podIps []*trident.PodIp)
 		}
 	}
 	t.podNameInfos[orgId] = podNameInfos
+	t.podIpInfos[orgId] = podIpInfos
 	t.containerInfos[orgId] = containerInfos
 	t.podIDInfos[orgId] = podIDInfos
 }
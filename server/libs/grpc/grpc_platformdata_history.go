@@ -0,0 +1,289 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package grpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/deepflowio/deepflow/server/libs/utils"
+)
+
+const (
+	DefaultHistoryMaxVersions = 20
+	DefaultHistoryMaxAge      = 30 * time.Minute
+	DefaultHistoryMaxMemory   = 256 << 20 // bytes
+
+	// approximate overhead of a map bucket slot beyond the key and the value
+	historyMapEntryOverhead = 16
+)
+
+// HistoryLimits bounds the superseded platform data versions kept by the master table.
+// A version is evicted when any of the limits is exceeded, the oldest first.
+type HistoryLimits struct {
+	Disabled    bool
+	MaxVersions int
+	MaxAge      time.Duration
+	MaxMemory   int64 // bytes
+}
+
+func (l *HistoryLimits) fillDefaults() {
+	if l.MaxVersions <= 0 {
+		l.MaxVersions = DefaultHistoryMaxVersions
+	}
+	if l.MaxAge <= 0 {
+		l.MaxAge = DefaultHistoryMaxAge
+	}
+	if l.MaxMemory <= 0 {
+		l.MaxMemory = DefaultHistoryMaxMemory
+	}
+}
+
+// platformDataVersion is a superseded platform data version, it was active in [validFrom, validTo).
+// The maps are never modified after being replaced, so they can be read without lock.
+type platformDataVersion struct {
+	version   uint64
+	validFrom uint32
+	validTo   uint32
+	size      int64
+
+	epcIDIPV4Infos     map[uint64]*Info
+	epcIDIPV6Infos     map[[EpcIDIPV6_LEN]byte]*Info
+	epcIDIPV4CidrInfos map[int32][]*CidrInfo
+	epcIDIPV6CidrInfos map[int32][]*CidrInfo
+	macInfos           map[uint64]*Info
+}
+
+func (v *platformDataVersion) estimateSize() int64 {
+	infoSize := int64(unsafe.Sizeof(Info{})) + 8 + historyMapEntryOverhead
+	size := int64(len(v.epcIDIPV4Infos))*(infoSize+8) +
+		int64(len(v.epcIDIPV6Infos))*(infoSize+EpcIDIPV6_LEN) +
+		int64(len(v.macInfos))*(infoSize+8)
+	cidrSize := int64(unsafe.Sizeof(CidrInfo{})) + int64(unsafe.Sizeof(net.IPNet{})) + 8
+	for _, cidrs := range v.epcIDIPV4CidrInfos {
+		size += int64(len(cidrs)) * cidrSize
+	}
+	for _, cidrs := range v.epcIDIPV6CidrInfos {
+		size += int64(len(cidrs)) * cidrSize
+	}
+	return size
+}
+
+func (v *platformDataVersion) queryIPV4Infos(epcID int32, ipv4 uint32) *Info {
+	if info, ok := v.epcIDIPV4Infos[uint64(epcID)<<32|uint64(ipv4)]; ok {
+		return info
+	}
+	return lookupIPV4Cidr(v.epcIDIPV4CidrInfos, epcID, ipv4)
+}
+
+func (v *platformDataVersion) queryIPV6Infos(epcID int32, ipv6 net.IP) *Info {
+	if info, ok := v.epcIDIPV6Infos[epcIDIPV6Key(epcID, ipv6)]; ok {
+		return info
+	}
+	return lookupIPV6Cidr(v.epcIDIPV6CidrInfos, epcID, ipv6)
+}
+
+func (v *platformDataVersion) queryMacInfo(mac uint64) *Info {
+	return v.macInfos[mac]
+}
+
+// platformDataHistory is created by the master table, and shared with the slave tables.
+type platformDataHistory struct {
+	sync.RWMutex
+	limits   HistoryLimits
+	versions []*platformDataVersion // sorted by validFrom, the oldest first
+	size     int64
+}
+
+func newPlatformDataHistory(limits HistoryLimits) *platformDataHistory {
+	return &platformDataHistory{limits: limits}
+}
+
+func (h *platformDataHistory) add(v *platformDataVersion, now time.Time) {
+	v.size = v.estimateSize()
+	h.Lock()
+	h.versions = append(h.versions, v)
+	h.size += v.size
+	h.pruneLocked(now)
+	h.Unlock()
+}
+
+func (h *platformDataHistory) prune(now time.Time) {
+	h.Lock()
+	h.pruneLocked(now)
+	h.Unlock()
+}
+
+func (h *platformDataHistory) pruneLocked(now time.Time) {
+	expired := uint32(now.Add(-h.limits.MaxAge).Unix())
+	evict := 0
+	for evict < len(h.versions) {
+		v := h.versions[evict]
+		if len(h.versions)-evict <= h.limits.MaxVersions && h.size <= h.limits.MaxMemory && v.validTo > expired {
+			break
+		}
+		h.size -= v.size
+		h.versions[evict] = nil
+		evict++
+	}
+	if evict > 0 {
+		h.versions = append(h.versions[:0], h.versions[evict:]...)
+	}
+}
+
+// find returns the version which was active at timestamp, or nil if it has been evicted
+func (h *platformDataHistory) find(timestamp uint32) *platformDataVersion {
+	h.RLock()
+	defer h.RUnlock()
+	for i := len(h.versions) - 1; i >= 0; i-- {
+		v := h.versions[i]
+		if timestamp >= v.validTo {
+			return nil
+		}
+		if timestamp >= v.validFrom {
+			return v
+		}
+	}
+	return nil
+}
+
+func (h *platformDataHistory) stats() (int, int64) {
+	h.RLock()
+	defer h.RUnlock()
+	return len(h.versions), h.size
+}
+
+func (h *platformDataHistory) String() string {
+	h.RLock()
+	defer h.RUnlock()
+	sb := &strings.Builder{}
+	sb.WriteString(fmt.Sprintf("history versions:%d size:%d maxVersions:%d maxAge:%s maxMemory:%d\n",
+		len(h.versions), h.size, h.limits.MaxVersions, h.limits.MaxAge, h.limits.MaxMemory))
+	for _, v := range h.versions {
+		sb.WriteString(fmt.Sprintf("  version:%d validFrom:%s validTo:%s ipv4:%d ipv6:%d mac:%d size:%d\n",
+			v.version, time.Unix(int64(v.validFrom), 0).Format(time.RFC3339), time.Unix(int64(v.validTo), 0).Format(time.RFC3339),
+			len(v.epcIDIPV4Infos), len(v.epcIDIPV6Infos), len(v.macInfos), v.size))
+	}
+	return sb.String()
+}
+
+// saveHistory keeps the platform data version which is going to be replaced
+func (t *PlatformInfoTable) saveHistory(orgId uint16, now time.Time) {
+	if t.historyLimits.Disabled || t.platformDataValidFrom[orgId] == 0 {
+		return
+	}
+	if t.history[orgId] == nil {
+		t.history[orgId] = newPlatformDataHistory(t.historyLimits)
+	}
+	t.history[orgId].add(&platformDataVersion{
+		version:            t.versionPlatformData[orgId],
+		validFrom:          t.platformDataValidFrom[orgId],
+		validTo:            uint32(now.Unix()),
+		epcIDIPV4Infos:     t.epcIDIPV4Infos[orgId],
+		epcIDIPV6Infos:     t.epcIDIPV6Infos[orgId],
+		epcIDIPV4CidrInfos: t.epcIDIPV4CidrInfos[orgId],
+		epcIDIPV6CidrInfos: t.epcIDIPV6CidrInfos[orgId],
+		macInfos:           t.macInfos[orgId],
+	}, now)
+}
+
+// queryHistory returns the platform data version active at timestamp(s), if returns nil the current version should be used.
+// timestamp 0 or any time after the current version becomes active always uses the current version.
+func (t *PlatformInfoTable) queryHistory(orgId uint16, timestamp uint32) *platformDataVersion {
+	if timestamp == 0 || timestamp >= t.platformDataValidFrom[orgId] {
+		return nil
+	}
+	history := t.history[orgId]
+	if history == nil {
+		t.counter.HistoryMissCount++
+		return nil
+	}
+	version := history.find(timestamp)
+	if version == nil {
+		t.counter.HistoryMissCount++
+		return nil
+	}
+	t.counter.HistoryHitCount++
+	return version
+}
+
+func (t *PlatformInfoTable) historyStats() (int64, int64) {
+	var versions, size int64
+	for _, orgId := range t.orgIds {
+		if history := t.history[orgId]; history != nil {
+			n, s := history.stats()
+			versions += int64(n)
+			size += s
+		}
+	}
+	return versions, size
+}
+
+func epcIDIPV6Key(epcID int32, ipv6 net.IP) [EpcIDIPV6_LEN]byte {
+	var key [EpcIDIPV6_LEN]byte
+	binary.LittleEndian.PutUint32(key[:], uint32(epcID))
+	copy(key[4:], ipv6)
+	return key
+}
+
+func lookupIPV4Cidr(epcIDIPV4CidrInfos map[int32][]*CidrInfo, epcID int32, ipv4 uint32) *Info {
+	cidrInfos, exist := epcIDIPV4CidrInfos[epcID]
+	if !exist {
+		return nil
+	}
+	ip := utils.IpFromUint32(ipv4)
+	for _, cidrInfo := range cidrInfos {
+		if cidrInfo.Cidr.Contains(ip) {
+			return &Info{
+				SubnetID: cidrInfo.SubnetID,
+				RegionID: cidrInfo.RegionID,
+				AZID:     cidrInfo.AZID,
+				HitCount: cidrInfo.HitCount,
+			}
+		}
+	}
+	return nil
+}
+
+func lookupIPV6Cidr(epcIDIPV6CidrInfos map[int32][]*CidrInfo, epcID int32, ipv6 net.IP) *Info {
+	cidrInfos, exist := epcIDIPV6CidrInfos[epcID]
+	if !exist {
+		return nil
+	}
+	for _, cidrInfo := range cidrInfos {
+		if cidrInfo.Cidr.Contains(ipv6) {
+			return &Info{
+				SubnetID: cidrInfo.SubnetID,
+				RegionID: cidrInfo.RegionID,
+				AZID:     cidrInfo.AZID,
+				HitCount: cidrInfo.HitCount,
+			}
+		}
+	}
+	return nil
+}
+
+func historyInfoStat(info *Info) {
+	if info != nil && info.HitCount != nil {
+		atomic.AddUint64(info.HitCount, 1)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package grpc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/libs/utils"
+)
+
+func newTestHistoryTable() *PlatformInfoTable {
+	t := &PlatformInfoTable{counter: &Counter{}, orgIds: []uint16{1}}
+	t.historyLimits.fillDefaults()
+	return t
+}
+
+func setTestPlatformData(t *PlatformInfoTable, orgId uint16, now time.Time, podID uint32) {
+	hitCount := uint64(0)
+	info := &Info{EpcID: 1, PodID: podID, HitCount: &hitCount}
+	t.saveHistory(orgId, now)
+	t.platformDataValidFrom[orgId] = uint32(now.Unix())
+	t.versionPlatformData[orgId]++
+	t.epcIDIPV4Infos[orgId] = map[uint64]*Info{uint64(1)<<32 | uint64(utils.IpToUint32(net.IP{10, 0, 0, 1})): info}
+	t.macInfos[orgId] = map[uint64]*Info{0x1234: info}
+}
+
+func TestPlatformDataHistoryQuery(t *testing.T) {
+	table := newTestHistoryTable()
+	base := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		setTestPlatformData(table, 1, base.Add(time.Duration(i)*time.Minute), uint32(100+i))
+	}
+	// 3 versions were active from base, base+1m and base+2m, the last one is the current version
+	if n, _ := table.history[1].stats(); n != 2 {
+		t.Fatalf("history versions %d, expected 2", n)
+	}
+	ip := net.IP{10, 0, 0, 1}
+	cases := []struct {
+		timestamp uint32
+		podID     uint32
+	}{
+		{uint32(base.Unix()) + 10, 100},
+		{uint32(base.Unix()) + 60, 101},
+		{uint32(base.Unix()) + 119, 101},
+		{uint32(base.Unix()) + 120, 102},
+		{0, 102},
+		{uint32(base.Unix()) - 10, 102}, // out of the history window, use the current version
+	}
+	for _, c := range cases {
+		table.epcIDIPV4Lru[1] = nil
+		info := table.QueryIPV4Infos(1, 1, utils.IpToUint32(ip), c.timestamp)
+		if info == nil || info.PodID != c.podID {
+			t.Errorf("query ipv4 at %d got %+v, expected pod %d", c.timestamp, info, c.podID)
+		}
+		if info := table.QueryMacInfo(1, 0x1234, c.timestamp); info == nil || info.PodID != c.podID {
+			t.Errorf("query mac at %d got %+v, expected pod %d", c.timestamp, info, c.podID)
+		}
+	}
+	if table.counter.HistoryHitCount != 6 || table.counter.HistoryMissCount != 2 {
+		t.Errorf("history hit %d miss %d, expected 6 and 2", table.counter.HistoryHitCount, table.counter.HistoryMissCount)
+	}
+}
+
+func TestPlatformDataHistoryPrune(t *testing.T) {
+	table := newTestHistoryTable()
+	table.historyLimits.MaxVersions = 3
+	table.historyLimits.MaxAge = 10 * time.Minute
+	base := time.Unix(1700000000, 0)
+	for i := 0; i < 6; i++ {
+		setTestPlatformData(table, 1, base.Add(time.Duration(i)*time.Minute), uint32(100+i))
+	}
+	history := table.history[1]
+	if n, _ := history.stats(); n != 3 {
+		t.Fatalf("history versions %d, expected 3", n)
+	}
+	if history.versions[0].validFrom != uint32(base.Add(2*time.Minute).Unix()) {
+		t.Errorf("the oldest version should be evicted first")
+	}
+
+	history.prune(base.Add(14*time.Minute + 30*time.Second))
+	if n, _ := history.stats(); n != 1 {
+		t.Errorf("history versions %d after max-age pruned, expected 1", n)
+	}
+
+	_, size := history.stats()
+	history.limits.MaxMemory = size - 1
+	history.prune(base.Add(5 * time.Minute))
+	if n, size := history.stats(); n != 0 || size != 0 {
+		t.Errorf("history versions %d size %d after max-memory pruned, expected 0", n, size)
+	}
+}
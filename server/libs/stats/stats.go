@@ -141,6 +141,13 @@ func counterToFields(counter interface{}) models.Fields {
 				continue
 			}
 			field := val.Type().Field(i)
+			// the fields of the embedded counter are flattened
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				for k, v := range counterToFields(val.Field(i).Interface()) {
+					fields[k] = v
+				}
+				continue
+			}
 			statsTag := field.Tag.Get("statsd")
 			if statsTag == "" {
 				continue
@@ -28,6 +28,9 @@ const (
 	DATABASE_FLOW_LOG = "flow_log"
 	TABLE_L7_FLOW_LOG = "l7_flow_log"
 	TAG_TRACE_ID      = "trace_id"
+
+	TABLE_SPAN_WITH_TRACE_ID = "span_with_trace_id"
+	TABLE_TRACE_TREE         = "trace_tree"
 )
 
 const (
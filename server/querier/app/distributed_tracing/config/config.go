@@ -44,6 +44,7 @@ type TraceMapConfig struct {
 	WriteBatchSize         int     `default:"1000" yaml:"write_batch_size"`
 	Querier                Querier `yaml:"querier"`
 	DebugSqlLenMax         int     `default:"1000" yaml:"debug_sql_len_max"`
+	MaxTracePerQuery       uint64  `default:"10000" yaml:"max_trace_per_query"`
 }
 
 type Querier struct {
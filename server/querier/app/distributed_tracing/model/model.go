@@ -27,3 +27,38 @@ type TraceMap struct {
 	Context        context.Context
 	OrgID          string
 }
+
+// TraceMapNode and TraceMapEdge follow the field names of the Grafana
+// node graph panel, so the result can be used as its data frames directly
+type TraceMapNode struct {
+	ID              string  `json:"id"`
+	Title           string  `json:"title"`
+	SubTitle        string  `json:"subtitle"`
+	MainStat        uint64  `json:"mainstat"`      // requests received
+	SecondaryStat   float64 `json:"secondarystat"` // server error ratio, %
+	ArcSuccess      float64 `json:"arc__success"`
+	ArcError        float64 `json:"arc__error"`
+	AutoServiceType uint8   `json:"detail__auto_service_type"`
+	AutoServiceID   uint32  `json:"detail__auto_service_id"`
+	AppService      string  `json:"detail__app_service"`
+	IP              string  `json:"detail__ip"`
+}
+
+// TraceMapEdge has no latency percentiles, trace trees only keep the latency sum of a node
+type TraceMapEdge struct {
+	ID            string  `json:"id"`
+	Source        string  `json:"source"`
+	Target        string  `json:"target"`
+	MainStat      uint64  `json:"mainstat"`      // requests
+	SecondaryStat float64 `json:"secondarystat"` // average latency, us
+	Request       uint64  `json:"detail__request"`
+	ServerError   uint64  `json:"detail__server_error"`
+	ErrorRatio    float64 `json:"detail__server_error_ratio"`
+	LatencyAvg    float64 `json:"detail__latency_avg"`
+	PseudoLink    bool    `json:"detail__pseudo_link"`
+}
+
+type TraceMapResult struct {
+	Nodes []*TraceMapNode `json:"nodes"`
+	Edges []*TraceMapEdge `json:"edges"`
+}
@@ -16,13 +16,157 @@
 package tracemap
 
 import (
+	"fmt"
+	"strconv"
+
+	ckdriver "github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 
+	"github.com/deepflowio/deepflow/server/libs/ckdb"
+	"github.com/deepflowio/deepflow/server/libs/codec"
+	"github.com/deepflowio/deepflow/server/libs/tracetree"
+	tracing_common "github.com/deepflowio/deepflow/server/querier/app/distributed_tracing/common"
 	"github.com/deepflowio/deepflow/server/querier/app/distributed_tracing/model"
+	"github.com/deepflowio/deepflow/server/querier/common"
 	"github.com/deepflowio/deepflow/server/querier/config"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse"
+	"github.com/deepflowio/deepflow/server/querier/router"
 )
 
 func TraceMap(args model.TraceMap, cfg *config.QuerierConfig, c *gin.Context, done chan bool, generator *TraceMapGenerator) {
-	done <- true
-	return
+	defer func() { done <- true }()
+	result, debug, err := queryTraceMap(&args, cfg)
+	if !args.Debug {
+		debug = nil
+	}
+	router.JsonResponse(c, result, debug, err)
+}
+
+func orgDatabase(db, orgID string) string {
+	orgIDInt, _ := strconv.Atoi(orgID)
+	return ckdb.OrgDatabasePrefix(uint16(orgIDInt)) + db
+}
+
+func queryTraceMap(args *model.TraceMap, cfg *config.QuerierConfig) (*model.TraceMapResult, []interface{}, error) {
+	debugs := []interface{}{}
+	var filter string
+	queryArgs := []interface{}{
+		ckdriver.Named("start", args.TimeStart), ckdriver.Named("end", args.TimeEnd),
+		ckdriver.Named("limit", cfg.Tracemap.MaxTracePerQuery),
+	}
+	if args.QueryCondition != "" {
+		traceIDs, debug, err := queryTraceIDs(args, cfg)
+		debugs = append(debugs, debug)
+		if err != nil {
+			return nil, debugs, err
+		}
+		if len(traceIDs) == 0 {
+			return &model.TraceMapResult{Nodes: []*model.TraceMapNode{}, Edges: []*model.TraceMapEdge{}}, debugs, nil
+		}
+		searchIndexes := make([]uint64, 0, len(traceIDs))
+		for _, traceID := range traceIDs {
+			searchIndexes = append(searchIndexes, tracetree.HashSearchIndex(traceID))
+		}
+		filter = " AND search_index IN (@indexes) AND trace_id IN (@trace_ids)"
+		queryArgs = append(queryArgs, ckdriver.Named("indexes", searchIndexes), ckdriver.Named("trace_ids", traceIDs))
+	}
+	// every row is a trace, the traces are capped like the ones matching the condition
+	sql := fmt.Sprintf(
+		"SELECT encoded_span_list FROM %s.%s WHERE time >= toDateTime(@start) AND time <= toDateTime(@end)%s LIMIT @limit",
+		orgDatabase(tracing_common.DATABASE_FLOW_LOG, args.OrgID), tracing_common.TABLE_TRACE_TREE, filter,
+	)
+	result, debug, err := clickhouse.SimpleExecute(&common.QuerierParams{
+		DB:        tracing_common.DATABASE_FLOW_LOG,
+		Sql:       sql,
+		Args:      queryArgs,
+		Debug:     strconv.FormatBool(args.Debug),
+		QueryUUID: uuid.NewString(),
+		Context:   args.Context,
+		ORGID:     args.OrgID,
+	})
+	debugs = append(debugs, debug)
+	if err != nil {
+		return nil, debugs, err
+	}
+
+	aggregator := NewTraceMapAggregator()
+	decoder := &codec.SimpleDecoder{}
+	tree := &tracetree.TraceTree{}
+	for _, v := range result.Values {
+		encoded, _ := v.([]interface{})[0].(string)
+		decoder.Init([]byte(encoded))
+		if err := tree.Decode(decoder); err != nil {
+			log.Debugf("decode trace tree failed: %s", err)
+			continue
+		}
+		aggregator.Add(tree)
+	}
+	names, namesDebug, err := queryServiceNames(args, aggregator.autoServices())
+	debugs = append(debugs, namesDebug)
+	if err != nil {
+		// the IDs are still usable as titles
+		log.Warningf("query service names failed: %s", err)
+	}
+	return aggregator.Result(names), debugs, nil
+}
+
+// queryTraceIDs returns the traces matching the tag filters, the condition is
+// translated by the querier engine like any DeepFlow SQL query on l7_flow_log
+func queryTraceIDs(args *model.TraceMap, cfg *config.QuerierConfig) ([]string, map[string]interface{}, error) {
+	sql := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE time>=%d AND time<=%d AND %s!='' AND (%s) GROUP BY %s LIMIT %d",
+		tracing_common.TAG_TRACE_ID, tracing_common.TABLE_L7_FLOW_LOG, args.TimeStart, args.TimeEnd,
+		tracing_common.TAG_TRACE_ID, args.QueryCondition, tracing_common.TAG_TRACE_ID, cfg.Tracemap.MaxTracePerQuery,
+	)
+	ckEngine := &clickhouse.CHEngine{DB: tracing_common.DATABASE_FLOW_LOG}
+	ckEngine.Init()
+	result, debug, err := ckEngine.ExecuteQuery(&common.QuerierParams{
+		DB:        tracing_common.DATABASE_FLOW_LOG,
+		Sql:       sql,
+		Debug:     strconv.FormatBool(args.Debug),
+		QueryUUID: uuid.NewString(),
+		Context:   args.Context,
+		ORGID:     args.OrgID,
+	})
+	if err != nil {
+		return nil, debug, err
+	}
+	traceIDs := make([]string, 0, len(result.Values))
+	for _, v := range result.Values {
+		if traceID, ok := v.([]interface{})[0].(string); ok {
+			traceIDs = append(traceIDs, traceID)
+		}
+	}
+	return traceIDs, debug, nil
+}
+
+func queryServiceNames(args *model.TraceMap, services [][]interface{}) (map[[2]uint64]string, map[string]interface{}, error) {
+	names := map[[2]uint64]string{}
+	if len(services) == 0 {
+		return names, nil, nil
+	}
+	sql := fmt.Sprintf(
+		"SELECT toUInt64(devicetype), toUInt64(deviceid), name FROM %s.device_map WHERE (devicetype, deviceid) IN (@services)",
+		orgDatabase("flow_tag", args.OrgID),
+	)
+	result, debug, err := clickhouse.SimpleExecute(&common.QuerierParams{
+		Sql:       sql,
+		Args:      []interface{}{ckdriver.Named("services", services)},
+		Debug:     strconv.FormatBool(args.Debug),
+		QueryUUID: uuid.NewString(),
+		Context:   args.Context,
+		ORGID:     args.OrgID,
+	})
+	if err != nil {
+		return names, debug, err
+	}
+	for _, v := range result.Values {
+		value := v.([]interface{})
+		deviceType, _ := value[0].(uint64)
+		deviceID, _ := value[1].(uint64)
+		name, _ := value[2].(string)
+		names[[2]uint64{deviceType, deviceID}] = name
+	}
+	return names, debug, nil
 }
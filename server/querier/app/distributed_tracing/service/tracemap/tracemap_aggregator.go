@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tracemap
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/deepflowio/deepflow/server/libs/tracetree"
+	"github.com/deepflowio/deepflow/server/querier/app/distributed_tracing/model"
+)
+
+type traceMapEdge struct {
+	source, target string
+	request        uint64
+	serverError    uint64
+	durationSum    uint64
+	pseudoLink     bool
+}
+
+// TraceMapAggregator merges trace trees into service to service edges
+type TraceMapAggregator struct {
+	nodes map[string]tracetree.NodeInfo
+	edges map[[2]string]*traceMapEdge
+}
+
+func NewTraceMapAggregator() *TraceMapAggregator {
+	return &TraceMapAggregator{
+		nodes: map[string]tracetree.NodeInfo{},
+		edges: map[[2]string]*traceMapEdge{},
+	}
+}
+
+func (a *TraceMapAggregator) addNode(n *tracetree.NodeInfo) string {
+	uid := NodeUID(n)
+	if _, ok := a.nodes[uid]; !ok {
+		a.nodes[uid] = *n
+	}
+	return uid
+}
+
+func (a *TraceMapAggregator) Add(t *tracetree.TraceTree) {
+	for i := range t.TreeNodes {
+		node := &t.TreeNodes[i]
+		target := a.addNode(&node.NodeInfo)
+		if node.ResponseTotal == 0 {
+			continue
+		}
+		var source string
+		if p := node.ParentNodeIndex; p >= 0 && int(p) < len(t.TreeNodes) {
+			source = a.addNode(&t.TreeNodes[p].NodeInfo)
+		} else if len(node.UniqParentSpanInfos) > 0 {
+			// the client of a root node is only known from its spans
+			s := &node.UniqParentSpanInfos[0]
+			source = a.addNode(&tracetree.NodeInfo{
+				AutoServiceType: s.AutoServiceType0,
+				AutoServiceID:   s.AutoServiceID0,
+				AppService:      s.AppService0,
+				IsIPv4:          s.IsIPv4,
+				IP4:             s.IP40,
+				IP6:             s.IP60,
+			})
+		} else {
+			continue
+		}
+		key := [2]string{source, target}
+		e, ok := a.edges[key]
+		if !ok {
+			e = &traceMapEdge{source: source, target: target}
+			a.edges[key] = e
+		}
+		e.request += uint64(node.ResponseTotal)
+		e.serverError += uint64(node.ResponseStatusServerErrorCount)
+		e.durationSum += node.ResponseDurationSum
+		e.pseudoLink = e.pseudoLink || node.PseudoLink != 0
+	}
+}
+
+func (a *TraceMapAggregator) autoServices() [][]interface{} {
+	services := [][]interface{}{}
+	for _, n := range a.nodes {
+		if !isIPService(n.AutoServiceType) {
+			services = append(services, []interface{}{uint64(n.AutoServiceType), uint64(n.AutoServiceID)})
+		}
+	}
+	return services
+}
+
+func ratio(a, b uint64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+func (a *TraceMapAggregator) Result(names map[[2]uint64]string) *model.TraceMapResult {
+	result := &model.TraceMapResult{Nodes: []*model.TraceMapNode{}, Edges: []*model.TraceMapEdge{}}
+	nodes := make(map[string]*model.TraceMapNode, len(a.nodes))
+	for uid, n := range a.nodes {
+		node := &model.TraceMapNode{
+			ID:              uid,
+			AutoServiceType: n.AutoServiceType,
+			AutoServiceID:   n.AutoServiceID,
+			AppService:      n.AppService,
+		}
+		title := names[[2]uint64{uint64(n.AutoServiceType), uint64(n.AutoServiceID)}]
+		if isIPService(n.AutoServiceType) {
+			node.IP = nodeIP(&n)
+			title = node.IP
+		} else if title == "" {
+			title = fmt.Sprintf("%d-%d", n.AutoServiceType, n.AutoServiceID)
+		}
+		if n.AppService != "" {
+			node.Title, node.SubTitle = n.AppService, title
+		} else {
+			node.Title = title
+		}
+		nodes[uid] = node
+		result.Nodes = append(result.Nodes, node)
+	}
+	serverErrors := map[string]uint64{}
+	for _, e := range a.edges {
+		latencyAvg := ratio(e.durationSum, e.request)
+		result.Edges = append(result.Edges, &model.TraceMapEdge{
+			ID:            e.source + "/" + e.target,
+			Source:        e.source,
+			Target:        e.target,
+			MainStat:      e.request,
+			SecondaryStat: latencyAvg,
+			Request:       e.request,
+			ServerError:   e.serverError,
+			ErrorRatio:    ratio(e.serverError, e.request) * 100,
+			LatencyAvg:    latencyAvg,
+			PseudoLink:    e.pseudoLink,
+		})
+		nodes[e.target].MainStat += e.request
+		serverErrors[e.target] += e.serverError
+	}
+	for uid, node := range nodes {
+		node.SecondaryStat = ratio(serverErrors[uid], node.MainStat) * 100
+		if node.MainStat > 0 {
+			node.ArcError = ratio(serverErrors[uid], node.MainStat)
+			node.ArcSuccess = 1 - node.ArcError
+		}
+	}
+	sort.Slice(result.Nodes, func(i, j int) bool { return result.Nodes[i].ID < result.Nodes[j].ID })
+	sort.Slice(result.Edges, func(i, j int) bool { return result.Edges[i].ID < result.Edges[j].ID })
+	return result
+}
@@ -16,10 +16,30 @@
 package tracemap
 
 import (
+	"fmt"
+	"strconv"
+	"time"
+
+	ckdriver "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/google/uuid"
+	logging "github.com/op/go-logging"
+
+	"github.com/deepflowio/deepflow/server/controller/election"
+	"github.com/deepflowio/deepflow/server/libs/codec"
 	"github.com/deepflowio/deepflow/server/libs/queue"
+	"github.com/deepflowio/deepflow/server/libs/tracetree"
+	tracing_common "github.com/deepflowio/deepflow/server/querier/app/distributed_tracing/common"
+	"github.com/deepflowio/deepflow/server/querier/common"
 	"github.com/deepflowio/deepflow/server/querier/config"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse"
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
 )
 
+var log = logging.MustGetLogger("tracemap")
+
+// TraceMapGenerator periodically builds the trace trees of the traces in `span_with_trace_id`
+// of every org and hands them to the ingester, which writes them to `trace_tree` of the org.
+// Only the querier of the master controller generates, otherwise every replica writes the same trees.
 type TraceMapGenerator struct {
 	sharedQueue *queue.OverwriteQueue
 	cfg         *config.QuerierConfig
@@ -30,5 +50,184 @@ func NewTraceMapGenerator(sharedQueue *queue.OverwriteQueue, cfg *config.Querier
 }
 
 func (g *TraceMapGenerator) Start() {
+	if g.sharedQueue == nil || g.cfg.Tracemap.WriteInterval <= 0 {
+		return
+	}
+	go g.run()
+}
+
+func (g *TraceMapGenerator) run() {
+	interval := uint32(g.cfg.Tracemap.WriteInterval)
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		// spans arrive late, only handle the traces started TraceQueryDelta ago
+		end := uint32(now.Unix()) - uint32(g.cfg.Tracemap.TraceQueryDelta)
+		end -= end % interval
+		if isLeader, err := election.IsMasterController(); err != nil || !isLeader {
+			continue
+		}
+		for _, orgID := range getOrgIDs() {
+			if err := g.generate(orgID, end-interval, end); err != nil {
+				log.Warningf("generate trace tree of org (%s) [%d, %d) failed: %s", orgID, end-interval, end, err)
+			}
+		}
+	}
+}
+
+// getOrgIDs returns the orgs from the controller, or the default org if the request fails
+func getOrgIDs() []string {
+	orgIDs, err := chCommon.GetOrgIDs()
+	if err != nil {
+		log.Warning(err)
+	}
+	if len(orgIDs) == 0 {
+		orgIDs = append(orgIDs, common.DEFAULT_ORG_ID)
+	}
+	return orgIDs
+}
+
+func roundUpPowerOf2(n uint64) uint64 {
+	p := uint64(1)
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+func (g *TraceMapGenerator) generate(orgID string, start, end uint32) error {
+	cfg := &g.cfg.Tracemap
+	iterations := uint32(roundUpPowerOf2(cfg.TraceIdQueryIterations))
+	step := (end - start + iterations - 1) / iterations
+	if step == 0 {
+		step = 1
+	}
+	for from := start; from < end; from += step {
+		to := from + step
+		if to > end {
+			to = end
+		}
+		traceIDs, err := g.queryTraceIDs(orgID, from, to)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(traceIDs); i += int(cfg.BatchTracesCountMax) {
+			j := i + int(cfg.BatchTracesCountMax)
+			if j > len(traceIDs) || cfg.BatchTracesCountMax == 0 {
+				j = len(traceIDs)
+			}
+			trees, err := g.queryTraceTrees(orgID, traceIDs[i:j], from, to)
+			if err != nil {
+				return err
+			}
+			g.put(trees)
+		}
+	}
+	return nil
+}
+
+func (g *TraceMapGenerator) put(trees []interface{}) {
+	batchSize := g.cfg.Tracemap.WriteBatchSize
+	if batchSize <= 0 {
+		batchSize = len(trees)
+	}
+	for i := 0; i < len(trees); i += batchSize {
+		j := i + batchSize
+		if j > len(trees) {
+			j = len(trees)
+		}
+		g.sharedQueue.Put(trees[i:j]...)
+	}
+}
+
+func execute(orgID, sql string, args ...interface{}) (*common.Result, error) {
+	result, _, err := clickhouse.SimpleExecute(&common.QuerierParams{
+		DB:        tracing_common.DATABASE_FLOW_LOG,
+		Sql:       sql,
+		Args:      args,
+		Debug:     "false",
+		QueryUUID: uuid.NewString(),
+		ORGID:     orgID,
+	})
+	return result, err
+}
+
+// queryTraceIDs returns the traces whose first span is in [from, to)
+func (g *TraceMapGenerator) queryTraceIDs(orgID string, from, to uint32) ([]string, error) {
+	sql := fmt.Sprintf(
+		"SELECT trace_id FROM %s.%s WHERE time >= toDateTime(@from) - @delta AND time < toDateTime(@to) GROUP BY trace_id HAVING min(time) >= toDateTime(@from) LIMIT @limit",
+		orgDatabase(tracing_common.DATABASE_FLOW_LOG, orgID), tracing_common.TABLE_SPAN_WITH_TRACE_ID,
+	)
+	result, err := execute(orgID, sql,
+		ckdriver.Named("from", from), ckdriver.Named("to", to),
+		ckdriver.Named("delta", g.cfg.Tracemap.TraceQueryDelta), ckdriver.Named("limit", g.cfg.Tracemap.MaxTracePerIteration),
+	)
+	if err != nil {
+		return nil, err
+	}
+	traceIDs := make([]string, 0, len(result.Values))
+	for _, v := range result.Values {
+		if traceID, ok := v.([]interface{})[0].(string); ok && traceID != "" {
+			traceIDs = append(traceIDs, traceID)
+		}
+	}
+	return traceIDs, nil
+}
 
+func (g *TraceMapGenerator) queryTraceTrees(orgID string, traceIDs []string, from, to uint32) ([]interface{}, error) {
+	orgIDInt, err := strconv.Atoi(orgID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid org id %s", orgID)
+	}
+	searchIndexes := make([]uint64, 0, len(traceIDs))
+	for _, traceID := range traceIDs {
+		searchIndexes = append(searchIndexes, tracetree.HashSearchIndex(traceID))
+	}
+	sql := fmt.Sprintf(
+		"SELECT trace_id, toUnixTimestamp(time), encoded_span FROM %s.%s WHERE search_index IN (@indexes) AND trace_id IN (@trace_ids) AND time >= toDateTime(@from) - @delta AND time < toDateTime(@to) + @delta",
+		orgDatabase(tracing_common.DATABASE_FLOW_LOG, orgID), tracing_common.TABLE_SPAN_WITH_TRACE_ID,
+	)
+	result, err := execute(orgID, sql,
+		ckdriver.Named("indexes", searchIndexes), ckdriver.Named("trace_ids", traceIDs),
+		ckdriver.Named("from", from), ckdriver.Named("to", to), ckdriver.Named("delta", g.cfg.Tracemap.TraceQueryDelta),
+	)
+	if err != nil {
+		return nil, err
+	}
+	spans := make(map[string][]*tracetree.SpanTrace, len(traceIDs))
+	times := make(map[string]uint32, len(traceIDs))
+	decoder := &codec.SimpleDecoder{}
+	for _, v := range result.Values {
+		value := v.([]interface{})
+		traceID, _ := value[0].(string)
+		spanTime, _ := value[1].(uint32)
+		encoded, _ := value[2].(string)
+		decoder.Init([]byte(encoded))
+		span := tracetree.AcquireSpanTrace()
+		if err := span.Decode(decoder); err != nil {
+			log.Debugf("decode span of trace %s failed: %s", traceID, err)
+			tracetree.ReleaseSpanTrace(span)
+			continue
+		}
+		span.Time = spanTime
+		spans[traceID] = append(spans[traceID], span)
+		if t, ok := times[traceID]; !ok || spanTime < t {
+			times[traceID] = spanTime
+		}
+	}
+	trees := make([]interface{}, 0, len(spans))
+	for _, traceID := range traceIDs {
+		if len(spans[traceID]) == 0 {
+			continue
+		}
+		tree := BuildTraceTree(traceID, times[traceID], spans[traceID])
+		tree.OrgId = uint16(orgIDInt)
+		// the ingester writes the encoded nodes as they are
+		tree.Encode()
+		trees = append(trees, tree)
+		for _, span := range spans[traceID] {
+			tracetree.ReleaseSpanTrace(span)
+		}
+	}
+	return trees, nil
 }
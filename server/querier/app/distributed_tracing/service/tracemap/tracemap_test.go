@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tracemap
+
+import (
+	"testing"
+
+	"github.com/deepflowio/deepflow/server/libs/codec"
+	"github.com/deepflowio/deepflow/server/libs/tracetree"
+)
+
+const (
+	serviceTypePod = 10
+	serviceA       = 1
+	serviceB       = 2
+	serviceDB      = 3
+)
+
+func newSpan(observationPoint string, client, server uint32) *tracetree.SpanTrace {
+	return &tracetree.SpanTrace{
+		ObservationPoint: observationPoint,
+		AutoServiceType0: serviceTypePod,
+		AutoServiceID0:   client,
+		AutoServiceType1: serviceTypePod,
+		AutoServiceID1:   server,
+		IsIPv4:           true,
+		IP40:             client,
+		IP41:             server,
+		ResponseDuration: 100,
+	}
+}
+
+func findNode(t *tracetree.TraceTree, id uint32, appService string) *tracetree.TreeNode {
+	for i := range t.TreeNodes {
+		if n := &t.TreeNodes[i]; n.NodeInfo.AutoServiceID == id && n.NodeInfo.AppService == appService {
+			return n
+		}
+	}
+	return nil
+}
+
+// a (app spans) -> b observed by c-p, c, s, s-p, then b -> db only observed by the client
+func newTestSpans() []*tracetree.SpanTrace {
+	cApp := newSpan("c-app", serviceA, serviceB)
+	cApp.SpanId, cApp.AppService = "1", "a"
+	sApp := newSpan("s-app", serviceA, serviceB)
+	sApp.SpanId, sApp.ParentSpanId, sApp.AppService = "2", "1", "b"
+	sApp.ResponseStatus = RESPONSE_STATUS_SERVER_ERROR
+
+	spans := []*tracetree.SpanTrace{cApp, sApp}
+	for _, op := range []string{"c-p", "c", "s", "s-p"} {
+		s := newSpan(op, serviceA, serviceB)
+		s.ReqTcpSeq = 1000
+		if op == "c-p" || op == "s-p" {
+			// the span id injected by the client app
+			s.SpanId = "1"
+		}
+		if op == "s-p" {
+			s.SyscallTraceIDRequest = 77
+		}
+		spans = append(spans, s)
+	}
+	db := newSpan("c-p", serviceB, serviceDB)
+	db.SyscallTraceIDRequest = 77
+	db.ResponseDuration = 30
+	return append(spans, db)
+}
+
+func TestBuildTraceTree(t *testing.T) {
+	tree := BuildTraceTree("trace-1", 100, newTestSpans())
+	if tree.SearchIndex != tracetree.HashSearchIndex("trace-1") || tree.Time != 100 {
+		t.Fatalf("unexpected tree %+v", tree)
+	}
+	if len(tree.TreeNodes) != 3 {
+		t.Fatalf("expected 3 nodes, got %+v", tree.TreeNodes)
+	}
+	a := findNode(tree, serviceA, "a")
+	if a == nil || a.ParentNodeIndex != -1 || a.ResponseTotal != 0 {
+		t.Errorf("unexpected root node %+v", a)
+	}
+	// the app spans and the network spans see the same request
+	b := findNode(tree, serviceB, "b")
+	if b == nil || tree.TreeNodes[b.ParentNodeIndex].NodeInfo.AppService != "a" || b.ResponseTotal != 1 || b.ResponseStatusServerErrorCount != 1 {
+		t.Errorf("unexpected node b %+v", b)
+	}
+	db := findNode(tree, serviceDB, "")
+	if db == nil || db.PseudoLink != 1 || db.ResponseDurationSum != 30 {
+		t.Errorf("unexpected node db %+v", db)
+	}
+	if parent := tree.TreeNodes[db.ParentNodeIndex].NodeInfo; parent.AutoServiceID != serviceB {
+		t.Errorf("unexpected parent of db %+v", parent)
+	}
+
+	// the tree is written by the ingester, make sure it survives encoding
+	tree.Encode()
+	block := tree.NewColumnBlock().(*tracetree.TraceTreeBlock)
+	tree.AppendToColumnBlock(block)
+	decoded := &tracetree.TraceTree{}
+	decoder := &codec.SimpleDecoder{}
+	decoder.Init([]byte(block.ColEncodedSpanList.Row(0)))
+	if err := decoded.Decode(decoder); err != nil || len(decoded.TreeNodes) != len(tree.TreeNodes) {
+		t.Errorf("decode failed: %v", err)
+	}
+}
+
+func TestBuildTraceTreeLoop(t *testing.T) {
+	a := newSpan("s-app", serviceA, serviceB)
+	a.SpanId, a.ParentSpanId, a.AppService = "1", "2", "a"
+	b := newSpan("s-app", serviceA, serviceA)
+	b.SpanId, b.ParentSpanId, b.AppService = "2", "1", "b"
+	tree := BuildTraceTree("trace-2", 100, []*tracetree.SpanTrace{a, b})
+	if len(tree.TreeNodes) != 2 {
+		t.Errorf("unexpected nodes %+v", tree.TreeNodes)
+	}
+}
+
+func TestTraceMapAggregator(t *testing.T) {
+	aggregator := NewTraceMapAggregator()
+	for i, duration := range []uint64{100, 100, 1000} {
+		spans := newTestSpans()
+		spans[1].ResponseDuration = duration
+		if i > 0 {
+			spans[1].ResponseStatus = 0
+		}
+		aggregator.Add(BuildTraceTree("trace", 100, spans))
+	}
+	result := aggregator.Result(map[[2]uint64]string{{serviceTypePod, serviceDB}: "mysql"})
+	if len(result.Nodes) != 3 || len(result.Edges) != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	for _, e := range result.Edges {
+		switch e.Target {
+		case "10-2-b":
+			if e.Source != "10-1-a" || e.Request != 3 || e.ServerError != 1 || e.LatencyAvg != 400 || e.SecondaryStat != 400 {
+				t.Errorf("unexpected edge %+v", e)
+			}
+		case "10-3-":
+			if e.Source != "10-2-b" || e.Request != 3 || !e.PseudoLink || e.LatencyAvg != 30 {
+				t.Errorf("unexpected edge %+v", e)
+			}
+		default:
+			t.Errorf("unexpected edge %+v", e)
+		}
+	}
+	for _, n := range result.Nodes {
+		switch n.ID {
+		case "10-1-a":
+			if n.Title != "a" || n.MainStat != 0 {
+				t.Errorf("unexpected node %+v", n)
+			}
+		case "10-2-b":
+			if n.Title != "b" || n.MainStat != 3 || n.ArcError == 0 || n.ArcError+n.ArcSuccess != 1 {
+				t.Errorf("unexpected node %+v", n)
+			}
+		case "10-3-":
+			if n.Title != "mysql" {
+				t.Errorf("unexpected node %+v", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,332 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tracemap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/deepflowio/deepflow/server/libs/tracetree"
+	"github.com/deepflowio/deepflow/server/libs/utils"
+)
+
+const (
+	AUTO_SERVICE_TYPE_INTERNET   = 0
+	AUTO_SERVICE_TYPE_IP         = 255
+	RESPONSE_STATUS_SERVER_ERROR = 3
+)
+
+// position of the observation points along a request, from the client
+// process to the server process
+var observationPointRanks = map[string]int{
+	"c-app": 0, "app": 0, "c-p": 1, "c": 2, "c-nd": 3, "c-hv": 4, "c-gw-hv": 5, "c-gw": 6,
+	"rest": 7, "local": 7,
+	"s-gw": 8, "s-gw-hv": 9, "s-hv": 10, "s-nd": 11, "s": 12, "s-p": 13, "s-app": 14,
+}
+
+func isServerSide(observationPoint string) bool {
+	return strings.HasPrefix(observationPoint, "s")
+}
+
+func isAppSpan(s *tracetree.SpanTrace) bool {
+	return strings.HasSuffix(s.ObservationPoint, "app")
+}
+
+// the service the span belongs to: the server side of server side spans and the client side of the others
+func spanNodeInfo(s *tracetree.SpanTrace, serverSide bool) tracetree.NodeInfo {
+	n := tracetree.NodeInfo{AppService: s.AppService, IsIPv4: s.IsIPv4}
+	if serverSide {
+		n.AutoServiceType, n.AutoServiceID, n.IP4, n.IP6 = s.AutoServiceType1, s.AutoServiceID1, s.IP41, s.IP61
+	} else {
+		n.AutoServiceType, n.AutoServiceID, n.IP4, n.IP6 = s.AutoServiceType0, s.AutoServiceID0, s.IP40, s.IP60
+	}
+	return n
+}
+
+func spanInfo(s *tracetree.SpanTrace, serverSide bool) tracetree.SpanInfo {
+	info := tracetree.SpanInfo{
+		AutoServiceType0: s.AutoServiceType0,
+		AutoServiceType1: s.AutoServiceType1,
+		AutoServiceID0:   s.AutoServiceID0,
+		AutoServiceID1:   s.AutoServiceID1,
+		IsIPv4:           s.IsIPv4,
+		IP40:             s.IP40,
+		IP60:             s.IP60,
+		IP41:             s.IP41,
+		IP61:             s.IP61,
+	}
+	if serverSide {
+		info.AppService1 = s.AppService
+	} else {
+		info.AppService0 = s.AppService
+	}
+	return info
+}
+
+func isIPService(autoServiceType uint8) bool {
+	return autoServiceType == AUTO_SERVICE_TYPE_INTERNET || autoServiceType == AUTO_SERVICE_TYPE_IP
+}
+
+func nodeIP(n *tracetree.NodeInfo) string {
+	if n.IsIPv4 {
+		return utils.IpFromUint32(n.IP4).String()
+	}
+	return n.IP6.String()
+}
+
+// NodeUID identifies a service in the trace map: the auto service (or IP when
+// the address belongs to no service) and the application service name
+func NodeUID(n *tracetree.NodeInfo) string {
+	if isIPService(n.AutoServiceType) {
+		return fmt.Sprintf("%d-%s-%s", n.AutoServiceType, nodeIP(n), n.AppService)
+	}
+	return fmt.Sprintf("%d-%d-%s", n.AutoServiceType, n.AutoServiceID, n.AppService)
+}
+
+// spans without app_service (network and eBPF spans) belong to the app
+// service of the same auto service
+func sameService(a, b *tracetree.NodeInfo) bool {
+	if a.AutoServiceType != b.AutoServiceType || (a.AppService != "" && b.AppService != "" && a.AppService != b.AppService) {
+		return false
+	}
+	if isIPService(a.AutoServiceType) {
+		return a.IsIPv4 == b.IsIPv4 && a.IP4 == b.IP4 && a.IP6.Equal(b.IP6)
+	}
+	return a.AutoServiceID == b.AutoServiceID
+}
+
+// spanParents links every span to its parent span, -1 for root spans:
+//   - by parent_span_id
+//   - spans of the same TCP request (req_tcp_seq) are chained by observation point
+//   - the first span of a request carrying the span id of an app span follows the app span
+//   - a client process span follows the server process span sharing its syscall trace id
+func spanParents(spans []*tracetree.SpanTrace) []int {
+	parents := make([]int, len(spans))
+	bySpanID := map[string]int{}
+	byTcpSeq := map[uint32][]int{}
+	bySyscall := map[uint64]int{}
+	for i, s := range spans {
+		parents[i] = -1
+		if s.SpanId != "" {
+			// app spans own their span id, eBPF spans only carry it
+			if j, ok := bySpanID[s.SpanId]; !ok || (isAppSpan(s) && !isAppSpan(spans[j])) {
+				bySpanID[s.SpanId] = i
+			}
+		}
+		if s.ReqTcpSeq != 0 {
+			byTcpSeq[s.ReqTcpSeq] = append(byTcpSeq[s.ReqTcpSeq], i)
+		}
+		if isServerSide(s.ObservationPoint) {
+			for _, id := range []uint64{s.SyscallTraceIDRequest, s.SyscallTraceIDResponse} {
+				if _, ok := bySyscall[id]; id != 0 && !ok {
+					bySyscall[id] = i
+				}
+			}
+		}
+	}
+	for _, group := range byTcpSeq {
+		sort.SliceStable(group, func(i, j int) bool {
+			return observationPointRanks[spans[group[i]].ObservationPoint] < observationPointRanks[spans[group[j]].ObservationPoint]
+		})
+		for k := 1; k < len(group); k++ {
+			parents[group[k]] = group[k-1]
+		}
+	}
+	for i, s := range spans {
+		if j, ok := bySpanID[s.ParentSpanId]; s.ParentSpanId != "" && ok && j != i {
+			parents[i] = j
+			continue
+		}
+		if parents[i] >= 0 {
+			continue
+		}
+		if j, ok := bySpanID[s.SpanId]; s.SpanId != "" && ok && j != i && isAppSpan(spans[j]) && !isAppSpan(s) {
+			parents[i] = j
+			continue
+		}
+		if isServerSide(s.ObservationPoint) || s.SyscallTraceIDRequest == 0 {
+			continue
+		}
+		if j, ok := bySyscall[s.SyscallTraceIDRequest]; ok && j != i {
+			parents[i] = j
+		}
+	}
+	return parents
+}
+
+type nodeStats struct {
+	durationSum uint64
+	total       uint32
+	serverError uint32
+}
+
+type traceTreeBuilder struct {
+	spans    []*tracetree.SpanTrace
+	parents  []int
+	hasChild []bool
+	nodeOf   []int
+	nodes    []tracetree.TreeNode
+	children map[int][]int // node indexes by parent node index, -1 for the roots
+	// every observation point sees the requests of an edge once, keep the one seeing most
+	stats []map[string]*nodeStats
+}
+
+const (
+	nodeUnresolved = -2
+	nodeResolving  = -3
+)
+
+// BuildTraceTree groups the spans of a trace into a tree of services, each
+// node holds the requests it received from its parent node
+func BuildTraceTree(traceID string, time uint32, spans []*tracetree.SpanTrace) *tracetree.TraceTree {
+	b := &traceTreeBuilder{
+		spans:    spans,
+		parents:  spanParents(spans),
+		hasChild: make([]bool, len(spans)),
+		nodeOf:   make([]int, len(spans)),
+		children: map[int][]int{},
+	}
+	for i := range spans {
+		b.nodeOf[i] = nodeUnresolved
+		if p := b.parents[i]; p >= 0 {
+			b.hasChild[p] = true
+		}
+	}
+	for i := range spans {
+		b.resolve(i)
+	}
+	// the server of a client span nobody observed on the server side
+	for i, s := range spans {
+		if b.hasChild[i] || isServerSide(s.ObservationPoint) {
+			continue
+		}
+		server := spanNodeInfo(s, true)
+		server.AppService = ""
+		if sameService(&b.nodes[b.nodeOf[i]].NodeInfo, &server) {
+			continue
+		}
+		count := len(b.nodes)
+		if node := b.node(b.nodeOf[i], server, s, false); node >= count {
+			b.nodes[node].PseudoLink = 1
+		}
+	}
+
+	t := tracetree.AcquireTraceTree()
+	t.Time = time
+	t.TraceId = traceID
+	t.SearchIndex = tracetree.HashSearchIndex(traceID)
+	for i := range b.nodes {
+		var max *nodeStats
+		maxRank := -1
+		for observationPoint, st := range b.stats[i] {
+			rank := observationPointRanks[observationPoint]
+			if max == nil || st.total > max.total || (st.total == max.total && rank > maxRank) {
+				max, maxRank = st, rank
+			}
+		}
+		if max != nil {
+			b.nodes[i].ResponseDurationSum = max.durationSum
+			b.nodes[i].ResponseTotal = max.total
+			b.nodes[i].ResponseStatusServerErrorCount = max.serverError
+		}
+	}
+	t.TreeNodes = append(t.TreeNodes[:0], b.nodes...)
+	return t
+}
+
+func (b *traceTreeBuilder) resolve(i int) int {
+	if b.nodeOf[i] >= 0 || b.nodeOf[i] == nodeResolving {
+		return b.nodeOf[i]
+	}
+	b.nodeOf[i] = nodeResolving
+	parentNode := -1
+	if p := b.parents[i]; p >= 0 {
+		// a loop in the parent links makes the span a root
+		if parentNode = b.resolve(p); parentNode < 0 {
+			parentNode = -1
+		}
+	}
+	s := b.spans[i]
+	serverSide := isServerSide(s.ObservationPoint)
+	info := spanNodeInfo(s, serverSide)
+	if parentNode >= 0 {
+		parent := &b.nodes[parentNode]
+		if sameService(&parent.NodeInfo, &info) {
+			if parent.NodeInfo.AppService == "" {
+				parent.NodeInfo.AppService = info.AppService
+			}
+			b.nodeOf[i] = parentNode
+			return parentNode
+		}
+	}
+	// only a server side span tells who the client of a root node is
+	b.nodeOf[i] = b.node(parentNode, info, s, parentNode < 0 && !serverSide)
+	return b.nodeOf[i]
+}
+
+// node returns the child of parentNode for the service, the span is counted as a request to it
+func (b *traceTreeBuilder) node(parentNode int, info tracetree.NodeInfo, s *tracetree.SpanTrace, origin bool) int {
+	index := -1
+	for _, child := range b.children[parentNode] {
+		if n := &b.nodes[child].NodeInfo; sameService(n, &info) {
+			if n.AppService == "" {
+				n.AppService = info.AppService
+			}
+			index = child
+			break
+		}
+	}
+	if index < 0 {
+		index = len(b.nodes)
+		b.children[parentNode] = append(b.children[parentNode], index)
+		b.nodes = append(b.nodes, tracetree.TreeNode{
+			ParentNodeIndex: int32(parentNode),
+			NodeInfo:        info,
+			UID:             fmt.Sprintf("%d/%s", parentNode, NodeUID(&info)),
+		})
+		b.stats = append(b.stats, map[string]*nodeStats{})
+	}
+	if origin {
+		return index
+	}
+	node := &b.nodes[index]
+	si := spanInfo(s, isServerSide(s.ObservationPoint))
+	found := false
+	for _, u := range node.UniqParentSpanInfos {
+		if u.AutoServiceType0 == si.AutoServiceType0 && u.AutoServiceID0 == si.AutoServiceID0 &&
+			u.AutoServiceType1 == si.AutoServiceType1 && u.AutoServiceID1 == si.AutoServiceID1 &&
+			u.AppService0 == si.AppService0 && u.AppService1 == si.AppService1 &&
+			u.IP40 == si.IP40 && u.IP41 == si.IP41 && u.IP60.Equal(si.IP60) && u.IP61.Equal(si.IP61) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		node.UniqParentSpanInfos = append(node.UniqParentSpanInfos, si)
+	}
+	st, ok := b.stats[index][s.ObservationPoint]
+	if !ok {
+		st = &nodeStats{}
+		b.stats[index][s.ObservationPoint] = st
+	}
+	st.total++
+	st.durationSum += s.ResponseDuration
+	if s.ResponseStatus == RESPONSE_STATUS_SERVER_ERROR {
+		st.serverError++
+	}
+	return index
+}
@@ -0,0 +1,25 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+type Loki struct {
+	QPSLimit        int `default:"100" yaml:"qps-limit"`
+	MaxEntriesLimit int `default:"5000" yaml:"max-entries-limit"` // max log lines returned by one log query
+	MaxQueryLines   int `default:"100000" yaml:"max-query-lines"` // max log lines loaded to evaluate parsers and label filters in memory
+	MaxSeries       int `default:"500" yaml:"max-series"`         // max series returned by one metric or series query
+	MaxPoints       int `default:"11000" yaml:"max-points"`       // max points of one series in a metric query
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import (
+	"context"
+)
+
+type LokiQueryParams struct {
+	Query     string
+	StartTime string
+	EndTime   string
+	Time      string // instant query
+	Step      string
+	Limit     string
+	Direction string
+	OrgID     string
+	Debug     bool
+	Context   context.Context
+}
+
+type LokiMetaParams struct {
+	LabelName string
+	Query     string
+	StartTime string
+	EndTime   string
+	Matchers  []string
+	OrgID     string
+	Context   context.Context
+}
+
+const (
+	RESULT_TYPE_STREAMS = "streams"
+	RESULT_TYPE_MATRIX  = "matrix"
+	RESULT_TYPE_VECTOR  = "vector"
+
+	STATUS_SUCCESS = "success"
+	STATUS_ERROR   = "error"
+)
+
+// LokiResponse is the response of the Loki HTTP API,
+// see https://grafana.com/docs/loki/latest/reference/loki-http-api/#query-logs-within-a-range-of-time
+type LokiResponse struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Warnings  []string    `json:"warnings,omitempty"`
+}
+
+type LokiQueryData struct {
+	ResultType string                 `json:"resultType"`
+	Result     interface{}            `json:"result"`
+	Stats      map[string]interface{} `json:"stats"`
+	Warnings   []string               `json:"-"` // returned in LokiResponse
+}
+
+// LokiStream is one item of the 'streams' result, each value is [<unix epoch in ns>, <log line>]
+type LokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// LokiSeries is one item of the 'matrix' result, each value is [<unix epoch in seconds>, <value>]
+type LokiSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]interface{}  `json:"values"`
+}
+
+// LokiSample is one item of the 'vector' result
+type LokiSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]interface{}    `json:"value"`
+}
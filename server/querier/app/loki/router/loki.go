@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package router
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/deepflowio/deepflow/server/querier/app/loki/model"
+	"github.com/deepflowio/deepflow/server/querier/app/loki/service"
+	"github.com/deepflowio/deepflow/server/querier/common"
+)
+
+// Loki HTTP API, see https://grafana.com/docs/loki/latest/reference/loki-http-api/
+func lokiQueryRange(svc *service.LokiService) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		args := queryParams(c)
+		args.StartTime = c.Request.FormValue("start")
+		args.EndTime = c.Request.FormValue("end")
+		args.Step = c.Request.FormValue("step")
+		args.Limit = c.Request.FormValue("limit")
+		args.Direction = c.Request.FormValue("direction")
+		result, err := svc.QueryRange(args)
+		respond(c, result, err)
+	})
+}
+
+func lokiQuery(svc *service.LokiService) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		args := queryParams(c)
+		args.Time = c.Request.FormValue("time")
+		result, err := svc.Query(args)
+		respond(c, result, err)
+	})
+}
+
+func lokiLabels(svc *service.LokiService) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		result, err := svc.Labels(metaParams(c))
+		respond(c, result, err)
+	})
+}
+
+func lokiLabelValues(svc *service.LokiService) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		args := metaParams(c)
+		args.LabelName = c.Param("labelName")
+		args.Query = c.Request.FormValue("query")
+		result, err := svc.LabelValues(args)
+		respond(c, result, err)
+	})
+}
+
+func lokiSeries(svc *service.LokiService) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		args := metaParams(c)
+		c.Request.ParseForm()
+		args.Matchers = c.Request.Form["match[]"]
+		if len(args.Matchers) == 0 {
+			args.Matchers = c.Request.Form["match"]
+		}
+		result, err := svc.Series(args)
+		respond(c, result, err)
+	})
+}
+
+func queryParams(c *gin.Context) *model.LokiQueryParams {
+	args := &model.LokiQueryParams{}
+	args.OrgID = c.Request.Header.Get(common.HEADER_KEY_X_ORG_ID)
+	args.Context = c.Request.Context()
+	args.Query = c.Request.FormValue("query")
+	args.Debug, _ = strconv.ParseBool(c.Request.FormValue("debug"))
+	return args
+}
+
+func metaParams(c *gin.Context) *model.LokiMetaParams {
+	args := &model.LokiMetaParams{}
+	args.OrgID = c.Request.Header.Get(common.HEADER_KEY_X_ORG_ID)
+	args.Context = c.Request.Context()
+	args.StartTime = c.Request.FormValue("start")
+	args.EndTime = c.Request.FormValue("end")
+	return args
+}
+
+func respond(c *gin.Context, data interface{}, err error) {
+	if err == nil {
+		response := &model.LokiResponse{Status: model.STATUS_SUCCESS, Data: data}
+		if queryData, ok := data.(*model.LokiQueryData); ok {
+			response.Warnings = queryData.Warnings
+		}
+		c.JSON(200, response)
+		return
+	}
+	var serviceError *common.ServiceError
+	if errors.As(err, &serviceError) && serviceError.Status == common.INVALID_PARAMETERS {
+		c.JSON(400, &model.LokiResponse{Status: model.STATUS_ERROR, ErrorType: "bad_data", Error: serviceError.Message})
+		return
+	}
+	c.JSON(500, &model.LokiResponse{Status: model.STATUS_ERROR, ErrorType: "execution", Error: err.Error()})
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/deepflowio/deepflow/server/querier/app/loki/service"
+	prometheus_router "github.com/deepflowio/deepflow/server/querier/app/prometheus/router"
+	"github.com/deepflowio/deepflow/server/querier/config"
+)
+
+func LokiRouter(e *gin.Engine) {
+	lokiService := service.NewLokiService()
+	// Both SetRate and Acquire are expanded by 1000 times, making it suitable for small QPS scenarios.
+	lokiService.QPSLeakyBucket.Init(uint64(config.Cfg.Loki.QPSLimit * 1000))
+
+	lokiGroup := e.Group("/loki/api/v1")
+	lokiGroup.Use(prometheus_router.Limiter(lokiService.QPSLeakyBucket))
+	{
+		lokiGroup.GET("/query_range", lokiQueryRange(lokiService))
+		lokiGroup.POST("/query_range", lokiQueryRange(lokiService))
+		lokiGroup.GET("/query", lokiQuery(lokiService))
+		lokiGroup.POST("/query", lokiQuery(lokiService))
+		lokiGroup.GET("/labels", lokiLabels(lokiService))
+		lokiGroup.POST("/labels", lokiLabels(lokiService))
+		lokiGroup.GET("/label/:labelName/values", lokiLabelValues(lokiService))
+		lokiGroup.POST("/label/:labelName/values", lokiLabelValues(lokiService))
+		lokiGroup.GET("/series", lokiSeries(lokiService))
+		lokiGroup.POST("/series", lokiSeries(lokiService))
+	}
+}
@@ -0,0 +1,531 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/prometheus/common/model"
+)
+
+// A practical subset of LogQL, see https://grafana.com/docs/loki/latest/query/
+//
+//	log query:    {app="foo", pod=~"foo-.*"} |= "error" != "timeout" |~ "code=5\\d\\d" | json | status >= 500
+//	metric query: sum by (pod) (rate({app="foo"} |= "error" [5m]))
+//
+// Supported pipeline stages are line filters, the 'json' and 'logfmt' parsers and label filters,
+// supported range aggregations are 'count_over_time' and 'rate', which can be wrapped by
+// vector aggregations 'sum', 'count', 'avg', 'min' and 'max' with 'by' or 'without'.
+
+type MatchType int
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+func (t MatchType) String() string {
+	switch t {
+	case MatchEqual:
+		return "="
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	default:
+		return "!~"
+	}
+}
+
+type LabelMatcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+}
+
+// LineFilter matches the log line, MatchEqual means the line contains the value
+type LineFilter struct {
+	Type  MatchType
+	Value string
+}
+
+type ParserStage struct {
+	Name string // json or logfmt
+}
+
+// LabelFilter filters by the stream labels and the labels extracted by the parsers.
+// When IsNumber is true, Op is one of '==', '!=', '>', '>=', '<', '<=' and compares with Number,
+// otherwise Type matches the string Value.
+type LabelFilter struct {
+	Name     string
+	Type     MatchType
+	Value    string
+	Op       string
+	Number   float64
+	IsNumber bool
+
+	re *regexp.Regexp
+}
+
+type LogQuery struct {
+	Matchers    []*LabelMatcher
+	LineFilters []*LineFilter
+	// parsers and label filters in the order of the pipeline
+	Stages []interface{}
+}
+
+type RangeAggregation struct {
+	Op    string // count_over_time or rate
+	Query *LogQuery
+	Range time.Duration
+}
+
+type VectorAggregation struct {
+	Op       string // sum, count, avg, min or max
+	Grouping []string
+	Without  bool
+	Inner    Expr
+}
+
+type Expr interface{}
+
+var (
+	rangeOps  = map[string]bool{"count_over_time": true, "rate": true}
+	vectorOps = map[string]bool{"sum": true, "count": true, "avg": true, "min": true, "max": true}
+	parsers   = map[string]bool{"json": true, "logfmt": true}
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenDuration
+	tokenOperator
+)
+
+type token struct {
+	typ   tokenType
+	value string
+	pos   int
+}
+
+type lexer struct {
+	input  string
+	pos    int
+	tokens []token
+}
+
+func lex(input string) ([]token, error) {
+	l := &lexer{input: input}
+	for {
+		for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
+			l.pos++
+		}
+		if l.pos >= len(l.input) {
+			l.tokens = append(l.tokens, token{typ: tokenEOF, pos: l.pos})
+			return l.tokens, nil
+		}
+		start := l.pos
+		c := l.input[l.pos]
+		switch {
+		case c == '"' || c == '`':
+			quoted, err := strconv.QuotedPrefix(l.input[l.pos:])
+			if err != nil {
+				return nil, fmt.Errorf("parse error at position %d: invalid string", start)
+			}
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("parse error at position %d: %s", start, err)
+			}
+			l.pos += len(quoted)
+			l.tokens = append(l.tokens, token{typ: tokenString, value: value, pos: start})
+		case c == '[':
+			end := strings.IndexByte(l.input[l.pos:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("parse error at position %d: missing ']'", start)
+			}
+			l.tokens = append(l.tokens, token{typ: tokenDuration, value: strings.TrimSpace(l.input[l.pos+1 : l.pos+end]), pos: start})
+			l.pos += end + 1
+		case c == '_' || unicode.IsLetter(rune(c)):
+			for l.pos < len(l.input) && (l.input[l.pos] == '_' || unicode.IsLetter(rune(l.input[l.pos])) || unicode.IsDigit(rune(l.input[l.pos]))) {
+				l.pos++
+			}
+			l.tokens = append(l.tokens, token{typ: tokenIdentifier, value: l.input[start:l.pos], pos: start})
+		case unicode.IsDigit(rune(c)) || c == '-' || c == '.':
+			l.pos++
+			for l.pos < len(l.input) && (unicode.IsDigit(rune(l.input[l.pos])) || unicode.IsLetter(rune(l.input[l.pos])) || l.input[l.pos] == '.') {
+				l.pos++
+			}
+			l.tokens = append(l.tokens, token{typ: tokenNumber, value: l.input[start:l.pos], pos: start})
+		default:
+			op := ""
+			for _, candidate := range []string{"|=", "|~", "!=", "!~", "=~", "==", ">=", "<=", "{", "}", "(", ")", ",", "|", "=", ">", "<"} {
+				if strings.HasPrefix(l.input[l.pos:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("parse error at position %d: unexpected character '%c'", start, c)
+			}
+			l.pos += len(op)
+			l.tokens = append(l.tokens, token{typ: tokenOperator, value: op, pos: start})
+		}
+	}
+}
+
+type logQLParser struct {
+	tokens []token
+	pos    int
+}
+
+// ParseLogQL parses the query into one of *LogQuery, *RangeAggregation or *VectorAggregation
+func ParseLogQL(query string) (Expr, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &logQLParser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, p.unexpected(t)
+	}
+	return expr, nil
+}
+
+func (p *logQLParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *logQLParser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *logQLParser) unexpected(t token) error {
+	if t.typ == tokenEOF {
+		return fmt.Errorf("parse error: unexpected end of query")
+	}
+	return fmt.Errorf("parse error at position %d: unexpected '%s'", t.pos, t.value)
+}
+
+func (p *logQLParser) expect(typ tokenType, value string) error {
+	t := p.next()
+	if t.typ != typ || (value != "" && t.value != value) {
+		return p.unexpected(t)
+	}
+	return nil
+}
+
+func (p *logQLParser) parseExpr() (Expr, error) {
+	t := p.peek()
+	if t.typ == tokenOperator && t.value == "{" {
+		return p.parseLogQuery()
+	}
+	if t.typ == tokenOperator && t.value == "(" {
+		p.next()
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(tokenOperator, ")")
+	}
+	if t.typ != tokenIdentifier {
+		return nil, p.unexpected(t)
+	}
+	if rangeOps[t.value] {
+		return p.parseRangeAggregation()
+	}
+	if vectorOps[t.value] {
+		return p.parseVectorAggregation()
+	}
+	return nil, fmt.Errorf("parse error at position %d: unsupported function '%s'", t.pos, t.value)
+}
+
+func (p *logQLParser) parseRangeAggregation() (Expr, error) {
+	agg := &RangeAggregation{Op: p.next().value}
+	if err := p.expect(tokenOperator, "("); err != nil {
+		return nil, err
+	}
+	query, err := p.parseLogQuery()
+	if err != nil {
+		return nil, err
+	}
+	agg.Query = query
+	t := p.next()
+	if t.typ != tokenDuration {
+		return nil, fmt.Errorf("parse error at position %d: %s requires a range, such as [5m]", t.pos, agg.Op)
+	}
+	d, err := model.ParseDuration(t.value)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("parse error at position %d: invalid range '%s'", t.pos, t.value)
+	}
+	agg.Range = time.Duration(d)
+	return agg, p.expect(tokenOperator, ")")
+}
+
+func (p *logQLParser) parseGrouping(agg *VectorAggregation) error {
+	t := p.peek()
+	if t.typ != tokenIdentifier || (t.value != "by" && t.value != "without") {
+		return nil
+	}
+	p.next()
+	agg.Without = t.value == "without"
+	if err := p.expect(tokenOperator, "("); err != nil {
+		return err
+	}
+	for {
+		t := p.next()
+		if t.typ == tokenOperator && t.value == ")" {
+			return nil
+		}
+		if t.typ != tokenIdentifier {
+			return p.unexpected(t)
+		}
+		agg.Grouping = append(agg.Grouping, t.value)
+		if n := p.peek(); n.typ == tokenOperator && n.value == "," {
+			p.next()
+		}
+	}
+}
+
+func (p *logQLParser) parseVectorAggregation() (Expr, error) {
+	agg := &VectorAggregation{Op: p.next().value}
+	if err := p.parseGrouping(agg); err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokenOperator, "("); err != nil {
+		return nil, err
+	}
+	inner, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := inner.(*LogQuery); ok {
+		return nil, fmt.Errorf("parse error: %s requires a metric query, such as count_over_time({...}[5m])", agg.Op)
+	}
+	agg.Inner = inner
+	if err := p.expect(tokenOperator, ")"); err != nil {
+		return nil, err
+	}
+	if agg.Grouping == nil {
+		if err := p.parseGrouping(agg); err != nil {
+			return nil, err
+		}
+	}
+	return agg, nil
+}
+
+func (p *logQLParser) parseLogQuery() (*LogQuery, error) {
+	query := &LogQuery{}
+	matchers, err := p.parseSelector()
+	if err != nil {
+		return nil, err
+	}
+	query.Matchers = matchers
+
+	for {
+		t := p.peek()
+		if t.typ != tokenOperator {
+			return query, nil
+		}
+		switch t.value {
+		case "|=", "!=", "|~", "!~":
+			p.next()
+			value := p.next()
+			if value.typ != tokenString {
+				return nil, p.unexpected(value)
+			}
+			filter := &LineFilter{Value: value.value}
+			switch t.value {
+			case "|=":
+				filter.Type = MatchEqual
+			case "!=":
+				filter.Type = MatchNotEqual
+			case "|~":
+				filter.Type = MatchRegexp
+			case "!~":
+				filter.Type = MatchNotRegexp
+			}
+			if filter.Type == MatchRegexp || filter.Type == MatchNotRegexp {
+				if _, err := regexp.Compile(filter.Value); err != nil {
+					return nil, fmt.Errorf("parse error at position %d: invalid regexp: %s", value.pos, err)
+				}
+			}
+			query.LineFilters = append(query.LineFilters, filter)
+		case "|":
+			p.next()
+			stage := p.next()
+			if stage.typ != tokenIdentifier {
+				return nil, p.unexpected(stage)
+			}
+			if parsers[stage.value] {
+				query.Stages = append(query.Stages, &ParserStage{Name: stage.value})
+				continue
+			}
+			if n := p.peek(); n.typ != tokenOperator || n.value == "|" || n.value == ")" {
+				return nil, fmt.Errorf("parse error at position %d: unsupported pipeline stage '%s'", stage.pos, stage.value)
+			}
+			filters, err := p.parseLabelFilters(stage)
+			if err != nil {
+				return nil, err
+			}
+			for _, filter := range filters {
+				query.Stages = append(query.Stages, filter)
+			}
+		default:
+			return query, nil
+		}
+	}
+}
+
+func (p *logQLParser) parseSelector() ([]*LabelMatcher, error) {
+	if err := p.expect(tokenOperator, "{"); err != nil {
+		return nil, err
+	}
+	var matchers []*LabelMatcher
+	for {
+		t := p.next()
+		if t.typ == tokenOperator && t.value == "}" {
+			break
+		}
+		if t.typ != tokenIdentifier {
+			return nil, p.unexpected(t)
+		}
+		op := p.next()
+		matcher := &LabelMatcher{Name: t.value}
+		switch op.value {
+		case "=":
+			matcher.Type = MatchEqual
+		case "!=":
+			matcher.Type = MatchNotEqual
+		case "=~":
+			matcher.Type = MatchRegexp
+		case "!~":
+			matcher.Type = MatchNotRegexp
+		default:
+			return nil, p.unexpected(op)
+		}
+		value := p.next()
+		if value.typ != tokenString {
+			return nil, p.unexpected(value)
+		}
+		matcher.Value = value.value
+		if matcher.Type == MatchRegexp || matcher.Type == MatchNotRegexp {
+			if _, err := regexp.Compile(matcher.Value); err != nil {
+				return nil, fmt.Errorf("parse error at position %d: invalid regexp: %s", value.pos, err)
+			}
+		}
+		matchers = append(matchers, matcher)
+		if n := p.peek(); n.typ == tokenOperator && n.value == "," {
+			p.next()
+		}
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("parse error: stream selector should contain at least one label matcher")
+	}
+	return matchers, nil
+}
+
+// parseLabelFilters parses filters such as 'status >= 500', 'level="error", pod=~"web-.*"' and
+// 'status >= 500 and duration < 1s'
+func (p *logQLParser) parseLabelFilters(name token) ([]*LabelFilter, error) {
+	var filters []*LabelFilter
+	for {
+		filter := &LabelFilter{Name: name.value}
+		op := p.next()
+		value := p.next()
+		switch op.value {
+		case "=", "!=", "=~", "!~":
+			if value.typ == tokenString {
+				switch op.value {
+				case "=":
+					filter.Type = MatchEqual
+				case "!=":
+					filter.Type = MatchNotEqual
+				case "=~":
+					filter.Type = MatchRegexp
+				case "!~":
+					filter.Type = MatchNotRegexp
+				}
+				filter.Value = value.value
+				if filter.Type == MatchRegexp || filter.Type == MatchNotRegexp {
+					re, err := regexp.Compile("^(?:" + filter.Value + ")$")
+					if err != nil {
+						return nil, fmt.Errorf("parse error at position %d: invalid regexp: %s", value.pos, err)
+					}
+					filter.re = re
+				}
+				break
+			}
+			if op.value == "=~" || op.value == "!~" {
+				return nil, p.unexpected(value)
+			}
+			fallthrough
+		case "==", ">", ">=", "<", "<=":
+			if value.typ != tokenNumber {
+				return nil, p.unexpected(value)
+			}
+			number, err := parseNumber(value.value)
+			if err != nil {
+				return nil, fmt.Errorf("parse error at position %d: %s", value.pos, err)
+			}
+			filter.IsNumber, filter.Op, filter.Number = true, op.value, number
+		default:
+			return nil, p.unexpected(op)
+		}
+		filters = append(filters, filter)
+
+		// chained by 'and' or ','
+		n := p.peek()
+		if !((n.typ == tokenIdentifier && n.value == "and") || (n.typ == tokenOperator && n.value == ",")) {
+			return filters, nil
+		}
+		p.next()
+		name = p.next()
+		if name.typ != tokenIdentifier {
+			return nil, p.unexpected(name)
+		}
+	}
+}
+
+// parseNumber parses numbers and durations, durations are converted to seconds
+func parseNumber(s string) (float64, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return d.Seconds(), nil
+	}
+	return 0, fmt.Errorf("invalid number '%s'", s)
+}
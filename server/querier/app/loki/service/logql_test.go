@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLogQL(t *testing.T) {
+	testCases := []struct {
+		name  string
+		query string
+		err   bool
+	}{
+		{"selector", `{app="foo", pod=~"foo-.*"}`, false},
+		{"line filters", `{app="foo"} |= "error" != "timeout" |~ "code=5\\d\\d" !~ "(?i)debug"`, false},
+		{"parsers and label filters", `{app="foo"} | json | status >= 500 and duration < 1s | level="error", pod!~"web-.*"`, false},
+		{"range aggregation", `rate({app="foo"} |= "error" [5m])`, false},
+		{"vector aggregation", `sum by (level) (count_over_time({app="foo"}[1m]))`, false},
+		{"grouping after", `sum(count_over_time({app="foo"}[1m])) by (pod)`, false},
+		{"empty selector", `{}`, true},
+		{"missing range", `count_over_time({app="foo"})`, true},
+		{"vector of logs", `sum({app="foo"})`, true},
+		{"unsupported stage", `{app="foo"} | line_format "{{.msg}}"`, true},
+		{"unsupported function", `bytes_rate({app="foo"}[1m])`, true},
+		{"invalid regexp", `{app=~"("}`, true},
+		{"trailing", `{app="foo"} foo`, true},
+	}
+	for _, tc := range testCases {
+		_, err := ParseLogQL(tc.query)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: %s, expected error: %v, got: %v", tc.name, tc.query, tc.err, err)
+		}
+	}
+}
+
+func TestParseMetricQuery(t *testing.T) {
+	expr, err := ParseLogQL(`sum by (pod, level) (rate({app="foo"} |= "error" | logfmt [5m]))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agg, ok := expr.(*VectorAggregation)
+	if !ok || agg.Op != "sum" || !reflect.DeepEqual(agg.Grouping, []string{"pod", "level"}) {
+		t.Fatalf("unexpected vector aggregation %+v", expr)
+	}
+	rangeAgg, ok := agg.Inner.(*RangeAggregation)
+	if !ok || rangeAgg.Op != "rate" || rangeAgg.Range != 5*time.Minute {
+		t.Fatalf("unexpected range aggregation %+v", agg.Inner)
+	}
+	if len(rangeAgg.Query.LineFilters) != 1 || len(rangeAgg.Query.Stages) != 1 {
+		t.Errorf("unexpected log query %+v", rangeAgg.Query)
+	}
+}
+
+func TestWhereClause(t *testing.T) {
+	testCases := []struct {
+		query  string
+		output string
+	}{
+		{
+			`{service_name="api", pod=~"web-.*"}`,
+			`time>=1 AND time<=2 AND app_service = 'api' AND pod REGEXP '^(?:web-.*)$'`,
+		},
+		{
+			`{level="warning", env!="prod"} |= "it's" != "a.b"`,
+			`time>=1 AND time<=2 AND Enum(severity_number) = 'WARN' AND attribute.env != 'prod' AND body REGEXP 'it\'s' AND body NOT REGEXP 'a\\.b'`,
+		},
+		{
+			`{level=~"error|warn"} !~ "\\d+"`,
+			`time>=1 AND time<=2 AND Enum(severity_number) REGEXP '(?i)^(?:error|warn)$' AND body NOT REGEXP '\\d+'`,
+		},
+	}
+	for _, tc := range testCases {
+		expr, err := ParseLogQL(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output := whereClause(expr.(*LogQuery), 1, 2); output != tc.output {
+			t.Errorf("query: %s\nexpected: %s\n     got: %s", tc.query, tc.output, output)
+		}
+	}
+}
+
+func TestRunPipeline(t *testing.T) {
+	testCases := []struct {
+		query   string
+		line    string
+		matched bool
+		labels  map[string]string
+	}{
+		{`{pod="a"} | json`, `{"level":"warn","http":{"status":502},"pod":"b"}`, true,
+			map[string]string{"pod": "a", "level": "warn", "http_status": "502", "pod_extracted": "b"}},
+		{`{pod="a"} | json | http_status >= 500`, `{"http":{"status":404}}`, false, nil},
+		{`{pod="a"} | logfmt | duration > 1s`, `msg="slow query" duration=1.5s`, true,
+			map[string]string{"pod": "a", "msg": "slow query", "duration": "1.5s"}},
+		{`{pod="a"} | json`, `not json`, true,
+			map[string]string{"pod": "a", LABEL_ERROR: ERROR_JSON_PARSER}},
+		{`{pod="a"} | json | __error__=""`, `not json`, false, nil},
+		{`{pod="a"} | env=~"prod|staging"`, `hello`, true, map[string]string{"pod": "a"}},
+		{`{pod="a"} | status > 1`, `hello`, true, map[string]string{"pod": "a", LABEL_ERROR: ERROR_LABEL_FILTER}},
+	}
+	for _, tc := range testCases {
+		expr, err := ParseLogQL(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := &logEntry{line: tc.line, labels: map[string]string{"pod": "a"}, attributes: map[string]string{"env": "prod"}}
+		matched := runPipeline(e, expr.(*LogQuery).Stages)
+		if matched != tc.matched {
+			t.Errorf("query: %s, line: %s, expected matched: %v", tc.query, tc.line, tc.matched)
+			continue
+		}
+		if matched && !reflect.DeepEqual(e.labels, tc.labels) {
+			t.Errorf("query: %s, line: %s, expected labels: %v, got: %v", tc.query, tc.line, tc.labels, e.labels)
+		}
+	}
+}
+
+func TestEvalRange(t *testing.T) {
+	set := rangeSeriesSet{}
+	set.add(map[string]string{"pod": "a"}, 0, 1)
+	set.add(map[string]string{"pod": "a"}, 60, 2)
+	set.add(map[string]string{"pod": "a"}, 120, 3)
+	set.add(map[string]string{"pod": "b"}, 60, 4)
+
+	agg := &RangeAggregation{Op: "count_over_time", Range: 2 * time.Minute}
+	result := evalRange(agg, set, 60, 240, 60)
+	if len(result) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(result))
+	}
+	for _, s := range result {
+		var expected map[int64]float64
+		if s.labels["pod"] == "a" {
+			// the window of t is [t-range, t)
+			expected = map[int64]float64{60: 1, 120: 3, 180: 5, 240: 3}
+		} else {
+			expected = map[int64]float64{120: 4, 180: 4}
+		}
+		if !reflect.DeepEqual(s.values, expected) {
+			t.Errorf("pod %s expected %v, got %v", s.labels["pod"], expected, s.values)
+		}
+	}
+
+	sum := evalVector(&VectorAggregation{Op: "sum"}, result)
+	if len(sum) != 1 || !reflect.DeepEqual(sum[0].values, map[int64]float64{60: 1, 120: 7, 180: 9, 240: 3}) {
+		t.Errorf("unexpected sum %+v", sum[0])
+	}
+	count := evalVector(&VectorAggregation{Op: "count", Grouping: []string{"pod"}, Without: true}, result)
+	if len(count) != 1 || count[0].values[120] != 2 || count[0].values[240] != 1 {
+		t.Errorf("unexpected count %+v", count[0])
+	}
+}
+
+func TestCountInterval(t *testing.T) {
+	testCases := []struct {
+		name                  string
+		start, end, step, rng int64
+		interval              int64
+		err                   bool
+	}{
+		{"aligned", 0, 3600, 60, 300, 60, false},
+		{"gcd", 0, 3600, 40, 60, 20, false},
+		{"instant", 1000, 1000, 1, 86400, 8, false},
+		{"coarsened", 0, 86400, 7, 60, 10, false},
+		{"too long", 0, 86400 * 30, 86400, 60, 0, true},
+	}
+	for _, tc := range testCases {
+		interval, err := countInterval(tc.start, tc.end, tc.step, tc.rng, 11000)
+		if (err != nil) != tc.err || interval != tc.interval {
+			t.Errorf("%s: expected interval %d err %v, got %d %v", tc.name, tc.interval, tc.err, interval, err)
+		}
+	}
+}
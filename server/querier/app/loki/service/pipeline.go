@@ -0,0 +1,366 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the pipeline stages which can not be translated to DeepFlow SQL are evaluated in memory
+
+const (
+	ERROR_JSON_PARSER   = "JSONParserErr"
+	ERROR_LOGFMT_PARSER = "LogfmtParserErr"
+	ERROR_LABEL_FILTER  = "LabelFilterErr"
+)
+
+type logEntry struct {
+	timestamp  int64 // us
+	line       string
+	labels     map[string]string
+	attributes map[string]string
+}
+
+// needPipeline returns true if the query has stages to be evaluated in memory
+func needPipeline(query *LogQuery) bool {
+	return len(query.Stages) > 0
+}
+
+// runPipeline runs the parsers and label filters, the extracted labels are added to the
+// labels of the entry, returns false if the entry is filtered out
+func runPipeline(e *logEntry, stages []interface{}) bool {
+	for _, stage := range stages {
+		switch s := stage.(type) {
+		case *ParserStage:
+			extracted := map[string]string{}
+			var err error
+			if s.Name == "json" {
+				err = parseJsonLabels(e.line, extracted)
+			} else {
+				err = parseLogfmtLabels(e.line, extracted)
+			}
+			if err != nil {
+				if _, ok := e.labels[LABEL_ERROR]; !ok {
+					if s.Name == "json" {
+						e.labels[LABEL_ERROR] = ERROR_JSON_PARSER
+					} else {
+						e.labels[LABEL_ERROR] = ERROR_LOGFMT_PARSER
+					}
+				}
+				continue
+			}
+			for k, v := range extracted {
+				if _, ok := e.labels[k]; ok {
+					k += "_extracted"
+				}
+				e.labels[k] = v
+			}
+		case *LabelFilter:
+			value, ok := e.labels[s.Name]
+			if !ok {
+				value = e.attributes[s.Name]
+			}
+			matched, err := s.match(value)
+			if err != nil {
+				// same as Loki, the entry is kept with an error label
+				if _, ok := e.labels[LABEL_ERROR]; !ok {
+					e.labels[LABEL_ERROR] = ERROR_LABEL_FILTER
+				}
+				continue
+			}
+			if !matched {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f *LabelFilter) match(value string) (bool, error) {
+	if !f.IsNumber {
+		switch f.Type {
+		case MatchEqual:
+			return value == f.Value, nil
+		case MatchNotEqual:
+			return value != f.Value, nil
+		case MatchRegexp:
+			return f.re.MatchString(value), nil
+		default:
+			return !f.re.MatchString(value), nil
+		}
+	}
+	number, err := parseNumber(value)
+	if err != nil {
+		return false, err
+	}
+	switch f.Op {
+	case "==", "=":
+		return number == f.Number, nil
+	case "!=":
+		return number != f.Number, nil
+	case ">":
+		return number > f.Number, nil
+	case ">=":
+		return number >= f.Number, nil
+	case "<":
+		return number < f.Number, nil
+	default:
+		return number <= f.Number, nil
+	}
+}
+
+// sanitizeLabelName replaces the characters which are not allowed in label names with '_'
+func sanitizeLabelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// parseJsonLabels extracts the fields of a JSON object, nested fields are joined by '_',
+// such as {"a": {"b": 1}} is extracted as a_b="1", arrays are ignored
+func parseJsonLabels(line string, labels map[string]string) error {
+	obj := map[string]interface{}{}
+	d := json.NewDecoder(strings.NewReader(line))
+	d.UseNumber()
+	if err := d.Decode(&obj); err != nil {
+		return err
+	}
+	flattenJson("", obj, labels)
+	return nil
+}
+
+func flattenJson(prefix string, obj map[string]interface{}, labels map[string]string) {
+	for k, v := range obj {
+		key := sanitizeLabelName(k)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flattenJson(key, v, labels)
+		case string:
+			labels[key] = v
+		case json.Number:
+			labels[key] = v.String()
+		case bool:
+			labels[key] = strconv.FormatBool(v)
+		}
+	}
+}
+
+// parseLogfmtLabels extracts the key=value pairs, such as: level=info msg="hello world" duration=1.2s
+func parseLogfmtLabels(line string, labels map[string]string) error {
+	for i := 0; i < len(line); {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" || key[0] == '"' {
+			return fmt.Errorf("invalid key at position %d", start)
+		}
+		if i >= len(line) || line[i] == ' ' {
+			// a bare key
+			labels[sanitizeLabelName(key)] = ""
+			continue
+		}
+		i++ // skip '='
+		var value string
+		if i < len(line) && line[i] == '"' {
+			quoted, err := strconv.QuotedPrefix(line[i:])
+			if err != nil {
+				return fmt.Errorf("invalid value of %s: %s", key, err)
+			}
+			value, _ = strconv.Unquote(quoted)
+			i += len(quoted)
+		} else {
+			start = i
+			for i < len(line) && line[i] != ' ' {
+				i++
+			}
+			value = line[start:i]
+		}
+		labels[sanitizeLabelName(key)] = value
+	}
+	return nil
+}
+
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+// rangeSeries counts the log lines of each interval, the key of buckets is the start time
+// of the interval in seconds
+type rangeSeries struct {
+	labels  map[string]string
+	buckets map[int64]float64
+}
+
+type rangeSeriesSet map[string]*rangeSeries
+
+func (s rangeSeriesSet) add(labels map[string]string, bucket int64, count float64) {
+	key := labelsKey(labels)
+	series, ok := s[key]
+	if !ok {
+		series = &rangeSeries{labels: labels, buckets: map[int64]float64{}}
+		s[key] = series
+	}
+	series.buckets[bucket] += count
+}
+
+// series is the result of a metric query, the key of values is the evaluation time in seconds
+type series struct {
+	labels map[string]string
+	values map[int64]float64
+}
+
+// evalRange evaluates the range aggregation at each step, the window of the evaluation time t
+// is [t-range, t), the points without log lines are absent same as Loki
+func evalRange(agg *RangeAggregation, set rangeSeriesSet, start, end, step int64) []*series {
+	rangeSeconds := int64(agg.Range / time.Second)
+	result := make([]*series, 0, len(set))
+	for _, rs := range set {
+		buckets := make([]int64, 0, len(rs.buckets))
+		for b := range rs.buckets {
+			buckets = append(buckets, b)
+		}
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+		s := &series{labels: rs.labels, values: map[int64]float64{}}
+		var sum float64
+		head, tail := 0, 0
+		for t := start; t <= end; t += step {
+			for ; tail < len(buckets) && buckets[tail] < t; tail++ {
+				sum += rs.buckets[buckets[tail]]
+			}
+			for ; head < tail && buckets[head] < t-rangeSeconds; head++ {
+				sum -= rs.buckets[buckets[head]]
+			}
+			if head == tail {
+				continue
+			}
+			if agg.Op == "rate" {
+				s.values[t] = sum / agg.Range.Seconds()
+			} else {
+				s.values[t] = sum
+			}
+		}
+		if len(s.values) > 0 {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func groupLabels(agg *VectorAggregation, labels map[string]string) map[string]string {
+	grouped := map[string]string{}
+	if agg.Without {
+		for k, v := range labels {
+			if !contains(agg.Grouping, k) {
+				grouped[k] = v
+			}
+		}
+		return grouped
+	}
+	for _, k := range agg.Grouping {
+		if v, ok := labels[k]; ok {
+			grouped[k] = v
+		}
+	}
+	return grouped
+}
+
+// evalVector evaluates the vector aggregation of the inner series
+func evalVector(agg *VectorAggregation, in []*series) []*series {
+	type accumulator struct {
+		value float64
+		count int
+	}
+	groups := map[string]*series{}
+	accumulators := map[string]map[int64]*accumulator{}
+	for _, s := range in {
+		labels := groupLabels(agg, s.labels)
+		key := labelsKey(labels)
+		if _, ok := groups[key]; !ok {
+			groups[key] = &series{labels: labels, values: map[int64]float64{}}
+			accumulators[key] = map[int64]*accumulator{}
+		}
+		for t, v := range s.values {
+			acc, ok := accumulators[key][t]
+			if !ok {
+				accumulators[key][t] = &accumulator{value: v, count: 1}
+				continue
+			}
+			acc.count++
+			switch agg.Op {
+			case "min":
+				if v < acc.value {
+					acc.value = v
+				}
+			case "max":
+				if v > acc.value {
+					acc.value = v
+				}
+			default:
+				acc.value += v
+			}
+		}
+	}
+
+	result := make([]*series, 0, len(groups))
+	for key, g := range groups {
+		for t, acc := range accumulators[key] {
+			switch agg.Op {
+			case "count":
+				g.values[t] = float64(acc.count)
+			case "avg":
+				g.values[t] = acc.value / float64(acc.count)
+			default:
+				g.values[t] = acc.value
+			}
+		}
+		result = append(result, g)
+	}
+	return result
+}
@@ -0,0 +1,616 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	logging "github.com/op/go-logging"
+	promModel "github.com/prometheus/common/model"
+
+	"github.com/deepflowio/deepflow/server/libs/datastructure"
+	"github.com/deepflowio/deepflow/server/querier/app/loki/model"
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/config"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse"
+)
+
+var log = logging.MustGetLogger("loki")
+
+const (
+	DEFAULT_LIMIT          = 100
+	DEFAULT_LOOKBACK       = time.Hour
+	DEFAULT_META_LOOKBACK  = 6 * time.Hour
+	DEFAULT_MAX_DATAPOINTS = 250 // the default step is (end - start) / DEFAULT_MAX_DATAPOINTS same as Loki
+	DIRECTION_FORWARD      = "forward"
+)
+
+type LokiService struct {
+	// loki query rate limit
+	QPSLeakyBucket *datastructure.LeakyBucket
+}
+
+func NewLokiService() *LokiService {
+	return &LokiService{
+		QPSLeakyBucket: &datastructure.LeakyBucket{},
+	}
+}
+
+func badData(format string, a ...interface{}) error {
+	return common.NewError(common.INVALID_PARAMETERS, fmt.Sprintf(format, a...))
+}
+
+// parseTime supports unix epoch in seconds or nanoseconds, and RFC3339
+func parseTime(s string, defaultValue time.Time) (time.Time, error) {
+	if s == "" {
+		return defaultValue, nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		// Loki clients use nanoseconds, 10 digits are considered as seconds for convenience
+		if i < 1e10 {
+			return time.Unix(i, 0), nil
+		}
+		return time.Unix(0, i), nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		seconds, fraction := math.Modf(f)
+		return time.Unix(int64(seconds), int64(fraction*1e9)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, badData("invalid time '%s'", s)
+}
+
+// parseStep supports durations and float seconds
+func parseStep(s string, start, end time.Time) (int64, error) {
+	if s == "" {
+		step := int64(end.Sub(start).Seconds()) / DEFAULT_MAX_DATAPOINTS
+		if step < 1 {
+			step = 1
+		}
+		return step, nil
+	}
+	if d, err := promModel.ParseDuration(s); err == nil {
+		if step := int64(time.Duration(d) / time.Second); step > 0 {
+			return step, nil
+		}
+	} else if f, err := strconv.ParseFloat(s, 64); err == nil && f >= 1 {
+		return int64(f), nil
+	}
+	return 0, badData("invalid step '%s', the step should be at least 1s", s)
+}
+
+func (s *LokiService) parseTimeRange(startTime, endTime string, lookback time.Duration) (time.Time, time.Time, error) {
+	end, err := parseTime(endTime, time.Now())
+	if err != nil {
+		return end, end, err
+	}
+	start, err := parseTime(startTime, end.Add(-lookback))
+	if err != nil {
+		return start, end, err
+	}
+	if end.Before(start) {
+		return start, end, badData("end timestamp must not be before start time")
+	}
+	return start, end, nil
+}
+
+func parseSelector(query string) (*LogQuery, error) {
+	expr, err := ParseLogQL(query)
+	if err != nil {
+		return nil, badData("%s", err)
+	}
+	logQuery, ok := expr.(*LogQuery)
+	if !ok {
+		return nil, badData("only log queries are supported, such as {app=\"foo\"}")
+	}
+	return logQuery, nil
+}
+
+func (s *LokiService) QueryRange(args *model.LokiQueryParams) (*model.LokiQueryData, error) {
+	expr, err := ParseLogQL(args.Query)
+	if err != nil {
+		return nil, badData("%s", err)
+	}
+	start, end, err := s.parseTimeRange(args.StartTime, args.EndTime, DEFAULT_LOOKBACK)
+	if err != nil {
+		return nil, err
+	}
+	if query, ok := expr.(*LogQuery); ok {
+		limit, err := s.parseLimit(args.Limit)
+		if err != nil {
+			return nil, err
+		}
+		streams, truncated, err := s.queryLogs(args, query, start, end, limit, args.Direction == DIRECTION_FORWARD)
+		if err != nil {
+			return nil, err
+		}
+		data := &model.LokiQueryData{ResultType: model.RESULT_TYPE_STREAMS, Result: streams, Stats: map[string]interface{}{}}
+		if truncated {
+			data.Warnings = []string{fmt.Sprintf("only the first %d log lines are filtered by the pipeline, the result may be incomplete, please narrow the time range or the stream selector", config.Cfg.Loki.MaxQueryLines)}
+		}
+		return data, nil
+	}
+
+	step, err := parseStep(args.Step, start, end)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.queryMetric(args, expr, start.Unix(), end.Unix(), step)
+	if err != nil {
+		return nil, err
+	}
+	matrix := make([]*model.LokiSeries, 0, len(result))
+	for _, series := range result {
+		times := make([]int64, 0, len(series.values))
+		for t := range series.values {
+			times = append(times, t)
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		values := make([][2]interface{}, 0, len(times))
+		for _, t := range times {
+			values = append(values, [2]interface{}{t, formatValue(series.values[t])})
+		}
+		matrix = append(matrix, &model.LokiSeries{Metric: series.labels, Values: values})
+	}
+	sort.Slice(matrix, func(i, j int) bool { return labelsKey(matrix[i].Metric) < labelsKey(matrix[j].Metric) })
+	return &model.LokiQueryData{ResultType: model.RESULT_TYPE_MATRIX, Result: matrix, Stats: map[string]interface{}{}}, nil
+}
+
+// Query is the instant query, only metric queries are supported
+func (s *LokiService) Query(args *model.LokiQueryParams) (*model.LokiQueryData, error) {
+	expr, err := ParseLogQL(args.Query)
+	if err != nil {
+		return nil, badData("%s", err)
+	}
+	if _, ok := expr.(*LogQuery); ok {
+		return nil, badData("log queries are not supported as an instant query type, please use the range query API")
+	}
+	t, err := parseTime(args.Time, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.queryMetric(args, expr, t.Unix(), t.Unix(), 1)
+	if err != nil {
+		return nil, err
+	}
+	vector := make([]*model.LokiSample, 0, len(result))
+	for _, series := range result {
+		for t, v := range series.values {
+			vector = append(vector, &model.LokiSample{Metric: series.labels, Value: [2]interface{}{t, formatValue(v)}})
+		}
+	}
+	sort.Slice(vector, func(i, j int) bool { return labelsKey(vector[i].Metric) < labelsKey(vector[j].Metric) })
+	return &model.LokiQueryData{ResultType: model.RESULT_TYPE_VECTOR, Result: vector, Stats: map[string]interface{}{}}, nil
+}
+
+func (s *LokiService) parseLimit(limitStr string) (int, error) {
+	if limitStr == "" {
+		return DEFAULT_LIMIT, nil
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		return 0, badData("invalid limit '%s'", limitStr)
+	}
+	if limit > config.Cfg.Loki.MaxEntriesLimit {
+		return 0, badData("max entries limit per query exceeded, limit > max_entries_limit (%d > %d)", limit, config.Cfg.Loki.MaxEntriesLimit)
+	}
+	return limit, nil
+}
+
+// queryLogs returns the streams, and whether the lines loaded for the pipeline are truncated by MaxQueryLines
+func (s *LokiService) queryLogs(args *model.LokiQueryParams, query *LogQuery, start, end time.Time, limit int, forward bool) ([]*model.LokiStream, bool, error) {
+	fetch := limit
+	pipeline := needPipeline(query)
+	if pipeline {
+		// the log lines are filtered in memory, load more lines to fill the limit
+		fetch = config.Cfg.Loki.MaxQueryLines
+	}
+	sql := buildLogSQL(query, start.Unix(), end.Unix(), forward, fetch)
+	result, err := s.execute(args.Context, sql, args.OrgID, args.Debug)
+	if err != nil {
+		return nil, false, err
+	}
+
+	startUs, endUs := start.UnixMicro(), end.UnixMicro()
+	streams := map[string]*model.LokiStream{}
+	var ordered []*model.LokiStream
+	count := 0
+	err = forEachEntry(result, func(e *logEntry) bool {
+		// the time filter of SQL is in seconds
+		if e.timestamp < startUs || e.timestamp > endUs {
+			return true
+		}
+		if !runPipeline(e, query.Stages) {
+			return true
+		}
+		key := labelsKey(e.labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &model.LokiStream{Stream: e.labels}
+			streams[key] = stream
+			ordered = append(ordered, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.timestamp*1000, 10), e.line})
+		count++
+		return count < limit
+	})
+	if ordered == nil {
+		ordered = []*model.LokiStream{}
+	}
+	// the limit is not filled after all the loaded lines are filtered, there may be more matched lines
+	truncated := pipeline && len(result.Values) >= fetch && count < limit
+	return ordered, truncated, err
+}
+
+// forEachEntry converts the rows selected by buildLogSQL to log entries, stops if f returns false
+func forEachEntry(result *common.Result, f func(e *logEntry) bool) error {
+	tsIndex, bodyIndex, attributeIndex := -1, -1, -1
+	labelIndexes := map[int]string{}
+	for i, column := range result.Columns {
+		name, _ := column.(string)
+		switch name {
+		case "ts":
+			tsIndex = i
+		case "body":
+			bodyIndex = i
+		case "attribute":
+			attributeIndex = i
+		default:
+			labelIndexes[i] = name
+		}
+	}
+	if tsIndex < 0 || bodyIndex < 0 {
+		return fmt.Errorf("invalid columns %v of the log query", result.Columns)
+	}
+	for _, value := range result.Values {
+		row, ok := value.([]interface{})
+		if !ok {
+			continue
+		}
+		e := &logEntry{labels: map[string]string{}}
+		e.timestamp, _ = toInt64(row[tsIndex])
+		e.line, _ = row[bodyIndex].(string)
+		for i, name := range labelIndexes {
+			if v := toString(row[i]); v != "" {
+				if name == LABEL_LEVEL {
+					v = strings.ToLower(v)
+				}
+				e.labels[name] = v
+			}
+		}
+		if attributeIndex >= 0 {
+			if attribute := toString(row[attributeIndex]); attribute != "" {
+				e.attributes = map[string]string{}
+				json.Unmarshal([]byte(attribute), &e.attributes)
+			}
+		}
+		if !f(e) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// countInterval returns the interval the log lines are counted by. The windows of all the evaluations are made up
+// of intervals if it is gcd(step, range), but a small gcd such as 1s makes too many rows, then the interval is
+// coarsened to a divisor of the range, only the ends of the windows are approximated.
+func countInterval(start, end, step, rangeSeconds, maxBuckets int64) (int64, error) {
+	interval := gcd(step, rangeSeconds)
+	for d := interval; d <= rangeSeconds; d += interval {
+		if rangeSeconds%d == 0 && (end-start+rangeSeconds)/d+1 <= maxBuckets {
+			return d, nil
+		}
+	}
+	return 0, badData("too many intervals to evaluate the range %ds in [%d, %d], please narrow the time range", rangeSeconds, start, end)
+}
+
+func (s *LokiService) queryMetric(args *model.LokiQueryParams, expr Expr, start, end, step int64) ([]*series, error) {
+	var aggs []*VectorAggregation
+	for {
+		agg, ok := expr.(*VectorAggregation)
+		if !ok {
+			break
+		}
+		aggs = append(aggs, agg)
+		expr = agg.Inner
+	}
+	rangeAgg := expr.(*RangeAggregation)
+	rangeSeconds := int64(rangeAgg.Range / time.Second)
+	if rangeSeconds < 1 {
+		return nil, badData("the range of %s should be at least 1s", rangeAgg.Op)
+	}
+	if points := (end-start)/step + 1; points > int64(config.Cfg.Loki.MaxPoints) {
+		return nil, badData("exceeded maximum resolution of %d points per time series, try increasing the value of the step parameter", config.Cfg.Loki.MaxPoints)
+	}
+
+	interval, err := countInterval(start, end, step, rangeSeconds, int64(config.Cfg.Loki.MaxPoints))
+	if err != nil {
+		return nil, err
+	}
+	set := rangeSeriesSet{}
+	query := rangeAgg.Query
+	if !needPipeline(query) {
+		labels := streamLabels(query)
+		if len(aggs) == 1 && aggs[0].Op == "sum" && !aggs[0].Without {
+			// the result of sum is the same whether grouped by all the labels or not
+			labels = aggs[0].Grouping
+		}
+		limit := config.Cfg.Loki.MaxSeries * int((end-start+rangeSeconds)/interval+1)
+		sql := buildCountSQL(query, start-rangeSeconds, end, interval, labels, limit)
+		result, err := s.execute(args.Context, sql, args.OrgID, args.Debug)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range result.Values {
+			row, ok := value.([]interface{})
+			if !ok || len(row) != len(labels)+2 {
+				continue
+			}
+			bucket, _ := toInt64(row[0])
+			count, _ := toFloat64(row[len(row)-1])
+			rowLabels := map[string]string{}
+			for i, label := range labels {
+				if v := toString(row[i+1]); v != "" {
+					if label == LABEL_LEVEL {
+						v = strings.ToLower(v)
+					}
+					rowLabels[label] = v
+				}
+			}
+			set.add(rowLabels, bucket, count)
+		}
+	} else {
+		maxLines := config.Cfg.Loki.MaxQueryLines
+		sql := buildLogSQL(query, start-rangeSeconds, end, true, maxLines+1)
+		result, err := s.execute(args.Context, sql, args.OrgID, args.Debug)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Values) > maxLines {
+			return nil, badData("too many log lines (> %d) to evaluate the pipeline, please narrow the time range or the stream selector", maxLines)
+		}
+		err = forEachEntry(result, func(e *logEntry) bool {
+			if runPipeline(e, query.Stages) {
+				seconds := e.timestamp / 1000000
+				set.add(e.labels, seconds-seconds%interval, 1)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := evalRange(rangeAgg, set, start, end, step)
+	for i := len(aggs) - 1; i >= 0; i-- {
+		result = evalVector(aggs[i], result)
+	}
+	if len(result) > config.Cfg.Loki.MaxSeries {
+		return nil, badData("maximum of series (%d) reached for a single query", config.Cfg.Loki.MaxSeries)
+	}
+	return result, nil
+}
+
+// Labels returns the label names, which are the stream labels and the attribute names
+func (s *LokiService) Labels(args *model.LokiMetaParams) ([]string, error) {
+	labels := append([]string{}, defaultStreamLabels...)
+	result, err := s.execute(args.Context, "show tags from "+LOG_TABLE, args.OrgID, false)
+	if err != nil {
+		return nil, err
+	}
+	nameIndex := -1
+	for i, column := range result.Columns {
+		if column == "name" {
+			nameIndex = i
+		}
+	}
+	if nameIndex < 0 {
+		return labels, nil
+	}
+	for _, value := range result.Values {
+		row, ok := value.([]interface{})
+		if !ok {
+			continue
+		}
+		name := toString(row[nameIndex])
+		if !strings.HasPrefix(name, ATTRIBUTE_PREFIX) {
+			continue
+		}
+		name = strings.TrimPrefix(name, ATTRIBUTE_PREFIX)
+		if validLabelName.MatchString(name) && !contains(labels, name) {
+			labels = append(labels, name)
+		}
+	}
+	sort.Strings(labels)
+	return labels, nil
+}
+
+func (s *LokiService) LabelValues(args *model.LokiMetaParams) ([]string, error) {
+	if !validLabelName.MatchString(args.LabelName) {
+		return nil, badData("invalid label name '%s'", args.LabelName)
+	}
+	start, end, err := s.parseTimeRange(args.StartTime, args.EndTime, DEFAULT_META_LOOKBACK)
+	if err != nil {
+		return nil, err
+	}
+	query := &LogQuery{}
+	if args.Query != "" {
+		if query, err = parseSelector(args.Query); err != nil {
+			return nil, err
+		}
+	}
+	sql := fmt.Sprintf("SELECT %s AS `value` FROM %s WHERE %s GROUP BY `value` LIMIT %d",
+		labelToTag(args.LabelName), LOG_TABLE, whereClause(query, start.Unix(), end.Unix()), config.Cfg.Loki.MaxEntriesLimit)
+	result, err := s.execute(args.Context, sql, args.OrgID, false)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(result.Values))
+	for _, value := range result.Values {
+		row, ok := value.([]interface{})
+		if !ok || len(row) == 0 {
+			continue
+		}
+		if v := toString(row[0]); v != "" {
+			if args.LabelName == LABEL_LEVEL {
+				v = strings.ToLower(v)
+			}
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// Series returns the streams matched by any of the selectors
+func (s *LokiService) Series(args *model.LokiMetaParams) ([]map[string]string, error) {
+	if len(args.Matchers) == 0 {
+		return nil, badData("at least one match[] argument must be provided")
+	}
+	start, end, err := s.parseTimeRange(args.StartTime, args.EndTime, DEFAULT_META_LOOKBACK)
+	if err != nil {
+		return nil, err
+	}
+	series := []map[string]string{}
+	seen := map[string]bool{}
+	for _, matcher := range args.Matchers {
+		query, err := parseSelector(matcher)
+		if err != nil {
+			return nil, err
+		}
+		labels := streamLabels(query)
+		groups := make([]string, 0, len(labels))
+		for _, label := range labels {
+			groups = append(groups, "`"+label+"`")
+		}
+		sql := fmt.Sprintf("SELECT %s FROM %s WHERE %s GROUP BY %s LIMIT %d",
+			strings.Join(selectLabels(labels), ", "), LOG_TABLE, whereClause(query, start.Unix(), end.Unix()),
+			strings.Join(groups, ", "), config.Cfg.Loki.MaxSeries)
+		result, err := s.execute(args.Context, sql, args.OrgID, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range result.Values {
+			row, ok := value.([]interface{})
+			if !ok || len(row) != len(labels) {
+				continue
+			}
+			stream := map[string]string{}
+			for i, label := range labels {
+				if v := toString(row[i]); v != "" {
+					if label == LABEL_LEVEL {
+						v = strings.ToLower(v)
+					}
+					stream[label] = v
+				}
+			}
+			if key := labelsKey(stream); !seen[key] {
+				seen[key] = true
+				series = append(series, stream)
+			}
+		}
+	}
+	return series, nil
+}
+
+func (s *LokiService) execute(ctx context.Context, sql, orgID string, debug bool) (*common.Result, error) {
+	args := common.QuerierParams{
+		DB:         LOG_DB,
+		Sql:        sql,
+		DataSource: "",
+		Debug:      strconv.FormatBool(debug),
+		QueryUUID:  uuid.New().String(),
+		Context:    ctx,
+		ORGID:      orgID,
+	}
+	ckEngine := &clickhouse.CHEngine{DB: args.DB, DataSource: args.DataSource}
+	ckEngine.Init()
+	result, debugInfo, err := ckEngine.ExecuteQuery(&args)
+	if err != nil {
+		log.Errorf("ExecuteQuery failed, sql = %s, debug info = %v, err info = %v", sql, debugInfo, err)
+		return nil, err
+	}
+	if debug {
+		log.Infof("loki query debug info: %v", debugInfo)
+	}
+	return result, nil
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	case time.Time:
+		return v.Unix(), true
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	}
+	i, ok := toInt64(v)
+	return float64(i), ok
+}
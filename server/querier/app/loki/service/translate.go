@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	LOG_DB    = "application_log"
+	LOG_TABLE = "log"
+
+	LABEL_LEVEL        = "level"
+	LABEL_SERVICE_NAME = "service_name"
+	LABEL_ERROR        = "__error__"
+
+	ATTRIBUTE_PREFIX = "attribute."
+)
+
+// defaultStreamLabels are the labels of each stream returned by log queries, other labels
+// are returned only when they are referenced by the stream selector
+var defaultStreamLabels = []string{
+	LABEL_SERVICE_NAME, "pod", "pod_ns", "pod_cluster", "pod_node", "host", "chost", LABEL_LEVEL,
+}
+
+// labelTags are the labels that are mapped to the tags of application_log, the others are
+// mapped to attributes
+var labelTags = map[string]string{
+	LABEL_SERVICE_NAME: "app_service",
+	LABEL_LEVEL:        "Enum(severity_number)",
+	"app_service":      "app_service",
+	"app_instance":     "app_instance",
+	"pod":              "pod",
+	"pod_ns":           "pod_ns",
+	"pod_cluster":      "pod_cluster",
+	"pod_node":         "pod_node",
+	"pod_service":      "pod_service",
+	"pod_group":        "pod_group",
+	"host":             "host",
+	"chost":            "chost",
+	"vpc":              "vpc",
+	"region":           "region",
+	"az":               "az",
+	"subnet":           "subnet",
+	"auto_instance":    "auto_instance",
+	"auto_service":     "auto_service",
+	"gprocess":         "gprocess",
+	"agent":            "agent",
+	"trace_id":         "trace_id",
+	"span_id":          "span_id",
+}
+
+// the values of Enum(severity_number), Loki clients use lowercase levels
+var levelAliases = map[string]string{
+	"warning":  "WARN",
+	"fatal":    "FATEL",
+	"critical": "FATEL",
+	"crit":     "FATEL",
+	"err":      "ERROR",
+	"dbug":     "DEBUG",
+	"trce":     "TRACE",
+}
+
+var validLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func labelToTag(label string) string {
+	if tag, ok := labelTags[label]; ok {
+		return tag
+	}
+	return ATTRIBUTE_PREFIX + label
+}
+
+func levelToEnum(level string) string {
+	if enum, ok := levelAliases[strings.ToLower(level)]; ok {
+		return enum
+	}
+	return strings.ToUpper(level)
+}
+
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
+
+func matcherToFilter(m *LabelMatcher) string {
+	tag := labelToTag(m.Name)
+	value := m.Value
+	switch m.Type {
+	case MatchEqual, MatchNotEqual:
+		if m.Name == LABEL_LEVEL {
+			value = levelToEnum(value)
+		}
+		return fmt.Sprintf("%s %s %s", tag, m.Type, quote(value))
+	default:
+		// LogQL regexps are fully anchored
+		value = "^(?:" + value + ")$"
+		if m.Name == LABEL_LEVEL {
+			value = "(?i)" + value
+		}
+		op := "REGEXP"
+		if m.Type == MatchNotRegexp {
+			op = "NOT REGEXP"
+		}
+		return fmt.Sprintf("%s %s %s", tag, op, quote(value))
+	}
+}
+
+func lineFilterToFilter(f *LineFilter) string {
+	switch f.Type {
+	case MatchEqual:
+		return "body REGEXP " + quote(regexp.QuoteMeta(f.Value))
+	case MatchNotEqual:
+		return "body NOT REGEXP " + quote(regexp.QuoteMeta(f.Value))
+	case MatchRegexp:
+		return "body REGEXP " + quote(f.Value)
+	default:
+		return "body NOT REGEXP " + quote(f.Value)
+	}
+}
+
+// whereClause translates the stream selector and the line filters, the time range is in seconds
+func whereClause(query *LogQuery, start, end int64) string {
+	filters := []string{fmt.Sprintf("time>=%d", start), fmt.Sprintf("time<=%d", end)}
+	for _, m := range query.Matchers {
+		filters = append(filters, matcherToFilter(m))
+	}
+	for _, f := range query.LineFilters {
+		filters = append(filters, lineFilterToFilter(f))
+	}
+	return strings.Join(filters, " AND ")
+}
+
+// streamLabels returns the labels that identify the streams of the query
+func streamLabels(query *LogQuery) []string {
+	labels := append([]string{}, defaultStreamLabels...)
+	for _, m := range query.Matchers {
+		// app_service is the same as service_name
+		if m.Name != "app_service" && !contains(labels, m.Name) {
+			labels = append(labels, m.Name)
+		}
+	}
+	return labels
+}
+
+func selectLabels(labels []string) []string {
+	selects := make([]string, 0, len(labels))
+	for _, label := range labels {
+		selects = append(selects, fmt.Sprintf("%s AS `%s`", labelToTag(label), label))
+	}
+	return selects
+}
+
+// buildLogSQL selects the log lines with the stream labels and attributes
+func buildLogSQL(query *LogQuery, start, end int64, forward bool, limit int) string {
+	selects := []string{"toUnixTimestamp64Micro(timestamp) AS `ts`", "body"}
+	selects = append(selects, selectLabels(streamLabels(query))...)
+	selects = append(selects, "attribute")
+	order := "DESC"
+	if forward {
+		order = "ASC"
+	}
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY `ts` %s LIMIT %d",
+		strings.Join(selects, ", "), LOG_TABLE, whereClause(query, start, end), order, limit)
+}
+
+// buildCountSQL counts the log lines of each interval grouped by the labels, it's used
+// by the metric queries which have no parsers or label filters
+func buildCountSQL(query *LogQuery, start, end, interval int64, labels []string, limit int) string {
+	selects := []string{fmt.Sprintf("time(time, %d) AS `bucket`", interval)}
+	selects = append(selects, selectLabels(labels)...)
+	selects = append(selects, "Count(row) AS `count`")
+	groups := []string{"`bucket`"}
+	for _, label := range labels {
+		groups = append(groups, "`"+label+"`")
+	}
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s GROUP BY %s LIMIT %d",
+		strings.Join(selects, ", "), LOG_TABLE, whereClause(query, start, end), strings.Join(groups, ", "), limit)
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
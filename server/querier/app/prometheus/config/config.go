@@ -28,6 +28,7 @@ type Prometheus struct {
 	ThanosReplicaLabels     []string        `yaml:"thanos-replica-labels"`
 	OperatorOffloading      bool            `default:"false" yaml:"operator-offloading"`
 	Cache                   PrometheusCache `yaml:"cache"`
+	RecordingRules          RecordingRules  `yaml:"recording-rules"`
 }
 
 type PrometheusCache struct {
@@ -39,3 +40,27 @@ type PrometheusCache struct {
 	CacheCleanInterval int    `default:"3600" yaml:"cache-clean-interval"` // clean interval for cache, unit: s, default: 1h
 	CacheAllowTimeGap  int    `default:"1" yaml:"cache-allow-time-gap"`    // when query end time - cache end time <= allow gap: not update cache, unit: s, default: 1s
 }
+
+type RecordingRules struct {
+	Enabled            bool                 `default:"false" yaml:"enabled"`
+	RemoteWriteURL     string               `default:"http://127.0.0.1:20046/api/v1/write" yaml:"remote-write-url"` // remote write url to write the results, the org/team are sent in headers
+	EvaluationInterval int                  `default:"60" yaml:"evaluation-interval"`                               // default interval of groups, unit: s
+	SyncInterval       int                  `default:"60" yaml:"sync-interval"`                                     // interval to reload groups from controller, unit: s
+	Groups             []RecordingRuleGroup `yaml:"groups"`
+}
+
+type RecordingRuleGroup struct {
+	Name           string          `yaml:"name"`
+	OrgID          string          `yaml:"org-id"`           // empty means the default org
+	TeamID         string          `yaml:"team-id"`          // empty means the default team
+	Interval       int             `yaml:"interval"`         // unit: s, 0 means evaluation-interval
+	QueryOffset    int             `yaml:"query-offset"`     // evaluate at this offset before now to wait for late data, unit: s
+	RemoteWriteURL string          `yaml:"remote-write-url"` // empty means remote-write-url of recording-rules
+	Rules          []RecordingRule `yaml:"rules"`
+}
+
+type RecordingRule struct {
+	Record string            `yaml:"record" json:"RECORD"`
+	Expr   string            `yaml:"expr" json:"EXPR"`
+	Labels map[string]string `yaml:"labels" json:"LABELS"`
+}
@@ -18,7 +18,10 @@ package router
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -268,6 +271,35 @@ func promQLAddFilters(svc *service.PrometheusService) gin.HandlerFunc {
 	})
 }
 
+// Recording Rules API, compatible with prometheus `/api/v1/rules`
+// requests to the querier which is not leader are forwarded to the leader
+func promRecordingRules(m *service.RecordingRuleManager) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		orgID := c.Request.Header.Get(common.HEADER_KEY_X_ORG_ID)
+		isLeader, leaderIP, err := m.IsLeader()
+		if config.Cfg.Prometheus.RecordingRules.Enabled && err == nil && !isLeader && c.Query("local") != "true" {
+			url := fmt.Sprintf("http://%s/prom/api/v1/rules?local=true", net.JoinHostPort(leaderIP, strconv.Itoa(config.Cfg.ListenPort)))
+			req, err := http.NewRequestWithContext(c.Request.Context(), "GET", url, nil)
+			if err == nil {
+				req.Header.Set(common.HEADER_KEY_X_ORG_ID, orgID)
+				var resp *http.Response
+				if resp, err = http.DefaultClient.Do(req); err == nil {
+					defer resp.Body.Close()
+					c.DataFromReader(resp.StatusCode, resp.ContentLength, resp.Header.Get("Content-Type"), resp.Body, nil)
+					return
+				}
+			}
+			code, obj := handleError(fmt.Errorf("forward to leader querier (%s) failed: %s", leaderIP, err))
+			c.JSON(code, obj)
+			return
+		}
+		c.JSON(200, &model.PromQueryResponse{
+			Status: _STATUS_SUCCESS,
+			Data:   map[string]interface{}{"groups": m.Status(orgID)},
+		})
+	})
+}
+
 // handle special errors
 // only for `RESOURCE_NOT_FOUND` error, it means query non-existence metrics, it should return 200 with empty result
 // but in querier, it will still cause a `RESOURCE_NOT_FOUND` to log error
@@ -52,6 +52,11 @@ func PrometheusRouter(e *gin.Engine) {
 		}
 	}
 
+	// recording rules are evaluated by the leader querier
+	recordingRuleManager := service.NewRecordingRuleManager(prometheusService, &config.Cfg.Prometheus.RecordingRules)
+	recordingRuleManager.Start()
+	e.GET("/prom/api/v1/rules", promRecordingRules(recordingRuleManager))
+
 	// not using rate-limit, cause it's low-frequency of request-calling
 	e.GET("/prom/api/v1/analysis", promQLAnalysis(prometheusService))
 	e.GET("/prom/api/v1/parse", promQLParse(prometheusService))
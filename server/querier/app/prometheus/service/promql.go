@@ -38,7 +38,6 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 
-	"github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/libs/lru"
 	"github.com/deepflowio/deepflow/server/querier/app/prometheus/cache"
 	"github.com/deepflowio/deepflow/server/querier/app/prometheus/model"
@@ -848,19 +847,12 @@ func (p *prometheusExecutor) beforePrometheusCalculate(q promql.Query, f func(e
 }
 
 func (p *prometheusExecutor) getAllOrganizations() []string {
-	getOrgUrl := fmt.Sprintf("http://localhost:%d/v1/orgs/", config.ControllerCfg.ListenPort)
-	resp, err := common.CURLPerform("GET", getOrgUrl, nil)
+	orgIDs, err := chCommon.GetOrgIDs()
 	if err != nil {
-		log.Errorf("request controller failed: %s, URL: %s", resp, getOrgUrl)
+		log.Error(err)
 		return nil
 	}
-	resultArray := resp.Get("DATA").MustArray()
-	result := make([]string, 0, len(resultArray))
-	for i := range resultArray {
-		orgIDInt := resp.Get("DATA").GetIndex(i).Get("ORG_ID").MustInt()
-		result = append(result, strconv.Itoa(orgIDInt))
-	}
-	return result
+	return orgIDs
 }
 
 func (p *prometheusExecutor) loadExtraLabelsCache(orgID string) {
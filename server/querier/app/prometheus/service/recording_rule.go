@@ -0,0 +1,640 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	prommodel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	ctrlcommon "github.com/deepflowio/deepflow/server/controller/common"
+	"github.com/deepflowio/deepflow/server/controller/election"
+	"github.com/deepflowio/deepflow/server/libs/stats"
+	promconfig "github.com/deepflowio/deepflow/server/querier/app/prometheus/config"
+	"github.com/deepflowio/deepflow/server/querier/app/prometheus/model"
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/config"
+	"github.com/deepflowio/deepflow/server/querier/statsd"
+)
+
+const (
+	RECORDING_RULE_SOURCE_CONFIG = "config"
+	RECORDING_RULE_SOURCE_API    = "api"
+
+	RECORDING_RULE_HEALTH_UNKNOWN = "unknown"
+	RECORDING_RULE_HEALTH_OK      = "ok"
+	RECORDING_RULE_HEALTH_ERR     = "err"
+
+	remoteWriteTimeout = 30 * time.Second
+)
+
+// RecordingRuleManager evaluates recording rule groups on the leader querier,
+// the groups are loaded from config and from the controller api of each org.
+type RecordingRuleManager struct {
+	service *PrometheusService
+	cfg     *promconfig.RecordingRules
+
+	mutex  sync.RWMutex
+	groups map[string]*recordingRuleGroup // key is recordingRuleGroup.key()
+}
+
+func NewRecordingRuleManager(s *PrometheusService, cfg *promconfig.RecordingRules) *RecordingRuleManager {
+	return &RecordingRuleManager{
+		service: s,
+		cfg:     cfg,
+		groups:  make(map[string]*recordingRuleGroup),
+	}
+}
+
+func (m *RecordingRuleManager) Start() {
+	if !m.cfg.Enabled {
+		return
+	}
+	log.Infof("start recording rule manager, %d groups in config", len(m.cfg.Groups))
+	go func() {
+		ticker := time.NewTicker(time.Duration(m.cfg.SyncInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			m.sync()
+			<-ticker.C
+		}
+	}()
+}
+
+// sync starts, updates or stops groups, only the leader querier evaluates rules
+func (m *RecordingRuleManager) sync() {
+	isLeader, err := election.IsMasterController()
+	if err != nil || !isLeader {
+		m.reconcile(nil)
+		return
+	}
+	m.reconcile(m.loadGroups())
+}
+
+func (m *RecordingRuleManager) loadGroups() map[string]*recordingRuleGroup {
+	groups := make(map[string]*recordingRuleGroup)
+	for _, c := range m.cfg.Groups {
+		g, err := m.newGroup(RECORDING_RULE_SOURCE_CONFIG, c)
+		if err != nil {
+			log.Errorf("load recording rule group (%s) from config failed: %s", c.Name, err)
+			continue
+		}
+		groups[g.key()] = g
+	}
+
+	orgIDs := m.service.executor.getAllOrganizations()
+	if len(orgIDs) == 0 {
+		orgIDs = []string{common.DEFAULT_ORG_ID}
+	}
+	for _, orgID := range orgIDs {
+		configs, err := getRecordingRuleGroupsFromController(orgID)
+		if err != nil {
+			// keep the running groups of the org to avoid flapping
+			log.Warningf("load recording rule groups of org (%s) from controller failed: %s", orgID, err)
+			m.mutex.RLock()
+			for k, g := range m.groups {
+				if g.source == RECORDING_RULE_SOURCE_API && g.orgID == orgID {
+					groups[k] = g
+				}
+			}
+			m.mutex.RUnlock()
+			continue
+		}
+		for _, c := range configs {
+			g, err := m.newGroup(RECORDING_RULE_SOURCE_API, c)
+			if err != nil {
+				log.Errorf("load recording rule group (%s) of org (%s) failed: %s", c.Name, orgID, err)
+				continue
+			}
+			groups[g.key()] = g
+		}
+	}
+	return groups
+}
+
+func getRecordingRuleGroupsFromController(orgID string) ([]promconfig.RecordingRuleGroup, error) {
+	url := fmt.Sprintf("http://localhost:%d/v1/prometheus-recording-rule-groups/", config.ControllerCfg.ListenPort)
+	resp, err := ctrlcommon.CURLPerform("GET", url, nil, ctrlcommon.WithORGHeader(orgID))
+	if err != nil {
+		return nil, err
+	}
+	data := resp.Get("DATA")
+	groups := make([]promconfig.RecordingRuleGroup, 0, len(data.MustArray()))
+	for i := range data.MustArray() {
+		g := data.GetIndex(i)
+		group := promconfig.RecordingRuleGroup{
+			Name:           g.Get("NAME").MustString(),
+			OrgID:          orgID,
+			Interval:       g.Get("EVAL_INTERVAL").MustInt(),
+			QueryOffset:    g.Get("QUERY_OFFSET").MustInt(),
+			RemoteWriteURL: g.Get("REMOTE_WRITE_URL").MustString(),
+		}
+		rules, err := g.Get("RULES").MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(rules, &group.Rules); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func (m *RecordingRuleManager) newGroup(source string, c promconfig.RecordingRuleGroup) (*recordingRuleGroup, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("name of group is empty")
+	}
+	g := &recordingRuleGroup{
+		manager:        m,
+		source:         source,
+		name:           c.Name,
+		orgID:          c.OrgID,
+		teamID:         c.TeamID,
+		interval:       time.Duration(c.Interval) * time.Second,
+		queryOffset:    time.Duration(c.QueryOffset) * time.Second,
+		remoteWriteURL: c.RemoteWriteURL,
+	}
+	if g.orgID == "" {
+		g.orgID = common.DEFAULT_ORG_ID
+	}
+	if g.teamID == "" {
+		g.teamID = common.DEFAULT_TEAM_ID
+	}
+	if g.interval <= 0 {
+		g.interval = time.Duration(m.cfg.EvaluationInterval) * time.Second
+	}
+	if g.remoteWriteURL == "" {
+		g.remoteWriteURL = m.cfg.RemoteWriteURL
+	}
+	for _, rc := range c.Rules {
+		if !prommodel.IsValidMetricName(prommodel.LabelValue(rc.Record)) {
+			return nil, fmt.Errorf("invalid metric name (%s)", rc.Record)
+		}
+		if _, err := parser.ParseExpr(rc.Expr); err != nil {
+			return nil, fmt.Errorf("invalid expr of rule (%s): %s", rc.Record, err)
+		}
+		g.rules = append(g.rules, &recordingRule{
+			record:  rc.Record,
+			expr:    rc.Expr,
+			labels:  labels.FromMap(rc.Labels),
+			health:  RECORDING_RULE_HEALTH_UNKNOWN,
+			counter: &RecordingRuleCounter{},
+		})
+	}
+	if len(g.rules) == 0 {
+		return nil, fmt.Errorf("no rules in group")
+	}
+	definition, _ := json.Marshal(c)
+	g.definition = string(definition)
+	return g, nil
+}
+
+// reconcile stops the groups not desired or changed, and starts the new ones
+func (m *RecordingRuleManager) reconcile(desired map[string]*recordingRuleGroup) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for k, g := range m.groups {
+		if d, ok := desired[k]; ok && (d == g || d.definition == g.definition) {
+			desired[k] = g
+			continue
+		}
+		g.stop()
+		delete(m.groups, k)
+	}
+	for k, g := range desired {
+		if _, ok := m.groups[k]; ok {
+			continue
+		}
+		m.groups[k] = g
+		g.start()
+	}
+}
+
+type RecordingRuleCounter struct {
+	EvalCount    uint64 `statsd:"eval_count"`
+	EvalFailed   uint64 `statsd:"eval_failed"`
+	WriteFailed  uint64 `statsd:"write_failed"`
+	Samples      uint64 `statsd:"samples"`
+	StaleSamples uint64 `statsd:"stale_samples"`
+	EvalTimeMax  uint64 `statsd:"eval_time_max"` // unit: ns
+}
+
+type recordingRule struct {
+	record string
+	expr   string
+	labels labels.Labels
+
+	// evaluation status, guarded by lock of group
+	health         string
+	lastError      string
+	lastEvaluation time.Time
+	evaluationTime time.Duration
+	lastSamples    int
+	// series written in the last evaluation, stale markers are written for the disappeared ones.
+	// nil until loaded from the stored series at the first evaluation, as the group may be
+	// restarted by reconcile or started on a new leader
+	lastSeen map[uint64]labels.Labels
+
+	counter *RecordingRuleCounter
+	closed  int32
+}
+
+func (r *recordingRule) GetCounter() interface{} {
+	counter := &RecordingRuleCounter{}
+	counter.EvalCount = atomic.SwapUint64(&r.counter.EvalCount, 0)
+	counter.EvalFailed = atomic.SwapUint64(&r.counter.EvalFailed, 0)
+	counter.WriteFailed = atomic.SwapUint64(&r.counter.WriteFailed, 0)
+	counter.Samples = atomic.SwapUint64(&r.counter.Samples, 0)
+	counter.StaleSamples = atomic.SwapUint64(&r.counter.StaleSamples, 0)
+	counter.EvalTimeMax = atomic.SwapUint64(&r.counter.EvalTimeMax, 0)
+	return counter
+}
+
+func (r *recordingRule) Closed() bool {
+	return atomic.LoadInt32(&r.closed) == 1
+}
+
+type recordingRuleGroup struct {
+	manager        *RecordingRuleManager
+	source         string
+	name           string
+	orgID          string
+	teamID         string
+	interval       time.Duration
+	queryOffset    time.Duration
+	remoteWriteURL string
+	rules          []*recordingRule
+	definition     string
+
+	mutex            sync.RWMutex
+	lastEvaluation   time.Time
+	evaluationTime   time.Duration
+	missedIterations uint64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (g *recordingRuleGroup) key() string {
+	return fmt.Sprintf("%s/%s/%s", g.orgID, g.source, g.name)
+}
+
+func (g *recordingRuleGroup) start() {
+	log.Infof("start recording rule group (%s) of org (%s) from %s, interval: %s", g.name, g.orgID, g.source, g.interval)
+	for _, r := range g.rules {
+		statsd.RegisterCountableForIngester("recording_rule", r, stats.OptionStatTags{
+			"org_id": g.orgID,
+			"group":  g.name,
+			"record": r.record,
+		})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancel = cancel
+	g.done = make(chan struct{})
+	go g.run(ctx)
+}
+
+func (g *recordingRuleGroup) stop() {
+	log.Infof("stop recording rule group (%s) of org (%s) from %s", g.name, g.orgID, g.source)
+	g.cancel()
+	<-g.done
+	for _, r := range g.rules {
+		atomic.StoreInt32(&r.closed, 1)
+	}
+}
+
+// run evaluates the group at the aligned time of each interval, iterations missed
+// because of slow evaluations are skipped rather than caught up
+func (g *recordingRuleGroup) run(ctx context.Context) {
+	defer close(g.done)
+	next := time.Now().Truncate(g.interval).Add(g.interval)
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		g.eval(ctx, next)
+
+		now := time.Now()
+		next = next.Add(g.interval)
+		if missed := now.Sub(next); missed >= 0 {
+			skipped := uint64(missed/g.interval) + 1
+			g.mutex.Lock()
+			g.missedIterations += skipped
+			g.mutex.Unlock()
+			log.Warningf("recording rule group (%s) of org (%s) missed %d iterations", g.name, g.orgID, skipped)
+			next = next.Add(time.Duration(skipped) * g.interval)
+		}
+	}
+}
+
+func (g *recordingRuleGroup) eval(ctx context.Context, ts time.Time) {
+	start := time.Now()
+	evalTime := ts.Add(-g.queryOffset)
+	for _, r := range g.rules {
+		if ctx.Err() != nil {
+			return
+		}
+		g.evalRule(ctx, r, evalTime)
+	}
+	g.mutex.Lock()
+	g.lastEvaluation = start
+	g.evaluationTime = time.Since(start)
+	g.mutex.Unlock()
+}
+
+func (g *recordingRuleGroup) evalRule(ctx context.Context, r *recordingRule, evalTime time.Time) {
+	start := time.Now()
+	atomic.AddUint64(&r.counter.EvalCount, 1)
+
+	ctx, cancel := context.WithTimeout(ctx, g.interval)
+	defer cancel()
+	timestamp := strconv.FormatInt(evalTime.Unix(), 10)
+	result, err := g.manager.service.PromInstantQueryService(&model.PromQueryParams{
+		Promql:    r.expr,
+		StartTime: timestamp,
+		EndTime:   timestamp,
+		OrgID:     g.orgID,
+		Context:   ctx,
+	}, ctx)
+	var serviceErr *common.ServiceError
+	if errors.As(err, &serviceErr) && serviceErr.Status == common.RESOURCE_NOT_FOUND {
+		// metrics not exist, same as empty result
+		result, err = &model.PromQueryResponse{}, nil
+	}
+	var series []prompb.TimeSeries
+	var stale int
+	if err == nil {
+		g.loadLastSeen(ctx, r, evalTime)
+		series, stale, err = g.buildSeries(r, result, evalTime)
+	}
+	if err != nil {
+		atomic.AddUint64(&r.counter.EvalFailed, 1)
+	} else if len(series) > 0 {
+		if err = writeRemote(ctx, g.remoteWriteURL, g.orgID, g.teamID, series); err != nil {
+			atomic.AddUint64(&r.counter.WriteFailed, 1)
+			err = fmt.Errorf("remote write failed: %s", err)
+		} else {
+			atomic.AddUint64(&r.counter.Samples, uint64(len(series)-stale))
+			atomic.AddUint64(&r.counter.StaleSamples, uint64(stale))
+		}
+	}
+
+	cost := time.Since(start)
+	for {
+		max := atomic.LoadUint64(&r.counter.EvalTimeMax)
+		if uint64(cost) <= max || atomic.CompareAndSwapUint64(&r.counter.EvalTimeMax, max, uint64(cost)) {
+			break
+		}
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	r.lastEvaluation = start
+	r.evaluationTime = cost
+	r.lastSamples = len(series) - stale
+	if err != nil {
+		log.Warningf("evaluate recording rule (%s) of group (%s) org (%s) failed: %s", r.record, g.name, g.orgID, err)
+		r.health = RECORDING_RULE_HEALTH_ERR
+		r.lastError = err.Error()
+	} else {
+		r.health = RECORDING_RULE_HEALTH_OK
+		r.lastError = ""
+	}
+}
+
+// loadLastSeen rebuilds the series written before the group started by querying the record,
+// so that stale markers are still written for them
+func (g *recordingRuleGroup) loadLastSeen(ctx context.Context, r *recordingRule, evalTime time.Time) {
+	g.mutex.RLock()
+	loaded := r.lastSeen != nil
+	g.mutex.RUnlock()
+	if loaded {
+		return
+	}
+
+	matchers := []string{fmt.Sprintf("%s=%s", labels.MetricName, strconv.Quote(r.record))}
+	for _, l := range r.labels {
+		matchers = append(matchers, fmt.Sprintf("%s=%s", l.Name, strconv.Quote(l.Value)))
+	}
+	timestamp := strconv.FormatInt(evalTime.Add(-g.interval).Unix(), 10)
+	result, err := g.manager.service.PromInstantQueryService(&model.PromQueryParams{
+		Promql:    fmt.Sprintf("last_over_time({%s}[%ds])", strings.Join(matchers, ","), int64(g.interval.Seconds())),
+		StartTime: timestamp,
+		EndTime:   timestamp,
+		OrgID:     g.orgID,
+		Context:   ctx,
+	}, ctx)
+	lastSeen := map[uint64]labels.Labels{}
+	if err != nil {
+		var serviceErr *common.ServiceError
+		if !errors.As(err, &serviceErr) || serviceErr.Status != common.RESOURCE_NOT_FOUND {
+			log.Warningf("load last series of recording rule (%s) of group (%s) org (%s) failed: %s", r.record, g.name, g.orgID, err)
+		}
+	} else if data, ok := result.Data.(*model.PromQueryData); ok {
+		if vector, ok := data.Result.(promql.Vector); ok {
+			for _, sample := range vector {
+				lset := labels.NewBuilder(sample.Metric).Set(labels.MetricName, r.record).Labels()
+				lastSeen[lset.Hash()] = lset
+			}
+		}
+	}
+	g.mutex.Lock()
+	r.lastSeen = lastSeen
+	g.mutex.Unlock()
+}
+
+// buildSeries converts the result of the rule into series named by the record, and adds stale
+// markers for the series which disappeared since the last evaluation
+func (g *recordingRuleGroup) buildSeries(r *recordingRule, result *model.PromQueryResponse, evalTime time.Time) ([]prompb.TimeSeries, int, error) {
+	var vector promql.Vector
+	if data, ok := result.Data.(*model.PromQueryData); ok {
+		switch v := data.Result.(type) {
+		case promql.Vector:
+			vector = v
+		case promql.Scalar:
+			vector = promql.Vector{promql.Sample{Point: promql.Point{T: v.T, V: v.V}}}
+		default:
+			return nil, 0, fmt.Errorf("result type (%s) of rule is neither vector nor scalar", data.ResultType)
+		}
+	}
+
+	timestamp := evalTime.UnixMilli()
+	seen := make(map[uint64]labels.Labels, len(vector))
+	series := make([]prompb.TimeSeries, 0, len(vector))
+	for _, sample := range vector {
+		lb := labels.NewBuilder(sample.Metric).Set(labels.MetricName, r.record)
+		for _, l := range r.labels {
+			lb.Set(l.Name, l.Value)
+		}
+		lset := lb.Labels()
+		hash := lset.Hash()
+		if _, ok := seen[hash]; ok {
+			return nil, 0, fmt.Errorf("vector contains metrics with the same labelset after applying rule labels")
+		}
+		seen[hash] = lset
+		series = append(series, toTimeSeries(lset, sample.V, timestamp))
+	}
+
+	g.mutex.Lock()
+	lastSeen := r.lastSeen
+	r.lastSeen = seen
+	g.mutex.Unlock()
+	stale := 0
+	for hash, lset := range lastSeen {
+		if _, ok := seen[hash]; !ok {
+			series = append(series, toTimeSeries(lset, math.Float64frombits(value.StaleNaN), timestamp))
+			stale++
+		}
+	}
+	return series, stale, nil
+}
+
+func toTimeSeries(lset labels.Labels, v float64, timestamp int64) prompb.TimeSeries {
+	ts := prompb.TimeSeries{
+		Labels:  make([]prompb.Label, 0, len(lset)),
+		Samples: []prompb.Sample{{Value: v, Timestamp: timestamp}},
+	}
+	for _, l := range lset {
+		ts.Labels = append(ts.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+	}
+	return ts
+}
+
+func writeRemote(ctx context.Context, url, orgID, teamID string, series []prompb.TimeSeries) error {
+	data, err := (&prompb.WriteRequest{Timeseries: series}).Marshal()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set(common.HEADER_KEY_X_ORG_ID, orgID)
+	req.Header.Set(common.HEADER_KEY_X_TEAM_ID, teamID)
+	client := &http.Client{Timeout: remoteWriteTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// RecordingRuleGroupStatus is compatible with the group of prometheus `/api/v1/rules`
+type RecordingRuleGroupStatus struct {
+	Name             string                `json:"name"`
+	File             string                `json:"file"`
+	Rules            []RecordingRuleStatus `json:"rules"`
+	Interval         float64               `json:"interval"`
+	QueryOffset      float64               `json:"queryOffset"`
+	EvaluationTime   float64               `json:"evaluationTime"`
+	LastEvaluation   time.Time             `json:"lastEvaluation"`
+	MissedIterations uint64                `json:"missedIterations"`
+}
+
+type RecordingRuleStatus struct {
+	Name           string            `json:"name"`
+	Query          string            `json:"query"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Health         string            `json:"health"`
+	LastError      string            `json:"lastError,omitempty"`
+	EvaluationTime float64           `json:"evaluationTime"`
+	LastEvaluation time.Time         `json:"lastEvaluation"`
+	LastSamples    int               `json:"lastSamples"`
+	Type           string            `json:"type"`
+}
+
+// Status returns the groups of the org evaluated by this querier
+func (m *RecordingRuleManager) Status(orgID string) []RecordingRuleGroupStatus {
+	if orgID == "" {
+		orgID = common.DEFAULT_ORG_ID
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	result := []RecordingRuleGroupStatus{}
+	for _, g := range m.groups {
+		if g.orgID != orgID {
+			continue
+		}
+		g.mutex.RLock()
+		status := RecordingRuleGroupStatus{
+			Name:             g.name,
+			File:             g.source,
+			Interval:         g.interval.Seconds(),
+			QueryOffset:      g.queryOffset.Seconds(),
+			EvaluationTime:   g.evaluationTime.Seconds(),
+			LastEvaluation:   g.lastEvaluation,
+			MissedIterations: g.missedIterations,
+		}
+		for _, r := range g.rules {
+			status.Rules = append(status.Rules, RecordingRuleStatus{
+				Name:           r.record,
+				Query:          r.expr,
+				Labels:         r.labels.Map(),
+				Health:         r.health,
+				LastError:      r.lastError,
+				EvaluationTime: r.evaluationTime.Seconds(),
+				LastEvaluation: r.lastEvaluation,
+				LastSamples:    r.lastSamples,
+				Type:           "recording",
+			})
+		}
+		g.mutex.RUnlock()
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].File != result[j].File {
+			return result[i].File < result[j].File
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// IsLeader returns whether this querier evaluates the rules, and the ip of the leader
+func (m *RecordingRuleManager) IsLeader() (bool, string, error) {
+	return election.IsMasterControllerAndReturnIP()
+}
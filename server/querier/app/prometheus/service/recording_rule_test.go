@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	promconfig "github.com/deepflowio/deepflow/server/querier/app/prometheus/config"
+	"github.com/deepflowio/deepflow/server/querier/app/prometheus/model"
+	"github.com/deepflowio/deepflow/server/querier/common"
+)
+
+func TestRecordingRuleGroup(t *testing.T) {
+	m := NewRecordingRuleManager(nil, &promconfig.RecordingRules{EvaluationInterval: 60, RemoteWriteURL: "http://localhost/write"})
+	if _, err := m.newGroup(RECORDING_RULE_SOURCE_CONFIG, promconfig.RecordingRuleGroup{
+		Name:  "g",
+		Rules: []promconfig.RecordingRule{{Record: "a-b", Expr: "up"}},
+	}); err == nil {
+		t.Error("invalid metric name accepted")
+	}
+	if _, err := m.newGroup(RECORDING_RULE_SOURCE_CONFIG, promconfig.RecordingRuleGroup{
+		Name:  "g",
+		Rules: []promconfig.RecordingRule{{Record: "job:up:sum", Expr: "sum(up"}},
+	}); err == nil {
+		t.Error("invalid expr accepted")
+	}
+	g, err := m.newGroup(RECORDING_RULE_SOURCE_API, promconfig.RecordingRuleGroup{
+		Name:  "g",
+		OrgID: "2",
+		Rules: []promconfig.RecordingRule{{Record: "job:up:sum", Expr: "sum by (job) (up)", Labels: map[string]string{"team": "a"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.key() != "2/api/g" || g.teamID != common.DEFAULT_TEAM_ID || g.interval != time.Minute || g.remoteWriteURL != "http://localhost/write" {
+		t.Errorf("unexpected group %s %s %s %s", g.key(), g.teamID, g.interval, g.remoteWriteURL)
+	}
+}
+
+func TestRecordingRuleWriteRemote(t *testing.T) {
+	var orgID, teamID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		orgID, teamID = req.Header.Get(common.HEADER_KEY_X_ORG_ID), req.Header.Get(common.HEADER_KEY_X_TEAM_ID)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	series := []prompb.TimeSeries{toTimeSeries(labels.FromStrings(labels.MetricName, "job:up:sum"), 1, 1700000000000)}
+	if err := writeRemote(context.Background(), server.URL, "2", "3", series); err != nil {
+		t.Fatal(err)
+	}
+	if orgID != "2" || teamID != "3" {
+		t.Errorf("org/team headers = %s/%s, want 2/3", orgID, teamID)
+	}
+}
+
+func TestRecordingRuleBuildSeries(t *testing.T) {
+	m := NewRecordingRuleManager(nil, &promconfig.RecordingRules{EvaluationInterval: 60})
+	g, err := m.newGroup(RECORDING_RULE_SOURCE_CONFIG, promconfig.RecordingRuleGroup{
+		Name:  "g",
+		Rules: []promconfig.RecordingRule{{Record: "job:up:sum", Expr: "sum by (job) (up)", Labels: map[string]string{"team": "a"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := g.rules[0]
+	vector := func(jobs ...string) *model.PromQueryResponse {
+		v := promql.Vector{}
+		for _, job := range jobs {
+			v = append(v, promql.Sample{Point: promql.Point{V: 1}, Metric: labels.FromStrings("job", job)})
+		}
+		return &model.PromQueryResponse{Data: &model.PromQueryData{ResultType: parser.ValueTypeVector, Result: v}}
+	}
+	getLabel := func(ts prompb.TimeSeries, name string) string {
+		for _, l := range ts.Labels {
+			if l.Name == name {
+				return l.Value
+			}
+		}
+		return ""
+	}
+
+	evalTime := time.Unix(1700000000, 0)
+	series, stale, err := g.buildSeries(r, vector("a", "b"), evalTime)
+	if err != nil || len(series) != 2 || stale != 0 {
+		t.Fatalf("buildSeries() = %v, %d, %v", series, stale, err)
+	}
+	for _, s := range series {
+		if getLabel(s, labels.MetricName) != "job:up:sum" || getLabel(s, "team") != "a" || s.Samples[0].Timestamp != evalTime.UnixMilli() {
+			t.Errorf("unexpected series %v", s)
+		}
+	}
+
+	series, stale, err = g.buildSeries(r, vector("a"), evalTime.Add(time.Minute))
+	if err != nil || len(series) != 2 || stale != 1 {
+		t.Fatalf("buildSeries() = %v, %d, %v", series, stale, err)
+	}
+	if getLabel(series[1], "job") != "b" || !value.IsStaleNaN(series[1].Samples[0].Value) {
+		t.Errorf("stale marker not written: %v", series[1])
+	}
+
+	scalar := &model.PromQueryResponse{Data: &model.PromQueryData{ResultType: parser.ValueTypeScalar, Result: promql.Scalar{V: 2}}}
+	if series, _, err = g.buildSeries(r, scalar, evalTime); err != nil || len(series) != 2 || series[0].Samples[0].Value != 2 {
+		t.Errorf("buildSeries(scalar) = %v, %v", series, err)
+	}
+
+	r.labels = labels.FromStrings("job", "x")
+	if _, _, err = g.buildSeries(r, vector("a", "b"), evalTime); err == nil {
+		t.Error("duplicated labelset accepted")
+	}
+}
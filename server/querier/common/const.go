@@ -27,6 +27,9 @@ const (
 	SERVER_ERROR                    = "SERVER_ERROR"
 	RESOURCE_NUM_EXCEEDED           = "RESOURCE_NUM_EXCEEDED"
 	SELECTED_RESOURCES_NUM_EXCEEDED = "SELECTED_RESOURCES_NUM_EXCEEDED"
+	QUERY_LIMIT_EXCEEDED            = "QUERY_LIMIT_EXCEEDED"
+	TOO_MANY_QUERIES                = "TOO_MANY_QUERIES"
+	QUERY_KILLED                    = "QUERY_KILLED"
 )
 
 const (
@@ -46,9 +49,12 @@ const (
 )
 
 const (
-	HEADER_KEY_LANGUAGE = "X-Language"
-	HEADER_KEY_X_ORG_ID = "X-Org-Id"
-	DEFAULT_ORG_ID      = "1"
+	HEADER_KEY_LANGUAGE  = "X-Language"
+	HEADER_KEY_X_ORG_ID  = "X-Org-Id"
+	HEADER_KEY_X_TEAM_ID = "X-Team-Id"
+	HEADER_KEY_X_USER_ID = "X-User-Id"
+	DEFAULT_ORG_ID       = "1"
+	DEFAULT_TEAM_ID      = "1"
 )
 
 const NO_LIMIT = "-1"
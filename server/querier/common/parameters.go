@@ -33,8 +33,10 @@ type QuerierParams struct {
 	Context       context.Context
 	NoPreWhere    bool
 	ORGID         string
+	UserID        string
 	SimpleSql     bool
 	Language      string
+	Args          []interface{}
 }
 
 type TempoParams struct {
@@ -47,6 +49,8 @@ type TempoParams struct {
 	Limit       string
 	Debug       string
 	Filters     []*KeyValue
+	Query       string
+	SpssLimit   string
 	Context     context.Context
 }
 
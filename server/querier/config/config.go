@@ -27,6 +27,7 @@ import (
 	yaml "gopkg.in/yaml.v2"
 
 	tracemap "github.com/deepflowio/deepflow/server/querier/app/distributed_tracing/config"
+	loki "github.com/deepflowio/deepflow/server/querier/app/loki/config"
 	prometheus "github.com/deepflowio/deepflow/server/querier/app/prometheus/config"
 	tracing_adapter "github.com/deepflowio/deepflow/server/querier/app/tracing-adapter/config"
 	profile "github.com/deepflowio/deepflow/server/querier/profile/config"
@@ -52,6 +53,7 @@ type QuerierConfig struct {
 	Tracemap                        tracemap.TraceMapConfig       `yaml:"trace-map"`
 	DeepflowApp                     DeepflowApp                   `yaml:"deepflow-app"`
 	Prometheus                      prometheus.Prometheus         `yaml:"prometheus"`
+	Loki                            loki.Loki                     `yaml:"loki"`
 	ExternalAPM                     []tracing_adapter.ExternalAPM `yaml:"external-apm"`
 	Language                        string                        `default:"en" yaml:"language"`
 	OtelEndpoint                    string                        `default:"http://deepflow-agent/api/v1/otel/trace" yaml:"otel-endpoint"`
@@ -62,6 +64,24 @@ type QuerierConfig struct {
 	MaxPrometheusIdSubqueryLruEntry int                           `default:"8000" yaml:"max-prometheus-id-subquery-lru-entry"`
 	PrometheusIdSubqueryLruTimeout  int                           `default:"60" yaml:"prometheus-id-subquery-lru-timeout"`
 	AutoCustomTags                  []AutoCustomTags              `yaml:"auto-custom-tags" binding:"omitempty,dive"`
+	QueryGovernance                 QueryGovernance               `yaml:"query-governance"`
+}
+
+type QueryGovernance struct {
+	Enabled bool                   `default:"false" yaml:"enabled"`
+	Default QueryPolicy            `yaml:"default"`
+	Orgs    map[string]QueryPolicy `yaml:"orgs"`  // key is org id
+	Users   map[string]QueryPolicy `yaml:"users"` // key is user id
+}
+
+// QueryPolicy limits queries, 0 means inheriting from the upper level policy and -1 means unlimited
+type QueryPolicy struct {
+	MaxTimeRange      int            `yaml:"max-time-range"`       // second
+	TableMaxTimeRange map[string]int `yaml:"table-max-time-range"` // second, key is `table` or `db.table`
+	MaxResultRows     int            `yaml:"max-result-rows"`
+	MaxConcurrency    int            `yaml:"max-concurrency"`
+	MaxExecutionTime  int            `yaml:"max-execution-time"` // second
+	MaxMemoryUsage    int64          `yaml:"max-memory-usage"`   // byte
 }
 
 type DeepflowApp struct {
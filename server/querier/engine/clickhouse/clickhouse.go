@@ -40,6 +40,7 @@ import (
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/tag"
 	tagdescription "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/tag"
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/view"
+	"github.com/deepflowio/deepflow/server/querier/governance"
 	"github.com/deepflowio/deepflow/server/querier/parse"
 )
 
@@ -102,7 +103,9 @@ type CHEngine struct {
 	NoPreWhere         bool
 	IsDerivative       bool
 	DerivativeGroupBy  []string
+	TimeSeries         *TimeSeries
 	ORGID              string
+	UserID             string
 	Language           string
 }
 
@@ -117,7 +120,7 @@ func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map
 	// 解析show开头的sql
 	// show metrics/tags from <table_name> 例：show metrics/tags from l4_flow_log
 	var err error
-	sql := args.Sql
+	sql := QuoteTimeSeriesDuration(args.Sql)
 	e.Context = args.Context
 	e.NoPreWhere = args.NoPreWhere
 	e.Language = args.Language
@@ -125,6 +128,7 @@ func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map
 	if args.ORGID != "" {
 		e.ORGID = args.ORGID
 	}
+	e.UserID = args.UserID
 	query_uuid := args.QueryUUID // FIXME: should be queryUUID
 	log.Debugf("query_uuid: %s | raw sql: %s", query_uuid, sql)
 	debug_info := &client.DebugInfo{}
@@ -190,7 +194,7 @@ func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map
 	for _, sql1 := range sqlList {
 		usedEngine := &CHEngine{}
 		if isShow {
-			showEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID}
+			showEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID, UserID: e.UserID}
 			showEngine.Init()
 			parser.Engine = showEngine
 			usedEngine = showEngine
@@ -209,6 +213,11 @@ func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map
 			stmt.Format(usedEngine.Model)
 		}
 		FormatModel(usedEngine.Model)
+		if !isShow {
+			if err = usedEngine.CheckTimeRange(); err != nil {
+				return nil, nil, err
+			}
+		}
 		// 使用Model生成View
 		usedEngine.View = view.NewView(usedEngine.Model)
 		if !isShow {
@@ -231,6 +240,7 @@ func (e *CHEngine) ExecuteQuery(args *common.QuerierParams) (*common.Result, map
 			QueryUUID:       query_uuid,
 			ColumnSchemaMap: ColumnSchemaMap,
 			ORGID:           args.ORGID,
+			UserID:          args.UserID,
 		}
 		if !isShow {
 			params.Callbacks = callbacks
@@ -571,6 +581,7 @@ func (e *CHEngine) QuerySlimitSql(sql string, args *common.QuerierParams) (*comm
 		QueryUUID:       query_uuid,
 		ColumnSchemaMap: columnSchemaMap,
 		ORGID:           args.ORGID,
+		UserID:          args.UserID,
 	}
 	rst, err := chClient.DoQuery(params)
 	if err != nil {
@@ -817,7 +828,7 @@ func (e *CHEngine) ParseSlimitSql(sql string, args *common.QuerierParams) (strin
 				}
 			}
 		}
-		innerEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID}
+		innerEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID, UserID: e.UserID}
 		innerEngine.Init()
 		if strings.Contains(innerSql, "Derivative") {
 			innerEngine.IsDerivative = true
@@ -837,7 +848,7 @@ func (e *CHEngine) ParseSlimitSql(sql string, args *common.QuerierParams) (strin
 		innerEngine.View = view.NewView(innerEngine.Model)
 		innerTransSql = innerEngine.ToSQLString()
 	}
-	outerEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID}
+	outerEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID, UserID: e.UserID}
 	outerEngine.Init()
 	if strings.Contains(newSql, "Derivative") {
 		outerEngine.IsDerivative = true
@@ -853,6 +864,9 @@ func (e *CHEngine) ParseSlimitSql(sql string, args *common.QuerierParams) (strin
 		stmt.Format(outerEngine.Model)
 	}
 	FormatModel(outerEngine.Model)
+	if err = outerEngine.CheckTimeRange(); err != nil {
+		return "", nil, nil, err
+	}
 	// 使用Model生成View
 	outerEngine.View = view.NewView(outerEngine.Model)
 	outerTransSql := outerEngine.ToSQLString()
@@ -929,6 +943,7 @@ func (e *CHEngine) QueryWithSql(sql string, args *common.QuerierParams) (*common
 		QueryUUID:       query_uuid,
 		ColumnSchemaMap: columnSchemaMap,
 		ORGID:           args.ORGID,
+		UserID:          args.UserID,
 	}
 	rst, err := chClient.DoQuery(params)
 	if err != nil {
@@ -950,7 +965,7 @@ func (e *CHEngine) ParseWithSql(sql string) (string, map[string]func(*common.Res
 	for _, match := range subMatches {
 		match = strings.TrimPrefix(match, "(")
 		match = strings.TrimSuffix(match, ")")
-		matchEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID}
+		matchEngine := &CHEngine{DB: e.DB, DataSource: e.DataSource, Context: e.Context, ORGID: e.ORGID, UserID: e.UserID}
 		matchEngine.Init()
 		matchParser := parse.Parser{Engine: matchEngine}
 		err := matchParser.ParseSQL(match)
@@ -961,6 +976,9 @@ func (e *CHEngine) ParseWithSql(sql string) (string, map[string]func(*common.Res
 			stmt.Format(matchEngine.Model)
 		}
 		FormatModel(matchEngine.Model)
+		if err = matchEngine.CheckTimeRange(); err != nil {
+			return "", nil, nil, err
+		}
 		// 使用Model生成View
 		matchEngine.View = view.NewView(matchEngine.Model)
 		if callbacks == nil {
@@ -978,6 +996,23 @@ func (e *CHEngine) ParseWithSql(sql string) (string, map[string]func(*common.Res
 	return sql, callbacks, columnSchemaMap, nil
 }
 
+// CheckTimeRange checks time range of the query against the query governance policy
+func (e *CHEngine) CheckTimeRange() error {
+	if e.DB == chCommon.DB_NAME_FLOW_TAG || e.Table == "" {
+		return nil
+	}
+	policy := governance.GetPolicy(e.ORGID, e.UserID)
+	// the time start includes the lookback of the time-series functions, and the shifted ranges of Compare
+	// are counted as if they were contiguous with the time range, so that the scanned span is checked
+	timeStart := e.Model.Time.TimeStart
+	if e.TimeSeries != nil && e.TimeSeries.TimeStart > 0 {
+		for _, r := range e.TimeSeries.ShiftedRanges(e.Model.Time.TimeEnd, e.Model.Time.Interval) {
+			timeStart -= r[1] - r[0]
+		}
+	}
+	return governance.CheckTimeRange(policy, e.DB, e.Table, timeStart, e.Model.Time.TimeEnd)
+}
+
 func (e *CHEngine) Init() {
 	e.Model = view.NewModel()
 	e.Model.DB = e.DB
@@ -1091,6 +1126,9 @@ func (e *CHEngine) TransSelect(tags sqlparser.SelectExprs) error {
 			}
 		}
 	}
+	if e.TimeSeries != nil && (e.Model.Time.Alias == "" || e.Model.Time.Interval <= 0) {
+		return errors.New("time-series functions require time(time, interval) with an alias in select")
+	}
 	return nil
 }
 
@@ -1507,6 +1545,10 @@ func (e *CHEngine) parseSelectAlias(item *sqlparser.AliasedExpr) error {
 		return nil
 	// func(field/tag)
 	case *sqlparser.FuncExpr:
+		// time-series functions computed on the result
+		if common.IsValueInSliceString(strings.Trim(sqlparser.String(expr.Name), "`"), TIME_SERIES_FUNCTIONS) {
+			return e.parseTimeSeriesFunction(expr, as)
+		}
 		// 二级运算符
 		if common.IsValueInSliceString(sqlparser.String(expr.Name), view.MATH_FUNCTIONS) {
 			if as == "" {
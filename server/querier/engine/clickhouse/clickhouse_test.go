@@ -381,6 +381,14 @@ var (
 		name:    "TopK_err",
 		input:   "select TopK(ip_0, 111) from l4_flow_log limit 1",
 		wantErr: "function [TopK] argument [111] value range is incorrect, it should be within [1, 100]",
+	}, {
+		name:    "time_series_without_time",
+		input:   "select MovingAvg(Sum(byte), 5) as moving_avg_byte from l4_flow_log limit 1",
+		wantErr: "time-series functions require time(time, interval) with an alias in select",
+	}, {
+		name:    "time_series_err",
+		input:   "select time(time, 60) as toi, Compare(Sum(byte), '1d', 'percent') as compare_byte from l4_flow_log group by toi limit 1",
+		wantErr: "function [Compare] mode [percent] should be one of ratio, diff and value",
 	}, {
 		name:       "TopK_3",
 		input:      "SELECT TopK(`region`,3) AS `TopK_3(区域)` FROM `vtap_app_port` WHERE (time>=1705370520 AND time<=1705371300)",
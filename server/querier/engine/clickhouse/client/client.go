@@ -31,6 +31,7 @@ import (
 	ctrCommon "github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/querier/common"
 	"github.com/deepflowio/deepflow/server/querier/config"
+	"github.com/deepflowio/deepflow/server/querier/governance"
 	"github.com/deepflowio/deepflow/server/querier/statsd"
 	"github.com/google/uuid"
 	logging "github.com/op/go-logging"
@@ -46,7 +47,10 @@ type QueryParams struct {
 	QueryUUID       string
 	ColumnSchemaMap map[string]*common.ColumnSchema
 	ORGID           string
+	UserID          string
 	SimpleSql       bool
+	// Args are bound to the `@name` placeholders in Sql, build them with clickhouse.Named
+	Args []interface{}
 }
 
 // All ClickHouse Client share one connection
@@ -151,9 +155,29 @@ func (c *Client) DoQuery(params *QueryParams) (result *common.Result, err error)
 	if c.Context == nil {
 		ctx = context.Background()
 	}
-	rows, err := c.connection.Query(ctx, sqlstr)
 	c.Debug.Sql = sqlstr
+	// query governance, the query can be killed by query_uuid
+	policy := governance.GetPolicy(params.ORGID, params.UserID)
+	ctx, runningQuery, err := governance.Begin(ctx, c.Debug.QueryUUID, params.ORGID, params.UserID, sqlstr)
 	if err != nil {
+		log.Warningf("query_uuid: %s rejected: %s", c.Debug.QueryUUID, err)
+		c.Debug.Error = fmt.Sprintf("%s", err)
+		return nil, err
+	}
+	defer runningQuery.End()
+	settings := clickhouse.Settings{}
+	if policy.MaxExecutionTime > 0 {
+		settings["max_execution_time"] = policy.MaxExecutionTime
+	}
+	if policy.MaxMemoryUsage > 0 {
+		settings["max_memory_usage"] = policy.MaxMemoryUsage
+	}
+	if len(settings) > 0 {
+		ctx = clickhouse.Context(ctx, clickhouse.WithSettings(settings))
+	}
+	rows, err := c.connection.Query(ctx, sqlstr, params.Args...)
+	if err != nil {
+		err = runningQuery.Err(err)
 		log.Errorf("query clickhouse Error: %s, sql: %s, query_uuid: %s", err, sqlstr, c.Debug.QueryUUID)
 		c.Debug.Error = fmt.Sprintf("%s", err)
 		return nil, err
@@ -181,6 +205,11 @@ func (c *Client) DoQuery(params *QueryParams) (result *common.Result, err error)
 	resSize := 0
 	for rows.Next() {
 		if err := rows.Scan(columnValues...); err != nil {
+			err = runningQuery.Err(err)
+			c.Debug.Error = fmt.Sprintf("%s", err)
+			return nil, err
+		}
+		if err := governance.CheckResultRows(policy, len(values)+1); err != nil {
 			c.Debug.Error = fmt.Sprintf("%s", err)
 			return nil, err
 		}
@@ -195,6 +224,7 @@ func (c *Client) DoQuery(params *QueryParams) (result *common.Result, err error)
 	// Even if the query operation produces an error, it does not necessarily return an error in the'err 'parameter,
 	// so the return value of the'rows. Err () ' method must be checked to ensure that the query operation is successful
 	if err := rows.Err(); err != nil {
+		err = runningQuery.Err(err)
 		log.Errorf("query clickhouse Error: %s, sql: %s, query_uuid: %s", err, sqlstr, c.Debug.QueryUUID)
 		c.Debug.Error = fmt.Sprintf("%s", err)
 		return nil, err
@@ -27,6 +27,7 @@ import (
 	"strconv"
 	"strings"
 
+	ctlcommon "github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/querier/config"
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/client"
 	logging "github.com/op/go-logging"
@@ -202,6 +203,21 @@ func GetDatasourceInterval(db string, table string, name string, orgID string) (
 	return int(body["DATA"].([]interface{})[0].(map[string]interface{})["INTERVAL"].(float64)), nil
 }
 
+// GetOrgIDs returns the IDs of all orgs from the controller
+func GetOrgIDs() ([]string, error) {
+	getOrgUrl := fmt.Sprintf("http://localhost:%d/v1/orgs/", config.ControllerCfg.ListenPort)
+	resp, err := ctlcommon.CURLPerform("GET", getOrgUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request controller failed: %s, URL: %s", err, getOrgUrl)
+	}
+	data := resp.Get("DATA")
+	orgIDs := make([]string, 0, len(data.MustArray()))
+	for i := range data.MustArray() {
+		orgIDs = append(orgIDs, strconv.Itoa(data.GetIndex(i).Get("ORG_ID").MustInt()))
+	}
+	return orgIDs, nil
+}
+
 func GetExtTables(db, where, queryCacheTTL, orgID string, useQueryCache bool, ctx context.Context, DebugInfo *client.DebugInfo) (values []interface{}) {
 	chClient := client.Client{
 		Host:     config.Cfg.Clickhouse.Host,
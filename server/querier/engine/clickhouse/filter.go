@@ -1282,6 +1282,11 @@ func (t *TimeTag) Trans(expr sqlparser.Expr, w *Where, e *CHEngine) (view.Node,
 		if e.IsDerivative && w.time.Interval > 0 {
 			newTime -= int64(w.time.Interval)
 		}
+		// time-series functions start time forward
+		if e.TimeSeries != nil && w.time.Interval > 0 {
+			e.TimeSeries.AddTimeStart(time, compareExpr.Operator)
+			newTime -= e.TimeSeries.Lookback(w.time.Interval)
+		}
 		w.time.AddTimeStart(newTime)
 		w.time.TimeStartOperator = compareExpr.Operator
 	} else if compareExpr.Operator == "<=" || compareExpr.Operator == "<" {
@@ -1292,6 +1297,18 @@ func (t *TimeTag) Trans(expr sqlparser.Expr, w *Where, e *CHEngine) (view.Node,
 	if newTime != time {
 		newValue = strings.Replace(newValue, strconv.FormatInt(time, 10), strconv.FormatInt(newTime, 10), 1)
 	}
+	// Compare queries the time range shifted by the offset besides the time range, instead of the whole span between them.
+	// the time end is parsed before in parseTimeWhere
+	if newTime != time && e.TimeSeries != nil {
+		if ranges := e.TimeSeries.ShiftedRanges(w.time.TimeEnd, w.time.Interval); len(ranges) > 0 {
+			column := sqlparser.String(compareExpr.Left)
+			conditions := []string{newValue}
+			for _, r := range ranges {
+				conditions = append(conditions, fmt.Sprintf("(%s >= %d AND %s < %d)", column, r[0], column, r[1]))
+			}
+			newValue = "(" + strings.Join(conditions, " OR ") + ")"
+		}
+	}
 	return &view.Expr{Value: newValue}, nil
 }
 
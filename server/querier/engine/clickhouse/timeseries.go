@@ -0,0 +1,681 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package clickhouse
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/xwb1989/sqlparser"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/view"
+)
+
+// Time-series functions are computed by the querier on the result of ClickHouse, per group of the tags
+// in the same query, the metric of the first argument is queried as usual and its column is replaced by
+// the result, e.g. MovingAvg(Avg(rtt), 5). The time range of the query is extended backward to compute the
+// first points, and the extra points are removed from the result, time(time, interval) must be selected.
+const (
+	TIME_SERIES_FUNCTION_MOVING_AVG    = "MovingAvg"    // MovingAvg(metric, n): average of the last n intervals
+	TIME_SERIES_FUNCTION_COMPARE       = "Compare"      // Compare(metric, offset[, 'ratio'|'diff'|'value']): compare with offset ago, e.g. 1d, 1w
+	TIME_SERIES_FUNCTION_RATE          = "Rate"         // Rate(metric): change per second since the previous point
+	TIME_SERIES_FUNCTION_DELTA         = "Delta"        // Delta(metric): change since the previous point
+	TIME_SERIES_FUNCTION_EWMA          = "EWMA"         // EWMA(metric, alpha): exponentially weighted moving average as the baseline
+	TIME_SERIES_FUNCTION_ANOMALY_SCORE = "AnomalyScore" // AnomalyScore(metric, n): z-score against the last n intervals
+
+	COMPARE_MODE_RATIO = "ratio" // (current - previous) / previous * 100
+	COMPARE_MODE_DIFF  = "diff"  // current - previous
+	COMPARE_MODE_VALUE = "value" // previous
+)
+
+var TIME_SERIES_FUNCTIONS = []string{
+	TIME_SERIES_FUNCTION_MOVING_AVG, TIME_SERIES_FUNCTION_COMPARE, TIME_SERIES_FUNCTION_RATE,
+	TIME_SERIES_FUNCTION_DELTA, TIME_SERIES_FUNCTION_EWMA, TIME_SERIES_FUNCTION_ANOMALY_SCORE,
+}
+
+var compareDurationRegexp = regexp.MustCompile(`^[0-9]+[smhdwy]$`)
+
+// QuoteTimeSeriesDuration quotes the bare durations such as 1d in the offset argument of Compare, which are
+// not valid sql. The sql is scanned by tokens, the string literals and quoted identifiers are kept as is.
+func QuoteTimeSeriesDuration(sql string) string {
+	type compareCall struct {
+		depth int // depth of the parentheses of the call
+		arg   int // index of the current argument
+	}
+	var calls []compareCall
+	var sb strings.Builder
+	depth := 0
+	afterCompare := false // the last token is Compare, the next parenthesis opens the call
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		j := i + 1
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			j = skipQuoted(sql, i)
+		case isIdentifierChar(c):
+			for j < len(sql) && isIdentifierChar(sql[j]) {
+				j++
+			}
+			token := sql[i:j]
+			if n := len(calls); n > 0 && calls[n-1].depth == depth && calls[n-1].arg == 1 && compareDurationRegexp.MatchString(token) {
+				sb.WriteString("'" + token + "'")
+				i = j
+				afterCompare = false
+				continue
+			}
+			sb.WriteString(token)
+			i = j
+			afterCompare = token == TIME_SERIES_FUNCTION_COMPARE
+			continue
+		case c == '(':
+			depth++
+			if afterCompare {
+				calls = append(calls, compareCall{depth: depth})
+			}
+		case c == ')':
+			if n := len(calls); n > 0 && calls[n-1].depth == depth {
+				calls = calls[:n-1]
+			}
+			depth--
+		case c == ',':
+			if n := len(calls); n > 0 && calls[n-1].depth == depth {
+				calls[n-1].arg++
+			}
+		}
+		sb.WriteString(sql[i:j])
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			afterCompare = false
+		}
+		i = j
+	}
+	return sb.String()
+}
+
+// skipQuoted returns the end of the quoted string or identifier starting at i
+func skipQuoted(sql string, i int) int {
+	quote := sql[i]
+	for j := i + 1; j < len(sql); j++ {
+		if sql[j] == '\\' {
+			j++
+		} else if sql[j] == quote {
+			if j+1 < len(sql) && sql[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(sql)
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+type TimeSeriesFunction struct {
+	Name   string
+	Column string
+	Points int     // window of MovingAvg and AnomalyScore
+	Offset int     // unit: second, offset of Compare
+	Mode   string  // mode of Compare
+	Alpha  float64 // smoothing factor of EWMA
+	Series *TimeSeries
+}
+
+func NewTimeSeriesFunction(name string, args []string, column string) (*TimeSeriesFunction, error) {
+	f := &TimeSeriesFunction{Name: name, Column: column}
+	argCount := map[string][]int{
+		TIME_SERIES_FUNCTION_MOVING_AVG:    {1, 1},
+		TIME_SERIES_FUNCTION_COMPARE:       {1, 2},
+		TIME_SERIES_FUNCTION_RATE:          {0, 0},
+		TIME_SERIES_FUNCTION_DELTA:         {0, 0},
+		TIME_SERIES_FUNCTION_EWMA:          {1, 1},
+		TIME_SERIES_FUNCTION_ANOMALY_SCORE: {1, 1},
+	}[name]
+	if len(args) < argCount[0] || len(args) > argCount[1] {
+		return nil, fmt.Errorf("function [%s] requires %d to %d arguments besides the metric, got %d", name, argCount[0], argCount[1], len(args))
+	}
+	var err error
+	switch name {
+	case TIME_SERIES_FUNCTION_MOVING_AVG, TIME_SERIES_FUNCTION_ANOMALY_SCORE:
+		f.Points, err = strconv.Atoi(args[0])
+		minPoints := 1
+		if name == TIME_SERIES_FUNCTION_ANOMALY_SCORE {
+			minPoints = 2
+		}
+		if err != nil || f.Points < minPoints {
+			return nil, fmt.Errorf("function [%s] argument [%s] should be an integer not less than %d", name, args[0], minPoints)
+		}
+	case TIME_SERIES_FUNCTION_COMPARE:
+		f.Offset, err = parseTimeSeriesOffset(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("function [%s] argument [%s] is not a valid offset: %s", name, args[0], err)
+		}
+		f.Mode = COMPARE_MODE_RATIO
+		if len(args) > 1 {
+			f.Mode = args[1]
+		}
+		if f.Mode != COMPARE_MODE_RATIO && f.Mode != COMPARE_MODE_DIFF && f.Mode != COMPARE_MODE_VALUE {
+			return nil, fmt.Errorf("function [%s] mode [%s] should be one of %s, %s and %s", name, f.Mode, COMPARE_MODE_RATIO, COMPARE_MODE_DIFF, COMPARE_MODE_VALUE)
+		}
+	case TIME_SERIES_FUNCTION_EWMA:
+		f.Alpha, err = strconv.ParseFloat(args[0], 64)
+		if err != nil || f.Alpha <= 0 || f.Alpha > 1 {
+			return nil, fmt.Errorf("function [%s] argument [%s] should be within (0, 1]", name, args[0])
+		}
+	}
+	return f, nil
+}
+
+func parseTimeSeriesOffset(offset string) (int, error) {
+	if seconds, err := strconv.Atoi(offset); err == nil {
+		if seconds <= 0 {
+			return 0, errors.New("offset should be positive")
+		}
+		return seconds, nil
+	}
+	d, err := model.ParseDuration(offset)
+	if err != nil {
+		return 0, err
+	}
+	if time.Duration(d) < time.Second {
+		return 0, errors.New("offset should not be less than 1s")
+	}
+	return int(time.Duration(d) / time.Second), nil
+}
+
+// Lookback returns the seconds to extend the time range backward, so that the first points have enough history,
+// Compare only needs the time range shifted by the offset, which is queried separately, see ShiftedRanges
+func (f *TimeSeriesFunction) Lookback(interval int) int64 {
+	switch f.Name {
+	case TIME_SERIES_FUNCTION_MOVING_AVG:
+		return int64((f.Points - 1) * interval)
+	case TIME_SERIES_FUNCTION_ANOMALY_SCORE:
+		return int64(f.Points * interval)
+	case TIME_SERIES_FUNCTION_RATE, TIME_SERIES_FUNCTION_DELTA:
+		return int64(interval)
+	case TIME_SERIES_FUNCTION_EWMA:
+		// the weight of the points before the span is small enough
+		return int64(math.Ceil(2/f.Alpha)) * int64(interval)
+	}
+	return 0
+}
+
+func (f *TimeSeriesFunction) Format(m *view.Model) {
+	f.Series.Format(m)
+}
+
+// Unit returns the unit of the result from the unit of the metric
+func (f *TimeSeriesFunction) Unit(unit string) string {
+	switch f.Name {
+	case TIME_SERIES_FUNCTION_RATE:
+		if unit == "" {
+			return "/s"
+		}
+		return unit + "/s"
+	case TIME_SERIES_FUNCTION_COMPARE:
+		if f.Mode == COMPARE_MODE_RATIO {
+			return "%"
+		}
+	case TIME_SERIES_FUNCTION_ANOMALY_SCORE:
+		return ""
+	}
+	return unit
+}
+
+// compute returns the results of the points in a group, the points are sorted by time
+func (f *TimeSeriesFunction) compute(times []int64, values []float64, valid []bool, interval int) []interface{} {
+	results := make([]interface{}, len(times))
+	switch f.Name {
+	case TIME_SERIES_FUNCTION_DELTA, TIME_SERIES_FUNCTION_RATE:
+		prev := -1
+		for i := range times {
+			if !valid[i] {
+				continue
+			}
+			if prev >= 0 {
+				if f.Name == TIME_SERIES_FUNCTION_DELTA {
+					results[i] = values[i] - values[prev]
+				} else if times[i] > times[prev] {
+					results[i] = (values[i] - values[prev]) / float64(times[i]-times[prev])
+				}
+			}
+			prev = i
+		}
+	case TIME_SERIES_FUNCTION_MOVING_AVG:
+		window := int64(f.Points * interval)
+		start, sum, count := 0, 0.0, 0
+		for i := range times {
+			if valid[i] {
+				sum += values[i]
+				count++
+			}
+			for ; times[start] <= times[i]-window; start++ {
+				if valid[start] {
+					sum -= values[start]
+					count--
+				}
+			}
+			if valid[i] && count > 0 {
+				results[i] = sum / float64(count)
+			}
+		}
+	case TIME_SERIES_FUNCTION_COMPARE:
+		indexes := make(map[int64]int, len(times))
+		for i, t := range times {
+			indexes[t] = i
+		}
+		for i, t := range times {
+			prev, ok := indexes[t-int64(f.Offset)]
+			if !ok || !valid[prev] {
+				continue
+			}
+			switch f.Mode {
+			case COMPARE_MODE_VALUE:
+				results[i] = values[prev]
+			case COMPARE_MODE_DIFF:
+				if valid[i] {
+					results[i] = values[i] - values[prev]
+				}
+			case COMPARE_MODE_RATIO:
+				if valid[i] && values[prev] != 0 {
+					results[i] = (values[i] - values[prev]) / values[prev] * 100
+				}
+			}
+		}
+	case TIME_SERIES_FUNCTION_EWMA:
+		var baseline float64
+		initialized := false
+		for i := range times {
+			if !valid[i] {
+				continue
+			}
+			if !initialized {
+				baseline = values[i]
+				initialized = true
+			} else {
+				baseline = f.Alpha*values[i] + (1-f.Alpha)*baseline
+			}
+			results[i] = baseline
+		}
+	case TIME_SERIES_FUNCTION_ANOMALY_SCORE:
+		window := int64(f.Points * interval)
+		start := 0
+		for i := range times {
+			for ; times[start] < times[i]-window; start++ {
+			}
+			if !valid[i] {
+				continue
+			}
+			var sum, squareSum float64
+			count := 0
+			for j := start; j < i; j++ {
+				if valid[j] {
+					sum += values[j]
+					squareSum += values[j] * values[j]
+					count++
+				}
+			}
+			if count < 2 {
+				continue
+			}
+			mean := sum / float64(count)
+			stddev := math.Sqrt(math.Max(squareSum/float64(count)-mean*mean, 0))
+			if stddev == 0 {
+				results[i] = 0.0
+			} else {
+				results[i] = (values[i] - mean) / stddev
+			}
+		}
+	}
+	return results
+}
+
+// TimeSeries keeps the time-series functions of a query, they share one callback
+type TimeSeries struct {
+	Functions         []*TimeSeriesFunction
+	TimeStart         int64 // the time start before extended
+	TimeStartOperator string
+	// limit of the query, applied after the points of the extended time range are removed
+	Limit     string
+	Offset    string
+	formatted bool
+}
+
+func (s *TimeSeries) AddFunction(f *TimeSeriesFunction) {
+	f.Series = s
+	s.Functions = append(s.Functions, f)
+}
+
+func (s *TimeSeries) Lookback(interval int) int64 {
+	var lookback int64
+	for _, f := range s.Functions {
+		if l := f.Lookback(interval); l > lookback {
+			lookback = l
+		}
+	}
+	return lookback
+}
+
+// ShiftedRanges returns the time ranges [start, end) shifted backward by the offsets of Compare, excluding the
+// part in the time range extended by the lookback, they are sorted and not overlapped. timeEnd <= 0 means now
+func (s *TimeSeries) ShiftedRanges(timeEnd int64, interval int) [][2]int64 {
+	if timeEnd <= 0 {
+		timeEnd = time.Now().Unix()
+	}
+	extendedStart := s.TimeStart - s.Lookback(interval)
+	var ranges [][2]int64
+	for _, f := range s.Functions {
+		if f.Name != TIME_SERIES_FUNCTION_COMPARE {
+			continue
+		}
+		// the point of the last interval covers [timeEnd, timeEnd+interval) in the worst case
+		r := [2]int64{s.TimeStart - int64(f.Offset), timeEnd - int64(f.Offset) + int64(interval)}
+		if r[1] > extendedStart {
+			r[1] = extendedStart
+		}
+		if r[0] < r[1] {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if last := len(merged) - 1; last >= 0 && r[0] <= merged[last][1] {
+			if r[1] > merged[last][1] {
+				merged[last][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func (s *TimeSeries) AddTimeStart(timeStart int64, operator string) {
+	if timeStart > s.TimeStart {
+		s.TimeStart = timeStart
+		s.TimeStartOperator = operator
+	}
+}
+
+// Format adds the callback after the time fill callback, so that the missing points are filled before computing,
+// and moves the limit of the query to the callback, otherwise the extra points take up the limit
+func (s *TimeSeries) Format(m *view.Model) {
+	if s.formatted {
+		return
+	}
+	s.formatted = true
+	FormatLimit(m)
+	s.Limit, s.Offset = m.Limit.Limit, m.Limit.Offset
+	m.Limit.Limit, m.Limit.Offset = common.NO_LIMIT, ""
+	timeFill := m.Callbacks["time"]
+	m.Callbacks["time"] = func(result *common.Result) error {
+		// the points of the shifted ranges of Compare are out of the time range, keep them from the time fill
+		shifted := takeRowsBefore(result, m.Time.Alias, m.Time.TimeStart)
+		if timeFill != nil {
+			if err := timeFill(result); err != nil {
+				return err
+			}
+		}
+		result.Values = append(result.Values, shifted...)
+		return s.Compute(result, m.Time.Alias, m.Time.Interval)
+	}
+}
+
+func timeColumnIndex(result *common.Result, timeAlias string) int {
+	for i, column := range result.Columns {
+		if name, _ := column.(string); name == strings.Trim(timeAlias, "`") {
+			return i
+		}
+	}
+	return -1
+}
+
+// takeRowsBefore removes the rows before timeStart from the result and returns them
+func takeRowsBefore(result *common.Result, timeAlias string, timeStart int64) []interface{} {
+	timeIndex := timeColumnIndex(result, timeAlias)
+	if timeIndex < 0 || timeStart <= 0 {
+		return nil
+	}
+	var taken []interface{}
+	values := result.Values[:0]
+	for _, value := range result.Values {
+		if row, ok := value.([]interface{}); ok {
+			if t, _ := toInt64(row[timeIndex]); t < timeStart {
+				taken = append(taken, value)
+				continue
+			}
+		}
+		values = append(values, value)
+	}
+	result.Values = values
+	return taken
+}
+
+func (s *TimeSeries) Compute(result *common.Result, timeAlias string, interval int) error {
+	if len(result.Values) == 0 {
+		return nil
+	}
+	timeIndex := timeColumnIndex(result, timeAlias)
+	columnIndexes := make(map[string]int, len(result.Columns))
+	for i, column := range result.Columns {
+		name, _ := column.(string)
+		columnIndexes[name] = i
+	}
+	if timeIndex < 0 {
+		return fmt.Errorf("time column [%s] not found", timeAlias)
+	}
+	var tagIndexes []int
+	for i, schema := range result.Schemas {
+		if i != timeIndex && schema != nil && schema.Type == common.COLUMN_SCHEMA_TYPE_TAG {
+			tagIndexes = append(tagIndexes, i)
+		}
+	}
+
+	// group the rows by the tags and sort them by time
+	var groupKeys []string
+	groups := make(map[string][][]interface{})
+	for _, value := range result.Values {
+		row, ok := value.([]interface{})
+		if !ok {
+			continue
+		}
+		keyParts := make([]string, len(tagIndexes))
+		for i, index := range tagIndexes {
+			keyParts[i] = fmt.Sprint(row[index])
+		}
+		key := strings.Join(keyParts, "\x00")
+		if _, ok := groups[key]; !ok {
+			groupKeys = append(groupKeys, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+
+	for _, key := range groupKeys {
+		rows := groups[key]
+		sort.SliceStable(rows, func(i, j int) bool {
+			ti, _ := toInt64(rows[i][timeIndex])
+			tj, _ := toInt64(rows[j][timeIndex])
+			return ti < tj
+		})
+		times := make([]int64, len(rows))
+		for i, row := range rows {
+			times[i], _ = toInt64(row[timeIndex])
+		}
+		// compute all the functions before writing back, the functions may share the same metric
+		results := make([][]interface{}, len(s.Functions))
+		for i, f := range s.Functions {
+			columnIndex, ok := columnIndexes[strings.Trim(f.Column, "`")]
+			if !ok {
+				continue
+			}
+			values := make([]float64, len(rows))
+			valid := make([]bool, len(rows))
+			for j, row := range rows {
+				values[j], valid[j] = toFloat64(row[columnIndex])
+			}
+			results[i] = f.compute(times, values, valid, interval)
+		}
+		for i, f := range s.Functions {
+			if results[i] == nil {
+				continue
+			}
+			columnIndex := columnIndexes[strings.Trim(f.Column, "`")]
+			for j, row := range rows {
+				row[columnIndex] = results[i][j]
+			}
+		}
+	}
+
+	// remove the points only queried for the history
+	if s.TimeStart > 0 {
+		newValues := make([]interface{}, 0, len(result.Values))
+		for _, value := range result.Values {
+			if row, ok := value.([]interface{}); ok {
+				t, _ := toInt64(row[timeIndex])
+				if t < s.TimeStart || (t == s.TimeStart && s.TimeStartOperator == ">") {
+					continue
+				}
+			}
+			newValues = append(newValues, value)
+		}
+		result.Values = newValues
+	}
+	s.applyLimit(result)
+	return nil
+}
+
+func (s *TimeSeries) applyLimit(result *common.Result) {
+	if offset, err := strconv.Atoi(s.Offset); err == nil && offset > 0 {
+		if offset > len(result.Values) {
+			offset = len(result.Values)
+		}
+		result.Values = result.Values[offset:]
+	}
+	if limit, err := strconv.Atoi(s.Limit); err == nil && limit >= 0 && limit < len(result.Values) {
+		result.Values = result.Values[:limit]
+	}
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case uint32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	case time.Time:
+		return v.Unix(), true
+	}
+	return 0, false
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case *float64:
+		if v != nil {
+			return *v, true
+		}
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// parseTimeSeriesFunction parses the metric of the function as a select item with the alias of the function
+func (e *CHEngine) parseTimeSeriesFunction(expr *sqlparser.FuncExpr, as string) error {
+	name := strings.Trim(sqlparser.String(expr.Name), "`")
+	if len(expr.Exprs) == 0 {
+		return fmt.Errorf("function [%s] requires a metric", name)
+	}
+	metric, ok := expr.Exprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return fmt.Errorf("function [%s] argument [%s] is not a metric", name, sqlparser.String(expr.Exprs[0]))
+	}
+	var args []string
+	for _, arg := range expr.Exprs[1:] {
+		args = append(args, strings.Trim(sqlparser.String(arg), "'"))
+	}
+	if as == "" {
+		as = strings.ReplaceAll(chCommon.ParseAlias(expr), "`", "")
+	}
+	function, err := NewTimeSeriesFunction(name, args, as)
+	if err != nil {
+		return err
+	}
+
+	schemaIndex := len(e.ColumnSchemas) - 1
+	if err := e.parseSelectAlias(&sqlparser.AliasedExpr{Expr: metric.Expr, As: sqlparser.NewColIdent(as)}); err != nil {
+		return err
+	}
+	metricSchema := e.ColumnSchemas[len(e.ColumnSchemas)-1]
+	e.ColumnSchemas = e.ColumnSchemas[:schemaIndex+1]
+	if metricSchema.Type != common.COLUMN_SCHEMA_TYPE_METRICS {
+		return fmt.Errorf("function [%s] argument [%s] is not a metric", name, sqlparser.String(metric))
+	}
+	e.ColumnSchemas[schemaIndex].Type = common.COLUMN_SCHEMA_TYPE_METRICS
+	e.ColumnSchemas[schemaIndex].Unit = function.Unit(metricSchema.Unit)
+
+	if e.TimeSeries == nil {
+		e.TimeSeries = &TimeSeries{}
+	}
+	e.TimeSeries.AddFunction(function)
+	e.Statements = append(e.Statements, function)
+	return nil
+}
@@ -0,0 +1,252 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package clickhouse
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/view"
+)
+
+func TestQuoteTimeSeriesDuration(t *testing.T) {
+	for _, c := range []struct {
+		input  string
+		output string
+	}{
+		{"select Compare(Sum(byte), 1d) as c from t", "select Compare(Sum(byte), '1d') as c from t"},
+		{"select Compare(Sum(byte), 1w, 'diff') from t", "select Compare(Sum(byte), '1w', 'diff') from t"},
+		{"select Compare(Sum(byte), 3600) from t", "select Compare(Sum(byte), 3600) from t"},
+		{"select MovingAvg(Sum(byte), 5) from t", "select MovingAvg(Sum(byte), 5) from t"},
+		{"select Compare(Sum(byte), 1d) from t where name = 'Compare(x, 5m)'", "select Compare(Sum(byte), '1d') from t where name = 'Compare(x, 5m)'"},
+		{"select Compare(Max(Sum(byte), 2), 5m) from t", "select Compare(Max(Sum(byte), 2), '5m') from t"},
+		{"select Compare (Sum(byte) , 1h , 'value') from t where `Compare(a, 1d)` = 1", "select Compare (Sum(byte) , '1h' , 'value') from t where `Compare(a, 1d)` = 1"},
+	} {
+		if output := QuoteTimeSeriesDuration(c.input); output != c.output {
+			t.Errorf("input %s, expected %s, got %s", c.input, c.output, output)
+		}
+	}
+}
+
+func TestNewTimeSeriesFunction(t *testing.T) {
+	f, err := NewTimeSeriesFunction(TIME_SERIES_FUNCTION_COMPARE, []string{"1d"}, "c")
+	if err != nil || f.Offset != 86400 || f.Mode != COMPARE_MODE_RATIO || f.Lookback(60) != 0 || f.Unit("byte") != "%" {
+		t.Errorf("unexpected function %+v, error %v", f, err)
+	}
+	f, err = NewTimeSeriesFunction(TIME_SERIES_FUNCTION_MOVING_AVG, []string{"5"}, "m")
+	if err != nil || f.Points != 5 || f.Lookback(60) != 240 {
+		t.Errorf("unexpected function %+v, error %v", f, err)
+	}
+	for _, c := range []struct {
+		name string
+		args []string
+	}{
+		{TIME_SERIES_FUNCTION_MOVING_AVG, nil},
+		{TIME_SERIES_FUNCTION_MOVING_AVG, []string{"0"}},
+		{TIME_SERIES_FUNCTION_COMPARE, []string{"1x"}},
+		{TIME_SERIES_FUNCTION_COMPARE, []string{"1d", "percent"}},
+		{TIME_SERIES_FUNCTION_RATE, []string{"1"}},
+		{TIME_SERIES_FUNCTION_EWMA, []string{"1.5"}},
+		{TIME_SERIES_FUNCTION_ANOMALY_SCORE, []string{"1"}},
+	} {
+		if _, err := NewTimeSeriesFunction(c.name, c.args, "x"); err == nil {
+			t.Errorf("%s%v should be invalid", c.name, c.args)
+		}
+	}
+}
+
+func TestTimeSeriesCompute(t *testing.T) {
+	times := []int64{0, 60, 120, 180, 300}
+	values := []float64{10, 20, 0, 40, 100}
+	valid := []bool{true, true, false, true, true}
+	for _, c := range []struct {
+		name     string
+		args     []string
+		expected []interface{}
+	}{
+		{TIME_SERIES_FUNCTION_DELTA, nil, []interface{}{nil, 10.0, nil, 20.0, 60.0}},
+		{TIME_SERIES_FUNCTION_RATE, nil, []interface{}{nil, 10.0 / 60, nil, 20.0 / 120, 60.0 / 120}},
+		{TIME_SERIES_FUNCTION_MOVING_AVG, []string{"2"}, []interface{}{10.0, 15.0, nil, 40.0, 100.0}},
+		{TIME_SERIES_FUNCTION_COMPARE, []string{"120", COMPARE_MODE_DIFF}, []interface{}{nil, nil, nil, 20.0, 60.0}},
+		{TIME_SERIES_FUNCTION_COMPARE, []string{"180", COMPARE_MODE_RATIO}, []interface{}{nil, nil, nil, 300.0, nil}},
+		{TIME_SERIES_FUNCTION_EWMA, []string{"0.5"}, []interface{}{10.0, 15.0, nil, 27.5, 63.75}},
+		{TIME_SERIES_FUNCTION_ANOMALY_SCORE, []string{"3"}, []interface{}{nil, nil, nil, 5.0, nil}},
+	} {
+		f, err := NewTimeSeriesFunction(c.name, c.args, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results := f.compute(times, values, valid, 60); !reflect.DeepEqual(results, c.expected) {
+			t.Errorf("%s%v expected %v, got %v", c.name, c.args, c.expected, results)
+		}
+	}
+}
+
+func TestTimeSeriesCallback(t *testing.T) {
+	m := view.NewModel()
+	m.Time.Alias = "`toi`"
+	m.Time.Interval = 60
+	s := &TimeSeries{}
+	f, _ := NewTimeSeriesFunction(TIME_SERIES_FUNCTION_DELTA, nil, "delta")
+	s.AddFunction(f)
+	s.AddTimeStart(120, ">=")
+	s.Format(m)
+	f.Format(m)
+
+	result := &common.Result{
+		Columns: []interface{}{"toi", "host", "delta"},
+		Schemas: common.ColumnSchemas{
+			{Name: "toi", Type: common.COLUMN_SCHEMA_TYPE_TAG},
+			{Name: "host", Type: common.COLUMN_SCHEMA_TYPE_TAG},
+			{Name: "delta", Type: common.COLUMN_SCHEMA_TYPE_METRICS},
+		},
+		Values: []interface{}{
+			[]interface{}{uint32(180), "a", 7.0},
+			[]interface{}{uint32(60), "a", 1.0},
+			[]interface{}{uint32(120), "b", 2.0},
+			[]interface{}{uint32(120), "a", 3.0},
+			[]interface{}{uint32(60), "b", 1.0},
+		},
+	}
+	if err := m.Callbacks["time"](result); err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		[]interface{}{uint32(180), "a", 4.0},
+		[]interface{}{uint32(120), "b", 1.0},
+		[]interface{}{uint32(120), "a", 2.0},
+	}
+	if !reflect.DeepEqual(result.Values, expected) {
+		t.Errorf("expected %v, got %v", expected, result.Values)
+	}
+}
+
+func TestTimeSeriesShiftedRanges(t *testing.T) {
+	s := &TimeSeries{}
+	for _, c := range []struct {
+		name string
+		args []string
+	}{
+		{TIME_SERIES_FUNCTION_COMPARE, []string{"1w"}},
+		{TIME_SERIES_FUNCTION_COMPARE, []string{"1d"}},
+		{TIME_SERIES_FUNCTION_COMPARE, []string{"30m"}},
+		{TIME_SERIES_FUNCTION_MOVING_AVG, []string{"11"}},
+	} {
+		f, _ := NewTimeSeriesFunction(c.name, c.args, "x")
+		s.AddFunction(f)
+	}
+	s.AddTimeStart(1000000, ">=")
+	// 1h time range, the lookback of moving average is 600s
+	expected := [][2]int64{
+		{1000000 - 604800, 1003600 - 604800 + 60},
+		{1000000 - 86400, 1003600 - 86400 + 60},
+		{1000000 - 1800, 1000000 - 600},
+	}
+	if ranges := s.ShiftedRanges(1003600, 60); !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("expected %v, got %v", expected, ranges)
+	}
+	// the shifted ranges overlapped with each other are merged
+	if ranges := s.ShiftedRanges(1000000+86400*3, 60); len(ranges) != 2 || ranges[1] != [2]int64{1000000 - 86400, 1000000 - 600} {
+		t.Errorf("unexpected ranges %v", ranges)
+	}
+}
+
+func TestTimeSeriesCallbackShifted(t *testing.T) {
+	m := view.NewModel()
+	m.Time.Alias = "`toi`"
+	m.Time.Interval = 60
+	m.Time.TimeStart = 1000
+	// the time fill drops the points out of the time range
+	m.Callbacks["time"] = func(result *common.Result) error {
+		for _, value := range result.Values {
+			if value.([]interface{})[0].(uint32) < 1000 {
+				t.Errorf("point %v is out of the time range", value)
+			}
+		}
+		return nil
+	}
+	s := &TimeSeries{}
+	f, _ := NewTimeSeriesFunction(TIME_SERIES_FUNCTION_COMPARE, []string{"10m", "diff"}, "c")
+	s.AddFunction(f)
+	s.AddTimeStart(1000, ">=")
+	s.Format(m)
+	f.Format(m)
+
+	result := &common.Result{
+		Columns: []interface{}{"toi", "c"},
+		Schemas: common.ColumnSchemas{
+			{Name: "toi", Type: common.COLUMN_SCHEMA_TYPE_TAG},
+			{Name: "c", Type: common.COLUMN_SCHEMA_TYPE_METRICS},
+		},
+		Values: []interface{}{
+			[]interface{}{uint32(1000), 5.0},
+			[]interface{}{uint32(1060), 8.0},
+			[]interface{}{uint32(400), 2.0},
+			[]interface{}{uint32(460), 3.0},
+		},
+	}
+	if err := m.Callbacks["time"](result); err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		[]interface{}{uint32(1000), 3.0},
+		[]interface{}{uint32(1060), 5.0},
+	}
+	if !reflect.DeepEqual(result.Values, expected) {
+		t.Errorf("expected %v, got %v", expected, result.Values)
+	}
+}
+
+func TestTimeSeriesLimit(t *testing.T) {
+	m := view.NewModel()
+	m.Time.Alias = "`toi`"
+	m.Time.Interval = 60
+	m.Limit.Limit, m.Limit.Offset = "2", "1"
+	s := &TimeSeries{}
+	f, _ := NewTimeSeriesFunction(TIME_SERIES_FUNCTION_DELTA, nil, "delta")
+	s.AddFunction(f)
+	s.AddTimeStart(120, ">=")
+	s.Format(m)
+	if m.Limit.Limit != common.NO_LIMIT || m.Limit.Offset != "" {
+		t.Fatalf("limit %s, offset %s should be removed from the sql", m.Limit.Limit, m.Limit.Offset)
+	}
+
+	result := &common.Result{
+		Columns: []interface{}{"toi", "delta"},
+		Schemas: common.ColumnSchemas{
+			{Name: "toi", Type: common.COLUMN_SCHEMA_TYPE_TAG},
+			{Name: "delta", Type: common.COLUMN_SCHEMA_TYPE_METRICS},
+		},
+		Values: []interface{}{
+			[]interface{}{uint32(60), 1.0},
+			[]interface{}{uint32(120), 2.0},
+			[]interface{}{uint32(180), 4.0},
+			[]interface{}{uint32(240), 7.0},
+			[]interface{}{uint32(300), 11.0},
+		},
+	}
+	if err := m.Callbacks["time"](result); err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		[]interface{}{uint32(180), 2.0},
+		[]interface{}{uint32(240), 3.0},
+	}
+	if !reflect.DeepEqual(result.Values, expected) {
+		t.Errorf("expected %v, got %v", expected, result.Values)
+	}
+}
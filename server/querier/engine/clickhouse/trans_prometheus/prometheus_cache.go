@@ -17,14 +17,13 @@
 package trans_prometheus
 
 import (
-	"fmt"
 	"time"
 
 	logging "github.com/op/go-logging"
 
-	"github.com/deepflowio/deepflow/server/controller/common"
 	"github.com/deepflowio/deepflow/server/querier/config"
 	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse/client"
+	chCommon "github.com/deepflowio/deepflow/server/querier/engine/clickhouse/common"
 )
 
 var log = logging.MustGetLogger("clickhouse.trans_prometheus")
@@ -50,16 +49,13 @@ type AppLabel struct {
 }
 
 func GenerateOrgMap() {
-	getOrgUrl := fmt.Sprintf("http://localhost:%d/v1/orgs/", config.ControllerCfg.ListenPort)
-	resp, err := common.CURLPerform("GET", getOrgUrl, nil)
+	orgIDs, err := chCommon.GetOrgIDs()
 	if err != nil {
-		log.Warningf("request controller failed: %s, URL: %s", resp, getOrgUrl)
+		log.Warning(err)
 		return
 	}
 	orgPrometheus := map[string]PrometheusMap{}
-	for i := range resp.Get("DATA").MustArray() {
-		orgIDInt := resp.Get("DATA").GetIndex(i).Get("ORG_ID").MustInt()
-		orgIDStr := fmt.Sprintf("%d", orgIDInt)
+	for _, orgIDStr := range orgIDs {
 		prometheusMap := GenerateMap(orgIDStr)
 		orgPrometheus[orgIDStr] = prometheusMap
 	}
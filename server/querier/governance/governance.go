@@ -0,0 +1,262 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package governance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/config"
+)
+
+var log = logging.MustGetLogger("querier.governance")
+
+// GetPolicy merges the default, org and user policies, the later one overrides the former one
+func GetPolicy(orgID, userID string) *config.QueryPolicy {
+	policy := &config.QueryPolicy{TableMaxTimeRange: map[string]int{}}
+	if config.Cfg == nil || !config.Cfg.QueryGovernance.Enabled {
+		return policy
+	}
+	governance := config.Cfg.QueryGovernance
+	mergePolicy(policy, governance.Default)
+	if orgID == "" {
+		orgID = common.DEFAULT_ORG_ID
+	}
+	if orgPolicy, ok := governance.Orgs[orgID]; ok {
+		mergePolicy(policy, orgPolicy)
+	}
+	if userPolicy, ok := governance.Users[userID]; ok && userID != "" {
+		mergePolicy(policy, userPolicy)
+	}
+	return policy
+}
+
+func mergePolicy(dst *config.QueryPolicy, src config.QueryPolicy) {
+	if src.MaxTimeRange != 0 {
+		dst.MaxTimeRange = src.MaxTimeRange
+	}
+	for table, timeRange := range src.TableMaxTimeRange {
+		if timeRange != 0 {
+			dst.TableMaxTimeRange[table] = timeRange
+		}
+	}
+	if src.MaxResultRows != 0 {
+		dst.MaxResultRows = src.MaxResultRows
+	}
+	if src.MaxConcurrency != 0 {
+		dst.MaxConcurrency = src.MaxConcurrency
+	}
+	if src.MaxExecutionTime != 0 {
+		dst.MaxExecutionTime = src.MaxExecutionTime
+	}
+	if src.MaxMemoryUsage != 0 {
+		dst.MaxMemoryUsage = src.MaxMemoryUsage
+	}
+}
+
+// concurrency limits of the org and the user, max-concurrency of the default and
+// org policies limits an org, while max-concurrency of the user policy limits a user
+func getConcurrencyLimits(orgID, userID string) (int, int) {
+	orgPolicy := GetPolicy(orgID, "")
+	userLimit := 0
+	if config.Cfg != nil && config.Cfg.QueryGovernance.Enabled && userID != "" {
+		userLimit = config.Cfg.QueryGovernance.Users[userID].MaxConcurrency
+	}
+	return orgPolicy.MaxConcurrency, userLimit
+}
+
+// CheckTimeRange checks time range of a query on db.table, start and end are in seconds
+func CheckTimeRange(policy *config.QueryPolicy, db, table string, start, end int64) error {
+	maxTimeRange := policy.MaxTimeRange
+	if timeRange, ok := policy.TableMaxTimeRange[fmt.Sprintf("%s.%s", db, table)]; ok {
+		maxTimeRange = timeRange
+	} else if timeRange, ok := policy.TableMaxTimeRange[table]; ok {
+		maxTimeRange = timeRange
+	}
+	if maxTimeRange <= 0 {
+		return nil
+	}
+	if end <= 0 {
+		end = time.Now().Unix()
+	}
+	if end-start > int64(maxTimeRange) {
+		return common.NewError(
+			common.QUERY_LIMIT_EXCEEDED,
+			fmt.Sprintf("time range of query on %s.%s should be less than %ds, set both start and end time in where clause", db, table, maxTimeRange),
+		)
+	}
+	return nil
+}
+
+// CheckResultRows checks row count of the result while reading
+func CheckResultRows(policy *config.QueryPolicy, rows int) error {
+	if policy.MaxResultRows > 0 && rows > policy.MaxResultRows {
+		return common.NewError(
+			common.QUERY_LIMIT_EXCEEDED,
+			fmt.Sprintf("result of query exceeds %d rows, add limit or narrow the filter", policy.MaxResultRows),
+		)
+	}
+	return nil
+}
+
+type RunningQuery struct {
+	QueryUUID string    `json:"QUERY_UUID"`
+	ORGID     string    `json:"ORG_ID"`
+	UserID    string    `json:"USER_ID"`
+	Sql       string    `json:"SQL"`
+	StartTime time.Time `json:"START_TIME"`
+
+	cancel context.CancelFunc
+	killed bool
+}
+
+type runningQueries struct {
+	sync.Mutex
+	queries    map[string][]*RunningQuery // key is query uuid
+	orgCounts  map[string]int
+	userCounts map[string]int
+}
+
+var running = &runningQueries{
+	queries:    map[string][]*RunningQuery{},
+	orgCounts:  map[string]int{},
+	userCounts: map[string]int{},
+}
+
+// Begin checks concurrency limits and registers the query so that it can be killed,
+// End of the returned query should be called when the query finishes.
+func Begin(ctx context.Context, queryUUID, orgID, userID, sql string) (context.Context, *RunningQuery, error) {
+	if orgID == "" {
+		orgID = common.DEFAULT_ORG_ID
+	}
+	orgLimit, userLimit := getConcurrencyLimits(orgID, userID)
+
+	running.Lock()
+	defer running.Unlock()
+	if orgLimit > 0 && running.orgCounts[orgID] >= orgLimit {
+		return ctx, nil, common.NewError(
+			common.TOO_MANY_QUERIES,
+			fmt.Sprintf("org (%s) has %d running queries, exceeds the limit", orgID, running.orgCounts[orgID]),
+		)
+	}
+	if userLimit > 0 && running.userCounts[userID] >= userLimit {
+		return ctx, nil, common.NewError(
+			common.TOO_MANY_QUERIES,
+			fmt.Sprintf("user (%s) has %d running queries, exceeds the limit", userID, running.userCounts[userID]),
+		)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	q := &RunningQuery{
+		QueryUUID: queryUUID,
+		ORGID:     orgID,
+		UserID:    userID,
+		Sql:       sql,
+		StartTime: time.Now(),
+		cancel:    cancel,
+	}
+	running.queries[queryUUID] = append(running.queries[queryUUID], q)
+	running.orgCounts[orgID]++
+	if userID != "" {
+		running.userCounts[userID]++
+	}
+	return ctx, q, nil
+}
+
+func (q *RunningQuery) End() {
+	q.cancel()
+	running.Lock()
+	defer running.Unlock()
+	queries := running.queries[q.QueryUUID]
+	for i, query := range queries {
+		if query == q {
+			queries = append(queries[:i], queries[i+1:]...)
+			break
+		}
+	}
+	if len(queries) == 0 {
+		delete(running.queries, q.QueryUUID)
+	} else {
+		running.queries[q.QueryUUID] = queries
+	}
+	if running.orgCounts[q.ORGID]--; running.orgCounts[q.ORGID] <= 0 {
+		delete(running.orgCounts, q.ORGID)
+	}
+	if q.UserID != "" {
+		if running.userCounts[q.UserID]--; running.userCounts[q.UserID] <= 0 {
+			delete(running.userCounts, q.UserID)
+		}
+	}
+}
+
+// Err converts the error of a killed query into a readable one
+func (q *RunningQuery) Err(err error) error {
+	running.Lock()
+	defer running.Unlock()
+	if q.killed {
+		return common.NewError(common.QUERY_KILLED, fmt.Sprintf("query (%s) was killed", q.QueryUUID))
+	}
+	return err
+}
+
+// List returns running queries of the org, sorted by start time
+func List(orgID string) []RunningQuery {
+	if orgID == "" {
+		orgID = common.DEFAULT_ORG_ID
+	}
+	running.Lock()
+	defer running.Unlock()
+	var result []RunningQuery
+	for _, queries := range running.queries {
+		for _, q := range queries {
+			if q.ORGID == orgID {
+				result = append(result, *q)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+	return result
+}
+
+// Kill cancels all running clickhouse queries of the query uuid in the org, and returns the number of them
+func Kill(queryUUID, orgID string) (int, error) {
+	if orgID == "" {
+		orgID = common.DEFAULT_ORG_ID
+	}
+	running.Lock()
+	defer running.Unlock()
+	killed := 0
+	for _, q := range running.queries[queryUUID] {
+		if q.ORGID != orgID {
+			continue
+		}
+		q.killed = true
+		q.cancel()
+		killed++
+	}
+	if killed == 0 {
+		return 0, common.NewError(common.RESOURCE_NOT_FOUND, fmt.Sprintf("query (%s) is not running", queryUUID))
+	}
+	log.Infof("org (%s) killed query (%s), %d clickhouse queries canceled", orgID, queryUUID, killed)
+	return killed, nil
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package governance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/config"
+)
+
+func setGovernance(governance config.QueryGovernance) func() {
+	origin := config.Cfg
+	config.Cfg = &config.QuerierConfig{QueryGovernance: governance}
+	return func() { config.Cfg = origin }
+}
+
+func TestGetPolicy(t *testing.T) {
+	defer setGovernance(config.QueryGovernance{
+		Enabled: true,
+		Default: config.QueryPolicy{
+			MaxTimeRange:      86400,
+			TableMaxTimeRange: map[string]int{"l7_flow_log": 3600},
+			MaxResultRows:     10000,
+			MaxExecutionTime:  60,
+		},
+		Orgs: map[string]config.QueryPolicy{
+			"2": {MaxResultRows: -1, TableMaxTimeRange: map[string]int{"l7_flow_log": 7200}},
+		},
+		Users: map[string]config.QueryPolicy{
+			"10": {MaxExecutionTime: 10},
+		},
+	})()
+
+	policy := GetPolicy("", "")
+	if policy.MaxResultRows != 10000 || policy.TableMaxTimeRange["l7_flow_log"] != 3600 {
+		t.Errorf("default policy %+v", policy)
+	}
+	policy = GetPolicy("2", "10")
+	if policy.MaxResultRows != -1 || policy.TableMaxTimeRange["l7_flow_log"] != 7200 ||
+		policy.MaxExecutionTime != 10 || policy.MaxTimeRange != 86400 {
+		t.Errorf("merged policy %+v", policy)
+	}
+
+	config.Cfg.QueryGovernance.Enabled = false
+	if policy = GetPolicy("2", "10"); policy.MaxResultRows != 0 || policy.MaxTimeRange != 0 {
+		t.Errorf("disabled policy %+v", policy)
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	policy := &config.QueryPolicy{
+		MaxTimeRange:      86400,
+		TableMaxTimeRange: map[string]int{"l7_flow_log": 3600, "flow_metrics.application": -1},
+		MaxResultRows:     100,
+	}
+	cases := []struct {
+		db, table  string
+		start, end int64
+		exceeded   bool
+	}{
+		{"flow_log", "l7_flow_log", 1000, 4600, false},
+		{"flow_log", "l7_flow_log", 1000, 4601, true},
+		{"flow_log", "l7_flow_log", 0, 0, true},
+		{"flow_log", "l4_flow_log", 1000, 7200, false},
+		{"flow_metrics", "application", 0, 0, false},
+		{"flow_metrics", "network", time.Now().Unix() - 3600, 0, false},
+	}
+	for _, c := range cases {
+		err := CheckTimeRange(policy, c.db, c.table, c.start, c.end)
+		if (err != nil) != c.exceeded {
+			t.Errorf("CheckTimeRange(%s.%s, %d, %d) = %v", c.db, c.table, c.start, c.end, err)
+		}
+	}
+	if CheckResultRows(policy, 100) != nil || CheckResultRows(policy, 101) == nil {
+		t.Error("CheckResultRows failed")
+	}
+}
+
+func TestConcurrencyAndKill(t *testing.T) {
+	defer setGovernance(config.QueryGovernance{
+		Enabled: true,
+		Default: config.QueryPolicy{MaxConcurrency: 2},
+		Users:   map[string]config.QueryPolicy{"10": {MaxConcurrency: 1}},
+	})()
+
+	ctx1, q1, err := Begin(context.Background(), "uuid-1", "", "10", "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Begin(context.Background(), "uuid-2", "", "10", "SELECT 1"); err == nil {
+		t.Error("user concurrency limit not applied")
+	}
+	_, q3, err := Begin(context.Background(), "uuid-3", "1", "11", "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Begin(context.Background(), "uuid-4", "1", "", "SELECT 1"); err == nil {
+		t.Error("org concurrency limit not applied")
+	}
+	if _, q, err := Begin(context.Background(), "uuid-5", "2", "", "SELECT 1"); err != nil {
+		t.Error("org concurrency limit applied to another org")
+	} else {
+		defer q.End()
+	}
+	if queries := List(""); len(queries) != 2 {
+		t.Errorf("running queries %+v", queries)
+	}
+
+	if _, err := Kill("uuid-1", "2"); err == nil {
+		t.Error("query killed by another org")
+	}
+	if killed, err := Kill("uuid-1", "1"); err != nil || killed != 1 {
+		t.Errorf("Kill() = %d, %v", killed, err)
+	}
+	if ctx1.Err() == nil {
+		t.Error("context of killed query not canceled")
+	}
+	err = q1.Err(ctx1.Err())
+	if e, ok := err.(*common.ServiceError); !ok || e.Status != common.QUERY_KILLED {
+		t.Errorf("Err() = %v", err)
+	}
+	q1.End()
+	q3.End()
+	if _, q, err := Begin(context.Background(), "uuid-6", "1", "10", "SELECT 1"); err != nil {
+		t.Errorf("limit not released: %v", err)
+	} else {
+		q.End()
+	}
+}
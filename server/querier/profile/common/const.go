@@ -51,3 +51,35 @@ var LOCATION_TYPE_MAP = map[string]string{
 	"[p] ": "P", // process
 	"[t] ": "T", // thread
 }
+
+const (
+	PPROF_DEFAULT_UNIT = "count"
+	PPROF_FILE_NAME    = "profile.pb.gz"
+)
+
+// unit of profile_value for each profile_event_type, used as the sample type
+// unit of exported pprof profiles
+var PROFILE_EVENT_TYPE_UNIT_MAP = map[string]string{
+	"cpu":                        "samples",
+	"inuse_objects":              "objects",
+	"alloc_objects":              "objects",
+	"inuse_space":                "bytes",
+	"alloc_space":                "bytes",
+	"goroutines":                 "goroutines",
+	"mutex_duration":             "nanoseconds",
+	"mutex_count":                "count",
+	"block_duration":             "nanoseconds",
+	"block_count":                "count",
+	"itimer":                     "samples",
+	"wall":                       "samples",
+	"alloc_in_new_tlab_objects":  "objects",
+	"alloc_in_new_tlab_bytes":    "bytes",
+	"alloc_outside_tlab_objects": "objects",
+	"alloc_outside_tlab_bytes":   "bytes",
+	"lock_count":                 "count",
+	"lock_duration":              "nanoseconds",
+	"on-cpu":                     "microseconds",
+	"off-cpu":                    "microseconds",
+	"mem-alloc":                  "bytes",
+	"mem-inuse":                  "bytes",
+}
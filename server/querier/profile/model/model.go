@@ -32,6 +32,18 @@ type Profile struct {
 	MaxKernelStackDepth *int `json:"max_kernel_stack_depth"` // default: -1
 }
 
+// ProfileDiff compares a baseline profile with the comparison profile described
+// by the embedded Profile. Baseline fields that are not set inherit the values
+// of the comparison, so a diff may differ by time range, tag filter or both.
+type ProfileDiff struct {
+	Profile
+	BaselineTimeStart int     `json:"baseline_time_start"`
+	BaselineTimeEnd   int     `json:"baseline_time_end"`
+	BaselineTagFilter *string `json:"baseline_tag_filter"`
+	// scale baseline values to the comparison total before computing deltas
+	Normalize bool `json:"normalize"`
+}
+
 type ProfileGrafana struct {
 	Sql              string `json:"sql" binding:"required"` // profile filter
 	ProfileEventType string `json:"profile_event_type" binding:"required"`
@@ -66,6 +78,13 @@ type ProfileTree struct {
 	NodeValues     Value    `json:"node_values"`
 }
 
+type ProfileDiffTree struct {
+	Functions      []string `json:"functions"`
+	FunctionTypes  []string `json:"function_types"`
+	FunctionValues Value    `json:"function_values"`
+	NodeValues     Value    `json:"node_values"`
+}
+
 type Value struct {
 	Columns []string `json:"columns"`
 	Values  [][]int  `json:"values"`
@@ -17,6 +17,8 @@
 package router
 
 import (
+	"fmt"
+	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -32,6 +34,17 @@ import (
 func ProfileRouter(e *gin.Engine, cfg *config.QuerierConfig) {
 	e.POST("/v1/profile/ProfileTracing", profile(cfg))
 	e.POST("/v1/profile/ProfileGrafana", profileGrafana(cfg))
+	e.POST("/v1/profile/ProfileDiff", profileDiff(cfg))
+	e.POST("/v1/profile/ProfilePprof", profilePprof(cfg))
+}
+
+func setProfileDefaults(c *gin.Context, args *model.Profile) {
+	args.Context = c.Request.Context()
+	args.OrgID = c.Request.Header.Get(common.HEADER_KEY_X_ORG_ID)
+	if args.MaxKernelStackDepth == nil {
+		var maxKernelStackDepth = common.MAX_KERNEL_STACK_DEPTH_DEFAULT
+		args.MaxKernelStackDepth = &maxKernelStackDepth
+	}
 }
 
 func profile(cfg *config.QuerierConfig) gin.HandlerFunc {
@@ -44,12 +57,7 @@ func profile(cfg *config.QuerierConfig) gin.HandlerFunc {
 			router.BadRequestResponse(c, common.INVALID_POST_DATA, err.Error())
 			return
 		}
-		args.Context = c.Request.Context()
-		args.OrgID = c.Request.Header.Get(common.HEADER_KEY_X_ORG_ID)
-		if args.MaxKernelStackDepth == nil {
-			var maxKernelStackDepth = common.MAX_KERNEL_STACK_DEPTH_DEFAULT
-			args.MaxKernelStackDepth = &maxKernelStackDepth
-		}
+		setProfileDefaults(c, &args)
 		result, debug, err := service.Profile(args, cfg)
 		if err == nil && !args.Debug {
 			debug = nil
@@ -79,3 +87,45 @@ func profileGrafana(cfg *config.QuerierConfig) gin.HandlerFunc {
 		router.JsonResponse(c, result, debug, err)
 	})
 }
+
+func profileDiff(cfg *config.QuerierConfig) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		var args model.ProfileDiff
+
+		err := c.ShouldBindBodyWith(&args, binding.JSON)
+		if err != nil {
+			router.BadRequestResponse(c, common.INVALID_POST_DATA, err.Error())
+			return
+		}
+		if args.BaselineTimeStart > 0 && args.BaselineTimeEnd > 0 && args.BaselineTimeStart > args.BaselineTimeEnd {
+			router.BadRequestResponse(c, common.INVALID_PARAMETERS, "baseline_time_start is after baseline_time_end")
+			return
+		}
+		setProfileDefaults(c, &args.Profile)
+		result, debug, err := service.ProfileDiff(args, cfg)
+		if err == nil && !args.Debug {
+			debug = nil
+		}
+		router.JsonResponse(c, result, debug, err)
+	})
+}
+
+func profilePprof(cfg *config.QuerierConfig) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		var args model.Profile
+
+		err := c.ShouldBindBodyWith(&args, binding.JSON)
+		if err != nil {
+			router.BadRequestResponse(c, common.INVALID_POST_DATA, err.Error())
+			return
+		}
+		setProfileDefaults(c, &args)
+		result, debug, err := service.ProfilePprof(args, cfg)
+		if err != nil {
+			router.JsonResponse(c, nil, debug, err)
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", common.PPROF_FILE_NAME))
+		c.Data(http.StatusOK, "application/octet-stream", result)
+	})
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+
+	"github.com/deepflowio/deepflow/server/querier/config"
+	"github.com/deepflowio/deepflow/server/querier/profile/common"
+	"github.com/deepflowio/deepflow/server/querier/profile/model"
+)
+
+// ProfilePprof runs the same query as Profile and renders the result as a
+// gzipped pprof profile.proto, which can be opened by `go tool pprof`.
+func ProfilePprof(args model.Profile, cfg *config.QuerierConfig) (result []byte, debug interface{}, err error) {
+	profileTree, debug, err := Profile(args, cfg)
+	if err != nil {
+		return
+	}
+	result, err = EncodePprof(profileTree, args.ProfileEventType, args.TimeStart, args.TimeEnd)
+	return
+}
+
+type pprofStringTable struct {
+	strings []string
+	index   map[string]int64
+}
+
+func newPprofStringTable() *pprofStringTable {
+	return &pprofStringTable{strings: []string{""}, index: map[string]int64{"": 0}}
+}
+
+func (t *pprofStringTable) id(s string) int64 {
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := int64(len(t.strings))
+	t.strings = append(t.strings, s)
+	t.index[s] = i
+	return i
+}
+
+// EncodePprof converts a profile tree to a gzipped pprof profile. Every
+// function of the tree becomes one function and one location, and every node
+// with a self value becomes one sample whose stack runs from that node up to,
+// but not including, the synthetic root node.
+func EncodePprof(profileTree model.ProfileTree, profileEventType string, timeStart, timeEnd int) ([]byte, error) {
+	strs := newPprofStringTable()
+	unit, ok := common.PROFILE_EVENT_TYPE_UNIT_MAP[profileEventType]
+	if !ok {
+		unit = common.PPROF_DEFAULT_UNIT
+	}
+	valueType := &tree.ValueType{Type: strs.id(profileEventType), Unit: strs.id(unit)}
+	p := &tree.Profile{
+		SampleType:    []*tree.ValueType{valueType},
+		PeriodType:    &tree.ValueType{Type: valueType.Type, Unit: valueType.Unit},
+		TimeNanos:     int64(timeStart) * int64(time.Second),
+		DurationNanos: int64(timeEnd-timeStart) * int64(time.Second),
+	}
+
+	nodes := profileTree.NodeValues.Values
+	// pprof ids must be non-zero, function i of the tree uses id i+1
+	usedFunctions := make([]bool, len(profileTree.Functions))
+	for i, node := range nodes {
+		if node[1] < 0 || node[2] == 0 {
+			continue
+		}
+		// leaf first, stop before the root
+		var locationIDs []uint64
+		for nodeID := i; nodes[nodeID][1] >= 0; nodeID = nodes[nodeID][1] {
+			locationIDs = append(locationIDs, uint64(nodes[nodeID][0])+1)
+		}
+		for _, id := range locationIDs {
+			usedFunctions[id-1] = true
+		}
+		p.Sample = append(p.Sample, &tree.Sample{LocationId: locationIDs, Value: []int64{int64(node[2])}})
+	}
+
+	for i, function := range profileTree.Functions {
+		if !usedFunctions[i] {
+			continue
+		}
+		id := uint64(i) + 1
+		name := strs.id(function)
+		p.Function = append(p.Function, &tree.Function{Id: id, Name: name, SystemName: name})
+		p.Location = append(p.Location, &tree.Location{Id: id, Line: []*tree.Line{{FunctionId: id}}})
+	}
+	p.StringTable = strs.strings
+
+	data, err := p.MarshalVT()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -47,6 +47,10 @@ const (
 )
 
 func Profile(args model.Profile, cfg *config.QuerierConfig) (result model.ProfileTree, debug interface{}, err error) {
+	return GenerateProfile(args, cfg, profileWhere(args))
+}
+
+func profileWhere(args model.Profile) string {
 	whereSlice := []string{}
 	whereSlice = append(whereSlice, fmt.Sprintf(" time>=%d", args.TimeStart))
 	whereSlice = append(whereSlice, fmt.Sprintf(" time<=%d", args.TimeEnd))
@@ -56,8 +60,7 @@ func Profile(args model.Profile, cfg *config.QuerierConfig) (result model.Profil
 	if args.TagFilter != "" {
 		whereSlice = append(whereSlice, " ("+args.TagFilter+")")
 	}
-	whereSql := strings.Join(whereSlice, " AND")
-	return GenerateProfile(args, cfg, whereSql)
+	return strings.Join(whereSlice, " AND")
 }
 
 func GenerateProfile(args model.Profile, cfg *config.QuerierConfig, where string) (result model.ProfileTree, debug interface{}, err error) {
@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package service
+
+import (
+	"math"
+
+	"github.com/deepflowio/deepflow/server/querier/config"
+	"github.com/deepflowio/deepflow/server/querier/profile/model"
+)
+
+// column offsets of the baseline and comparison values in a diff node
+const (
+	diffBaselineOffset   = 2
+	diffComparisonOffset = 4
+)
+
+func ProfileDiff(args model.ProfileDiff, cfg *config.QuerierConfig) (result model.ProfileDiffTree, debug interface{}, err error) {
+	comparisonArgs := args.Profile
+	baselineArgs := args.Profile
+	if args.BaselineTimeStart > 0 {
+		baselineArgs.TimeStart = args.BaselineTimeStart
+	}
+	if args.BaselineTimeEnd > 0 {
+		baselineArgs.TimeEnd = args.BaselineTimeEnd
+	}
+	if args.BaselineTagFilter != nil {
+		baselineArgs.TagFilter = *args.BaselineTagFilter
+	}
+
+	debugs := model.ProfileDebug{}
+	baseline, baselineDebug, err := GenerateProfile(baselineArgs, cfg, profileWhere(baselineArgs))
+	appendProfileDebug(&debugs, baselineDebug)
+	if err != nil {
+		debug = debugs
+		return
+	}
+	comparison, comparisonDebug, err := GenerateProfile(comparisonArgs, cfg, profileWhere(comparisonArgs))
+	appendProfileDebug(&debugs, comparisonDebug)
+	debug = debugs
+	if err != nil {
+		return
+	}
+
+	result = DiffProfileTree(baseline, comparison, args.AppService, args.ProfileEventType, args.Normalize)
+	return
+}
+
+func appendProfileDebug(debugs *model.ProfileDebug, debug interface{}) {
+	if d, ok := debug.(model.ProfileDebug); ok {
+		debugs.QuerierDebug = append(debugs.QuerierDebug, d.QuerierDebug...)
+		debugs.FormatTime = d.FormatTime
+	}
+}
+
+type diffNodeKey struct {
+	parentNodeID int
+	function     string
+}
+
+type profileTreeMerger struct {
+	functions    []string
+	functionToID map[string]int
+	keyToNodeID  map[diffNodeKey]int
+	nodes        [][]int
+}
+
+// DiffProfileTree merges two profile trees by call path. Nodes of both trees
+// that share the same stack from the root end up in the same diff node, which
+// carries the baseline values, the comparison values and their deltas.
+// With normalize, baseline values are scaled so that both roots have the same
+// total value, which makes profiles of different time ranges comparable.
+func DiffProfileTree(baseline, comparison model.ProfileTree, root, profileEventType string, normalize bool) (result model.ProfileDiffTree) {
+	m := &profileTreeMerger{
+		functions:    []string{root},
+		functionToID: map[string]int{root: 0},
+		keyToNodeID:  make(map[diffNodeKey]int),
+		nodes:        [][]int{newDiffNode(0, -1)},
+	}
+
+	scale := 1.0
+	if normalize {
+		baselineTotal, comparisonTotal := rootTotalValue(baseline), rootTotalValue(comparison)
+		if baselineTotal > 0 && comparisonTotal > 0 {
+			scale = float64(comparisonTotal) / float64(baselineTotal)
+		}
+	}
+	m.merge(baseline, diffBaselineOffset, scale)
+	m.merge(comparison, diffComparisonOffset, 1)
+
+	functionValues := make([][]int, len(m.functions))
+	for i := range functionValues {
+		functionValues[i] = make([]int, 4)
+	}
+	for _, node := range m.nodes {
+		node[6] = node[4] - node[2]
+		node[7] = node[5] - node[3]
+		values := functionValues[node[0]]
+		for i := range values {
+			values[i] += node[2+i]
+		}
+	}
+
+	// function types are decided by the values of both profiles
+	typeValues := make([][]int, len(functionValues))
+	for i, values := range functionValues {
+		typeValues[i] = []int{values[0] + values[2], values[1] + values[3]}
+	}
+
+	result.Functions = m.functions
+	result.FunctionTypes = GetLocationType(m.functions, typeValues, profileEventType)
+	result.FunctionValues.Columns = []string{"baseline_self_value", "baseline_total_value", "self_value", "total_value"}
+	result.FunctionValues.Values = functionValues
+	result.NodeValues.Columns = []string{
+		"function_id", "parent_node_id",
+		"baseline_self_value", "baseline_total_value", "self_value", "total_value",
+		"self_delta", "total_delta",
+	}
+	result.NodeValues.Values = m.nodes
+	return
+}
+
+func newDiffNode(functionID, parentNodeID int) []int {
+	return []int{functionID, parentNodeID, 0, 0, 0, 0, 0, 0}
+}
+
+func rootTotalValue(tree model.ProfileTree) int {
+	if len(tree.NodeValues.Values) == 0 {
+		return 0
+	}
+	return tree.NodeValues.Values[0][3]
+}
+
+func (m *profileTreeMerger) merge(tree model.ProfileTree, offset int, scale float64) {
+	treeNodes := tree.NodeValues.Values
+	if len(treeNodes) == 0 {
+		return
+	}
+	// parents may be stored after their children, resolve them on demand
+	mapped := make([]int, len(treeNodes))
+	for i := range mapped {
+		mapped[i] = -1
+	}
+	var resolve func(int) int
+	resolve = func(i int) int {
+		if mapped[i] >= 0 {
+			return mapped[i]
+		}
+		node := treeNodes[i]
+		if node[1] < 0 { // root
+			mapped[i] = 0
+			return 0
+		}
+		parentNodeID := resolve(node[1])
+		key := diffNodeKey{parentNodeID, tree.Functions[node[0]]}
+		nodeID, ok := m.keyToNodeID[key]
+		if !ok {
+			functionID, ok := m.functionToID[key.function]
+			if !ok {
+				functionID = len(m.functions)
+				m.functionToID[key.function] = functionID
+				m.functions = append(m.functions, key.function)
+			}
+			nodeID = len(m.nodes)
+			m.keyToNodeID[key] = nodeID
+			m.nodes = append(m.nodes, newDiffNode(functionID, parentNodeID))
+		}
+		mapped[i] = nodeID
+		return nodeID
+	}
+
+	for i, node := range treeNodes {
+		// resolve appends to m.nodes, index it after the call
+		nodeID := resolve(i)
+		diffNode := m.nodes[nodeID]
+		diffNode[offset] += scaleValue(node[2], scale)
+		diffNode[offset+1] += scaleValue(node[3], scale)
+	}
+}
+
+func scaleValue(value int, scale float64) int {
+	if scale == 1 {
+		return value
+	}
+	return int(math.Round(float64(value) * scale))
+}
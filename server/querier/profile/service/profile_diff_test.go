@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+
+	"github.com/deepflowio/deepflow/server/querier/profile/model"
+)
+
+// root -> a -> b (self 3), root -> a (self 1)
+func testBaselineTree() model.ProfileTree {
+	return model.ProfileTree{
+		Functions: []string{"app", "b", "a"},
+		NodeValues: model.Value{Values: [][]int{
+			{0, -1, 0, 4},
+			{1, 2, 3, 3}, // child stored before its parent
+			{2, 0, 1, 4},
+		}},
+	}
+}
+
+// root -> a -> b (self 2), root -> c (self 6)
+func testComparisonTree() model.ProfileTree {
+	return model.ProfileTree{
+		Functions: []string{"app", "a", "b", "c"},
+		NodeValues: model.Value{Values: [][]int{
+			{0, -1, 0, 8},
+			{1, 0, 0, 2},
+			{2, 1, 2, 2},
+			{3, 0, 6, 6},
+		}},
+	}
+}
+
+func TestDiffProfileTree(t *testing.T) {
+	result := DiffProfileTree(testBaselineTree(), testComparisonTree(), "app", "on-cpu", false)
+	if !reflect.DeepEqual(result.Functions, []string{"app", "a", "b", "c"}) {
+		t.Fatalf("unexpected functions %v", result.Functions)
+	}
+	expected := [][]int{
+		{0, -1, 0, 4, 0, 8, 0, 4},
+		{1, 0, 1, 4, 0, 2, -1, -2},
+		{2, 1, 3, 3, 2, 2, -1, -1},
+		{3, 0, 0, 0, 6, 6, 6, 6},
+	}
+	if !reflect.DeepEqual(result.NodeValues.Values, expected) {
+		t.Errorf("unexpected nodes %v", result.NodeValues.Values)
+	}
+
+	result = DiffProfileTree(testBaselineTree(), testComparisonTree(), "app", "on-cpu", true)
+	if root := result.NodeValues.Values[0]; root[3] != 8 || root[7] != 0 {
+		t.Errorf("baseline is not normalized: %v", root)
+	}
+	if b := result.NodeValues.Values[2]; b[2] != 6 || b[6] != -4 {
+		t.Errorf("unexpected normalized node %v", b)
+	}
+}
+
+func TestEncodePprof(t *testing.T) {
+	data, err := EncodePprof(testBaselineTree(), "on-cpu", 100, 160)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &tree.Profile{}
+	if err := p.UnmarshalVT(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.SampleType) != 1 || p.StringTable[p.SampleType[0].Type] != "on-cpu" || p.StringTable[p.SampleType[0].Unit] != "microseconds" {
+		t.Errorf("unexpected sample type %v", p.SampleType)
+	}
+	if p.DurationNanos != 60e9 {
+		t.Errorf("unexpected duration %d", p.DurationNanos)
+	}
+	stacks := map[string]int64{}
+	for _, s := range p.Sample {
+		stack := ""
+		for _, id := range s.LocationId {
+			for _, f := range p.Function {
+				if f.Id == id {
+					stack += p.StringTable[f.Name] + ";"
+				}
+			}
+		}
+		stacks[stack] = s.Value[0]
+	}
+	if !reflect.DeepEqual(stacks, map[string]int64{"b;a;": 3, "a;": 1}) {
+		t.Errorf("unexpected samples %v", stacks)
+	}
+	if len(p.Location) != 2 || len(p.Function) != 2 {
+		t.Errorf("expected 2 locations and functions, got %d and %d", len(p.Location), len(p.Function))
+	}
+}
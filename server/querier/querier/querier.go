@@ -32,6 +32,7 @@ import (
 	"github.com/deepflowio/deepflow/server/libs/stats"
 	distributed_tracing "github.com/deepflowio/deepflow/server/querier/app/distributed_tracing/router"
 	"github.com/deepflowio/deepflow/server/querier/app/distributed_tracing/service/tracemap"
+	loki_router "github.com/deepflowio/deepflow/server/querier/app/loki/router"
 	prometheus_router "github.com/deepflowio/deepflow/server/querier/app/prometheus/router"
 	tracing_adapter "github.com/deepflowio/deepflow/server/querier/app/tracing-adapter/router"
 	"github.com/deepflowio/deepflow/server/querier/common"
@@ -91,6 +92,7 @@ func Start(configPath, serverLogFile string, shared *servercommon.ControllerInge
 	router.QueryRouter(r)
 	profile_router.ProfileRouter(r, &cfg)
 	prometheus_router.PrometheusRouter(r)
+	loki_router.LokiRouter(r)
 	tracing_adapter.TracingAdapterRouter(r)
 	distributed_tracing.TraceMapRouter(r, &cfg, tracemap_generator)
 	registerRouterCounter(r.Routes())
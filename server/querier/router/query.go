@@ -23,11 +23,14 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/governance"
 	"github.com/deepflowio/deepflow/server/querier/service"
 )
 
 func QueryRouter(e *gin.Engine) {
 	e.POST("/v1/query/", executeQuery())
+	e.GET("/v1/query/running/", listRunningQueries())
+	e.POST("/v1/query/kill/", killQuery())
 
 	// api router for tempo
 	e.GET("/api/traces/:traceId", tempoTraceReader())
@@ -48,6 +51,7 @@ func executeQuery() gin.HandlerFunc {
 		args.QueryUUID = c.Query("query_uuid")
 		args.NoPreWhere, _ = strconv.ParseBool(c.DefaultQuery("no_prewhere", "false"))
 		args.ORGID = c.Request.Header.Get(common.HEADER_KEY_X_ORG_ID)
+		args.UserID = c.Request.Header.Get(common.HEADER_KEY_X_USER_ID)
 		args.Language = c.Request.Header.Get(common.HEADER_KEY_LANGUAGE)
 		// if no org_id in header, set default org id
 		if args.ORGID == "" {
@@ -82,3 +86,31 @@ func executeQuery() gin.HandlerFunc {
 		JsonResponse(c, result, debug, err)
 	})
 }
+
+func listRunningQueries() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		orgID := c.Request.Header.Get(common.HEADER_KEY_X_ORG_ID)
+		JsonResponse(c, governance.List(orgID), nil, nil)
+	})
+}
+
+func killQuery() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		orgID := c.Request.Header.Get(common.HEADER_KEY_X_ORG_ID)
+		queryUUID := c.Query("query_uuid")
+		if queryUUID == "" {
+			queryUUID = c.PostForm("query_uuid")
+		}
+		if queryUUID == "" {
+			json := make(map[string]interface{})
+			c.BindJSON(&json)
+			queryUUID, _ = json["query_uuid"].(string)
+		}
+		if queryUUID == "" {
+			BadRequestResponse(c, common.INVALID_PARAMETERS, "query_uuid is required")
+			return
+		}
+		killed, err := governance.Kill(queryUUID, orgID)
+		JsonResponse(c, map[string]interface{}{"QUERY_UUID": queryUUID, "KILLED": killed}, nil, err)
+	})
+}
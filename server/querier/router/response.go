@@ -70,8 +70,10 @@ func JsonResponse(c *gin.Context, data interface{}, debug interface{}, err error
 		case *common.ServiceError:
 			switch t.Status {
 			case common.RESOURCE_NOT_FOUND, common.INVALID_POST_DATA, common.RESOURCE_NUM_EXCEEDED,
-				common.SELECTED_RESOURCES_NUM_EXCEEDED:
+				common.SELECTED_RESOURCES_NUM_EXCEEDED, common.QUERY_LIMIT_EXCEEDED, common.QUERY_KILLED:
 				BadRequestResponse(c, t.Status, t.Message)
+			case common.TOO_MANY_QUERIES:
+				HttpResponse(c, http.StatusTooManyRequests, data, debug, t.Status, t.Message)
 			case common.SERVER_ERROR:
 				InternalErrorResponse(c, data, debug, t.Status, t.Message)
 			}
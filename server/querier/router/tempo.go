@@ -70,10 +70,19 @@ func tempoSearchReader() gin.HandlerFunc {
 			StartTime:   c.Query("start"),
 			EndTime:     c.Query("end"),
 			Debug:       c.Query("debug"),
+			Query:       c.Query("q"),
+			SpssLimit:   c.Query("spss"),
 			Context:     c.Request.Context(),
 		}
-		args.SetFilters(c.Query("tags"))
-		result, _, err := tempo.TraceSearch(&args)
+		var result map[string]interface{}
+		var err error
+		if args.Query != "" {
+			// TraceQL search sent by Grafana, tags are ignored
+			result, _, err = tempo.TraceQLSearch(&args)
+		} else {
+			args.SetFilters(c.Query("tags"))
+			result, _, err = tempo.TraceSearch(&args)
+		}
 		if err != nil {
 			c.JSON(500, err)
 			return
@@ -0,0 +1,566 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tempo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TraceQL grammar supported by the Tempo compatible search API:
+//
+//	query          := spanset { '|' 'select' '(' attribute { ',' attribute } ')' }
+//	spanset        := spansetAnd { '||' spansetAnd }
+//	spansetAnd     := spansetStruct { '&&' spansetStruct }
+//	spansetStruct  := spansetPrimary { ( '>>' | '>' | '~' ) spansetPrimary }
+//	spansetPrimary := '{' [ field ] '}' | '(' spanset ')'
+//	field          := fieldAnd { '||' fieldAnd }
+//	fieldAnd       := fieldUnary { '&&' fieldUnary }
+//	fieldUnary     := '!' fieldUnary | '(' field ')' | attribute op static
+//
+// Attributes are `span.xxx`, `resource.xxx`, `.xxx` or one of the intrinsics
+// name, status, statusMessage, duration and kind (optionally prefixed with `span:`).
+
+const (
+	TRACEQL_SCOPE_NONE     = ""
+	TRACEQL_SCOPE_SPAN     = "span"
+	TRACEQL_SCOPE_RESOURCE = "resource"
+
+	TRACEQL_INTRINSIC_NAME           = "name"
+	TRACEQL_INTRINSIC_STATUS         = "status"
+	TRACEQL_INTRINSIC_STATUS_MESSAGE = "statusMessage"
+	TRACEQL_INTRINSIC_DURATION       = "duration"
+	TRACEQL_INTRINSIC_KIND           = "kind"
+)
+
+var traceQLIntrinsics = map[string]bool{
+	TRACEQL_INTRINSIC_NAME:           true,
+	TRACEQL_INTRINSIC_STATUS:         true,
+	TRACEQL_INTRINSIC_STATUS_MESSAGE: true,
+	TRACEQL_INTRINSIC_DURATION:       true,
+	TRACEQL_INTRINSIC_KIND:           true,
+}
+
+// values of the `status` intrinsic
+var traceQLStatuses = map[string]bool{
+	"ok":    true,
+	"error": true,
+	"unset": true,
+}
+
+// values of the `kind` intrinsic, same as the span_kind enum of l7_flow_log
+var traceQLKinds = map[string]uint8{
+	"unspecified": 0,
+	"internal":    1,
+	"server":      2,
+	"client":      3,
+	"producer":    4,
+	"consumer":    5,
+}
+
+type traceQLTokenType int
+
+const (
+	tokEOF traceQLTokenType = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokDuration
+	tokLBrace
+	tokRBrace
+	tokLParen
+	tokRParen
+	tokPipe
+	tokComma
+	tokAnd
+	tokOr
+	tokNot
+	tokEq
+	tokNeq
+	tokGt
+	tokGte
+	tokLt
+	tokLte
+	tokRe
+	tokNre
+	tokDescendant
+	tokSibling
+)
+
+type traceQLToken struct {
+	typ  traceQLTokenType
+	text string
+	pos  int
+}
+
+// operators ordered so that the longest one is matched first
+var traceQLOperators = []struct {
+	text string
+	typ  traceQLTokenType
+}{
+	{"&&", tokAnd}, {"||", tokOr}, {"!=", tokNeq}, {"!~", tokNre}, {"=~", tokRe},
+	{">>", tokDescendant}, {">=", tokGte}, {"<=", tokLte},
+	{"{", tokLBrace}, {"}", tokRBrace}, {"(", tokLParen}, {")", tokRParen},
+	{"|", tokPipe}, {",", tokComma}, {"!", tokNot}, {"=", tokEq},
+	{">", tokGt}, {"<", tokLt}, {"~", tokSibling},
+}
+
+func isTraceQLIdentChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == ':' || r == '-' || r == '/'
+}
+
+func lexTraceQL(query string) ([]traceQLToken, error) {
+	tokens := []traceQLToken{}
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '`':
+			end := i + 1
+			for ; end < len(runes) && runes[end] != r; end++ {
+				if runes[end] == '\\' && r == '"' {
+					end++
+				}
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			text := string(runes[i+1 : end])
+			if r == '"' {
+				unquoted, err := strconv.Unquote(string(runes[i : end+1]))
+				if err != nil {
+					return nil, fmt.Errorf("invalid string at %d: %s", i, err)
+				}
+				text = unquoted
+			}
+			tokens = append(tokens, traceQLToken{tokString, text, i})
+			i = end + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			end := i + 1
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			typ := tokNumber
+			// a number directly followed by a unit is a duration, e.g. 100ms or 1.5s
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				typ = tokDuration
+				end++
+			}
+			tokens = append(tokens, traceQLToken{typ, string(runes[i:end]), i})
+			i = end
+		case unicode.IsLetter(r) || r == '.' || r == '_':
+			end := i + 1
+			for end < len(runes) && isTraceQLIdentChar(runes[end]) {
+				end++
+			}
+			tokens = append(tokens, traceQLToken{tokIdent, string(runes[i:end]), i})
+			i = end
+		default:
+			matched := false
+			for _, op := range traceQLOperators {
+				if strings.HasPrefix(string(runes[i:]), op.text) {
+					tokens = append(tokens, traceQLToken{op.typ, op.text, i})
+					i += len([]rune(op.text))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", r, i)
+			}
+		}
+	}
+	return append(tokens, traceQLToken{tokEOF, "", len(runes)}), nil
+}
+
+type TraceQLStaticType int
+
+const (
+	TRACEQL_STATIC_STRING TraceQLStaticType = iota
+	TRACEQL_STATIC_NUMBER
+	TRACEQL_STATIC_DURATION
+	TRACEQL_STATIC_BOOL
+	TRACEQL_STATIC_NIL
+	TRACEQL_STATIC_STATUS
+	TRACEQL_STATIC_KIND
+)
+
+type TraceQLStatic struct {
+	Type     TraceQLStaticType
+	String   string
+	Number   float64
+	Duration time.Duration
+	Bool     bool
+}
+
+type TraceQLAttribute struct {
+	Scope     string
+	Name      string
+	Intrinsic bool
+}
+
+func (a *TraceQLAttribute) String() string {
+	if a.Intrinsic {
+		return a.Name
+	}
+	return a.Scope + "." + a.Name
+}
+
+// TraceQLFieldExpr is the condition inside a `{}` spanset filter, one of
+// *TraceQLComparison, *TraceQLFieldBinary and *TraceQLFieldNot
+type TraceQLFieldExpr interface{}
+
+type TraceQLComparison struct {
+	Attribute *TraceQLAttribute
+	Op        string
+	Value     *TraceQLStatic
+}
+
+type TraceQLFieldBinary struct {
+	Op       string // && or ||
+	LHS, RHS TraceQLFieldExpr
+}
+
+type TraceQLFieldNot struct {
+	Expr TraceQLFieldExpr
+}
+
+// TraceQLSpansetExpr is either a *TraceQLSpansetFilter or a *TraceQLSpansetOperation
+type TraceQLSpansetExpr interface{}
+
+type TraceQLSpansetFilter struct {
+	Index int              // position of the filter in the query
+	Expr  TraceQLFieldExpr // nil matches all spans
+}
+
+type TraceQLSpansetOperation struct {
+	Op       string // &&, ||, >, >> or ~
+	LHS, RHS TraceQLSpansetExpr
+}
+
+type TraceQLQuery struct {
+	Spanset TraceQLSpansetExpr
+	Filters []*TraceQLSpansetFilter
+	Select  []*TraceQLAttribute
+}
+
+// IsStructural returns whether the query has structural operators, which can
+// only be checked on the spans of the traces
+func (q *TraceQLQuery) IsStructural() bool {
+	return isStructuralSpanset(q.Spanset)
+}
+
+func isStructuralSpanset(expr TraceQLSpansetExpr) bool {
+	if e, ok := expr.(*TraceQLSpansetOperation); ok {
+		return (e.Op != "&&" && e.Op != "||") || isStructuralSpanset(e.LHS) || isStructuralSpanset(e.RHS)
+	}
+	return false
+}
+
+type traceQLParser struct {
+	tokens  []traceQLToken
+	pos     int
+	filters []*TraceQLSpansetFilter
+}
+
+func ParseTraceQL(query string) (*TraceQLQuery, error) {
+	tokens, err := lexTraceQL(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &traceQLParser{tokens: tokens}
+	spanset, err := p.parseSpanset()
+	if err != nil {
+		return nil, err
+	}
+	q := &TraceQLQuery{Spanset: spanset}
+	for p.peek().typ == tokPipe {
+		p.next()
+		if t := p.next(); t.typ != tokIdent || t.text != "select" {
+			return nil, p.errorf(t, "only select() is supported after |")
+		}
+		if _, err := p.expect(tokLParen); err != nil {
+			return nil, err
+		}
+		for {
+			t, err := p.expect(tokIdent)
+			if err != nil {
+				return nil, err
+			}
+			attr, err := parseTraceQLAttribute(t.text)
+			if err != nil {
+				return nil, p.errorf(t, "%s", err)
+			}
+			q.Select = append(q.Select, attr)
+			if p.peek().typ != tokComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	q.Filters = p.filters
+	return q, nil
+}
+
+func (p *traceQLParser) peek() traceQLToken {
+	return p.tokens[p.pos]
+}
+
+func (p *traceQLParser) next() traceQLToken {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *traceQLParser) expect(typ traceQLTokenType) (traceQLToken, error) {
+	t := p.next()
+	if t.typ != typ {
+		if t.typ == tokEOF {
+			return t, p.errorf(t, "unexpected end of query")
+		}
+		return t, p.errorf(t, "unexpected %q", t.text)
+	}
+	return t, nil
+}
+
+func (p *traceQLParser) errorf(t traceQLToken, format string, a ...interface{}) error {
+	return fmt.Errorf("traceql: %s at position %d", fmt.Sprintf(format, a...), t.pos)
+}
+
+func (p *traceQLParser) parseSpanset() (TraceQLSpansetExpr, error) {
+	lhs, err := p.parseSpansetAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokOr {
+		p.next()
+		rhs, err := p.parseSpansetAnd()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &TraceQLSpansetOperation{Op: "||", LHS: lhs, RHS: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *traceQLParser) parseSpansetAnd() (TraceQLSpansetExpr, error) {
+	lhs, err := p.parseSpansetStruct()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokAnd {
+		p.next()
+		rhs, err := p.parseSpansetStruct()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &TraceQLSpansetOperation{Op: "&&", LHS: lhs, RHS: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *traceQLParser) parseSpansetStruct() (TraceQLSpansetExpr, error) {
+	lhs, err := p.parseSpansetPrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.typ != tokGt && t.typ != tokDescendant && t.typ != tokSibling {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.parseSpansetPrimary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &TraceQLSpansetOperation{Op: t.text, LHS: lhs, RHS: rhs}
+	}
+}
+
+func (p *traceQLParser) parseSpansetPrimary() (TraceQLSpansetExpr, error) {
+	t := p.next()
+	switch t.typ {
+	case tokLParen:
+		expr, err := p.parseSpanset()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokLBrace:
+		filter := &TraceQLSpansetFilter{Index: len(p.filters)}
+		p.filters = append(p.filters, filter)
+		if p.peek().typ == tokRBrace {
+			p.next()
+			return filter, nil
+		}
+		expr, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRBrace); err != nil {
+			return nil, err
+		}
+		filter.Expr = expr
+		return filter, nil
+	case tokEOF:
+		return nil, p.errorf(t, "unexpected end of query")
+	}
+	return nil, p.errorf(t, "expected { but got %q", t.text)
+}
+
+func (p *traceQLParser) parseField() (TraceQLFieldExpr, error) {
+	lhs, err := p.parseFieldAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokOr {
+		p.next()
+		rhs, err := p.parseFieldAnd()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &TraceQLFieldBinary{Op: "||", LHS: lhs, RHS: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *traceQLParser) parseFieldAnd() (TraceQLFieldExpr, error) {
+	lhs, err := p.parseFieldUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokAnd {
+		p.next()
+		rhs, err := p.parseFieldUnary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &TraceQLFieldBinary{Op: "&&", LHS: lhs, RHS: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *traceQLParser) parseFieldUnary() (TraceQLFieldExpr, error) {
+	t := p.next()
+	switch t.typ {
+	case tokNot:
+		expr, err := p.parseFieldUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &TraceQLFieldNot{Expr: expr}, nil
+	case tokLParen:
+		expr, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokIdent:
+		attr, err := parseTraceQLAttribute(t.text)
+		if err != nil {
+			return nil, p.errorf(t, "%s", err)
+		}
+		op := p.next()
+		switch op.typ {
+		case tokEq, tokNeq, tokGt, tokGte, tokLt, tokLte, tokRe, tokNre:
+		default:
+			return nil, p.errorf(op, "expected comparison operator after %s", t.text)
+		}
+		v := p.next()
+		value, err := parseTraceQLStatic(v)
+		if err != nil {
+			return nil, p.errorf(v, "%s", err)
+		}
+		return &TraceQLComparison{Attribute: attr, Op: op.text, Value: value}, nil
+	case tokEOF:
+		return nil, p.errorf(t, "unexpected end of query")
+	}
+	return nil, p.errorf(t, "unexpected %q", t.text)
+}
+
+func parseTraceQLAttribute(text string) (*TraceQLAttribute, error) {
+	switch {
+	case strings.HasPrefix(text, "span:"):
+		name := strings.TrimPrefix(text, "span:")
+		if !traceQLIntrinsics[name] {
+			return nil, fmt.Errorf("unknown intrinsic %s", text)
+		}
+		return &TraceQLAttribute{Name: name, Intrinsic: true}, nil
+	case strings.HasPrefix(text, TRACEQL_SCOPE_SPAN+"."):
+		return &TraceQLAttribute{Scope: TRACEQL_SCOPE_SPAN, Name: strings.TrimPrefix(text, TRACEQL_SCOPE_SPAN+".")}, nil
+	case strings.HasPrefix(text, TRACEQL_SCOPE_RESOURCE+"."):
+		return &TraceQLAttribute{Scope: TRACEQL_SCOPE_RESOURCE, Name: strings.TrimPrefix(text, TRACEQL_SCOPE_RESOURCE+".")}, nil
+	case strings.HasPrefix(text, "."):
+		return &TraceQLAttribute{Scope: TRACEQL_SCOPE_NONE, Name: strings.TrimPrefix(text, ".")}, nil
+	case traceQLIntrinsics[text]:
+		return &TraceQLAttribute{Name: text, Intrinsic: true}, nil
+	}
+	return nil, fmt.Errorf("unknown attribute %s, use span., resource. or . as prefix", text)
+}
+
+func parseTraceQLStatic(t traceQLToken) (*TraceQLStatic, error) {
+	switch t.typ {
+	case tokString:
+		return &TraceQLStatic{Type: TRACEQL_STATIC_STRING, String: t.text}, nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t.text)
+		}
+		return &TraceQLStatic{Type: TRACEQL_STATIC_NUMBER, Number: n}, nil
+	case tokDuration:
+		d, err := time.ParseDuration(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %s", t.text)
+		}
+		return &TraceQLStatic{Type: TRACEQL_STATIC_DURATION, Duration: d}, nil
+	case tokIdent:
+		switch {
+		case t.text == "true" || t.text == "false":
+			return &TraceQLStatic{Type: TRACEQL_STATIC_BOOL, Bool: t.text == "true"}, nil
+		case t.text == "nil":
+			return &TraceQLStatic{Type: TRACEQL_STATIC_NIL}, nil
+		case traceQLStatuses[t.text]:
+			return &TraceQLStatic{Type: TRACEQL_STATIC_STATUS, String: t.text}, nil
+		}
+		if _, ok := traceQLKinds[t.text]; ok {
+			return &TraceQLStatic{Type: TRACEQL_STATIC_KIND, String: t.text}, nil
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+	return nil, fmt.Errorf("expected a value but got %q", t.text)
+}
@@ -0,0 +1,654 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tempo
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	ckdriver "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/google/uuid"
+
+	"github.com/deepflowio/deepflow/server/querier/common"
+	"github.com/deepflowio/deepflow/server/querier/engine/clickhouse"
+)
+
+const (
+	TRACEQL_TABLE            = "flow_log.l7_flow_log"
+	TRACEQL_DEFAULT_LIMIT    = 20
+	TRACEQL_DEFAULT_SPSS     = 3
+	TRACEQL_ATTRIBUTE_NAMES  = "attribute_names"
+	TRACEQL_ATTRIBUTE_VALUES = "attribute_values"
+
+	// the structural operators are checked on the spans of the candidate traces, so more candidates
+	// are queried per page, and pages are queried until the limit is reached or this many traces are inspected
+	TRACEQL_STRUCTURAL_OVERFETCH = 4
+	TRACEQL_MAX_INSPECTED_TRACES = 10000
+)
+
+// the fixed columns of the span query, followed by one match flag per spanset
+// filter and one column per select() attribute
+var TRACEQL_SPAN_FIELDS = []string{
+	"trace_id", "_id", "span_id", "parent_span_id", "app_service", "endpoint",
+	"toUnixTimestamp64Micro(start_time) AS start_time_us", "response_duration",
+}
+
+type traceQLColumn struct {
+	name    string
+	numeric bool // nullable number column, otherwise a string column
+}
+
+// span and resource attributes that are stored in native l7_flow_log columns,
+// all the others are looked up in attribute_names/attribute_values
+var TRACEQL_ATTRS_MAP = map[string]traceQLColumn{
+	"service.name":        {L7_FLOW_LOG_SERVICE_NAME, false},
+	"service.instance.id": {"app_instance", false},
+	"http.method":         {"request_type", false},
+	"http.status_code":    {"response_code", true},
+}
+
+var TRACEQL_INTRINSIC_COLUMNS = map[string]traceQLColumn{
+	TRACEQL_INTRINSIC_NAME:           {L7_TRACING_ENDPOINT, false},
+	TRACEQL_INTRINSIC_STATUS_MESSAGE: {"response_exception", false},
+}
+
+// status intrinsic to response_status, refer to the response_status enum
+var TRACEQL_STATUS_FILTERS = map[string]string{
+	"ok":    "response_status = 0",
+	"error": "response_status IN (3, 4)",
+	"unset": "response_status = 2",
+}
+
+var traceQLStatusSelect = "multiIf(response_status = 0, 'ok', response_status IN (3, 4), 'error', 'unset')"
+var traceQLKindSelect = "transform(ifNull(span_kind, 0), [1, 2, 3, 4, 5], ['internal', 'server', 'client', 'producer', 'consumer'], 'unspecified')"
+
+// TraceQLPlan is the ClickHouse translation of a TraceQL query. Every value
+// coming from the query is bound as a named parameter in Args instead of
+// being formatted into the SQL.
+type TraceQLPlan struct {
+	Query      *TraceQLQuery
+	Conditions []string // one per spanset filter, indexed by TraceQLSpansetFilter.Index
+	Selects    []string
+	Args       []interface{}
+
+	timeFilters []string
+}
+
+func PlanTraceQL(query *TraceQLQuery) (*TraceQLPlan, error) {
+	p := &TraceQLPlan{Query: query}
+	for _, filter := range query.Filters {
+		condition := "1"
+		if filter.Expr != nil {
+			var err error
+			if condition, err = p.fieldSql(filter.Expr); err != nil {
+				return nil, err
+			}
+		}
+		p.Conditions = append(p.Conditions, condition)
+	}
+	for _, attr := range query.Select {
+		p.Selects = append(p.Selects, p.selectSql(attr))
+	}
+	return p, nil
+}
+
+func (p *TraceQLPlan) bind(value interface{}) string {
+	name := fmt.Sprintf("p%d", len(p.Args))
+	p.Args = append(p.Args, ckdriver.Named(name, value))
+	return "@" + name
+}
+
+func (p *TraceQLPlan) SetTimeRange(start, end uint64) {
+	if start > 0 {
+		p.timeFilters = append(p.timeFilters, fmt.Sprintf("time >= toDateTime(%s)", p.bind(start)))
+	}
+	if end > 0 {
+		p.timeFilters = append(p.timeFilters, fmt.Sprintf("time <= toDateTime(%s)", p.bind(end)))
+	}
+}
+
+// TraceIDSql returns a page of the traces which have a span for every spanset filter
+// the query requires, structural operators are checked later on the spans.
+func (p *TraceQLPlan) TraceIDSql(limit, offset int) string {
+	filters := append([]string{"trace_id != ''"}, p.timeFilters...)
+	conditions := make([]string, 0, len(p.Conditions))
+	for _, c := range p.Conditions {
+		conditions = append(conditions, "("+c+")")
+	}
+	filters = append(filters, "("+strings.Join(conditions, " OR ")+")")
+	return fmt.Sprintf(
+		"SELECT trace_id FROM %s WHERE %s GROUP BY trace_id HAVING %s ORDER BY min(start_time) DESC, trace_id LIMIT %s OFFSET %s",
+		TRACEQL_TABLE, strings.Join(filters, " AND "), p.havingSql(p.Query.Spanset), p.bind(limit), p.bind(offset),
+	)
+}
+
+// SpanSql returns all the spans of the traces, with the result of every
+// spanset filter and the select() attributes
+func (p *TraceQLPlan) SpanSql(traceIDs []string) string {
+	fields := append([]string{}, TRACEQL_SPAN_FIELDS...)
+	for i, c := range p.Conditions {
+		fields = append(fields, fmt.Sprintf("ifNull(%s, 0) AS f%d", c, i))
+	}
+	for i, s := range p.Selects {
+		fields = append(fields, fmt.Sprintf("%s AS s%d", s, i))
+	}
+	filters := append([]string{fmt.Sprintf("trace_id IN (%s)", p.bind(traceIDs))}, p.timeFilters...)
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(fields, ", "), TRACEQL_TABLE, strings.Join(filters, " AND "))
+}
+
+func (p *TraceQLPlan) havingSql(expr TraceQLSpansetExpr) string {
+	switch e := expr.(type) {
+	case *TraceQLSpansetFilter:
+		return fmt.Sprintf("countIf(%s) > 0", p.Conditions[e.Index])
+	case *TraceQLSpansetOperation:
+		op := " AND "
+		if e.Op == "||" {
+			op = " OR "
+		}
+		return "(" + p.havingSql(e.LHS) + op + p.havingSql(e.RHS) + ")"
+	}
+	return "1"
+}
+
+func (p *TraceQLPlan) fieldSql(expr TraceQLFieldExpr) (string, error) {
+	switch e := expr.(type) {
+	case *TraceQLFieldBinary:
+		lhs, err := p.fieldSql(e.LHS)
+		if err != nil {
+			return "", err
+		}
+		rhs, err := p.fieldSql(e.RHS)
+		if err != nil {
+			return "", err
+		}
+		op := " AND "
+		if e.Op == "||" {
+			op = " OR "
+		}
+		return "(" + lhs + op + rhs + ")", nil
+	case *TraceQLFieldNot:
+		inner, err := p.fieldSql(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + inner + ")", nil
+	case *TraceQLComparison:
+		return p.comparisonSql(e)
+	}
+	return "", fmt.Errorf("traceql: unsupported expression %T", expr)
+}
+
+func traceQLTypeError(c *TraceQLComparison) error {
+	return fmt.Errorf("traceql: unsupported value type for %s %s", c.Attribute, c.Op)
+}
+
+func (p *TraceQLPlan) comparisonSql(c *TraceQLComparison) (string, error) {
+	attr, value := c.Attribute, c.Value
+	if !attr.Intrinsic {
+		if column, ok := TRACEQL_ATTRS_MAP[attr.Name]; ok {
+			return p.columnSql(column, c)
+		}
+		return p.attributeSql(attr.Name, c)
+	}
+	switch attr.Name {
+	case TRACEQL_INTRINSIC_STATUS:
+		if value.Type != TRACEQL_STATIC_STATUS || (c.Op != "=" && c.Op != "!=") {
+			return "", traceQLTypeError(c)
+		}
+		filter := TRACEQL_STATUS_FILTERS[value.String]
+		if c.Op == "!=" {
+			filter = "NOT (" + filter + ")"
+		}
+		return filter, nil
+	case TRACEQL_INTRINSIC_KIND:
+		if value.Type != TRACEQL_STATIC_KIND || (c.Op != "=" && c.Op != "!=") {
+			return "", traceQLTypeError(c)
+		}
+		return fmt.Sprintf("ifNull(span_kind, 0) %s %s", c.Op, p.bind(traceQLKinds[value.String])), nil
+	case TRACEQL_INTRINSIC_DURATION:
+		if value.Type != TRACEQL_STATIC_DURATION || value.Duration < 0 || c.Op == "=~" || c.Op == "!~" {
+			return "", traceQLTypeError(c)
+		}
+		// response_duration is in microseconds
+		return fmt.Sprintf("response_duration %s %s", c.Op, p.bind(uint64(value.Duration.Microseconds()))), nil
+	}
+	return p.columnSql(TRACEQL_INTRINSIC_COLUMNS[attr.Name], c)
+}
+
+func (p *TraceQLPlan) columnSql(column traceQLColumn, c *TraceQLComparison) (string, error) {
+	switch c.Value.Type {
+	case TRACEQL_STATIC_NIL:
+		if c.Op != "=" && c.Op != "!=" {
+			return "", traceQLTypeError(c)
+		}
+		if column.numeric {
+			if c.Op == "=" {
+				return fmt.Sprintf("isNull(%s)", column.name), nil
+			}
+			return fmt.Sprintf("isNotNull(%s)", column.name), nil
+		}
+		return fmt.Sprintf("%s %s ''", column.name, c.Op), nil
+	case TRACEQL_STATIC_STRING:
+		if column.numeric {
+			return "", traceQLTypeError(c)
+		}
+		if c.Op == "=~" || c.Op == "!~" {
+			return p.regexSql(column.name, c)
+		}
+		return fmt.Sprintf("%s %s %s", column.name, c.Op, p.bind(c.Value.String)), nil
+	case TRACEQL_STATIC_NUMBER:
+		if !column.numeric || c.Op == "=~" || c.Op == "!~" {
+			return "", traceQLTypeError(c)
+		}
+		return fmt.Sprintf("%s %s %s", column.name, c.Op, p.bind(c.Value.Number)), nil
+	}
+	return "", traceQLTypeError(c)
+}
+
+func (p *TraceQLPlan) attributeSql(name string, c *TraceQLComparison) (string, error) {
+	key := p.bind(name)
+	exists := fmt.Sprintf("has(%s, %s)", TRACEQL_ATTRIBUTE_NAMES, key)
+	value := fmt.Sprintf("%s[indexOf(%s, %s)]", TRACEQL_ATTRIBUTE_VALUES, TRACEQL_ATTRIBUTE_NAMES, key)
+	var condition string
+	var err error
+	switch c.Value.Type {
+	case TRACEQL_STATIC_NIL:
+		switch c.Op {
+		case "=":
+			return "NOT " + exists, nil
+		case "!=":
+			return exists, nil
+		}
+		return "", traceQLTypeError(c)
+	case TRACEQL_STATIC_STRING:
+		if c.Op == "=~" || c.Op == "!~" {
+			condition, err = p.regexSql(value, c)
+		} else {
+			condition = fmt.Sprintf("%s %s %s", value, c.Op, p.bind(c.Value.String))
+		}
+	case TRACEQL_STATIC_NUMBER:
+		if c.Op == "=~" || c.Op == "!~" {
+			return "", traceQLTypeError(c)
+		}
+		condition = fmt.Sprintf("toFloat64OrNull(%s) %s %s", value, c.Op, p.bind(c.Value.Number))
+	case TRACEQL_STATIC_BOOL:
+		if c.Op != "=" && c.Op != "!=" {
+			return "", traceQLTypeError(c)
+		}
+		condition = fmt.Sprintf("%s %s %s", value, c.Op, p.bind(strconv.FormatBool(c.Value.Bool)))
+	default:
+		return "", traceQLTypeError(c)
+	}
+	if err != nil {
+		return "", err
+	}
+	return "(" + exists + " AND " + condition + ")", nil
+}
+
+// regexes are fully anchored like in Tempo
+func (p *TraceQLPlan) regexSql(column string, c *TraceQLComparison) (string, error) {
+	if _, err := regexp.Compile(c.Value.String); err != nil {
+		return "", fmt.Errorf("traceql: invalid regex %q: %s", c.Value.String, err)
+	}
+	condition := fmt.Sprintf("match(%s, %s)", column, p.bind("^(?:"+c.Value.String+")$"))
+	if c.Op == "!~" {
+		condition = "NOT " + condition
+	}
+	return condition, nil
+}
+
+func (p *TraceQLPlan) selectSql(attr *TraceQLAttribute) string {
+	if attr.Intrinsic {
+		switch attr.Name {
+		case TRACEQL_INTRINSIC_STATUS:
+			return traceQLStatusSelect
+		case TRACEQL_INTRINSIC_KIND:
+			return traceQLKindSelect
+		case TRACEQL_INTRINSIC_DURATION:
+			return "toString(response_duration * 1000)"
+		}
+		return TRACEQL_INTRINSIC_COLUMNS[attr.Name].name
+	}
+	if column, ok := TRACEQL_ATTRS_MAP[attr.Name]; ok {
+		return fmt.Sprintf("ifNull(toString(%s), '')", column.name)
+	}
+	return fmt.Sprintf("%s[indexOf(%s, %s)]", TRACEQL_ATTRIBUTE_VALUES, TRACEQL_ATTRIBUTE_NAMES, p.bind(attr.Name))
+}
+
+type traceQLSpan struct {
+	id           uint64
+	spanID       string
+	parentSpanID string
+	service      string
+	name         string
+	startTimeUs  int64
+	durationUs   uint64
+	matched      []bool
+	selected     []string
+}
+
+type traceQLTrace struct {
+	traceID string
+	spans   []*traceQLSpan
+}
+
+func newTraceQLSpan(value []interface{}, filterCount, selectCount int) *traceQLSpan {
+	span := &traceQLSpan{
+		matched:  make([]bool, filterCount),
+		selected: make([]string, selectCount),
+	}
+	span.id, _ = value[1].(uint64)
+	span.spanID, _ = value[2].(string)
+	span.parentSpanID, _ = value[3].(string)
+	span.service, _ = value[4].(string)
+	span.name, _ = value[5].(string)
+	span.startTimeUs, _ = value[6].(int64)
+	span.durationUs, _ = value[7].(uint64)
+	for i := 0; i < filterCount; i++ {
+		flag, _ := value[len(TRACEQL_SPAN_FIELDS)+i].(uint8)
+		span.matched[i] = flag != 0
+	}
+	for i := 0; i < selectCount; i++ {
+		span.selected[i], _ = value[len(TRACEQL_SPAN_FIELDS)+filterCount+i].(string)
+	}
+	return span
+}
+
+func traceQLAny(set []bool) bool {
+	for _, b := range set {
+		if b {
+			return true
+		}
+	}
+	return false
+}
+
+// evalTraceQLSpanset returns which spans of the trace are matched by the spanset expression
+func evalTraceQLSpanset(expr TraceQLSpansetExpr, spans []*traceQLSpan) []bool {
+	result := make([]bool, len(spans))
+	switch e := expr.(type) {
+	case *TraceQLSpansetFilter:
+		for i, s := range spans {
+			result[i] = s.matched[e.Index]
+		}
+	case *TraceQLSpansetOperation:
+		lhs := evalTraceQLSpanset(e.LHS, spans)
+		rhs := evalTraceQLSpanset(e.RHS, spans)
+		switch e.Op {
+		case "||":
+			for i := range spans {
+				result[i] = lhs[i] || rhs[i]
+			}
+		case "&&":
+			if traceQLAny(lhs) && traceQLAny(rhs) {
+				for i := range spans {
+					result[i] = lhs[i] || rhs[i]
+				}
+			}
+		case ">", ">>":
+			lhsSpanIDs := map[string]bool{}
+			parents := map[string]string{}
+			for i, s := range spans {
+				if s.spanID == "" {
+					continue
+				}
+				if lhs[i] {
+					lhsSpanIDs[s.spanID] = true
+				}
+				if _, ok := parents[s.spanID]; !ok {
+					parents[s.spanID] = s.parentSpanID
+				}
+			}
+			for i, s := range spans {
+				if !rhs[i] {
+					continue
+				}
+				visited := map[string]bool{}
+				for parent := s.parentSpanID; parent != "" && !visited[parent]; parent = parents[parent] {
+					if lhsSpanIDs[parent] {
+						result[i] = true
+						break
+					}
+					if e.Op == ">" {
+						break
+					}
+					visited[parent] = true
+				}
+			}
+		case "~":
+			lhsParents := map[string]int{}
+			for i, s := range spans {
+				if lhs[i] && s.parentSpanID != "" {
+					lhsParents[s.parentSpanID]++
+				}
+			}
+			for i, s := range spans {
+				if !rhs[i] || s.parentSpanID == "" {
+					continue
+				}
+				count := lhsParents[s.parentSpanID]
+				if lhs[i] {
+					count--
+				}
+				result[i] = count > 0
+			}
+		}
+	}
+	return result
+}
+
+func traceQLSpanResult(span *traceQLSpan, selects []*TraceQLAttribute) map[string]interface{} {
+	spanID := span.spanID
+	if spanID == "" {
+		spanID = fmt.Sprintf("%016x", span.id)
+	}
+	attributes := []map[string]interface{}{
+		{"key": "service.name", "value": map[string]interface{}{"stringValue": span.service}},
+	}
+	for i, attr := range selects {
+		attributes = append(attributes, map[string]interface{}{
+			"key": attr.Name, "value": map[string]interface{}{"stringValue": span.selected[i]},
+		})
+	}
+	return map[string]interface{}{
+		"spanID":            spanID,
+		"name":              span.name,
+		"startTimeUnixNano": strconv.FormatInt(span.startTimeUs*1000, 10),
+		"durationNanos":     strconv.FormatUint(span.durationUs*1000, 10),
+		"attributes":        attributes,
+	}
+}
+
+// traceQLTraceResult returns the Tempo search result of a trace, or nil if
+// no span of the trace matches the query
+func traceQLTraceResult(trace *traceQLTrace, query *TraceQLQuery, spss int) map[string]interface{} {
+	matched := evalTraceQLSpanset(query.Spanset, trace.spans)
+	spans := []*traceQLSpan{}
+	spanIDs := map[string]bool{}
+	for i, s := range trace.spans {
+		if matched[i] {
+			spans = append(spans, s)
+		}
+		if s.spanID != "" {
+			spanIDs[s.spanID] = true
+		}
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	var root *traceQLSpan
+	start, end := trace.spans[0].startTimeUs, int64(0)
+	for _, s := range trace.spans {
+		if s.startTimeUs < start {
+			start = s.startTimeUs
+		}
+		if e := s.startTimeUs + int64(s.durationUs); e > end {
+			end = e
+		}
+		if (s.parentSpanID == "" || !spanIDs[s.parentSpanID]) && (root == nil || s.startTimeUs < root.startTimeUs) {
+			root = s
+		}
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].startTimeUs < spans[j].startTimeUs })
+	spanResults := []map[string]interface{}{}
+	for i, s := range spans {
+		if i >= spss {
+			break
+		}
+		spanResults = append(spanResults, traceQLSpanResult(s, query.Select))
+	}
+	spanSet := map[string]interface{}{
+		"spans":   spanResults,
+		"matched": len(spans),
+	}
+	result := map[string]interface{}{
+		"traceID":           trace.traceID,
+		"startTimeUnixNano": strconv.FormatInt(start*1000, 10),
+		"durationMs":        (end - start) / 1000,
+		"spanSet":           spanSet,
+		"spanSets":          []map[string]interface{}{spanSet},
+	}
+	if root != nil {
+		result["rootServiceName"] = root.service
+		result["rootTraceName"] = root.name
+	}
+	return result
+}
+
+func traceQLExecute(sql string, plan *TraceQLPlan, args *common.TempoParams) (*common.Result, map[string]interface{}, error) {
+	return clickhouse.SimpleExecute(&common.QuerierParams{
+		DB:        "flow_log",
+		Sql:       sql,
+		Args:      plan.Args,
+		Debug:     args.Debug,
+		QueryUUID: uuid.New().String(),
+		Context:   args.Context,
+	})
+}
+
+// TraceQLSearch searches traces with a TraceQL query, the result is grouped
+// per trace with the matched spans in spanSet
+func TraceQLSearch(args *common.TempoParams) (resp map[string]interface{}, debug map[string]interface{}, err error) {
+	resp = map[string]interface{}{
+		"metrics": map[string]interface{}{},
+		"traces":  []map[string]interface{}{},
+	}
+	query, err := ParseTraceQL(args.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := PlanTraceQL(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	var start, end uint64
+	if args.StartTime != "" {
+		if start, err = strconv.ParseUint(args.StartTime, 10, 64); err != nil {
+			return nil, nil, fmt.Errorf("invalid start %s", args.StartTime)
+		}
+	}
+	if args.EndTime != "" {
+		if end, err = strconv.ParseUint(args.EndTime, 10, 64); err != nil {
+			return nil, nil, fmt.Errorf("invalid end %s", args.EndTime)
+		}
+	}
+	plan.SetTimeRange(start, end)
+	limit, spss := TRACEQL_DEFAULT_LIMIT, TRACEQL_DEFAULT_SPSS
+	if args.Limit != "" {
+		if limit, err = strconv.Atoi(args.Limit); err != nil || limit <= 0 {
+			return nil, nil, fmt.Errorf("invalid limit %s", args.Limit)
+		}
+	}
+	if args.SpssLimit != "" {
+		if spss, err = strconv.Atoi(args.SpssLimit); err != nil || spss <= 0 {
+			return nil, nil, fmt.Errorf("invalid spss %s", args.SpssLimit)
+		}
+	}
+
+	pageSize := limit
+	if query.IsStructural() {
+		pageSize = limit * TRACEQL_STRUCTURAL_OVERFETCH
+	}
+	// the arguments bound for a page are dropped before querying the next page
+	pageArgs := len(plan.Args)
+	respValues := []map[string]interface{}{}
+	inspected := 0
+	for offset := 0; len(respValues) < limit && offset < TRACEQL_MAX_INSPECTED_TRACES; offset += pageSize {
+		plan.Args = plan.Args[:pageArgs]
+		result, pageDebug, err := traceQLExecute(plan.TraceIDSql(pageSize, offset), plan, args)
+		debug = pageDebug
+		if err != nil {
+			return nil, debug, err
+		}
+		traceIDs := []string{}
+		for _, v := range result.Values {
+			if traceID, ok := v.([]interface{})[0].(string); ok {
+				traceIDs = append(traceIDs, traceID)
+			}
+		}
+		if len(traceIDs) == 0 {
+			break
+		}
+		inspected += len(traceIDs)
+		traces, pageDebug, err := traceQLSearchTraces(plan, args, traceIDs, spss)
+		debug = pageDebug
+		if err != nil {
+			return nil, debug, err
+		}
+		for _, r := range traces {
+			if len(respValues) >= limit {
+				break
+			}
+			respValues = append(respValues, r)
+		}
+		if len(traceIDs) < pageSize {
+			break
+		}
+	}
+	resp["traces"] = respValues
+	resp["metrics"] = map[string]interface{}{"inspectedTraces": inspected}
+	return resp, debug, nil
+}
+
+// traceQLSearchTraces queries the spans of the traces and returns the results of
+// the traces matched by the query, in the order of traceIDs
+func traceQLSearchTraces(plan *TraceQLPlan, args *common.TempoParams, traceIDs []string, spss int) ([]map[string]interface{}, map[string]interface{}, error) {
+	result, debug, err := traceQLExecute(plan.SpanSql(traceIDs), plan, args)
+	if err != nil {
+		return nil, debug, err
+	}
+	traces := make(map[string]*traceQLTrace, len(traceIDs))
+	for _, traceID := range traceIDs {
+		traces[traceID] = &traceQLTrace{traceID: traceID}
+	}
+	for _, v := range result.Values {
+		value := v.([]interface{})
+		traceID, _ := value[0].(string)
+		if trace, ok := traces[traceID]; ok {
+			trace.spans = append(trace.spans, newTraceQLSpan(value, len(plan.Conditions), len(plan.Selects)))
+		}
+	}
+	respValues := []map[string]interface{}{}
+	for _, traceID := range traceIDs {
+		if r := traceQLTraceResult(traces[traceID], plan.Query, spss); r != nil {
+			respValues = append(respValues, r)
+		}
+	}
+	return respValues, debug, nil
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright (c) 2024 Yunshan Networks
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tempo
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+func TestParseTraceQL(t *testing.T) {
+	testCases := []struct {
+		query   string
+		filters int
+		selects int
+		wantErr bool
+	}{
+		{query: "{}", filters: 1},
+		{query: `{ resource.service.name = "frontend" && span.http.status_code >= 500 }`, filters: 1},
+		{query: `{ .service.name = "a" } >> { duration > 1.5s } | select(span.http.url, status)`, filters: 2, selects: 2},
+		{query: `({ name =~ "GET.*" } || { status = error }) && { kind != client }`, filters: 3},
+		{query: `{ span.foo = nil } ~ { !(span.bar = true || .baz < -3) }`, filters: 2},
+		{query: `{ foo = "a" }`, wantErr: true},
+		{query: `{ .a = "b" `, wantErr: true},
+		{query: `{ .a "b" }`, wantErr: true},
+		{query: `{ .a = "b" } | count()`, wantErr: true},
+		{query: `{ span:duration > 10ms } > {}`, filters: 2},
+	}
+	for _, tc := range testCases {
+		q, err := ParseTraceQL(tc.query)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tc.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.query, err)
+			continue
+		}
+		if len(q.Filters) != tc.filters || len(q.Select) != tc.selects {
+			t.Errorf("%s: got %d filters %d selects", tc.query, len(q.Filters), len(q.Select))
+		}
+	}
+}
+
+func TestParseTraceQLPrecedence(t *testing.T) {
+	q, err := ParseTraceQL(`{ .a = 1 } || { .b = 2 } && { .c = 3 } > { .d = 4 }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	or, ok := q.Spanset.(*TraceQLSpansetOperation)
+	if !ok || or.Op != "||" {
+		t.Fatalf("expected || at the top, got %#v", q.Spanset)
+	}
+	and, ok := or.RHS.(*TraceQLSpansetOperation)
+	if !ok || and.Op != "&&" {
+		t.Fatalf("expected && on the right, got %#v", or.RHS)
+	}
+	if child, ok := and.RHS.(*TraceQLSpansetOperation); !ok || child.Op != ">" {
+		t.Fatalf("expected > on the right, got %#v", and.RHS)
+	}
+}
+
+func traceQLArgs(p *TraceQLPlan) map[string]interface{} {
+	args := map[string]interface{}{}
+	for _, a := range p.Args {
+		v := a.(driver.NamedValue)
+		args["@"+v.Name] = v.Value
+	}
+	return args
+}
+
+func TestPlanTraceQL(t *testing.T) {
+	q, err := ParseTraceQL(`{ resource.service.name = "x' OR 1=1" && span.db.system =~ "my.*" && duration >= 100ms && status = error }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := PlanTraceQL(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "(((app_service = @p0 AND (has(attribute_names, @p1) AND match(attribute_values[indexOf(attribute_names, @p1)], @p2))) AND response_duration >= @p3) AND response_status IN (3, 4))"
+	if p.Conditions[0] != expected {
+		t.Errorf("got %s", p.Conditions[0])
+	}
+	args := traceQLArgs(p)
+	expectedArgs := map[string]interface{}{
+		"@p0": "x' OR 1=1",
+		"@p1": "db.system",
+		"@p2": "^(?:my.*)$",
+		"@p3": uint64(100000),
+	}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("got args %v", args)
+	}
+	if strings.Contains(p.Conditions[0], "OR 1=1") {
+		t.Errorf("value is formatted into the sql: %s", p.Conditions[0])
+	}
+
+	p.SetTimeRange(1700000000, 1700003600)
+	sql := p.TraceIDSql(20, 40)
+	if !strings.Contains(sql, "time >= toDateTime(@p4) AND time <= toDateTime(@p5)") ||
+		!strings.Contains(sql, "HAVING countIf("+expected+") > 0") ||
+		!strings.HasSuffix(sql, "LIMIT @p6 OFFSET @p7") {
+		t.Errorf("got %s", sql)
+	}
+	sql = p.SpanSql([]string{"t1", "t2"})
+	if !strings.Contains(sql, "ifNull("+expected+", 0) AS f0") || !strings.Contains(sql, "trace_id IN (@p8)") {
+		t.Errorf("got %s", sql)
+	}
+	if q.IsStructural() {
+		t.Errorf("%v should not be structural", q.Spanset)
+	}
+	for query, structural := range map[string]bool{
+		`{ .a = 1 } && { .b = 2 }`:                false,
+		`{ .a = 1 } > { .b = 2 }`:                 true,
+		`({ .a = 1 } ~ { .b = 2 }) || { .c = 3 }`: true,
+	} {
+		if q, err := ParseTraceQL(query); err != nil || q.IsStructural() != structural {
+			t.Errorf("%s structural should be %t, err %v", query, structural, err)
+		}
+	}
+	if v := traceQLArgs(p)["@p8"]; !reflect.DeepEqual(v, []string{"t1", "t2"}) {
+		t.Errorf("got trace ids %v", v)
+	}
+}
+
+func TestPlanTraceQLErrors(t *testing.T) {
+	for _, query := range []string{
+		`{ status = "error" }`,
+		`{ kind > server }`,
+		`{ duration > 100 }`,
+		`{ span.http.status_code = "500" }`,
+		`{ .foo =~ "(" }`,
+		`{ .foo =~ 1 }`,
+	} {
+		q, err := ParseTraceQL(query)
+		if err != nil {
+			t.Errorf("%s: %s", query, err)
+			continue
+		}
+		if _, err := PlanTraceQL(q); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+// newTestTrace builds spans from spanID, parentSpanID and the value of the
+// `f` attribute, a spanset filter `{ .f = N }` matches the spans whose value is N
+func newTestTrace(q *TraceQLQuery, spans ...[]string) []*traceQLSpan {
+	result := []*traceQLSpan{}
+	for i, s := range spans {
+		span := &traceQLSpan{spanID: s[0], parentSpanID: s[1], startTimeUs: int64(i)}
+		for _, f := range q.Filters {
+			c := f.Expr.(*TraceQLComparison)
+			span.matched = append(span.matched, s[2] == strconv.Itoa(int(c.Value.Number)))
+		}
+		result = append(result, span)
+	}
+	return result
+}
+
+func TestEvalTraceQLSpanset(t *testing.T) {
+	// root -> a -> b -> c, root -> d -> e, root -> g
+	spans := [][]string{
+		{"root", "", "0"},
+		{"a", "root", "2"},
+		{"b", "a", "1"},
+		{"c", "b", "1"},
+		{"d", "root", "1"},
+		{"e", "d", "2"},
+		{"g", "root", "2"},
+	}
+	testCases := []struct {
+		query    string
+		expected []bool
+	}{
+		{`{ .f = 0 } && { .f = 1 }`, []bool{true, false, true, true, true, false, false}},
+		{`{ .f = 0 } && { .f = 3 }`, []bool{false, false, false, false, false, false, false}},
+		{`{ .f = 0 } || { .f = 3 }`, []bool{true, false, false, false, false, false, false}},
+		{`{ .f = 0 } > { .f = 1 }`, []bool{false, false, false, false, true, false, false}},
+		{`{ .f = 0 } >> { .f = 1 }`, []bool{false, false, true, true, true, false, false}},
+		{`{ .f = 1 } > { .f = 1 }`, []bool{false, false, false, true, false, false, false}},
+		{`{ .f = 1 } >> { .f = 0 }`, []bool{false, false, false, false, false, false, false}},
+		{`{ .f = 1 } ~ { .f = 2 }`, []bool{false, true, false, false, false, false, true}},
+		{`{ .f = 2 } ~ { .f = 2 }`, []bool{false, true, false, false, false, false, true}},
+		{`{ .f = 1 } ~ { .f = 1 }`, []bool{false, false, false, false, false, false, false}},
+		{`({ .f = 0 } > { .f = 1 }) > { .f = 2 }`, []bool{false, false, false, false, false, true, false}},
+	}
+	for _, tc := range testCases {
+		q, err := ParseTraceQL(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := evalTraceQLSpanset(q.Spanset, newTestTrace(q, spans...)); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: got %v, expected %v", tc.query, got, tc.expected)
+		}
+	}
+}
+
+func TestTraceQLTraceResult(t *testing.T) {
+	q, _ := ParseTraceQL(`{ .f = 1 } | select(span.http.url)`)
+	trace := &traceQLTrace{traceID: "t1"}
+	for i, s := range [][]string{{"root", "", "0"}, {"a", "root", "1"}, {"b", "root", "1"}} {
+		trace.spans = append(trace.spans, &traceQLSpan{
+			spanID: s[0], parentSpanID: s[1], service: "svc-" + s[0], name: "op-" + s[0],
+			startTimeUs: int64(1000 + i*10), durationUs: 100,
+			matched: []bool{s[2] == "1"}, selected: []string{"/" + s[0]},
+		})
+	}
+	result := traceQLTraceResult(trace, q, 1)
+	if result["rootServiceName"] != "svc-root" || result["rootTraceName"] != "op-root" || result["startTimeUnixNano"] != "1000000" {
+		t.Errorf("got %v", result)
+	}
+	spanSet := result["spanSet"].(map[string]interface{})
+	spans := spanSet["spans"].([]map[string]interface{})
+	if spanSet["matched"] != 2 || len(spans) != 1 || spans[0]["spanID"] != "a" {
+		t.Errorf("got %v", spanSet)
+	}
+	attributes := spans[0]["attributes"].([]map[string]interface{})
+	if len(attributes) != 2 || attributes[1]["key"] != "http.url" {
+		t.Errorf("got %v", attributes)
+	}
+
+	q, _ = ParseTraceQL(`{ .f = 2 }`)
+	trace.spans[1].matched[0], trace.spans[2].matched[0] = false, false
+	if traceQLTraceResult(trace, q, 3) != nil {
+		t.Errorf("expected no result")
+	}
+}